@@ -0,0 +1,275 @@
+package bench
+
+// The benchmarks in this file drive a GoNB binary through its HTTP API, see package documentation and README.md.
+//
+// Besides the usual Go benchmark output, the samples of each metric (latencies in milliseconds, throughputs in MB/s)
+// are collected in a Report, printed at the end, optionally saved with --out and compared with --baseline.
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	flagGonb = flag.String("gonb", "",
+		"GoNB binary to benchmark: it must support the HTTP API (--http). If empty, it is built from the source tree of the benchmarks.")
+	flagLabel = flag.String("label", "",
+		"Label of the version of GoNB benchmarked, in the report. If empty, the `git describe` of the source tree is used, or the "+
+			"binary name if --gonb is set.")
+	flagOut       = flag.String("out", "", "Save the report with the results of the benchmarks, as JSON, to the given file.")
+	flagBaseline  = flag.String("baseline", "", "Report (saved with --out) to compare with: the benchmarks fail if a metric regressed more than --tolerance.")
+	flagTolerance = flag.Float64("tolerance", 0.2, "Relative regression of the median of a metric tolerated when comparing with --baseline.")
+	flagStream    = flag.Int("stream_size", 16<<20, "Bytes written to the standard output by the streaming benchmarks.")
+)
+
+var (
+	recorder = NewRecorder()
+
+	serverOnce sync.Once
+	server     *Server
+	serverErr  error
+	buildDir   string
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	if server != nil {
+		if err := server.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to stop GoNB server: %+v\n", err)
+		}
+	}
+	if buildDir != "" {
+		_ = os.RemoveAll(buildDir)
+	}
+	if code == 0 && recorder.Len() > 0 {
+		code = reportResults()
+	}
+	os.Exit(code)
+}
+
+// reportResults prints and saves the report, and compares it with the baseline. It returns the exit code.
+func reportResults() int {
+	report := recorder.Report(kernelLabel())
+	fmt.Printf("\n%s", report)
+	if *flagOut != "" {
+		if err := report.Save(*flagOut); err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			return 1
+		}
+		fmt.Printf("Report saved to %q\n", *flagOut)
+	}
+	if *flagBaseline == "" {
+		return 0
+	}
+	baseline, err := LoadReport(*flagBaseline)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		return 1
+	}
+	regressions := Compare(baseline, report, *flagTolerance)
+	if len(regressions) == 0 {
+		fmt.Printf("No regressions above %.0f%% compared to %q (kernel %s).\n", 100**flagTolerance, *flagBaseline, baseline.Kernel)
+		return 0
+	}
+	fmt.Printf("%d regression(s) above %.0f%% compared to %q:\n", len(regressions), 100**flagTolerance, *flagBaseline)
+	for _, r := range regressions {
+		fmt.Printf("  %s\n", r)
+	}
+	return 1
+}
+
+// kernelLabel returns the label of the version of GoNB benchmarked, see --label.
+func kernelLabel() string {
+	if *flagLabel != "" {
+		return *flagLabel
+	}
+	if *flagGonb != "" {
+		return path.Base(*flagGonb)
+	}
+	if describe := GitDescribe(RootDir()); describe != "" {
+		return describe
+	}
+	return "dev"
+}
+
+// getServer returns the GoNB server shared by the benchmarks, building and starting it on the first call.
+func getServer(b *testing.B) *Server {
+	serverOnce.Do(func() {
+		binaryPath := *flagGonb
+		if binaryPath == "" {
+			buildDir, serverErr = os.MkdirTemp("", "gonb_bench")
+			if serverErr != nil {
+				return
+			}
+			binaryPath = path.Join(buildDir, "gonb")
+			if serverErr = BuildGonb(RootDir(), binaryPath); serverErr != nil {
+				return
+			}
+		}
+		server, serverErr = StartServer(binaryPath)
+	})
+	if serverErr != nil {
+		b.Fatalf("Failed to start GoNB: %+v", serverErr)
+	}
+	return server
+}
+
+// newSession creates a session closed at the end of the benchmark.
+func newSession(b *testing.B) *Session {
+	s := getServer(b)
+	sess, err := s.NewSession()
+	if err != nil {
+		b.Fatalf("%+v", err)
+	}
+	b.Cleanup(func() {
+		if err := sess.Close(); err != nil {
+			b.Errorf("Failed to close session: %+v", err)
+		}
+	})
+	return sess
+}
+
+// mustExecute executes the cell, failing the benchmark on errors.
+func mustExecute(b *testing.B, sess *Session, code string) *Execution {
+	e, err := sess.Execute(code)
+	if err != nil {
+		b.Fatalf("Failed to execute %q: %+v\nGoNB logs:\n%s", code, err, server.Logs())
+	}
+	return e
+}
+
+// requireGoImports skips the benchmark if Go code can't be executed.
+func requireGoImports(b *testing.B) {
+	if !GoImportsInstalled() {
+		b.Skip("goimports is not installed, required to execute Go code")
+	}
+}
+
+// BenchmarkExecute measures the end-to-end latency of the execution of small cells, from the request to the reply.
+//
+// Cells marked with `vary` change at every iteration (the "%d" in the code is replaced by the iteration number), so
+// they are rebuilt; the others re-execute the same code, and measure the reuse of the previous binary.
+func BenchmarkExecute(b *testing.B) {
+	for _, bm := range []struct {
+		name, code   string
+		goCode, vary bool
+	}{
+		{"special_command", "%env GONB_BENCH=1", false, false},
+		{"shell", "!true", false, false},
+		{"rerun", "%%\nfmt.Println(\"ok\")", true, false},
+		{"main_changed", "%%\nfmt.Println(%d)", true, true},
+		{"declarations", "type Counter struct{ n int }\n\nfunc (c *Counter) Inc() { c.n++ }\n\n%%\nc := &Counter{n: %d}\nc.Inc()\nfmt.Println(c.n)", true, true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			if bm.goCode {
+				requireGoImports(b)
+			}
+			code := func(i int) string {
+				if bm.vary {
+					return fmt.Sprintf(bm.code, i)
+				}
+				return bm.code
+			}
+			sess := newSession(b)
+			mustExecute(b, sess, code(-1)) // Warm-up: go.mod, build cache, etc.
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e := mustExecute(b, sess, code(i))
+				recorder.AddDuration("execute/"+bm.name, e.Elapsed)
+			}
+		})
+	}
+}
+
+// BenchmarkComplete measures the latency of auto-complete requests, served by `gopls`.
+func BenchmarkComplete(b *testing.B) {
+	if !GoplsInstalled() {
+		b.Skip("gopls is not installed, required for auto-complete")
+	}
+	const code = "import \"strings\"\n\n%%\nvar sb strings.Builder\nsb."
+	sess := newSession(b)
+	if _, err := sess.Complete(code); err != nil { // Warm-up: starts gopls.
+		b.Fatalf("%+v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		matches, err := sess.Complete(code)
+		elapsed := time.Since(start)
+		if err != nil {
+			b.Fatalf("%+v", err)
+		}
+		if len(matches) == 0 {
+			b.Fatalf("No auto-complete options for %q", code)
+		}
+		recorder.AddDuration("complete/method", elapsed)
+	}
+}
+
+// BenchmarkStream measures the throughput of the output of the executed cells (see --stream_size), and the
+// latency of its first chunk.
+func BenchmarkStream(b *testing.B) {
+	size := *flagStream
+	const line = "0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz"
+	for _, bm := range []struct {
+		name, code string
+		goCode     bool
+	}{
+		{"shell", fmt.Sprintf("!yes %s | head -c %d", line, size), false},
+		{"program", fmt.Sprintf("%%%%\nw := bufio.NewWriter(os.Stdout)\ndefer w.Flush()\nline := %q\n"+
+			"for n := 0; n < %d; n += len(line) {\n\tw.WriteString(line)\n}", line+"\n", size), true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			if bm.goCode {
+				requireGoImports(b)
+			}
+			sess := newSession(b)
+			mustExecute(b, sess, bm.code) // Warm-up.
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e := mustExecute(b, sess, bm.code)
+				if e.StreamBytes < size {
+					b.Fatalf("Only %d of the %d bytes written were received", e.StreamBytes, size)
+				}
+				recorder.AddHigherIsBetter("stream/"+bm.name, "MB/s", float64(e.StreamBytes)/(1<<20)/e.Elapsed.Seconds())
+				recorder.AddDuration("stream/"+bm.name+"/first_output", e.FirstOutput)
+			}
+		})
+	}
+}
+
+// BenchmarkNotebook replays the synthetic notebook `synthetic.ipynb`, in a new session for each iteration, and
+// records the latency of each cell.
+func BenchmarkNotebook(b *testing.B) {
+	requireGoImports(b)
+	cells, err := LoadNotebook("synthetic.ipynb")
+	if err != nil {
+		b.Fatalf("%+v", err)
+	}
+	s := getServer(b)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		sess, err := s.NewSession()
+		if err != nil {
+			b.Fatalf("%+v", err)
+		}
+		b.StartTimer()
+		err = ReplayNotebook(sess, cells, recorder)
+		b.StopTimer()
+		if closeErr := sess.Close(); closeErr != nil {
+			b.Errorf("Failed to close session: %+v", closeErr)
+		}
+		if err != nil {
+			b.Fatalf("%+v\nGoNB logs:\n%s", err, strings.TrimSpace(s.Logs()))
+		}
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Cell is a code cell of a notebook replayed by the benchmarks.
+type Cell struct {
+	// Name of the cell, used in the name of its metric: it is taken from the cell metadata `{"bench": {"name": ...}}`,
+	// or if not set, it is "cell_<index>", with the index of the code cell (starting from 1).
+	Name string
+
+	// Code of the cell.
+	Code string
+}
+
+// LoadNotebook reads the code cells of a Jupyter notebook (`.ipynb`), to be replayed with ReplayNotebook.
+// Empty cells are skipped.
+func LoadNotebook(filePath string) ([]Cell, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading notebook")
+	}
+	var notebook struct {
+		Cells []struct {
+			CellType string          `json:"cell_type"`
+			Source   json.RawMessage `json:"source"`
+			Metadata struct {
+				Bench struct {
+					Name string `json:"name"`
+				} `json:"bench"`
+			} `json:"metadata"`
+		} `json:"cells"`
+	}
+	if err = json.Unmarshal(contents, &notebook); err != nil {
+		return nil, errors.Wrapf(err, "decoding notebook %q", filePath)
+	}
+	var cells []Cell
+	for _, nbCell := range notebook.Cells {
+		if nbCell.CellType != "code" {
+			continue
+		}
+		// The source is either a string, or a list of lines (with their "\n").
+		var code string
+		if err = json.Unmarshal(nbCell.Source, &code); err != nil {
+			var lines []string
+			if err = json.Unmarshal(nbCell.Source, &lines); err != nil {
+				return nil, errors.Wrapf(err, "decoding source of cell #%d of %q", len(cells)+1, filePath)
+			}
+			code = strings.Join(lines, "")
+		}
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		name := nbCell.Metadata.Bench.Name
+		if name == "" {
+			name = fmt.Sprintf("cell_%02d", len(cells)+1)
+		}
+		cells = append(cells, Cell{Name: name, Code: code})
+	}
+	return cells, nil
+}
+
+// ReplayNotebook executes the cells in order in the session, and records their latency in the metrics
+// "notebook/<cell name>", and the total in "notebook/total".
+func ReplayNotebook(sess *Session, cells []Cell, recorder *Recorder) error {
+	var total time.Duration
+	for _, cell := range cells {
+		e, err := sess.Execute(cell.Code)
+		if err != nil {
+			return errors.WithMessagef(err, "replaying notebook cell %q", cell.Name)
+		}
+		recorder.AddDuration("notebook/"+cell.Name, e.Elapsed)
+		total += e.Elapsed
+	}
+	recorder.AddDuration("notebook/total", total)
+	return nil
+}
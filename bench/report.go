@@ -0,0 +1,243 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report holds the results of a run of the benchmarks, in a machine-readable form: it is saved as JSON with
+// Report.Save, and compared with a previous run (the baseline) with Compare.
+type Report struct {
+	// Kernel identifies the version of GoNB benchmarked, e.g.: the `git describe` of its source tree.
+	Kernel string `json:"kernel"`
+
+	GoVersion string    `json:"go_version"`
+	Platform  string    `json:"platform"`
+	Date      time.Time `json:"date"`
+
+	// Results sorted by name.
+	Results []*Result `json:"results"`
+}
+
+// Result summarizes the samples of one metric.
+type Result struct {
+	// Name of the metric, e.g.: "execute/trivial".
+	Name string `json:"name"`
+
+	// Unit of the values, e.g.: "ms" or "MB/s".
+	Unit string `json:"unit"`
+
+	// HigherIsBetter is set for metrics like throughput. Otherwise, lower values are better (e.g.: latency).
+	HigherIsBetter bool `json:"higher_is_better,omitempty"`
+
+	// Statistics of the samples.
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean"`
+	Median  float64 `json:"median"`
+	P90     float64 `json:"p90"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// Recorder collects the samples of the metrics measured by the benchmarks. It is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+type metric struct {
+	unit           string
+	higherIsBetter bool
+	samples        []float64
+}
+
+// NewRecorder creates an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{metrics: make(map[string]*metric)}
+}
+
+// Add a sample of the metric. For metrics where higher values are better (e.g.: throughput), use AddHigherIsBetter.
+func (r *Recorder) Add(name, unit string, value float64) {
+	r.add(name, unit, false, value)
+}
+
+// AddHigherIsBetter adds a sample of a metric where higher values are better, e.g.: throughput.
+func (r *Recorder) AddHigherIsBetter(name, unit string, value float64) {
+	r.add(name, unit, true, value)
+}
+
+// AddDuration adds a latency sample, in milliseconds.
+func (r *Recorder) AddDuration(name string, d time.Duration) {
+	r.add(name, "ms", false, float64(d)/float64(time.Millisecond))
+}
+
+func (r *Recorder) add(name, unit string, higherIsBetter bool, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, found := r.metrics[name]
+	if !found {
+		m = &metric{unit: unit, higherIsBetter: higherIsBetter}
+		r.metrics[name] = m
+	}
+	m.samples = append(m.samples, value)
+}
+
+// Len returns the number of metrics recorded.
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.metrics)
+}
+
+// Report summarizes the samples recorded so far, labeling it with the given kernel version.
+func (r *Recorder) Report(kernel string) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &Report{
+		Kernel:    kernel,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Date:      time.Now().UTC().Truncate(time.Second),
+	}
+	for name, m := range r.metrics {
+		result := summarize(m.samples)
+		result.Name = name
+		result.Unit = m.unit
+		result.HigherIsBetter = m.higherIsBetter
+		report.Results = append(report.Results, result)
+	}
+	sort.Slice(report.Results, func(i, j int) bool { return report.Results[i].Name < report.Results[j].Name })
+	return report
+}
+
+// summarize returns the statistics of the samples. The percentiles use the nearest-rank method.
+func summarize(samples []float64) *Result {
+	result := &Result{Samples: len(samples)}
+	if len(samples) == 0 {
+		return result
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	result.Mean = sum / float64(len(sorted))
+	result.Median = percentile(sorted, 0.5)
+	result.P90 = percentile(sorted, 0.9)
+	result.Min = sorted[0]
+	result.Max = sorted[len(sorted)-1]
+	return result
+}
+
+// percentile of the sorted samples, using the nearest-rank method.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// Save the report as indented JSON.
+func (report *Report) Save(filePath string) error {
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "encoding benchmarks report")
+	}
+	if err = os.WriteFile(filePath, append(encoded, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "writing benchmarks report to %q", filePath)
+	}
+	return nil
+}
+
+// LoadReport reads a report saved with Report.Save.
+func LoadReport(filePath string) (*Report, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading benchmarks report")
+	}
+	report := &Report{}
+	if err = json.Unmarshal(contents, report); err != nil {
+		return nil, errors.Wrapf(err, "decoding benchmarks report %q", filePath)
+	}
+	return report, nil
+}
+
+// Result returns the result with the given name, or nil if not found.
+func (report *Report) Result(name string) *Result {
+	for _, result := range report.Results {
+		if result.Name == name {
+			return result
+		}
+	}
+	return nil
+}
+
+// Regression is a metric that got worse than the tolerance, compared to the baseline.
+type Regression struct {
+	Name, Unit string
+
+	// Baseline and Current medians of the metric.
+	Baseline, Current float64
+
+	// Change is the relative change of the median: negative if it got worse for HigherIsBetter metrics.
+	Change float64
+
+	BaselineKernel, CurrentKernel string
+}
+
+// String describes the regression.
+func (r Regression) String() string {
+	return fmt.Sprintf("%s: median %.2f%s (%s) -> %.2f%s (%s), %+.1f%%",
+		r.Name, r.Baseline, r.Unit, r.BaselineKernel, r.Current, r.Unit, r.CurrentKernel, 100*r.Change)
+}
+
+// Compare the medians of the metrics present in both reports, and returns the ones that got worse by more than
+// `tolerance` (relative, e.g.: 0.2 for 20%). Metrics present in only one of the reports are ignored.
+func Compare(baseline, current *Report, tolerance float64) []Regression {
+	var regressions []Regression
+	for _, cur := range current.Results {
+		base := baseline.Result(cur.Name)
+		if base == nil || base.Samples == 0 || cur.Samples == 0 || base.Median == 0 {
+			continue
+		}
+		change := (cur.Median - base.Median) / base.Median
+		worse := change > tolerance
+		if cur.HigherIsBetter {
+			worse = -change > tolerance
+		}
+		if !worse {
+			continue
+		}
+		regressions = append(regressions, Regression{
+			Name:           cur.Name,
+			Unit:           cur.Unit,
+			Baseline:       base.Median,
+			Current:        cur.Median,
+			Change:         change,
+			BaselineKernel: baseline.Kernel,
+			CurrentKernel:  current.Kernel,
+		})
+	}
+	return regressions
+}
+
+// String returns a table with the results, for humans.
+func (report *Report) String() string {
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "GoNB benchmarks: kernel %s, %s, %s\n", report.Kernel, report.GoVersion, report.Platform)
+	for _, r := range report.Results {
+		_, _ = fmt.Fprintf(&sb, "  %-32s %4d samples  median %10.2f %-5s  p90 %10.2f  min %10.2f  max %10.2f\n",
+			r.Name, r.Samples, r.Median, r.Unit, r.P90, r.Min, r.Max)
+	}
+	return sb.String()
+}
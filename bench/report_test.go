@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestRecorderReport(t *testing.T) {
+	r := NewRecorder()
+	for _, v := range []float64{5, 1, 4, 2, 3} {
+		r.Add("execute/trivial", "ms", v)
+	}
+	r.AddDuration("complete/method", 1500*time.Microsecond)
+	r.AddHigherIsBetter("stream/shell", "MB/s", 100)
+	require.Equal(t, 3, r.Len())
+
+	report := r.Report("v1")
+	assert.Equal(t, "v1", report.Kernel)
+	require.Len(t, report.Results, 3)
+	assert.Equal(t, "complete/method", report.Results[0].Name) // Sorted by name.
+	assert.Equal(t, 1.5, report.Results[0].Median)
+
+	result := report.Result("execute/trivial")
+	require.NotNil(t, result)
+	assert.Equal(t, 5, result.Samples)
+	assert.Equal(t, 3.0, result.Mean)
+	assert.Equal(t, 3.0, result.Median)
+	assert.Equal(t, 5.0, result.P90)
+	assert.Equal(t, 1.0, result.Min)
+	assert.Equal(t, 5.0, result.Max)
+	assert.True(t, report.Result("stream/shell").HigherIsBetter)
+	assert.Nil(t, report.Result("unknown"))
+}
+
+func TestCompare(t *testing.T) {
+	baseline := &Report{Kernel: "v1", Results: []*Result{
+		{Name: "execute/trivial", Unit: "ms", Samples: 10, Median: 100},
+		{Name: "complete/method", Unit: "ms", Samples: 10, Median: 10},
+		{Name: "stream/shell", Unit: "MB/s", HigherIsBetter: true, Samples: 10, Median: 50},
+		{Name: "removed", Unit: "ms", Samples: 10, Median: 1},
+	}}
+	current := &Report{Kernel: "v2", Results: []*Result{
+		{Name: "execute/trivial", Unit: "ms", Samples: 10, Median: 110}, // +10%: tolerated.
+		{Name: "complete/method", Unit: "ms", Samples: 10, Median: 15},  // +50%: regression.
+		{Name: "stream/shell", Unit: "MB/s", HigherIsBetter: true, Samples: 10, Median: 30},
+		{Name: "added", Unit: "ms", Samples: 10, Median: 1000},
+	}}
+	regressions := Compare(baseline, current, 0.2)
+	require.Len(t, regressions, 2)
+	assert.Equal(t, "complete/method", regressions[0].Name)
+	assert.InDelta(t, 0.5, regressions[0].Change, 1e-9)
+	assert.Equal(t, "stream/shell", regressions[1].Name)
+	assert.InDelta(t, -0.4, regressions[1].Change, 1e-9)
+	assert.Equal(t, "complete/method: median 10.00ms (v1) -> 15.00ms (v2), +50.0%", regressions[0].String())
+
+	// Improvements are never regressions.
+	assert.Empty(t, Compare(current, baseline, 0))
+}
+
+func TestReportSaveLoad(t *testing.T) {
+	r := NewRecorder()
+	r.Add("execute/trivial", "ms", 42)
+	report := r.Report("v1")
+	filePath := path.Join(t.TempDir(), "report.json")
+	require.NoError(t, report.Save(filePath))
+	loaded, err := LoadReport(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, report.Kernel, loaded.Kernel)
+	assert.True(t, report.Date.Equal(loaded.Date))
+	assert.Equal(t, report.Results, loaded.Results)
+}
+
+func TestLoadNotebook(t *testing.T) {
+	cells, err := LoadNotebook("synthetic.ipynb")
+	require.NoError(t, err)
+	require.NotEmpty(t, cells)
+	names := make(map[string]bool)
+	for _, cell := range cells {
+		assert.NotEmpty(t, cell.Code)
+		assert.False(t, names[cell.Name], "duplicate cell name %q", cell.Name)
+		names[cell.Name] = true
+	}
+	assert.Equal(t, "setup", cells[0].Name)
+	assert.Equal(t, "%env GONB_BENCH=1", cells[0].Code)
+}
@@ -0,0 +1,369 @@
+// Package bench is the kernel-side benchmark suite of GoNB, and its performance regression harness.
+//
+// It drives a GoNB binary through its HTTP API (`gonb --http`, see package `internal/httpapi`), so it
+// measures the kernel end-to-end -- dispatching, compilation, execution and output streaming -- without
+// Jupyter, and it can be pointed to any build of GoNB, to compare kernel versions.
+//
+// The benchmarks are in `bench_test.go`, and the results are summarized in a Report, that can be saved as
+// JSON and compared with a baseline. See `bench/README.md` for how to run them.
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ServerStartTimeout is how long StartServer waits for the HTTP API to respond.
+var ServerStartTimeout = 30 * time.Second
+
+// RootDir returns the root directory of the GoNB source tree the package was compiled from.
+func RootDir() string {
+	_, filePath, _, _ := runtime.Caller(0)
+	return path.Dir(path.Dir(filePath)) // ".."
+}
+
+// BuildGonb compiles the GoNB binary from the source tree in rootDir to outputPath.
+func BuildGonb(rootDir, outputPath string) error {
+	cmd := exec.Command("go", "build", "-o", outputPath, ".")
+	cmd.Dir = rootDir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to build GoNB with %q:\n%s", cmd, output)
+	}
+	return nil
+}
+
+// Server is a GoNB binary serving the HTTP API, started with StartServer.
+type Server struct {
+	cmd     *exec.Cmd
+	address string
+	token   string
+	client  *http.Client
+	exited  chan struct{}
+	logs    syncBuffer
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, to collect the logs of the server.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write implements io.Writer.
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// String returns the contents written so far.
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// StartServer starts the GoNB binary serving the HTTP API in a free local port, and waits for it to be ready.
+// Extra arguments (e.g.: "--vmodule=...") are passed to the binary.
+func StartServer(binaryPath string, args ...string) (*Server, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	token, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create token")
+	}
+	s := &Server{
+		address: fmt.Sprintf("localhost:%d", port),
+		token:   token.String(),
+		client:  &http.Client{},
+		exited:  make(chan struct{}),
+	}
+	args = append([]string{"--http=" + s.address, "--http_token=" + s.token}, args...)
+	s.cmd = exec.Command(binaryPath, args...)
+	s.cmd.Stdout = &s.logs
+	s.cmd.Stderr = &s.logs
+	if err = s.cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start %q", s.cmd)
+	}
+	go func() {
+		_ = s.cmd.Wait()
+		close(s.exited)
+	}()
+
+	deadline := time.Now().Add(ServerStartTimeout)
+	for {
+		var resp *http.Response
+		resp, err = s.request(http.MethodGet, "/v1/sessions", nil, "")
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return s, nil
+			}
+			err = errors.Errorf("status %s", resp.Status)
+		}
+		select {
+		case <-s.exited:
+			return nil, errors.Errorf("GoNB server %q exited before serving, logs:\n%s", s.cmd, s.logs.String())
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			_ = s.Close()
+			return nil, errors.WithMessagef(err, "GoNB server %q not ready after %s", s.cmd, ServerStartTimeout)
+		}
+	}
+}
+
+// freePort returns a local TCP port that is not in use.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to find a free port")
+	}
+	defer func() { _ = listener.Close() }()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Close stops the server, and waits for it to exit.
+func (s *Server) Close() error {
+	select {
+	case <-s.exited:
+		return nil
+	default:
+	}
+	if err := s.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return errors.Wrapf(err, "failed to stop GoNB server")
+	}
+	select {
+	case <-s.exited:
+	case <-time.After(15 * time.Second):
+		_ = s.cmd.Process.Kill()
+		<-s.exited
+	}
+	return nil
+}
+
+// request sends an authenticated request to the server. body, if not nil, is encoded as JSON.
+func (s *Server) request(method, urlPath string, body any, accept string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encoding request to %q", urlPath)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, "http://"+s.address+urlPath, reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating request to %q", urlPath)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "request to %q", urlPath)
+	}
+	return resp, nil
+}
+
+// requestJSON sends the request and decodes the JSON response into result.
+func (s *Server) requestJSON(method, urlPath string, body, result any) error {
+	resp, err := s.request(method, urlPath, body, "")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		contents, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("request to %q failed with %s: %s", urlPath, resp.Status, contents)
+	}
+	if result == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.Wrapf(err, "decoding response of %q", urlPath)
+	}
+	return nil
+}
+
+// Session is a session of the HTTP API: the state (declarations, `go.mod`, etc.) of a notebook.
+type Session struct {
+	server *Server
+	id     string
+}
+
+// NewSession creates a new session in the server.
+func (s *Server) NewSession() (*Session, error) {
+	var created struct {
+		Id string `json:"id"`
+	}
+	if err := s.requestJSON(http.MethodPost, "/v1/sessions", nil, &created); err != nil {
+		return nil, errors.WithMessagef(err, "creating session")
+	}
+	return &Session{server: s, id: created.Id}, nil
+}
+
+// Close the session, removing its temporary files.
+func (sess *Session) Close() error {
+	return sess.server.requestJSON(http.MethodDelete, "/v1/sessions/"+sess.id, nil, nil)
+}
+
+// Execution holds the measurements of the execution of a cell.
+type Execution struct {
+	// Elapsed is the time from the request until the reply.
+	Elapsed time.Duration
+
+	// FirstOutput is the time from the request until the first output, or 0 if there were no outputs.
+	FirstOutput time.Duration
+
+	// Outputs is the number of outputs published.
+	Outputs int
+
+	// StreamBytes is the number of bytes of text written to the standard output and error.
+	StreamBytes int
+}
+
+// Execute the cell, reading the outputs as they are streamed. It returns an error if the execution fails.
+func (sess *Session) Execute(code string) (*Execution, error) {
+	start := time.Now()
+	resp, err := sess.server.request(http.MethodPost, "/v1/sessions/"+sess.id+"/execute",
+		map[string]any{"code": code}, "text/event-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		contents, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("execute failed with %s: %s", resp.Status, contents)
+	}
+
+	e := &Execution{}
+	var errorOutput string
+	var eventType string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, found := strings.CutPrefix(line, "event: "); found {
+			eventType = value
+			continue
+		}
+		data, found := strings.CutPrefix(line, "data: ")
+		if !found {
+			continue
+		}
+		if eventType == "execute_reply" {
+			e.Elapsed = time.Since(start)
+			var reply struct {
+				Status string `json:"status"`
+			}
+			if err = json.Unmarshal([]byte(data), &reply); err != nil {
+				return nil, errors.Wrapf(err, "decoding execute_reply")
+			}
+			if reply.Status != "ok" {
+				return nil, errors.Errorf("cell execution failed with status %q:\n%s", reply.Status, errorOutput)
+			}
+			return e, nil
+		}
+		if e.Outputs == 0 {
+			e.FirstOutput = time.Since(start)
+		}
+		e.Outputs++
+		switch eventType {
+		case "stream":
+			var stream struct {
+				Text string `json:"text"`
+			}
+			if err = json.Unmarshal([]byte(data), &stream); err == nil {
+				e.StreamBytes += len(stream.Text)
+			}
+		case "error":
+			errorOutput = data
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading execution outputs")
+	}
+	return nil, errors.New("execution outputs ended without an execute_reply")
+}
+
+// Complete requests the auto-complete options at the end of the code, and returns them.
+func (sess *Session) Complete(code string) ([]string, error) {
+	var reply struct {
+		Status  string   `json:"status"`
+		Matches []string `json:"matches"`
+	}
+	err := sess.server.requestJSON(http.MethodPost, "/v1/sessions/"+sess.id+"/complete", map[string]any{
+		"code":       code,
+		"cursor_pos": utf16Len(code),
+	}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Status != "ok" {
+		return nil, errors.Errorf("complete failed with status %q", reply.Status)
+	}
+	return reply.Matches, nil
+}
+
+// utf16Len returns the length of s in UTF-16 units, the unit of cursor positions in Jupyter.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// Logs returns the output of the GoNB server so far, helpful when a benchmark fails.
+func (s *Server) Logs() string {
+	return s.logs.String()
+}
+
+// GoplsInstalled reports whether `gopls` is available, required for auto-complete.
+func GoplsInstalled() bool {
+	_, err := exec.LookPath("gopls")
+	return err == nil
+}
+
+// GoImportsInstalled reports whether `goimports` is available, required to execute Go code.
+func GoImportsInstalled() bool {
+	_, err := exec.LookPath("goimports")
+	return err == nil
+}
+
+// GitDescribe returns the `git describe` of the source tree in rootDir (e.g.: "v0.10.0-12-gabcdef-dirty"), or ""
+// if not available. It is used to label the results of the benchmarks.
+func GitDescribe(rootDir string) string {
+	cmd := exec.Command("git", "describe", "--always", "--dirty")
+	cmd.Dir = rootDir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 	"golang.org/x/exp/constraints"
+	"golang.org/x/mod/semver"
 	"io/fs"
 	"os"
 	"os/user"
@@ -15,6 +16,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 // Panicf panics with an error constructed with the given format and args.
@@ -348,3 +350,34 @@ func (f *ArrayFlag) Set(value string) error {
 	*f = append(*f, value)
 	return nil
 }
+
+// IncompleteUTF8Suffix returns the number of bytes at the end of data that are the start of a UTF-8 encoded
+// rune that is not complete (at most utf8.UTFMax-1), or 0 if data doesn't end with an incomplete rune.
+//
+// It is used when data is split into chunks, to avoid breaking a rune between the chunks.
+func IncompleteUTF8Suffix(data []byte) int {
+	for ii := len(data) - 1; ii >= 0 && ii > len(data)-utf8.UTFMax; ii-- {
+		if utf8.RuneStart(data[ii]) {
+			if utf8.FullRune(data[ii:]) {
+				return 0
+			}
+			return len(data) - ii
+		}
+	}
+	return 0
+}
+
+// MinGoVersion is the oldest Go toolchain GoNB is tested with, and the tutorials are written for (they use the
+// `min` and `max` builtins).
+const MinGoVersion = "go1.21"
+
+// IsOldGoVersion returns whether the Go version, as reported by `go env GOVERSION` (e.g.: "go1.22.1"), is older
+// than MinGoVersion. Release candidates and betas (e.g.: "go1.22rc1") are compared by their release, and
+// versions that can't be parsed (including empty ones) are considered old.
+func IsOldGoVersion(version string) bool {
+	version = "v" + strings.TrimPrefix(version, "go")
+	if idx := strings.IndexAny(version, "rb"); idx > 0 {
+		version = version[:idx]
+	}
+	return !semver.IsValid(version) || semver.Compare(version, "v"+strings.TrimPrefix(MinGoVersion, "go")) < 0
+}
@@ -20,3 +20,27 @@ func TestReplaceEnvVars(t *testing.T) {
 	want = "foo"
 	assert.Equal(t, want, ReplaceEnvVars(str))
 }
+
+func TestIncompleteUTF8Suffix(t *testing.T) {
+	euro := []byte("€") // 3 bytes.
+	assert.Equal(t, 0, IncompleteUTF8Suffix(nil))
+	assert.Equal(t, 0, IncompleteUTF8Suffix([]byte("abc")))
+	assert.Equal(t, 0, IncompleteUTF8Suffix(append([]byte("a"), euro...)))
+	assert.Equal(t, 1, IncompleteUTF8Suffix(append([]byte("a"), euro[:1]...)))
+	assert.Equal(t, 2, IncompleteUTF8Suffix(append([]byte("a"), euro[:2]...)))
+	assert.Equal(t, 0, IncompleteUTF8Suffix([]byte("a\xff"))) // Invalid, but not incomplete.
+	assert.Equal(t, 0, IncompleteUTF8Suffix(euro[1:]))        // Continuation bytes only.
+	assert.Equal(t, 1, IncompleteUTF8Suffix([]byte("\xe9")))  // E.g.: latin-1 "é", may be the start of a rune.
+}
+
+func TestIsOldGoVersion(t *testing.T) {
+	assert.False(t, IsOldGoVersion(MinGoVersion))
+	assert.False(t, IsOldGoVersion("go1.21.5"))
+	assert.False(t, IsOldGoVersion("go1.22rc1"))
+	assert.False(t, IsOldGoVersion("go1.23beta2"))
+	assert.True(t, IsOldGoVersion("go1.20.14"))
+	assert.False(t, IsOldGoVersion("go1.21rc2")) // Compared by its release.
+	assert.True(t, IsOldGoVersion("go1.19"))
+	assert.True(t, IsOldGoVersion(""))
+	assert.True(t, IsOldGoVersion("devel"))
+}
@@ -18,6 +18,9 @@ require (
 	go.lsp.dev/jsonrpc2 v0.10.0
 	golang.org/x/exp v0.0.0-20240119083558-1b970713d09a
 	golang.org/x/mod v0.14.0
+	golang.org/x/sys v0.16.0
+	golang.org/x/sys v0.16.0
+	golang.org/x/text v0.14.0
 	k8s.io/klog/v2 v2.120.1
 )
 
@@ -41,8 +44,6 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.26.0 // indirect
 	golang.org/x/sync v0.6.0 // indirect
-	golang.org/x/sys v0.16.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/xerrors v0.0.0-20231012003039-104605ab7028 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
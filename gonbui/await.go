@@ -0,0 +1,200 @@
+package gonbui
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// This file implements waiting for values sent by the front-end to an address, used by the functions that
+// block until the user answers (e.g.: RequestForm). The values are still delivered to the subscribers of the
+// `comms` package.
+
+var (
+	// ErrTimeout is returned when the user didn't answer in time.
+	ErrTimeout = errors.New("gonbui: timed out waiting for the front-end")
+
+	// ErrInterrupted is returned when the cell is interrupted while waiting for the user (see OnInterrupt).
+	ErrInterrupted = errors.New("gonbui: interrupted while waiting for the front-end")
+)
+
+// WaitFor blocks until the front-end sends to the address a value for which predicate returns true (any value, if
+// predicate is nil), and returns it. It can be used to pause the program until a specific widget interaction --
+// e.g.: "click Start to continue", waiting on the address of a button (see `widgets.ButtonBuilder.Address`).
+//
+// Only values sent after WaitFor is called are considered. Values are delivered as decoded from JSON: numbers
+// are float64, and objects are `map[string]any`. The values are still delivered to the other subscribers of the
+// address (see the `comms` package).
+//
+// If timeout > 0 and no value is accepted in time, it returns ErrTimeout. If the cell is interrupted while
+// waiting, it returns ErrInterrupted. It returns ErrNotInNotebook if the program is not executed by GoNB.
+func WaitFor(address string, predicate func(value any) bool, timeout time.Duration) (any, error) {
+	if !IsNotebook {
+		return nil, ErrNotInNotebook
+	}
+	if err := Open(); err != nil {
+		return nil, err
+	}
+	return newValueWaiter(address, predicate).wait(timeout)
+}
+
+// valueWaiter waits for the first value sent by the front-end to an address that it accepts.
+type valueWaiter struct {
+	address string
+	accept  func(value any) bool
+	result  chan any
+}
+
+var (
+	muWaiters sync.Mutex
+	waiters   = make(map[string][]*valueWaiter)
+
+	// waitersInterrupted is closed when the cell is interrupted, releasing all waiters.
+	waitersInterrupted     = make(chan struct{})
+	waitersInterruptedOnce sync.Once
+
+	// addressSubscriptions counts the subscriptions to each address, see SubscribeAddress.
+	addressSubscriptions = make(map[string]int)
+)
+
+// SubscribeAddress asks GoNB to deliver to the program the values the front-end sends to the address.
+//
+// It is a low-level function, used by the `comms` package: the subscriptions are counted, and GoNB is only
+// informed of the first one -- and, with UnsubscribeAddress, when there are no more subscriptions.
+func SubscribeAddress(address string) {
+	muWaiters.Lock()
+	addressSubscriptions[address]++
+	first := addressSubscriptions[address] == 1
+	muWaiters.Unlock()
+	if first {
+		SendData(&protocol.DisplayData{
+			Data: map[protocol.MIMEType]any{
+				protocol.MIMECommSubscribe: &protocol.CommSubscription{Address: address},
+			},
+		})
+	}
+}
+
+// UnsubscribeAddress releases a subscription created with SubscribeAddress.
+func UnsubscribeAddress(address string) {
+	muWaiters.Lock()
+	count := addressSubscriptions[address]
+	if count == 0 {
+		muWaiters.Unlock()
+		return
+	}
+	last := count == 1
+	if last {
+		delete(addressSubscriptions, address)
+	} else {
+		addressSubscriptions[address] = count - 1
+	}
+	muWaiters.Unlock()
+	if last {
+		SendData(&protocol.DisplayData{
+			Data: map[protocol.MIMEType]any{
+				protocol.MIMECommSubscribe: &protocol.CommSubscription{Address: address, Unsubscribe: true},
+			},
+		})
+	}
+}
+
+// newValueWaiter subscribes to the address, and returns a waiter for the first value accepted (all values are
+// accepted if accept is nil). It should be created before requesting the value from the front-end, so it is not
+// missed.
+func newValueWaiter(address string, accept func(value any) bool) *valueWaiter {
+	w := &valueWaiter{address: address, accept: accept, result: make(chan any, 1)}
+	muWaiters.Lock()
+	waiters[address] = append(waiters[address], w)
+	muWaiters.Unlock()
+	SubscribeAddress(address)
+	return w
+}
+
+// wait for the value, up to the timeout (if > 0). The waiter is released when it returns.
+func (w *valueWaiter) wait(timeout time.Duration) (value any, err error) {
+	defer w.release()
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+	select {
+	case value = <-w.result:
+		return value, nil
+	case <-waitersInterrupted:
+		return nil, ErrInterrupted
+	case <-timeoutChan:
+		return nil, ErrTimeout
+	}
+}
+
+// release the waiter and its subscription.
+func (w *valueWaiter) release() {
+	muWaiters.Lock()
+	ws := waiters[w.address]
+	for ii, other := range ws {
+		if other == w {
+			ws = append(ws[:ii], ws[ii+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(waiters, w.address)
+	} else {
+		waiters[w.address] = ws
+	}
+	muWaiters.Unlock()
+	UnsubscribeAddress(w.address)
+}
+
+// deliverToWaiters delivers the value received from the front-end to the waiters of its address that accept it.
+func deliverToWaiters(valueMsg *protocol.CommValue) {
+	muWaiters.Lock()
+	ws := append([]*valueWaiter(nil), waiters[valueMsg.Address]...)
+	muWaiters.Unlock()
+	for _, w := range ws {
+		if w.accept != nil && !w.accept(valueMsg.Value) {
+			continue
+		}
+		select {
+		case w.result <- valueMsg.Value:
+		default:
+			// Already received a value.
+		}
+	}
+}
+
+// interruptWaiters releases all current and future waiters with ErrInterrupted, when the cell is interrupted.
+func interruptWaiters() {
+	waitersInterruptedOnce.Do(func() { close(waitersInterrupted) })
+}
+
+// isReply returns whether the value sent by the front-end is a reply to a request (e.g.: RequestForm): a
+// `map[string]any` with at least one of the given keys.
+func isReply(value any, keys ...string) bool {
+	reply, ok := value.(map[string]any)
+	if !ok {
+		return false
+	}
+	for _, key := range keys {
+		if _, found := reply[key]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// closeRequest informs the front-end that the program stopped waiting for the reply sent to address (e.g.: after
+// a timeout), so the request is disabled and not answered later.
+func closeRequest(address string) {
+	SendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMECommValue: &protocol.CommValue{
+				Address: address,
+				Value:   map[string]any{"closed": true},
+			}},
+	})
+}
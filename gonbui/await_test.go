@@ -0,0 +1,147 @@
+package gonbui
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetInterrupt makes sure waiters are not interrupted, and that the interruption of a test doesn't affect the others.
+func resetInterrupt(t *testing.T) {
+	reset := func() {
+		muWaiters.Lock()
+		defer muWaiters.Unlock()
+		waitersInterrupted = make(chan struct{})
+		waitersInterruptedOnce = sync.Once{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// subscriptions returns the number of subscriptions to the address, and whether it has waiters.
+func subscriptions(address string) (count int, hasWaiters bool) {
+	muWaiters.Lock()
+	defer muWaiters.Unlock()
+	_, hasWaiters = waiters[address]
+	return addressSubscriptions[address], hasWaiters
+}
+
+func deliver(address string, value any) {
+	deliverToWaiters(&protocol.CommValue{Address: address, Value: value})
+}
+
+func TestWaiterPredicate(t *testing.T) {
+	resetInterrupt(t)
+	const address = "/test/predicate"
+	even := newValueWaiter(address, func(value any) bool {
+		v, ok := value.(float64)
+		return ok && int(v)%2 == 0
+	})
+	first := newValueWaiter(address, nil)
+	count, hasWaiters := subscriptions(address)
+	assert.Equal(t, 2, count)
+	assert.True(t, hasWaiters)
+
+	deliver("/test/other", 0.0) // Other addresses are ignored.
+	deliver(address, "not a number")
+	deliver(address, 1.0)
+	deliver(address, 2.0) // Values after the first are not queued for waiters that accept everything.
+
+	value, err := even.wait(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, value)
+	value, err = first.wait(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "not a number", value)
+
+	count, hasWaiters = subscriptions(address)
+	assert.Zero(t, count)
+	assert.False(t, hasWaiters)
+}
+
+func TestWaiterTimeout(t *testing.T) {
+	resetInterrupt(t)
+	const address = "/test/timeout"
+	w := newValueWaiter(address, func(value any) bool { return value == "yes" })
+	deliver(address, "no")
+	start := time.Now()
+	_, err := w.wait(20 * time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	count, hasWaiters := subscriptions(address)
+	assert.Zero(t, count)
+	assert.False(t, hasWaiters)
+
+	// Values delivered after the waiter was released are ignored.
+	deliver(address, "yes")
+}
+
+func TestWaiterInterrupt(t *testing.T) {
+	resetInterrupt(t)
+	const address = "/test/interrupt"
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for ii := range errs {
+		w := newValueWaiter(address, nil)
+		wg.Add(1)
+		go func(ii int) {
+			defer wg.Done()
+			_, errs[ii] = w.wait(0) // No timeout.
+		}(ii)
+	}
+	interruptWaiters()
+	interruptWaiters() // No-op.
+	wg.Wait()
+	for _, err := range errs {
+		assert.ErrorIs(t, err, ErrInterrupted)
+	}
+
+	// Future waiters are also released.
+	_, err := newValueWaiter(address, nil).wait(5 * time.Second)
+	assert.ErrorIs(t, err, ErrInterrupted)
+	count, hasWaiters := subscriptions(address)
+	assert.Zero(t, count)
+	assert.False(t, hasWaiters)
+}
+
+func TestSubscribeAddress(t *testing.T) {
+	resetInterrupt(t)
+	const address = "/test/subscribe"
+	SubscribeAddress(address)
+	w1 := newValueWaiter(address, nil)
+	w2 := newValueWaiter(address, nil)
+	count, _ := subscriptions(address)
+	assert.Equal(t, 3, count)
+
+	// Releasing a waiter keeps the other subscriptions.
+	w1.release()
+	count, hasWaiters := subscriptions(address)
+	assert.Equal(t, 2, count)
+	assert.True(t, hasWaiters)
+	w2.release()
+	count, hasWaiters = subscriptions(address)
+	assert.Equal(t, 1, count)
+	assert.False(t, hasWaiters)
+
+	// The last subscription removes the address, and extra unsubscriptions are ignored.
+	UnsubscribeAddress(address)
+	UnsubscribeAddress(address)
+	muWaiters.Lock()
+	_, found := addressSubscriptions[address]
+	muWaiters.Unlock()
+	assert.False(t, found)
+	SubscribeAddress(address)
+	count, _ = subscriptions(address)
+	assert.Equal(t, 1, count)
+	UnsubscribeAddress(address)
+}
+
+func TestIsReply(t *testing.T) {
+	assert.True(t, isReply(map[string]any{"values": 1}, "values", "cancelled"))
+	assert.True(t, isReply(map[string]any{"cancelled": true}, "values", "cancelled"))
+	assert.False(t, isReply(map[string]any{"other": 1}, "values", "cancelled"))
+	assert.False(t, isReply("values", "values"))
+}
@@ -0,0 +1,69 @@
+package gonbui
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"os"
+	"strconv"
+)
+
+// Build variables injected by GoNB (with `-ldflags -X`) when compiling the cells, so they are recorded in the
+// binaries. They are the same for all executions of a kernel, so they don't prevent reusing previous builds.
+var (
+	buildKernelVersion string
+	buildSessionId     string
+	buildKernelId      string
+	buildNotebookPath  string
+)
+
+// CellMetadata describes the execution of the cell, and the notebook and kernel executing it.
+// Any of the fields may be empty (or 0) if not known.
+type CellMetadata struct {
+	// ExecutionCount of the cell, as shown in the notebook (e.g.: `[12]`).
+	ExecutionCount int
+
+	// CellId of the notebook cell, if provided by the front-end (JupyterLab does).
+	CellId string
+
+	// NotebookPath of the notebook, as reported by JupyterServer.
+	NotebookPath string
+
+	// SessionId is the unique id of the GoNB kernel session, also the prefix of the kernel logs.
+	SessionId string
+
+	// KernelId is the id assigned by Jupyter to the kernel.
+	KernelId string
+
+	// JupyterSession is the session of the Jupyter client (front-end) that requested the execution.
+	JupyterSession string
+
+	// KernelVersion is the version of GoNB.
+	KernelVersion string
+}
+
+// CellInfo returns the metadata of the current cell execution, so logs and artifacts produced by cells can be
+// traced back to their origin. It returns nil if not running in a notebook (IsNotebook is false).
+//
+// The kernel version, session, kernel id and notebook path are injected into the binary when it is compiled,
+// the others are set in the environment (see `protocol.GONB_EXECUTION_COUNT_ENV` and others) for each execution.
+func CellInfo() *CellMetadata {
+	if !IsNotebook {
+		return nil
+	}
+	info := &CellMetadata{
+		CellId:         os.Getenv(protocol.GONB_CELL_ID_ENV),
+		NotebookPath:   buildNotebookPath,
+		SessionId:      buildSessionId,
+		KernelId:       buildKernelId,
+		JupyterSession: os.Getenv(protocol.GONB_JUPYTER_SESSION_ENV),
+		KernelVersion:  buildKernelVersion,
+	}
+	info.ExecutionCount, _ = strconv.Atoi(os.Getenv(protocol.GONB_EXECUTION_COUNT_ENV))
+	if info.KernelId == "" {
+		info.KernelId = os.Getenv(protocol.GONB_JUPYTER_KERNEL_ID_ENV)
+	}
+	if info.NotebookPath == "" {
+		// Set by JupyterServer in the kernel environment, inherited by the program.
+		info.NotebookPath = os.Getenv("JPY_SESSION_NAME")
+	}
+	return info
+}
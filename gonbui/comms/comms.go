@@ -47,13 +47,40 @@ func Start() {
 //
 // This is used to implement widgets, or arbitrary Javascript/Wasm code running
 // in the front-end.
+//
+// If the type of the address was declared (see Declare), the value is validated first.
 func Send[T protocol.CommValueTypes](address string, value T) {
+	coerced, ok := coerceValue(address, value)
+	if !ok {
+		return
+	}
 	data := &protocol.DisplayData{
 		Data: map[protocol.MIMEType]any{
 			protocol.MIMECommValue: &protocol.CommValue{
 				Address: address,
 				Request: false,
-				Value:   value,
+				Value:   coerced,
+			}},
+	}
+	gonbui.SendData(data)
+}
+
+// SendReliable is like Send, but the value is re-sent (with exponential backoff) until the front-end
+// acknowledges it, so a transient problem with the connection doesn't leave the front-end with a stale value.
+//
+// Only the latest value sent to an address is retried, so it is adequate to update the state of a widget:
+// the front-end always ends up with the last value sent.
+func SendReliable[T protocol.CommValueTypes](address string, value T) {
+	coerced, ok := coerceValue(address, value)
+	if !ok {
+		return
+	}
+	data := &protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMECommValue: &protocol.CommValue{
+				Address:  address,
+				Value:    coerced,
+				Reliable: true,
 			}},
 	}
 	gonbui.SendData(data)
@@ -143,22 +170,17 @@ func Subscribe[T protocol.CommValueTypes](address string, callback func(address
 
 	// Inform GoNB to start sending messages for this address.
 	if newAddress {
-		// If the first time someone is subscribing to address, send message to
-		// subscribe.
-		data := &protocol.DisplayData{
-			Data: map[protocol.MIMEType]any{
-				protocol.MIMECommSubscribe: &protocol.CommSubscription{
-					Address:     address,
-					Unsubscribe: false,
-				}},
-		}
-		gonbui.SendData(data)
+		// If the first time someone is subscribing to address: the subscriptions of GoNB are shared with
+		// the other users of the address in gonbui (e.g.: gonbui.RequestForm).
+		gonbui.SubscribeAddress(address)
 	}
 
 	return id
 }
 
 // ConvertTo converts from `any` value to one of the `CommValueTypes`.
+// Numbers are converted between int and float64, and arrays and objects (decoded from JSON as `[]any` and
+// `map[string]any`), have their elements converted.
 // If the conversion fails, it returns an error.
 func ConvertTo[T protocol.CommValueTypes](from any) (to T, err error) {
 	var ok bool
@@ -166,7 +188,7 @@ func ConvertTo[T protocol.CommValueTypes](from any) (to T, err error) {
 	if ok {
 		return
 	}
-	var anyTo any
+	var anyTo, converted any
 	anyTo = to
 	switch anyTo.(type) {
 	case int:
@@ -210,11 +232,67 @@ func ConvertTo[T protocol.CommValueTypes](from any) (to T, err error) {
 			to = anyTo.(T)
 			return
 		}
+
+	case []int:
+		converted, err = convertSlice[int](from)
+	case []float64:
+		converted, err = convertSlice[float64](from)
+	case []string:
+		converted, err = convertSlice[string](from)
+	case map[string]int:
+		converted, err = convertMap[int](from)
+	case map[string]float64:
+		converted, err = convertMap[float64](from)
+	case map[string]string:
+		converted, err = convertMap[string](from)
+	}
+	if converted != nil && err == nil {
+		// Converted to one of the container types.
+		to = converted.(T)
+		return
+	}
+	if err == nil {
+		err = errors.Errorf("failed to convert type %T (%v) to requested type %T", from, from, to)
 	}
-	err = errors.Errorf("failed to convert type %T (%v) to requested type %T", from, from, to)
 	return
 }
 
+// convertSlice converts the values of a JSON decoded array (`[]any`) to a slice of one of the basic CommValueTypes.
+// It returns nil (and no error) if `from` is not an array.
+func convertSlice[E int | float64 | string](from any) (any, error) {
+	values, ok := from.([]any)
+	if !ok {
+		return nil, nil
+	}
+	to := make([]E, len(values))
+	for ii, value := range values {
+		var err error
+		to[ii], err = ConvertTo[E](value)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "element #%d of array", ii)
+		}
+	}
+	return to, nil
+}
+
+// convertMap converts the values of a JSON decoded object (`map[string]any`) to a map of one of the basic
+// CommValueTypes. It returns nil (and no error) if `from` is not an object.
+func convertMap[E int | float64 | string](from any) (any, error) {
+	values, ok := from.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	to := make(map[string]E, len(values))
+	for key, value := range values {
+		var err error
+		to[key], err = ConvertTo[E](value)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "key %q of object", key)
+		}
+	}
+	return to, nil
+}
+
 // Unsubscribe from receiving front-end updates, using the SubscriptionId returned by Subscribe.
 func Unsubscribe(id SubscriptionId) {
 	if gonbui.Open() != nil {
@@ -240,14 +318,7 @@ func Unsubscribe(id SubscriptionId) {
 
 	// No more subscriptions to the address.
 	if len(s) == 0 {
-		data := &protocol.DisplayData{
-			Data: map[protocol.MIMEType]any{
-				protocol.MIMECommSubscribe: &protocol.CommSubscription{
-					Address:     address,
-					Unsubscribe: true,
-				}},
-		}
-		gonbui.SendData(data)
+		gonbui.UnsubscribeAddress(address)
 	}
 }
 
@@ -268,10 +339,15 @@ func dispatchValueUpdates(valueMsg *protocol.CommValue) {
 	subscribers, found := subscriptions[address]
 	if !found {
 		// No (longer any) subscribers to the address, simply drop.
+		muSubscriptions.Unlock()
 		return
 	}
 	subscribers = slices.Clone(subscribers)
 	muSubscriptions.Unlock()
+	value, ok := coerceValue(address, value)
+	if !ok {
+		return
+	}
 	gonbui.Logf("dispatchValueUpdates(%q->%v) -> %d subscribers", valueMsg.Address, value, len(subscribers))
 	for _, s := range subscribers {
 		go s.callback(address, value)
@@ -0,0 +1,42 @@
+package comms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestConvertTo(t *testing.T) {
+	i, err := ConvertTo[int](2.6)
+	require.NoError(t, err)
+	assert.Equal(t, 3, i)
+	i, err = ConvertTo[int]("7")
+	require.NoError(t, err)
+	assert.Equal(t, 7, i)
+	f, err := ConvertTo[float64](3)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, f)
+	ints, err := ConvertTo[[]int]([]any{1.0, 2.4})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ints)
+	ints, err = ConvertTo[[]int]([]any{})
+	require.NoError(t, err)
+	assert.Equal(t, []int{}, ints)
+	m, err := ConvertTo[map[string]string](map[string]any{"a": "b"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "b"}, m)
+
+	// Values that can't be converted are reported, not converted to the zero value.
+	_, err = ConvertTo[int](true)
+	assert.Error(t, err)
+	_, err = ConvertTo[float64]([]any{1.0})
+	assert.Error(t, err)
+	_, err = ConvertTo[string](1)
+	assert.Error(t, err)
+	_, err = ConvertTo[[]byte]("bytes")
+	assert.Error(t, err)
+	_, err = ConvertTo[[]int]("1,2")
+	assert.Error(t, err)
+	_, err = ConvertTo[[]int]([]any{"x"})
+	assert.Error(t, err)
+}
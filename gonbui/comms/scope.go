@@ -0,0 +1,72 @@
+package comms
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"strings"
+	"sync"
+)
+
+// Scope is a namespace of addresses: all addresses created with it (see Scope.Address) share its prefix.
+//
+// It is used to prevent collisions when the same cell (or copies of it) is executed more than once: the
+// front-end elements of previous executions may still be displayed and still send updates. With addresses
+// scoped by execution (see ExecutionScope), those updates are dropped, as opposed to being mixed with the ones
+// of the current execution.
+type Scope struct {
+	prefix string
+}
+
+// NewScope returns a Scope with the given name as prefix: e.g. `NewScope("dashboard").Address("level")`
+// returns "/dashboard/level".
+//
+// Use it for addresses that should be shared across executions, with a name chosen by the user.
+func NewScope(name string) *Scope {
+	return &Scope{prefix: "/" + strings.Trim(name, "/")}
+}
+
+var (
+	muExecutionScope sync.Mutex
+	executionScope   *Scope
+)
+
+// ExecutionScope returns the Scope of the current execution of the cell: its prefix is unique to the
+// execution (e.g.: "/exec/12-5c1d2e3f", where 12 is the execution count of the cell). The widgets use it
+// for their default addresses.
+func ExecutionScope() *Scope {
+	muExecutionScope.Lock()
+	defer muExecutionScope.Unlock()
+	if executionScope == nil {
+		var count int
+		if info := gonbui.CellInfo(); info != nil {
+			count = info.ExecutionCount
+		}
+		executionScope = &Scope{prefix: fmt.Sprintf("/exec/%d-%s", count, common.UniqueId())}
+	}
+	return executionScope
+}
+
+// Prefix shared by all addresses of the Scope.
+func (s *Scope) Prefix() string {
+	return s.prefix
+}
+
+// Address returns the address with the given name in the Scope. Addresses already in the Scope are returned
+// unchanged, so it is safe to scope an address more than once.
+func (s *Scope) Address(name string) string {
+	if s.Contains(name) {
+		return name
+	}
+	return s.prefix + "/" + strings.TrimPrefix(name, "/")
+}
+
+// Scope returns a Scope nested in this one, with the given name.
+func (s *Scope) Scope(name string) *Scope {
+	return &Scope{prefix: s.Address(strings.TrimSuffix(name, "/"))}
+}
+
+// Contains returns whether the address belongs to the Scope (or to one of its nested scopes).
+func (s *Scope) Contains(address string) bool {
+	return strings.HasPrefix(address, s.prefix+"/")
+}
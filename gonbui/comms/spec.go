@@ -0,0 +1,202 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"log"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Spec declares the type and the valid values of an address, see Declare.
+//
+// Once declared, the values sent to the address (with Send or SendReliable) and received from the front-end
+// are converted to the type, and validated: numbers out of range are clamped (with a warning), and values
+// that can't be converted, that are NaN while a range is set, or that are not one of the valid values, are
+// dropped (with a warning).
+type Spec[T protocol.CommValueTypes] struct {
+	address string
+
+	// Range of valid numbers, if hasRange is set. For slices, it applies to each element.
+	hasRange bool
+	min, max float64
+
+	// enum holds the valid values, if not empty.
+	enum []T
+}
+
+// coercer is the type independent interface of a Spec, used to validate the values of the address.
+type coercer interface {
+	coerceAny(value any) (any, error)
+}
+
+var (
+	muSpecs sync.Mutex
+	specs   = make(map[string]coercer)
+)
+
+// Declare the type of the values of the address, and returns its Spec, that can be further configured with
+// WithRange or WithEnum. Declaring it again replaces the previous declaration.
+//
+// This is used by the widgets, so their values are always valid.
+func Declare[T protocol.CommValueTypes](address string) *Spec[T] {
+	s := &Spec[T]{address: address}
+	muSpecs.Lock()
+	defer muSpecs.Unlock()
+	specs[address] = s
+	return s
+}
+
+// Undeclare removes the Spec of the address: its values are no longer validated.
+func Undeclare(address string) {
+	muSpecs.Lock()
+	defer muSpecs.Unlock()
+	delete(specs, address)
+}
+
+// coerceValue validates the value with the Spec of the address, if one was declared. It returns false if the
+// value is invalid, in which case it should be dropped.
+func coerceValue(address string, value any) (any, bool) {
+	muSpecs.Lock()
+	spec, found := specs[address]
+	muSpecs.Unlock()
+	if !found {
+		return value, true
+	}
+	coerced, err := spec.coerceAny(value)
+	if err != nil {
+		log.Printf("Warning: gonbui/comms: value for address %q dropped: %+v", address, err)
+		return nil, false
+	}
+	return coerced, true
+}
+
+// WithRange sets the range of valid numbers (inclusive) of the address: values out of range are clamped.
+// For slices, it applies to each element. It is ignored for strings.
+//
+// It returns itself, to allow cascaded settings.
+func (s *Spec[T]) WithRange(min, max float64) *Spec[T] {
+	s.hasRange = true
+	s.min, s.max = min, max
+	return s
+}
+
+// WithEnum sets the valid values of the address: other values are dropped.
+//
+// It returns itself, to allow cascaded settings.
+func (s *Spec[T]) WithEnum(values ...T) *Spec[T] {
+	s.enum = values
+	return s
+}
+
+// Address of the Spec.
+func (s *Spec[T]) Address() string {
+	return s.address
+}
+
+// Coerce converts the value to the type of the Spec and validates it: numbers out of range are clamped (with a
+// warning). It returns an error if the value can't be converted, if it is (or includes) NaN while a range is
+// set, or if it is not one of the valid values.
+func (s *Spec[T]) Coerce(value any) (T, error) {
+	typed, err := ConvertTo[T](value)
+	if err != nil {
+		return typed, errors.WithMessagef(err, "address %q", s.address)
+	}
+	if s.hasRange {
+		if hasNaN(any(typed)) {
+			return typed, errors.Errorf("value %v for address %q is not a number, it can't be clamped to the range [%g, %g]",
+				typed, s.address, s.min, s.max)
+		}
+		if clamped, changed := clamp(any(typed), s.min, s.max); changed {
+			log.Printf("Warning: gonbui/comms: value %v for address %q out of the range [%g, %g], clamped to %v",
+				typed, s.address, s.min, s.max, clamped)
+			typed = clamped.(T)
+		}
+	}
+	if len(s.enum) > 0 && !slices.ContainsFunc(s.enum, func(e T) bool { return reflect.DeepEqual(e, typed) }) {
+		return typed, errors.Errorf("value %v for address %q is not one of the valid values %v", typed, s.address, s.enum)
+	}
+	return typed, nil
+}
+
+// coerceAny implements coercer.
+func (s *Spec[T]) coerceAny(value any) (any, error) {
+	return s.Coerce(value)
+}
+
+// Send the value to the address, see Send.
+func (s *Spec[T]) Send(value T) {
+	Send(s.address, value)
+}
+
+// SendReliable sends the value to the address, see SendReliable.
+func (s *Spec[T]) SendReliable(value T) {
+	SendReliable(s.address, value)
+}
+
+// Subscribe to the updates of the address, see Subscribe.
+func (s *Spec[T]) Subscribe(callback func(address string, value T)) SubscriptionId {
+	return Subscribe[T](s.address, callback)
+}
+
+// Listen to the updates of the address, see Listen.
+func (s *Spec[T]) Listen() *AddressChan[T] {
+	return Listen[T](s.address)
+}
+
+// clamp the number (or the numbers of a slice) to the range. It returns whether any value was changed.
+func clamp(value any, min, max float64) (any, bool) {
+	switch v := value.(type) {
+	case int:
+		clamped := int(clampFloat(float64(v), min, max))
+		return clamped, clamped != v
+	case float64:
+		clamped := clampFloat(v, min, max)
+		return clamped, clamped != v
+	case []int:
+		return clampSlice(v, min, max)
+	case []float64:
+		return clampSlice(v, min, max)
+	}
+	return value, false
+}
+
+// hasNaN returns whether the number, or any of the numbers of a slice, is NaN.
+func hasNaN(value any) bool {
+	switch v := value.(type) {
+	case float64:
+		return math.IsNaN(v)
+	case []float64:
+		return slices.ContainsFunc(v, math.IsNaN)
+	}
+	return false
+}
+
+// clampSlice clamps each of the numbers, returning a new slice if any was changed.
+func clampSlice[E int | float64](values []E, min, max float64) (any, bool) {
+	var clamped []E
+	for ii, value := range values {
+		if newValue := E(clampFloat(float64(value), min, max)); newValue != value {
+			if clamped == nil {
+				clamped = slices.Clone(values)
+			}
+			clamped[ii] = newValue
+		}
+	}
+	if clamped == nil {
+		return values, false
+	}
+	return clamped, true
+}
+
+func clampFloat(value, min, max float64) float64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,87 @@
+package comms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestSpecCoerce(t *testing.T) {
+	ints := Declare[int]("/test/int").WithRange(0, 10)
+	floats := Declare[float64]("/test/float").WithRange(-1, 1)
+	intSlices := Declare[[]int]("/test/ints").WithRange(0, 10)
+	floatSlices := Declare[[]float64]("/test/floats").WithRange(0, 1)
+	choices := Declare[string]("/test/choice").WithEnum("a", "b")
+	texts := Declare[string]("/test/string").WithRange(0, 1) // Range is ignored for strings.
+	defer func() {
+		for _, address := range []string{"/test/int", "/test/float", "/test/ints", "/test/floats", "/test/choice",
+			"/test/string"} {
+			Undeclare(address)
+		}
+	}()
+
+	for _, tc := range []struct {
+		name    string
+		coerce  func(value any) (any, error)
+		value   any
+		want    any
+		wantErr string
+	}{
+		// Type conversion.
+		{"int", ints.coerceAny, 5, 5, ""},
+		{"float to int", ints.coerceAny, 4.6, 5, ""},
+		{"string to int", ints.coerceAny, "3", 3, ""},
+		{"invalid string to int", ints.coerceAny, "three", nil, `address "/test/int"`},
+		{"bool to int", ints.coerceAny, true, nil, "failed to convert"},
+		{"int to float", floats.coerceAny, 1, 1.0, ""},
+		{"JSON array to []int", intSlices.coerceAny, []any{1.0, 2.0}, []int{1, 2}, ""},
+		{"JSON array to []float64", floatSlices.coerceAny, []any{0.5, 1}, []float64{0.5, 1}, ""},
+		{"invalid JSON array", intSlices.coerceAny, []any{"x"}, nil, "failed to convert"},
+
+		// Range clamping.
+		{"int above range", ints.coerceAny, 11, 10, ""},
+		{"int below range", ints.coerceAny, -3.0, 0, ""},
+		{"float above range", floats.coerceAny, 1.5, 1.0, ""},
+		{"float at the limit", floats.coerceAny, -1.0, -1.0, ""},
+		{"+Inf", floats.coerceAny, math.Inf(1), 1.0, ""},
+		{"-Inf", floats.coerceAny, math.Inf(-1), -1.0, ""},
+		{"NaN", floats.coerceAny, math.NaN(), nil, "is not a number"},
+		{"[]int clamped", intSlices.coerceAny, []int{-1, 5, 20}, []int{0, 5, 10}, ""},
+		{"[]float64 clamped", floatSlices.coerceAny, []float64{0.5, 2}, []float64{0.5, 1}, ""},
+		{"[]float64 with NaN", floatSlices.coerceAny, []float64{0.5, math.NaN()}, nil, "is not a number"},
+		{"range ignored for strings", texts.coerceAny, "text", "text", ""},
+
+		// Enum.
+		{"valid choice", choices.coerceAny, "b", "b", ""},
+		{"invalid choice", choices.coerceAny, "c", nil, "is not one of the valid values"},
+	} {
+		got, err := tc.coerce(tc.value)
+		if tc.wantErr != "" {
+			require.Errorf(t, err, "%s: Coerce(%v)", tc.name, tc.value)
+			assert.Containsf(t, err.Error(), tc.wantErr, "%s: Coerce(%v)", tc.name, tc.value)
+			continue
+		}
+		require.NoErrorf(t, err, "%s: Coerce(%v)", tc.name, tc.value)
+		assert.Equalf(t, tc.want, got, "%s: Coerce(%v)", tc.name, tc.value)
+	}
+
+	// Clamping a slice doesn't change the original.
+	original := []int{-1, 5}
+	_, err := intSlices.Coerce(original)
+	require.NoError(t, err)
+	assert.Equal(t, []int{-1, 5}, original)
+
+	// Values are validated by address, once declared: invalid ones are dropped.
+	value, ok := coerceValue("/test/int", 20.0)
+	assert.True(t, ok)
+	assert.Equal(t, 10, value)
+	_, ok = coerceValue("/test/float", math.NaN())
+	assert.False(t, ok)
+	_, ok = coerceValue("/test/choice", "c")
+	assert.False(t, ok)
+	Undeclare("/test/choice")
+	value, ok = coerceValue("/test/choice", "c")
+	assert.True(t, ok, "values of undeclared addresses are not validated")
+	assert.Equal(t, "c", value)
+}
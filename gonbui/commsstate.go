@@ -0,0 +1,64 @@
+package gonbui
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"sync"
+)
+
+var (
+	muCommsState         sync.Mutex
+	commsStateHandlers   []func(connected bool)
+	commsStateSubscribed bool
+
+	// commsConnected is the last state reported by GoNB, if commsStateKnown.
+	commsConnected, commsStateKnown bool
+
+	// commsStateChanges are delivered to the handlers in a separate goroutine, in order.
+	commsStateChanges = make(chan bool, 16)
+)
+
+// OnCommsStateChange registers handler to be called when the connection with the front-end (the browser
+// displaying the notebook) is established or lost, e.g.: when the page is closed or reloaded, or the network
+// fails.
+//
+// GoNB probes the connection with heartbeats while the program runs, so long-running programs driven by widgets
+// can pause their output (which would be lost) while no one is watching. The handlers are called right away with
+// the current state, and then at every change, in the order they were registered, in a separate goroutine.
+//
+// It does nothing if the program is not executed by GoNB.
+func OnCommsStateChange(handler func(connected bool)) {
+	if !IsNotebook {
+		return
+	}
+	muCommsState.Lock()
+	defer muCommsState.Unlock()
+	commsStateHandlers = append(commsStateHandlers, handler)
+	if commsStateSubscribed {
+		if commsStateKnown {
+			go handler(commsConnected)
+		}
+		return
+	}
+	commsStateSubscribed = true
+	if err := Open(); err != nil {
+		Logf("OnCommsStateChange(): failed to open pipes to GoNB: %+v", err)
+		return
+	}
+	go func() {
+		for connected := range commsStateChanges {
+			muCommsState.Lock()
+			handlers := commsStateHandlers
+			commsConnected, commsStateKnown = connected, true
+			muCommsState.Unlock()
+			Logf("comms state changed: connected=%v, calling %d handlers", connected, len(handlers))
+			for _, handler := range handlers {
+				handler(connected)
+			}
+		}
+	}()
+	SendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMECommSubscribe: &protocol.CommSubscription{Address: protocol.GonbuiCommsStateAddress},
+		},
+	})
+}
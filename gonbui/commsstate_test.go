@@ -0,0 +1,83 @@
+package gonbui
+
+import (
+	"encoding/gob"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// nextState returns the next state delivered to a handler of OnCommsStateChange.
+func nextState(t *testing.T, states chan bool) bool {
+	select {
+	case connected := <-states:
+		return connected
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnCommsStateChange handler not called")
+	}
+	return false
+}
+
+func TestOnCommsStateChange(t *testing.T) {
+	reader := fakePipes(t)
+
+	// Messages from GoNB are written to the other end of the reader pipe.
+	mu.Lock()
+	kernelPipe := gonbWriterPipe
+	gonbDecoder = gob.NewDecoder(gonbReaderPipe)
+	mu.Unlock()
+	polling := make(chan struct{})
+	go func() {
+		pollReaderPipe()
+		close(polling)
+	}()
+	kernel := gob.NewEncoder(kernelPipe)
+	sendState := func(connected bool) {
+		require.NoError(t, kernel.Encode(&protocol.CommValue{Address: protocol.GonbuiCommsStateAddress, Value: connected}))
+	}
+	t.Cleanup(func() {
+		_ = kernelPipe.Close()
+		<-polling
+		muCommsState.Lock()
+		defer muCommsState.Unlock()
+		commsStateHandlers = nil
+		commsStateSubscribed = false
+		commsConnected, commsStateKnown = false, false
+		close(commsStateChanges) // Stops the goroutine calling the handlers.
+		commsStateChanges = make(chan bool, 16)
+	})
+
+	// The first handler subscribes to the connection state.
+	subscribed := make(chan *protocol.DisplayData)
+	go func() {
+		data := &protocol.DisplayData{}
+		assert.NoError(t, gob.NewDecoder(reader).Decode(data))
+		subscribed <- data
+	}()
+	first := make(chan bool, 10)
+	OnCommsStateChange(func(connected bool) { first <- connected })
+	data := <-subscribed
+	require.Contains(t, data.Data, protocol.MIMECommSubscribe)
+	assert.Equal(t, protocol.GonbuiCommsStateAddress, data.Data[protocol.MIMECommSubscribe].(protocol.CommSubscription).Address)
+
+	// Opened, as reported by GoNB when the subscription is received.
+	sendState(true)
+	assert.True(t, nextState(t, first))
+
+	// Handlers registered later are called right away with the current state, without subscribing again.
+	second := make(chan bool, 10)
+	OnCommsStateChange(func(connected bool) { second <- connected })
+	assert.True(t, nextState(t, second))
+
+	// Closed (or heartbeat lost), and opened again: every handler is called, in order.
+	sendState(false)
+	assert.False(t, nextState(t, first))
+	assert.False(t, nextState(t, second))
+	sendState(true)
+	assert.True(t, nextState(t, first))
+	assert.True(t, nextState(t, second))
+	assert.Empty(t, first)
+	assert.Empty(t, second)
+}
@@ -0,0 +1,83 @@
+package gonbui
+
+import (
+	"encoding/json"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+)
+
+// This file implements the display of content with specialized MIME types -- Vega-Lite charts, GeoJSON maps,
+// LaTeX and Mermaid diagrams -- that front-ends with native renderers (e.g.: JupyterLab) pick up.
+// A plain text version is always included, for front-ends that don't support them.
+//
+// Each has a `Display*` version, and an `Update*` version that displays on an output block with the given `id`,
+// created the first time it is used and updated thereafter -- see UpdateHtml.
+//
+// Like the Try* variants (e.g.: TryDisplayHtml), they all return the errors communicating with GoNB, including
+// ErrNotInNotebook when the program is not executed by GoNB and the degraded mode is disabled: in degraded
+// mode the plain text version is written (see SetDegradedOutput).
+
+// displayMIME sends the content with the given MIME type, and the plain text version. If id is not empty, the
+// output block with the given id is updated. It returns the error of TrySendData.
+func displayMIME(id string, mimeType protocol.MIMEType, content any, plain string) error {
+	return TrySendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			mimeType:               content,
+			protocol.MIMETextPlain: plain,
+		},
+		DisplayID: id,
+	})
+}
+
+// displayJSON validates the JSON content and sends it with the given MIME type, see displayMIME.
+func displayJSON(id string, mimeType protocol.MIMEType, contentJSON, plain string) error {
+	if !json.Valid([]byte(contentJSON)) {
+		return errors.Errorf("invalid JSON content for %s", mimeType)
+	}
+	return displayMIME(id, mimeType, json.RawMessage(contentJSON), plain)
+}
+
+// DisplayVegaLite displays the Vega-Lite (https://vega.github.io/vega-lite/) chart with the given specification,
+// in JSON. It also returns an error if the specification is not valid JSON.
+func DisplayVegaLite(specJSON string) error {
+	return displayJSON("", protocol.MIMEVegaLite, specJSON, "<Vega-Lite chart>")
+}
+
+// UpdateVegaLite displays the Vega-Lite chart on the output block with the given `id`, see UpdateHtml.
+// It can be used to update a chart with new data.
+func UpdateVegaLite(id, specJSON string) error {
+	return displayJSON(id, protocol.MIMEVegaLite, specJSON, "<Vega-Lite chart>")
+}
+
+// DisplayGeoJSON displays the GeoJSON (https://geojson.org/) data as a map.
+// It also returns an error if the data is not valid JSON.
+func DisplayGeoJSON(geoJSON string) error {
+	return displayJSON("", protocol.MIMEGeoJSON, geoJSON, "<GeoJSON map>")
+}
+
+// UpdateGeoJSON displays the GeoJSON data on the output block with the given `id`, see UpdateHtml.
+func UpdateGeoJSON(id, geoJSON string) error {
+	return displayJSON(id, protocol.MIMEGeoJSON, geoJSON, "<GeoJSON map>")
+}
+
+// DisplayLatex displays the LaTeX content, usually math formulas delimited by `$` or `$$`, e.g.:
+// `$$f(x) = \int_{-\infty}^{\infty} e^{-x^2} dx$$`.
+func DisplayLatex(latex string) error {
+	return displayMIME("", protocol.MIMETextLatex, latex, latex)
+}
+
+// UpdateLatex displays the LaTeX content on the output block with the given `id`, see UpdateHtml.
+func UpdateLatex(id, latex string) error {
+	return displayMIME(id, protocol.MIMETextLatex, latex, latex)
+}
+
+// DisplayMermaid displays the Mermaid (https://mermaid.js.org/) diagram, given in its text format, e.g.:
+// "graph LR\n  A --> B".
+func DisplayMermaid(diagram string) error {
+	return displayMIME("", protocol.MIMEMermaid, diagram, diagram)
+}
+
+// UpdateMermaid displays the Mermaid diagram on the output block with the given `id`, see UpdateHtml.
+func UpdateMermaid(id, diagram string) error {
+	return displayMIME(id, protocol.MIMEMermaid, diagram, diagram)
+}
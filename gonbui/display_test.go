@@ -0,0 +1,43 @@
+package gonbui
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestDisplayMIMEDegraded(t *testing.T) {
+	require.False(t, IsNotebook, "tests must not be executed by GoNB")
+	SetDegradedOutput(nil)
+	t.Cleanup(func() { SetDegradedOutput(nil) })
+
+	// Degraded mode disabled.
+	assert.ErrorIs(t, DisplayLatex("$x$"), ErrNotInNotebook)
+	assert.ErrorIs(t, UpdateMermaid("id", "graph LR"), ErrNotInNotebook)
+	assert.ErrorIs(t, DisplayVegaLite(`{"mark": "bar"}`), ErrNotInNotebook)
+
+	// Degraded mode: the plain text version is written.
+	var out strings.Builder
+	SetDegradedOutput(&out)
+	require.NoError(t, DisplayLatex("$x$"))
+	require.NoError(t, UpdateLatex("id", "$y$"))
+	require.NoError(t, DisplayMermaid("graph LR"))
+	require.NoError(t, UpdateMermaid("id", "graph TD"))
+	require.NoError(t, DisplayVegaLite(`{"mark": "bar"}`))
+	require.NoError(t, UpdateGeoJSON("id", `{"type": "Point"}`))
+	assert.Equal(t, strings.Join([]string{
+		"[gonbui:text/plain]\n$x$\n",
+		"[gonbui:text/plain]\n$y$\n",
+		"[gonbui:text/plain]\ngraph LR\n",
+		"[gonbui:text/plain]\ngraph TD\n",
+		"[gonbui:text/plain]\n<Vega-Lite chart>\n",
+		"[gonbui:text/plain]\n<GeoJSON map>\n",
+	}, ""), out.String())
+
+	// Invalid JSON is reported before anything is displayed.
+	out.Reset()
+	assert.ErrorContains(t, DisplayVegaLite("{"), "invalid JSON")
+	assert.ErrorContains(t, DisplayGeoJSON("not json"), "invalid JSON")
+	assert.Empty(t, out.String())
+}
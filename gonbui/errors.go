@@ -0,0 +1,123 @@
+package gonbui
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// This file implements the reporting of the errors communicating with GoNB -- the Try* variants of the display
+// functions, and OnError -- and the degraded mode, where the content displayed is written to stdout when the
+// program is not executed by GoNB, so code using gonbui also runs outside the notebook.
+//
+// The interactive content of the sub-packages (gonbui/plots charts and gonbui/tables tables) has no degraded
+// version: outside the notebook their Display methods do nothing and return nil.
+
+// ErrNotInNotebook is returned by the Try* functions (e.g.: TryDisplayHtml) when the program is not executed
+// by GoNB (IsNotebook is false), and the degraded mode is disabled (see SetDegradedOutput).
+var ErrNotInNotebook = errors.New("gonbui: not running in a GoNB notebook")
+
+// DegradedEnv is the environment variable that configures the degraded mode (see SetDegradedOutput) when the
+// program is not executed by GoNB: set it to "stderr" to write the content displayed there instead of stdout, or
+// to "off" to disable it.
+const DegradedEnv = "GONBUI_DEGRADED"
+
+// DegradedMarker prefixes the content written in degraded mode, followed by the MIME type and "]".
+const DegradedMarker = "[gonbui:"
+
+var (
+	muErrors       sync.Mutex
+	onErrorHandler func(err error)
+	degradedOutput io.Writer
+)
+
+func init() {
+	degradedOutput = degradedOutputFromEnv(os.Getenv(DegradedEnv))
+}
+
+// degradedOutputFromEnv returns the output of the degraded mode for the value of DegradedEnv: stdout by default.
+func degradedOutputFromEnv(value string) io.Writer {
+	switch value {
+	case "stderr":
+		return os.Stderr
+	case "off", "none":
+		return nil
+	default:
+		return os.Stdout
+	}
+}
+
+// OnError registers a handler called with the errors communicating with GoNB: e.g.: when the named pipes fail,
+// and each time some content can't be displayed afterward. Set it to nil to remove it.
+//
+// The errors are also returned by the Try* variants of the display functions (e.g.: TryDisplayHtml), and the
+// first one is returned by Error.
+func OnError(handler func(err error)) {
+	muErrors.Lock()
+	defer muErrors.Unlock()
+	onErrorHandler = handler
+}
+
+// reportError calls the handler registered with OnError, if any. It must not be called with `mu` locked.
+func reportError(err error) {
+	muErrors.Lock()
+	handler := onErrorHandler
+	muErrors.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+// SetDegradedOutput sets the output of the degraded mode, used when the program is not executed by GoNB
+// (IsNotebook is false): the content displayed is written to w instead, in a text form, each prefixed by a line
+// with DegradedMarker and its MIME type. E.g.: `[gonbui:text/markdown]`.
+//
+// It is os.Stdout by default (see DegradedEnv). Set it to nil to disable the degraded mode: the Try* functions
+// then return ErrNotInNotebook outside the notebook.
+func SetDegradedOutput(w io.Writer) {
+	muErrors.Lock()
+	defer muErrors.Unlock()
+	degradedOutput = w
+}
+
+// degradedMIMETypes are the MIME types written in degraded mode, in order of preference.
+var degradedMIMETypes = []protocol.MIMEType{protocol.MIMETextPlain, protocol.MIMETextMarkdown, protocol.MIMETextHTML}
+
+// writeDegraded writes the data in degraded mode, or returns ErrNotInNotebook if it is disabled.
+// Messages that are not displayed (e.g.: comms) and content with no text form (e.g.: Javascript) are ignored.
+func writeDegraded(data *protocol.DisplayData) error {
+	muErrors.Lock()
+	defer muErrors.Unlock()
+	if degradedOutput == nil {
+		return ErrNotInNotebook
+	}
+	if !isDisplayData(data) {
+		return nil
+	}
+	var text string
+	var mimeType protocol.MIMEType
+	for _, candidate := range degradedMIMETypes {
+		if content, ok := data.Data[candidate].(string); ok {
+			text, mimeType = content, candidate
+			break
+		}
+	}
+	if mimeType == "" {
+		for _, candidate := range common.SortedKeys(data.Data) {
+			if content, ok := data.Data[candidate].([]byte); ok {
+				// Binary content (e.g.: images) is only summarized.
+				text, mimeType = fmt.Sprintf("<%d bytes>", len(content)), candidate
+				break
+			}
+		}
+	}
+	if mimeType == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(degradedOutput, "%s%s]\n%s\n", DegradedMarker, mimeType, text)
+	return err
+}
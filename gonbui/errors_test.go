@@ -0,0 +1,105 @@
+package gonbui
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestDegradedOutputFromEnv(t *testing.T) {
+	assert.Equal(t, os.Stdout, degradedOutputFromEnv(""), "degraded mode should write to stdout by default")
+	assert.Equal(t, os.Stdout, degradedOutputFromEnv("stdout"))
+	assert.Equal(t, os.Stderr, degradedOutputFromEnv("stderr"))
+	assert.Nil(t, degradedOutputFromEnv("off"))
+	assert.Nil(t, degradedOutputFromEnv("none"))
+}
+
+// failingWriter is an io.Writer that always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestTryDegraded(t *testing.T) {
+	require.False(t, IsNotebook, "tests must not be executed by GoNB")
+	var out strings.Builder
+	SetDegradedOutput(&out)
+	t.Cleanup(func() { SetDegradedOutput(nil) })
+	var reported []error
+	OnError(func(err error) { reported = append(reported, err) })
+	t.Cleanup(func() { OnError(nil) })
+
+	require.NoError(t, TryDisplayHtml("<b>html</b>"))
+	require.NoError(t, TryDisplayMarkdown("# markdown"))
+	require.NoError(t, TryUpdateHtml("id", "<i>updated</i>"))
+	require.NoError(t, TryUpdateMarkdown("id", "*updated*"))
+	require.NoError(t, TryDisplayPng([]byte{1, 2, 3}))
+	// Messages that are not displayed are ignored.
+	require.NoError(t, TrySendData(&protocol.DisplayData{Data: map[protocol.MIMEType]any{
+		protocol.MIMECommValue: &protocol.CommValue{Address: "/x", Value: 1}}}))
+	// Text forms are preferred, in order: plain text, then markdown and then HTML.
+	require.NoError(t, TrySendData(&protocol.DisplayData{Data: map[protocol.MIMEType]any{
+		protocol.MIMETextHTML: "<p>html</p>", protocol.MIMETextMarkdown: "markdown", protocol.MIMETextPlain: "plain"}}))
+	assert.Equal(t, strings.Join([]string{
+		"[gonbui:text/html]\n<b>html</b>\n",
+		"[gonbui:text/markdown]\n# markdown\n",
+		"[gonbui:text/html]\n<i>updated</i>\n",
+		"[gonbui:text/markdown]\n*updated*\n",
+		"[gonbui:image/png]\n<3 bytes>\n",
+		"[gonbui:text/plain]\nplain\n",
+	}, ""), out.String())
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.HasPrefix(line, "[") {
+			assert.True(t, strings.HasPrefix(line, DegradedMarker) && strings.HasSuffix(line, "]"),
+				"marker line %q", line)
+		}
+	}
+	assert.Empty(t, reported)
+
+	// Errors writing the degraded output are returned and reported.
+	SetDegradedOutput(failingWriter{})
+	err := TryDisplayHtml("<b>html</b>")
+	require.Error(t, err)
+	require.Len(t, reported, 1)
+	assert.Equal(t, err, reported[0])
+	DisplayMarkdown("# markdown")
+	assert.Len(t, reported, 2, "SendData errors should also be reported")
+
+	// Degraded mode disabled: ErrNotInNotebook is returned, but it is not reported.
+	SetDegradedOutput(nil)
+	assert.ErrorIs(t, TryDisplayHtml("<b>html</b>"), ErrNotInNotebook)
+	assert.ErrorIs(t, TryUpdateMarkdown("id", "*updated*"), ErrNotInNotebook)
+	assert.Len(t, reported, 2)
+}
+
+func TestTryPipesError(t *testing.T) {
+	require.False(t, IsNotebook, "tests must not be executed by GoNB")
+	t.Setenv(protocol.GONB_PIPE_ENV, path.Join(t.TempDir(), "missing_pipe"))
+	IsNotebook = true
+	t.Cleanup(func() {
+		mu.Lock()
+		IsNotebook = false
+		gonbPipesError = nil
+		mu.Unlock()
+	})
+	var reported []error
+	OnError(func(err error) { reported = append(reported, err) })
+	t.Cleanup(func() { OnError(nil) })
+
+	// Failing to open the pipes is returned by the Try* functions, and reported.
+	err := TryDisplayHtml("<b>html</b>")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed opening pipe")
+	require.Len(t, reported, 1)
+	assert.Equal(t, err, reported[0])
+
+	// Errors are persistent, and reported each time.
+	DisplayMarkdown("# markdown")
+	require.Len(t, reported, 2)
+	assert.Equal(t, err, reported[1])
+	assert.Equal(t, err, TryDisplayPng([]byte{1}))
+}
@@ -0,0 +1,110 @@
+package gonbui
+
+import (
+	"encoding/base64"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// ErrFilePickCancelled is returned by RequestFilePath when the user cancels the selection of the file.
+var ErrFilePickCancelled = errors.New("gonbui: file selection cancelled by the user")
+
+// PickedFile is the file selected by the user with RequestFilePath.
+type PickedFile struct {
+	// Name of the file, without the directory.
+	Name string
+
+	// Path of the file in the local filesystem, if it was picked from the files served by Jupyter.
+	// It is empty if the file was uploaded from the browser.
+	Path string
+
+	// Content of the file, if it was uploaded from the browser.
+	Content []byte
+}
+
+// Uploaded returns whether the file was uploaded from the browser, as opposed to picked from the files served
+// by Jupyter.
+func (f *PickedFile) Uploaded() bool {
+	return f.Path == ""
+}
+
+// ReadAll returns the content of the file, uploaded or read from its Path.
+func (f *PickedFile) ReadAll() ([]byte, error) {
+	if f.Uploaded() {
+		return f.Content, nil
+	}
+	return os.ReadFile(f.Path)
+}
+
+// RequestFilePath displays a file browser in the cell output, and waits for the user to pick a file. It allows
+// notebooks to interactively select their inputs, without hard-coding their paths.
+//
+// The browser lists the files served by Jupyter (with the Jupyter contents API), starting from the directory of
+// the notebook, and only the files matching one of the patterns (e.g.: "*.csv", see `path.Match`), if any are given.
+// If the files can't be listed (e.g.: the front-end is not served by a Jupyter server), or if the user prefers,
+// a file can be uploaded from the browser instead: then its content is returned in PickedFile.Content.
+//
+// It returns ErrFilePickCancelled if the user cancels, ErrInterrupted if the cell is interrupted while waiting,
+// and ErrNotInNotebook if the program is not executed by GoNB.
+func RequestFilePath(prompt string, patterns ...string) (*PickedFile, error) {
+	return RequestFilePathWithTimeout(prompt, 0, patterns...)
+}
+
+// RequestFilePathWithTimeout is like RequestFilePath, but if the user doesn't pick a file within the timeout
+// (if > 0), the file browser is disabled and ErrTimeout is returned.
+func RequestFilePathWithTimeout(prompt string, timeout time.Duration, patterns ...string) (*PickedFile, error) {
+	if !IsNotebook {
+		return nil, ErrNotInNotebook
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "gonbui.RequestFilePath invalid pattern %q", pattern)
+		}
+	}
+	req := &protocol.FileRequest{
+		Address:  "#gonbui/file/" + UniqueId(),
+		Prompt:   prompt,
+		Patterns: patterns,
+	}
+	waiter := newValueWaiter(req.Address, func(value any) bool { return isReply(value, "path", "content", "cancelled") })
+	err := TrySendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMEFileRequest: req},
+	})
+	if err != nil {
+		waiter.release()
+		return nil, err
+	}
+	value, err := waiter.wait(timeout)
+	if err != nil {
+		// Disable the file browser in the front-end, so no file is picked later.
+		closeRequest(req.Address)
+		return nil, err
+	}
+	reply := value.(map[string]any)
+	if cancelled, _ := reply["cancelled"].(bool); cancelled {
+		return nil, ErrFilePickCancelled
+	}
+	if contentsPath, ok := reply["path"].(string); ok {
+		// Paths of the Jupyter contents API are relative to the Jupyter root directory, with "/" separators.
+		jupyterRoot := os.Getenv(protocol.GONB_JUPYTER_ROOT_ENV)
+		if jupyterRoot == "" {
+			return nil, errors.Errorf("gonbui.RequestFilePath: file %q picked, but the Jupyter root directory "+
+				"is not known (%s not set)", contentsPath, protocol.GONB_JUPYTER_ROOT_ENV)
+		}
+		return &PickedFile{
+			Name: path.Base(contentsPath),
+			Path: filepath.Join(jupyterRoot, filepath.FromSlash(contentsPath)),
+		}, nil
+	}
+	name, _ := reply["name"].(string)
+	encoded, _ := reply["content"].(string)
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrapf(err, "gonbui.RequestFilePath: failed to decode content of file %q uploaded", name)
+	}
+	return &PickedFile{Name: name, Content: content}, nil
+}
@@ -0,0 +1,136 @@
+package gonbui
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// FormField is one of the fields requested with RequestForm, see protocol.FormField.
+type FormField = protocol.FormField
+
+// ErrFormCancelled is returned by RequestForm when the user cancels the form.
+var ErrFormCancelled = errors.New("gonbui: form cancelled by the user")
+
+// FormValues are the values entered by the user in a form, by the name of the field, see RequestForm.
+//
+// The values are strings for the text, password and select fields, float64 for the number fields and bool for
+// the checkbox fields. Use the typed accessors to read them.
+type FormValues map[string]any
+
+// String returns the value of the field as a string, or "" if it is not set.
+// Number and checkbox values are converted to their text representation.
+func (v FormValues) String(name string) string {
+	switch value := v[name].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return ""
+}
+
+// Float returns the value of a number field, or 0 if it is not set or not a number.
+func (v FormValues) Float(name string) float64 {
+	switch value := v[name].(type) {
+	case float64:
+		return value
+	case string:
+		f, _ := strconv.ParseFloat(value, 64)
+		return f
+	}
+	return 0
+}
+
+// Int returns the value of a number field truncated to an int, or 0 if it is not set or not a number.
+func (v FormValues) Int(name string) int {
+	return int(v.Float(name))
+}
+
+// Bool returns the value of a checkbox field, or false if it is not set.
+func (v FormValues) Bool(name string) bool {
+	switch value := v[name].(type) {
+	case bool:
+		return value
+	case string:
+		b, _ := strconv.ParseBool(value)
+		return b
+	}
+	return false
+}
+
+// RequestForm displays a form with the given fields in the cell output, and waits for the user to submit it.
+// It replaces a sequence of RequestInput calls, with all the values entered at once, and typed.
+//
+// It returns ErrFormCancelled if the user cancels the form, ErrInterrupted if the cell is interrupted while
+// waiting, and ErrNotInNotebook if the program is not executed by GoNB.
+//
+// Example:
+//
+//	values, err := gonbui.RequestForm("Training", gonbui.FormField{Name: "name", Required: true},
+//		gonbui.FormField{Name: "steps", Type: protocol.FormFieldNumber, Default: "100"},
+//		gonbui.FormField{Name: "optimizer", Type: protocol.FormFieldSelect, Options: []string{"adam", "sgd"}})
+//	if err != nil { ... }
+//	fmt.Printf("Training %s for %d steps with %s\n", values.String("name"), values.Int("steps"),
+//		values.String("optimizer"))
+func RequestForm(title string, fields ...FormField) (FormValues, error) {
+	return RequestFormWithTimeout(title, 0, fields...)
+}
+
+// RequestFormWithTimeout is like RequestForm, but if the user doesn't submit the form within the timeout (if > 0),
+// the form is disabled and ErrTimeout is returned.
+func RequestFormWithTimeout(title string, timeout time.Duration, fields ...FormField) (FormValues, error) {
+	if !IsNotebook {
+		return nil, ErrNotInNotebook
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("gonbui.RequestForm requires at least one field")
+	}
+	names := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if field.Name == "" {
+			return nil, errors.New("gonbui.RequestForm fields must have a Name")
+		}
+		if names[field.Name] {
+			return nil, errors.Errorf("gonbui.RequestForm field %q defined more than once", field.Name)
+		}
+		names[field.Name] = true
+	}
+
+	reply, err := requestForm(&protocol.FormRequest{
+		Title:  title,
+		Fields: fields,
+	}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if cancelled, _ := reply["cancelled"].(bool); cancelled {
+		return nil, ErrFormCancelled
+	}
+	values, _ := reply["values"].(map[string]any)
+	return FormValues(values), nil
+}
+
+// requestForm sends the form request to GoNB, with a new unique address, and waits for the reply of the user.
+func requestForm(req *protocol.FormRequest, timeout time.Duration) (reply map[string]any, err error) {
+	req.Address = "#gonbui/form/" + UniqueId()
+	// Only the replies of the form are accepted: the form itself is closed with a message to the same address.
+	waiter := newValueWaiter(req.Address, func(value any) bool { return isReply(value, "values", "cancelled") })
+	err = TrySendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMEFormRequest: req},
+	})
+	if err != nil {
+		waiter.release()
+		return nil, err
+	}
+	value, err := waiter.wait(timeout)
+	if err != nil {
+		// Disable the form in the front-end, so it is not submitted later.
+		closeRequest(req.Address)
+		return nil, err
+	}
+	return value.(map[string]any), nil
+}
@@ -30,10 +30,12 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 )
 
 func init() {
-	IsNotebook = os.Getenv(protocol.GONB_PIPE_ENV) != ""
+	IsNotebook = os.Getenv(protocol.GONB_PIPE_ENV) != "" || wasmId != ""
+	orderedOutput = IsNotebook && os.Getenv(protocol.GONB_ORDERED_OUTPUT_ENV) != ""
 }
 
 var Debug bool
@@ -59,7 +61,7 @@ var (
 	gonbWriterPipe, gonbReaderPipe *os.File
 	gonbPipesError                 error
 
-	// gonbEncoder encodes messages to GoNB, to gonbWriterPipe.
+	// gonbEncoder encodes messages to GoNB, to be written to gonbWriterPipe, see writer.go.
 	//
 	// The messages are always a protocol.DisplayData object.
 	gonbEncoder *gob.Encoder
@@ -68,6 +70,12 @@ var (
 	//
 	// The messages are always a protocol.CommValue.
 	gonbDecoder *gob.Decoder
+
+	// orderedOutput is set if GoNB reads the stdout of the program, see protocol.DisplayData.Seq.
+	orderedOutput bool
+
+	// lastSeq is the sequence number of the last display data sent, see protocol.DisplayData.Seq.
+	lastSeq int64
 )
 
 // Error returns the error that triggered failure on the communication with GoNB.
@@ -118,7 +126,7 @@ func openLocked() error {
 			closePipesLocked()
 			return gonbPipesError
 		}
-		gonbEncoder = gob.NewEncoder(gonbWriterPipe)
+		startWriterLocked(gonbWriterPipe)
 	}
 	if gonbReaderPipe == nil {
 		gonbReaderPath := os.Getenv(protocol.GONB_PIPE_BACK_ENV)
@@ -130,7 +138,7 @@ func openLocked() error {
 			gonbDecoder = gob.NewDecoder(readerPipe)
 		} else {
 			if gonbPipesError == nil {
-				gonbPipesError = errors.Wrapf(gonbPipesError, "failed opening pipe %q for reading", gonbReaderPath)
+				gonbPipesError = errors.Wrapf(err, "failed opening pipe %q for reading", gonbReaderPath)
 				closePipesLocked()
 			}
 		}
@@ -166,44 +174,136 @@ func closePipesLocked() {
 //
 // But if you are testing new types of MIME types, this is the way to result
 // messages ("execute_result" message type) directly to the front-end.
+//
+// It is safe to call concurrently: the messages are sent in the order of the calls. It returns once the message
+// is written to GoNB, unless SetAsyncDisplay is enabled.
+//
+// Errors are reported to the handler registered with OnError, see TrySendData to get them instead.
 func SendData(data *protocol.DisplayData) {
+	_ = TrySendData(data)
+}
+
+// TrySendData is like SendData, but it returns the error if the data could not be sent (also reported to the
+// handler registered with OnError).
+//
+// If the program is not executed by GoNB, the data is written in degraded mode (see SetDegradedOutput), or
+// ErrNotInNotebook is returned if it is disabled.
+func TrySendData(data *protocol.DisplayData) error {
 	Logf("SendData() sending ...")
+	if !IsNotebook {
+		err := writeDegraded(data)
+		if err != nil && err != ErrNotInNotebook {
+			reportError(err)
+		}
+		return err
+	}
 	mu.Lock()
-	defer mu.Unlock()
+	written, err := sendDataLocked(data)
+	if err == nil && written != nil {
+		mu.Unlock()
+		<-written
+		mu.Lock()
+		err = checkWriteErrorLocked()
+	}
+	mu.Unlock()
+	if err != nil {
+		reportError(err)
+	}
+	return err
+}
+
+// checkWriteErrorLocked returns the error writing to the pipe (see pollWriteQueue), if any, in which case the
+// pipes are closed. It assumes `mu` is locked.
+func checkWriteErrorLocked() error {
+	if err := takeWriteError(); err != nil {
+		gonbPipesError = errors.Wrapf(err, "failed to write to GoNB pipe %q, pipes closed", os.Getenv(protocol.GONB_PIPE_ENV))
+		closePipesLocked()
+		klog.Errorf("%+v", gonbPipesError)
+		return gonbPipesError
+	}
+	return nil
+}
 
-	if err := openLocked(); err != nil {
+// sendDataLocked implements SendData, assuming `mu` is locked. It returns a channel closed once the
+// message is written to GoNB, if it should be waited for.
+func sendDataLocked(data *protocol.DisplayData) (written <-chan struct{}, err error) {
+	data.Version = protocol.Version
+	if wasmId != "" {
+		sendWasmLocked(data)
+		return nil, nil
+	}
+	if err = openLocked(); err != nil {
 		Logf("SendData(): failed, error: %+v", err)
-		return
+		return nil, err
 	}
-	err := gonbEncoder.Encode(data)
+	if err = checkWriteErrorLocked(); err != nil {
+		return nil, err
+	}
+	if orderedOutput && isDisplayData(data) {
+		// Mark the position in the stdout, so GoNB displays the data after what was printed before.
+		lastSeq++
+		data.Seq = lastSeq
+		_, _ = os.Stdout.WriteString(protocol.StdoutMarker(data.Seq))
+	}
+	written, err = queueLocked(data, !asyncDisplay)
 	if err != nil {
-		gonbPipesError = errors.Wrapf(err, "failed to write to GoNB pipe %q, pipes closed", os.Getenv(protocol.GONB_PIPE_ENV))
+		gonbPipesError = errors.Wrapf(err, "failed to encode message to GoNB pipe %q, pipes closed", os.Getenv(protocol.GONB_PIPE_ENV))
 		closePipesLocked()
 		klog.Errorf("%+v", gonbPipesError)
+		return nil, gonbPipesError
 	}
+	return written, nil
+}
+
+// isDisplayData returns whether the data is displayed in the output of the cell, as opposed to the GoNB specific
+// messages (comms, input requests, logs).
+func isDisplayData(data *protocol.DisplayData) bool {
+	for _, mimeType := range []protocol.MIMEType{protocol.MIMEJupyterInput, protocol.MIMECommValue,
+		protocol.MIMECommSubscribe, protocol.MIMELogRecord, protocol.MIMEFormRequest,
+		protocol.MIMEFileRequest} {
+		if _, found := data.Data[mimeType]; found {
+			return false
+		}
+	}
+	return true
 }
 
 // pollReaderPipe loops on reading messages (protocol.CommValue) from gonbReaderPipe and
 // calling comms.DeliverValue, until the pipe is closed.
 func pollReaderPipe() {
 	Logf("pollReaderPipe() started")
+	var dropped bool // Whether the last message was dropped, see protocol.IsUnregisteredTypeError.
 	for gonbReaderPipe != nil {
 		valueMsg := &protocol.CommValue{}
 		err := gonbDecoder.Decode(valueMsg)
 		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) {
 			Logf("pollReaderPipe() closed")
 			return
+		} else if protocol.IsUnregisteredTypeError(err) {
+			log.Printf("Warning: gonbui: message from GoNB dropped, it has a value of a type unknown to this version "+
+				"of gonbui (protocol version %d) -- consider updating it: %v", protocol.Version, err)
+			dropped = true
+			continue
+		} else if dropped && protocol.IsCorruptedError(err) {
+			// Remainder of the message dropped.
+			continue
 		} else if err != nil {
 			Logf("pollReaderPipe() error decoding: %+v", err)
 			mu.Lock()
-			if gonbPipesError == nil {
+			reported := gonbPipesError == nil
+			if reported {
 				gonbPipesError = errors.Wrapf(err, "failed to read from GoNB pipe %q, pipes closed", os.Getenv(protocol.GONB_PIPE_BACK_ENV))
 				closePipesLocked()
 				klog.Errorf("%+v", gonbPipesError)
 			}
+			pipesErr := gonbPipesError
 			mu.Unlock()
+			if reported {
+				reportError(pipesErr)
+			}
 			break
 		}
+		dropped = false
 		Logf("pollReaderPipe() received %+v", valueMsg)
 
 		if valueMsg.Address == protocol.GonbuiSyncAckAddress {
@@ -223,10 +323,23 @@ func pollReaderPipe() {
 			}
 			mu.Unlock()
 
-		} else if OnCommValueUpdate != nil {
+		} else if valueMsg.Address == protocol.GonbuiInterruptAddress {
+			// Cell interrupted, see OnInterrupt.
+			interruptWaiters()
+			go runInterruptHandlers()
+
+		} else if valueMsg.Address == protocol.GonbuiCommsStateAddress {
+			// Connection with the front-end changed, see OnCommsStateChange.
+			connected, _ := valueMsg.Value.(bool)
+			commsStateChanges <- connected
+
+		} else {
 			// Generic Comms update.
-			Logf("dispatching OnCommValueUpdate(%q)", valueMsg.Address)
-			OnCommValueUpdate(valueMsg)
+			deliverToWaiters(valueMsg)
+			if OnCommValueUpdate != nil {
+				Logf("dispatching OnCommValueUpdate(%q)", valueMsg.Address)
+				OnCommValueUpdate(valueMsg)
+			}
 		}
 
 		Logf("pollReaderPipe() delivered to %q", valueMsg.Address)
@@ -287,10 +400,12 @@ func UniqueID() string {
 
 // DisplayHtml will display the given HTML in the notebook, as the output of the cell being executed.
 func DisplayHtml(html string) {
-	if !IsNotebook {
-		return
-	}
-	SendData(&protocol.DisplayData{
+	_ = TryDisplayHtml(html)
+}
+
+// TryDisplayHtml is like DisplayHtml, but it returns the error if the content could not be displayed, see TrySendData.
+func TryDisplayHtml(html string) error {
+	return TrySendData(&protocol.DisplayData{
 		Data: map[protocol.MIMEType]any{protocol.MIMETextHTML: html},
 	})
 }
@@ -303,9 +418,6 @@ func DisplayHTML(html string) {
 // DisplayHtmlf is similar to DisplayHtml, but it takes a format string and its args which
 // are passed to fmt.Sprintf.
 func DisplayHtmlf(htmlFormat string, args ...any) {
-	if !IsNotebook {
-		return
-	}
 	html := fmt.Sprintf(htmlFormat, args...)
 	DisplayHtml(html)
 }
@@ -316,10 +428,13 @@ func DisplayHtmlf(htmlFormat string, args ...any) {
 // double "$" for formulas in a separate line -- e.g.:
 // `$$f(x) = \int_{-\infty}^{\infty} e^{-x^2} dx$$`.
 func DisplayMarkdown(markdown string) {
-	if !IsNotebook {
-		return
-	}
-	SendData(&protocol.DisplayData{
+	_ = TryDisplayMarkdown(markdown)
+}
+
+// TryDisplayMarkdown is like DisplayMarkdown, but it returns the error if the content could not be displayed,
+// see TrySendData.
+func TryDisplayMarkdown(markdown string) error {
+	return TrySendData(&protocol.DisplayData{
 		Data: map[protocol.MIMEType]any{protocol.MIMETextMarkdown: markdown},
 	})
 }
@@ -344,10 +459,12 @@ func DisplayMarkdown(markdown string) {
 // If you want a `<div>` that you can manipulate with the [dom] package, create an empty `<div id=%q></div>`
 // with another unique id (see [gonbui.UniqueID]) and use that instead.
 func UpdateHtml(id, html string) {
-	if !IsNotebook {
-		return
-	}
-	SendData(&protocol.DisplayData{
+	_ = TryUpdateHtml(id, html)
+}
+
+// TryUpdateHtml is like UpdateHtml, but it returns the error if the content could not be displayed, see TrySendData.
+func TryUpdateHtml(id, html string) error {
+	return TrySendData(&protocol.DisplayData{
 		Data:      map[protocol.MIMEType]any{protocol.MIMETextHTML: html},
 		DisplayID: id,
 	})
@@ -368,10 +485,13 @@ func UpdateHTML(id, html string) {
 //
 // See example in UpdateHtml, just instead this used Markdown content.
 func UpdateMarkdown(id, markdown string) {
-	if !IsNotebook {
-		return
-	}
-	SendData(&protocol.DisplayData{
+	_ = TryUpdateMarkdown(id, markdown)
+}
+
+// TryUpdateMarkdown is like UpdateMarkdown, but it returns the error if the content could not be displayed,
+// see TrySendData.
+func TryUpdateMarkdown(id, markdown string) error {
+	return TrySendData(&protocol.DisplayData{
 		Data:      map[protocol.MIMEType]any{protocol.MIMETextMarkdown: markdown},
 		DisplayID: id,
 	})
@@ -379,10 +499,12 @@ func UpdateMarkdown(id, markdown string) {
 
 // DisplayPng displays the given PNG, given as raw bytes.
 func DisplayPng(png []byte) {
-	if !IsNotebook {
-		return
-	}
-	SendData(&protocol.DisplayData{
+	_ = TryDisplayPng(png)
+}
+
+// TryDisplayPng is like DisplayPng, but it returns the error if the image could not be displayed, see TrySendData.
+func TryDisplayPng(png []byte) error {
+	return TrySendData(&protocol.DisplayData{
 		Data: map[protocol.MIMEType]any{protocol.MIMEImagePNG: png},
 	})
 }
@@ -394,7 +516,7 @@ func DisplayPNG(png []byte) {
 }
 
 // DisplayImage displays the given image, by converting it to PNG first.
-// It returns an error if it fails to encode to the image to PNG.
+// It returns an error if it fails to encode to the image to PNG -- see TryDisplayPng for the errors displaying it.
 func DisplayImage(image image.Image) error {
 	buf := bytes.NewBuffer(nil)
 	err := png.Encode(buf, image)
@@ -406,9 +528,6 @@ func DisplayImage(image image.Image) error {
 }
 
 func DisplaySvg(svg string) {
-	if !IsNotebook {
-		return
-	}
 	// This should be the implementation, but Jupyter doesn't handle well SVG data
 	// when the notebook is converted to HTML.
 	// So we try a simple workaround of embedding the SVG as HTML.
@@ -436,12 +555,46 @@ func DisplaySVG(svg string) {
 //   - prompt: string displayed in front of the field to be entered. Leave empty ("") if not needed.
 //   - password: if whatever the user is typing is not to be displayed.
 func RequestInput(prompt string, password bool) {
+	RequestInputWithTimeout(prompt, password, 0, "")
+}
+
+// RequestInputWithTimeout is like RequestInput, but if the user doesn't answer within the timeout, the prompt is
+// cancelled, and defaultValue (followed by a new line) is written to the stdin of the program instead.
+// This way the program doesn't hang if no one is there to answer (e.g.: notebooks executed headless).
+//
+// If timeout is 0, the timeout configured in the kernel with `%config input.timeout` is used (by default none).
+func RequestInputWithTimeout(prompt string, password bool, timeout time.Duration, defaultValue string) {
 	if !IsNotebook {
 		return
 	}
 	req := protocol.InputRequest{
 		Prompt:   prompt,
 		Password: password,
+		Timeout:  timeout,
+		Default:  defaultValue,
+	}
+	SendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMEJupyterInput: &req,
+		},
+	})
+}
+
+// RequestSecret requests the secret with the given name (e.g.: an API token), which is then written to the stdin
+// of the cell program, like with RequestInput.
+//
+// The secret is first looked up in the secrets of the kernel session: only if it is not there the user is prompted
+// for it (with the input masked, as a password), and the value entered is kept for the rest of the session -- so
+// other cells requesting it don't prompt again. Secrets are kept in memory only, see `%secrets` to manage them.
+func RequestSecret(name, prompt string) {
+	if !IsNotebook {
+		return
+	}
+	req := protocol.InputRequest{
+		Prompt:        prompt,
+		Password:      true,
+		SecretName:    name,
+		PersistSecret: true,
 	}
 	SendData(&protocol.DisplayData{
 		Data: map[protocol.MIMEType]any{
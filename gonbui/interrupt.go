@@ -0,0 +1,63 @@
+package gonbui
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+var (
+	muInterrupt        sync.Mutex
+	interruptHandlers  []func()
+	interruptListening bool
+	interruptOnce      sync.Once
+)
+
+// OnInterrupt registers handler to be called when the cell is interrupted (e.g.: with the "Interrupt the kernel"
+// button in the notebook), so long-running programs can checkpoint their work and exit cleanly.
+//
+// Once a handler is registered, the program no longer exits immediately when interrupted: it is up to the
+// handlers (or the rest of the program) to stop it. GoNB waits for a grace period (see `%config interrupt.grace`,
+// 5 seconds by default), after which the program is killed.
+//
+// The handlers are called only once, in a separate goroutine, in the order they were registered: further
+// interruptions are ignored. It works also when the program is not executed by GoNB: then the handlers are
+// called when the program receives a SIGINT.
+func OnInterrupt(handler func()) {
+	muInterrupt.Lock()
+	defer muInterrupt.Unlock()
+	interruptHandlers = append(interruptHandlers, handler)
+	if interruptListening {
+		return
+	}
+	interruptListening = true
+	interruptSignals := make(chan os.Signal, 1)
+	signal.Notify(interruptSignals, os.Interrupt)
+	go func() {
+		for range interruptSignals {
+			runInterruptHandlers()
+		}
+	}()
+	if IsNotebook {
+		// GoNB also notifies the interruption through the pipe, which is only read once it is opened.
+		if err := Open(); err != nil {
+			Logf("OnInterrupt(): failed to open pipes to GoNB: %+v", err)
+		}
+	}
+}
+
+// runInterruptHandlers calls the handlers registered with OnInterrupt, only the first time it is called.
+//
+// GoNB notifies the interruption both through the pipe and with a SIGINT, so later calls are ignored.
+func runInterruptHandlers() {
+	interruptOnce.Do(func() {
+		interruptWaiters()
+		muInterrupt.Lock()
+		handlers := interruptHandlers
+		muInterrupt.Unlock()
+		Logf("interrupted: calling %d handlers", len(handlers))
+		for _, handler := range handlers {
+			handler()
+		}
+	})
+}
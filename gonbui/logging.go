@@ -0,0 +1,113 @@
+package gonbui
+
+import (
+	"context"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"golang.org/x/exp/slices"
+	"log/slog"
+	"os"
+)
+
+// LogHandler returns a `log/slog` handler that sends the log records to GoNB: they are shown in the log viewer
+// of the notebook (see `%logs`), or in the cell output (stderr) if no log viewer is open.
+//
+// If `opts` is nil, the default options are used (level info and above). If not running in a notebook
+// (IsNotebook is false), it returns a text handler writing to stderr.
+//
+// Example:
+//
+//	logger := slog.New(gonbui.LogHandler(&slog.HandlerOptions{Level: slog.LevelDebug}))
+//	logger.Debug("loading", "file", filePath)
+func LogHandler(opts *slog.HandlerOptions) slog.Handler {
+	if !IsNotebook {
+		return slog.NewTextHandler(os.Stderr, opts)
+	}
+	h := &logHandler{}
+	if opts != nil {
+		h.level = opts.Level
+	}
+	return h
+}
+
+// Logger returns a `*slog.Logger` using LogHandler with the default options.
+func Logger() *slog.Logger {
+	return slog.New(LogHandler(nil))
+}
+
+// logHandler implements slog.Handler.
+type logHandler struct {
+	level slog.Leveler
+
+	// attrs already formatted, set with WithAttrs.
+	attrs []string
+
+	// groupPrefix is prepended to the keys of the attributes, set with WithGroup.
+	groupPrefix string
+}
+
+// Enabled implements slog.Handler.
+func (h *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.level != nil {
+		minLevel = h.level.Level()
+	}
+	return level >= minLevel
+}
+
+// Handle implements slog.Handler.
+func (h *logHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(attr slog.Attr) bool {
+		attrs = appendLogAttr(attrs, h.groupPrefix, attr)
+		return true
+	})
+	SendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMELogRecord: &protocol.LogRecord{
+				Time:    r.Time,
+				Level:   int(r.Level),
+				Message: r.Message,
+				Attrs:   attrs,
+			},
+		},
+	})
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clone(h.attrs)
+	for _, attr := range attrs {
+		h2.attrs = appendLogAttr(h2.attrs, h.groupPrefix, attr)
+	}
+	return &h2
+}
+
+// WithGroup implements slog.Handler.
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groupPrefix = h.groupPrefix + name + "."
+	return &h2
+}
+
+// appendLogAttr formats the attribute as `key=value`, recursively for groups.
+func appendLogAttr(attrs []string, prefix string, attr slog.Attr) []string {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return attrs
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			attrs = appendLogAttr(attrs, prefix, groupAttr)
+		}
+		return attrs
+	}
+	return append(attrs, prefix+attr.Key+"="+attr.Value.String())
+}
@@ -0,0 +1,67 @@
+package gonbui
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"time"
+)
+
+// Confirm displays the message in the cell output, with "OK" and "Cancel" buttons, and waits for the user to
+// choose one. It returns true only if the user confirms: e.g.: before a destructive step of a long-running cell.
+//
+// It returns false if the user cancels, if the cell is interrupted while waiting, or if the program is not
+// executed by GoNB. See ConfirmWithTimeout to distinguish these cases.
+func Confirm(msg string) bool {
+	confirmed, _ := ConfirmWithTimeout(msg, 0)
+	return confirmed
+}
+
+// ConfirmWithTimeout is like Confirm, but if the user doesn't answer within the timeout (if > 0), the buttons are
+// disabled and ErrTimeout is returned. A cancellation by the user is not an error: it returns false and nil.
+func ConfirmWithTimeout(msg string, timeout time.Duration) (bool, error) {
+	if !IsNotebook {
+		return false, ErrNotInNotebook
+	}
+	// A form with no fields.
+	reply, err := requestForm(&protocol.FormRequest{
+		Title:       msg,
+		SubmitLabel: "OK",
+	}, timeout)
+	if err != nil {
+		return false, err
+	}
+	_, confirmed := reply["values"]
+	return confirmed, nil
+}
+
+// ToastLevel is the level of a toast notification, which defines its color and for how long it is displayed.
+type ToastLevel string
+
+const (
+	ToastInfo    ToastLevel = "info"
+	ToastSuccess ToastLevel = "success"
+	ToastWarning ToastLevel = "warning"
+	ToastError   ToastLevel = "error"
+)
+
+// Toast displays a non-blocking notification in the corner of the notebook page, which disappears after a few
+// seconds (or when clicked) -- e.g.: to tell the user a long-running step finished, without cluttering the cell
+// output.
+//
+// It is sent through comms, so it also works while the program waits for other inputs. Outside the notebook,
+// it is written in degraded mode, if enabled (see SetDegradedOutput).
+func Toast(level ToastLevel, msg string) {
+	if !IsNotebook {
+		_ = writeDegraded(&protocol.DisplayData{
+			Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: fmt.Sprintf("%s: %s", level, msg)},
+		})
+		return
+	}
+	SendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMECommValue: &protocol.CommValue{
+				Address: protocol.GonbuiToastAddress,
+				Value:   map[string]any{"level": string(level), "message": msg},
+			}},
+	})
+}
@@ -0,0 +1,363 @@
+// Package plots implements interactive charts (lines, scatter plots and histograms) in the notebook,
+// rendered with the Plotly Javascript library (https://plotly.com/javascript/).
+//
+// Charts are created by one of the constructors (Line, Scatter, Histogram), configured with the
+// `With*` and `Add*` methods, and displayed with Display. Example:
+//
+//	x := []float64{1, 2, 3, 4}
+//	y := []float64{1, 4, 9, 16}
+//	err := plots.Line(x, y).WithTitle("Squares").WithXLabel("x").Display()
+//
+// Charts created with Live can be updated after they are displayed: Update streams new points into the
+// chart in the front-end, using the communication channel of the `gonbui/comms` package. This allows one to,
+// e.g., plot the loss of a model while it is being trained.
+//
+// Charts are published as a MIME bundle with the HTML (and Javascript) that renders the chart, a plain text
+// description, and, except for Live charts, the Plotly JSON specification of the chart (MIME type
+// `application/vnd.plotly.v1+json`), for front-ends that render it natively.
+package plots
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/plotly"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"strings"
+	"text/template"
+)
+
+// MIMEPlotly is the MIME type of Plotly JSON specifications of charts.
+const MIMEPlotly protocol.MIMEType = "application/vnd.plotly.v1+json"
+
+//go:embed plots.js
+var plotsJs []byte
+
+var tmplPlotsJs = template.Must(template.New("plotsJs").Parse(
+	string(plotsJs)))
+
+// Trace is one series of data in a chart, in the format of Plotly traces.
+type Trace struct {
+	// Type of the trace: "scatter" (used for lines too) or "histogram".
+	Type string `json:"type"`
+
+	// Mode of "scatter" traces: "lines", "markers" or "lines+markers".
+	Mode string `json:"mode,omitempty"`
+
+	// Name of the trace, used in the legend.
+	Name string `json:"name,omitempty"`
+
+	X []float64 `json:"x"`
+	Y []float64 `json:"y,omitempty"`
+
+	// NBinsX is the maximum number of bins of "histogram" traces. If 0 Plotly picks it automatically.
+	NBinsX int `json:"nbinsx,omitempty"`
+}
+
+// Chart holds the traces and the layout of a chart. Create it with Line, Scatter or Histogram.
+type Chart struct {
+	// Traces of the chart. They can be modified before the chart is displayed.
+	Traces []*Trace
+
+	// Layout of the chart, see https://plotly.com/javascript/reference/layout/.
+	// They can be modified before the chart is displayed.
+	Layout map[string]any
+
+	htmlId, address string
+	live, displayed bool
+	maxPoints       int
+
+	// ready is triggered when the front-end acknowledges a Live chart is ready to receive updates.
+	ready *common.Latch
+}
+
+// Line creates a chart with a line connecting the points given by `x` and `y`.
+func Line(x, y []float64) *Chart {
+	return newChart().AddLine(x, y)
+}
+
+// Scatter creates a chart with markers at the points given by `x` and `y`.
+func Scatter(x, y []float64) *Chart {
+	return newChart().AddScatter(x, y)
+}
+
+// Histogram creates a chart with the histogram of the given values.
+func Histogram(values []float64) *Chart {
+	return newChart().AddHistogram(values)
+}
+
+func newChart() *Chart {
+	return &Chart{
+		Layout:  make(map[string]any),
+		htmlId:  "gonb_plot_" + gonbui.UniqueId(),
+		address: "/plots/" + gonbui.UniqueId(),
+		ready:   common.NewLatch(),
+	}
+}
+
+// checkNotDisplayed panics if the chart was already displayed.
+func (c *Chart) checkNotDisplayed() {
+	if c.displayed {
+		panicf("plots.Chart cannot change parameters after it is displayed")
+	}
+}
+
+// AddLine adds a line connecting the points given by `x` and `y` to the chart.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) AddLine(x, y []float64) *Chart {
+	c.checkNotDisplayed()
+	c.Traces = append(c.Traces, &Trace{Type: "scatter", Mode: "lines", X: x, Y: y})
+	return c
+}
+
+// AddScatter adds markers at the points given by `x` and `y` to the chart.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) AddScatter(x, y []float64) *Chart {
+	c.checkNotDisplayed()
+	c.Traces = append(c.Traces, &Trace{Type: "scatter", Mode: "markers", X: x, Y: y})
+	return c
+}
+
+// AddHistogram adds the histogram of the given values to the chart.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) AddHistogram(values []float64) *Chart {
+	c.checkNotDisplayed()
+	c.Traces = append(c.Traces, &Trace{Type: "histogram", X: values})
+	return c
+}
+
+// WithName sets the name of the last trace added, used in the legend.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) WithName(name string) *Chart {
+	c.checkNotDisplayed()
+	if len(c.Traces) > 0 {
+		c.Traces[len(c.Traces)-1].Name = name
+	}
+	return c
+}
+
+// WithBins sets the maximum number of bins of the last trace added, if it is a histogram.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) WithBins(n int) *Chart {
+	c.checkNotDisplayed()
+	if len(c.Traces) > 0 {
+		c.Traces[len(c.Traces)-1].NBinsX = n
+	}
+	return c
+}
+
+// WithTitle sets the title of the chart.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) WithTitle(title string) *Chart {
+	c.checkNotDisplayed()
+	c.Layout["title"] = map[string]any{"text": title}
+	return c
+}
+
+// WithXLabel sets the title of the horizontal axis.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) WithXLabel(label string) *Chart {
+	c.checkNotDisplayed()
+	c.Layout["xaxis"] = map[string]any{"title": map[string]any{"text": label}}
+	return c
+}
+
+// WithYLabel sets the title of the vertical axis.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) WithYLabel(label string) *Chart {
+	c.checkNotDisplayed()
+	c.Layout["yaxis"] = map[string]any{"title": map[string]any{"text": label}}
+	return c
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) WithHtmlId(htmlId string) *Chart {
+	c.checkNotDisplayed()
+	c.htmlId = htmlId
+	return c
+}
+
+// Live configures the chart to accept updates (see Update) after it is displayed.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) Live() *Chart {
+	c.checkNotDisplayed()
+	c.live = true
+	return c
+}
+
+// WithMaxPoints sets the maximum number of points kept in each trace of a Live chart: when updates go over
+// the limit, the oldest points are dropped. If 0 (the default) all points are kept.
+//
+// It panics if called after the chart is displayed.
+func (c *Chart) WithMaxPoints(n int) *Chart {
+	c.checkNotDisplayed()
+	c.maxPoints = n
+	return c
+}
+
+// HtmlId returns the `id` used in the HTML element of the chart.
+func (c *Chart) HtmlId() string {
+	return c.htmlId
+}
+
+// Spec returns the Plotly JSON specification of the chart: `{"data": <traces>, "layout": <layout>}`.
+func (c *Chart) Spec() ([]byte, error) {
+	spec, err := json.Marshal(map[string]any{
+		"data":   c.Traces,
+		"layout": c.Layout,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal chart to Plotly JSON")
+	}
+	return spec, nil
+}
+
+// String returns a plain text description of the chart.
+func (c *Chart) String() string {
+	parts := make([]string, 0, len(c.Traces))
+	for _, trace := range c.Traces {
+		kind := trace.Type
+		if kind == "scatter" {
+			kind = trace.Mode
+		}
+		desc := fmt.Sprintf("%s with %d points", kind, len(trace.X))
+		if trace.Name != "" {
+			desc = fmt.Sprintf("%q: %s", trace.Name, desc)
+		}
+		parts = append(parts, desc)
+	}
+	return fmt.Sprintf("Chart(%s)", strings.Join(parts, ", "))
+}
+
+// Display the chart as the output of the cell being executed.
+//
+// After this is called, the chart can no longer be configured, and, if it is Live, it can be updated
+// with Update.
+//
+// Outside the notebook (gonbui.IsNotebook is false) it does nothing and returns nil: charts have no degraded
+// version (see gonbui.SetDegradedOutput).
+func (c *Chart) Display() error {
+	if c.displayed {
+		return errors.Errorf("plots.Chart.Display already called")
+	}
+	c.displayed = true
+	if !gonbui.IsNotebook {
+		return nil
+	}
+	spec, err := c.Spec()
+	if err != nil {
+		return err
+	}
+	if c.live {
+		// Record the acknowledgement that the chart is ready for updates.
+		readyChan := comms.Listen[int](c.address + "/ready")
+		go func() {
+			for range readyChan.C {
+				c.ready.Trigger()
+			}
+		}()
+		comms.Start()
+	}
+
+	var buf bytes.Buffer
+	data := struct {
+		Src, Spec, HtmlId, Address string
+		Live                       bool
+	}{
+		Src:     plotly.PlotlySrc,
+		Spec:    string(spec),
+		HtmlId:  c.htmlId,
+		Address: c.address,
+		Live:    c.live,
+	}
+	err = tmplPlotsJs.Execute(&buf, data)
+	if err != nil {
+		panicf("Plots template is invalid!? Please report the error to GoNB: %v", err)
+	}
+	mimeData := map[protocol.MIMEType]any{
+		protocol.MIMETextHTML:  fmt.Sprintf("<div id=%q></div>\n<script charset=\"UTF-8\">\n%s</script>", c.htmlId, buf.String()),
+		protocol.MIMETextPlain: c.String(),
+	}
+	if !c.live {
+		// Front-ends rendering the Plotly JSON natively don't run the Javascript that receives updates,
+		// so it is only included for static charts.
+		mimeData[MIMEPlotly] = json.RawMessage(spec)
+	}
+	gonbui.SendData(&protocol.DisplayData{Data: mimeData})
+	return gonbui.Error()
+}
+
+// Update appends the new points given by `x` and `y` to the first trace of a Live chart, in the front-end.
+// For histograms, `x` holds the new values and `y` is ignored.
+//
+// It waits for the chart to be ready in the front-end, and it returns an error if the chart is not Live
+// or has not been displayed.
+func (c *Chart) Update(x, y []float64) error {
+	return c.UpdateTrace(0, x, y)
+}
+
+// UpdateTrace appends the new points given by `x` and `y` to the trace with the given index (in the order
+// they were added to the chart) of a Live chart, in the front-end.
+// For histograms, `x` holds the new values and `y` is ignored.
+//
+// It waits for the chart to be ready in the front-end, and it returns an error if the chart is not Live
+// or has not been displayed.
+func (c *Chart) UpdateTrace(trace int, x, y []float64) error {
+	if !c.live || !c.displayed {
+		return errors.Errorf("plots.Chart.Update requires a chart configured with Live and displayed")
+	}
+	updateJson, err := c.updateJson(trace, x, y)
+	if err != nil {
+		return err
+	}
+	if !gonbui.IsNotebook {
+		return nil
+	}
+	c.ready.Wait()
+	comms.Send(c.address, updateJson)
+	return gonbui.Error()
+}
+
+// updateJson returns the message sent to the front-end to append the points to the trace, JSON encoded.
+func (c *Chart) updateJson(trace int, x, y []float64) (string, error) {
+	if trace < 0 || trace >= len(c.Traces) {
+		return "", errors.Errorf("plots.Chart.UpdateTrace(%d): chart only has %d traces", trace, len(c.Traces))
+	}
+	t := c.Traces[trace]
+	update := map[string]any{"trace": trace, "x": x}
+	if t.Type != "histogram" {
+		if len(x) != len(y) {
+			return "", errors.Errorf("plots.Chart.UpdateTrace(%d): x and y have different lengths (%d and %d)",
+				trace, len(x), len(y))
+		}
+		update["y"] = y
+	}
+	if c.maxPoints > 0 {
+		update["max_points"] = c.maxPoints
+	}
+	updateJson, err := json.Marshal(update)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to marshal chart update")
+	}
+	return string(updateJson), nil
+}
+
+// panicf is an alias for common.Panicf.
+var panicf = common.Panicf
@@ -0,0 +1,53 @@
+(() => {
+    const src = "{{.Src}}";
+    const spec = {{.Spec}};
+    const div = document.getElementById("{{.HtmlId}}");
+
+    function plot(Plotly) {
+        Plotly.newPlot(div, spec.data, spec.layout);
+{{if .Live}}
+        let gonb_comm = globalThis?.gonb_comm;
+        if (!gonb_comm) {
+            console.error("Communication to GoNB not setup, chart will not receive updates from program.")
+            return;
+        }
+        gonb_comm.subscribe("{{.Address}}", (address, value) => {
+            // Each update holds the new points of one trace, see Chart.UpdateTrace.
+            const update = JSON.parse(value);
+            let data = {x: [update.x]};
+            if (update.y) {
+                data.y = [update.y];
+            }
+            Plotly.extendTraces(div, data, [update.trace], update.max_points || undefined);
+        });
+        gonb_comm.send("{{.Address}}/ready", 1);
+{{end}}
+    }
+
+    if (globalThis.Plotly) {
+        plot(globalThis.Plotly);
+        return;
+    }
+    if (typeof requirejs === "function") {
+        // Use RequireJS to load module.
+        requirejs.config({paths: {plotly: src.replace(/\.js$/, "")}});
+        require(["plotly"], plot);
+        return;
+    }
+    for (const script of document.head.getElementsByTagName("script")) {
+        if (script.src === src) {
+            if (globalThis.Plotly) {
+                plot(globalThis.Plotly);
+            } else {
+                // Still loading.
+                script.addEventListener("load", () => plot(globalThis.Plotly));
+            }
+            return;
+        }
+    }
+    let script = document.createElement("script");
+    script.charset = "utf-8";
+    script.src = src;
+    script.onload = () => plot(globalThis.Plotly);
+    document.head.appendChild(script);
+})();
@@ -0,0 +1,69 @@
+package plots
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestSpec(t *testing.T) {
+	chart := Line([]float64{1, 2}, []float64{1, 4}).WithName("squares").
+		AddScatter([]float64{3}, []float64{9}).
+		AddHistogram([]float64{0.5, 1.5}).WithBins(10).
+		WithTitle("Squares").WithXLabel("x").WithYLabel("y")
+	spec, err := chart.Spec()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"data": [
+			{"type": "scatter", "mode": "lines", "name": "squares", "x": [1, 2], "y": [1, 4]},
+			{"type": "scatter", "mode": "markers", "x": [3], "y": [9]},
+			{"type": "histogram", "x": [0.5, 1.5], "nbinsx": 10}
+		],
+		"layout": {
+			"title": {"text": "Squares"},
+			"xaxis": {"title": {"text": "x"}},
+			"yaxis": {"title": {"text": "y"}}
+		}
+	}`, string(spec))
+	assert.Equal(t, `Chart("squares": lines with 2 points, markers with 1 points, histogram with 2 points)`, chart.String())
+
+	// Values that can't be represented in JSON.
+	_, err = Line([]float64{math.NaN()}, []float64{1}).Spec()
+	assert.Error(t, err)
+
+	// Configuration after the chart is displayed panics.
+	chart = Line(nil, nil)
+	require.NoError(t, chart.Display())
+	assert.Panics(t, func() { chart.WithTitle("late") })
+	assert.Error(t, chart.Display(), "displaying twice is an error")
+}
+
+func TestUpdate(t *testing.T) {
+	chart := Line(nil, nil).AddHistogram(nil).Live().WithMaxPoints(100)
+	update, err := chart.updateJson(0, []float64{1, 2}, []float64{3, 4})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"trace": 0, "x": [1, 2], "y": [3, 4], "max_points": 100}`, update)
+	update, err = chart.updateJson(1, []float64{0.5}, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"trace": 1, "x": [0.5], "max_points": 100}`, update, "histograms only have x")
+
+	_, err = chart.updateJson(2, nil, nil)
+	assert.ErrorContains(t, err, "chart only has 2 traces")
+	_, err = chart.updateJson(0, []float64{1}, nil)
+	assert.ErrorContains(t, err, "different lengths")
+	_, err = chart.updateJson(0, []float64{math.Inf(1)}, []float64{1})
+	assert.ErrorContains(t, err, "failed to marshal")
+
+	// Without max points, all are kept.
+	update, err = Scatter(nil, nil).updateJson(0, []float64{1}, []float64{2})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"trace": 0, "x": [1], "y": [2]}`, update)
+
+	// Only live charts, once displayed, can be updated.
+	assert.Error(t, Line(nil, nil).Update([]float64{1}, []float64{2}))
+	assert.Error(t, chart.Update([]float64{1}, []float64{2}), "not displayed yet")
+	require.NoError(t, chart.Display())
+	assert.NoError(t, chart.Update([]float64{1}, []float64{2}), "outside the notebook it does nothing")
+	assert.Error(t, chart.UpdateTrace(0, []float64{1}, nil), "invalid updates are reported outside the notebook")
+}
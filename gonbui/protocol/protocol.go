@@ -2,7 +2,13 @@
 // kernel, using the standard Go `encoding/gob` package.
 package protocol
 
-import "encoding/gob"
+import (
+	"encoding/gob"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
 
 const (
 	// GONB_PIPE_ENV is the name of the environment variable holding
@@ -49,6 +55,19 @@ const (
 	// If it's not set, GoNB was not able to parse it from the kernel file path.
 	GONB_JUPYTER_KERNEL_ID_ENV = "GONB_JUPYTER_KERNEL_ID"
 
+	// GONB_EXECUTION_COUNT_ENV is the environment variable with the execution count of the cell being executed,
+	// as shown in the notebook (e.g.: `[12]`).
+	// See also `gonbui.CellInfo`.
+	GONB_EXECUTION_COUNT_ENV = "GONB_EXECUTION_COUNT"
+
+	// GONB_CELL_ID_ENV is the environment variable with the id of the notebook cell being executed, if provided
+	// by the front-end (JupyterLab does).
+	GONB_CELL_ID_ENV = "GONB_CELL_ID"
+
+	// GONB_JUPYTER_SESSION_ENV is the environment variable with the Jupyter session of the client (front-end)
+	// that requested the execution.
+	GONB_JUPYTER_SESSION_ENV = "GONB_JUPYTER_SESSION"
+
 	// GONB_WASM_DIR_ENV is the temporary directory created in "${GONB_JUPYTER_ROOT}/.jupyter_files/<session_id>/wasm/"
 	// where the generated `.wasm` file is stored when using `%wasm`.
 	// It is set/updated everytime `%wasm` is first used.
@@ -68,8 +87,29 @@ const (
 	// Notice that the Wasm program gets this value from a global variable automatically introduced in the Go code,
 	// see `%help`.
 	GONB_WASM_URL_ENV = "GONB_WASM_URL"
+
+	// GONB_WASM_ID_ENV is set in the environment of programs compiled with `%wasm`, running in the browser, with
+	// the id of their cell. `gonbui` uses it to relay the display data to the cell output, see GonbuiWasmOutputAddress.
+	GONB_WASM_ID_ENV = "GONB_WASM_ID"
+
+	// GONB_ORDERED_OUTPUT_ENV is set by GoNB (to "1") when the stdout of the program is read by the kernel, which
+	// then keeps the order of the display data relative to what is printed. See DisplayData.Seq.
+	GONB_ORDERED_OUTPUT_ENV = "GONB_ORDERED_OUTPUT"
 )
 
+// StdoutMarkerPrefix and StdoutMarkerSuffix delimit the sequence number written to stdout just before sending a
+// DisplayData with DisplayData.Seq set. It's an "operating system command" escape sequence, ignored by terminals,
+// and it is removed by GoNB from the output displayed.
+const (
+	StdoutMarkerPrefix = "\x1b]gonb;seq="
+	StdoutMarkerSuffix = "\a"
+)
+
+// StdoutMarker returns the marker written to stdout for the given sequence number.
+func StdoutMarker(seq int64) string {
+	return StdoutMarkerPrefix + strconv.FormatInt(seq, 10) + StdoutMarkerSuffix
+}
+
 type MIMEType string
 
 const (
@@ -79,6 +119,16 @@ const (
 	MIMETextPlain      MIMEType = "text/plain"
 	MIMEImagePNG       MIMEType = "image/png"
 	MIMEImageSVG       MIMEType = "image/svg+xml"
+	MIMETextLatex      MIMEType = "text/latex"
+
+	// MIMEVegaLite is the type of Vega-Lite (https://vega.github.io/vega-lite/) chart specifications, in JSON.
+	MIMEVegaLite MIMEType = "application/vnd.vegalite.v5+json"
+
+	// MIMEGeoJSON is the type of GeoJSON (https://geojson.org/) geographic data, rendered as a map.
+	MIMEGeoJSON MIMEType = "application/geo+json"
+
+	// MIMEMermaid is the type of Mermaid (https://mermaid.js.org/) diagrams, in their text format.
+	MIMEMermaid MIMEType = "text/vnd.mermaid"
 
 	// MIMEJupyterInput maps to an `*InputRequest`, and requests input from Jupyter.
 	// It's used by `gonbui.RequestInput`.
@@ -99,6 +149,34 @@ const (
 	//
 	// It's a GoNB specific mime type.
 	MIMECommSubscribe MIMEType = "gonb/comm_subscribe"
+
+	// MIMELogRecord maps to a `*LogRecord`, a structured log entry of the program, shown by the
+	// log viewer (`%logs`) of the notebook.
+	// It's used by the `slog.Handler` returned by `gonbui.LogHandler`.
+	//
+	// It's a GoNB specific mime type.
+	MIMELogRecord MIMEType = "gonb/log_record"
+
+	// MIMEFormRequest maps to a `*FormRequest`, and requests several inputs at once from the user, with a
+	// form rendered in the cell output.
+	// It's used by `gonbui.RequestForm`.
+	//
+	// It's a GoNB specific mime type.
+	MIMEFormRequest MIMEType = "gonb/form_request"
+
+	// MIMEFileRequest maps to a `*FileRequest`, and requests the user to pick a file, with a file browser
+	// rendered in the cell output.
+	// It's used by `gonbui.RequestFilePath`.
+	//
+	// It's a GoNB specific mime type.
+	MIMEFileRequest MIMEType = "gonb/file_request"
+
+	// MIMEServerAnnouncement maps to a `*ServerAnnouncement`, and registers a server started by the program
+	// with GoNB, which displays a link to it and tracks it as a job (see `%jobs`).
+	// It's used by `gonbui.AnnounceServer`.
+	//
+	// It's a GoNB specific mime type.
+	MIMEServerAnnouncement MIMEType = "gonb/server"
 )
 
 // DisplayData mimics the contents of the "display_data" message used by Jupyter, see
@@ -119,6 +197,15 @@ type DisplayData struct {
 	// unique IDs to start with, and then re-use them to update them. If set, after the first time that it's
 	// used, it will trigger the use of the `update_display_data` as opposed to `display_data` message.
 	DisplayID string
+
+	// Version of the protocol used by the program that sent the message, see protocol.Version. It is 0 for
+	// programs compiled with a version of gonbui from before the protocol was versioned.
+	Version int
+
+	// Seq is the sequence number of the display data, if > 0. It's set when GONB_ORDERED_OUTPUT_ENV is set, and
+	// the corresponding StdoutMarker is written to stdout just before: GoNB uses it to display it in the same
+	// order relative to the printed output.
+	Seq int64
 }
 
 // InputRequest for the front-end.
@@ -128,14 +215,108 @@ type InputRequest struct {
 
 	// Password input, in which case the contents are not displayed.
 	Password bool
+
+	// Timeout after which the prompt is cancelled, if the user hasn't answered, and Default is written to the
+	// stdin of the program instead. If 0, the timeout configured in the kernel (`%config input.timeout`) is used.
+	Timeout time.Duration
+
+	// Default is written to the stdin of the program (followed by a new line) if the prompt times out.
+	Default string
+
+	// SecretName, if set, requests the secret with this name from the secrets of the kernel session: if it is
+	// there, it is written to the stdin of the program without prompting the user. Otherwise, the user is
+	// prompted (as a password), and the value entered is kept in the session if PersistSecret is set.
+	SecretName string
+
+	// PersistSecret keeps the value entered for SecretName in the secrets of the kernel session.
+	PersistSecret bool
+}
+
+// Types of the fields of a FormRequest.
+const (
+	FormFieldText     = "text"
+	FormFieldPassword = "password"
+	FormFieldNumber   = "number"
+	FormFieldCheckbox = "checkbox"
+	FormFieldSelect   = "select"
+)
+
+// FormField is one of the fields of a FormRequest.
+type FormField struct {
+	// Name of the field, the key of its value in the reply.
+	Name string
+
+	// Label displayed, if empty Name is used.
+	Label string
+
+	// Type of the field, one of FormFieldText (the default, if empty), FormFieldPassword, FormFieldNumber,
+	// FormFieldCheckbox or FormFieldSelect.
+	Type string
+
+	// Default value, in text form: e.g.: "true" for a checked checkbox, or one of the Options.
+	Default string
+
+	// Options of a FormFieldSelect.
+	Options []string
+
+	// Required fields must be filled before the form can be submitted.
+	Required bool
+}
+
+// FormRequest for the front-end: a form with several fields, submitted at once.
+//
+// The front-end sends the reply to Address: a `map[string]any` with either the "values" of the fields
+// (by FormField.Name: strings, numbers or booleans, depending on the type of the field), or "cancelled" set
+// to true, if the user cancelled the form.
+type FormRequest struct {
+	// Address where the reply is sent.
+	Address string
+
+	// Title displayed above the fields. Can be left empty.
+	Title string
+
+	// SubmitLabel is the label of the submit button, by default "Submit".
+	SubmitLabel string
+
+	Fields []FormField
+}
+
+// FileRequest for the front-end: the user picks a file, either browsing the files served by Jupyter (with the
+// Jupyter contents API), or uploading one from the browser.
+//
+// The front-end sends the reply to Address: a `map[string]any` with either the "path" of the file picked,
+// relative to the Jupyter root directory (see GONB_JUPYTER_ROOT_ENV); or the "name" and the "content" (base64
+// encoded) of the file uploaded; or "cancelled" set to true, if the user cancelled.
+type FileRequest struct {
+	// Address where the reply is sent.
+	Address string
+
+	// Prompt displayed above the file browser. Can be left empty.
+	Prompt string
+
+	// Patterns of the names of the files listed (e.g.: "*.csv"), in the syntax of `path.Match`.
+	// If empty, all files are listed.
+	Patterns []string
+}
+
+// ServerAnnouncement of a server listening on a TCP port, started by the program.
+type ServerAnnouncement struct {
+	// Port where the server listens.
+	Port int
+
+	// Path of the page linked, e.g.: "/" or "/debug/pprof/".
+	Path string
 }
 
 // CommValueTypes currently accepted for communication with front-end.
 // Can be used in generics for type matching, even though through the wire
 // they are simply encoded as `any`.
+//
+// `[]byte` values are received when the front-end sends binary buffers (see `gonb_comm.send`);
+// when sent to the front-end they are encoded (in JSON) as base64 strings.
 type CommValueTypes interface {
 	int | float64 | string | []int | []float64 | []string |
-		map[string]int | map[string]float64 | map[string]string
+		map[string]int | map[string]float64 | map[string]string | []byte
 }
 
 // CommValue update or request to the front-end.
@@ -143,6 +324,12 @@ type CommValue struct {
 	Address string
 	Request bool
 	Value   any
+
+	// Reliable is set for values that must be acknowledged by the front-end: they are re-sent until they are.
+	Reliable bool
+
+	// Version of the protocol used by the sender, set in the messages from GoNB to the program, see protocol.Version.
+	Version int
 }
 
 // CommSubscription (un-)subscribe to changes to an address in the front-end.
@@ -151,6 +338,19 @@ type CommSubscription struct {
 	Unsubscribe bool // Set to true to unsubscribe instead.
 }
 
+// LogRecord is a structured log entry sent by the program.
+type LogRecord struct {
+	Time time.Time
+
+	// Level of the record, as in `log/slog`: -4 for debug, 0 for info, 4 for warning and 8 for error.
+	Level int
+
+	Message string
+
+	// Attrs are the attributes of the record, formatted as `key=value`.
+	Attrs []string
+}
+
 const (
 	// GonbuiSyncAddress is for internal use -- used to implement `gonbui.Sync`.
 	GonbuiSyncAddress = "#gonbui/sync"
@@ -158,19 +358,71 @@ const (
 	GonbuiSyncAckAddress = "#gonbui/sync_ack"
 	// GonbuiStartAddress is for internal use -- used to implement `comms.Start`.
 	GonbuiStartAddress = "#comms/start"
+	// GonbuiInterruptAddress is for internal use -- sent by GoNB when the cell is interrupted, used to implement
+	// `gonbui.OnInterrupt`.
+	GonbuiInterruptAddress = "#gonbui/interrupt"
+	// GonbuiWasmOutputAddress is for internal use -- the output (stdout, stderr and display data) of programs
+	// compiled with `%wasm` is sent by the front-end to this address, and GoNB relays it to the cell output.
+	GonbuiWasmOutputAddress = "#wasm/output"
+	// GonbuiCommsStateAddress is for internal use -- programs subscribe to it to be notified (with a bool value)
+	// of the changes of the connection with the front-end, used to implement `gonbui.OnCommsStateChange`.
+	GonbuiCommsStateAddress = "#gonbui/comms_state"
+	// GonbuiStdinCloseAddress is messaged by the front-end (with any value) to close the stdin of the program
+	// being executed, so it reads an EOF. E.g.: from a button with `gonb_comm.send("#gonbui/stdin_close", true)`.
+	GonbuiStdinCloseAddress = "#gonbui/stdin_close"
+	// GonbuiToastAddress is messaged by the programs to display a toast notification in the front-end, with
+	// a `map[string]any` value with the "level" (see `gonbui.ToastLevel`) and the "message". See `gonbui.Toast`.
+	GonbuiToastAddress = "#gonbui/toast"
+	// GonbuiStreamAddressPrefix is the prefix of the addresses of the streams created with `gonbui.NewStream`:
+	// the program sends the text written as string values, which GoNB batches and sends to the front-end as
+	// `{data: <text>}` values; and `{closed: true}` when the stream is closed.
+	GonbuiStreamAddressPrefix = "#gonbui/stream/"
 )
 
+// Version of the protocol of the named pipes between GoNB and the programs it executes, sent in the messages
+// (DisplayData.Version and CommValue.Version). It is increased when new types of values (see Register) or
+// messages are added.
+//
+// A program may be compiled with a version of gonbui different from the kernel's (e.g.: if it pins an
+// older version of GoNB in its `go.mod`): the messages with values of types unknown to the receiver are
+// dropped (see IsUnregisteredTypeError), and the others still work.
+const Version = 5
+
+// Register the types of the values sent in the `any` fields of the messages (e.g.: DisplayData.Data or
+// CommValue.Value), so they can be encoded -- by both GoNB and the programs, since both import this package.
+//
+// New types of values must be registered here (in init), and Version increased.
+func Register(values ...any) {
+	for _, value := range values {
+		gob.Register(value)
+	}
+}
+
+// IsUnregisteredTypeError returns whether the error decoding a message is due to a value of a type not
+// registered (see Register) by the receiver: usually because the sender uses a newer Version.
+//
+// The message is dropped, but the decoder can continue to be used: the next decoding may still fail with
+// the remainder of the dropped message, see IsCorruptedError.
+func IsUnregisteredTypeError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "not registered for interface")
+}
+
+// IsCorruptedError returns whether the error decoding a message is due to corrupted data, e.g.: the remainder
+// of a message dropped because of IsUnregisteredTypeError.
+func IsCorruptedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "corrupted data")
+}
+
 func init() {
-	gob.Register(DisplayData{})
-	gob.Register(InputRequest{})
-	gob.Register(CommValue{})
-	gob.Register(CommSubscription{})
+	Register(DisplayData{}, InputRequest{}, CommValue{}, CommSubscription{}, LogRecord{}, FormRequest{}, FileRequest{},
+		ServerAnnouncement{})
 
 	// Register CommValueTypes.
-	gob.Register([]int{})
-	gob.Register([]float64{})
-	gob.Register([]string{})
-	gob.Register(map[string]int{})
-	gob.Register(map[string]float64{})
-	gob.Register(map[string]string{})
+	Register([]int{}, []float64{}, []string{}, map[string]int{}, map[string]float64{}, map[string]string{})
+
+	// Generic values, e.g. values sent by the front-end in JSON.
+	Register(map[string]any{}, []any{})
+
+	// Register content of DisplayData that is already encoded as JSON (e.g.: Plotly specifications).
+	Register(json.RawMessage{})
 }
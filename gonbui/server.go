@@ -0,0 +1,33 @@
+package gonbui
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+)
+
+// AnnounceServer registers with GoNB a server started by the program listening on the TCP port, and displays
+// in the cell output a link to the given path (e.g.: "/"), proxied through the Jupyter server (it requires
+// jupyter-server-proxy), so it also works when the notebook is accessed remotely. A direct link is also offered.
+//
+// The server is tracked as a job, listed by `%jobs`, until the program exits. Example:
+//
+//	listener, err := net.Listen("tcp", "localhost:8080")
+//	if err != nil { ... }
+//	gonbui.AnnounceServer(8080, "/")
+//	_ = http.Serve(listener, handler)
+//
+// Call it once the server is listening, so the link works right away. Outside the notebook, the address is
+// written in degraded mode, if enabled (see SetDegradedOutput).
+func AnnounceServer(port int, path string) {
+	if !IsNotebook {
+		_ = writeDegraded(&protocol.DisplayData{
+			Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: fmt.Sprintf("Server listening on http://localhost:%d%s", port, path)},
+		})
+		return
+	}
+	SendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMEServerAnnouncement: &protocol.ServerAnnouncement{Port: port, Path: path},
+		},
+	})
+}
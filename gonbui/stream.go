@@ -0,0 +1,84 @@
+package gonbui
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+// Stream is an `io.WriteCloser` whose text is pushed live to the front-end, through the comms websocket managed
+// by GoNB -- so a cell program can feed a widget (e.g.: metrics, log events) without opening its own port.
+// Create it with NewStream.
+//
+// GoNB batches the text written (for up to `comms.StreamFlushInterval` in the kernel, 50ms by default), and
+// delivers it to the Address, as `{data: <text>}` values -- so the front-end should not rely on one value per
+// write. When the stream is closed, `{closed: true}` is delivered. In Javascript:
+//
+//	gonb_comm.subscribe("#gonbui/stream/metrics", (address, value) => {
+//	    if (value.closed) { ... return; }
+//	    append(value.data);
+//	});
+//
+// See `widgets.StreamView` for a ready-made viewer. Text written before the front-end subscribes is lost.
+type Stream struct {
+	address string
+	mu      sync.Mutex
+	closed  bool
+}
+
+// NewStream creates a Stream with the given name, which defines its address in the front-end (see Stream.Address).
+// Streams with the same name share the address.
+func NewStream(name string) *Stream {
+	return &Stream{address: protocol.GonbuiStreamAddressPrefix + name}
+}
+
+// Address where the text of the stream is delivered in the front-end.
+func (s *Stream) Address() string {
+	return s.address
+}
+
+// Write implements io.Writer: the text is pushed to the front-end. Outside the notebook, it is written to the
+// standard output.
+//
+// It returns os.ErrClosed if the stream was closed.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !IsNotebook {
+		return os.Stdout.Write(p)
+	}
+	err := TrySendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMECommValue: &protocol.CommValue{Address: s.address, Value: string(p)},
+		},
+	})
+	if err != nil {
+		return 0, errors.WithMessagef(err, "gonbui.Stream %q", s.address)
+	}
+	return len(p), nil
+}
+
+// Close implements io.Closer: the front-end is notified that the stream ended. Further writes fail.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if !IsNotebook {
+		return nil
+	}
+	return TrySendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMECommValue: &protocol.CommValue{Address: s.address, Value: map[string]any{"closed": true}},
+		},
+	})
+}
@@ -0,0 +1,57 @@
+package gonbui
+
+import (
+	"encoding/gob"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	reader := fakePipes(t)
+	const numMessages = 3
+	received := make(chan []protocol.CommValue)
+	go func() {
+		decoder := gob.NewDecoder(reader)
+		var values []protocol.CommValue
+		for ii := 0; ii < numMessages; ii++ {
+			data := &protocol.DisplayData{}
+			if err := decoder.Decode(data); err != nil {
+				t.Errorf("failed to decode message #%d: %+v", ii, err)
+				break
+			}
+			values = append(values, data.Data[protocol.MIMECommValue].(protocol.CommValue))
+		}
+		received <- values
+	}()
+
+	stream := NewStream("metrics")
+	assert.Equal(t, protocol.GonbuiStreamAddressPrefix+"metrics", stream.Address())
+	n, err := stream.Write([]byte("loss=0.5\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 9, n)
+	_, err = io.WriteString(stream, "") // Empty writes are not sent.
+	require.NoError(t, err)
+	_, err = io.WriteString(stream, "loss=0.4\n")
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	// After closing, writes fail and nothing else is sent.
+	_, err = io.WriteString(stream, "loss=0.3\n")
+	assert.ErrorIs(t, err, os.ErrClosed)
+	require.NoError(t, stream.Close(), "closing again is a no-op")
+	mu.Lock()
+	assert.Empty(t, writeQueue)
+	mu.Unlock()
+
+	values := <-received
+	var got []any
+	for _, value := range values {
+		assert.Equal(t, stream.Address(), value.Address)
+		got = append(got, value.Value)
+	}
+	assert.Equal(t, []any{"loss=0.5\n", "loss=0.4\n", map[string]any{"closed": true}}, got)
+}
@@ -0,0 +1,469 @@
+// Package tables displays tabular data -- slices of structs, maps, DataFrames, etc. -- as an interactive
+// HTML table in the notebook, with sorting (clicking on the column headers) and paging.
+//
+// Only the visible page of the table is sent to the front-end: the first page is rendered when the table is
+// displayed, and the other pages (or a different sort order) are requested by the front-end through the
+// communication channel of the `gonbui/comms` package. So large tables can be browsed while the program is
+// running -- see Table.Serve to keep serving the pages after the end of `main()`: once the program exits, the
+// pager is disabled. Tables that fit in one page don't need the program, and are sorted in the front-end.
+//
+// Example:
+//
+//	type Point struct { X, Y float64; Label string }
+//	points := []Point{{1, 2, "a"}, {3, 4, "b"}}
+//	err := tables.Display(points)
+package tables
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"html/template"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	textTemplate "text/template"
+)
+
+// DefaultPageSize is the number of rows displayed per page, if not changed with Table.WithPageSize.
+var DefaultPageSize = 20
+
+// Recorder is implemented by tabular types that can be converted to records, where the first record holds
+// the names of the columns. E.g.: `github.com/go-gota/gota/dataframe.DataFrame`.
+type Recorder interface {
+	Records() [][]string
+}
+
+//go:embed tables.js
+var tablesJs []byte
+
+var tmplTablesJs = textTemplate.Must(textTemplate.New("tablesJs").Parse(
+	string(tablesJs)))
+
+// Table holds the contents of a table to be displayed. Create it with New.
+type Table struct {
+	// Columns names of the table.
+	Columns []string
+
+	// Rows of the table, with the formatted values of each column.
+	Rows [][]string
+
+	pageSize        int
+	htmlId, address string
+	displayed       bool
+
+	// numeric holds whether each column only holds numbers: they are sorted by value, as opposed to
+	// lexicographically.
+	numeric []bool
+
+	// Sort order of the rows, cached for the latest column sorted.
+	muOrder               sync.Mutex
+	orderColumn           int
+	orderDescending       bool
+	order                 []int
+	requests              *comms.AddressChan[string]
+	servingDone, finished *common.Latch
+}
+
+// Display the given data as a table, see New for the types of data accepted.
+func Display(data any) error {
+	t, err := New(data)
+	if err != nil {
+		return err
+	}
+	return t.Display()
+}
+
+// New creates a table with the given data, which can be:
+//
+//   - A slice (or array) of structs, or pointers to structs: one row per element, with one column per exported field.
+//   - A slice of any other type: one row per element, with its value.
+//   - A map: one row per key (sorted), with the key in the first column, and the value (or the exported fields of
+//     the value, if it is a struct) in the following columns.
+//   - `[][]string` or a Recorder (e.g. gota DataFrames): the first record holds the names of the columns.
+func New(data any) (*Table, error) {
+	t := &Table{
+		pageSize:    DefaultPageSize,
+		htmlId:      "gonb_table_" + gonbui.UniqueId(),
+		address:     "/tables/" + gonbui.UniqueId(),
+		orderColumn: -1,
+		servingDone: common.NewLatch(),
+		finished:    common.NewLatch(),
+	}
+	if recorder, ok := data.(Recorder); ok {
+		data = recorder.Records()
+	}
+	if records, ok := data.([][]string); ok {
+		if len(records) == 0 {
+			return nil, errors.Errorf("tables.New: no records, not even the names of the columns")
+		}
+		t.Columns = records[0]
+		t.Rows = records[1:]
+	} else if err := t.fromValue(data); err != nil {
+		return nil, err
+	}
+	for ii, row := range t.Rows {
+		if len(row) != len(t.Columns) {
+			return nil, errors.Errorf("tables.New: row %d has %d values, but there are %d columns", ii, len(row), len(t.Columns))
+		}
+	}
+	t.numeric = make([]bool, len(t.Columns))
+	for col := range t.Columns {
+		t.numeric[col] = t.isNumeric(col)
+	}
+	return t, nil
+}
+
+// fromValue sets the columns and rows from a slice, array or map.
+func (t *Table) fromValue(data any) error {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		elemType := derefType(v.Type().Elem())
+		fields := structFields(elemType)
+		if fields == nil {
+			t.Columns = []string{"Value"}
+		} else {
+			t.Columns = fieldNames(elemType, fields)
+		}
+		t.Rows = make([][]string, 0, v.Len())
+		for ii := 0; ii < v.Len(); ii++ {
+			t.Rows = append(t.Rows, formatValue(v.Index(ii), fields))
+		}
+	case reflect.Map:
+		valueType := derefType(v.Type().Elem())
+		fields := structFields(valueType)
+		t.Columns = []string{"Key"}
+		if fields == nil {
+			t.Columns = append(t.Columns, "Value")
+		} else {
+			t.Columns = append(t.Columns, fieldNames(valueType, fields)...)
+		}
+		t.Rows = make([][]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			row := append([]string{formatCell(iter.Key())}, formatValue(iter.Value(), fields)...)
+			t.Rows = append(t.Rows, row)
+		}
+		isNumber := true
+		for _, row := range t.Rows {
+			if _, err := strconv.ParseFloat(row[0], 64); err != nil {
+				isNumber = false
+				break
+			}
+		}
+		sort.SliceStable(t.Rows, func(i, j int) bool {
+			return lessCell(t.Rows[i][0], t.Rows[j][0], isNumber)
+		})
+	default:
+		return errors.Errorf("tables.New: can't display values of type %T as a table", data)
+	}
+	return nil
+}
+
+// derefType returns the type pointed to, if t is a pointer.
+func derefType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// structFields returns the indices of the exported fields of t, if it is a struct, or nil otherwise.
+func structFields(t reflect.Type) []int {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields := make([]int, 0, t.NumField())
+	for ii := 0; ii < t.NumField(); ii++ {
+		if t.Field(ii).IsExported() {
+			fields = append(fields, ii)
+		}
+	}
+	return fields
+}
+
+func fieldNames(t reflect.Type, fields []int) []string {
+	names := make([]string, 0, len(fields))
+	for _, ii := range fields {
+		names = append(names, t.Field(ii).Name)
+	}
+	return names
+}
+
+// formatValue returns the cells of the value: its fields, if fields is not nil, or its formatted value otherwise.
+func formatValue(v reflect.Value, fields []int) []string {
+	if fields == nil {
+		return []string{formatCell(v)}
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return make([]string, len(fields))
+		}
+		v = v.Elem()
+	}
+	cells := make([]string, 0, len(fields))
+	for _, ii := range fields {
+		cells = append(cells, formatCell(v.Field(ii)))
+	}
+	return cells
+}
+
+// formatCell returns the formatted value of a cell.
+func formatCell(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// isNumeric returns whether all the non-empty values of the column are numbers.
+func (t *Table) isNumeric(col int) bool {
+	for _, row := range t.Rows {
+		if row[col] == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(row[col], 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lessCell compares the values of two cells. Empty values are sorted first.
+func lessCell(a, b string, numeric bool) bool {
+	if numeric && a != "" && b != "" {
+		fa, _ := strconv.ParseFloat(a, 64)
+		fb, _ := strconv.ParseFloat(b, 64)
+		return fa < fb
+	}
+	return a < b
+}
+
+// WithPageSize sets the number of rows displayed per page.
+//
+// It panics if called after the table is displayed.
+func (t *Table) WithPageSize(n int) *Table {
+	if t.displayed {
+		panicf("tables.Table cannot change parameters after it is displayed")
+	}
+	if n <= 0 {
+		panicf("tables.Table.WithPageSize(%d): page size must be positive", n)
+	}
+	t.pageSize = n
+	return t
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// It panics if called after the table is displayed.
+func (t *Table) WithHtmlId(htmlId string) *Table {
+	if t.displayed {
+		panicf("tables.Table cannot change parameters after it is displayed")
+	}
+	t.htmlId = htmlId
+	return t
+}
+
+// HtmlId returns the `id` used in the HTML element of the table.
+func (t *Table) HtmlId() string {
+	return t.htmlId
+}
+
+// NumPages returns the number of pages of the table.
+func (t *Table) NumPages() int {
+	return max((len(t.Rows)+t.pageSize-1)/t.pageSize, 1)
+}
+
+// sortedOrder returns the order of the rows sorted by the given column, or in the original order if column < 0.
+func (t *Table) sortedOrder(column int, descending bool) []int {
+	t.muOrder.Lock()
+	defer t.muOrder.Unlock()
+	if t.order != nil && t.orderColumn == column && t.orderDescending == descending {
+		return t.order
+	}
+	order := make([]int, len(t.Rows))
+	for ii := range order {
+		order[ii] = ii
+	}
+	if column >= 0 && column < len(t.Columns) {
+		numeric := t.numeric[column]
+		sort.SliceStable(order, func(i, j int) bool {
+			a, b := t.Rows[order[i]][column], t.Rows[order[j]][column]
+			if descending {
+				return lessCell(b, a, numeric)
+			}
+			return lessCell(a, b, numeric)
+		})
+	}
+	t.order, t.orderColumn, t.orderDescending = order, column, descending
+	return order
+}
+
+// pageRequest is sent by the front-end to request a page of the table.
+type pageRequest struct {
+	Page       int  `json:"page"`
+	Column     int  `json:"column"` // -1 for the original order.
+	Descending bool `json:"descending"`
+}
+
+// pageReply is sent to the front-end with the rows of the page requested.
+type pageReply struct {
+	pageRequest
+	// Rows of the page, with the index of the row (starting at 1) in the first column.
+	Rows [][]string `json:"rows"`
+}
+
+// page returns the rows of the page requested, with the index of the row in the first column.
+func (t *Table) page(req pageRequest) [][]string {
+	order := t.sortedOrder(req.Column, req.Descending)
+	start := min(max(req.Page, 0)*t.pageSize, len(order))
+	end := min(start+t.pageSize, len(order))
+	rows := make([][]string, 0, end-start)
+	for _, rowIdx := range order[start:end] {
+		rows = append(rows, append([]string{strconv.Itoa(rowIdx + 1)}, t.Rows[rowIdx]...))
+	}
+	return rows
+}
+
+var tmplTableHtml = template.Must(template.New("tableHtml").Parse(`<div id="{{.HtmlId}}" class="gonb-table">
+<style>
+.gonb-table table { border-collapse: collapse; font-size: 90%; }
+.gonb-table th { cursor: pointer; user-select: none; }
+.gonb-table th, .gonb-table td { padding: 2px 8px; text-align: right; }
+.gonb-table th.gonb-table-sorted-asc::after { content: " ▲"; }
+.gonb-table th.gonb-table-sorted-desc::after { content: " ▼"; }
+.gonb-table .gonb-table-index { opacity: 0.6; }
+.gonb-table .gonb-table-nav button { margin: 0 2px; }
+</style>
+<table>
+<thead><tr><th class="gonb-table-index" data-column="-1">#</th>{{range $ii, $col := .Columns}}<th data-column="{{$ii}}">{{$col}}</th>{{end}}</tr></thead>
+<tbody>{{range .Rows}}<tr>{{range $ii, $cell := .}}<td{{if eq $ii 0}} class="gonb-table-index"{{end}}>{{$cell}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+<div class="gonb-table-nav">{{if gt .NumPages 1}}<button data-page="first">«</button><button data-page="prev">‹</button>
+<span class="gonb-table-status">Page 1 of {{.NumPages}}</span> ({{.NumRows}} rows)
+<button data-page="next">›</button><button data-page="last">»</button>{{else}}{{.NumRows}} rows{{end}}</div>
+</div>`))
+
+// Display the table as the output of the cell being executed.
+//
+// If the table has more than one page, it starts serving the pages requested by the front-end, until
+// the program exits, when the pager is disabled -- see Serve to keep serving them after the end of `main()`.
+//
+// Outside the notebook (gonbui.IsNotebook is false) it does nothing and returns nil: tables have no degraded
+// version (see gonbui.SetDegradedOutput).
+func (t *Table) Display() error {
+	if t.displayed {
+		return errors.Errorf("tables.Table.Display already called")
+	}
+	t.displayed = true
+	if !gonbui.IsNotebook {
+		return nil
+	}
+	paged := t.NumPages() > 1
+	firstPage := t.page(pageRequest{Column: -1})
+	var buf bytes.Buffer
+	err := tmplTableHtml.Execute(&buf, map[string]any{
+		"HtmlId":   t.htmlId,
+		"Columns":  t.Columns,
+		"Rows":     firstPage,
+		"NumPages": t.NumPages(),
+		"NumRows":  len(t.Rows),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to render table")
+	}
+	numericJson, _ := json.Marshal(t.numeric)
+	buf.WriteString("\n<script charset=\"UTF-8\">\n")
+	err = tmplTablesJs.Execute(&buf, map[string]any{
+		"HtmlId":   t.htmlId,
+		"Address":  t.address,
+		"Paged":    paged,
+		"NumPages": t.NumPages(),
+		"Numeric":  string(numericJson),
+	})
+	if err != nil {
+		panicf("Tables template is invalid!? Please report the error to GoNB: %v", err)
+	}
+	buf.WriteString("</script>")
+	gonbui.SendData(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{
+			protocol.MIMETextHTML:  buf.String(),
+			protocol.MIMETextPlain: t.text(firstPage),
+		},
+	})
+	if paged {
+		t.requests = comms.Listen[string](t.address)
+		go t.servePages()
+		comms.Start()
+	}
+	return gonbui.Error()
+}
+
+// servePages replies to the page requests of the front-end, until Close is called.
+func (t *Table) servePages() {
+	defer t.servingDone.Trigger()
+	for reqJson := range t.requests.C {
+		var req pageRequest
+		if err := json.Unmarshal([]byte(reqJson), &req); err != nil {
+			gonbui.Logf("Table(%s): invalid page request %q: %v", t.htmlId, reqJson, err)
+			continue
+		}
+		reply, err := json.Marshal(pageReply{pageRequest: req, Rows: t.page(req)})
+		if err != nil {
+			gonbui.Logf("Table(%s): failed to encode page: %v", t.htmlId, err)
+			continue
+		}
+		comms.Send(t.address+"/page", string(reply))
+	}
+}
+
+// Serve blocks serving the pages requested by the front-end, until Close is called (e.g.: by another
+// goroutine) or the cell is interrupted. Call it at the end of `main()` to browse a large table.
+func (t *Table) Serve() {
+	if t.requests == nil {
+		return
+	}
+	t.finished.Wait()
+}
+
+// Close stops serving the pages requested by the front-end, and releases Serve.
+func (t *Table) Close() {
+	if t.requests != nil {
+		t.requests.Close()
+		t.servingDone.Wait()
+	}
+	t.finished.Trigger()
+}
+
+// text returns the rows of the page formatted as plain text, for front-ends that don't render HTML.
+func (t *Table) text(rows [][]string) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintf(w, "#\t%s\t\n", strings.Join(t.Columns, "\t"))
+	for _, row := range rows {
+		_, _ = fmt.Fprintf(w, "%s\t\n", strings.Join(row, "\t"))
+	}
+	_ = w.Flush()
+	if len(rows) < len(t.Rows) {
+		_, _ = fmt.Fprintf(&buf, "... (%d rows)\n", len(t.Rows))
+	}
+	return buf.String()
+}
+
+// panicf is an alias for common.Panicf.
+var panicf = common.Panicf
@@ -0,0 +1,145 @@
+(() => {
+    const div = document.getElementById("{{.HtmlId}}");
+    const tbody = div.querySelector("tbody");
+    const headers = div.querySelectorAll("th");
+    const numeric = {{.Numeric}};
+    const numPages = {{.NumPages}};
+    let state = {page: 0, column: -1, descending: false};
+
+    // markSorted shows the column sorted in its header.
+    function markSorted() {
+        headers.forEach((th) => {
+            th.classList.remove("gonb-table-sorted-asc", "gonb-table-sorted-desc");
+            if (state.column >= 0 && +th.dataset.column === state.column) {
+                th.classList.add(state.descending ? "gonb-table-sorted-desc" : "gonb-table-sorted-asc");
+            }
+        });
+    }
+{{if .Paged}}
+    // Pages are served by the program, see tables.Table.
+    const status = div.querySelector(".gonb-table-status");
+    const buttons = div.querySelectorAll(".gonb-table-nav button");
+    let gonb_comm = null;
+    let replyTimeout = null;
+    let disabled = false;
+
+    // disable the pager: the program that serves the pages is no longer running.
+    function disable() {
+        disabled = true;
+        clearTimeout(replyTimeout);
+        buttons.forEach((button) => { button.disabled = true; });
+        headers.forEach((th) => { th.style.cursor = "default"; });
+        status.textContent = `Page ${state.page + 1} of ${numPages} (program ended: pages are only served while it runs, see tables.Table.Serve)`;
+    }
+
+    // connect subscribes to the pages sent by the program, and to the end of the execution of the cell, when
+    // the program exits. The communication with GoNB is only installed after the table is displayed, so it is
+    // retried for a while.
+    function connect() {
+        if (gonb_comm) {
+            return true;
+        }
+        gonb_comm = globalThis?.gonb_comm;
+        if (!gonb_comm) {
+            return false;
+        }
+        gonb_comm.subscribe("#execution/end", () => disable());
+        gonb_comm.subscribe("{{.Address}}/page", (address, value) => {
+            clearTimeout(replyTimeout);
+            const reply = JSON.parse(value);
+            state = {page: reply.page, column: reply.column, descending: reply.descending};
+            let rows = [];
+            for (const values of reply.rows) {
+                let tr = document.createElement("tr");
+                values.forEach((value, ii) => {
+                    let td = document.createElement("td");
+                    if (ii === 0) {
+                        td.className = "gonb-table-index";
+                    }
+                    td.textContent = value;
+                    tr.appendChild(td);
+                });
+                rows.push(tr);
+            }
+            tbody.replaceChildren(...rows);
+            status.textContent = `Page ${state.page + 1} of ${numPages}`;
+            markSorted();
+        });
+        return true;
+    }
+
+    let connectAttempts = 0;
+    const connectInterval = setInterval(() => {
+        if (connect() || ++connectAttempts >= 50) {
+            clearInterval(connectInterval);
+        }
+    }, 200);
+
+    function request(newState) {
+        if (disabled) {
+            return;
+        }
+        if (!gonb_comm && !connect()) {
+            status.textContent = "Communication to GoNB not setup, pages are not available";
+            return;
+        }
+        status.textContent = "Loading ...";
+        clearTimeout(replyTimeout);
+        replyTimeout = setTimeout(disable, 3000); // E.g.: a notebook reopened after the program ended.
+        gonb_comm.send("{{.Address}}", JSON.stringify(newState));
+    }
+
+    buttons.forEach((button) => {
+        button.addEventListener("click", () => {
+            let page = state.page;
+            switch (button.dataset.page) {
+                case "first":
+                    page = 0;
+                    break;
+                case "prev":
+                    page = Math.max(page - 1, 0);
+                    break;
+                case "next":
+                    page = Math.min(page + 1, numPages - 1);
+                    break;
+                case "last":
+                    page = numPages - 1;
+                    break;
+            }
+            request({...state, page: page});
+        });
+    });
+{{else}}
+    // sortRows sorts the rows of the (only) page, in the front-end.
+    function sortRows() {
+        const cellIdx = state.column + 1;  // Column 0 holds the index of the row.
+        const isNumeric = state.column < 0 || numeric[state.column];
+        let rows = Array.from(tbody.rows);
+        rows.sort((rowA, rowB) => {
+            const a = rowA.cells[cellIdx].textContent, b = rowB.cells[cellIdx].textContent;
+            let cmp;
+            if (isNumeric && a !== "" && b !== "") {
+                cmp = parseFloat(a) - parseFloat(b);
+            } else {
+                cmp = a < b ? -1 : (a > b ? 1 : 0);
+            }
+            return state.descending ? -cmp : cmp;
+        });
+        tbody.replaceChildren(...rows);
+        markSorted();
+    }
+{{end}}
+    headers.forEach((th) => {
+        th.addEventListener("click", () => {
+            // Clicking again on the sorted column reverses the order; the "#" column restores the original order.
+            const column = +th.dataset.column;
+            const descending = column >= 0 && column === state.column && !state.descending;
+{{if .Paged}}
+            request({page: 0, column: column, descending: descending});
+{{else}}
+            state = {page: 0, column: column, descending: descending};
+            sortRows();
+{{end}}
+        });
+    });
+})();
@@ -0,0 +1,145 @@
+package tables
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X, Y   float64
+	Label  string
+	hidden int
+}
+
+// records implements Recorder.
+type records [][]string
+
+func (r records) Records() [][]string { return r }
+
+func TestNew(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		data    any
+		columns []string
+		rows    [][]string
+		numeric []bool
+	}{
+		{"structs", []point{{1, 2, "a", 0}, {3.5, -4, "b", 0}},
+			[]string{"X", "Y", "Label"}, [][]string{{"1", "2", "a"}, {"3.5", "-4", "b"}}, []bool{true, true, false}},
+		{"pointers to structs", []*point{{X: 1, Label: "a"}, nil},
+			[]string{"X", "Y", "Label"}, [][]string{{"1", "0", "a"}, {"", "", ""}}, []bool{true, true, false}},
+		{"pointer to array", &[2]int{7, 8},
+			[]string{"Value"}, [][]string{{"7"}, {"8"}}, []bool{true}},
+		{"values", []string{"x", "10"},
+			[]string{"Value"}, [][]string{{"x"}, {"10"}}, []bool{false}},
+		{"map sorted by numeric key", map[int]string{10: "ten", 9: "nine", 100: "hundred"},
+			[]string{"Key", "Value"}, [][]string{{"9", "nine"}, {"10", "ten"}, {"100", "hundred"}}, []bool{true, false}},
+		{"map sorted by string key", map[string]int{"b": 2, "a": 1, "10": 10},
+			[]string{"Key", "Value"}, [][]string{{"10", "10"}, {"a", "1"}, {"b", "2"}}, []bool{false, true}},
+		{"map of structs", map[string]point{"p": {X: 1, Y: 2, Label: "q"}},
+			[]string{"Key", "X", "Y", "Label"}, [][]string{{"p", "1", "2", "q"}}, []bool{false, true, true, false}},
+		{"records", [][]string{{"a", "b"}, {"1", "x"}},
+			[]string{"a", "b"}, [][]string{{"1", "x"}}, []bool{true, false}},
+		{"recorder", records{{"name"}, {"gonb"}},
+			[]string{"name"}, [][]string{{"gonb"}}, []bool{false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			table, err := New(tc.data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.columns, table.Columns)
+			assert.Equal(t, tc.rows, table.Rows)
+			assert.Equal(t, tc.numeric, table.numeric)
+		})
+	}
+
+	for _, tc := range []struct {
+		name, errMsg string
+		data         any
+	}{
+		{"no records", "no records", [][]string{}},
+		{"ragged records", "row 1 has 1 values", [][]string{{"a", "b"}, {"1", "2"}, {"3"}}},
+		{"not tabular", "can't display values of type int", 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(tc.data)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.errMsg)
+		})
+	}
+}
+
+func TestSortedOrder(t *testing.T) {
+	table, err := New([][]string{
+		{"Name", "Size"},
+		{"b", "10"},
+		{"a", "9"},
+		{"c", ""},
+		{"a", "100"},
+	})
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name       string
+		column     int
+		descending bool
+		want       []int
+	}{
+		{"original order", -1, false, []int{0, 1, 2, 3}},
+		{"invalid column keeps original order", 5, false, []int{0, 1, 2, 3}},
+		{"strings, stable for ties", 0, false, []int{1, 3, 0, 2}},
+		{"strings descending", 0, true, []int{2, 0, 1, 3}},
+		{"numbers by value, empty first", 1, false, []int{2, 1, 0, 3}},
+		{"numbers descending, empty last", 1, true, []int{3, 0, 1, 2}},
+		{"cached order is recomputed", 1, false, []int{2, 1, 0, 3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, table.sortedOrder(tc.column, tc.descending))
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	rows := make([][]string, 0, 5)
+	for _, v := range []string{"e", "d", "c", "b", "a"} {
+		rows = append(rows, []string{v})
+	}
+	table, err := New(append([][]string{{"Letter"}}, rows...))
+	require.NoError(t, err)
+	table.WithPageSize(2)
+	assert.Equal(t, 3, table.NumPages())
+
+	for _, tc := range []struct {
+		name string
+		req  pageRequest
+		want [][]string
+	}{
+		{"first page", pageRequest{Page: 0, Column: -1}, [][]string{{"1", "e"}, {"2", "d"}}},
+		{"last page is partial", pageRequest{Page: 2, Column: -1}, [][]string{{"5", "a"}}},
+		{"beyond the last page", pageRequest{Page: 3, Column: -1}, [][]string{}},
+		{"negative page", pageRequest{Page: -1, Column: -1}, [][]string{{"1", "e"}, {"2", "d"}}},
+		{"sorted, with original indices", pageRequest{Page: 0, Column: 0}, [][]string{{"5", "a"}, {"4", "b"}}},
+		{"sorted descending", pageRequest{Page: 1, Column: 0, Descending: true}, [][]string{{"3", "c"}, {"4", "b"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, table.page(tc.req))
+		})
+	}
+
+	empty, err := New([]int{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, empty.NumPages())
+	assert.Empty(t, empty.page(pageRequest{Column: -1}))
+}
+
+func TestText(t *testing.T) {
+	table, err := New([]point{{1, 2, "a", 0}, {3, 4, "b", 0}})
+	require.NoError(t, err)
+	table.WithPageSize(1)
+	text := table.text(table.page(pageRequest{Column: -1}))
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"#", "X", "Y", "Label"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"1", "1", "2", "a"}, strings.Fields(lines[1]))
+	assert.Equal(t, "... (2 rows)", lines[2])
+}
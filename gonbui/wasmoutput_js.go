@@ -0,0 +1,47 @@
+//go:build js
+
+package gonbui
+
+import (
+	"encoding/json"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"os"
+	"syscall/js"
+)
+
+// Programs compiled with `%wasm` run in the browser, where there are no named pipes to GoNB: instead the display
+// data is relayed to the cell output through the `gonb_comm` Javascript object (see protocol.GonbuiWasmOutputAddress).
+
+// wasmId is the id of the `%wasm` cell, set by GoNB when running in the browser.
+var wasmId = os.Getenv(protocol.GONB_WASM_ID_ENV)
+
+func init() {
+	if wasmId != "" {
+		// Only the display data is relayed: the other functionality (comms, input, sync) uses the named pipes.
+		gonbPipesError = errors.New("programs compiled with `%wasm` only support displaying data, " +
+			"use the `gonb_comm` Javascript object to communicate with the front-end")
+	}
+}
+
+// sendWasmLocked relays the display data to GoNB, through the `gonb_comm` Javascript object.
+func sendWasmLocked(data *protocol.DisplayData) {
+	if !isDisplayData(data) {
+		Logf("SendData(): data not supported in `%%wasm` programs, dropped")
+		return
+	}
+	gonbComm := js.Global().Get("gonb_comm")
+	if gonbComm.IsUndefined() || gonbComm.IsNull() {
+		Logf("SendData(): `gonb_comm` not installed, display data dropped")
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		Logf("SendData(): failed to encode display data: %+v", err)
+		return
+	}
+	gonbComm.Call("send", protocol.GonbuiWasmOutputAddress, map[string]any{
+		"id":      wasmId,
+		"display": string(encoded),
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !js
+
+package gonbui
+
+import "github.com/janpfeifer/gonb/gonbui/protocol"
+
+// wasmId is only set for programs compiled with `%wasm`, running in the browser.
+var wasmId string
+
+// sendWasmLocked is only used by programs compiled with `%wasm`.
+func sendWasmLocked(_ *protocol.DisplayData) {}
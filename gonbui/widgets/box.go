@@ -0,0 +1,105 @@
+package widgets
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/dom"
+)
+
+// BoxBuilder is used to create a layout container on the front-end, that lays out the widgets appended to it
+// in a row (HBox) or in a column (VBox).
+type BoxBuilder struct {
+	htmlId, parentHtmlId string
+	direction, gap       string
+	built                bool
+}
+
+// HBox returns a builder object that builds a container that lays out its contents horizontally, in a row.
+//
+// Widgets (or other boxes) are added to it with their `AppendTo(box.HtmlId())` method, after the box is
+// created with `Done`.
+func HBox() *BoxBuilder {
+	return &BoxBuilder{
+		htmlId:    "gonb_hbox_" + gonbui.UniqueId(),
+		direction: "row",
+		gap:       "0.5em",
+	}
+}
+
+// VBox returns a builder object that builds a container that lays out its contents vertically, in a column.
+//
+// Widgets (or other boxes) are added to it with their `AppendTo(box.HtmlId())` method, after the box is
+// created with `Done`.
+func VBox() *BoxBuilder {
+	return &BoxBuilder{
+		htmlId:    "gonb_vbox_" + gonbui.UniqueId(),
+		direction: "column",
+		gap:       "0.5em",
+	}
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// This can only be set before call to Done. If called afterward, it panics.
+func (b *BoxBuilder) WithHtmlId(htmlId string) *BoxBuilder {
+	if b.built {
+		panicf("BoxBuilder cannot change parameters after it is built")
+	}
+	b.htmlId = htmlId
+	return b
+}
+
+// WithGap sets the space between the contents of the box, in CSS units, e.g.: "10px". The default is "0.5em".
+//
+// It panics if called after the widget is built.
+func (b *BoxBuilder) WithGap(gap string) *BoxBuilder {
+	if b.built {
+		panicf("BoxBuilder cannot change parameters after it is built")
+	}
+	b.gap = gap
+	return b
+}
+
+// AppendTo defines an id of the parent element in the DOM (in the front-end)
+// where to insert the box -- e.g.: another box.
+//
+// If not defined, it will simply display it as default in the output of the cell.
+//
+// It panics if called after the widget is built.
+func (b *BoxBuilder) AppendTo(parentHtmlId string) *BoxBuilder {
+	if b.built {
+		panicf("BoxBuilder cannot change parameters after it is built")
+	}
+	b.parentHtmlId = parentHtmlId
+	return b
+}
+
+// Done builds the HTML element in the frontend. After this, contents can be appended to it.
+func (b *BoxBuilder) Done() *BoxBuilder {
+	if b.built {
+		panicf("BoxBuilder.Done already called!?")
+	}
+	b.built = true
+	html := fmt.Sprintf(`<div id="%s" style="display: flex; flex-direction: %s; flex-wrap: wrap; align-items: %s; gap: %s"></div>`,
+		b.htmlId, b.direction, b.alignItems(), b.gap)
+	if b.parentHtmlId == "" {
+		gonbui.DisplayHtml(html)
+	} else {
+		dom.Append(b.parentHtmlId, html)
+	}
+	return b
+}
+
+// alignItems returns the CSS alignment of the contents across the direction of the box.
+func (b *BoxBuilder) alignItems() string {
+	if b.direction == "row" {
+		return "center"
+	}
+	return "flex-start"
+}
+
+// HtmlId returns the `id` used in the HTML element created, to be used by the contents' `AppendTo`.
+func (b *BoxBuilder) HtmlId() string {
+	return b.htmlId
+}
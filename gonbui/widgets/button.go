@@ -8,6 +8,7 @@ import (
 	"github.com/janpfeifer/gonb/gonbui/comms"
 	"github.com/janpfeifer/gonb/gonbui/dom"
 	"text/template"
+	"time"
 )
 
 //go:embed button.js
@@ -33,7 +34,7 @@ type ButtonBuilder struct {
 func Button(label string) *ButtonBuilder {
 	return &ButtonBuilder{
 		label:   label,
-		address: "/button/" + gonbui.UniqueId(),
+		address: newAddress("button"),
 		htmlId:  "gonb_button_" + gonbui.UniqueId(),
 	}
 }
@@ -53,14 +54,15 @@ func (b *ButtonBuilder) WithHtmlId(htmlId string) *ButtonBuilder {
 // WithAddress configures the widget to use the given address to communicate its state
 // with the front-end.
 //
-// The default is to use a randomly created unique address.
+// The default is to use a randomly created unique address, in the comms.ExecutionScope.
+// The address given is in the scope set with SetScope, if any.
 //
 // It panics if called after the widget is built.
 func (b *ButtonBuilder) WithAddress(address string) *ButtonBuilder {
 	if b.built {
 		panicf("ButtonBuilder cannot change parameters after it is built")
 	}
-	b.address = address
+	b.address = scopedAddress(address)
 	return b
 }
 
@@ -128,6 +130,19 @@ func (b *ButtonBuilder) Listen() *comms.AddressChan[int] {
 	return comms.Listen[int](b.address)
 }
 
+// WaitClick blocks until the button is clicked, e.g.: to let the user decide when to continue a step-by-step
+// flow. It returns the errors of `gonbui.WaitFor`: `gonbui.ErrTimeout` if timeout > 0 and the button is not
+// clicked in time, or `gonbui.ErrInterrupted` if the cell is interrupted while waiting.
+//
+// It can only be called after the Button is created with Done, otherwise it panics.
+func (b *ButtonBuilder) WaitClick(timeout time.Duration) error {
+	if !b.built {
+		panicf("ButtonBuilder.WaitClick can only be called after the button was created with `Done()` method")
+	}
+	_, err := gonbui.WaitFor(b.address, nil, timeout)
+	return err
+}
+
 // HtmlId returns the `id` used in the widget HTML element created.
 func (b *ButtonBuilder) HtmlId() string {
 	return b.htmlId
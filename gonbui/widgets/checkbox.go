@@ -0,0 +1,212 @@
+package widgets
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/dom"
+	"golang.org/x/exp/slices"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//go:embed checkbox.js
+var checkboxJs []byte
+
+var tmplCheckboxJs = template.Must(template.New("checkboxJs").Parse(
+	string(checkboxJs)))
+
+// sendChecked sends the indices of the options checked to the front-end, replaced in tests.
+var sendChecked = comms.SendReliable[[]int]
+
+// CheckboxGroupBuilder is used to create a group of checkboxes on the front-end.
+type CheckboxGroupBuilder struct {
+	address, htmlId, parentHtmlId string
+	built                         bool
+
+	// Options that can be checked.
+	options []string
+
+	mu                         sync.Mutex
+	currentValue, defaultValue []int
+
+	// listenUpdates is the channel used to keep tabs of the updates.
+	listenUpdates *comms.AddressChan[[]int]
+	firstUpdate   *common.Latch // If first update received.
+}
+
+// CheckboxGroup returns a builder object that builds a group of checkboxes, one for each of the options given.
+//
+// Values (used for `Listen`, `Value` and `SetValue`) are the (sorted) indices of the options checked.
+//
+// Call `Done` method when you finish configuring the CheckboxGroupBuilder.
+func CheckboxGroup(options []string) *CheckboxGroupBuilder {
+	return &CheckboxGroupBuilder{
+		address:     newAddress("checkbox"),
+		options:     options,
+		htmlId:      "gonb_checkbox_" + gonbui.UniqueId(),
+		firstUpdate: common.NewLatch(),
+	}
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// This can only be set before call to Done. If called afterward, it panics.
+func (b *CheckboxGroupBuilder) WithHtmlId(htmlId string) *CheckboxGroupBuilder {
+	if b.built {
+		panicf("CheckboxGroupBuilder cannot change parameters after it is built")
+	}
+	b.htmlId = htmlId
+	return b
+}
+
+// WithAddress configures the widget to use the given address to communicate its state
+// with the front-end.
+//
+// The default is to use a randomly created unique address, in the comms.ExecutionScope.
+// The address given is in the scope set with SetScope, if any.
+//
+// It panics if called after the widget is built.
+func (b *CheckboxGroupBuilder) WithAddress(address string) *CheckboxGroupBuilder {
+	if b.built {
+		panicf("CheckboxGroupBuilder cannot change parameters after it is built")
+	}
+	b.address = scopedAddress(address)
+	return b
+}
+
+// SetDefault sets the indices of the options initially checked. If not set, none are checked.
+//
+// It panics if called after the widget is built.
+func (b *CheckboxGroupBuilder) SetDefault(indices ...int) *CheckboxGroupBuilder {
+	if b.built {
+		panicf("CheckboxGroupBuilder cannot change parameters after it is built")
+	}
+	b.defaultValue = indices
+	return b
+}
+
+// AppendTo defines an id of the parent element in the DOM (in the front-end)
+// where to insert the widget.
+//
+// If not defined, it will simply display it as default in the output of the cell.
+//
+// It panics if called after the widget is built.
+func (b *CheckboxGroupBuilder) AppendTo(parentHtmlId string) *CheckboxGroupBuilder {
+	if b.built {
+		panicf("CheckboxGroupBuilder cannot change parameters after it is built")
+	}
+	b.parentHtmlId = parentHtmlId
+	return b
+}
+
+// Done builds the HTML element in the frontend and starts listening to updates.
+//
+// After this is called options can no longer be set.
+//
+// The value associated with the widget can now be read or modified with `Value`, `SetValue` and
+// `Listen`.
+func (b *CheckboxGroupBuilder) Done() *CheckboxGroupBuilder {
+	if b.built {
+		panicf("CheckboxGroupBuilder.Done already called!?")
+	}
+	b.built = true
+
+	// Record incoming updates.
+	b.listenUpdates = comms.Listen[[]int](b.address)
+	go b.recordUpdates()
+
+	html := b.html()
+	if b.parentHtmlId == "" {
+		gonbui.DisplayHtml(html)
+	} else {
+		dom.Append(b.parentHtmlId, html)
+	}
+
+	var buf bytes.Buffer
+	data := struct {
+		Address, HtmlId string
+	}{
+		Address: b.address,
+		HtmlId:  b.htmlId,
+	}
+	err := tmplCheckboxJs.Execute(&buf, data)
+	if err != nil {
+		panicf("CheckboxGroup template is invalid!? Please report the error to GoNB: %v", err)
+	}
+	dom.TransientJavascript(buf.String())
+
+	b.firstUpdate.Wait()
+	return b
+}
+
+// recordUpdates records the options checked in the front-end, until listenUpdates is closed.
+func (b *CheckboxGroupBuilder) recordUpdates() {
+	for newValue := range b.listenUpdates.C {
+		b.firstUpdate.Trigger() // First update received, we are ready for business.
+		gonbui.Logf("CheckboxGroup(%s): checked %v", b.htmlId, newValue)
+		b.mu.Lock()
+		b.currentValue = newValue
+		b.mu.Unlock()
+	}
+}
+
+// html returns the HTML of the checkboxes, with the default ones checked.
+func (b *CheckboxGroupBuilder) html() string {
+	parts := make([]string, 0, len(b.options)+2)
+	parts = append(parts, fmt.Sprintf(`<div id="%s" class="gonb-checkbox-group">`, b.htmlId))
+	for ii, option := range b.options {
+		var checked string
+		if slices.Contains(b.defaultValue, ii) {
+			checked = ` checked`
+		}
+		parts = append(parts, fmt.Sprintf(`<label><input type="checkbox" value="%d"%s> %s</label>`, ii, checked, option))
+	}
+	parts = append(parts, "</div>")
+	return strings.Join(parts, "\n")
+}
+
+// Listen returns an `AddressChannel[[]int]` (a wrapper for a `chan []int`) that receives the indices of the
+// options checked, each time they change.
+//
+// Close the returned channel (`Close()` method) to unsubscribe from these messages and release the resources.
+//
+// It can only be called after the CheckboxGroup is created with Done, otherwise it panics.
+func (b *CheckboxGroupBuilder) Listen() *comms.AddressChan[[]int] {
+	if !b.built {
+		panicf("CheckboxGroupBuilder.Listen can only be called after the widget was created with `Done()` method")
+	}
+	return comms.Listen[[]int](b.address)
+}
+
+// HtmlId returns the `id` used in the widget HTML element created.
+func (b *CheckboxGroupBuilder) HtmlId() string {
+	return b.htmlId
+}
+
+// Address returns the address used to communicate to the widgets HTML element.
+func (b *CheckboxGroupBuilder) Address() string {
+	return b.address
+}
+
+// Value returns the indices of the options currently checked.
+func (b *CheckboxGroupBuilder) Value() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.currentValue)
+}
+
+// SetValue sets the options checked, given by their indices, communicating that with the UI.
+func (b *CheckboxGroupBuilder) SetValue(indices []int) {
+	indices = slices.Clone(indices)
+	slices.Sort(indices)
+	sendChecked(b.address, indices)
+	b.mu.Lock()
+	b.currentValue = indices
+	b.mu.Unlock()
+}
@@ -0,0 +1,29 @@
+(() => {
+    let gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        console.error("Communication to GoNB not setup, checkbox group will not synchronize with program.")
+        return;
+    }
+    const div = document.getElementById("{{.HtmlId}}");
+    const checkboxes = Array.from(div.querySelectorAll("input[type=checkbox]"));
+
+    // checked returns the indices of the options checked.
+    function checked() {
+        return checkboxes.filter((cb) => cb.checked).map((cb) => +cb.value);
+    }
+
+    let checkedValue = gonb_comm.newSyncedVariable("{{.Address}}", checked());
+    checkboxes.forEach((cb) => {
+        cb.addEventListener("change", function() {
+            cb.toggleAttribute("checked", cb.checked);  // Makes value available when reading `outerHTML`.
+            checkedValue.set(checked());
+        });
+    });
+    checkedValue.subscribe((value) => {
+        const indices = new Set(value || []);
+        checkboxes.forEach((cb) => {
+            cb.checked = indices.has(+cb.value);
+            cb.toggleAttribute("checked", cb.checked);  // Makes value available when reading `outerHTML`.
+        });
+    })
+})();
@@ -0,0 +1,57 @@
+package widgets
+
+import (
+	"encoding/json"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCheckboxGroup(t *testing.T) {
+	var sent [][]int
+	previousSend := sendChecked
+	sendChecked = func(_ string, indices []int) { sent = append(sent, indices) }
+	defer func() { sendChecked = previousSend }()
+
+	b := CheckboxGroup([]string{"a", "b", "c"}).SetDefault(2, 0)
+	html := b.html()
+	assert.Contains(t, html, `<input type="checkbox" value="0" checked> a`)
+	assert.Contains(t, html, `<input type="checkbox" value="1"> b`)
+	assert.Contains(t, html, `<input type="checkbox" value="2" checked> c`)
+
+	// The front-end sends the indices checked as a JSON array, converted to []int.
+	var fromFrontEnd any
+	require.NoError(t, json.Unmarshal([]byte(`[0, 2]`), &fromFrontEnd))
+	indices, err := comms.ConvertTo[[]int](fromFrontEnd)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 2}, indices)
+	_, err = comms.ConvertTo[[]int]([]any{"b"})
+	assert.Error(t, err, "indices must be numbers")
+
+	// Updates from the front-end are recorded, the first one making the widget ready.
+	b.listenUpdates = comms.Listen[[]int]("/test/checkbox")
+	defer b.listenUpdates.Close()
+	go b.recordUpdates()
+	assert.False(t, b.firstUpdate.Test())
+	b.listenUpdates.C <- indices
+	require.Eventually(t, b.firstUpdate.Test, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return len(b.Value()) == 2 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []int{0, 2}, b.Value())
+	b.listenUpdates.C <- []int{}
+	require.Eventually(t, func() bool { return len(b.Value()) == 0 }, 5*time.Second, time.Millisecond)
+
+	// Value returns a copy.
+	b.listenUpdates.C <- []int{1}
+	require.Eventually(t, func() bool { return len(b.Value()) == 1 }, 5*time.Second, time.Millisecond)
+	b.Value()[0] = 2
+	assert.Equal(t, []int{1}, b.Value())
+
+	// SetValue sends the indices sorted, without changing the ones given.
+	given := []int{2, 0}
+	b.SetValue(given)
+	assert.Equal(t, []int{2, 0}, given)
+	assert.Equal(t, [][]int{{0, 2}}, sent)
+	assert.Equal(t, []int{0, 2}, b.Value())
+}
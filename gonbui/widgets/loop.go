@@ -0,0 +1,217 @@
+package widgets
+
+import (
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// ErrDisconnected is returned by Loop.Run when the connection with the front-end is lost, see
+// Loop.StopOnDisconnect.
+var ErrDisconnected = errors.New("widgets: connection with the front-end lost")
+
+// Notifications used by Loop, replaced in tests.
+var (
+	onInterrupt        = gonbui.OnInterrupt
+	onCommsStateChange = gonbui.OnCommsStateChange
+)
+
+// Loop is an event loop for programs driven by widgets (e.g.: interactive dashboards): it calls the handlers
+// of the values received from the widgets (see OnValue) and of the periodic ticks (see Every), one at a time,
+// until it is stopped.
+//
+// It stops when Stop is called, when the cell is interrupted, or, if configured with StopOnDisconnect, when the
+// connection with the front-end is lost. Example:
+//
+//	slider := widgets.Slider(0, 100, 50).Done()
+//	button := widgets.Button("Stop").Done()
+//	loop := widgets.NewLoop()
+//	widgets.OnValue(loop, slider.Listen(), func(value int) { redraw(value) })
+//	widgets.OnValue(loop, button.Listen(), func(int) { loop.Stop() })
+//	loop.Every(time.Second, func() { updateClock() })
+//	if err := loop.Run(); err != nil {
+//		fmt.Printf("Stopped: %v\n", err)
+//	}
+//
+// Since the handlers are called sequentially, they don't need to synchronize among themselves, but they should
+// return quickly, or the other events are delayed.
+type Loop struct {
+	// events to be executed by Run, posted by the goroutines of the sources (see OnValue and Every).
+	events chan func()
+
+	muStop  sync.Mutex
+	done    chan struct{} // Closed when stopped.
+	stopped bool
+	stopErr error // Returned by Run.
+	running bool
+	closers []func() // Called when Run returns.
+
+	// See StopOnDisconnect.
+	stopOnDisconnect bool
+	grace            time.Duration
+	disconnectTimer  *time.Timer
+}
+
+// NewLoop creates a new event loop. Configure it with OnValue, Every and StopOnDisconnect, and then call Run.
+func NewLoop() *Loop {
+	return &Loop{
+		events: make(chan func()),
+		done:   make(chan struct{}),
+	}
+}
+
+// OnValue registers handler to be called by the loop with each value received in the channel -- e.g.: from the
+// `Listen` method of a widget. The channel is closed when the loop stops.
+//
+// It is a function, and not a method of Loop, because Go doesn't support generic methods. It returns the loop,
+// to allow cascaded settings.
+func OnValue[T protocol.CommValueTypes](loop *Loop, ch *comms.AddressChan[T], handler func(value T)) *Loop {
+	loop.addCloser(func() {
+		if !ch.IsClosed() {
+			ch.Close()
+		}
+	})
+	go func() {
+		for value := range ch.C {
+			value := value // The event is executed after the next value is received.
+			if !loop.post(func() { handler(value) }) {
+				return
+			}
+		}
+	}()
+	return loop
+}
+
+// Every registers handler to be called by the loop periodically, every period -- e.g.: to refresh a display.
+// Ticks are dropped if the loop is busy, as with `time.Ticker`.
+//
+// It returns the loop, to allow cascaded settings.
+func (l *Loop) Every(period time.Duration, handler func()) *Loop {
+	ticker := time.NewTicker(period)
+	l.addCloser(ticker.Stop)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if !l.post(handler) {
+					return
+				}
+			case <-l.done:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// StopOnDisconnect configures the loop to stop, with ErrDisconnected, if the connection with the front-end is lost
+// (e.g.: the notebook page is closed) and not re-established within grace -- a page reload usually reconnects
+// in a few seconds. See `gonbui.OnCommsStateChange`.
+//
+// It returns the loop, to allow cascaded settings.
+func (l *Loop) StopOnDisconnect(grace time.Duration) *Loop {
+	l.muStop.Lock()
+	defer l.muStop.Unlock()
+	if l.stopOnDisconnect {
+		l.grace = grace
+		return l
+	}
+	l.stopOnDisconnect, l.grace = true, grace
+	onCommsStateChange(func(connected bool) {
+		l.muStop.Lock()
+		defer l.muStop.Unlock()
+		if l.stopped {
+			return
+		}
+		if connected {
+			if l.disconnectTimer != nil {
+				l.disconnectTimer.Stop()
+				l.disconnectTimer = nil
+			}
+			return
+		}
+		if l.disconnectTimer == nil {
+			l.disconnectTimer = time.AfterFunc(l.grace, func() { l.stopWith(ErrDisconnected) })
+		}
+	})
+	return l
+}
+
+// Run the loop until it is stopped: it returns nil if stopped with Stop, `gonbui.ErrInterrupted` if the cell is
+// interrupted, or ErrDisconnected (see StopOnDisconnect).
+//
+// When it returns, the channels registered with OnValue are closed, and the tickers stopped. It can only be
+// called once, otherwise it panics.
+func (l *Loop) Run() error {
+	l.muStop.Lock()
+	if l.running {
+		l.muStop.Unlock()
+		panicf("widgets.Loop.Run can only be called once")
+	}
+	l.running = true
+	l.muStop.Unlock()
+
+	// The program no longer exits immediately when the cell is interrupted: the loop stops instead.
+	onInterrupt(func() { l.stopWith(gonbui.ErrInterrupted) })
+
+	for {
+		select {
+		case event := <-l.events:
+			event()
+		case <-l.done:
+			l.muStop.Lock()
+			closers, err := l.closers, l.stopErr
+			l.closers = nil
+			if l.disconnectTimer != nil {
+				l.disconnectTimer.Stop()
+			}
+			l.muStop.Unlock()
+			for _, closer := range closers {
+				closer()
+			}
+			return err
+		}
+	}
+}
+
+// Stop the loop: Run returns nil once the handler being executed, if any, returns. It is safe to call from the
+// handlers, or concurrently.
+func (l *Loop) Stop() {
+	l.stopWith(nil)
+}
+
+// stopWith stops the loop, with the error returned by Run. Only the first call has effect.
+func (l *Loop) stopWith(err error) {
+	l.muStop.Lock()
+	defer l.muStop.Unlock()
+	if l.stopped {
+		return
+	}
+	l.stopped, l.stopErr = true, err
+	close(l.done)
+}
+
+// addCloser registers a function called when the loop stops. If it has already stopped, it is called right away.
+func (l *Loop) addCloser(closer func()) {
+	l.muStop.Lock()
+	if !l.stopped {
+		l.closers = append(l.closers, closer)
+		l.muStop.Unlock()
+		return
+	}
+	l.muStop.Unlock()
+	closer()
+}
+
+// post the event to be executed by the loop. It returns false if the loop stopped.
+func (l *Loop) post(event func()) bool {
+	select {
+	case l.events <- event:
+		return true
+	case <-l.done:
+		return false
+	}
+}
@@ -0,0 +1,137 @@
+package widgets
+
+import (
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// fakeNotifications replaces the interrupt and comms state notifications of gonbui: the handlers registered
+// by the loop are sent to the returned channels.
+func fakeNotifications(t *testing.T) (interrupts chan func(), commsStates chan func(bool)) {
+	interrupts, commsStates = make(chan func(), 10), make(chan func(bool), 10)
+	previousInterrupt, previousCommsState := onInterrupt, onCommsStateChange
+	onInterrupt = func(handler func()) { interrupts <- handler }
+	onCommsStateChange = func(handler func(bool)) { commsStates <- handler }
+	t.Cleanup(func() { onInterrupt, onCommsStateChange = previousInterrupt, previousCommsState })
+	return
+}
+
+// runLoop runs the loop in a goroutine, returning the channel with the result of Run.
+func runLoop(loop *Loop) chan error {
+	result := make(chan error, 1)
+	go func() { result <- loop.Run() }()
+	return result
+}
+
+// waitResult waits for the result of Run.
+func waitResult(t *testing.T, result chan error) error {
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop didn't stop")
+		return nil
+	}
+}
+
+func TestLoopValues(t *testing.T) {
+	fakeNotifications(t)
+	numbers := comms.Listen[int]("/test/loop/numbers")
+	words := comms.Listen[string]("/test/loop/words")
+	loop := NewLoop()
+	var gotNumbers []int
+	var gotWords []string
+	OnValue(loop, numbers, func(value int) { gotNumbers = append(gotNumbers, value) })
+	OnValue(loop, words, func(value string) {
+		gotWords = append(gotWords, value)
+		if value == "stop" {
+			loop.Stop()
+		}
+	})
+	result := runLoop(loop)
+	numbers.C <- 1
+	words.C <- "a"
+	numbers.C <- 2
+	numbers.C <- 3 // Blocks until 2 was handled.
+	words.C <- "stop"
+	require.NoError(t, waitResult(t, result))
+	assert.Equal(t, []int{1, 2}, gotNumbers[:2])
+	assert.Equal(t, []string{"a", "stop"}, gotWords)
+
+	// Channels are closed when the loop stops.
+	assert.True(t, numbers.IsClosed())
+	assert.True(t, words.IsClosed())
+
+	// Registered after it stopped: closed right away.
+	late := comms.Listen[int]("/test/loop/late")
+	OnValue(loop, late, func(int) { t.Error("handler called after the loop stopped") })
+	assert.True(t, late.IsClosed())
+
+	assert.Panics(t, func() { _ = loop.Run() }, "Run can only be called once")
+}
+
+func TestLoopTicks(t *testing.T) {
+	fakeNotifications(t)
+	loop := NewLoop()
+	ticks := 0
+	loop.Every(time.Millisecond, func() {
+		ticks++
+		if ticks == 3 {
+			loop.Stop()
+		}
+	})
+	require.NoError(t, waitResult(t, runLoop(loop)))
+	assert.Equal(t, 3, ticks)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 3, ticks, "ticks after the loop stopped")
+
+	// Stopped before running.
+	loop = NewLoop()
+	loop.Every(time.Hour, func() { t.Error("unexpected tick") })
+	loop.Stop()
+	loop.Stop() // No-op.
+	require.NoError(t, waitResult(t, runLoop(loop)))
+}
+
+func TestLoopInterrupt(t *testing.T) {
+	interrupts, _ := fakeNotifications(t)
+	values := comms.Listen[int]("/test/loop/interrupt")
+	loop := NewLoop()
+	OnValue(loop, values, func(int) {})
+	result := runLoop(loop)
+	interrupt := <-interrupts
+	values.C <- 1 // Still running.
+	interrupt()
+	assert.ErrorIs(t, waitResult(t, result), gonbui.ErrInterrupted)
+	assert.True(t, values.IsClosed())
+}
+
+func TestLoopStopOnDisconnect(t *testing.T) {
+	_, commsStates := fakeNotifications(t)
+	const grace = 20 * time.Millisecond
+	loop := NewLoop().StopOnDisconnect(time.Hour).StopOnDisconnect(grace) // Only the last grace is used.
+	stateChange := <-commsStates
+	assert.Empty(t, commsStates, "comms state handler registered more than once")
+	result := runLoop(loop)
+
+	// Reconnected within the grace period.
+	stateChange(false)
+	stateChange(false)
+	stateChange(true)
+	select {
+	case err := <-result:
+		t.Fatalf("loop stopped with %v, even though the connection was re-established", err)
+	case <-time.After(3 * grace):
+	}
+
+	// Disconnected for longer than the grace period.
+	start := time.Now()
+	stateChange(false)
+	assert.ErrorIs(t, waitResult(t, result), ErrDisconnected)
+	assert.GreaterOrEqual(t, time.Since(start), grace)
+	stateChange(true) // Ignored after stopped.
+}
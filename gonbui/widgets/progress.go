@@ -0,0 +1,193 @@
+package widgets
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/dom"
+	"text/template"
+)
+
+//go:embed progress.js
+var progressJs []byte
+
+var tmplProgressJs = template.Must(template.New("progressJs").Parse(
+	string(progressJs)))
+
+// sendProgress sends the progress to the front-end, replaced in tests.
+var sendProgress = comms.SendReliable[float64]
+
+// ProgressBarBuilder is used to create a progress bar on the front-end.
+type ProgressBarBuilder struct {
+	address, label, htmlId, parentHtmlId string
+	built                                bool
+
+	// Parameters of the progress bar.
+	max           float64
+	indeterminate bool
+
+	// ready is triggered when the front-end is ready to receive updates.
+	ready *common.Latch
+}
+
+// ProgressBar returns a builder object that builds a new progress bar, that goes from 0 to `max`.
+//
+// The progress is updated (e.g.: from a running program) with SetValue, or set to an indeterminate state
+// (an animation indicating that something is happening) with SetIndeterminate.
+//
+// Call `Done` method when you finish configuring the ProgressBarBuilder.
+func ProgressBar(max float64) *ProgressBarBuilder {
+	return &ProgressBarBuilder{
+		max:     max,
+		address: newAddress("progress"),
+		htmlId:  "gonb_progress_" + gonbui.UniqueId(),
+		ready:   common.NewLatch(),
+	}
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// This can only be set before call to Done. If called afterward, it panics.
+func (b *ProgressBarBuilder) WithHtmlId(htmlId string) *ProgressBarBuilder {
+	if b.built {
+		panicf("ProgressBarBuilder cannot change parameters after it is built")
+	}
+	b.htmlId = htmlId
+	return b
+}
+
+// WithAddress configures the widget to use the given address to communicate its state
+// with the front-end.
+//
+// The default is to use a randomly created unique address, in the comms.ExecutionScope.
+// The address given is in the scope set with SetScope, if any.
+//
+// It panics if called after the widget is built.
+func (b *ProgressBarBuilder) WithAddress(address string) *ProgressBarBuilder {
+	if b.built {
+		panicf("ProgressBarBuilder cannot change parameters after it is built")
+	}
+	b.address = scopedAddress(address)
+	return b
+}
+
+// WithLabel sets a label displayed before the progress bar.
+//
+// It panics if called after the widget is built.
+func (b *ProgressBarBuilder) WithLabel(label string) *ProgressBarBuilder {
+	if b.built {
+		panicf("ProgressBarBuilder cannot change parameters after it is built")
+	}
+	b.label = label
+	return b
+}
+
+// Indeterminate makes the progress bar start in the indeterminate state, until the first call to SetValue.
+//
+// It panics if called after the widget is built.
+func (b *ProgressBarBuilder) Indeterminate() *ProgressBarBuilder {
+	if b.built {
+		panicf("ProgressBarBuilder cannot change parameters after it is built")
+	}
+	b.indeterminate = true
+	return b
+}
+
+// AppendTo defines an id of the parent element in the DOM (in the front-end)
+// where to insert the widget.
+//
+// If not defined, it will simply display it as default in the output of the cell.
+//
+// It panics if called after the widget is built.
+func (b *ProgressBarBuilder) AppendTo(parentHtmlId string) *ProgressBarBuilder {
+	if b.built {
+		panicf("ProgressBarBuilder cannot change parameters after it is built")
+	}
+	b.parentHtmlId = parentHtmlId
+	return b
+}
+
+// Done builds the HTML element in the frontend, and waits for it to be ready to receive updates.
+//
+// After this is called options can no longer be set, and the progress can be updated with SetValue and
+// SetIndeterminate.
+func (b *ProgressBarBuilder) Done() *ProgressBarBuilder {
+	if b.built {
+		panicf("ProgressBarBuilder.Done already called!?")
+	}
+	b.built = true
+
+	readyChan := comms.Listen[int](b.address + "/ready")
+	html := b.html()
+	if b.parentHtmlId == "" {
+		gonbui.DisplayHtml(html)
+	} else {
+		dom.Append(b.parentHtmlId, html)
+	}
+
+	var buf bytes.Buffer
+	data := struct {
+		Address, HtmlId string
+	}{
+		Address: b.address,
+		HtmlId:  b.htmlId,
+	}
+	err := tmplProgressJs.Execute(&buf, data)
+	if err != nil {
+		panicf("ProgressBar template is invalid!? Please report the error to GoNB: %v", err)
+	}
+	dom.TransientJavascript(buf.String())
+
+	<-readyChan.C // Front-end is subscribed to the updates.
+	readyChan.Close()
+	b.ready.Trigger()
+	return b
+}
+
+// html returns the HTML of the progress bar: without a value if it starts indeterminate.
+func (b *ProgressBarBuilder) html() string {
+	value := ` value="0"`
+	if b.indeterminate {
+		value = ""
+	}
+	return fmt.Sprintf(`<div id="%s" class="gonb-progress"><label>%s <progress max="%g"%s></progress></label> <span class="gonb-progress-status"></span></div>`,
+		b.htmlId, b.label, b.max, value)
+}
+
+// SetValue sets the progress, from 0 to the `max` given when the progress bar was created.
+// If it was indeterminate, it becomes determinate.
+//
+// It can only be called after the ProgressBar is created with Done, otherwise it panics.
+func (b *ProgressBarBuilder) SetValue(value float64) {
+	if !b.built {
+		panicf("ProgressBarBuilder.SetValue can only be called after the widget was created with `Done()` method")
+	}
+	b.ready.Wait()
+	sendProgress(b.address, max(value, 0))
+}
+
+// SetIndeterminate changes the progress bar to an indeterminate state, an animation indicating that something
+// is happening. Call SetValue to make it determinate again.
+//
+// It can only be called after the ProgressBar is created with Done, otherwise it panics.
+func (b *ProgressBarBuilder) SetIndeterminate() {
+	if !b.built {
+		panicf("ProgressBarBuilder.SetIndeterminate can only be called after the widget was created with `Done()` method")
+	}
+	b.ready.Wait()
+	sendProgress(b.address, -1.0) // Negative values are indeterminate in the front-end.
+}
+
+// HtmlId returns the `id` used in the widget HTML element created.
+func (b *ProgressBarBuilder) HtmlId() string {
+	return b.htmlId
+}
+
+// Address returns the address used to communicate to the widgets HTML element.
+func (b *ProgressBarBuilder) Address() string {
+	return b.address
+}
@@ -0,0 +1,22 @@
+(() => {
+    let gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        console.error("Communication to GoNB not setup, progress bar will not be updated.")
+        return;
+    }
+    const div = document.getElementById("{{.HtmlId}}");
+    const progress = div.querySelector("progress");
+    const status = div.querySelector(".gonb-progress-status");
+    gonb_comm.subscribe("{{.Address}}", (address, value) => {
+        if (value < 0) {
+            // Indeterminate.
+            progress.removeAttribute("value");
+            status.textContent = "";
+            return;
+        }
+        progress.value = value;
+        progress.setAttribute("value", value);  // Makes value available when reading `outerHTML`.
+        status.textContent = `${Math.floor(100 * value / progress.max)}%`;
+    });
+    gonb_comm.send("{{.Address}}/ready", 1);
+})();
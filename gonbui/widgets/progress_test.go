@@ -0,0 +1,32 @@
+package widgets
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProgressBar(t *testing.T) {
+	var sent []float64
+	previousSend := sendProgress
+	sendProgress = func(_ string, value float64) { sent = append(sent, value) }
+	defer func() { sendProgress = previousSend }()
+
+	b := ProgressBar(10).WithLabel("Training")
+	assert.Contains(t, b.html(), `<label>Training <progress max="10" value="0"></progress></label>`)
+	b = ProgressBar(10).Indeterminate()
+	assert.Contains(t, b.html(), `<progress max="10"></progress>`, "indeterminate progress bars have no value")
+
+	// Negative values are indeterminate in the front-end: only SetIndeterminate sends them.
+	b.built = true
+	b.ready.Trigger()
+	b.SetValue(3)
+	b.SetValue(-2)
+	b.SetIndeterminate()
+	b.SetValue(10)
+	assert.Equal(t, []float64{3, 0, -1, 10}, sent)
+
+	// Updates before the widget is built panic.
+	b = ProgressBar(10)
+	assert.Panics(t, func() { b.SetValue(1) })
+	assert.Panics(t, func() { b.SetIndeterminate() })
+}
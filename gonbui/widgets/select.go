@@ -8,6 +8,7 @@ import (
 	"github.com/janpfeifer/gonb/gonbui"
 	"github.com/janpfeifer/gonb/gonbui/comms"
 	"github.com/janpfeifer/gonb/gonbui/dom"
+	"log"
 	"strings"
 	"text/template"
 )
@@ -28,6 +29,9 @@ type SelectBuilder struct {
 	options                    []string
 	currentValue, defaultValue int
 
+	// spec validates the values of the address.
+	spec *comms.Spec[int]
+
 	// listenUpdates is the channel used to keep tabs of the updates.
 	listenUpdates *comms.AddressChan[int]
 	firstUpdate   *common.Latch // If first update received.
@@ -42,7 +46,7 @@ type SelectBuilder struct {
 // Call `Done` method when you finish configuring the SelectBuilder.
 func Select(options []string) *SelectBuilder {
 	return &SelectBuilder{
-		address:     "/select/" + gonbui.UniqueId(),
+		address:     newAddress("select"),
 		options:     options,
 		htmlId:      "gonb_select_" + gonbui.UniqueId(),
 		firstUpdate: common.NewLatch(),
@@ -64,14 +68,15 @@ func (b *SelectBuilder) WithHtmlId(htmlId string) *SelectBuilder {
 // WithAddress configures the widget to use the given address to communicate its state
 // with the front-end.
 //
-// The default is to use a randomly created unique address.
+// The default is to use a randomly created unique address, in the comms.ExecutionScope.
+// The address given is in the scope set with SetScope, if any.
 //
 // It panics if called after the widget is built.
 func (b *SelectBuilder) WithAddress(address string) *SelectBuilder {
 	if b.built {
 		panicf("SelectBuilder cannot change parameters after it is built")
 	}
-	b.address = address
+	b.address = scopedAddress(address)
 	return b
 }
 
@@ -112,9 +117,10 @@ func (b *SelectBuilder) Done() *SelectBuilder {
 		panicf("SelectBuilder.Done already called!?")
 	}
 	b.built = true
+	b.spec = comms.Declare[int](b.address).WithRange(0, float64(len(b.options)-1))
 
 	// Record incoming slider updates.
-	b.listenUpdates = comms.Listen[int](b.address)
+	b.listenUpdates = b.spec.Listen()
 	go func() {
 		for newValue := range b.listenUpdates.C {
 			b.firstUpdate.Trigger() // First update received, we are ready for business.
@@ -188,7 +194,16 @@ func (b *SelectBuilder) Value() int {
 }
 
 // SetValue sets the value of the widget, communicating that with the UI.
+//
+// Once the widget is created (see Done), values out of range are clamped, with a warning.
 func (b *SelectBuilder) SetValue(value int) {
-	comms.Send(b.address, value)
+	if b.built {
+		var err error
+		if value, err = b.spec.Coerce(value); err != nil {
+			log.Printf("Warning: Select(%s).SetValue: %+v", b.htmlId, err)
+			return
+		}
+	}
+	comms.SendReliable(b.address, value)
 	b.currentValue = value
 }
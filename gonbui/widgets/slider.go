@@ -8,6 +8,7 @@ import (
 	"github.com/janpfeifer/gonb/gonbui"
 	"github.com/janpfeifer/gonb/gonbui/comms"
 	"github.com/janpfeifer/gonb/gonbui/dom"
+	"log"
 	"text/template"
 )
 
@@ -26,6 +27,9 @@ type SliderBuilder struct {
 	// Parameters of the slider.
 	min, max, currentValue int
 
+	// spec validates the values of the address.
+	spec *comms.Spec[int]
+
 	// listenUpdates is the channel used to keep tabs of the updates.
 	listenUpdates *comms.AddressChan[int]
 	firstUpdate   *common.Latch // If first update received.
@@ -43,7 +47,7 @@ func Slider(min, max, value int) *SliderBuilder {
 		min:          min,
 		max:          max,
 		currentValue: value,
-		address:      "/select/" + gonbui.UniqueId(),
+		address:      newAddress("slider"),
 		htmlId:       "gonb_slider_" + gonbui.UniqueId(),
 		firstUpdate:  common.NewLatch(),
 	}
@@ -64,14 +68,15 @@ func (b *SliderBuilder) WithHtmlId(htmlId string) *SliderBuilder {
 // WithAddress configures the widget to use the given address to communicate its state
 // with the front-end.
 //
-// The default is to use a randomly created unique address.
+// The default is to use a randomly created unique address, in the comms.ExecutionScope.
+// The address given is in the scope set with SetScope, if any.
 //
 // It panics if called after the widget is built.
 func (b *SliderBuilder) WithAddress(address string) *SliderBuilder {
 	if b.built {
 		panicf("SliderBuilder cannot change parameters after it is built")
 	}
-	b.address = address
+	b.address = scopedAddress(address)
 	return b
 }
 
@@ -100,9 +105,10 @@ func (b *SliderBuilder) Done() *SliderBuilder {
 		panicf("SliderBuilder.Done already called!?")
 	}
 	b.built = true
+	b.spec = comms.Declare[int](b.address).WithRange(float64(b.min), float64(b.max))
 
 	// Record incoming slider updates.
-	b.listenUpdates = comms.Listen[int](b.address)
+	b.listenUpdates = b.spec.Listen()
 	go func() {
 		for newValue := range b.listenUpdates.C {
 			b.firstUpdate.Trigger() // First update received, we are ready for business.
@@ -165,7 +171,16 @@ func (b *SliderBuilder) Value() int {
 }
 
 // SetValue sets the value of the widget, communicating that with the UI.
+//
+// Once the widget is created (see Done), values out of range are clamped, with a warning.
 func (b *SliderBuilder) SetValue(value int) {
-	comms.Send(b.address, value)
+	if b.built {
+		var err error
+		if value, err = b.spec.Coerce(value); err != nil {
+			log.Printf("Warning: Slider(%s).SetValue: %+v", b.htmlId, err)
+			return
+		}
+	}
+	comms.SendReliable(b.address, value)
 	b.currentValue = value
 }
@@ -0,0 +1,142 @@
+package widgets
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/dom"
+	"text/template"
+)
+
+//go:embed streamview.js
+var streamViewJs []byte
+
+var tmplStreamViewJs = template.Must(template.New("streamViewJs").Parse(
+	string(streamViewJs)))
+
+// StreamViewBuilder is used to create a view of a gonbui.Stream on the front-end.
+type StreamViewBuilder struct {
+	stream                             *gonbui.Stream
+	readyAddress, htmlId, parentHtmlId string
+	built                              bool
+
+	// Parameters of the view.
+	maxLines int
+	height   string
+}
+
+// StreamView returns a builder object that builds a view of the stream on the front-end: it displays the text
+// written to the stream as it arrives, following (scrolling to) the end, like `tail -f`.
+//
+// Call `Done` method when you finish configuring the StreamViewBuilder, before writing to the stream: text written
+// before the view is ready is not displayed. Example:
+//
+//	metrics := gonbui.NewStream("metrics")
+//	widgets.StreamView(metrics).MaxLines(100).Done()
+//	for step := range numSteps {
+//		fmt.Fprintf(metrics, "step=%d loss=%g\n", step, train())
+//	}
+func StreamView(stream *gonbui.Stream) *StreamViewBuilder {
+	return &StreamViewBuilder{
+		stream:       stream,
+		readyAddress: newAddress("stream_view") + "/ready",
+		htmlId:       "gonb_stream_view_" + gonbui.UniqueId(),
+		maxLines:     1000,
+		height:       "20em",
+	}
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// This can only be set before call to Done. If called afterward, it panics.
+func (b *StreamViewBuilder) WithHtmlId(htmlId string) *StreamViewBuilder {
+	if b.built {
+		panicf("StreamViewBuilder cannot change parameters after it is built")
+	}
+	b.htmlId = htmlId
+	return b
+}
+
+// MaxLines sets the number of lines kept in the view: older lines are dropped. If <= 0, all lines are kept.
+// The default is 1000.
+//
+// It panics if called after the widget is built.
+func (b *StreamViewBuilder) MaxLines(maxLines int) *StreamViewBuilder {
+	if b.built {
+		panicf("StreamViewBuilder cannot change parameters after it is built")
+	}
+	b.maxLines = maxLines
+	return b
+}
+
+// Height sets the maximum height of the view, as a CSS length. The default is "20em".
+//
+// It panics if called after the widget is built.
+func (b *StreamViewBuilder) Height(height string) *StreamViewBuilder {
+	if b.built {
+		panicf("StreamViewBuilder cannot change parameters after it is built")
+	}
+	b.height = height
+	return b
+}
+
+// AppendTo defines an id of the parent element in the DOM (in the front-end)
+// where to insert the widget.
+//
+// If not defined, it will simply display it as default in the output of the cell.
+//
+// It panics if called after the widget is built.
+func (b *StreamViewBuilder) AppendTo(parentHtmlId string) *StreamViewBuilder {
+	if b.built {
+		panicf("StreamViewBuilder cannot change parameters after it is built")
+	}
+	b.parentHtmlId = parentHtmlId
+	return b
+}
+
+// Done builds the HTML element in the frontend, and waits for it to be subscribed to the stream.
+//
+// After this is called options can no longer be set.
+func (b *StreamViewBuilder) Done() *StreamViewBuilder {
+	if b.built {
+		panicf("StreamViewBuilder.Done already called!?")
+	}
+	b.built = true
+
+	readyChan := comms.Listen[int](b.readyAddress)
+	html := fmt.Sprintf(`<div id="%s" class="gonb-stream-view"><pre style="max-height: %s; overflow-y: auto; margin: 0;"></pre><span class="gonb-stream-status"></span></div>`,
+		b.htmlId, b.height)
+	if b.parentHtmlId == "" {
+		gonbui.DisplayHtml(html)
+	} else {
+		dom.Append(b.parentHtmlId, html)
+	}
+
+	var buf bytes.Buffer
+	data := struct {
+		Address, ReadyAddress, HtmlId string
+		MaxLines                      int
+	}{
+		Address:      b.stream.Address(),
+		ReadyAddress: b.readyAddress,
+		HtmlId:       b.htmlId,
+		MaxLines:     b.maxLines,
+	}
+	err := tmplStreamViewJs.Execute(&buf, data)
+	if err != nil {
+		panicf("StreamView template is invalid!? Please report the error to GoNB: %v", err)
+	}
+	dom.TransientJavascript(buf.String())
+
+	<-readyChan.C // Front-end is subscribed to the stream.
+	readyChan.Close()
+	return b
+}
+
+// HtmlId returns the `id` used in the widget HTML element created.
+func (b *StreamViewBuilder) HtmlId() string {
+	return b.htmlId
+}
@@ -0,0 +1,40 @@
+(() => {
+    let gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        console.error("Communication to GoNB not setup, stream view will not be updated.")
+        return;
+    }
+    const div = document.getElementById("{{.HtmlId}}");
+    const pre = div.querySelector("pre");
+    const status = div.querySelector(".gonb-stream-status");
+    const maxLines = {{.MaxLines}};
+    let text = "";
+    const subscription = gonb_comm.subscribe("{{.Address}}", (address, value) => {
+        if (!div.isConnected) {
+            // View was removed (e.g.: the cell output was cleared).
+            gonb_comm.unsubscribe(subscription);
+            return;
+        }
+        if (value?.closed) {
+            status.textContent = "(stream closed)";
+            return;
+        }
+        if (typeof value?.data !== "string") {
+            return;
+        }
+        text += value.data;
+        if (maxLines > 0) {
+            // Keep only the last maxLines complete lines, plus the line being written.
+            const lines = text.split("\n");
+            if (lines.length > maxLines + 1) {
+                text = lines.slice(lines.length - maxLines - 1).join("\n");
+            }
+        }
+        const follow = pre.scrollTop + pre.clientHeight >= pre.scrollHeight - 4;
+        pre.textContent = text;
+        if (follow) {
+            pre.scrollTop = pre.scrollHeight;
+        }
+    });
+    gonb_comm.send("{{.ReadyAddress}}", 1);
+})();
@@ -0,0 +1,278 @@
+package widgets
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"github.com/janpfeifer/gonb/gonbui/dom"
+	"github.com/pkg/errors"
+	"html"
+	"io"
+	"text/template"
+)
+
+//go:embed upload.js
+var uploadJs []byte
+
+var tmplUploadJs = template.Must(template.New("uploadJs").Parse(
+	string(uploadJs)))
+
+// DefaultUploadChunkSize is the default size of the chunks in which the files are sent by the FileUpload widget.
+const DefaultUploadChunkSize = 256 * 1024
+
+// FileUploadBuilder is used to create a file upload element on the front-end.
+type FileUploadBuilder struct {
+	address, label, htmlId, parentHtmlId string
+	accept                               string
+	multiple                             bool
+	chunkSize                            int
+	built                                bool
+
+	files chan *UploadedFile
+}
+
+// UploadedFile is a file selected by the user in a FileUpload widget.
+//
+// Its content is streamed from the browser in chunks as it is read, so large files can be processed without
+// holding them in memory. The browser only sends the next chunk after the previous one was received.
+type UploadedFile struct {
+	// Name of the file, without the directory.
+	Name string
+
+	// Size of the file in bytes.
+	Size int64
+
+	// MIMEType of the file as reported by the browser, it may be empty.
+	MIMEType string
+
+	reader *io.PipeReader
+}
+
+// Read implements io.Reader, reading the content of the file as it is received from the browser.
+func (f *UploadedFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Close stops reading the file: the rest of its content is discarded.
+func (f *UploadedFile) Close() error {
+	return f.reader.Close()
+}
+
+// uploadHeader is sent by the front-end before the content of each file.
+type uploadHeader struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Type string `json:"type"`
+}
+
+// FileUpload returns a builder object that builds a new file selection element, with the given `label`.
+//
+// The files selected by the user are received with `Files`.
+//
+// Call `Done` method when you finish configuring the FileUploadBuilder.
+func FileUpload(label string) *FileUploadBuilder {
+	return &FileUploadBuilder{
+		label:     label,
+		address:   newAddress("upload"),
+		htmlId:    "gonb_upload_" + gonbui.UniqueId(),
+		chunkSize: DefaultUploadChunkSize,
+	}
+}
+
+// WithHtmlId sets the id to use when creating the HTML element in the DOM.
+// If not set, a unique one will be generated, and can be read with HtmlId.
+//
+// This can only be set before call to Done. If called afterward, it panics.
+func (b *FileUploadBuilder) WithHtmlId(htmlId string) *FileUploadBuilder {
+	if b.built {
+		panicf("FileUploadBuilder cannot change parameters after it is built")
+	}
+	b.htmlId = htmlId
+	return b
+}
+
+// WithAddress configures the widget to use the given address to communicate its state
+// with the front-end.
+//
+// The default is to use a randomly created unique address, in the comms.ExecutionScope.
+// The address given is in the scope set with SetScope, if any.
+//
+// It panics if called after the widget is built.
+func (b *FileUploadBuilder) WithAddress(address string) *FileUploadBuilder {
+	if b.built {
+		panicf("FileUploadBuilder cannot change parameters after it is built")
+	}
+	b.address = scopedAddress(address)
+	return b
+}
+
+// WithAccept sets the types of files accepted, in the format of the `accept` attribute of HTML
+// file inputs, e.g.: ".csv,.tsv" or "image/*".
+//
+// It panics if called after the widget is built.
+func (b *FileUploadBuilder) WithAccept(accept string) *FileUploadBuilder {
+	if b.built {
+		panicf("FileUploadBuilder cannot change parameters after it is built")
+	}
+	b.accept = accept
+	return b
+}
+
+// Multiple allows the user to select more than one file at a time. They are received one after the other.
+//
+// It panics if called after the widget is built.
+func (b *FileUploadBuilder) Multiple() *FileUploadBuilder {
+	if b.built {
+		panicf("FileUploadBuilder cannot change parameters after it is built")
+	}
+	b.multiple = true
+	return b
+}
+
+// WithChunkSize sets the size of the chunks in which the files are sent. The default is DefaultUploadChunkSize.
+//
+// It panics if called after the widget is built.
+func (b *FileUploadBuilder) WithChunkSize(chunkSize int) *FileUploadBuilder {
+	if b.built {
+		panicf("FileUploadBuilder cannot change parameters after it is built")
+	}
+	if chunkSize <= 0 {
+		panicf("FileUploadBuilder.WithChunkSize(%d): chunk size must be > 0", chunkSize)
+	}
+	b.chunkSize = chunkSize
+	return b
+}
+
+// AppendTo defines an id of the parent element in the DOM (in the front-end)
+// where to insert the widget.
+//
+// If not defined, it will simply display it as default in the output of the cell.
+//
+// It panics if called after the widget is built.
+func (b *FileUploadBuilder) AppendTo(parentHtmlId string) *FileUploadBuilder {
+	if b.built {
+		panicf("FileUploadBuilder cannot change parameters after it is built")
+	}
+	b.parentHtmlId = parentHtmlId
+	return b
+}
+
+// Done builds the HTML element in the frontend and starts listening to the files selected.
+//
+// After this is called options can no longer be set, and the files can be received with Files.
+func (b *FileUploadBuilder) Done() *FileUploadBuilder {
+	if b.built {
+		panicf("FileUploadBuilder.Done already called!?")
+	}
+	b.built = true
+	b.files = make(chan *UploadedFile)
+	headers := comms.Listen[string](b.address + "/file")
+	chunks := comms.Listen[[]byte](b.address + "/chunk")
+	go b.receive(headers, chunks)
+
+	var attributes string
+	if b.accept != "" {
+		attributes += fmt.Sprintf(` accept="%s"`, html.EscapeString(b.accept))
+	}
+	if b.multiple {
+		attributes += " multiple"
+	}
+	htmlContent := fmt.Sprintf(`<div id="%s" class="gonb-upload"><label>%s <input type="file"%s></label> <span class="gonb-upload-status"></span></div>`,
+		b.htmlId, b.label, attributes)
+	if b.parentHtmlId == "" {
+		gonbui.DisplayHtml(htmlContent)
+	} else {
+		dom.Append(b.parentHtmlId, htmlContent)
+	}
+
+	var buf bytes.Buffer
+	data := struct {
+		Address, HtmlId string
+		ChunkSize       int
+	}{
+		Address:   b.address,
+		HtmlId:    b.htmlId,
+		ChunkSize: b.chunkSize,
+	}
+	err := tmplUploadJs.Execute(&buf, data)
+	if err != nil {
+		panicf("FileUpload template is invalid!? Please report the error to GoNB: %v", err)
+	}
+	dom.TransientJavascript(buf.String())
+	return b
+}
+
+// receive the files sent by the front-end: each one starts with a header, followed by its content in chunks.
+// Each message is acknowledged (with the number of bytes received so far), which tells the front-end to
+// send the next.
+func (b *FileUploadBuilder) receive(headers *comms.AddressChan[string], chunks *comms.AddressChan[[]byte]) {
+	ackAddress := b.address + "/ack"
+	var (
+		file     *UploadedFile
+		writer   *io.PipeWriter
+		received int64
+	)
+	for {
+		select {
+		case headerJson := <-headers.C:
+			if writer != nil {
+				// New file selected before the previous one finished.
+				_ = writer.CloseWithError(errors.Errorf("upload of %q interrupted", file.Name))
+				writer = nil
+			}
+			var header uploadHeader
+			if err := json.Unmarshal([]byte(headerJson), &header); err != nil {
+				gonbui.Logf("FileUpload(%s): invalid file header %q: %+v", b.htmlId, headerJson, err)
+				continue
+			}
+			gonbui.Logf("FileUpload(%s): receiving %q (%d bytes)", b.htmlId, header.Name, header.Size)
+			var reader *io.PipeReader
+			reader, writer = io.Pipe()
+			file = &UploadedFile{Name: header.Name, Size: header.Size, MIMEType: header.Type, reader: reader}
+			received = 0
+			b.files <- file
+			comms.Send(ackAddress, 0)
+			if header.Size == 0 {
+				_ = writer.Close()
+				writer = nil
+			}
+
+		case chunk := <-chunks.C:
+			if writer == nil {
+				continue
+			}
+			received += int64(len(chunk))
+			comms.Send(ackAddress, int(received))
+			// If the program closed the file, the error is ignored, and the rest of the file is discarded.
+			_, _ = writer.Write(chunk)
+			if received >= file.Size {
+				_ = writer.Close()
+				writer = nil
+			}
+		}
+	}
+}
+
+// Files returns the channel that receives the files selected by the user, in order. Their content must be
+// read (or the file closed) before the next file is received.
+//
+// It can only be called after the FileUpload is created with Done, otherwise it panics.
+func (b *FileUploadBuilder) Files() <-chan *UploadedFile {
+	if !b.built {
+		panicf("FileUploadBuilder.Files can only be called after the widget was created with `Done()` method")
+	}
+	return b.files
+}
+
+// HtmlId returns the `id` used in the widget HTML element created.
+func (b *FileUploadBuilder) HtmlId() string {
+	return b.htmlId
+}
+
+// Address returns the address used to communicate to the widgets HTML element.
+func (b *FileUploadBuilder) Address() string {
+	return b.address
+}
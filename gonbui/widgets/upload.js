@@ -0,0 +1,58 @@
+(() => {
+    let gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        console.error("Communication to GoNB not setup, file upload will not work.")
+        return;
+    }
+    const div = document.getElementById("{{.HtmlId}}");
+    const input = div.querySelector("input");
+    const status = div.querySelector(".gonb-upload-status");
+    const chunkSize = {{.ChunkSize}};
+
+    // Each message sent (the file header, and each chunk) is acknowledged by the program with the number of
+    // bytes received so far: only then the next is sent.
+    let ackResolve = null;
+    gonb_comm.subscribe("{{.Address}}/ack", (address, value) => {
+        if (ackResolve) {
+            const resolve = ackResolve;
+            ackResolve = null;
+            resolve(value);
+        }
+    });
+    let uploadId = 0;  // Incremented at each selection, interrupting previous uploads.
+
+    function send(address, value, buffers) {
+        const ack = new Promise((resolve) => { ackResolve = resolve; });
+        const waiting = setTimeout(() => {
+            status.textContent = "Waiting for the program: files are only received while it runs ...";
+        }, 3000);
+        gonb_comm.send(address, value, buffers);
+        return ack.finally(() => clearTimeout(waiting));
+    }
+
+    async function upload(file, id) {
+        status.textContent = `${file.name}: starting ...`;
+        await send("{{.Address}}/file", JSON.stringify({name: file.name, size: file.size, type: file.type}));
+        let offset = 0;
+        while (offset < file.size) {
+            if (id !== uploadId) {
+                return;
+            }
+            const chunk = await file.slice(offset, offset + chunkSize).arrayBuffer();
+            offset = await send("{{.Address}}/chunk", chunk.byteLength, [chunk]);
+            status.textContent = `${file.name}: ${Math.floor(100 * offset / file.size)}%`;
+        }
+        status.textContent = `${file.name}: ${file.size} bytes uploaded`;
+    }
+
+    input.addEventListener("change", async () => {
+        uploadId++;
+        const id = uploadId;
+        for (const file of Array.from(input.files)) {
+            if (id !== uploadId) {
+                return;
+            }
+            await upload(file, id);
+        }
+    });
+})();
@@ -12,7 +12,43 @@
 // building widgets.
 package widgets
 
-import "github.com/janpfeifer/gonb/common"
+import (
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui"
+	"github.com/janpfeifer/gonb/gonbui/comms"
+	"sync"
+)
 
 // panicf is an alias for common.Panicf.
 var panicf = common.Panicf
+
+var (
+	muScope sync.Mutex
+	scope   *comms.Scope
+)
+
+// SetScope sets the scope of the addresses configured with `WithAddress`, for the widgets created afterwards.
+//
+// Use `widgets.SetScope(comms.ExecutionScope())` so the addresses of the widgets don't collide with the ones
+// of previous executions of the cell, whose widgets may still be displayed. The default (nil) is to use
+// the addresses unchanged.
+func SetScope(s *comms.Scope) {
+	muScope.Lock()
+	defer muScope.Unlock()
+	scope = s
+}
+
+// newAddress returns a new unique address for a widget of the given kind, in the comms.ExecutionScope.
+func newAddress(kind string) string {
+	return comms.ExecutionScope().Address(kind + "/" + gonbui.UniqueId())
+}
+
+// scopedAddress returns the address in the scope set with SetScope, if any.
+func scopedAddress(address string) string {
+	muScope.Lock()
+	defer muScope.Unlock()
+	if scope == nil {
+		return address
+	}
+	return scope.Address(address)
+}
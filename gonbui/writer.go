@@ -0,0 +1,119 @@
+package gonbui
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"io"
+	"sync"
+)
+
+// This file implements the writing of the messages to GoNB: SendData encodes them (in the order of the calls),
+// and a single goroutine writes them to the named pipe, from a queue. So the display functions can be called
+// concurrently from several goroutines without their messages being interleaved, and a large message (e.g.: an
+// image) doesn't block the goroutines sending small ones while it is written -- only its own caller.
+//
+// With SetAsyncDisplay, the callers don't wait for their messages to be written at all.
+
+// WriteQueueSize is the maximum number of messages waiting to be written to GoNB: once it is reached, SendData
+// waits for the queue to have room, even with SetAsyncDisplay.
+const WriteQueueSize = 256
+
+// pendingWrite is a message encoded, waiting in the queue to be written.
+type pendingWrite struct {
+	frame []byte
+
+	// written, if not nil, is closed once the frame is written (or failed to).
+	written chan struct{}
+}
+
+var (
+	// writeQueue of the messages to be written to GoNB, and encodeBuf where they are encoded (by gonbEncoder).
+	writeQueue chan *pendingWrite
+	encodeBuf  bytes.Buffer
+
+	// asyncDisplay is set with SetAsyncDisplay.
+	asyncDisplay bool
+
+	// writeErr is the error of writing to the pipe, reported by the next call to SendData.
+	muWriteErr sync.Mutex
+	writeErr   error
+)
+
+// SetAsyncDisplay configures whether the display functions (and anything that uses SendData) return without
+// waiting for the content to be written to GoNB, so compute goroutines are not blocked by it.
+//
+// If enabled, call Flush (or Sync) before the program exits, otherwise the content still waiting to be written
+// is lost. The default is false.
+func SetAsyncDisplay(async bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	asyncDisplay = async
+}
+
+// Flush waits until all the content sent (see SetAsyncDisplay) is written to GoNB. Sync also waits for
+// GoNB to handle it.
+func Flush() {
+	mu.Lock()
+	if writeQueue == nil {
+		mu.Unlock()
+		return
+	}
+	w := &pendingWrite{written: make(chan struct{})}
+	writeQueue <- w
+	mu.Unlock()
+	<-w.written
+}
+
+// startWriterLocked starts the goroutine that writes the messages to the pipe, assuming `mu` is locked.
+func startWriterLocked(pipe io.Writer) {
+	encodeBuf.Reset()
+	gonbEncoder = gob.NewEncoder(&encodeBuf)
+	writeQueue = make(chan *pendingWrite, WriteQueueSize)
+	go pollWriteQueue(pipe, writeQueue)
+}
+
+// queueLocked encodes the data and queues it to be written, assuming `mu` is locked. If wait is set, it
+// returns a channel closed once it is written.
+//
+// The data is encoded right away, so the caller is free to change it afterward.
+func queueLocked(data *protocol.DisplayData, wait bool) (written <-chan struct{}, err error) {
+	encodeBuf.Reset()
+	if err = gonbEncoder.Encode(data); err != nil {
+		return nil, err
+	}
+	w := &pendingWrite{frame: bytes.Clone(encodeBuf.Bytes())}
+	if wait {
+		w.written = make(chan struct{})
+	}
+	writeQueue <- w // It doesn't require `mu`, so it's ok to wait for room in the queue while holding it.
+	return w.written, nil
+}
+
+// pollWriteQueue writes the messages in the queue to the pipe. After a failure, the following messages
+// are dropped.
+func pollWriteQueue(pipe io.Writer, queue chan *pendingWrite) {
+	var err error
+	for w := range queue {
+		if err == nil && len(w.frame) > 0 {
+			if _, err = pipe.Write(w.frame); err != nil {
+				Logf("pollWriteQueue(): failed to write: %+v", err)
+				muWriteErr.Lock()
+				writeErr = err
+				muWriteErr.Unlock()
+			}
+		}
+		if w.written != nil {
+			close(w.written)
+		}
+	}
+}
+
+// takeWriteError returns the error of writing to the pipe, if any, and resets it.
+func takeWriteError() error {
+	muWriteErr.Lock()
+	defer muWriteErr.Unlock()
+	err := writeErr
+	writeErr = nil
+	return err
+}
@@ -0,0 +1,163 @@
+package gonbui
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePipes makes the program behave as if executed by GoNB, with the messages written to the returned reader.
+func fakePipes(t *testing.T) *io.PipeReader {
+	require.False(t, IsNotebook, "tests must not be executed by GoNB")
+	reader, writer := io.Pipe()
+	unusedReader, unusedWriter, err := os.Pipe()
+	require.NoError(t, err)
+
+	mu.Lock()
+	IsNotebook = true
+	gonbWriterPipe, gonbReaderPipe = unusedWriter, unusedReader
+	startWriterLocked(writer)
+	queue := writeQueue
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		_ = reader.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		close(queue)
+		closePipesLocked()
+		IsNotebook = false
+		asyncDisplay = false
+		gonbPipesError = nil
+		writeQueue = nil
+		_ = takeWriteError()
+	})
+	return reader
+}
+
+// markdown returns the markdown content of the data.
+func markdown(data *protocol.DisplayData) string {
+	content, _ := data.Data[protocol.MIMETextMarkdown].(string)
+	return content
+}
+
+func TestSendDataConcurrent(t *testing.T) {
+	reader := fakePipes(t)
+	const numGoroutines, numMessages = 8, 50
+
+	// Decode everything written: each frame must be intact, and the messages of each goroutine in order.
+	received := make(chan []*protocol.DisplayData)
+	go func() {
+		decoder := gob.NewDecoder(reader)
+		var all []*protocol.DisplayData
+		for ii := 0; ii < numGoroutines*numMessages; ii++ {
+			data := &protocol.DisplayData{}
+			if err := decoder.Decode(data); err != nil {
+				t.Errorf("failed to decode message #%d: %+v", ii, err)
+				break
+			}
+			all = append(all, data)
+		}
+		received <- all
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < numGoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for ii := 0; ii < numMessages; ii++ {
+				// Messages of different sizes, to catch interleaved writes.
+				content := fmt.Sprintf("%d:%d:%0*d", g, ii, (g*numMessages+ii)%97, 0)
+				assert.NoError(t, TryDisplayMarkdown(content))
+			}
+		}(g)
+	}
+	wg.Wait()
+	all := <-received
+	require.Len(t, all, numGoroutines*numMessages)
+
+	next := make([]int, numGoroutines)
+	for _, data := range all {
+		assert.Equal(t, protocol.Version, data.Version)
+		var g, ii int
+		_, err := fmt.Sscanf(markdown(data), "%d:%d:", &g, &ii)
+		require.NoErrorf(t, err, "invalid content %q", markdown(data))
+		assert.Equalf(t, next[g], ii, "messages of goroutine %d out of order", g)
+		next[g] = ii + 1
+	}
+}
+
+func TestFlush(t *testing.T) {
+	reader := fakePipes(t)
+	SetAsyncDisplay(true)
+	const numMessages = 5
+	for ii := 0; ii < numMessages; ii++ {
+		require.NoError(t, TryDisplayMarkdown(fmt.Sprintf("message %d", ii)), "async display should not block")
+	}
+
+	// Flush waits for the queue to be written: nothing is read from the pipe yet, so it blocks.
+	flushed := make(chan struct{})
+	go func() {
+		Flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		t.Fatal("Flush returned before the messages were written")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	decoder := gob.NewDecoder(reader)
+	for ii := 0; ii < numMessages; ii++ {
+		data := &protocol.DisplayData{}
+		require.NoError(t, decoder.Decode(data))
+		assert.Equal(t, fmt.Sprintf("message %d", ii), markdown(data))
+	}
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Flush didn't return after the messages were written")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	reader := fakePipes(t)
+	var reported []error
+	OnError(func(err error) { reported = append(reported, err) })
+	t.Cleanup(func() { OnError(nil) })
+
+	// With asynchronous display, the error writing a message is returned by the next SendData.
+	SetAsyncDisplay(true)
+	_ = reader.CloseWithError(errors.New("pipe broken"))
+	require.NoError(t, TryDisplayMarkdown("lost"))
+	Flush()
+	err := TryDisplayMarkdown("next")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pipe broken")
+	assert.Contains(t, err.Error(), "pipes closed")
+	require.Len(t, reported, 1)
+	assert.Equal(t, err, reported[0])
+
+	// The pipes are closed, and the error is persistent.
+	assert.Equal(t, err, TryDisplayMarkdown("again"))
+	assert.Len(t, reported, 2)
+}
+
+func TestWriteErrorSync(t *testing.T) {
+	reader := fakePipes(t)
+
+	// With synchronous display, the error is returned by the SendData that failed.
+	_ = reader.CloseWithError(errors.New("pipe broken"))
+	err := TryDisplayMarkdown("lost")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pipe broken")
+}
@@ -0,0 +1,144 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/metrics"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+)
+
+// This file implements the broadcasting of kernel events to all connected front-ends.
+//
+// When a notebook is shared by multiple users (e.g.: JupyterLab real time collaboration, RTC),
+// each browser installs its own `gonb_comm` and opens its own comm channel. Messages sent by
+// the program go only to the last one opened (State.CommId), but the kernel events below are
+// broadcast to all of them, so that widgets and staleness indicators of every user stay consistent.
+
+const (
+	// ExecutionStartAddress is broadcast to all front-ends when the execution of a cell starts.
+	// The value is an ExecutionEvent.
+	ExecutionStartAddress = "#execution/start"
+
+	// ExecutionEndAddress is broadcast to all front-ends when the execution of a cell finishes.
+	// The value is an ExecutionEvent, with Status set.
+	ExecutionEndAddress = "#execution/end"
+
+	// DeclarationsAddress is broadcast to all front-ends when the memorized Go declarations change.
+	// The value maps the kind of declaration ("functions", "variables", "types", "constants", "imports")
+	// to a map of the declaration key to the execution count of the cell that declared it.
+	DeclarationsAddress = "#declarations"
+
+	// MaxPeers is the maximum number of front-ends connections tracked for broadcasting. When more
+	// are opened, the oldest ones are dropped -- usually they are stale connections of reloaded pages.
+	MaxPeers = 32
+)
+
+// ExecutionEvent is the value broadcast to ExecutionStartAddress and ExecutionEndAddress.
+type ExecutionEvent struct {
+	// ExecutionCount of the cell, or 0 if it's not stored in the history.
+	ExecutionCount int `json:"execution_count"`
+
+	// Session and Username of the client that requested the execution.
+	Session  string `json:"session"`
+	Username string `json:"username"`
+
+	// CellId is the id of the notebook cell being executed, if provided by the front-end.
+	CellId string `json:"cell_id,omitempty"`
+
+	// Status is only set at the end of the execution: either "ok" or "error".
+	Status string `json:"status,omitempty"`
+}
+
+// NewExecutionEvent creates an ExecutionEvent for the execution requested by `msg`.
+func NewExecutionEvent(msg kernel.Message, executionCount int) *ExecutionEvent {
+	composed := msg.ComposedMsg()
+	event := &ExecutionEvent{
+		ExecutionCount: executionCount,
+		Session:        composed.Header.Session,
+		Username:       composed.Header.Username,
+	}
+	if cellId, ok := composed.Metadata["cellId"].(string); ok {
+		event.CellId = cellId
+	}
+	return event
+}
+
+// addPeerLocked registers the comm id of a newly opened front-end connection.
+// It assumes the `s.mu` lock is already acquired.
+func (s *State) addPeerLocked(commId string) {
+	s.removePeerLocked(commId) // Makes sure it's not duplicate.
+	s.Peers = append(s.Peers, commId)
+	if len(s.Peers) > MaxPeers {
+		s.Peers = slices.Delete(s.Peers, 0, len(s.Peers)-MaxPeers)
+	}
+	s.updateMetricsLocked()
+}
+
+// removePeerLocked removes the comm id from the list of peers, if present.
+// It assumes the `s.mu` lock is already acquired.
+func (s *State) removePeerLocked(commId string) {
+	if idx := slices.Index(s.Peers, commId); idx >= 0 {
+		s.Peers = slices.Delete(s.Peers, idx, idx+1)
+	}
+	s.updateMetricsLocked()
+}
+
+// mostRecentPeerLocked returns the comm id of the most recently opened front-end connection, or "" if there
+// are none: Peers is kept in the order the connections were opened, and one opened again is moved to the end.
+// It assumes the `s.mu` lock is already acquired.
+func (s *State) mostRecentPeerLocked() string {
+	if len(s.Peers) == 0 {
+		return ""
+	}
+	return s.Peers[len(s.Peers)-1]
+}
+
+// updateMetricsLocked updates the metrics of the connection with the front-end.
+// It assumes the `s.mu` lock is already acquired.
+func (s *State) updateMetricsLocked() {
+	connected := 0.0
+	if s.Opened {
+		connected = 1
+	}
+	metrics.CommsConnected.Set(connected)
+	metrics.CommsPeers.Set(float64(len(s.Peers)))
+}
+
+// OpenedPeers returns the comm ids of all front-end connections opened, or nil if none is opened.
+func (s *State) OpenedPeers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.Opened {
+		return nil
+	}
+	return slices.Clone(s.Peers)
+}
+
+// Broadcast value to the given address to all connected front-ends.
+// It's a no-op if no front-end has opened a connection.
+// The value will be converted to JSON before being sent.
+func (s *State) Broadcast(msg kernel.Message, address string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Opened {
+		return nil
+	}
+	data := map[string]any{
+		"address": address,
+		"value":   value,
+	}
+	klog.V(2).Infof("comms: broadcast(address=%q) to %d front-end(s)", address, len(s.Peers))
+	for _, commId := range s.Peers {
+		content := map[string]any{
+			"comm_id": commId,
+			"data":    data,
+		}
+		s.recordOutLocked(address, value)
+		if err := msg.Publish("comm_msg", content); err != nil {
+			return errors.WithMessagef(err, "failed to broadcast to address %q", address)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package comms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBroadcastTwoPeers(t *testing.T) {
+	s, program := openedState("c1", "c2")
+	s.LastMsgTime = time.Now()
+	msg := &fakeMsg{}
+
+	// Broadcasts reach both peers, messages only the current (most recently opened) one.
+	require.NoError(t, s.Broadcast(msg, ExecutionStartAddress, 1))
+	require.NoError(t, s.Send(msg, "/a", 2))
+	assert.Equal(t, []string{"c1", "c2", "c2"}, msg.Recipients())
+
+	// Closing the current connection: the other peer becomes the current one, and still receives the broadcasts.
+	require.NoError(t, s.HandleClose(commCloseMsg("c2")))
+	assert.Equal(t, "c1", s.CommId)
+	assert.True(t, s.LastMsgTime.IsZero(), "the new current connection should be confirmed with a heartbeat")
+	assert.Empty(t, program, "program should not be notified while a connection is opened")
+	msg = &fakeMsg{}
+	require.NoError(t, s.Broadcast(msg, ExecutionEndAddress, 3))
+	require.NoError(t, s.Send(msg, "/a", 4))
+	assert.Equal(t, []string{"c1", "c1"}, msg.Recipients())
+	assert.Equal(t, []string{"c1"}, s.OpenedPeers())
+
+	// The most recently opened peer is picked, regardless of the order in which the others were closed.
+	s, _ = openedState("c1", "c2", "c3")
+	s.addPeerLocked("c1") // Opened again: it is now the most recent.
+	s.CommId = "c1"
+	require.NoError(t, s.HandleClose(commCloseMsg("c2")))
+	assert.Equal(t, "c1", s.CommId, "closing a peer that is not the current one keeps the current one")
+	require.NoError(t, s.HandleClose(commCloseMsg("c1")))
+	assert.Equal(t, "c3", s.CommId)
+	msg = &fakeMsg{}
+	require.NoError(t, s.Broadcast(msg, ExecutionStartAddress, 5))
+	assert.Equal(t, []string{"c3"}, msg.Recipients())
+
+	// Once all are closed, broadcasts are not sent.
+	require.NoError(t, s.HandleClose(commCloseMsg("c3")))
+	assert.Nil(t, s.OpenedPeers())
+	msg = &fakeMsg{}
+	require.NoError(t, s.Broadcast(msg, ExecutionEndAddress, 6))
+	assert.Empty(t, msg.Recipients())
+}
@@ -0,0 +1,206 @@
+package comms
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the chaos mode of the comms channel, a testing aid for the authors of widgets: it injects
+// latency, reordering (random jitter on the latency) and drops in the delivery of the `comm_msg` messages, in both
+// directions, to test the reconnection and acknowledgement logic (e.g.: SendReliable, heartbeats) against a bad
+// network -- e.g.: from the integration tests of a widgets library, setting ChaosEnv in the environment of the
+// kernel. It is configured with `%comms chaos`.
+//
+// The acknowledgement of the opening of the connection (CommOpenAckAddress) is never affected.
+
+// ChaosEnv is the environment variable read when the kernel starts, with the initial chaos configuration, in
+// the format accepted by ParseChaos.
+const ChaosEnv = "GONB_COMMS_CHAOS"
+
+// Chaos configures the faults injected in the comms channel. The zero value disables it.
+type Chaos struct {
+	// Latency added to each message.
+	Latency time.Duration
+
+	// Jitter is the maximum random extra latency added to each message: messages sent close together may be
+	// delivered out of order.
+	Jitter time.Duration
+
+	// Drop is the probability (from 0 to 1) that a message is dropped.
+	Drop float64
+
+	// Seed of the random number generator: with the same seed (and the same sequence of messages) the same
+	// faults are injected. If 0, a seed based on the current time is used.
+	Seed int64
+}
+
+// Enabled returns whether any fault is injected.
+func (c Chaos) Enabled() bool {
+	return c.Latency > 0 || c.Jitter > 0 || c.Drop > 0
+}
+
+// String returns the configuration in the format accepted by ParseChaos.
+func (c Chaos) String() string {
+	if !c.Enabled() {
+		return "off"
+	}
+	parts := []string{
+		"latency=" + c.Latency.String(),
+		"jitter=" + c.Jitter.String(),
+		"drop=" + strconv.FormatFloat(c.Drop, 'g', -1, 64),
+	}
+	if c.Seed != 0 {
+		parts = append(parts, "seed="+strconv.FormatInt(c.Seed, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseChaos parses a chaos configuration: "off", or a comma-separated list of `latency=<duration>`,
+// `jitter=<duration>`, `drop=<probability>` and `seed=<int>`, e.g.: "latency=200ms,jitter=100ms,drop=0.05".
+func ParseChaos(spec string) (Chaos, error) {
+	var c Chaos
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return c, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return Chaos{}, errors.Errorf("invalid chaos setting %q: it must be `<key>=<value>`", part)
+		}
+		var err error
+		switch key {
+		case "latency":
+			c.Latency, err = time.ParseDuration(value)
+			if err == nil && c.Latency < 0 {
+				err = errors.New("it must be positive")
+			}
+		case "jitter":
+			c.Jitter, err = time.ParseDuration(value)
+			if err == nil && c.Jitter < 0 {
+				err = errors.New("it must be positive")
+			}
+		case "drop":
+			c.Drop, err = strconv.ParseFloat(value, 64)
+			if err == nil && (c.Drop < 0 || c.Drop > 1) {
+				err = errors.New("it must be a probability, from 0 to 1")
+			}
+		case "seed":
+			c.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return Chaos{}, errors.Errorf("unknown chaos setting %q, it must be one of latency, jitter, drop or seed", key)
+		}
+		if err != nil {
+			return Chaos{}, errors.WithMessagef(err, "invalid chaos setting %q", part)
+		}
+	}
+	return c, nil
+}
+
+// chaosInjector decides the fate of each message, given the Chaos configuration.
+type chaosInjector struct {
+	mu     sync.Mutex
+	config Chaos
+	rng    *rand.Rand
+
+	// Counters of the messages affected, since the configuration was set.
+	numDelayed, numDropped int
+}
+
+// newChaosInjector returns the injector configured from ChaosEnv, if set.
+func newChaosInjector() *chaosInjector {
+	ci := &chaosInjector{}
+	if spec := os.Getenv(ChaosEnv); spec != "" {
+		config, err := ParseChaos(spec)
+		if err != nil {
+			klog.Errorf("comms: ignoring $%s: %+v", ChaosEnv, err)
+		} else {
+			ci.set(config)
+		}
+	}
+	return ci
+}
+
+// set the configuration, and resets the counters.
+func (ci *chaosInjector) set(config Chaos) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	ci.config = config
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	ci.rng = rand.New(rand.NewSource(seed))
+	ci.numDelayed, ci.numDropped = 0, 0
+	if config.Enabled() {
+		klog.Warningf("comms: chaos mode enabled (%s): messages with the front-end will be delayed and dropped", config)
+	}
+}
+
+// decide whether to drop the message, or for how long to delay it.
+func (ci *chaosInjector) decide() (drop bool, delay time.Duration) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	if !ci.config.Enabled() {
+		return false, 0
+	}
+	if ci.config.Drop > 0 && ci.rng.Float64() < ci.config.Drop {
+		ci.numDropped++
+		return true, 0
+	}
+	delay = ci.config.Latency
+	if ci.config.Jitter > 0 {
+		delay += time.Duration(ci.rng.Int63n(int64(ci.config.Jitter)))
+	}
+	if delay > 0 {
+		ci.numDelayed++
+	}
+	return false, delay
+}
+
+// SetChaos configures the faults injected in the comms channel, see Chaos. The zero value disables them.
+func (s *State) SetChaos(config Chaos) {
+	s.chaos.set(config)
+}
+
+// ChaosReport returns the current chaos configuration, and the number of messages affected since it was set.
+func (s *State) ChaosReport() string {
+	ci := s.chaos
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	if !ci.config.Enabled() {
+		return "Comms chaos mode: off\n"
+	}
+	return fmt.Sprintf("Comms chaos mode: %s\n  %d message(s) delayed, %d dropped.\n",
+		ci.config, ci.numDelayed, ci.numDropped)
+}
+
+// publishWithChaos calls publish, to send a `comm_msg` to the address in the front-end, subject to the chaos
+// configuration: right away, later (in a separate goroutine, logging any errors), or never.
+func (s *State) publishWithChaos(address string, publish func() error) error {
+	if address == CommOpenAckAddress {
+		return publish()
+	}
+	drop, delay := s.chaos.decide()
+	switch {
+	case drop:
+		klog.V(1).Infof("comms: chaos dropped message to %q", address)
+		return nil
+	case delay > 0:
+		klog.V(2).Infof("comms: chaos delayed message to %q by %s", address, delay)
+		time.AfterFunc(delay, func() {
+			if err := publish(); err != nil {
+				klog.Warningf("comms: failed to publish message delayed to %q: %+v", address, err)
+			}
+		})
+		return nil
+	}
+	return publish()
+}
@@ -0,0 +1,153 @@
+package comms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseChaos(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want Chaos
+	}{
+		{"", Chaos{}},
+		{" off ", Chaos{}},
+		{"latency=200ms", Chaos{Latency: 200 * time.Millisecond}},
+		{"latency=200ms, jitter=1s,drop=0.05,seed=7",
+			Chaos{Latency: 200 * time.Millisecond, Jitter: time.Second, Drop: 0.05, Seed: 7}},
+		{"drop=1", Chaos{Drop: 1}},
+	} {
+		got, err := ParseChaos(tc.spec)
+		require.NoErrorf(t, err, "ParseChaos(%q)", tc.spec)
+		assert.Equalf(t, tc.want, got, "ParseChaos(%q)", tc.spec)
+
+		// String returns a spec that is parsed back to the same configuration.
+		again, err := ParseChaos(got.String())
+		require.NoError(t, err)
+		assert.Equal(t, got, again)
+	}
+	assert.Equal(t, "off", Chaos{Seed: 3}.String())
+
+	for _, tc := range []struct {
+		spec, errMsg string
+	}{
+		{"latency", "it must be `<key>=<value>`"},
+		{"latency=fast", "invalid chaos setting"},
+		{"latency=-1s", "it must be positive"},
+		{"jitter=-1s", "it must be positive"},
+		{"drop=1.5", "it must be a probability"},
+		{"drop=-0.1", "it must be a probability"},
+		{"drop=often", "invalid chaos setting"},
+		{"seed=1.5", "invalid chaos setting"},
+		{"latency=1s,loss=0.1", "unknown chaos setting \"loss\""},
+	} {
+		_, err := ParseChaos(tc.spec)
+		require.Errorf(t, err, "ParseChaos(%q)", tc.spec)
+		assert.Containsf(t, err.Error(), tc.errMsg, "ParseChaos(%q)", tc.spec)
+	}
+}
+
+// decisions returns the results of n calls to decide, with a newly configured injector.
+func decisions(config Chaos, n int) (drops []bool, delays []time.Duration) {
+	ci := &chaosInjector{}
+	ci.set(config)
+	for ii := 0; ii < n; ii++ {
+		drop, delay := ci.decide()
+		drops = append(drops, drop)
+		delays = append(delays, delay)
+	}
+	return
+}
+
+func TestChaosDecide(t *testing.T) {
+	const n = 1000
+	config := Chaos{Latency: 10 * time.Millisecond, Jitter: 5 * time.Millisecond, Drop: 0.2, Seed: 42}
+
+	// Same seed, same faults.
+	drops, delays := decisions(config, n)
+	drops2, delays2 := decisions(config, n)
+	assert.Equal(t, drops, drops2)
+	assert.Equal(t, delays, delays2)
+
+	// Different seed, different faults.
+	config2 := config
+	config2.Seed = 43
+	drops2, delays2 = decisions(config2, n)
+	assert.NotEqual(t, drops, drops2)
+	assert.NotEqual(t, delays, delays2)
+
+	numDropped := 0
+	for ii, drop := range drops {
+		if drop {
+			numDropped++
+			assert.Zero(t, delays[ii])
+			continue
+		}
+		assert.GreaterOrEqual(t, delays[ii], config.Latency)
+		assert.Less(t, delays[ii], config.Latency+config.Jitter)
+	}
+	assert.InDelta(t, config.Drop*n, numDropped, 0.05*n)
+
+	// Counters.
+	ci := &chaosInjector{}
+	ci.set(config)
+	for ii := 0; ii < n; ii++ {
+		_, _ = ci.decide()
+	}
+	assert.Equal(t, numDropped, ci.numDropped)
+	assert.Equal(t, n-numDropped, ci.numDelayed)
+
+	// Disabled: nothing happens, regardless of the seed.
+	drops, delays = decisions(Chaos{Seed: 42}, 10)
+	assert.Equal(t, make([]bool, 10), drops)
+	assert.Equal(t, make([]time.Duration, 10), delays)
+}
+
+func TestChaosEnv(t *testing.T) {
+	t.Setenv(ChaosEnv, "drop=0.5,seed=1")
+	assert.Equal(t, Chaos{Drop: 0.5, Seed: 1}, newChaosInjector().config)
+
+	t.Setenv(ChaosEnv, "drop=2")
+	assert.False(t, newChaosInjector().config.Enabled(), "invalid configuration should be ignored")
+}
+
+func TestPublishWithChaos(t *testing.T) {
+	s := New()
+	published := make(chan string, 10)
+	publish := func(address string) func() error {
+		return func() error {
+			published <- address
+			return nil
+		}
+	}
+
+	// Everything dropped, except the acknowledgement of the opening of the connection.
+	s.SetChaos(Chaos{Drop: 1, Seed: 1})
+	require.NoError(t, s.publishWithChaos("/widget", publish("/widget")))
+	require.NoError(t, s.publishWithChaos(CommOpenAckAddress, publish(CommOpenAckAddress)))
+	assert.Equal(t, CommOpenAckAddress, <-published)
+	assert.Empty(t, published)
+	assert.Contains(t, s.ChaosReport(), "0 message(s) delayed, 1 dropped")
+
+	// Delayed messages are published later.
+	s.SetChaos(Chaos{Latency: 50 * time.Millisecond, Seed: 1})
+	start := time.Now()
+	require.NoError(t, s.publishWithChaos("/widget", publish("/widget")))
+	assert.Empty(t, published, "message should have been delayed")
+	select {
+	case address := <-published:
+		assert.Equal(t, "/widget", address)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed message was never published")
+	}
+	assert.Contains(t, s.ChaosReport(), "1 message(s) delayed, 0 dropped")
+
+	// Disabled.
+	s.SetChaos(Chaos{})
+	require.NoError(t, s.publishWithChaos("/widget", publish("/widget")))
+	assert.Equal(t, "/widget", <-published)
+	assert.Equal(t, "Comms chaos mode: off\n", s.ChaosReport())
+}
@@ -14,8 +14,10 @@ import (
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/internal/jpyexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/metrics"
 	"github.com/janpfeifer/gonb/internal/websocket"
 	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
 	"k8s.io/klog/v2"
 	"strings"
 	"sync"
@@ -44,13 +46,24 @@ type State struct {
 	// Opened indicates whether "comm_open" message has already been received.
 	Opened bool
 
+	// FromExtension indicates the Javascript was installed by the JupyterLab extension (see `labextension/` in the
+	// repository), which connects when the notebook is opened or the kernel restarts. The kernel then doesn't
+	// inject it in the cell outputs, unless the extension fails to connect.
+	FromExtension bool
+
 	// openedLatch is created by InstallWebSocket, and is triggered when it is
 	// finally opened (or failed to open).
 	openLatch *common.Latch
 
 	// CommId created when the channel is opened from the front-end.
+	// If more than one front-end is connected, this is the most recently opened one.
 	CommId string
 
+	// Peers holds the comm ids of all front-end connections opened, including CommId, in the order
+	// they were opened. It is used to broadcast kernel events (see Broadcast) when the notebook is
+	// shared by multiple users. Some may be stale (e.g.: reloaded pages), and are eventually dropped.
+	Peers []string
+
 	// LastMsgTime is used to condition the need of a heartbeat, to access if the connection is still alive.
 	LastMsgTime time.Time
 
@@ -59,10 +72,48 @@ type State struct {
 	// It is recreated everytime a HeartbeatPing is sent.
 	HeartbeatPongLatch *common.LatchWithValue[bool]
 
+	// HeartbeatRTT is the round-trip time of the last heartbeat replied, and heartbeatSent the time
+	// the current heartbeat ping was sent.
+	HeartbeatRTT  time.Duration
+	heartbeatSent time.Time
+
 	// AddressSubscriptions by the program being executed. Needs to be reset at every program
 	// execution.
 	AddressSubscriptions common.Set[string]
 
+	// kernelHandlers of messages from the front-end handled by the kernel itself, by address.
+	kernelHandlers map[string]func(msg kernel.Message, value any)
+
+	// commsState is the state of the connection reported to the program, see `gonbui.OnCommsStateChange`.
+	commsState commsStateMonitor
+
+	// stats of the messages exchanged, see Stats.
+	stats Stats
+
+	// pendingAcks holds the reliable messages (see SendReliable) waiting for an acknowledgement, by address,
+	// and lastReliableSeq is the sequence number of the last one sent.
+	pendingAcks     map[string]*pendingAck
+	lastReliableSeq int64
+
+	// lastValues holds the last value exchanged in each address, to reply to synchronization requests from
+	// the front-end, see CommSyncRequestAddress.
+	lastValues map[string]any
+
+	// chaos injects faults in the messages exchanged with the front-end, see chaos.go.
+	chaos *chaosInjector
+
+	// streams holds the text written to the streams of the program (see `gonbui.NewStream`) waiting to be sent,
+	// by address. See stream.go.
+	streams map[string]*streamBatch
+
+	// programAddresses are the addresses used by the program being executed, and deadAddresses the ones no
+	// longer used, with the time they were last used: their state is collected after the AddressTTL (see
+	// SetAddressTTL). gcTimer is set when a collection is scheduled.
+	programAddresses common.Set[string]
+	deadAddresses    map[string]time.Time
+	addressTTL       time.Duration
+	gcTimer          *time.Timer
+
 	// ProgramExecutor is a reference to the executor of the user's program (current cell).
 	// It is used to dispatch comms coming from the front-end to the program.
 	// This is set at the start of every cell execution, and reset to nil when the execution finishes.
@@ -75,6 +126,10 @@ type State struct {
 	// LogWebsocket controls whether to turn verbose logging (on the Javascript console) of the
 	// WebSocket Javascript library, when it is installed.
 	LogWebSocket bool
+
+	// versionMismatch is set when a connection from a front-end with a different websocket.ProtocolVersion was
+	// rejected, and the user is warned about it when the websocket is installed again.
+	versionMismatch string
 }
 
 const (
@@ -93,6 +148,7 @@ func New() *State {
 	s := &State{
 		IsWebSocketInstalled: false,
 		AddressSubscriptions: make(common.Set[string]),
+		chaos:                newChaosInjector(),
 	}
 	return s
 }
@@ -177,12 +233,32 @@ func (s *State) installWebSocketLocked(msg kernel.Message) error {
 
 		// Likely we have a stale comms connection (e.g.: if the browser reloaded), we reset it and
 		// follow with the re-install.
+		s.removePeerLocked(s.CommId)
 		s.CommId = ""
 		s.IsWebSocketInstalled = false
 		s.Opened = false
+		s.updateMetricsLocked()
+		s.setCommsStateLocked(false)
+	}
+
+	if s.versionMismatch != "" {
+		err := kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf(
+			"GoNB: %s -- installing the current one, re-run the cells with widgets if they don't work.\n", s.versionMismatch))
+		if err != nil {
+			klog.Warningf("comms: failed to publish warning: %+v", err)
+		}
+		s.versionMismatch = ""
 	}
 
-	if s.openLatch == nil {
+	if s.openLatch == nil && s.FromExtension {
+		// The JupyterLab extension re-connects by itself (e.g.: after the page is reloaded): wait for it.
+		klog.V(1).Infof("comms.State.InstallWebSocket(): waiting for the JupyterLab extension to connect...")
+		s.openLatch = common.NewLatch()
+		go func(l *common.Latch) {
+			time.Sleep(WaitForConnectionTimeout)
+			l.Trigger() // No-op if already triggered.
+		}(s.openLatch)
+	} else if s.openLatch == nil {
 		// Install WebSocked javascript and create openLatch to wait it to open.
 		// Notice if s.openLatch is created already, this is a concurrent call to
 		// InstallWebSocket, and we can simply wait on it.
@@ -221,6 +297,12 @@ func (s *State) installWebSocketLocked(msg kernel.Message) error {
 	}
 
 	// Check whether connection opening was successful.
+	if !s.Opened && s.FromExtension {
+		// Falls back to injecting the Javascript.
+		klog.Warningf("comms: the JupyterLab extension didn't connect, installing the websocket Javascript instead")
+		s.FromExtension = false
+		return s.installWebSocketLocked(msg)
+	}
 	if !s.Opened {
 		return errors.Errorf("InstallWebSocket failed: Javascript was sent to execution, but connection was not established. " +
 			"Likely widgets won't work, since connection with front-end (browser can't be installed).")
@@ -239,8 +321,9 @@ func (s *State) HandleOpen(msg kernel.Message) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	rejected := false
 	defer func() {
-		if s.openLatch != nil {
+		if s.openLatch != nil && !rejected {
 			// Confirms open was received and possibly replied.
 			// This doesn't mean it succeeded, only that the attempt at establishing
 			// the connection finished. Check `s.Opened` to see if it was correctly opened.
@@ -278,25 +361,56 @@ func (s *State) HandleOpen(msg kernel.Message) (err error) {
 		return nil
 	}
 
-	if s.Opened {
-		// Close the previous connection if it is still open.
-		err = s.closeLocked(msg)
-		if err != nil {
-			return
+	// The front-end Javascript may have been installed by a different version of GoNB (e.g.: by the saved output
+	// of an old notebook): it is not acknowledged, so it closes itself, and the current one is installed when the
+	// websocket is needed.
+	version := 1 // Before the version was sent.
+	if value, versionErr := getFromJson[float64](content, "data/protocol_version"); versionErr == nil {
+		version = int(value)
+	}
+	if version != websocket.ProtocolVersion {
+		s.versionMismatch = fmt.Sprintf("the front-end Javascript (protocol version %d) doesn't match the kernel's (version %d)",
+			version, websocket.ProtocolVersion)
+		if fromExtension, _ := getFromJson[bool](content, "data/extension"); fromExtension {
+			// The Javascript is injected instead.
+			s.versionMismatch += ": please update the GoNB JupyterLab extension"
+			s.FromExtension = false
 		}
+		klog.Warningf("comms: ignored comm_open(comm_id=%q): %s", commId, s.versionMismatch)
+		rejected = true
+		if s.IsWebSocketInstalled && !s.Opened {
+			// Forces the re-installation.
+			s.IsWebSocketInstalled = false
+		}
+		return nil
 	}
 
-	// Erase javascript that installs WebSocket.
-	jsData := kernel.Data{
-		Data:      make(kernel.MIMEMap, 1),
-		Metadata:  make(kernel.MIMEMap),
-		Transient: make(kernel.MIMEMap),
+	if s.Opened {
+		// The previous connection is not closed: it is kept as a peer, since it may belong to
+		// another user sharing the notebook.
+		klog.V(1).Infof("comms: comm_open(comm_id=%q) while connection %q is opened, keeping it as a peer", commId, s.CommId)
+		s.Opened = false
 	}
-	jsData.Data[string(protocol.MIMETextHTML)] = "" // Empty.
-	jsData.Transient["display_id"] = s.TransientDisplayId
-	if err = kernel.PublishUpdateDisplayData(msg, jsData); err != nil {
-		klog.Warningf("comms: failed to erase <div> block with javascript used to install websocket: %+v", err)
-		err = nil
+
+	// Installed by the JupyterLab extension, or by the Javascript injected by the kernel.
+	fromExtension, _ := getFromJson[bool](content, "data/extension")
+	if fromExtension {
+		klog.V(1).Infof("comms: comm_open(comm_id=%q) from the JupyterLab extension", commId)
+		s.FromExtension = true
+		s.IsWebSocketInstalled = true
+	} else if s.TransientDisplayId != "" {
+		// Erase javascript that installs WebSocket.
+		jsData := kernel.Data{
+			Data:      make(kernel.MIMEMap, 1),
+			Metadata:  make(kernel.MIMEMap),
+			Transient: make(kernel.MIMEMap),
+		}
+		jsData.Data[string(protocol.MIMETextHTML)] = "" // Empty.
+		jsData.Transient["display_id"] = s.TransientDisplayId
+		if err = kernel.PublishUpdateDisplayData(msg, jsData); err != nil {
+			klog.Warningf("comms: failed to erase <div> block with javascript used to install websocket: %+v", err)
+			err = nil
+		}
 	}
 
 	// Mark comms opened.
@@ -304,7 +418,7 @@ func (s *State) HandleOpen(msg kernel.Message) (err error) {
 	s.LastMsgTime = time.Now()
 	err = s.sendDataLocked(msg, map[string]any{
 		"address": CommOpenAckAddress,
-		"value":   true,
+		"value":   websocket.ProtocolVersion,
 	})
 	if err != nil {
 		klog.Warningf("Failed to acknowledge open connection to front-end, likely widgets won't work!")
@@ -312,13 +426,36 @@ func (s *State) HandleOpen(msg kernel.Message) (err error) {
 		return
 	}
 	s.Opened = true
+	s.addPeerLocked(commId)
+	s.setCommsStateLocked(true)
 	return nil
 }
 
 // HandleMsg is called by the dispatcher whenever a new `comm_msg` arrives from the front-end.
 // It filters out messages with the wrong `comm_id`, handles protocol messages (heartbeat)
 // and routes other messages.
-func (s *State) HandleMsg(msg kernel.Message) (err error) {
+//
+// In chaos mode (see SetChaos), the message may be dropped, or handled later.
+func (s *State) HandleMsg(msg kernel.Message) error {
+	drop, delay := s.chaos.decide()
+	switch {
+	case drop:
+		klog.V(1).Infof("comms: chaos dropped message from the front-end")
+		return nil
+	case delay > 0:
+		klog.V(2).Infof("comms: chaos delayed message from the front-end by %s", delay)
+		time.AfterFunc(delay, func() {
+			if err := s.handleMsg(msg); err != nil {
+				klog.Warningf("comms: failed to handle message from the front-end delayed: %+v", err)
+			}
+		})
+		return nil
+	}
+	return s.handleMsg(msg)
+}
+
+// handleMsg implements HandleMsg.
+func (s *State) handleMsg(msg kernel.Message) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -354,17 +491,48 @@ func (s *State) HandleMsg(msg kernel.Message) (err error) {
 
 	switch address {
 	case HeartbeatPongAddress:
+		s.recordInLocked(address, nil, false)
 		return s.handleHeartbeatPongLocked(msg)
 	case HeartbeatPingAddress:
+		s.recordInLocked(address, nil, false)
 		return s.handleHeartbeatPingLocked(msg)
+	case CommAckAddress:
+		s.recordInLocked(address, nil, false)
+		s.handleAckLocked(content)
+		return nil
+	case CommSyncRequestAddress:
+		s.recordInLocked(address, nil, false)
+		return s.handleSyncRequestLocked(msg, content)
 	default:
 		var value any
-		value, err = getFromJson[any](content, "data/value")
-		if err != nil {
-			klog.Warningf("comms: comm_msg did not set an \"content/data/value\" field: %+v", err)
+		if buffers := msg.ComposedMsg().Buffers; len(buffers) > 0 {
+			// Binary data is delivered as []byte, in place of the value.
+			value = buffers[0]
+		} else {
+			value, err = getFromJson[any](content, "data/value")
+			if err != nil {
+				klog.Warningf("comms: comm_msg did not set an \"content/data/value\" field: %+v", err)
+				return nil
+			}
+		}
+		handler, handled := s.kernelHandlers[address]
+		delivered := handled || s.deliverProgramSubscriptionsLocked(address, value)
+		s.recordInLocked(address, value, !delivered)
+		if !handled {
+			s.recordLastValueLocked(address, value)
+			if !s.programAddresses.Has(address) {
+				// Not used by the current program, e.g.: a widget of a previous execution.
+				s.markDeadLocked(address)
+			}
+		}
+		if handled {
+			// Handled without the lock, since the handler may use the State.
+			s.mu.Unlock()
+			handler(msg, value)
+			s.mu.Lock()
 			return nil
 		}
-		if s.deliverProgramSubscriptionsLocked(address, value) {
+		if delivered {
 			klog.V(2).Infof("comms: HandleMsg(address=%q) delivered", address)
 		} else {
 			klog.V(1).Infof("comms: HandleMsg(address=%q) dropped -- usually because there were no recipients", address)
@@ -373,6 +541,74 @@ func (s *State) HandleMsg(msg kernel.Message) (err error) {
 	}
 }
 
+// HandleAddress registers a handler for the messages the front-end sends to the given address, which are
+// then handled by the kernel, as opposed to being delivered to the program being executed.
+func (s *State) HandleAddress(address string, handler func(value any)) {
+	s.HandleRequest(address, func(_ kernel.Message, value any) { handler(value) })
+}
+
+// HandleRequest is like HandleAddress, but the handler also receives the message of the front-end, which can be
+// used to reply to it (e.g.: with Send).
+func (s *State) HandleRequest(address string, handler func(msg kernel.Message, value any)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kernelHandlers == nil {
+		s.kernelHandlers = make(map[string]func(msg kernel.Message, value any))
+	}
+	s.kernelHandlers[address] = handler
+}
+
+// HandleClose handles a "comm_close" message initiated by the front-end (e.g.: when the page navigates away, or the
+// widget manager is reset).
+//
+// The connection is dropped from the Peers and, if it is the current one (CommId), its state is cleaned up: the
+// reliable messages pending are dropped, and the program being executed is notified (see
+// `gonbui.OnCommsStateChange`). The most recently opened of the other peers, if any, becomes the current
+// connection, confirmed with a heartbeat the next time it is used; otherwise the websocket is installed again the
+// next time it is needed -- a new "comm_open" is accepted right away.
+func (s *State) HandleClose(msg kernel.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, ok := msg.ComposedMsg().Content.(map[string]any)
+	if !ok {
+		klog.V(1).Infof("comms: ignored comm_close, no content in msg %+v", msg.ComposedMsg())
+		return nil
+	}
+	commId, err := getFromJson[string](content, "comm_id")
+	if err != nil {
+		klog.V(1).Infof("comms: ignored comm_close, \"comm_id\" not set: %+v", err)
+		return nil
+	}
+	if commId != s.CommId {
+		if slices.Contains(s.Peers, commId) {
+			klog.V(1).Infof("comms: comm_close(comm_id=%q) of a peer", commId)
+			s.removePeerLocked(commId)
+		} else {
+			klog.V(1).Infof("comms: ignored comm_close(comm_id=%q), unknown connection", commId)
+		}
+		return nil
+	}
+
+	klog.V(1).Infof("comms: comm_close(comm_id=%q) of the current connection, initiated by the front-end", commId)
+	s.removePeerLocked(commId)
+	s.clearPendingAcksLocked()
+	if len(s.Peers) > 0 {
+		s.CommId = s.mostRecentPeerLocked()
+		// It may be stale (e.g.: a page reloaded without closing its connection): forget when we last heard
+		// from the closed connection, so the next InstallWebSocket confirms the new one with a heartbeat.
+		s.LastMsgTime = time.Time{}
+		klog.V(1).Infof("comms: connection %q is now the current one", s.CommId)
+	} else {
+		s.CommId = ""
+		s.Opened = false
+		s.IsWebSocketInstalled = false
+		s.setCommsStateLocked(false)
+	}
+	s.updateMetricsLocked()
+	return nil
+}
+
 // Close connection with front-end.
 // If `msg != nil`, It sends a "comm_close" message.
 func (s *State) Close(msg kernel.Message) error {
@@ -396,8 +632,12 @@ func (s *State) closeLocked(msg kernel.Message) error {
 		err = msg.Reply("comm_close", content)
 	}
 	s.CommId = "" // Erase comm_id.
+	s.Peers = nil
+	s.clearPendingAcksLocked()
 	s.Opened = false
 	s.IsWebSocketInstalled = false
+	s.updateMetricsLocked()
+	s.setCommsStateLocked(false)
 	return err
 }
 
@@ -425,10 +665,39 @@ func (s *State) sendDataLocked(msg kernel.Message, data map[string]any) error {
 		"data":    data,
 	}
 	klog.V(2).Infof("comms: sendData %+v", content)
-	return msg.Publish("comm_msg", content)
+	if address, ok := data["address"].(string); ok {
+		s.recordOutLocked(address, data["value"])
+	}
+	address, _ := data["address"].(string)
+	return s.publishWithChaos(address, func() error { return msg.Publish("comm_msg", content) })
 	//return msg.Reply("comm_msg", content)
 }
 
+// sendBinaryLocked is like sendDataLocked, but the binary value is sent as a buffer of the message: the
+// front-end receives it as an `ArrayBuffer`, in place of the value.
+func (s *State) sendBinaryLocked(msg kernel.Message, data map[string]any, value []byte) error {
+	content := map[string]any{
+		"comm_id": s.CommId,
+		"data":    data,
+	}
+	klog.V(2).Infof("comms: sendBinary %+v (%d bytes)", content, len(value))
+	if address, ok := data["address"].(string); ok {
+		s.recordOutLocked(address, value)
+	}
+	address, _ := data["address"].(string)
+	return s.publishWithChaos(address, func() error {
+		return kernel.PublishWithBuffers(msg, "comm_msg", content, [][]byte{value})
+	})
+}
+
+// Status returns whether the websocket Javascript was installed in the front-end, whether the connection was
+// opened, and the number of front-end connections (see Peers).
+func (s *State) Status() (installed, opened bool, numPeers int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.IsWebSocketInstalled, s.Opened, len(s.Peers)
+}
+
 // SendHeartbeatAndWait sends a heartbeat request (ping) and waits for a reply within the given timeout.
 // Returns true if a heartbeat was replied (pong) back, or false if it timed out.
 // It returns an error if it failed to sendData the heartbeat message.
@@ -465,6 +734,7 @@ func (s *State) sendHeartbeatPingLocked(msg kernel.Message, timeout time.Duratio
 		// Create latch to receive response, and a timeout trigger for the latch, in case we don't
 		// get the reply in time.
 		s.HeartbeatPongLatch = common.NewLatchWithValue[bool]()
+		s.heartbeatSent = time.Now()
 		go func(l *common.LatchWithValue[bool]) {
 			time.Sleep(timeout)
 			// If latch has already triggered in the meantime, this trigger is discarded automatically.
@@ -481,6 +751,9 @@ func (s *State) sendHeartbeatPingLocked(msg kernel.Message, timeout time.Duratio
 	// Clear the latch that we already used -- care in case in between some other process created a new latch.
 	if s.HeartbeatPongLatch == latch {
 		s.HeartbeatPongLatch = nil
+		if !heartbeat {
+			s.recordHeartbeatLocked(0, false)
+		}
 	}
 	return
 }
@@ -489,6 +762,9 @@ func (s *State) sendHeartbeatPingLocked(msg kernel.Message, timeout time.Duratio
 func (s *State) handleHeartbeatPongLocked(msg kernel.Message) error {
 	if s.HeartbeatPongLatch != nil {
 		klog.V(1).Infof("comms: heartbeat pong received, latch triggered")
+		s.HeartbeatRTT = time.Since(s.heartbeatSent)
+		metrics.HeartbeatRTTSeconds.Set(s.HeartbeatRTT.Seconds())
+		s.recordHeartbeatLocked(s.HeartbeatRTT, true)
 		s.HeartbeatPongLatch.Trigger(true)
 	} else {
 		klog.Warningf("comms: heartbeat pong received but no one listening (no associated latch)!?")
@@ -0,0 +1,238 @@
+package comms
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMsg is a kernel.Message that records the comms messages published to the front-end.
+type fakeMsg struct {
+	kernel.Message
+
+	mu        sync.Mutex
+	published []map[string]any
+}
+
+// Publish implements kernel.Message.
+func (m *fakeMsg) Publish(msgType string, content any) error {
+	return m.PublishWithBuffers(msgType, content, nil)
+}
+
+// PublishWithBuffers implements kernel.PublishWithBuffers: the binary buffer is recorded as the value.
+func (m *fakeMsg) PublishWithBuffers(msgType string, content any, buffers [][]byte) error {
+	c := content.(map[string]any)
+	if len(buffers) > 0 {
+		c["data"].(map[string]any)["value"] = buffers[0]
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published = append(m.published, c)
+	return nil
+}
+
+// Published returns the "data" of the messages published so far.
+func (m *fakeMsg) Published() (data []map[string]any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, content := range m.published {
+		data = append(data, content["data"].(map[string]any))
+	}
+	return
+}
+
+// Recipients returns the comm ids of the messages published so far.
+func (m *fakeMsg) Recipients() (commIds []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, content := range m.published {
+		commIds = append(commIds, content["comm_id"].(string))
+	}
+	return
+}
+
+// frontEndMsg returns a "comm_msg" message from the front-end, with the value to the given address.
+func frontEndMsg(commId, address string, value any) kernel.Message {
+	return &kernel.MessageImpl{Composed: kernel.ComposedMsg{Content: map[string]any{
+		"comm_id": commId,
+		"data":    map[string]any{"address": address, "value": value},
+	}}}
+}
+
+// commCloseMsg returns a "comm_close" message from the front-end, for the given comm id.
+func commCloseMsg(commId string) kernel.Message {
+	return &kernel.MessageImpl{Composed: kernel.ComposedMsg{Content: map[string]any{"comm_id": commId}}}
+}
+
+// testKernel is the kernel the messages of the tests are received from.
+var testKernel = &kernel.Kernel{JupyterKernelId: "test-kernel", KnownBlockIds: make(common.Set[string])}
+
+// kernelMsg is a message received by testKernel (e.g.: a cell execution, or a "comm_open"), that records the
+// messages published in reply: the comms messages as fakeMsg, and the others (streams, display data) as outputs.
+type kernelMsg struct {
+	*fakeMsg
+	content map[string]any
+
+	muOutputs sync.Mutex
+	outputs   []string
+}
+
+// ComposedMsg implements kernel.Message.
+func (m *kernelMsg) ComposedMsg() kernel.ComposedMsg { return kernel.ComposedMsg{Content: m.content} }
+
+// Kernel implements kernel.Message.
+func (m *kernelMsg) Kernel() *kernel.Kernel { return testKernel }
+
+// Publish implements kernel.Message.
+func (m *kernelMsg) Publish(msgType string, content any) error {
+	if msgType == "comm_msg" {
+		return m.fakeMsg.Publish(msgType, content)
+	}
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	m.muOutputs.Lock()
+	defer m.muOutputs.Unlock()
+	m.outputs = append(m.outputs, msgType+": "+string(encoded))
+	return nil
+}
+
+// Outputs returns the messages other than comms published so far, as "<msg_type>: <JSON content>".
+func (m *kernelMsg) Outputs() []string {
+	m.muOutputs.Lock()
+	defer m.muOutputs.Unlock()
+	return append([]string(nil), m.outputs...)
+}
+
+// commOpenMsg returns a "comm_open" message from the front-end, for the given comm id and data, which usually
+// holds the "protocol_version" of the Javascript.
+func commOpenMsg(commId string, data map[string]any) *kernelMsg {
+	return &kernelMsg{fakeMsg: &fakeMsg{}, content: map[string]any{
+		"target_name": "gonb_comm",
+		"kernel_id":   testKernel.JupyterKernelId,
+		"comm_id":     commId,
+		"data":        data,
+	}}
+}
+
+// openedState returns a State with the given connections opened, the last one being the current one, and a
+// program subscribed to the changes of the connection state.
+func openedState(peers ...string) (s *State, program chan *protocol.CommValue) {
+	s = New()
+	s.IsWebSocketInstalled = true
+	s.Opened = true
+	s.Peers = peers
+	s.CommId = peers[len(peers)-1]
+	s.pendingAcks = map[string]*pendingAck{"/reliable": {timer: time.NewTimer(time.Hour)}}
+
+	program = make(chan *protocol.CommValue, 10)
+	s.ProgramExecutor = &jpyexec.Executor{PipeWriterFifo: program}
+	s.AddressSubscriptions.Insert(protocol.GonbuiCommsStateAddress)
+	s.commsState = commsStateMonitor{exec: s.ProgramExecutor, connected: true}
+	return
+}
+
+func TestHandleClose(t *testing.T) {
+	// Close of the only connection, initiated by the front-end.
+	s, program := openedState("c1")
+	require.NoError(t, s.HandleClose(commCloseMsg("c1")))
+	assert.False(t, s.Opened)
+	assert.False(t, s.IsWebSocketInstalled, "websocket should be installed again the next time it is needed")
+	assert.Empty(t, s.CommId)
+	assert.Empty(t, s.Peers)
+	assert.Empty(t, s.pendingAcks)
+	require.Len(t, program, 1)
+	assert.Equal(t, &protocol.CommValue{Address: protocol.GonbuiCommsStateAddress, Value: false}, <-program)
+
+	// Close of the current connection, with another peer opened: it becomes the current one.
+	s, program = openedState("c1", "c2")
+	require.NoError(t, s.HandleClose(commCloseMsg("c2")))
+	assert.True(t, s.Opened)
+	assert.True(t, s.IsWebSocketInstalled)
+	assert.Equal(t, "c1", s.CommId)
+	assert.Equal(t, []string{"c1"}, s.Peers)
+	assert.Empty(t, s.pendingAcks)
+	assert.Empty(t, program, "program should not be notified while a connection is opened")
+
+	// Close of a peer that is not the current connection.
+	s, _ = openedState("c1", "c2")
+	require.NoError(t, s.HandleClose(commCloseMsg("c1")))
+	assert.Equal(t, "c2", s.CommId)
+	assert.Equal(t, []string{"c2"}, s.Peers)
+	assert.Len(t, s.pendingAcks, 1)
+
+	// Unknown connections and malformed messages are ignored.
+	s, program = openedState("c1")
+	require.NoError(t, s.HandleClose(commCloseMsg("unknown")))
+	require.NoError(t, s.HandleClose(&kernel.MessageImpl{}))
+	require.NoError(t, s.HandleClose(&kernel.MessageImpl{Composed: kernel.ComposedMsg{Content: map[string]any{}}}))
+	assert.True(t, s.Opened)
+	assert.Equal(t, "c1", s.CommId)
+	assert.Equal(t, []string{"c1"}, s.Peers)
+	assert.Empty(t, program)
+}
+
+func TestHandleOpenVersion(t *testing.T) {
+	current := map[string]any{"protocol_version": float64(websocket.ProtocolVersion)}
+
+	// A front-end Javascript of another version (here before the version was sent) is not acknowledged.
+	s := New()
+	stale := commOpenMsg("stale", map[string]any{})
+	require.NoError(t, s.HandleOpen(stale))
+	assert.False(t, s.Opened)
+	assert.Empty(t, s.CommId)
+	assert.Empty(t, stale.Published(), "connection of another version should not be acknowledged")
+	assert.Contains(t, s.versionMismatch, "protocol version 1")
+
+	// The next installation warns about it, and installs the current Javascript.
+	exec := &kernelMsg{fakeMsg: &fakeMsg{}}
+	installed := make(chan error, 1)
+	go func() { installed <- s.InstallWebSocket(exec) }()
+	require.Eventually(t, func() bool { return len(exec.Outputs()) == 2 }, 5*time.Second, time.Millisecond)
+	outputs := exec.Outputs()
+	assert.Contains(t, outputs[0], "stderr")
+	assert.Contains(t, outputs[0], fmt.Sprintf("doesn't match the kernel's (version %d)", websocket.ProtocolVersion))
+	assert.Contains(t, outputs[1], fmt.Sprintf("protocol_version: %d", websocket.ProtocolVersion))
+
+	// Another stale connection, while installing: it doesn't interrupt the installation.
+	require.NoError(t, s.HandleOpen(commOpenMsg("stale2", map[string]any{"protocol_version": float64(websocket.ProtocolVersion - 1)})))
+	select {
+	case err := <-installed:
+		t.Fatalf("InstallWebSocket returned before the current Javascript connected: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The current Javascript connects, and is acknowledged with the kernel's version.
+	open := commOpenMsg("c1", current)
+	require.NoError(t, s.HandleOpen(open))
+	require.NoError(t, <-installed)
+	assert.True(t, s.Opened)
+	assert.Equal(t, "c1", s.CommId)
+	assert.Equal(t, []map[string]any{{"address": CommOpenAckAddress, "value": websocket.ProtocolVersion}}, open.Published())
+	assert.Len(t, exec.Outputs(), 2, "the warning should be published only once")
+
+	// An installed websocket that is not opened is installed again.
+	s = New()
+	s.IsWebSocketInstalled = true
+	require.NoError(t, s.HandleOpen(commOpenMsg("stale", map[string]any{"protocol_version": float64(websocket.ProtocolVersion + 1)})))
+	assert.False(t, s.IsWebSocketInstalled)
+	assert.Contains(t, s.versionMismatch, fmt.Sprintf("protocol version %d", websocket.ProtocolVersion+1))
+
+	// An outdated JupyterLab extension: the Javascript is injected instead.
+	s = New()
+	s.FromExtension = true
+	require.NoError(t, s.HandleOpen(commOpenMsg("ext", map[string]any{"protocol_version": 1.0, "extension": true})))
+	assert.False(t, s.Opened)
+	assert.False(t, s.FromExtension)
+	assert.Contains(t, s.versionMismatch, "please update the GoNB JupyterLab extension")
+}
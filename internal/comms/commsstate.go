@@ -0,0 +1,85 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// This file notifies the program being executed of the changes of the connection with the front-end (see
+// `gonbui.OnCommsStateChange`): while the program is subscribed to protocol.GonbuiCommsStateAddress, the
+// connection is probed with heartbeats, and every change is delivered to the program.
+
+// CommsStateCheckInterval is the interval between checks of the connection with the front-end, while the
+// program is subscribed to protocol.GonbuiCommsStateAddress. A heartbeat is only sent if nothing was heard
+// from the front-end in the last HeartbeatRequestThreshold.
+var CommsStateCheckInterval = 2 * time.Second
+
+// commsStateMonitor is the bookkeeping of the connection state reported to the program.
+type commsStateMonitor struct {
+	// exec is the program being monitored, nil if none.
+	exec *jpyexec.Executor
+
+	// connected is the last state delivered to the program.
+	connected bool
+}
+
+// startCommsStateMonitor delivers the current state of the connection to the program, and starts monitoring
+// it, if not yet started for the program.
+func (s *State) startCommsStateMonitor() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exec := s.ProgramExecutor
+	if exec == nil || s.commsState.exec == exec {
+		return
+	}
+	s.commsState.exec = exec
+	s.commsState.connected = s.Opened
+	s.deliverProgramSubscriptionsLocked(protocol.GonbuiCommsStateAddress, s.commsState.connected)
+	go s.monitorCommsState(exec)
+}
+
+// monitorCommsState checks the connection with heartbeats, every CommsStateCheckInterval, until the program
+// finishes or unsubscribes.
+func (s *State) monitorCommsState(exec *jpyexec.Executor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	isMonitored := func() bool {
+		return s.ProgramExecutor == exec && s.commsState.exec == exec &&
+			s.AddressSubscriptions.Has(protocol.GonbuiCommsStateAddress)
+	}
+	for isMonitored() {
+		s.mu.Unlock()
+		time.Sleep(CommsStateCheckInterval)
+		s.mu.Lock()
+		if !isMonitored() {
+			break
+		}
+		connected := s.Opened
+		if connected && time.Since(s.LastMsgTime) > HeartbeatRequestThreshold {
+			heartbeat, err := s.sendHeartbeatPingLocked(s.ProgramExecMsg, HeartbeatTimeout)
+			if err != nil {
+				klog.V(1).Infof("comms: failed to check the connection with the front-end: %+v", err)
+			}
+			connected = err == nil && heartbeat
+			if !isMonitored() {
+				break
+			}
+		}
+		s.setCommsStateLocked(connected)
+	}
+	if s.commsState.exec == exec {
+		s.commsState.exec = nil
+	}
+}
+
+// setCommsStateLocked delivers the state of the connection to the program, if it is monitored and the state changed.
+func (s *State) setCommsStateLocked(connected bool) {
+	if s.commsState.exec == nil || s.commsState.exec != s.ProgramExecutor || s.commsState.connected == connected {
+		return
+	}
+	klog.V(1).Infof("comms: connection with the front-end changed to connected=%v, notifying program", connected)
+	s.commsState.connected = connected
+	s.deliverProgramSubscriptionsLocked(protocol.GonbuiCommsStateAddress, connected)
+}
@@ -0,0 +1,95 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// heartbeatMsg is a kernel.Message whose heartbeat pings are answered by the front-end, if reply is set.
+type heartbeatMsg struct {
+	*fakeMsg
+	s     *State
+	reply atomic.Bool
+}
+
+// Publish implements kernel.Message.
+func (m *heartbeatMsg) Publish(msgType string, content any) error {
+	c := content.(map[string]any)
+	if c["data"].(map[string]any)["address"] == HeartbeatPingAddress && m.reply.Load() {
+		// Replied once the State is unlocked, waiting for the pong.
+		go func() { _ = m.s.HandleMsg(frontEndMsg(c["comm_id"].(string), HeartbeatPongAddress, true)) }()
+	}
+	return m.fakeMsg.Publish(msgType, content)
+}
+
+// nextCommsState returns the next state of the connection delivered to the program.
+func nextCommsState(t *testing.T, program chan *protocol.CommValue) bool {
+	select {
+	case value := <-program:
+		require.Equal(t, protocol.GonbuiCommsStateAddress, value.Address)
+		return value.Value.(bool)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection state not delivered to the program")
+	}
+	return false
+}
+
+func TestCommsStateOpenClose(t *testing.T) {
+	// Closed by the front-end, and opened again.
+	s, program := openedState("c1")
+	require.NoError(t, s.HandleClose(commCloseMsg("c1")))
+	assert.False(t, nextCommsState(t, program))
+	require.NoError(t, s.HandleOpen(commOpenMsg("c2", map[string]any{"protocol_version": float64(websocket.ProtocolVersion)})))
+	assert.True(t, nextCommsState(t, program))
+
+	// Only changes are delivered: a new connection while opened is not.
+	require.NoError(t, s.HandleOpen(commOpenMsg("c3", map[string]any{"protocol_version": float64(websocket.ProtocolVersion)})))
+	assert.Empty(t, program)
+
+	// Closed by the kernel.
+	require.NoError(t, s.Close(nil))
+	assert.False(t, nextCommsState(t, program))
+
+	// Nothing is delivered if the program is not monitored.
+	s, program = openedState("c1")
+	s.commsState = commsStateMonitor{}
+	require.NoError(t, s.HandleClose(commCloseMsg("c1")))
+	assert.Empty(t, program)
+}
+
+func TestCommsStateHeartbeat(t *testing.T) {
+	previousInterval := CommsStateCheckInterval
+	CommsStateCheckInterval = 10 * time.Millisecond
+	defer func() { CommsStateCheckInterval = previousInterval }()
+
+	s, program := openedState("c1")
+	msg := &heartbeatMsg{fakeMsg: &fakeMsg{}, s: s}
+	s.ProgramExecMsg = msg
+	s.commsState = commsStateMonitor{}
+
+	// The current state is delivered when the monitoring starts.
+	s.startCommsStateMonitor()
+	assert.True(t, nextCommsState(t, program))
+
+	// Nothing heard from the front-end: heartbeats are not replied, the connection is lost.
+	assert.False(t, nextCommsState(t, program))
+
+	// The front-end answers again.
+	msg.reply.Store(true)
+	assert.True(t, nextCommsState(t, program))
+
+	// Monitoring stops when the program unsubscribes.
+	s.mu.Lock()
+	s.AddressSubscriptions.Delete(protocol.GonbuiCommsStateAddress)
+	s.mu.Unlock()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.commsState.exec == nil
+	}, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,140 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/common"
+	"k8s.io/klog/v2"
+	"strings"
+	"time"
+)
+
+// This file implements the garbage collection of the addresses of programs that have exited: the kernel keeps
+// state by address (the last value, for synchronization requests, reliable messages pending and statistics),
+// which in long-lived dashboard sessions would otherwise grow without bound.
+//
+// The addresses used by a program (sent to, read or subscribed) are marked as dead when it finishes, and
+// so are addresses messaged by the front-end that no program uses (e.g.: from widgets of previous executions
+// still displayed). Their state is dropped once they have been dead for AddressTTL, unless a new program
+// uses them in the meantime.
+
+// DefaultAddressTTL is the default time the state of the addresses of programs that have exited is kept,
+// see State.SetAddressTTL.
+var DefaultAddressTTL = 10 * time.Minute
+
+// AddressTTL returns the time the state of the addresses of programs that have exited is kept, or 0 if it
+// is never collected.
+func (s *State) AddressTTL() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addressTTLLocked()
+}
+
+func (s *State) addressTTLLocked() time.Duration {
+	switch {
+	case s.addressTTL == 0:
+		return DefaultAddressTTL
+	case s.addressTTL < 0:
+		return 0
+	}
+	return s.addressTTL
+}
+
+// SetAddressTTL sets the time the state of the addresses of programs that have exited is kept. A ttl of 0
+// resets it to DefaultAddressTTL, and a negative one disables the collection.
+func (s *State) SetAddressTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addressTTL = ttl
+	if s.gcTimer != nil {
+		s.gcTimer.Stop()
+		s.gcTimer = nil
+	}
+	s.scheduleGCLocked()
+}
+
+// useAddressLocked records the address as used by the program being executed.
+func (s *State) useAddressLocked(address string) {
+	if strings.HasPrefix(address, "#") {
+		return
+	}
+	if s.programAddresses == nil {
+		s.programAddresses = make(common.Set[string])
+	}
+	s.programAddresses.Insert(address)
+	delete(s.deadAddresses, address)
+}
+
+// useAddress is like useAddressLocked, but it acquires the lock.
+func (s *State) useAddress(address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.useAddressLocked(address)
+}
+
+// markDeadLocked marks the address as no longer used, if not yet marked, and schedules its collection.
+func (s *State) markDeadLocked(address string) {
+	if strings.HasPrefix(address, "#") {
+		return
+	}
+	if _, found := s.deadAddresses[address]; found {
+		return
+	}
+	if s.deadAddresses == nil {
+		s.deadAddresses = make(map[string]time.Time)
+	}
+	s.deadAddresses[address] = time.Now()
+	s.scheduleGCLocked()
+}
+
+// programAddressesDeadLocked marks all addresses used by the program as dead, when it finishes.
+func (s *State) programAddressesDeadLocked() {
+	for address := range s.programAddresses {
+		s.markDeadLocked(address)
+	}
+	s.programAddresses = nil
+}
+
+// scheduleGCLocked schedules the collection of the dead address that expires first, if not yet scheduled.
+func (s *State) scheduleGCLocked() {
+	ttl := s.addressTTLLocked()
+	if s.gcTimer != nil || len(s.deadAddresses) == 0 || ttl == 0 {
+		return
+	}
+	var first time.Time
+	for _, deadSince := range s.deadAddresses {
+		if first.IsZero() || deadSince.Before(first) {
+			first = deadSince
+		}
+	}
+	s.gcTimer = time.AfterFunc(time.Until(first.Add(ttl)), s.collectDeadAddresses)
+}
+
+// collectDeadAddresses drops the state of the addresses dead for longer than the AddressTTL.
+func (s *State) collectDeadAddresses() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gcTimer = nil
+	ttl := s.addressTTLLocked()
+	if ttl == 0 {
+		return
+	}
+	var collected int
+	for address, deadSince := range s.deadAddresses {
+		if time.Since(deadSince) < ttl {
+			continue
+		}
+		delete(s.deadAddresses, address)
+		delete(s.lastValues, address)
+		if p, found := s.pendingAcks[address]; found {
+			p.timer.Stop()
+			delete(s.pendingAcks, address)
+		}
+		delete(s.stats.Addresses, address)
+		collected++
+	}
+	if collected > 0 {
+		s.stats.AddressesCollected += collected
+		klog.V(1).Infof("comms: collected the state of %d addresses of programs that have exited (%d still to expire)",
+			collected, len(s.deadAddresses))
+	}
+	s.scheduleGCLocked()
+}
@@ -0,0 +1,115 @@
+package comms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSetAddressTTL(t *testing.T) {
+	s := New()
+	assert.Equal(t, DefaultAddressTTL, s.AddressTTL())
+	s.SetAddressTTL(time.Minute)
+	assert.Equal(t, time.Minute, s.AddressTTL())
+	s.SetAddressTTL(0)
+	assert.Equal(t, DefaultAddressTTL, s.AddressTTL(), "0 should reset it to the default")
+	s.SetAddressTTL(-1)
+	assert.Equal(t, time.Duration(0), s.AddressTTL(), "negative should disable the collection")
+}
+
+// addressState creates state (last value, reliable message pending and statistics) for the addresses.
+func addressState(s *State, addresses ...string) {
+	for _, address := range addresses {
+		s.recordLastValueLocked(address, address+" value")
+		if s.pendingAcks == nil {
+			s.pendingAcks = make(map[string]*pendingAck)
+		}
+		s.pendingAcks[address] = &pendingAck{address: address, timer: time.NewTimer(time.Hour)}
+		s.addressStatsLocked(address).MsgsOut++
+	}
+}
+
+// hasState returns whether the address still has its last value, reliable message pending and statistics.
+func hasState(t *testing.T, s *State, address string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, hasValue := s.lastValues[address]
+	_, hasPending := s.pendingAcks[address]
+	_, hasStats := s.stats.Addresses[address]
+	assert.Truef(t, hasValue == hasPending && hasPending == hasStats,
+		"state of %q partially collected: value=%v, pending=%v, stats=%v", address, hasValue, hasPending, hasStats)
+	return hasValue
+}
+
+func TestCollectDeadAddresses(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	s := New()
+	s.SetAddressTTL(ttl)
+	s.mu.Lock()
+	addressState(s, "/expired", "/recent", "/used", "/resurrected")
+	for _, address := range []string{"/expired", "/recent", "/used", "/resurrected", "#internal"} {
+		s.useAddressLocked(address)
+	}
+	assert.False(t, s.programAddresses.Has("#internal"), "internal addresses should not be tracked")
+	pendingExpired := s.pendingAcks["/expired"]
+
+	// Addresses of the program are marked dead when it finishes, except those used again.
+	s.programAddresses.Delete("/used")
+	s.programAddressesDeadLocked()
+	assert.Empty(t, s.programAddresses)
+	assert.Len(t, s.deadAddresses, 3)
+	assert.NotNil(t, s.gcTimer, "collection should be scheduled")
+	s.markDeadLocked("#internal")
+	assert.NotContains(t, s.deadAddresses, "#internal")
+	deadSince := s.deadAddresses["/recent"]
+	s.markDeadLocked("/recent")
+	assert.Equal(t, deadSince, s.deadAddresses["/recent"], "marking it dead again should keep the original time")
+
+	// A new program using the address resurrects it.
+	s.useAddressLocked("/resurrected")
+	assert.NotContains(t, s.deadAddresses, "/resurrected")
+
+	// Only expired addresses are collected, and the collection is rescheduled for the remaining ones.
+	s.deadAddresses["/expired"] = time.Now().Add(-2 * ttl)
+	s.deadAddresses["/recent"] = time.Now()
+	s.gcTimer.Stop()
+	s.gcTimer = nil
+	s.mu.Unlock()
+	s.collectDeadAddresses()
+	assert.False(t, hasState(t, s, "/expired"))
+	assert.False(t, pendingExpired.timer.Stop(), "timer of the reliable message of the address should be stopped")
+	assert.True(t, hasState(t, s, "/recent"))
+	assert.True(t, hasState(t, s, "/used"))
+	assert.True(t, hasState(t, s, "/resurrected"))
+	assert.Equal(t, 1, s.Stats().AddressesCollected)
+	s.mu.Lock()
+	assert.NotContains(t, s.deadAddresses, "/expired")
+	assert.NotNil(t, s.gcTimer, "collection of the remaining dead address should be rescheduled")
+	s.mu.Unlock()
+
+	// The rescheduled collection drops the remaining one.
+	require.Eventually(t, func() bool { return !hasState(t, s, "/recent") }, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 2, s.Stats().AddressesCollected)
+	s.mu.Lock()
+	assert.Empty(t, s.deadAddresses)
+	assert.Nil(t, s.gcTimer, "nothing left to collect")
+	s.mu.Unlock()
+	assert.True(t, hasState(t, s, "/used"))
+	assert.True(t, hasState(t, s, "/resurrected"))
+
+	// With the collection disabled, dead addresses are kept.
+	s.SetAddressTTL(-1)
+	s.mu.Lock()
+	s.markDeadLocked("/used")
+	s.deadAddresses["/used"] = time.Now().Add(-time.Hour)
+	assert.Nil(t, s.gcTimer)
+	s.mu.Unlock()
+	s.collectDeadAddresses()
+	assert.True(t, hasState(t, s, "/used"))
+
+	// Enabling it again schedules the collection.
+	s.SetAddressTTL(ttl)
+	require.Eventually(t, func() bool { return !hasState(t, s, "/used") }, 5*time.Second, 5*time.Millisecond)
+	assert.True(t, hasState(t, s, "/resurrected"))
+}
@@ -21,6 +21,7 @@ func (s *State) ProgramStart(exec *jpyexec.Executor) {
 
 	klog.V(2).Infof("comms: ProgramStart()")
 	s.AddressSubscriptions = make(common.Set[string])
+	s.programAddressesDeadLocked() // In case the previous program didn't finish cleanly.
 	s.ProgramExecutor = exec
 	s.ProgramExecMsg = exec.Msg
 }
@@ -31,16 +32,44 @@ func (s *State) ProgramFinished() {
 	defer s.mu.Unlock()
 
 	klog.V(2).Infof("comms: ProgramFinished()")
+	s.flushStreamsLocked()
 	s.AddressSubscriptions = make(common.Set[string])
+	s.programAddressesDeadLocked()
 	s.ProgramExecMsg = nil
 	s.ProgramExecutor = nil
 }
 
+// CloseProgramStdin closes the stdin of the program being executed, if any, so it reads an EOF.
+// It handles the messages of the front-end to protocol.GonbuiStdinCloseAddress.
+func (s *State) CloseProgramStdin() {
+	s.mu.Lock()
+	exec := s.ProgramExecutor
+	s.mu.Unlock()
+	if exec == nil {
+		klog.V(1).Infof("comms: request to close stdin ignored, no program being executed")
+		return
+	}
+	exec.CloseStdin()
+}
+
 // ProgramSendValueRequest handler, it implements jpyexec.CommsHandler.
 // It sends a value to the front-end.
 //
 // It also tries to install the WebSocket, if not yet installed.
 func (s *State) ProgramSendValueRequest(address string, value any) {
+	s.programSendValue(address, value, false)
+}
+
+// ProgramSendReliableValueRequest handler, it implements jpyexec.CommsHandler.
+// It sends a value to the front-end that is re-sent until acknowledged, see SendReliable.
+//
+// It also tries to install the WebSocket, if not yet installed.
+func (s *State) ProgramSendReliableValueRequest(address string, value any) {
+	s.programSendValue(address, value, true)
+}
+
+// programSendValue implements ProgramSendValueRequest and ProgramSendReliableValueRequest.
+func (s *State) programSendValue(address string, value any, reliable bool) {
 	// Notice the program may end while handling this request, so we save the value
 	// of the msg that will be used to complete the request, even if the program ends.
 	msg := s.ProgramExecMsg
@@ -51,7 +80,7 @@ func (s *State) ProgramSendValueRequest(address string, value any) {
 		return
 	}
 	if klog.V(2).Enabled() {
-		klog.Infof("comms: ValueUpdate: address=%q, value=%v", address, value)
+		klog.Infof("comms: ValueUpdate: address=%q, value=%v, reliable=%v", address, value, reliable)
 	}
 	err := s.InstallWebSocket(msg)
 	if err != nil {
@@ -64,7 +93,18 @@ func (s *State) ProgramSendValueRequest(address string, value any) {
 		return
 	}
 
-	err = s.Send(msg, address, value)
+	s.useAddress(address)
+	if isStreamAddress(address) {
+		// Batched, and not recorded: the text written is not the state of the address.
+		s.streamWrite(msg, address, value)
+		return
+	}
+	s.recordLastValue(address, value)
+	if reliable {
+		err = s.SendReliable(msg, address, value)
+	} else {
+		err = s.Send(msg, address, value)
+	}
 	if err != nil {
 		klog.Infof("Failed to send to value (%v) to address %q in the front-end -- widgets may mal-function. "+
 			"Consider restarting the GoNB kernel. "+
@@ -94,6 +134,7 @@ func (s *State) ProgramReadValueRequest(address string) {
 	if klog.V(2).Enabled() {
 		klog.Infof("comms: ReadValue: address=%q", address)
 	}
+	s.useAddress(address)
 	err := s.InstallWebSocket(msg)
 	if err != nil {
 		klog.Infof("Failed to install WebSocket in front-end, used to communicate with programs, "+
@@ -132,8 +173,13 @@ func (s *State) ProgramSubscribeRequest(address string) {
 		klog.Infof("comms: SubscribeRequest: address=%q", address)
 	}
 	s.AddressSubscriptions.Insert(address)
+	s.useAddress(address)
 
 	err := s.InstallWebSocket(msg)
+	if address == protocol.GonbuiCommsStateAddress {
+		// Reports the state even if the installation failed.
+		s.startCommsStateMonitor()
+	}
 	if err != nil {
 		klog.Infof("Failed to install WebSocket in front-end, used to communicate with programs, "+
 			"in particular widgets -- those will not work. Error message: %+v", err)
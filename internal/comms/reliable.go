@@ -0,0 +1,147 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// This file implements "reliable" (at-least-once) messages to the front-end: each one is sent with a
+// sequence number ("seq"), and re-sent with exponential backoff until the front-end acknowledges it with a
+// message to CommAckAddress.
+//
+// Only the latest value sent to an address is retried: a new reliable message to the same address
+// supersedes any pending one, and the front-end drops values older than the last one it delivered. So a
+// transient problem with the websocket doesn't leave a widget displaying a stale value.
+
+const (
+	// CommAckAddress is messaged by the front-end in acknowledgement of a reliable message, with its
+	// sequence number as value.
+	CommAckAddress = "#comm_ack"
+)
+
+var (
+	// ReliableRetryDelay is the delay before re-sending a reliable message not acknowledged. It doubles
+	// at every attempt, up to ReliableMaxRetryDelay.
+	ReliableRetryDelay = 250 * time.Millisecond
+
+	// ReliableMaxRetryDelay is the maximum delay between attempts of sending a reliable message.
+	ReliableMaxRetryDelay = 8 * time.Second
+
+	// ReliableMaxAttempts is the number of times a reliable message is sent before giving up.
+	ReliableMaxAttempts = 10
+)
+
+// pendingAck is a reliable message waiting for its acknowledgement.
+type pendingAck struct {
+	seq      int64
+	address  string
+	value    any
+	msg      kernel.Message
+	attempts int
+	timer    *time.Timer
+}
+
+// SendReliable sends the value to the given address in the front-end, like Send, but it is re-sent until
+// the front-end acknowledges it: see ReliableRetryDelay and ReliableMaxAttempts.
+//
+// A `[]byte` value is sent as a binary buffer, and received by the front-end as an `ArrayBuffer`.
+//
+// An error is returned if the first attempt fails, but it is still retried.
+func (s *State) SendReliable(msg kernel.Message, address string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pendingAcks == nil {
+		s.pendingAcks = make(map[string]*pendingAck)
+	}
+	if previous, found := s.pendingAcks[address]; found {
+		// Superseded by the new value.
+		previous.timer.Stop()
+	}
+	s.lastReliableSeq++
+	p := &pendingAck{
+		seq:     s.lastReliableSeq,
+		address: address,
+		value:   value,
+		msg:     msg,
+	}
+	s.pendingAcks[address] = p
+	return s.sendReliableLocked(p)
+}
+
+// sendReliableLocked sends the pending message, and schedules its retry.
+func (s *State) sendReliableLocked(p *pendingAck) error {
+	p.attempts++
+	p.timer = time.AfterFunc(reliableRetryDelay(p.attempts), func() { s.retryReliable(p) })
+	if binary, ok := p.value.([]byte); ok {
+		return s.sendBinaryLocked(p.msg, map[string]any{
+			"address": p.address,
+			"seq":     p.seq,
+		}, binary)
+	}
+	return s.sendDataLocked(p.msg, map[string]any{
+		"address": p.address,
+		"value":   p.value,
+		"seq":     p.seq,
+	})
+}
+
+// reliableRetryDelay returns the delay before the retry of a reliable message sent the given number of times.
+func reliableRetryDelay(attempts int) time.Duration {
+	delay := ReliableRetryDelay << (attempts - 1)
+	if delay > ReliableMaxRetryDelay || delay <= 0 {
+		delay = ReliableMaxRetryDelay
+	}
+	return delay
+}
+
+// retryReliable is called when the acknowledgement of a reliable message times out.
+func (s *State) retryReliable(p *pendingAck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pendingAcks[p.address] != p {
+		// Acknowledged or superseded in the meantime.
+		return
+	}
+	if p.attempts >= ReliableMaxAttempts {
+		klog.Warningf("comms: message to address %q (seq=%d) not acknowledged by the front-end after %d attempts, "+
+			"giving up -- widgets may be out-of-sync", p.address, p.seq, p.attempts)
+		delete(s.pendingAcks, p.address)
+		return
+	}
+	klog.V(1).Infof("comms: message to address %q (seq=%d) not acknowledged, re-sending (attempt %d)",
+		p.address, p.seq, p.attempts+1)
+	s.addressStatsLocked(p.address).Retries++
+	if err := s.sendReliableLocked(p); err != nil {
+		klog.Warningf("comms: failed to re-send message to address %q: %+v", p.address, err)
+	}
+}
+
+// handleAckLocked handles the acknowledgement of a reliable message.
+func (s *State) handleAckLocked(content map[string]any) {
+	seqValue, err := getFromJson[float64](content, "data/value")
+	if err != nil {
+		klog.Warningf("comms: invalid %q message: %+v", CommAckAddress, err)
+		return
+	}
+	seq := int64(seqValue)
+	for address, p := range s.pendingAcks {
+		if p.seq == seq {
+			klog.V(2).Infof("comms: message to address %q (seq=%d) acknowledged", address, seq)
+			p.timer.Stop()
+			delete(s.pendingAcks, address)
+			return
+		}
+	}
+	klog.V(2).Infof("comms: acknowledgement of seq=%d ignored, it is no longer pending", seq)
+}
+
+// clearPendingAcksLocked drops the reliable messages still pending, when the connection is closed.
+func (s *State) clearPendingAcksLocked() {
+	for _, p := range s.pendingAcks {
+		p.timer.Stop()
+	}
+	s.pendingAcks = nil
+}
@@ -0,0 +1,103 @@
+package comms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// setReliableRetries configures the retries of reliable messages for the test.
+func setReliableRetries(t *testing.T, delay, maxDelay time.Duration, maxAttempts int) {
+	previousDelay, previousMaxDelay, previousMaxAttempts := ReliableRetryDelay, ReliableMaxRetryDelay, ReliableMaxAttempts
+	ReliableRetryDelay, ReliableMaxRetryDelay, ReliableMaxAttempts = delay, maxDelay, maxAttempts
+	t.Cleanup(func() {
+		ReliableRetryDelay, ReliableMaxRetryDelay, ReliableMaxAttempts = previousDelay, previousMaxDelay, previousMaxAttempts
+	})
+}
+
+// pending returns the reliable message pending for the address, or nil.
+func (s *State) pending(address string) *pendingAck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pendingAcks[address]
+}
+
+func TestReliableRetryDelay(t *testing.T) {
+	setReliableRetries(t, 10*time.Millisecond, 50*time.Millisecond, 10)
+	var delays []time.Duration
+	for attempts := 1; attempts <= 5; attempts++ {
+		delays = append(delays, reliableRetryDelay(attempts))
+	}
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond,
+		50 * time.Millisecond, 50 * time.Millisecond}, delays)
+	assert.Equal(t, 50*time.Millisecond, reliableRetryDelay(100), "overflows should be capped")
+}
+
+func TestSendReliableRetries(t *testing.T) {
+	setReliableRetries(t, time.Millisecond, 4*time.Millisecond, 5)
+	s, _ := openedState("c1")
+	s.pendingAcks = nil
+	msg := &fakeMsg{}
+
+	// The message is re-sent until ReliableMaxAttempts, and then dropped.
+	require.NoError(t, s.SendReliable(msg, "/a", 7))
+	require.Eventually(t, func() bool { return s.pending("/a") == nil }, 5*time.Second, time.Millisecond)
+	published := msg.Published()
+	require.Len(t, published, 5)
+	for _, data := range published {
+		assert.Equal(t, map[string]any{"address": "/a", "value": 7, "seq": int64(1)}, data)
+	}
+	assert.Equal(t, 4, s.Stats().Addresses["/a"].Retries)
+	assert.Equal(t, 5, s.Stats().Addresses["/a"].MsgsOut)
+
+	// Binary values are sent as buffers.
+	msg = &fakeMsg{}
+	require.NoError(t, s.SendReliable(msg, "/binary", []byte{1, 2}))
+	require.Eventually(t, func() bool { return s.pending("/binary") == nil }, 5*time.Second, time.Millisecond)
+	require.Len(t, msg.Published(), 5)
+	assert.Equal(t, map[string]any{"address": "/binary", "value": []byte{1, 2}, "seq": int64(2)}, msg.Published()[0])
+}
+
+func TestSendReliableAck(t *testing.T) {
+	setReliableRetries(t, time.Hour, time.Hour, 5)
+	s, _ := openedState("c1")
+	s.pendingAcks = nil
+	msg := &fakeMsg{}
+
+	// A new value supersedes the pending one.
+	require.NoError(t, s.SendReliable(msg, "/a", 1))
+	first := s.pending("/a")
+	require.NoError(t, s.SendReliable(msg, "/a", 2))
+	second := s.pending("/a")
+	assert.Equal(t, 2, second.value)
+	assert.Greater(t, second.seq, first.seq)
+	assert.False(t, first.timer.Stop(), "timer of the superseded message should be stopped")
+	s.retryReliable(first)
+	assert.Len(t, msg.Published(), 2, "superseded message should not be re-sent")
+	assert.Equal(t, 2, msg.Published()[1]["value"])
+
+	// The acknowledgement of the superseded message is ignored.
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", CommAckAddress, float64(first.seq))))
+	assert.Equal(t, second, s.pending("/a"))
+
+	// The acknowledgement of the pending message clears it.
+	require.NoError(t, s.SendReliable(msg, "/b", 3))
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", CommAckAddress, float64(second.seq))))
+	assert.Nil(t, s.pending("/a"))
+	assert.NotNil(t, s.pending("/b"))
+	assert.False(t, second.timer.Stop(), "timer of the acknowledged message should be stopped")
+	s.retryReliable(second)
+	assert.Len(t, msg.Published(), 3, "acknowledged message should not be re-sent")
+
+	// Closing the connection stops all the pending messages.
+	pendingB := s.pending("/b")
+	require.NoError(t, s.SendReliable(msg, "/c", 4))
+	pendingC := s.pending("/c")
+	s.mu.Lock()
+	s.clearPendingAcksLocked()
+	s.mu.Unlock()
+	assert.Nil(t, s.pendingAcks)
+	assert.False(t, pendingB.timer.Stop(), "timers of the pending messages should be stopped")
+	assert.False(t, pendingC.timer.Stop(), "timers of the pending messages should be stopped")
+}
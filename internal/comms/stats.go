@@ -0,0 +1,212 @@
+package comms
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/metrics"
+	"sort"
+	"strings"
+	"time"
+)
+
+// This file keeps statistics of the messages exchanged with the front-end, by address, reported with
+// `%comms stats`: they give widget authors the data to tune the frequency of their updates.
+
+// MaxHeartbeatHistory is the number of heartbeat round-trip times kept in the statistics.
+var MaxHeartbeatHistory = 20
+
+// AddressStats are the statistics of the messages sent to one address.
+type AddressStats struct {
+	// MsgsIn and BytesIn count the messages (and the size of their values) received from the front-end.
+	MsgsIn, BytesIn int
+
+	// MsgsOut and BytesOut count the messages (and the size of their values) sent to the front-end.
+	MsgsOut, BytesOut int
+
+	// Dropped counts the messages received from the front-end that had no recipient: usually because no
+	// program was subscribed to the address.
+	Dropped int
+
+	// Retries counts the reliable messages (see SendReliable) re-sent, because they were not acknowledged.
+	Retries int
+}
+
+// Stats of the comms channel.
+type Stats struct {
+	// Since when the statistics are collected: the start of the kernel, or the last reset.
+	Since time.Time
+
+	// Addresses holds the statistics of each address.
+	Addresses map[string]*AddressStats
+
+	// HeartbeatRTTs are the round-trip times of the last MaxHeartbeatHistory heartbeats, the most recent last.
+	HeartbeatRTTs []time.Duration
+
+	// HeartbeatTimeouts counts the heartbeats not replied in time.
+	HeartbeatTimeouts int
+
+	// AddressesCollected counts the addresses of programs that have exited whose state (including these
+	// statistics) was dropped, see State.SetAddressTTL.
+	AddressesCollected int
+}
+
+// Stats returns a copy of the statistics of the comms channel.
+func (s *State) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Addresses = make(map[string]*AddressStats, len(s.stats.Addresses))
+	for address, addressStats := range s.stats.Addresses {
+		copied := *addressStats
+		stats.Addresses[address] = &copied
+	}
+	stats.HeartbeatRTTs = append([]time.Duration(nil), s.stats.HeartbeatRTTs...)
+	if stats.Since.IsZero() {
+		stats.Since = metrics.StartTime
+	}
+	return stats
+}
+
+// ResetStats erases the statistics collected so far.
+func (s *State) ResetStats() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = Stats{Since: time.Now()}
+}
+
+// addressStatsLocked returns the statistics of the address, creating them if needed.
+func (s *State) addressStatsLocked(address string) *AddressStats {
+	if s.stats.Addresses == nil {
+		s.stats.Addresses = make(map[string]*AddressStats)
+	}
+	addressStats, found := s.stats.Addresses[address]
+	if !found {
+		addressStats = &AddressStats{}
+		s.stats.Addresses[address] = addressStats
+	}
+	return addressStats
+}
+
+// recordOutLocked records a message sent to the front-end.
+func (s *State) recordOutLocked(address string, value any) {
+	size := valueSize(value)
+	addressStats := s.addressStatsLocked(address)
+	addressStats.MsgsOut++
+	addressStats.BytesOut += size
+	metrics.CommsMessages.With("out").Inc()
+	metrics.CommsBytes.With("out").Add(float64(size))
+}
+
+// recordInLocked records a message received from the front-end, and whether it was dropped.
+func (s *State) recordInLocked(address string, value any, dropped bool) {
+	size := valueSize(value)
+	addressStats := s.addressStatsLocked(address)
+	addressStats.MsgsIn++
+	addressStats.BytesIn += size
+	metrics.CommsMessages.With("in").Inc()
+	metrics.CommsBytes.With("in").Add(float64(size))
+	if dropped {
+		addressStats.Dropped++
+		metrics.CommsDropped.Inc()
+	}
+}
+
+// recordHeartbeatLocked records the round-trip time of a heartbeat, or a timeout if `replied` is false.
+func (s *State) recordHeartbeatLocked(rtt time.Duration, replied bool) {
+	if !replied {
+		s.stats.HeartbeatTimeouts++
+		return
+	}
+	s.stats.HeartbeatRTTs = append(s.stats.HeartbeatRTTs, rtt)
+	if excess := len(s.stats.HeartbeatRTTs) - MaxHeartbeatHistory; excess > 0 {
+		s.stats.HeartbeatRTTs = s.stats.HeartbeatRTTs[excess:]
+	}
+}
+
+// valueSize returns the size of the value in the messages: the length of binary values, or of
+// the JSON encoding of the other values.
+func valueSize(value any) int {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
+
+// StatsReport returns the statistics of the comms channel formatted in Markdown, with the busiest addresses
+// first.
+func (s *State) StatsReport() string {
+	stats := s.Stats()
+	var report strings.Builder
+	report.WriteString("### Comms Statistics\n\n")
+	elapsed := time.Since(stats.Since)
+	_, _ = fmt.Fprintf(&report, "Since %s (%s).\n\n", stats.Since.Format(time.DateTime), elapsed.Round(time.Second))
+	if len(stats.Addresses) == 0 {
+		report.WriteString("No messages exchanged with the front-end yet.\n")
+	} else {
+		addresses := make([]string, 0, len(stats.Addresses))
+		for address := range stats.Addresses {
+			addresses = append(addresses, address)
+		}
+		total := func(address string) int {
+			return stats.Addresses[address].MsgsIn + stats.Addresses[address].MsgsOut
+		}
+		sort.Slice(addresses, func(i, j int) bool {
+			if total(addresses[i]) != total(addresses[j]) {
+				return total(addresses[i]) > total(addresses[j])
+			}
+			return addresses[i] < addresses[j]
+		})
+		report.WriteString("| Address | Msgs in | Bytes in | Msgs out | Bytes out | Out rate | Dropped | Retries |\n" +
+			"|---|--:|--:|--:|--:|--:|--:|--:|\n")
+		for _, address := range addresses {
+			a := stats.Addresses[address]
+			_, _ = fmt.Fprintf(&report, "| `%s` | %d | %s | %d | %s | %.1f/s | %d | %d |\n",
+				address, a.MsgsIn, formatBytes(a.BytesIn), a.MsgsOut, formatBytes(a.BytesOut),
+				float64(a.MsgsOut)/elapsed.Seconds(), a.Dropped, a.Retries)
+		}
+	}
+	if stats.AddressesCollected > 0 {
+		_, _ = fmt.Fprintf(&report, "\nState of %d addresses of programs that have exited collected.\n", stats.AddressesCollected)
+	}
+
+	report.WriteString("\n#### Heartbeat\n\n")
+	if len(stats.HeartbeatRTTs) == 0 {
+		_, _ = fmt.Fprintf(&report, "No heartbeat replied yet (%d timed out).\n", stats.HeartbeatTimeouts)
+		return report.String()
+	}
+	var sum, maxRTT time.Duration
+	minRTT := stats.HeartbeatRTTs[0]
+	rtts := make([]string, 0, len(stats.HeartbeatRTTs))
+	for _, rtt := range stats.HeartbeatRTTs {
+		sum += rtt
+		minRTT = min(minRTT, rtt)
+		maxRTT = max(maxRTT, rtt)
+		rtts = append(rtts, rtt.Round(time.Microsecond*100).String())
+	}
+	average := sum / time.Duration(len(stats.HeartbeatRTTs))
+	_, _ = fmt.Fprintf(&report, "Round-trip time of the last %d heartbeats: min %s, average %s, max %s (%d timed out).\n\n",
+		len(stats.HeartbeatRTTs), minRTT.Round(time.Microsecond*100), average.Round(time.Microsecond*100),
+		maxRTT.Round(time.Microsecond*100), stats.HeartbeatTimeouts)
+	_, _ = fmt.Fprintf(&report, "History (most recent last): %s\n", strings.Join(rtts, ", "))
+	return report.String()
+}
+
+// formatBytes formats a number of bytes in human-readable form.
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,131 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsCounters(t *testing.T) {
+	s, program := openedState("c1")
+	s.LastMsgTime = time.Now()
+	s.AddressSubscriptions.Insert("/sub")
+	msg := &fakeMsg{}
+	droppedBefore := metrics.CommsDropped.Value()
+	inBefore := metrics.CommsMessages.With("in").Value()
+
+	// Messages from the front-end: delivered to the program if subscribed, otherwise dropped.
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", "/sub", "abc")))
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", "/nobody", 1.5)))
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", "/nobody", []any{1.0, 2.0})))
+	require.Len(t, program, 1)
+
+	// Messages to the front-end.
+	require.NoError(t, s.Send(msg, "/sub", map[string]any{"x": 1}))
+	require.NoError(t, s.Send(msg, "/sub", []byte{1, 2, 3, 4}))
+
+	stats := s.Stats()
+	assert.Equal(t, AddressStats{MsgsIn: 1, BytesIn: 3, MsgsOut: 2, BytesOut: len(`{"x":1}`) + 4}, *stats.Addresses["/sub"])
+	assert.Equal(t, AddressStats{MsgsIn: 2, BytesIn: len("1.5") + len("[1,2]"), Dropped: 2}, *stats.Addresses["/nobody"])
+	assert.Equal(t, 2.0, metrics.CommsDropped.Value()-droppedBefore)
+	assert.Equal(t, 3.0, metrics.CommsMessages.With("in").Value()-inBefore)
+
+	// Stats returns a copy.
+	stats.Addresses["/sub"].MsgsIn = 100
+	assert.Equal(t, 1, s.Stats().Addresses["/sub"].MsgsIn)
+
+	// ResetStats erases everything.
+	before := time.Now()
+	s.ResetStats()
+	stats = s.Stats()
+	assert.Empty(t, stats.Addresses)
+	assert.False(t, stats.Since.Before(before))
+}
+
+func TestStatsHeartbeat(t *testing.T) {
+	s, _ := openedState("c1")
+	s.LastMsgTime = time.Now()
+	msg := &fakeMsg{}
+
+	// A heartbeat not replied in time is counted as a timeout.
+	heartbeat, err := s.SendHeartbeatAndWait(msg, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, heartbeat)
+	assert.Equal(t, 1, s.Stats().HeartbeatTimeouts)
+	assert.Empty(t, s.Stats().HeartbeatRTTs)
+
+	// A heartbeat replied records its round-trip time.
+	replied := make(chan bool)
+	go func() {
+		heartbeat, err := s.SendHeartbeatAndWait(msg, 5*time.Second)
+		assert.NoError(t, err)
+		replied <- heartbeat
+	}()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.HeartbeatPongLatch != nil
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", HeartbeatPongAddress, true)))
+	assert.True(t, <-replied)
+	stats := s.Stats()
+	assert.Equal(t, 1, stats.HeartbeatTimeouts)
+	require.Len(t, stats.HeartbeatRTTs, 1)
+	assert.GreaterOrEqual(t, stats.HeartbeatRTTs[0], 5*time.Millisecond)
+	assert.Equal(t, stats.HeartbeatRTTs[0].Seconds(), metrics.HeartbeatRTTSeconds.Value())
+
+	// Only the last MaxHeartbeatHistory round-trip times are kept.
+	previousHistory := MaxHeartbeatHistory
+	MaxHeartbeatHistory = 3
+	defer func() { MaxHeartbeatHistory = previousHistory }()
+	s.ResetStats()
+	s.mu.Lock()
+	for ii := 1; ii <= 5; ii++ {
+		s.recordHeartbeatLocked(time.Duration(ii)*time.Millisecond, true)
+	}
+	s.mu.Unlock()
+	assert.Equal(t, []time.Duration{3 * time.Millisecond, 4 * time.Millisecond, 5 * time.Millisecond},
+		s.Stats().HeartbeatRTTs)
+}
+
+func TestStatsReport(t *testing.T) {
+	s, _ := openedState("c1")
+	s.ResetStats()
+	report := s.StatsReport()
+	assert.Contains(t, report, "No messages exchanged with the front-end yet.")
+	assert.Contains(t, report, "No heartbeat replied yet (0 timed out).")
+
+	s.mu.Lock()
+	s.addressStatsLocked("/quiet").MsgsIn = 1
+	busy := s.addressStatsLocked("/busy")
+	busy.MsgsOut, busy.BytesOut, busy.Dropped, busy.Retries = 10, 3<<20, 2, 4
+	s.stats.HeartbeatTimeouts = 1
+	s.stats.AddressesCollected = 2
+	for _, rtt := range []time.Duration{2 * time.Millisecond, 4 * time.Millisecond, 3 * time.Millisecond} {
+		s.recordHeartbeatLocked(rtt, true)
+	}
+	s.mu.Unlock()
+
+	// Busiest addresses first.
+	report = s.StatsReport()
+	busyRow := strings.Index(report, "| `/busy` | 0 | 0 B | 10 | 3.0 MiB |")
+	quietRow := strings.Index(report, "| `/quiet` | 1 | 0 B | 0 | 0 B |")
+	require.Positive(t, busyRow, report)
+	require.Positive(t, quietRow, report)
+	assert.Less(t, busyRow, quietRow)
+	assert.Contains(t, report, "| 2 | 4 |\n", "dropped and retries of /busy")
+	assert.Contains(t, report, "State of 2 addresses of programs that have exited collected.")
+	assert.Contains(t, report, "Round-trip time of the last 3 heartbeats: min 2ms, average 3ms, max 4ms (1 timed out).")
+	assert.Contains(t, report, "History (most recent last): 2ms, 4ms, 3ms")
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+	assert.Equal(t, "2.0 MiB", formatBytes(2<<20))
+}
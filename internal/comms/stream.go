@@ -0,0 +1,96 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+	"strings"
+	"time"
+)
+
+// This file implements the bridge of the streams created by the programs with `gonbui.NewStream`: the text
+// written by the program (sent to the addresses prefixed by protocol.GonbuiStreamAddressPrefix) is batched by
+// the kernel, so a program writing lots of small chunks (e.g.: a line per metric) doesn't flood the front-end
+// with one message per write. Batches are sent after StreamFlushInterval, or once they reach StreamMaxBatch.
+
+var (
+	// StreamFlushInterval is the longest the text written to a stream waits in the kernel before it is sent.
+	StreamFlushInterval = 50 * time.Millisecond
+
+	// StreamMaxBatch is the size at which the text batched for a stream is sent right away.
+	StreamMaxBatch = 64 * 1024
+)
+
+// streamBatch is the text written to a stream, waiting to be sent.
+type streamBatch struct {
+	msg   kernel.Message
+	data  strings.Builder
+	timer *time.Timer
+}
+
+// isStreamAddress returns whether the address is of a stream created with `gonbui.NewStream`.
+func isStreamAddress(address string) bool {
+	return strings.HasPrefix(address, protocol.GonbuiStreamAddressPrefix)
+}
+
+// streamWrite handles a value sent by the program to a stream: text (a string) is batched, anything else (e.g.:
+// the closing of the stream) flushes the batch and is sent as is.
+func (s *State) streamWrite(msg kernel.Message, address string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chunk, isText := value.(string)
+	if !isText {
+		s.flushStreamLocked(address)
+		s.sendStreamLocked(msg, address, value)
+		return
+	}
+	if s.streams == nil {
+		s.streams = make(map[string]*streamBatch)
+	}
+	batch, found := s.streams[address]
+	if !found {
+		batch = &streamBatch{msg: msg}
+		batch.timer = time.AfterFunc(StreamFlushInterval, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.streams[address] == batch {
+				s.flushStreamLocked(address)
+			}
+		})
+		s.streams[address] = batch
+	}
+	batch.data.WriteString(chunk)
+	if batch.data.Len() >= StreamMaxBatch {
+		s.flushStreamLocked(address)
+	}
+}
+
+// flushStreamLocked sends the text batched for the stream, if any. It assumes s.mu is locked.
+func (s *State) flushStreamLocked(address string) {
+	batch, found := s.streams[address]
+	if !found {
+		return
+	}
+	delete(s.streams, address)
+	batch.timer.Stop()
+	s.sendStreamLocked(batch.msg, address, map[string]any{"data": batch.data.String()})
+}
+
+// flushStreamsLocked sends the text batched for all streams, e.g.: when the program finishes. It assumes s.mu is
+// locked.
+func (s *State) flushStreamsLocked() {
+	for address := range s.streams {
+		s.flushStreamLocked(address)
+	}
+}
+
+// sendStreamLocked sends the value to the stream address in the front-end. It assumes s.mu is locked.
+func (s *State) sendStreamLocked(msg kernel.Message, address string, value any) {
+	err := s.sendDataLocked(msg, map[string]any{
+		"address": address,
+		"value":   value,
+	})
+	if err != nil {
+		klog.Infof("Failed to send to stream %q in the front-end: %+v", address, err)
+	}
+}
@@ -0,0 +1,69 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// setStreamBatching configures the batching of the streams for the test.
+func setStreamBatching(t *testing.T, interval time.Duration, maxBatch int) {
+	previousInterval, previousMaxBatch := StreamFlushInterval, StreamMaxBatch
+	StreamFlushInterval, StreamMaxBatch = interval, maxBatch
+	t.Cleanup(func() { StreamFlushInterval, StreamMaxBatch = previousInterval, previousMaxBatch })
+}
+
+func TestStreamRouting(t *testing.T) {
+	setStreamBatching(t, 20*time.Millisecond, 10)
+	s, _ := openedState("c1")
+	s.LastMsgTime = time.Now()
+	msg := &fakeMsg{}
+	s.ProgramExecMsg = msg
+	metrics := protocol.GonbuiStreamAddressPrefix + "metrics"
+	logs := protocol.GonbuiStreamAddressPrefix + "logs"
+
+	// Text written is batched per stream, and sent after StreamFlushInterval to the stream address.
+	s.ProgramSendValueRequest(metrics, "a=1\n")
+	s.ProgramSendValueRequest(logs, "started\n")
+	s.ProgramSendValueRequest(metrics, "a=2\n")
+	assert.Empty(t, msg.Published(), "text should be batched")
+	require.Eventually(t, func() bool { return len(msg.Published()) == 2 }, 5*time.Second, time.Millisecond)
+	assert.ElementsMatch(t, []map[string]any{
+		{"address": metrics, "value": map[string]any{"data": "a=1\na=2\n"}},
+		{"address": logs, "value": map[string]any{"data": "started\n"}},
+	}, msg.Published())
+	assert.Equal(t, []string{"c1", "c1"}, msg.Recipients())
+
+	// Once the batch reaches StreamMaxBatch, it is sent right away.
+	msg = &fakeMsg{}
+	s.ProgramExecMsg = msg
+	s.ProgramSendValueRequest(metrics, "a=3\n")
+	s.ProgramSendValueRequest(metrics, "a=4\na=5\n")
+	require.Len(t, msg.Published(), 1)
+	assert.Equal(t, map[string]any{"address": metrics, "value": map[string]any{"data": "a=3\na=4\na=5\n"}},
+		msg.Published()[0])
+
+	// Closing the stream flushes the text batched, and then sends the closing.
+	s.ProgramSendValueRequest(metrics, "a=6\n")
+	s.ProgramSendValueRequest(metrics, map[string]any{"closed": true})
+	require.Len(t, msg.Published(), 3)
+	assert.Equal(t, []map[string]any{
+		{"address": metrics, "value": map[string]any{"data": "a=6\n"}},
+		{"address": metrics, "value": map[string]any{"closed": true}},
+	}, msg.Published()[1:])
+	time.Sleep(2 * StreamFlushInterval)
+	assert.Len(t, msg.Published(), 3, "nothing should be left to send after the stream is closed")
+
+	// The text written by the stream is not kept as the state of the address.
+	s.mu.Lock()
+	assert.NotContains(t, s.lastValues, metrics)
+	s.mu.Unlock()
+
+	// Text still batched when the program finishes is sent.
+	s.ProgramSendValueRequest(logs, "finished\n")
+	s.ProgramFinished()
+	require.Len(t, msg.Published(), 4)
+	assert.Equal(t, map[string]any{"address": logs, "value": map[string]any{"data": "finished\n"}}, msg.Published()[3])
+}
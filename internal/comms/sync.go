@@ -0,0 +1,72 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+	"strings"
+)
+
+// This file implements the bulk state synchronization: when the front-end reconnects (e.g.: after the page is
+// reloaded, or the websocket is re-installed), it requests the current value of all the addresses its widgets
+// listen to, and the kernel replies with a single snapshot, so the widgets don't have to wait for the next
+// update of each value.
+//
+// The kernel keeps the last value exchanged in each address to that end: the ones sent by the program, and
+// the ones received from the front-end. Protocol addresses (starting with "#") and binary values are not kept.
+
+const (
+	// CommSyncRequestAddress is messaged by the front-end, after it connects, with the list of addresses it
+	// wants the current value of.
+	CommSyncRequestAddress = "#comm_sync_request"
+
+	// CommSyncAddress is messaged by the kernel in reply to CommSyncRequestAddress, with a map of the addresses
+	// (among the ones requested) with a known value, to their value.
+	CommSyncAddress = "#comm_sync"
+)
+
+// recordLastValueLocked keeps the value, to reply to future synchronization requests.
+func (s *State) recordLastValueLocked(address string, value any) {
+	if strings.HasPrefix(address, "#") {
+		return
+	}
+	if _, isBinary := value.([]byte); isBinary {
+		// Not sent in the snapshot, which is JSON encoded.
+		delete(s.lastValues, address)
+		return
+	}
+	if s.lastValues == nil {
+		s.lastValues = make(map[string]any)
+	}
+	s.lastValues[address] = value
+}
+
+// recordLastValue is like recordLastValueLocked, but it acquires the lock.
+func (s *State) recordLastValue(address string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordLastValueLocked(address, value)
+}
+
+// handleSyncRequestLocked replies to a synchronization request with the snapshot of the values requested.
+func (s *State) handleSyncRequestLocked(msg kernel.Message, content map[string]any) error {
+	addresses, err := getFromJson[[]any](content, "data/value")
+	if err != nil {
+		klog.Warningf("comms: invalid %q message: %+v", CommSyncRequestAddress, err)
+		return nil
+	}
+	snapshot := make(map[string]any, len(addresses))
+	for _, addressValue := range addresses {
+		address, ok := addressValue.(string)
+		if !ok {
+			continue
+		}
+		if value, found := s.lastValues[address]; found {
+			snapshot[address] = value
+		}
+	}
+	klog.V(1).Infof("comms: synchronization requested for %d addresses, %d with known values", len(addresses), len(snapshot))
+	return s.sendDataLocked(msg, map[string]any{
+		"address": CommSyncAddress,
+		"value":   snapshot,
+	})
+}
@@ -0,0 +1,50 @@
+package comms
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSyncRequest(t *testing.T) {
+	s, _ := openedState("c1")
+	s.LastMsgTime = time.Now()
+	s.ProgramExecMsg = &fakeMsg{}
+	defer func() {
+		// Stops the retries of the reliable message.
+		s.mu.Lock()
+		s.clearPendingAcksLocked()
+		s.mu.Unlock()
+	}()
+
+	// Values sent by the program, and received from the front-end, are kept.
+	s.ProgramSendValueRequest("/slider", 10)
+	s.ProgramSendValueRequest("/slider", 20)
+	s.ProgramSendReliableValueRequest("/text", "hello")
+	s.ProgramSendValueRequest("/binary", []byte{1, 2, 3})
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", "/checkbox", true)))
+	// Protocol addresses are not kept.
+	require.NoError(t, s.HandleMsg(frontEndMsg("c1", HeartbeatPongAddress, true)))
+
+	// One snapshot is published, in reply to the request, with the values known among the ones requested.
+	request := &fakeMsg{Message: frontEndMsg("c1", CommSyncRequestAddress,
+		[]any{"/slider", "/text", "/binary", "/checkbox", "/unknown", HeartbeatPongAddress, 7})}
+	require.NoError(t, s.HandleMsg(request))
+	require.Len(t, request.Published(), 1, "the snapshot should be published in one message")
+	assert.Equal(t, map[string]any{
+		"address": CommSyncAddress,
+		"value":   map[string]any{"/slider": 20, "/text": "hello", "/checkbox": true},
+	}, request.Published()[0])
+
+	// Nothing known: an empty snapshot is still published, so the front-end stops waiting.
+	request = &fakeMsg{Message: frontEndMsg("c1", CommSyncRequestAddress, []any{"/unknown"})}
+	require.NoError(t, s.HandleMsg(request))
+	require.Len(t, request.Published(), 1)
+	assert.Equal(t, map[string]any{"address": CommSyncAddress, "value": map[string]any{}}, request.Published()[0])
+
+	// Malformed requests are ignored.
+	request = &fakeMsg{Message: frontEndMsg("c1", CommSyncRequestAddress, "/slider")}
+	require.NoError(t, s.HandleMsg(request))
+	assert.Empty(t, request.Published())
+}
@@ -0,0 +1,156 @@
+package comms
+
+import (
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+	"sync"
+)
+
+// This file implements the variable inspector comm target: front-ends (e.g.: a variable explorer panel)
+// open a comm with the target name VariableInspectorTarget, and the kernel replies with the list of
+// package-level declarations (variables, constants, functions and types) currently memorized.
+// The list is pushed again to all connected inspectors after each cell execution.
+//
+// Messages sent by the front-end (`comm_msg`) have the data `{"method": "request"}`, and the kernel
+// always sends `{"method": "update", "variables": [...]}`, where each variable is a goexec.VariableInfo.
+
+const (
+	// VariableInspectorTarget is the target name of the comms opened for the variable inspector.
+	VariableInspectorTarget = "gonb_variable_inspector"
+
+	// VariableInspectorRequest is the "method" of messages requesting the variables.
+	VariableInspectorRequest = "request"
+
+	// VariableInspectorUpdate is the "method" of messages with the variables sent to the front-end.
+	VariableInspectorUpdate = "update"
+)
+
+// VariableInspector keeps track of the comms opened by variable inspectors, and of the latest
+// list of variables sent to them.
+//
+// The variables are a snapshot taken (by the dispatcher) after each execution, so requests
+// can be answered concurrently with the execution of cells.
+type VariableInspector struct {
+	mu sync.Mutex
+
+	// CommIds of the opened variable inspectors, in the order they were opened.
+	CommIds []string
+
+	// variables last set by Update.
+	variables any
+}
+
+// NewVariableInspector creates a VariableInspector with no comms opened.
+func NewVariableInspector() *VariableInspector {
+	return &VariableInspector{variables: []any{}}
+}
+
+// HandleOpen handles a `comm_open` message: if its target name is VariableInspectorTarget it registers the
+// comm and replies with the current variables. It returns handled=false for other target names.
+func (vi *VariableInspector) HandleOpen(msg kernel.Message) (handled bool, err error) {
+	content, ok := msg.ComposedMsg().Content.(map[string]any)
+	if !ok {
+		return false, nil
+	}
+	targetName, _ := getFromJson[string](content, "target_name")
+	if targetName != VariableInspectorTarget {
+		return false, nil
+	}
+	commId, err := getFromJson[string](content, "comm_id")
+	if err != nil {
+		klog.V(1).Infof("comms: ignored variable inspector comm_open, \"comm_id\" not set: %+v", err)
+		return true, nil
+	}
+
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+	if !slices.Contains(vi.CommIds, commId) {
+		vi.CommIds = append(vi.CommIds, commId)
+	}
+	if len(vi.CommIds) > MaxPeers {
+		vi.CommIds = slices.Delete(vi.CommIds, 0, len(vi.CommIds)-MaxPeers)
+	}
+	klog.V(1).Infof("comms: variable inspector opened (comm_id=%q)", commId)
+	return true, vi.sendLocked(msg, commId)
+}
+
+// HandleMsg handles a `comm_msg` message: if it comes from a variable inspector it replies to its request.
+// It returns handled=false for messages of other comms.
+func (vi *VariableInspector) HandleMsg(msg kernel.Message) (handled bool, err error) {
+	content, ok := msg.ComposedMsg().Content.(map[string]any)
+	if !ok {
+		return false, nil
+	}
+	commId, _ := getFromJson[string](content, "comm_id")
+
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+	if !slices.Contains(vi.CommIds, commId) {
+		return false, nil
+	}
+	method, _ := getFromJson[string](content, "data/method")
+	if method != VariableInspectorRequest {
+		klog.Warningf("comms: variable inspector message with unknown method %q ignored", method)
+		return true, nil
+	}
+	return true, vi.sendLocked(msg, commId)
+}
+
+// HandleClose handles a `comm_close` message: if it closes a variable inspector, it is unregistered.
+// It returns handled=false for messages of other comms.
+func (vi *VariableInspector) HandleClose(msg kernel.Message) (handled bool) {
+	content, ok := msg.ComposedMsg().Content.(map[string]any)
+	if !ok {
+		return false
+	}
+	commId, _ := getFromJson[string](content, "comm_id")
+
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+	idx := slices.Index(vi.CommIds, commId)
+	if idx < 0 {
+		return false
+	}
+	vi.CommIds = slices.Delete(vi.CommIds, idx, idx+1)
+	klog.V(1).Infof("comms: variable inspector closed (comm_id=%q)", commId)
+	return true
+}
+
+// Opened returns the comm ids of the variable inspectors currently opened.
+func (vi *VariableInspector) Opened() []string {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+	return slices.Clone(vi.CommIds)
+}
+
+// Update the variables, and send them to all opened variable inspectors.
+// The variables will be converted to JSON before being sent.
+func (vi *VariableInspector) Update(msg kernel.Message, variables any) error {
+	vi.mu.Lock()
+	defer vi.mu.Unlock()
+	vi.variables = variables
+	for _, commId := range vi.CommIds {
+		if err := vi.sendLocked(msg, commId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendLocked sends the current variables to the comm.
+// It assumes the `vi.mu` lock is already acquired.
+func (vi *VariableInspector) sendLocked(msg kernel.Message, commId string) error {
+	content := map[string]any{
+		"comm_id": commId,
+		"data": map[string]any{
+			"method":    VariableInspectorUpdate,
+			"variables": vi.variables,
+		},
+	}
+	if err := msg.Publish("comm_msg", content); err != nil {
+		return errors.WithMessagef(err, "failed to send variables to the variable inspector (comm_id=%q)", commId)
+	}
+	return nil
+}
@@ -0,0 +1,507 @@
+// Package console implements a terminal REPL front-end to GoNB, that doesn't require Jupyter.
+//
+// It reads cells from an input (usually the terminal), and uses the same machinery as the
+// Jupyter kernel (the dispatcher, special commands and goexec) to run them, rendering the
+// outputs as plain text.
+//
+// Input is read line by line: multi-line cells are detected with specialcmd.IsComplete, and
+// an empty line forces the submission of an incomplete cell.
+//
+// If the input is a terminal (only supported in Linux), lines can be edited (arrows, Home/End,
+// Control+A/E/K/U), previous lines are recalled with the Up/Down arrows, and pressing TAB completes
+// the code before the cursor, or lists the auto-complete options (provided by `gopls`).
+// Otherwise, a line ending with a TAB character lists the auto-complete options at that point,
+// instead of executing.
+package console
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/dispatcher"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/specialcmd"
+	"github.com/pkg/errors"
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// ContinuationPrompt is displayed while reading the following lines of an incomplete cell.
+	ContinuationPrompt = "   ...: "
+
+	// CompletionKey is the suffix of an input line that requests auto-complete options, when the
+	// input is not a terminal. In a terminal, the key is handled as it is pressed.
+	CompletionKey = "\t"
+)
+
+// Console holds the state of a REPL session.
+type Console struct {
+	kernel *kernel.Kernel
+	goExec *goexec.State
+	out    io.Writer
+
+	// muOut serializes writes to out, since the executed program output is published concurrently.
+	muOut sync.Mutex
+
+	// lines read from the input. It's closed when the input reaches EOF.
+	lines chan string
+
+	// queuedLines holds lines received while a cell was executing, and no input was requested.
+	queuedLines []string
+
+	// muInput protects inputFn.
+	muInput sync.Mutex
+	// inputFn is set when the executing cell requested input (see message.PromptInput).
+	inputFn kernel.OnInputFn
+	// password is set when the input requested should not be echoed.
+	password atomic.Bool
+
+	// editor is used if the input is a terminal, and restoreTerminal restores its original mode.
+	editor          *lineEditor
+	restoreTerminal func()
+
+	// muPrompt protects the fields below, used by the editor to auto-complete and redraw the line.
+	muPrompt sync.Mutex
+	// lastPrompt displayed.
+	lastPrompt string
+	// cellLines already read of the cell being edited.
+	cellLines []string
+}
+
+// New creates a Console that reads cells from `in` and writes the rendered outputs to `out`.
+// The kernel `k` should be created with kernel.NewStandalone.
+//
+// If `in` is a terminal, it is put in raw mode (see package documentation) until Run returns.
+func New(k *kernel.Kernel, goExec *goexec.State, in io.Reader, out io.Writer) *Console {
+	c := &Console{
+		kernel: k,
+		goExec: goExec,
+		out:    out,
+		lines:  make(chan string),
+	}
+	if f, ok := in.(*os.File); ok {
+		restore, err := makeRaw(f)
+		if err == nil {
+			c.restoreTerminal = restore
+			c.editor = newLineEditor(in, func(s string) { c.printf("%s", s) }, c.currentPrompt,
+				c.password.Load, c.editorComplete)
+		} else {
+			klog.V(1).Infof("console: no line editing: %v", err)
+		}
+	}
+	go c.readLines(in)
+	return c
+}
+
+// readLines reads `in` line by line and sends them to c.lines, until EOF.
+func (c *Console) readLines(in io.Reader) {
+	defer close(c.lines)
+	if c.editor != nil {
+		for {
+			line, err := c.editor.ReadLine()
+			if err != nil {
+				if err != io.EOF {
+					klog.Errorf("console: failed reading input: %+v", err)
+				}
+				return
+			}
+			select {
+			case c.lines <- line:
+			case <-c.kernel.StoppedChan():
+				return
+			}
+		}
+	}
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		select {
+		case c.lines <- scanner.Text():
+		case <-c.kernel.StoppedChan():
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		klog.Errorf("console: failed reading input: %+v", err)
+	}
+}
+
+// printf writes to the console output.
+func (c *Console) printf(format string, args ...any) {
+	c.muOut.Lock()
+	defer c.muOut.Unlock()
+	_, _ = fmt.Fprintf(c.out, format, args...)
+}
+
+// printPrompt displays the prompt, and records it, in case the line editor needs to redraw it.
+func (c *Console) printPrompt(prompt string) {
+	c.muPrompt.Lock()
+	c.lastPrompt = prompt
+	c.muPrompt.Unlock()
+	c.printf("%s", prompt)
+}
+
+// currentPrompt returns the last prompt displayed.
+func (c *Console) currentPrompt() string {
+	c.muPrompt.Lock()
+	defer c.muPrompt.Unlock()
+	return c.lastPrompt
+}
+
+// setCellLines records the lines already read of the cell being edited, used as context for auto-complete.
+func (c *Console) setCellLines(lines []string) {
+	c.muPrompt.Lock()
+	defer c.muPrompt.Unlock()
+	c.cellLines = lines
+}
+
+// nextLine returns the next line of input, and false if input reached EOF or the kernel was stopped.
+func (c *Console) nextLine() (string, bool) {
+	if len(c.queuedLines) > 0 {
+		line := c.queuedLines[0]
+		c.queuedLines = c.queuedLines[1:]
+		return line, true
+	}
+	select {
+	case line, ok := <-c.lines:
+		return line, ok
+	case <-c.kernel.StoppedChan():
+		return "", false
+	}
+}
+
+// Run the REPL loop, until the input reaches EOF (Control+D in a terminal) or the kernel is stopped.
+func (c *Console) Run() error {
+	if c.restoreTerminal != nil {
+		defer c.restoreTerminal()
+		c.printf("GoNB console - v%s. Press Control+D to exit, and TAB to auto-complete.\n", dispatcher.Version)
+	} else {
+		c.printf("GoNB console - v%s. Press Control+D to exit, and end a line with TAB to list completions.\n",
+			dispatcher.Version)
+	}
+	for !c.kernel.IsStopped() {
+		code, ok := c.readCell()
+		if !ok {
+			break
+		}
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		if err := c.execute(code); err != nil {
+			return err
+		}
+	}
+	c.printf("\n")
+	return nil
+}
+
+// readCell reads lines until they form a complete cell. It returns false if the input reached EOF.
+func (c *Console) readCell() (code string, ok bool) {
+	var lines []string
+	defer c.setCellLines(nil)
+	c.printf("\n")
+	c.printPrompt(c.prompt(0))
+	for {
+		var line string
+		line, ok = c.nextLine()
+		if !ok {
+			return strings.Join(lines, "\n"), len(lines) > 0
+		}
+		if strings.HasSuffix(line, CompletionKey) {
+			c.complete(strings.Join(append(lines, strings.TrimSuffix(line, CompletionKey)), "\n"))
+			c.printPrompt(c.prompt(len(lines)))
+			continue
+		}
+		if line == "" && len(lines) > 0 {
+			// Empty line forces the execution of an incomplete cell.
+			return strings.Join(lines, "\n"), true
+		}
+		lines = append(lines, line)
+		code = strings.Join(lines, "\n")
+		status, indent := specialcmd.IsComplete(code)
+		if status != specialcmd.CodeIncomplete {
+			return code, true
+		}
+		c.setCellLines(lines)
+		c.printPrompt(ContinuationPrompt + indent)
+	}
+}
+
+// prompt returns the prompt for the line number `lineNum` (0-based) of the cell.
+func (c *Console) prompt(lineNum int) string {
+	if lineNum == 0 {
+		return fmt.Sprintf("In [%d]: ", c.kernel.ExecCounter+1)
+	}
+	return ContinuationPrompt
+}
+
+// execute `code` as a cell, and waits for it to finish, delivering input lines to the
+// executing program if it requests them.
+func (c *Console) execute(code string) error {
+	msg := c.newMessage("execute_request", map[string]any{
+		"code":          code,
+		"silent":        false,
+		"store_history": true,
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- dispatcher.HandleMessage(msg, c.goExec)
+	}()
+
+	lines := c.lines
+	for {
+		select {
+		case err := <-done:
+			return err
+		case line, ok := <-lines:
+			if !ok {
+				// EOF: stop reading, but wait for cell to finish.
+				lines = nil
+				continue
+			}
+			c.deliverInput(line)
+		}
+	}
+}
+
+// deliverInput sends the line to the executing program, if it requested input. Otherwise, it's
+// queued to be used as the next line of the following cell.
+func (c *Console) deliverInput(line string) {
+	c.muInput.Lock()
+	onInput := c.inputFn
+	c.inputFn = nil
+	c.muInput.Unlock()
+	c.password.Store(false)
+	if onInput == nil {
+		c.queuedLines = append(c.queuedLines, line)
+		return
+	}
+	input := &kernel.MessageImpl{}
+	input.Composed.Header.MsgType = "input_reply"
+	input.Composed.Content = map[string]any{"value": line}
+	if err := onInput(nil, input); err != nil {
+		klog.Errorf("console: failed to deliver input: %+v", err)
+	}
+}
+
+// complete prints the auto-complete options for the end of `code`.
+func (c *Console) complete(code string) {
+	matches, _, err := c.completions(code)
+	if err != nil {
+		c.printf("\nFailed to auto-complete: %+v\n", err)
+		return
+	}
+	c.printCompletions(matches)
+}
+
+// printCompletions lists the auto-complete options in a new line.
+func (c *Console) printCompletions(matches []string) {
+	if len(matches) == 0 {
+		c.printf("\n(no completions)\n")
+		return
+	}
+	c.printf("\n%s\n", strings.Join(matches, "  "))
+}
+
+// completions returns the sorted auto-complete options for the end of `code`, and the text they replace.
+func (c *Console) completions(code string) (matches []string, replaced string, err error) {
+	codeUTF16 := utf16.Encode([]rune(code)) // Jupyter uses UTF-16 positions.
+	msg := c.newMessage("complete_request", map[string]any{
+		"code":       code,
+		"cursor_pos": float64(len(codeUTF16)),
+	})
+	if err = dispatcher.HandleMessage(msg, c.goExec); err != nil {
+		return nil, "", err
+	}
+	reply, ok := msg.reply.(*kernel.CompleteReply)
+	if !ok || len(reply.Matches) == 0 {
+		return nil, "", nil
+	}
+	if reply.CursorStart >= 0 && reply.CursorStart <= len(codeUTF16) {
+		replaced = string(utf16.Decode(codeUTF16[reply.CursorStart:]))
+	}
+	matches = append([]string(nil), reply.Matches...)
+	sort.Strings(matches)
+	return matches, replaced, nil
+}
+
+// editorComplete is called by the line editor when TAB is pressed: `prefix` is the contents of the
+// line before the cursor. If the options have a common prefix longer than the text they replace, it
+// returns the rest to be inserted. Otherwise, the options are listed.
+func (c *Console) editorComplete(prefix string) (insert string, listed bool) {
+	c.muInput.Lock()
+	executing := c.inputFn != nil
+	c.muInput.Unlock()
+	if executing {
+		// Reading the input of the executing program.
+		return "", false
+	}
+	c.muPrompt.Lock()
+	code := strings.Join(append(append([]string(nil), c.cellLines...), prefix), "\n")
+	c.muPrompt.Unlock()
+	matches, replaced, err := c.completions(code)
+	if err != nil {
+		c.printf("\nFailed to auto-complete: %+v\n", err)
+		return "", true
+	}
+	if common := commonPrefix(matches); strings.HasPrefix(common, replaced) && len(common) > len(replaced) {
+		return common[len(replaced):], false
+	}
+	c.printCompletions(matches)
+	return "", true
+}
+
+// commonPrefix returns the longest common prefix of the strings, or "" if there are none.
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	common := values[0]
+	for _, v := range values[1:] {
+		for !strings.HasPrefix(v, common) {
+			_, size := utf8.DecodeLastRuneInString(common)
+			common = common[:len(common)-size]
+		}
+	}
+	return common
+}
+
+// newMessage creates a request message, as if coming from a Jupyter client.
+func (c *Console) newMessage(msgType string, content map[string]any) *message {
+	m := &message{console: c}
+	m.composed.Header.MsgType = msgType
+	m.composed.Header.ProtocolVersion = kernel.ProtocolVersion
+	m.composed.Content = content
+	return m
+}
+
+// render displays as plain text the contents published by the kernel.
+func (c *Console) render(msgType string, content any) {
+	var fields map[string]any
+	if err := toMap(content, &fields); err != nil {
+		klog.Errorf("console: failed to render %q: %+v", msgType, err)
+		return
+	}
+	switch msgType {
+	case "stream":
+		text, _ := fields["text"].(string)
+		c.printf("%s", text)
+	case "display_data", "update_display_data", "execute_result":
+		data, _ := fields["data"].(map[string]any)
+		if text := plainText(data); text != "" {
+			c.printf("%s\n", strings.TrimSuffix(text, "\n"))
+		}
+	case "error":
+		traceback, _ := fields["traceback"].([]any)
+		parts := make([]string, 0, len(traceback))
+		for _, line := range traceback {
+			parts = append(parts, fmt.Sprint(line))
+		}
+		c.printf("%s\n", strings.Join(parts, "\n"))
+	default:
+		// "status", "execute_input", "clear_output", comms, etc., have no representation in the console.
+		klog.V(2).Infof("console: ignoring published %q", msgType)
+	}
+}
+
+// toMap converts the published content (usually anonymous structs with JSON tags) to a map,
+// the same way the Jupyter client would see it.
+func toMap(content any, fields *map[string]any) error {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return errors.Wrapf(err, "encoding %T", content)
+	}
+	return json.Unmarshal(encoded, fields)
+}
+
+// plainText returns the best plain text representation of the given display data.
+// Javascript is silently ignored, since it's mostly used for the front-end bookkeeping.
+func plainText(data map[string]any) string {
+	for _, mimeType := range []protocol.MIMEType{protocol.MIMETextPlain, protocol.MIMETextMarkdown} {
+		if text, ok := data[string(mimeType)].(string); ok {
+			return text
+		}
+	}
+	var others []string
+	for mimeType := range data {
+		if mimeType == string(protocol.MIMETextJavascript) {
+			continue
+		}
+		others = append(others, mimeType)
+	}
+	if len(others) == 0 {
+		return ""
+	}
+	sort.Strings(others)
+	return fmt.Sprintf("[%s output not displayable in the console]", strings.Join(others, ", "))
+}
+
+// message implements kernel.Message for requests originated in the console.
+type message struct {
+	console  *Console
+	composed kernel.ComposedMsg
+
+	// reply holds the content of the last reply to this message.
+	reply any
+}
+
+// Compile-time check that message implements kernel.Message.
+var _ kernel.Message = (*message)(nil)
+
+// Error implements kernel.Message. Console messages have no errors.
+func (m *message) Error() error { return nil }
+
+// Ok implements kernel.Message.
+func (m *message) Ok() bool { return true }
+
+// ComposedMsg implements kernel.Message.
+func (m *message) ComposedMsg() kernel.ComposedMsg { return m.composed }
+
+// Kernel implements kernel.Message.
+func (m *message) Kernel() *kernel.Kernel { return m.console.kernel }
+
+// Publish implements kernel.Message, by rendering the content in the console.
+func (m *message) Publish(msgType string, content any) error {
+	m.console.render(msgType, content)
+	return nil
+}
+
+// PromptInput implements kernel.Message. The prompt is printed and the next line read
+// from the console is delivered to `onInput`.
+// If `password` is set, the input is not echoed -- only possible if the input is a terminal.
+func (m *message) PromptInput(prompt string, password bool, onInput kernel.OnInputFn) error {
+	m.console.muInput.Lock()
+	m.console.inputFn = onInput
+	m.console.muInput.Unlock()
+	m.console.password.Store(password)
+	m.console.printPrompt(prompt)
+	return nil
+}
+
+// CancelInput implements kernel.Message.
+func (m *message) CancelInput() error {
+	m.console.muInput.Lock()
+	m.console.inputFn = nil
+	m.console.muInput.Unlock()
+	m.console.password.Store(false)
+	return nil
+}
+
+// DeliverInput implements kernel.Message. Input is delivered directly by the Console.
+func (m *message) DeliverInput() error { return nil }
+
+// Reply implements kernel.Message, by storing the reply content, to be used by the Console.
+func (m *message) Reply(_ string, content any) error {
+	m.reply = content
+	return nil
+}
@@ -0,0 +1,294 @@
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxHistory is the number of lines kept in the history of the line editor.
+var MaxHistory = 1000
+
+// lineEditor reads lines from a terminal in raw mode (see makeRaw), with line editing, history
+// (Up/Down arrows) and auto-complete (TAB key).
+//
+// Lines are edited in place, using relative cursor movements, so it doesn't need to know the prompt,
+// except to redraw the line after the auto-complete options are listed. Lines wider than the terminal
+// are not handled.
+type lineEditor struct {
+	in *bufio.Reader
+
+	// write is used for all the output, serialized with the outputs of the console.
+	write func(s string)
+
+	// prompt returns the prompt currently displayed.
+	prompt func() string
+
+	// hidden returns whether the input should not be echoed (e.g.: passwords). Hidden lines are not
+	// kept in the history.
+	hidden func() bool
+
+	// complete is called when TAB is pressed, with the line contents before the cursor. It returns the
+	// text to insert at the cursor, if any, and whether it listed the options -- in which case the prompt
+	// and the line are redrawn.
+	complete func(prefix string) (insert string, listed bool)
+
+	history []string
+
+	// State of the line being edited.
+	line          []rune
+	pos           int    // Cursor position in line.
+	shown         []rune // Line shown in the terminal.
+	shownPos      int    // Cursor position in the terminal, relative to the start of the line.
+	historyIdx    int    // Position in the history being edited, len(history) for a new line.
+	pending       []rune // New line being edited, while navigating the history.
+	isHiddenInput bool
+}
+
+// Control keys.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyTab       = '\t'
+	keyCtrlK     = 11
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// newLineEditor reads keys from `in`. See lineEditor for the other parameters.
+func newLineEditor(in io.Reader, write func(string), prompt func() string, hidden func() bool,
+	complete func(string) (string, bool)) *lineEditor {
+	return &lineEditor{
+		in:       bufio.NewReader(in),
+		write:    write,
+		prompt:   prompt,
+		hidden:   hidden,
+		complete: complete,
+	}
+}
+
+// ReadLine reads the next line, handling the editing keys. It returns io.EOF on Control+D in an empty line,
+// or when the input is closed.
+func (e *lineEditor) ReadLine() (string, error) {
+	e.line, e.pos, e.shown, e.shownPos = nil, 0, nil, 0
+	e.historyIdx, e.pending = len(e.history), nil
+	for {
+		r, _, err := e.in.ReadRune()
+		e.isHiddenInput = e.hidden() // Input may be requested while the line is being read.
+		if err != nil {
+			if len(e.line) > 0 && err == io.EOF {
+				return e.accept(), nil
+			}
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			return e.accept(), nil
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				return "", io.EOF
+			}
+			e.delete(e.pos)
+		case keyBackspace, keyCtrlH:
+			if e.pos > 0 {
+				e.pos--
+				e.delete(e.pos)
+			}
+		case keyTab:
+			e.autoComplete()
+		case keyCtrlA:
+			e.pos = 0
+		case keyCtrlE:
+			e.pos = len(e.line)
+		case keyCtrlB:
+			e.moveLeft()
+		case keyCtrlF:
+			e.moveRight()
+		case keyCtrlK:
+			e.line = e.line[:e.pos]
+		case keyCtrlU:
+			e.line = append([]rune(nil), e.line[e.pos:]...)
+			e.pos = 0
+		case keyCtrlP:
+			e.historyPrev()
+		case keyCtrlN:
+			e.historyNext()
+		case keyEscape:
+			if err = e.escapeSequence(); err != nil {
+				return "", err
+			}
+		default:
+			if r < ' ' {
+				// Other control keys are ignored.
+				continue
+			}
+			e.insert([]rune{r})
+		}
+		e.redraw()
+	}
+}
+
+// accept the edited line: it's added to the history, and the cursor moved to the next line.
+func (e *lineEditor) accept() string {
+	line := string(e.line)
+	e.write("\n")
+	if !e.isHiddenInput && strings.TrimSpace(line) != "" &&
+		(len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+		e.history = append(e.history, line)
+		if len(e.history) > MaxHistory {
+			e.history = e.history[len(e.history)-MaxHistory:]
+		}
+	}
+	return line
+}
+
+// escapeSequence handles the keys sent as escape sequences (arrows, Home, End, Delete), after the ESC was read.
+// Unknown sequences are ignored.
+func (e *lineEditor) escapeSequence() error {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return err
+	}
+	if r != '[' && r != 'O' {
+		return nil
+	}
+	var param strings.Builder
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return err
+		}
+		if r >= 0x40 && r <= 0x7E { // Final byte of the sequence.
+			break
+		}
+		param.WriteRune(r)
+	}
+	switch r {
+	case 'A':
+		e.historyPrev()
+	case 'B':
+		e.historyNext()
+	case 'C':
+		e.moveRight()
+	case 'D':
+		e.moveLeft()
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.line)
+	case '~':
+		switch n, _ := strconv.Atoi(param.String()); n {
+		case 1, 7:
+			e.pos = 0
+		case 4, 8:
+			e.pos = len(e.line)
+		case 3:
+			e.delete(e.pos)
+		}
+	}
+	return nil
+}
+
+func (e *lineEditor) moveLeft() {
+	if e.pos > 0 {
+		e.pos--
+	}
+}
+
+func (e *lineEditor) moveRight() {
+	if e.pos < len(e.line) {
+		e.pos++
+	}
+}
+
+// insert runes at the cursor position.
+func (e *lineEditor) insert(runes []rune) {
+	line := make([]rune, 0, len(e.line)+len(runes))
+	line = append(line, e.line[:e.pos]...)
+	line = append(line, runes...)
+	e.line = append(line, e.line[e.pos:]...)
+	e.pos += len(runes)
+}
+
+// delete the rune at position `pos`, if any.
+func (e *lineEditor) delete(pos int) {
+	if pos < len(e.line) {
+		e.line = append(e.line[:pos], e.line[pos+1:]...)
+	}
+}
+
+// historyPrev replaces the line with the previous one in the history.
+func (e *lineEditor) historyPrev() {
+	if e.historyIdx == 0 {
+		return
+	}
+	if e.historyIdx == len(e.history) {
+		e.pending = e.line
+	}
+	e.historyIdx--
+	e.line = []rune(e.history[e.historyIdx])
+	e.pos = len(e.line)
+}
+
+// historyNext replaces the line with the next one in the history, or the new line being edited.
+func (e *lineEditor) historyNext() {
+	if e.historyIdx == len(e.history) {
+		return
+	}
+	e.historyIdx++
+	if e.historyIdx == len(e.history) {
+		e.line = e.pending
+	} else {
+		e.line = []rune(e.history[e.historyIdx])
+	}
+	e.pos = len(e.line)
+}
+
+// autoComplete inserts the completion of the text before the cursor, or lists the options.
+func (e *lineEditor) autoComplete() {
+	if e.isHiddenInput || e.complete == nil {
+		return
+	}
+	insert, listed := e.complete(string(e.line[:e.pos]))
+	if listed {
+		// The options were printed after the line: the prompt and the line are redrawn below it.
+		e.write(e.prompt())
+		e.shown, e.shownPos = nil, 0
+	}
+	e.insert([]rune(insert))
+}
+
+// redraw the line from the first position that changed since it was last shown, and places the cursor at e.pos.
+func (e *lineEditor) redraw() {
+	if e.isHiddenInput {
+		return
+	}
+	start := 0
+	for start < len(e.line) && start < len(e.shown) && start < e.shownPos && e.line[start] == e.shown[start] {
+		start++
+	}
+	var sb strings.Builder
+	if back := e.shownPos - start; back > 0 {
+		_, _ = fmt.Fprintf(&sb, "\x1b[%dD", back)
+	}
+	sb.WriteString(string(e.line[start:]))
+	if len(e.line) < len(e.shown) {
+		sb.WriteString("\x1b[K") // Clear the rest of the previous line.
+	}
+	if back := len(e.line) - e.pos; back > 0 {
+		_, _ = fmt.Fprintf(&sb, "\x1b[%dD", back)
+	}
+	e.shown, e.shownPos = append(e.shown[:0], e.line...), e.pos
+	if sb.Len() > 0 {
+		e.write(sb.String())
+	}
+}
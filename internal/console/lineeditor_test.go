@@ -0,0 +1,109 @@
+package console
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testEditor creates a lineEditor reading the given keys, and collecting its output in `out`.
+func testEditor(keys string, out *strings.Builder) *lineEditor {
+	return newLineEditor(strings.NewReader(keys), func(s string) { out.WriteString(s) },
+		func() string { return "> " }, func() bool { return false },
+		func(prefix string) (string, bool) {
+			if strings.HasSuffix(prefix, "fmt.Pr") {
+				return "int", false
+			}
+			out.WriteString("\n[options]\n")
+			return "", true
+		})
+}
+
+func TestLineEditor(t *testing.T) {
+	const (
+		left      = "\x1b[D"
+		right     = "\x1b[C"
+		up        = "\x1b[A"
+		down      = "\x1b[B"
+		home      = "\x1b[H"
+		end       = "\x1b[F"
+		del       = "\x1b[3~"
+		backspace = "\x7f"
+	)
+	for _, tc := range []struct {
+		name, keys string
+		want       []string
+	}{
+		{"plain", "abc\n", []string{"abc"}},
+		{"carriage return", "abc\r", []string{"abc"}},
+		{"insert in the middle", "ac" + left + "b\n", []string{"abc"}},
+		{"backspace", "abx" + backspace + "c\n", []string{"abc"}},
+		{"delete", "abxc" + left + left + del + "\n", []string{"abc"}},
+		{"home and end", "bc" + home + "a" + end + "d\n", []string{"abcd"}},
+		{"control keys", "bc\x01a\x05d\x02\x02\x0b\n", []string{"ab"}},
+		{"kill to start", "xyzabc" + left + left + left + "\x15" + right + "\n", []string{"abc"}},
+		{"unicode", "héllo" + left + backspace + "L\n", []string{"hélLo"}},
+		{"unknown keys ignored", "a\x07b\x1bxc\x1b[5~\n", []string{"abc"}},
+		{"history", "one\ntwo\n" + up + "\n" + up + up + "\n", []string{"one", "two", "two", "one"}},
+		{"history keeps new line", "one\nne" + up + down + "w\n", []string{"one", "new"}},
+		{"history navigation bounds", "one\n" + up + up + down + down + "\n", []string{"one", ""}},
+		{"complete inserts", "fmt.Pr\t(1)\n", []string{"fmt.Print(1)"}},
+		{"complete lists", "x\ty\n", []string{"xy"}},
+		{"partial line at EOF", "abc", []string{"abc"}},
+		{"control-d in empty line", "abc\n\x04ignored\n", []string{"abc"}},
+		{"control-d deletes", "ab" + left + "\x04\n", []string{"a"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			e := testEditor(tc.keys, &out)
+			var got []string
+			for {
+				line, err := e.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				got = append(got, line)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLineEditorOutput(t *testing.T) {
+	var out strings.Builder
+	e := testEditor("ab\x1b[Dx\ty\n", &out)
+	line, err := e.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "axyb", line)
+	assert.Equal(t, strings.Join([]string{
+		"a", "b",
+		"\x1b[1D",                   // Left.
+		"xb\x1b[1D",                 // Only the changed part is redrawn.
+		"\n[options]\n> axb\x1b[1D", // Options listed, prompt and line redrawn.
+		"yb\x1b[1D",
+		"\n",
+	}, ""), out.String())
+	assert.Equal(t, []string{"axyb"}, e.history)
+}
+
+func TestLineEditorHidden(t *testing.T) {
+	var out strings.Builder
+	e := testEditor("secret\n", &out)
+	e.hidden = func() bool { return true }
+	line, err := e.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "secret", line)
+	assert.Equal(t, "\n", out.String(), "hidden input should not be echoed")
+	assert.Empty(t, e.history, "hidden input should not be kept in the history")
+}
+
+func TestCommonPrefix(t *testing.T) {
+	assert.Equal(t, "", commonPrefix(nil))
+	assert.Equal(t, "Println", commonPrefix([]string{"Println"}))
+	assert.Equal(t, "Print", commonPrefix([]string{"Println", "Printf", "Print"}))
+	assert.Equal(t, "", commonPrefix([]string{"Println", "Sprintf"}))
+	assert.Equal(t, "é", commonPrefix([]string{"éa", "éb"}))
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package console
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// makeRaw puts the terminal in `f` in raw mode: input is delivered as it's typed and not echoed,
+// so the console can edit the line. Signals (Control+C) are still generated by the terminal.
+//
+// It returns a function that restores the previous mode, or an error if `f` is not a terminal.
+func makeRaw(f *os.File) (restore func(), err error) {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%q is not a terminal", f.Name())
+	}
+	previous := *termios
+	termios.Lflag &^= unix.ICANON | unix.ECHO | unix.IEXTEN
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, errors.Wrapf(err, "failed to set terminal %q to raw mode", f.Name())
+	}
+	return func() { _ = unix.IoctlSetTermios(fd, unix.TCSETS, &previous) }, nil
+}
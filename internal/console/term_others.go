@@ -0,0 +1,13 @@
+//go:build !linux
+
+package console
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+// makeRaw is not supported in this platform: the console reads the input line by line.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.Errorf("line editing of terminal %q not supported in this platform", f.Name())
+}
@@ -1,6 +1,7 @@
 package dispatcher
 
 import (
+	"github.com/janpfeifer/gonb/internal/comms"
 	"github.com/janpfeifer/gonb/internal/goexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
 	"k8s.io/klog/v2"
@@ -39,17 +40,50 @@ func handleComms(msg kernel.Message, goExec *goexec.State) error {
 	switch msgType {
 	case "comm_info_request":
 		// https://jupyter-client.readthedocs.io/en/latest/messaging.html#comm-info
+		return handleCommInfoRequest(msg, goExec)
 
 	case "comm_open":
+		if handled, err := goExec.VariableInspector.HandleOpen(msg); handled {
+			return err
+		}
 		return goExec.Comms.HandleOpen(msg)
 
 	case "comm_close":
-		klog.Warningf("\"comm_close\" received, but not implemented -- likely there is no impact.")
-		return nil
+		if goExec.VariableInspector.HandleClose(msg) {
+			return nil
+		}
+		return goExec.Comms.HandleClose(msg)
 
 	case "comm_msg":
+		if handled, err := goExec.VariableInspector.HandleMsg(msg); handled {
+			return err
+		}
 		return goExec.Comms.HandleMsg(msg)
 
 	}
 	return nil
 }
+
+// handleCommInfoRequest replies with the comms currently opened, optionally filtered by the requested
+// "target_name".
+func handleCommInfoRequest(msg kernel.Message, goExec *goexec.State) error {
+	var targetName string
+	if content, ok := msg.ComposedMsg().Content.(map[string]any); ok {
+		targetName, _ = content["target_name"].(string)
+	}
+	reply := &kernel.CommInfoReply{
+		Status: "ok",
+		Comms:  make(map[string]map[string]string),
+	}
+	addComms := func(target string, commIds []string) {
+		if targetName != "" && targetName != target {
+			return
+		}
+		for _, commId := range commIds {
+			reply.Comms[commId] = map[string]string{"target_name": target}
+		}
+	}
+	addComms("gonb_comm", goExec.Comms.OpenedPeers())
+	addComms(comms.VariableInspectorTarget, goExec.VariableInspector.Opened())
+	return msg.Reply("comm_info_reply", reply)
+}
@@ -6,24 +6,31 @@ import (
 	"fmt"
 	. "github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/comms"
 	"github.com/janpfeifer/gonb/internal/goexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/metrics"
 	"github.com/janpfeifer/gonb/internal/specialcmd"
 	"github.com/pkg/errors"
 	"golang.org/x/exp/slices"
 	"io"
 	"k8s.io/klog/v2"
+	"reflect"
+	"regexp"
 	"strings"
 	"sync"
 )
 
 const (
-	Version = "0.1.0"
+	// Version of GoNB, see kernel.Version.
+	Version = kernel.Version
 )
 
 // RunKernel takes a connected kernel and dispatches the various inputs the appropriate handlers.
 // It returns only when the kernel stops running.
 func RunKernel(k *kernel.Kernel, goExec *goexec.State) {
+	specialcmd.HandlePalette(goExec, goExec.Executing.Load)
+	d := &shellDispatcher{busyMessages: startBusyMessages()}
 	var wg sync.WaitGroup
 	poll := func(ch <-chan kernel.Message, fn func(msg kernel.Message, goExec *goexec.State) error) {
 		wg.Add(1)
@@ -34,7 +41,11 @@ func RunKernel(k *kernel.Kernel, goExec *goexec.State) {
 				select {
 				case <-kernelStop:
 					return
-				case msg := <-ch:
+				case msg, ok := <-ch:
+					if !ok {
+						// Polling of the socket finished: the kernel is stopping.
+						return
+					}
 					err := fn(msg, goExec)
 					if err != nil {
 						if !k.IsStopped() {
@@ -64,7 +75,7 @@ func RunKernel(k *kernel.Kernel, goExec *goexec.State) {
 		}()
 		return nil
 	})
-	poll(k.Shell(), handleShellMsg)
+	poll(k.Shell(), d.handleShellMsg)
 	poll(k.Control(), func(msg kernel.Message, goExec *goexec.State) error {
 		if msg == nil {
 			return nil
@@ -72,11 +83,11 @@ func RunKernel(k *kernel.Kernel, goExec *goexec.State) {
 		if !msg.Ok() {
 			return errors.WithMessagef(msg.Error(), "control message error")
 		}
-		return handleShellMsg(msg, goExec)
+		return d.handleShellMsg(msg, goExec)
 	})
 
 	wg.Wait()
-	close(busyMessagesChan)
+	close(d.busyMessages)
 }
 
 // BusyMessageTypes are messages that triggers setting the kernel status to busy
@@ -92,21 +103,23 @@ var BusyMessageTypes = []string{
 
 const MaxExecuteRequestQueue = 10000
 
-var (
-	busyMessagesChan = make(chan *shellMsgParams, 10000)
-	busyMessagesOnce sync.Once
-)
-
 type shellMsgParams struct {
 	msg    kernel.Message
 	goExec *goexec.State
 }
 
+// shellDispatcher holds the state of the dispatching of the shell and control messages of one RunKernel,
+// so more than one kernel can run in the same process (e.g.: when embedded with pkg/gonbkernel).
+type shellDispatcher struct {
+	// busyMessages is the queue of the messages of BusyMessageTypes, see startBusyMessages.
+	busyMessages chan *shellMsgParams
+}
+
 // handleShellMsg responds to a message on the shell or control ROUTER socket.
 //
 // It's assumed that more than one message may be handled concurrently, in particular
 // messages coming from the control socket.
-func handleShellMsg(msg kernel.Message, goExec *goexec.State) (err error) {
+func (d *shellDispatcher) handleShellMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 	if !msg.Ok() {
 		return errors.WithMessagef(msg.Error(), "shell message error")
 	}
@@ -118,7 +131,7 @@ func handleShellMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 	if !slices.Contains(BusyMessageTypes, msgType) {
 		// Messages that are handled asynchronously and don't block kernel
 		switch msgType {
-		case "comm_open", "comm_msg", "comm_comm_close", "comm_info_request":
+		case "comm_open", "comm_msg", "comm_close", "comm_info_request":
 			// Handle in a separate goroutine.
 			go func() {
 				klog.V(1).Infof("Dispatcher: handling %q", msgType)
@@ -130,7 +143,14 @@ func handleShellMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 			}()
 
 		case "is_complete_request":
-			klog.V(2).Infof("Received is_complete_request: ignoring, since it's not a console like kernel.")
+			if err = handleIsCompleteRequest(msg); err != nil {
+				err = errors.WithMessagef(err, "replying to 'is_complete_request'")
+			}
+
+		case "format_request":
+			if err = handleFormatRequest(msg, goExec); err != nil {
+				err = errors.WithMessagef(err, "replying to 'format_request'")
+			}
 
 		case "shutdown_request":
 			if err = handleShutdownRequest(msg, goExec); err != nil {
@@ -153,32 +173,44 @@ func handleShellMsg(msg kernel.Message, goExec *goexec.State) (err error) {
 		return
 	}
 
-	// Start processing of requests queue.
-	busyMessagesOnce.Do(func() {
-		go func() {
-			for params := range busyMessagesChan {
-				msgType := msg.ComposedMsg().Header.MsgType
-				klog.V(1).Infof("Dispatcher: handling %q", msgType)
-				err := handleBusyMessage(params.msg, params.goExec)
-				if err != nil {
-					klog.Errorf("Failed to handle %q, this may indicate that the kernel is in an "+
-						"unstable state, it would be safer to restart the kernel. "+
-						"If you know how to reproduce the issue pls report to GoNB. Error: %+v", msgType, err)
-				}
-			}
-		}()
-	})
-
-	sentStatus := SendNoBlock(busyMessagesChan, &shellMsgParams{msg: msg, goExec: goExec})
+	sentStatus := SendNoBlock(d.busyMessages, &shellMsgParams{msg: msg, goExec: goExec})
 	if sentStatus == 1 {
 		err := errors.Errorf("Execution queue (with %d elements) is full!? Something must be going wrong with the notebook (too many cells?) or Jupyter, please check.",
-			len(busyMessagesChan))
+			len(d.busyMessages))
 		klog.Errorf("%v", err)
 		return err
 	}
 	return nil
 }
 
+// startBusyMessages creates the queue of the messages of BusyMessageTypes, and starts processing it
+// sequentially. The queue is closed by RunKernel when the kernel stops.
+func startBusyMessages() chan *shellMsgParams {
+	busyMessages := make(chan *shellMsgParams, MaxExecuteRequestQueue)
+	go func() {
+		for params := range busyMessages {
+			msgType := params.msg.ComposedMsg().Header.MsgType
+			klog.V(1).Infof("Dispatcher: handling %q", msgType)
+			err := handleBusyMessage(params.msg, params.goExec)
+			if err != nil {
+				klog.Errorf("Failed to handle %q, this may indicate that the kernel is in an "+
+					"unstable state, it would be safer to restart the kernel. "+
+					"If you know how to reproduce the issue pls report to GoNB. Error: %+v", msgType, err)
+			}
+		}
+	}()
+	return busyMessages
+}
+
+// HandleMessage handles synchronously the given request message, as if it had been received in the
+// shell socket, and returns when it's done -- any replies are sent with `msg.Reply`.
+//
+// It is meant for front-ends other than Jupyter (e.g.: the console REPL) that create their own
+// kernel.Message implementation, and don't use RunKernel. It doesn't support concurrent calls.
+func HandleMessage(msg kernel.Message, goExec *goexec.State) error {
+	return handleBusyMessage(msg, goExec)
+}
+
 // handleBusyMessage handles Shell messages that need to be serialized.
 func handleBusyMessage(msg kernel.Message, goExec *goexec.State) (err error) {
 	msgType := msg.ComposedMsg().Header.MsgType
@@ -207,7 +239,10 @@ func handleBusyMessage(msg kernel.Message, goExec *goexec.State) (err error) {
 		}
 
 	case "execute_request":
-		if err = handleExecuteRequest(msg, goExec); err != nil {
+		goExec.Executing.Store(true)
+		err = handleExecuteRequest(msg, goExec)
+		goExec.Executing.Store(false)
+		if err != nil {
 			err = errors.WithMessagef(err, "replying to 'execute_request'")
 		}
 	case "inspect_request":
@@ -219,11 +254,18 @@ func handleBusyMessage(msg kernel.Message, goExec *goexec.State) (err error) {
 			klog.Fatal(err)
 		}
 
-	case "comm_open", "comm_msg", "comm_comm_close", "comm_info_request":
+	case "comm_open", "comm_msg", "comm_close", "comm_info_request":
 		err = handleComms(msg, goExec)
 
 	case "is_complete_request":
-		klog.V(2).Infof("Received is_complete_request: ignoring, since it's not a console like kernel.")
+		if err = handleIsCompleteRequest(msg); err != nil {
+			err = errors.WithMessagef(err, "replying to 'is_complete_request'")
+		}
+
+	case "format_request":
+		if err = handleFormatRequest(msg, goExec); err != nil {
+			err = errors.WithMessagef(err, "replying to 'format_request'")
+		}
 
 	default:
 		// Log, ignore, and hope for the best.
@@ -289,6 +331,30 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 		}
 	}
 
+	// Offer to recover the previous session, if it crashed.
+	if !silent {
+		if notice := goExec.RecoveryNotice(); notice != "" {
+			if err := kernel.PublishMarkdown(msg, notice); err != nil {
+				klog.Errorf("Failed to publish session recovery notice: %+v", err)
+			}
+		}
+	}
+
+	// Introspection cells (`obj?` or `obj??`) are answered with the pager payload, instead of executed.
+	if matches := regexpIntrospection.FindStringSubmatch(code); matches != nil {
+		return handleIntrospectionCell(msg, goExec, matches[1], len(matches[2])-1, replyContent)
+	}
+
+	// Let all connected front-ends (e.g.: collaborators sharing the notebook) know about the execution.
+	var executionEvent *comms.ExecutionEvent
+	var declarationsBefore map[string]map[string]int
+	if !silent {
+		executionCount, _ := replyContent["execution_count"].(int)
+		executionEvent = comms.NewExecutionEvent(msg, executionCount)
+		broadcast(msg, goExec, comms.ExecutionStartAddress, executionEvent)
+		declarationsBefore = goExec.Definitions.CellIds()
+	}
+
 	// Dispatch to various executors.
 	msg.Kernel().Interrupted.Store(false)
 	lines := strings.Split(code, "\n")
@@ -303,14 +369,21 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 			executionErr = errors.WithMessagef(err, "executing special commands in cell")
 		}
 		hasMoreToRun := !goexec.IsEmptyLines(lines, specialLines) || goExec.CellIsTest
+		if executionErr == nil && !silent && goExec.AutoFormat && hasMoreToRun {
+			lines, specialLines = autoFormatCell(msg, goExec, lines, specialLines, replyContent)
+		}
 		if executionErr == nil && !msg.Kernel().Interrupted.Load() && hasMoreToRun {
 			executionErr = goExec.ExecuteCell(msg, msg.Kernel().ExecCounter, lines, specialLines)
 		}
 	}
+	if err := goExec.FlushUsageStats(); err != nil {
+		klog.Warningf("Failed to save usage statistics: %+v", err)
+	}
 
 	// Final execution result.
 	if executionErr == nil {
 		// if the only non-nil value should be auto-rendered graphically, render it
+		goExec.CaptureSnapshot()
 		replyContent["status"] = "ok"
 		replyContent["user_expressions"] = make(map[string]string)
 	} else {
@@ -326,6 +399,22 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 		}
 	}
 
+	metrics.Executions.With(replyContent["status"].(string)).Inc()
+	if executionEvent != nil {
+		executionEvent.Status = replyContent["status"].(string)
+		broadcast(msg, goExec, comms.ExecutionEndAddress, executionEvent)
+		if declarations := goExec.Definitions.CellIds(); !reflect.DeepEqual(declarations, declarationsBefore) {
+			broadcast(msg, goExec, comms.DeclarationsAddress, declarations)
+		}
+	}
+
+	// Refresh the variable inspectors (the snapshot is also used to answer later requests).
+	if goExec.VariableInspector != nil {
+		if err := goExec.VariableInspector.Update(msg, goExec.InspectVariables()); err != nil {
+			klog.Warningf("Failed to update variable inspectors: %+v", err)
+		}
+	}
+
 	// Send the output back to the notebook.
 	if klog.V(2).Enabled() {
 		klog.Infof("> execute_reply: %+v", replyContent)
@@ -336,6 +425,17 @@ func handleExecuteRequest(msg kernel.Message, goExec *goexec.State) error {
 	return nil
 }
 
+// broadcast value to the address to all front-ends connected to the kernel. Failures are only logged,
+// since they shouldn't interfere with the execution.
+func broadcast(msg kernel.Message, goExec *goexec.State, address string, value any) {
+	if goExec.Comms == nil {
+		return
+	}
+	if err := goExec.Comms.Broadcast(msg, address, value); err != nil {
+		klog.Warningf("Failed to broadcast %q to front-ends: %+v", address, err)
+	}
+}
+
 // HandleInspectRequest presents rich data (HTML?) with contextual information for the
 // contents under the cursor.
 func HandleInspectRequest(msg kernel.Message, goExec *goexec.State) error {
@@ -347,13 +447,31 @@ func HandleInspectRequest(msg kernel.Message, goExec *goexec.State) error {
 
 	// Find cursorLine and cursorCol from cursorPos. Both are 0-based.
 	lines, cursorLine, cursorCol := kernel.JupyterToLinesAndCursor(code, cursorPos)
+	data, err := inspectCell(msg, goExec, lines, cursorLine, cursorCol, detailLevel)
+	if err != nil {
+		return err
+	}
 
+	// Send reply.
+	reply := &kernel.InspectReply{
+		Status:   "ok",
+		Found:    len(data) > 0,
+		Data:     data,
+		Metadata: make(kernel.MIMEMap),
+	}
+	return msg.Reply("inspect_reply", reply)
+}
+
+// inspectCell returns the contextual information for the contents under the cursor in the cell `lines`.
+// See HandleInspectRequest.
+func inspectCell(msg kernel.Message, goExec *goexec.State, lines []string, cursorLine, cursorCol, detailLevel int) (
+	kernel.MIMEMap, error) {
 	var data kernel.MIMEMap
 	if len(lines) > 0 && specialcmd.IsGoCell(lines[0]) {
 		// Separate special commands from Go commands.
 		usedLines := MakeSet[int]()
 		if err := specialcmd.Parse(msg, goExec, false, lines, usedLines); err != nil {
-			return errors.WithMessagef(err, "parsing special commands in cell")
+			return nil, errors.WithMessagef(err, "parsing special commands in cell")
 		}
 
 		// Get data contents for reply.
@@ -363,7 +481,7 @@ func HandleInspectRequest(msg kernel.Message, goExec *goexec.State) error {
 		} else {
 			// Parse Go.
 			var err error
-			data, err = goExec.InspectIdentifierInCell(lines, usedLines, cursorLine, cursorCol)
+			data, err = goExec.InspectIdentifierInCell(lines, usedLines, cursorLine, cursorCol, detailLevel)
 			if err != nil {
 				data = kernel.MIMEMap{
 					string(protocol.MIMETextPlain): any(
@@ -380,14 +498,110 @@ func HandleInspectRequest(msg kernel.Message, goExec *goexec.State) error {
 		data = kernel.MIMEMap{string(protocol.MIMETextPlain): any(specialcmd.HelpMessage)}
 	}
 
-	// Send reply.
-	reply := &kernel.InspectReply{
-		Status:   "ok",
-		Found:    len(data) > 0,
-		Data:     data,
-		Metadata: make(kernel.MIMEMap),
+	return data, nil
+}
+
+// regexpIntrospection matches cells that only ask for introspection of an identifier: `obj?` or `obj??`
+// (e.g. `fmt.Println?`).
+var regexpIntrospection = regexp.MustCompile(`^\s*([\p{L}_][\p{L}\p{N}_]*(?:\.[\p{L}_][\p{L}\p{N}_]*)*)(\?\??)\s*$`)
+
+// handleIntrospectionCell replies to the execution of an introspection cell (`obj?` or `obj??`, see
+// regexpIntrospection) with the documentation of the identifier in the "page" payload, displayed by the
+// front-end pager. For `obj??` (detail level 1) it also includes the source of the declaration.
+func handleIntrospectionCell(msg kernel.Message, goExec *goexec.State, identifier string, detailLevel int,
+	replyContent map[string]any) error {
+	klog.V(1).Infof("introspection of %q, detailLevel=%d", identifier, detailLevel)
+	// Inspect the identifier as a statement in `func main()`, with the cursor on its last character.
+	lines := []string{"%%", identifier}
+	data, err := inspectCell(msg, goExec, lines, 1, len(identifier)-1, detailLevel)
+	if err != nil {
+		return err
 	}
-	return msg.Reply("inspect_reply", reply)
+	replyContent["status"] = "ok"
+	replyContent["user_expressions"] = make(map[string]string)
+	if len(data) == 0 {
+		data = kernel.MIMEMap{string(protocol.MIMETextPlain): fmt.Sprintf("No information found for %q.", identifier)}
+	}
+	replyContent["payload"] = []map[string]any{{
+		"source": "page",
+		"data":   data,
+		"start":  0,
+	}}
+	if err := msg.Reply("execute_reply", replyContent); err != nil {
+		return errors.WithMessagef(err, "publish 'execute_reply`")
+	}
+	return nil
+}
+
+// handleIsCompleteRequest replies with a `is_complete_reply` message, indicating whether the code
+// sent is ready to be executed. Used by console like front-ends.
+func handleIsCompleteRequest(msg kernel.Message) error {
+	content := msg.ComposedMsg().Content.(map[string]any)
+	code, _ := content["code"].(string)
+	status, indent := specialcmd.IsComplete(code)
+	klog.V(2).Infof("`is_complete_reply`: %q", status)
+	replyContent := map[string]any{"status": status}
+	if status == specialcmd.CodeIncomplete {
+		replyContent["indent"] = indent
+	}
+	return msg.Reply("is_complete_reply", replyContent)
+}
+
+// autoFormatCell formats the Go code of the cell (see goexec.FormatCell) and, if it changed, asks the front-end to
+// replace the contents of the cell with the formatted code, with a "set_next_input" payload in the reply.
+//
+// It returns the lines to execute and the corresponding special lines. Cells that fail to parse are executed as
+// they are, so the compilation reports the errors.
+func autoFormatCell(msg kernel.Message, goExec *goexec.State, lines []string, specialLines Set[int],
+	replyContent map[string]any) ([]string, Set[int]) {
+	formatted, err := goexec.FormatCell(lines, specialLines)
+	if err != nil || slices.Equal(formatted, lines) {
+		return lines, specialLines
+	}
+	formattedSpecialLines := MakeSet[int]()
+	if err = specialcmd.Parse(msg, goExec, false, formatted, formattedSpecialLines); err != nil {
+		return lines, specialLines
+	}
+	replyContent["payload"] = []map[string]any{{
+		"source":  "set_next_input",
+		"text":    strings.Join(formatted, "\n"),
+		"replace": true,
+	}}
+	return formatted, formattedSpecialLines
+}
+
+// handleFormatRequest replies to a `format_request` message with the formatted cell, in a `format_reply`.
+//
+// This is not a standard Jupyter message: it's meant for front-end extensions implementing "Format cell" (e.g.: in
+// JupyterLab). The request content is `{"code": "..."}`, and the reply is `{"status": "ok", "code": "...",
+// "changed": <bool>}` -- or with status "error", and the usual "ename", "evalue" and "traceback", if the Go code
+// fails to parse. Cells that are not Go (e.g.: `%%script`) are returned unchanged.
+func handleFormatRequest(msg kernel.Message, goExec *goexec.State) error {
+	content := msg.ComposedMsg().Content.(map[string]any)
+	code, _ := content["code"].(string)
+	lines := strings.Split(code, "\n")
+	replyContent := map[string]any{"status": "ok", "code": code, "changed": false}
+	if !specialcmd.IsGoCell(lines[0]) {
+		return msg.Reply("format_reply", replyContent)
+	}
+	specialLines := MakeSet[int]()
+	err := specialcmd.Parse(msg, goExec, false, lines, specialLines)
+	var formatted []string
+	if err == nil {
+		formatted, err = goexec.FormatCell(lines, specialLines)
+	}
+	if err != nil {
+		name, value, traceback := goexec.JupyterErrorSplit(err)
+		return msg.Reply("format_reply", map[string]any{
+			"status":    "error",
+			"ename":     name,
+			"evalue":    value,
+			"traceback": traceback,
+		})
+	}
+	replyContent["code"] = strings.Join(formatted, "\n")
+	replyContent["changed"] = replyContent["code"] != code
+	return msg.Reply("format_reply", replyContent)
 }
 
 // handleCompleteRequest replies with a `complete_reply` message, to auto-complete code.
@@ -0,0 +1,180 @@
+package goexec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"k8s.io/klog/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// This file implements a cache of the fingerprints of the last `go get` and `go build`, used to skip
+// them when nothing relevant changed since their last successful execution.
+//
+// Re-executing a cell (or executing a cell that only has special commands changing nothing in the Go
+// code) will re-use the previous binary. Notice that the Go toolchain already caches the compilation
+// of the dependencies, but the `main` package (that includes all memorized declarations along with the
+// current cell's `func main`) is always recompiled and linked when anything in it changes: skipping the
+// build is only possible if the generated code is exactly the same.
+//
+// In particular, a cell that only changes the body of `func main` is still rebuilt: the declarations and
+// `func main` are compiled together as one package, so there is no previous binary to reuse. What is skipped
+// in that case is the `go get` (goGetFingerprint). The latency of re-executions is measured by the benchmark
+// "execute/rerun" in `bench/`.
+
+// buildCache holds the fingerprints of the last successful `go get` and `go build`.
+// Empty fingerprints mean there is nothing cached.
+type buildCache struct {
+	goGet, build string
+}
+
+// buildCacheSourceExtensions are the file extensions that can affect a build.
+var buildCacheSourceExtensions = common.SetWithValues(".go", ".mod", ".sum", ".work", ".c", ".cc", ".cpp", ".h", ".s", ".syso")
+
+// Reset the cached fingerprints: the next `go get` and `go build` will be executed.
+func (c *buildCache) Reset() {
+	c.goGet = ""
+	c.build = ""
+}
+
+// goGetFingerprint hashes everything that can affect `go get`: the imports used, the test flag, the
+// environment, the `go.mod`, `go.sum` and `go.work` files, and the Go files created by the user
+// (e.g.: with `%%writefile`).
+//
+// The generated `main.go` (or `main_test.go`) is not included, since it changes at every cell: only its
+// imports matter.
+func (s *State) goGetFingerprint(decls *Declarations) (string, error) {
+	h := sha256.New()
+	for _, key := range common.SortedKeys(decls.Imports) {
+		_, _ = fmt.Fprintf(h, "import %q\n", decls.Imports[key].Path)
+	}
+	_, _ = fmt.Fprintf(h, "test=%v\n", s.CellIsTest)
+	hashEnviron(h)
+	for _, name := range []string{"go.mod", "go.sum", "go.work"} {
+		if err := hashFile(h, path.Join(s.TempDir, name)); err != nil {
+			return "", err
+		}
+	}
+	files, err := s.sourceFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, filePath := range files {
+		if filePath == path.Join(s.TempDir, MainGo) || filePath == path.Join(s.TempDir, MainTestGo) ||
+			!strings.HasSuffix(filePath, ".go") {
+			continue
+		}
+		if err := hashFile(h, filePath); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildFingerprint hashes everything that can affect the `go build` (or `go test -c`) with the given
+// arguments: the environment and the source files under State.TempDir.
+//
+// It returns an empty fingerprint if the build can't be safely cached: if the code depends on local
+// modules (that may change without notice) or embeds files with `//go:embed`.
+// It also returns an empty fingerprint if the output of the previous build is no longer available.
+func (s *State) buildFingerprint(args []string, outputPath string) (string, error) {
+	if s.hasGoWork || s.hasLocalReplace() {
+		return "", nil
+	}
+	if _, err := os.Stat(outputPath); err != nil {
+		return "", nil
+	}
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "args=%q\n", args)
+	hashEnviron(h)
+	files, err := s.sourceFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, filePath := range files {
+		contents, err := os.ReadFile(filePath)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read %q", filePath)
+		}
+		if strings.HasSuffix(filePath, ".go") && bytes.Contains(contents, []byte("//go:embed")) {
+			return "", nil
+		}
+		_, _ = fmt.Fprintf(h, "file %q %d\n", filePath, len(contents))
+		_, _ = h.Write(contents)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sourceFiles returns the sorted list of files under State.TempDir that can affect a build.
+func (s *State) sourceFiles() ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(s.TempDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || !buildCacheSourceExtensions.Has(path.Ext(filePath)) {
+			return nil
+		}
+		files = append(files, filePath)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list source files in %q", s.TempDir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// hasLocalReplace returns whether `go.mod` redirects any module to the local filesystem.
+func (s *State) hasLocalReplace() bool {
+	contents, err := os.ReadFile(path.Join(s.TempDir, "go.mod"))
+	if err != nil {
+		return false
+	}
+	for _, match := range regexpGoModReplace.FindAllSubmatch(contents, -1) {
+		target := strings.TrimSpace(string(match[1]))
+		if strings.HasPrefix(target, "/") || strings.HasPrefix(target, ".") || strings.HasPrefix(target, "~") {
+			return true
+		}
+	}
+	return false
+}
+
+// hashEnviron writes the sorted environment variables to the hash: they can change the build
+// (`GOOS`, `CGO_ENABLED`, `GOFLAGS`, etc.), and they can be changed with `%env`.
+func hashEnviron(w io.Writer) {
+	environ := os.Environ()
+	sort.Strings(environ)
+	for _, kv := range environ {
+		_, _ = fmt.Fprintf(w, "env %q\n", kv)
+	}
+}
+
+// hashFile writes the file name and contents to the hash. A missing file is hashed as such.
+func hashFile(w io.Writer, filePath string) error {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			_, _ = fmt.Fprintf(w, "missing %q\n", filePath)
+			return nil
+		}
+		return errors.Wrapf(err, "failed to read %q", filePath)
+	}
+	_, _ = fmt.Fprintf(w, "file %q %d\n", filePath, len(contents))
+	_, _ = w.Write(contents)
+	return nil
+}
+
+// logBuildCacheError logs errors fingerprinting: they simply disable the cache.
+func logBuildCacheError(step string, err error) {
+	klog.Warningf("goexec: build cache disabled for %s: %+v", step, err)
+}
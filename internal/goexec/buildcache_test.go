@@ -0,0 +1,85 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestBuildFingerprint(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	mainPath := path.Join(s.TempDir, MainGo)
+	writeMain := func(content string) {
+		require.NoError(t, os.WriteFile(mainPath, []byte(content), 0600))
+	}
+	args := []string{"build", "-o", s.BinaryPath()}
+
+	// No binary yet: nothing to reuse.
+	writeMain("package main\n\nfunc main() {}\n")
+	fingerprint, err := s.buildFingerprint(args, s.BinaryPath())
+	require.NoError(t, err)
+	assert.Empty(t, fingerprint)
+
+	require.NoError(t, os.WriteFile(s.BinaryPath(), []byte("binary"), 0700))
+	fingerprint, err = s.buildFingerprint(args, s.BinaryPath())
+	require.NoError(t, err)
+	assert.NotEmpty(t, fingerprint)
+
+	// Same sources and arguments.
+	again, err := s.buildFingerprint(args, s.BinaryPath())
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, again)
+
+	// Different build flags.
+	withRace, err := s.buildFingerprint(append(args, "-race"), s.BinaryPath())
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, withRace)
+
+	// Changed code.
+	writeMain("package main\n\nfunc main() { println(1) }\n")
+	changed, err := s.buildFingerprint(args, s.BinaryPath())
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, changed)
+
+	// Embedded files are not cached.
+	writeMain("package main\n\nimport _ \"embed\"\n\n//go:embed data.txt\nvar data string\n\nfunc main() {}\n")
+	embedded, err := s.buildFingerprint(args, s.BinaryPath())
+	require.NoError(t, err)
+	assert.Empty(t, embedded)
+}
+
+func TestGoGetFingerprint(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	decls := NewDeclarations()
+	decls.Imports["fmt"] = &Import{Key: "fmt", Path: "fmt"}
+	fingerprint, err := s.goGetFingerprint(decls)
+	require.NoError(t, err)
+
+	// Changes to main.go don't matter.
+	require.NoError(t, os.WriteFile(path.Join(s.TempDir, MainGo), []byte("package main\n"), 0600))
+	again, err := s.goGetFingerprint(decls)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, again)
+
+	// New imports do.
+	decls.Imports["strings"] = &Import{Key: "strings", Path: "strings"}
+	withImport, err := s.goGetFingerprint(decls)
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, withImport)
+
+	// And so do other Go files created by the user.
+	require.NoError(t, os.WriteFile(path.Join(s.TempDir, "other.go"), []byte("package main\n"), 0600))
+	withOther, err := s.goGetFingerprint(decls)
+	require.NoError(t, err)
+	assert.NotEqual(t, withImport, withOther)
+}
@@ -0,0 +1,333 @@
+package goexec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"go/scanner"
+	"go/token"
+	"golang.org/x/mod/modfile"
+	"hash"
+	"k8s.io/klog/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements `%cache`: the memoization of the outputs of a cell execution.
+//
+// The cell is fingerprinted with its code (`func main`), the memorized declarations it references
+// (transitively), the imports, the modules required, the program arguments, the build flags and
+// the environment variables set with `%env`. If a previous execution with the same fingerprint is
+// found on disk, its outputs (stdout, stderr and display data) are replayed instead of compiling
+// and executing the cell again.
+
+const (
+	// CellCacheDirEnv can be set to change the base directory where the outputs of cells executed with
+	// `%cache` are saved. It defaults to `gonb/cells` under the user cache directory (see os.UserCacheDir).
+	CellCacheDirEnv = "GONB_CELL_CACHE_DIR"
+)
+
+// cachedMsgTypes are the published message types that are recorded for `%cache`.
+var cachedMsgTypes = common.SetWithValues("stream", "display_data", "update_display_data", "clear_output", "execute_result")
+
+// cachedOutput is one message published by the cell execution.
+type cachedOutput struct {
+	MsgType string          `json:"msg_type"`
+	Content json.RawMessage `json:"content"`
+}
+
+// cachedCell is what is saved to disk for a cell execution.
+type cachedCell struct {
+	Time    time.Time      `json:"time"`
+	Outputs []cachedOutput `json:"outputs"`
+}
+
+// CellCacheDir returns the directory where cached cell outputs of the current notebook are saved.
+func CellCacheDir() (string, error) {
+	return notebookDir(CellCacheDirEnv, "cells")
+}
+
+// ResetCellCache removes all cached outputs of the current notebook. It returns the number of cached
+// cells removed.
+func ResetCellCache() (int, error) {
+	dir, err := CellCacheDir()
+	if err != nil {
+		return 0, err
+	}
+	files, _ := filepath.Glob(path.Join(dir, "*.json"))
+	for _, filePath := range files {
+		if err = os.Remove(filePath); err != nil {
+			return 0, errors.Wrapf(err, "failed to remove cached cell %q", filePath)
+		}
+	}
+	return len(files), nil
+}
+
+// cellCacheFingerprint hashes everything that can affect the outputs of the cell.
+func (s *State) cellCacheFingerprint(decls *Declarations, mainDecl *Function) (string, error) {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "main %q\n", mainDecl.Definition)
+	for _, definition := range referencedDefinitions(decls, mainDecl) {
+		_, _ = fmt.Fprintf(h, "decl %q\n", definition)
+	}
+	for _, key := range common.SortedKeys(decls.Imports) {
+		_, _ = fmt.Fprintf(h, "import %q %q\n", decls.Imports[key].Alias, decls.Imports[key].Path)
+	}
+	_, _ = fmt.Fprintf(h, "args=%q test=%v tests=%q profile=%q\n", s.Args, s.CellIsTest, s.CellTests, s.CellProfile)
+	if s.CellTestReport != nil {
+		_, _ = fmt.Fprintf(h, "test_report=%+v\n", *s.CellTestReport)
+	}
+	_, _ = fmt.Fprintf(h, "goflags=%q %q\n", s.GoBuildFlags, s.CellGoFlags)
+	for _, key := range common.SortedKeys(s.SessionEnv) {
+		_, _ = fmt.Fprintf(h, "env %q=%q\n", key, s.SessionEnv[key])
+	}
+	pwd, _ := os.Getwd()
+	_, _ = fmt.Fprintf(h, "pwd %q\n", pwd)
+	if err := s.hashGoModRequirements(h); err != nil {
+		return "", err
+	}
+
+	// Other Go files created by the user (e.g. with `%%writefile`).
+	files, err := s.sourceFiles()
+	if err != nil {
+		return "", err
+	}
+	for _, filePath := range files {
+		base := path.Base(filePath)
+		if !strings.HasSuffix(base, ".go") || base == MainGo || base == MainTestGo || base == ProfileHelperGo {
+			continue
+		}
+		contents, err := os.ReadFile(filePath)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read %q", filePath)
+		}
+		relPath, _ := filepath.Rel(s.TempDir, filePath)
+		_, _ = fmt.Fprintf(h, "file %q %d\n", relPath, len(contents))
+		_, _ = h.Write(contents)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashGoModRequirements writes the required modules (and replace rules) of `go.mod` to the hash. The module
+// name is not included, since it's different for each kernel session.
+func (s *State) hashGoModRequirements(h hash.Hash) error {
+	goModPath := path.Join(s.TempDir, "go.mod")
+	contents, err := os.ReadFile(goModPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to read %q", goModPath)
+	}
+	goMod, err := modfile.Parse(goModPath, contents, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %q", goModPath)
+	}
+	for _, req := range goMod.Require {
+		_, _ = fmt.Fprintf(h, "require %s@%s\n", req.Mod.Path, req.Mod.Version)
+	}
+	for _, rep := range goMod.Replace {
+		_, _ = fmt.Fprintf(h, "replace %s@%s => %s@%s\n", rep.Old.Path, rep.Old.Version, rep.New.Path, rep.New.Version)
+	}
+	return nil
+}
+
+// referencedDefinitions returns the sorted definitions of the declarations referenced (transitively) by
+// `mainDecl`. Declarations executed regardless of being referenced (`func init_*` and `var _ = ...`) are
+// always included.
+//
+// References are found by the identifiers used, so it may include declarations shadowed by local symbols,
+// which is ok: it only makes the fingerprint more conservative.
+func referencedDefinitions(decls *Declarations, mainDecl *Function) []string {
+	// Index the definitions by the identifier that references them.
+	byIdentifier := make(map[string][]string)
+	var roots []string
+	methods := make(map[string][]string) // type name -> method definitions.
+	for key, f := range decls.Functions {
+		if typeName, _, isMethod := strings.Cut(key, "~"); isMethod {
+			methods[typeName] = append(methods[typeName], f.Definition)
+			continue
+		}
+		if strings.HasPrefix(key, InitFunctionPrefix) {
+			roots = append(roots, f.Definition)
+			continue
+		}
+		byIdentifier[key] = append(byIdentifier[key], f.Definition)
+	}
+	for key, v := range decls.Variables {
+		definition := fmt.Sprintf("var %s %s = %s", v.Name, v.TypeDefinition, v.ValueDefinition)
+		if strings.HasPrefix(key, "_~") {
+			roots = append(roots, definition)
+			continue
+		}
+		byIdentifier[key] = append(byIdentifier[key], definition)
+	}
+	for key, t := range decls.Types {
+		byIdentifier[key] = append(byIdentifier[key], "type "+t.TypeDefinition)
+		byIdentifier[key] = append(byIdentifier[key], methods[key]...)
+	}
+	for key, c := range decls.Constants {
+		// Constants in a block may inherit the definitions of the previous ones (e.g.: `iota`).
+		var parts []string
+		for prev := c; prev != nil; prev = prev.Prev {
+			parts = append(parts, fmt.Sprintf("%s %s = %s", prev.Key, prev.TypeDefinition, prev.ValueDefinition))
+		}
+		byIdentifier[key] = append(byIdentifier[key], "const "+strings.Join(parts, "; "))
+	}
+
+	// Transitive closure of the references, starting from main and the declarations always executed.
+	visited := common.MakeSet[string]()
+	var definitions []string
+	toVisit := append([]string{mainDecl.Definition}, roots...)
+	definitions = append(definitions, roots...)
+	for len(toVisit) > 0 {
+		code := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		for _, identifier := range identifiers(code) {
+			if visited.Has(identifier) {
+				continue
+			}
+			visited.Insert(identifier)
+			for _, definition := range byIdentifier[identifier] {
+				definitions = append(definitions, definition)
+				toVisit = append(toVisit, definition)
+			}
+		}
+	}
+	sort.Strings(definitions)
+	return definitions
+}
+
+// identifiers returns the identifiers used in the Go code.
+func identifiers(code string) []string {
+	var s scanner.Scanner
+	fileSet := token.NewFileSet()
+	file := fileSet.AddFile("", fileSet.Base(), len(code))
+	s.Init(file, []byte(code), nil, 0)
+	var ids []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.IDENT {
+			ids = append(ids, lit)
+		}
+	}
+	return ids
+}
+
+// cachedCellPath returns the path of the file holding the outputs of the cell with the given fingerprint.
+func cachedCellPath(fingerprint string) (string, error) {
+	dir, err := CellCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, fingerprint+".json"), nil
+}
+
+// replayCachedCell publishes the outputs of a previous execution of the cell with the same fingerprint.
+// It returns false if there is no such execution cached.
+func replayCachedCell(msg kernel.Message, fingerprint string) (found bool, err error) {
+	filePath, err := cachedCellPath(fingerprint)
+	if err != nil {
+		return false, err
+	}
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to read cached cell %q", filePath)
+	}
+	cell := &cachedCell{}
+	if err = json.Unmarshal(contents, cell); err != nil {
+		return false, errors.Wrapf(err, "failed to decode cached cell %q", filePath)
+	}
+	klog.V(1).Infof("%%cache: replaying %d outputs from %q", len(cell.Outputs), filePath)
+	for _, output := range cell.Outputs {
+		var content map[string]any
+		if err = json.Unmarshal(output.Content, &content); err != nil {
+			return true, errors.Wrapf(err, "failed to decode cached %q output", output.MsgType)
+		}
+		if err = msg.Publish(output.MsgType, content); err != nil {
+			return true, errors.WithMessagef(err, "failed to publish cached %q output", output.MsgType)
+		}
+	}
+	return true, kernel.PublishWriteStream(msg, kernel.StreamStderr,
+		fmt.Sprintf("(%%cache: outputs replayed from execution at %s; use `%%cache reset` to invalidate)\n",
+			cell.Time.Format(time.DateTime)))
+}
+
+// saveCachedCell writes the recorded outputs of the cell execution.
+func saveCachedCell(fingerprint string, outputs []cachedOutput) error {
+	filePath, err := cachedCellPath(fingerprint)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory for cached cells")
+	}
+	contents, err := json.Marshal(&cachedCell{Time: time.Now(), Outputs: outputs})
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode cached cell")
+	}
+	tmpPath := filePath + ".tmp"
+	if err = os.WriteFile(tmpPath, contents, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", tmpPath)
+	}
+	return errors.Wrapf(os.Rename(tmpPath, filePath), "failed to rename %q", tmpPath)
+}
+
+// recordingMessage is a kernel.Message that records the outputs published, before forwarding them
+// to the original message.
+type recordingMessage struct {
+	kernel.Message
+
+	mu      sync.Mutex
+	outputs []cachedOutput
+	err     error
+}
+
+// Publish implements kernel.Message.
+func (m *recordingMessage) Publish(msgType string, content any) error {
+	if cachedMsgTypes.Has(msgType) {
+		encoded, err := json.Marshal(content)
+		m.mu.Lock()
+		if err != nil {
+			m.err = errors.Wrapf(err, "failed to encode %q output for %%cache", msgType)
+		} else {
+			m.outputs = append(m.outputs, cachedOutput{MsgType: msgType, Content: encoded})
+		}
+		m.mu.Unlock()
+	}
+	return m.Message.Publish(msgType, content)
+}
+
+// executeAndCache executes the compiled cell, recording its outputs to the cell cache if it succeeds.
+func (s *State) executeAndCache(msg kernel.Message, fingerprint string, fileToCellIdAndLine []CellIdAndLine) error {
+	recorder := &recordingMessage{Message: msg}
+	err := s.Execute(recorder, fileToCellIdAndLine)
+	if err != nil || msg.Kernel().Interrupted.Load() {
+		return err
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.err != nil {
+		klog.Warningf("Cell outputs not cached: %+v", recorder.err)
+		return nil
+	}
+	if cacheErr := saveCachedCell(fingerprint, recorder.outputs); cacheErr != nil {
+		klog.Warningf("Cell outputs not cached: %+v", cacheErr)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCellCacheFingerprint(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	decls := NewDeclarations()
+	decls.Functions["f"] = &Function{Key: "f", Name: "f", Definition: "func f() int { return g() }"}
+	decls.Functions["g"] = &Function{Key: "g", Name: "g", Definition: "func g() int { return 1 }"}
+	decls.Functions["h"] = &Function{Key: "h", Name: "h", Definition: "func h() int { return 2 }"}
+	decls.Types["T"] = &TypeDecl{Key: "T", TypeDefinition: "T struct{}"}
+	decls.Functions["T~M"] = &Function{Key: "T~M", Name: "M", Receiver: "T", Definition: "func (T) M() int { return 3 }"}
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() { fmt.Println(f()) }"}
+
+	assert.Equal(t, []string{"func f() int { return g() }", "func g() int { return 1 }"},
+		referencedDefinitions(decls, mainDecl))
+
+	fingerprint, err := s.cellCacheFingerprint(decls, mainDecl)
+	require.NoError(t, err)
+
+	// Changing a declaration not referenced doesn't change the fingerprint.
+	decls.Functions["h"].Definition = "func h() int { return 20 }"
+	unreferenced, err := s.cellCacheFingerprint(decls, mainDecl)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, unreferenced)
+
+	// But changing a transitively referenced one does.
+	decls.Functions["g"].Definition = "func g() int { return 10 }"
+	referenced, err := s.cellCacheFingerprint(decls, mainDecl)
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, referenced)
+
+	// Methods are referenced through their type, and so are program arguments.
+	mainDecl.Definition = "func main() { var t T; fmt.Println(t.M()) }"
+	assert.Equal(t, []string{"func (T) M() int { return 3 }", "type T struct{}"},
+		referencedDefinitions(decls, mainDecl))
+	withType, err := s.cellCacheFingerprint(decls, mainDecl)
+	require.NoError(t, err)
+	s.Args = []string{"--n=1"}
+	withArgs, err := s.cellCacheFingerprint(decls, mainDecl)
+	require.NoError(t, err)
+	assert.NotEqual(t, withType, withArgs)
+}
@@ -0,0 +1,72 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"os"
+	"strings"
+)
+
+// gonbuiPackage is the import path of the package with the build variables injected, see `gonbui.CellInfo`.
+const gonbuiPackage = "github.com/janpfeifer/gonb/gonbui"
+
+// cellInfoLdflags returns the linker `-X` flags that set the build variables of `gonbui.CellInfo`.
+// They are ignored by the linker if the program doesn't use `gonbui`.
+//
+// Only information that is the same for all executions of the kernel is included, so the build of a cell can
+// be reused (see buildFingerprint).
+func (s *State) cellInfoLdflags() string {
+	var kernelId string
+	if s.Kernel != nil {
+		kernelId = s.Kernel.JupyterKernelId
+	}
+	vars := []struct{ name, value string }{
+		{"buildKernelVersion", kernel.Version},
+		{"buildSessionId", s.UniqueID},
+		{"buildKernelId", kernelId},
+		{"buildNotebookPath", os.Getenv(JupyterSessionNameEnv)},
+	}
+	var parts []string
+	for _, v := range vars {
+		if v.value == "" {
+			continue
+		}
+		// The go command splits the flags on spaces, except within quotes -- there are no escape sequences.
+		quote := "'"
+		if strings.Contains(v.value, quote) {
+			quote = `"`
+			if strings.Contains(v.value, quote) {
+				continue
+			}
+		}
+		parts = append(parts, fmt.Sprintf("-X %s%s.%s=%s%s", quote, gonbuiPackage, v.name, v.value, quote))
+	}
+	return strings.Join(parts, " ")
+}
+
+// appendLdflags to the `go build` (or `go test`) arguments: they are added to the last `-ldflags` given
+// (e.g.: with `%goflags`), since only the last one is used, or as a new one.
+func appendLdflags(args []string, ldflags string) []string {
+	if ldflags == "" {
+		return args
+	}
+	for ii := len(args) - 1; ii >= 0; ii-- {
+		arg := strings.TrimPrefix(args[ii], "-")
+		if arg == "-ldflags" || arg == "ldflags" {
+			if ii+1 < len(args) {
+				args[ii+1] += " " + ldflags
+				return args
+			}
+			break
+		}
+		if value, found := strings.CutPrefix(arg, "-ldflags="); found {
+			args[ii] = "-ldflags=" + value + " " + ldflags
+			return args
+		}
+		if value, found := strings.CutPrefix(arg, "ldflags="); found {
+			args[ii] = "-ldflags=" + value + " " + ldflags
+			return args
+		}
+	}
+	return append(args, "-ldflags="+ldflags)
+}
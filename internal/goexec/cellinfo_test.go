@@ -0,0 +1,26 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAppendLdflags(t *testing.T) {
+	ldflags := "-X 'github.com/janpfeifer/gonb/gonbui.buildSessionId=abc'"
+	assert.Equal(t, []string{"build", "-ldflags=" + ldflags},
+		appendLdflags([]string{"build"}, ldflags))
+	assert.Equal(t, []string{"build", "-ldflags=-s -w " + ldflags, "-race"},
+		appendLdflags([]string{"build", "-ldflags=-s -w", "-race"}, ldflags))
+	assert.Equal(t, []string{"build", "--ldflags", "-s " + ldflags},
+		appendLdflags([]string{"build", "--ldflags", "-s"}, ldflags))
+	assert.Equal(t, []string{"build"}, appendLdflags([]string{"build"}, ""))
+}
+
+func TestCellInfoLdflags(t *testing.T) {
+	s := &State{UniqueID: "abc"}
+	t.Setenv(JupyterSessionNameEnv, "/home/me/my notebook's.ipynb")
+	ldflags := s.cellInfoLdflags()
+	assert.Contains(t, ldflags, "-X 'github.com/janpfeifer/gonb/gonbui.buildSessionId=abc'")
+	assert.Contains(t, ldflags, `-X "github.com/janpfeifer/gonb/gonbui.buildNotebookPath=/home/me/my notebook's.ipynb"`)
+	assert.NotContains(t, ldflags, "buildKernelId")
+}
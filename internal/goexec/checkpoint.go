@@ -0,0 +1,102 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// This file implements named checkpoints of the session, saved with `%save <name>` and restored with
+// `%load <name>`, typically in a fresh kernel.
+//
+// A checkpoint is a SessionSnapshot (see snapshot.go) written under CheckpointsDir, so it can be loaded
+// from any notebook. Unlike the session snapshots, checkpoints are only removed by the user.
+
+// CheckpointDirEnv can be set to change the directory where checkpoints are saved.
+// It defaults to `gonb/checkpoints` under the user cache directory (see os.UserCacheDir).
+const CheckpointDirEnv = "GONB_CHECKPOINT_DIR"
+
+// checkpointExt is the extension of the checkpoint files.
+const checkpointExt = ".json"
+
+// regexpCheckpointName matches the names accepted for checkpoints.
+var regexpCheckpointName = regexp.MustCompile(`^[\w.-]+$`)
+
+// CheckpointsDir returns the directory where checkpoints are saved. Unlike the snapshots (see SnapshotsDir), it
+// is shared by all notebooks.
+func CheckpointsDir() (string, error) {
+	return cacheDir(CheckpointDirEnv, "checkpoints")
+}
+
+// checkpointPath returns the path of the checkpoint file for name. If name contains a path separator
+// (e.g.: "./session.json"), it is taken as the path of the file. Otherwise, it must be a simple name, saved
+// under CheckpointsDir -- with or without the checkpointExt extension.
+func checkpointPath(name string) (string, error) {
+	if name == "" {
+		return "", errors.Errorf("checkpoint name is empty")
+	}
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		return name, nil
+	}
+	if !regexpCheckpointName.MatchString(name) {
+		return "", errors.Errorf("invalid checkpoint name %q: use only letters, digits, \"_\", \"-\" and \".\", "+
+			"or a path (e.g.: \"./%s\") to use it as the file", name, name)
+	}
+	dir, err := CheckpointsDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, strings.TrimSuffix(name, checkpointExt)+checkpointExt), nil
+}
+
+// SaveCheckpoint saves the memorized declarations, environment variables set with `%env`, `go.mod` and
+// `go.sum`, current directory, `go build` flags and tracked files under the given name.
+// It returns the path of the file written and the checkpoint.
+func (s *State) SaveCheckpoint(name string) (string, *SessionSnapshot, error) {
+	filePath, err := checkpointPath(name)
+	if err != nil {
+		return "", nil, err
+	}
+	snapshot, err := s.newSnapshot()
+	if err != nil {
+		return "", nil, errors.WithMessagef(err, "failed to capture checkpoint %q", name)
+	}
+	if err = os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return "", nil, errors.Wrapf(err, "failed to create directory for checkpoint %q", filePath)
+	}
+	if err = writeSnapshot(filePath, snapshot); err != nil {
+		return "", nil, err
+	}
+	return filePath, snapshot, nil
+}
+
+// LoadCheckpoint restores the configuration saved in the named checkpoint, see RecoverSnapshot.
+//
+// It returns the checkpoint, whose Code with the memorized declarations should be executed as a cell to
+// restore them.
+func (s *State) LoadCheckpoint(name string) (*SessionSnapshot, error) {
+	filePath, err := checkpointPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(filePath); err != nil {
+		return nil, errors.Wrapf(err, "checkpoint %q not found", name)
+	}
+	return s.restoreSnapshot(filePath)
+}
+
+// ListCheckpoints returns the names of the saved checkpoints, sorted.
+func ListCheckpoints() ([]string, error) {
+	dir, err := CheckpointsDir()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, filePath := range listSnapshots(dir, "") {
+		names = append(names, strings.TrimSuffix(filepath.Base(filePath), checkpointExt))
+	}
+	return names, nil
+}
@@ -0,0 +1,71 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	t.Setenv(CheckpointDirEnv, t.TempDir())
+	t.Setenv("GONB_CHECKPOINT_TEST", "") // Restored at the end of the test.
+
+	s := newEmptyState(t)
+	s.Definitions.Functions["f"] = &Function{Key: "f", Name: "f", Definition: "func f() int { return 1 }"}
+	s.SessionEnv = map[string]string{"GONB_CHECKPOINT_TEST": "bar"}
+	s.GoBuildFlags = []string{"-race"}
+	filePath, snapshot, err := s.SaveCheckpoint("my_session")
+	require.NoError(t, err)
+	assert.Equal(t, 1, snapshot.NumDeclarations)
+	assert.FileExists(t, filePath)
+	require.NoError(t, s.Stop())
+	names, err := ListCheckpoints()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"my_session"}, names, "Checkpoints are not removed when the kernel stops")
+
+	// Restore in a fresh kernel.
+	s2 := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s2.Stop(), "Failed to finalized state")
+	}()
+	_, err = s2.LoadCheckpoint("unknown")
+	require.Error(t, err)
+	snapshot, err = s2.LoadCheckpoint("my_session")
+	require.NoError(t, err)
+	assert.Contains(t, snapshot.Code, "func f() int { return 1 }")
+	assert.Equal(t, "bar", os.Getenv("GONB_CHECKPOINT_TEST"))
+	assert.Equal(t, []string{"-race"}, s2.GoBuildFlags)
+
+	// Checkpoints can also be saved to a given path.
+	otherPath := path.Join(t.TempDir(), "other.json")
+	filePath, _, err = s2.SaveCheckpoint(otherPath)
+	require.NoError(t, err)
+	assert.Equal(t, otherPath, filePath)
+	assert.FileExists(t, otherPath)
+}
+
+func TestCheckpointPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(CheckpointDirEnv, dir)
+	for _, tc := range []struct{ name, want string }{
+		{"my_session", path.Join(dir, "my_session.json")},
+		{"v1.2", path.Join(dir, "v1.2.json")},
+		{"foo.json", path.Join(dir, "foo.json")}, // Not a path: saved in the checkpoints directory.
+		{"./foo.json", "./foo.json"},
+		{"/tmp/session", "/tmp/session"},
+	} {
+		got, err := checkpointPath(tc.name)
+		require.NoErrorf(t, err, "checkpointPath(%q)", tc.name)
+		assert.Equalf(t, tc.want, got, "checkpointPath(%q)", tc.name)
+	}
+	for _, name := range []string{"", "my session", "a*b"} {
+		_, err := checkpointPath(name)
+		assert.Errorf(t, err, "checkpointPath(%q)", name)
+	}
+
+	got, err := CheckpointsDir()
+	require.NoError(t, err)
+	assert.Equal(t, dir, got)
+}
@@ -481,7 +481,7 @@ func (s *State) createCodeFromDecls(writer io.Writer, decls *Declarations, mainD
 		}
 		fileToCellIdAndLine = w.FillLinesGap(fileToCellIdAndLine)
 		fileToCellIdAndLine = mainDecl.CellLines.Append(fileToCellIdAndLine)
-		w.Writef("%s\n", mainDecl.Definition)
+		w.Writef("%s\n", s.injectProfileStart(mainDecl.Definition))
 	}
 	return
 }
@@ -0,0 +1,139 @@
+package goexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"golang.org/x/mod/semver"
+	"html"
+	"k8s.io/klog/v2"
+	"os/exec"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// This file implements the structured reporting of the diagnostics (errors and warnings of the compiler and
+// of `go vet`): their positions are translated to the cell and line where the code was defined, and they are
+// published, besides the HTML report, as metadata front-ends can use to highlight the offending cell lines.
+
+// DiagnosticsMetadataKey is the key in the metadata of the `display_data` message with the error report,
+// that holds the diagnostics: `{"gonb": {"diagnostics": [...]}}`, where each entry is a Diagnostic.
+const DiagnosticsMetadataKey = "gonb"
+
+// Diagnostic is one error or warning reported by the Go tools, with its position in the generated code and in
+// the cell that defined it.
+type Diagnostic struct {
+	// Severity is "error", or "warning" for `go vet` findings.
+	Severity string `json:"severity"`
+
+	// Source of the diagnostic: "compiler" or "vet".
+	Source string `json:"source"`
+
+	Message string `json:"message"`
+
+	// File, Line and Column (starting at 1) of the generated code where the diagnostic was reported.
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+
+	// CellId is the execution count of the cell that defined the code, or -1 for the cell being executed.
+	// It is only valid if CellLine > 0.
+	CellId int `json:"cell_id"`
+
+	// CellLine (starting at 1) where the code was defined, or 0 if it was generated by GoNB.
+	CellLine int `json:"cell_line"`
+}
+
+// Diagnostics returns the errors (and warnings) that could be parsed from the output of the Go tools.
+func (nbErr *GonbError) Diagnostics() []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, len(nbErr.Lines))
+	for _, line := range nbErr.Lines {
+		if line.Diagnostic != nil {
+			diagnostics = append(diagnostics, *line.Diagnostic)
+		}
+	}
+	return diagnostics
+}
+
+// diagnosticsMetadata returns the metadata of the error report, with the diagnostics.
+func (nbErr *GonbError) diagnosticsMetadata() kernel.MIMEMap {
+	return kernel.MIMEMap{
+		DiagnosticsMetadataKey: map[string]any{"diagnostics": nbErr.Diagnostics()},
+	}
+}
+
+// squiggleHtml returns the escaped line of code, with the token starting at the column (in bytes, starting at 1)
+// underlined with a squiggly line.
+func squiggleHtml(line string, col int) string {
+	start := col - 1
+	if start < 0 || start >= len(line) {
+		return html.EscapeString(line)
+	}
+	end := start
+	for end < len(line) {
+		r, size := utf8.DecodeRuneInString(line[end:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		end += size
+	}
+	if end == start {
+		// Not an identifier: underline only one character.
+		_, size := utf8.DecodeRuneInString(line[start:])
+		end += size
+	}
+	return html.EscapeString(line[:start]) + `<span class="gonb-err-squiggle">` + html.EscapeString(line[start:end]) +
+		`</span>` + html.EscapeString(line[end:])
+}
+
+// buildEvent is one line of the output of `go build -json`, see `go help buildjson`.
+type buildEvent struct {
+	ImportPath string
+	Action     string
+	Output     string
+}
+
+// buildOutputFromJSON converts the output of `go build -json` to the usual text output: the "build-output" events
+// are concatenated, and lines that are not JSON (e.g.: output of the `go` command itself) are kept as they are.
+func buildOutputFromJSON(output []byte) []byte {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(output, []byte("\n")) {
+		var event buildEvent
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '{' || json.Unmarshal(line, &event) != nil || event.Action == "" {
+			buf.Write(line)
+			continue
+		}
+		if event.Action == "build-output" {
+			buf.WriteString(event.Output)
+		}
+	}
+	return buf.Bytes()
+}
+
+var (
+	goBuildJSONOnce      sync.Once
+	goBuildJSONSupported bool
+)
+
+// goBuildSupportsJSON returns whether `go build` supports the `-json` flag (Go 1.24 or newer).
+func goBuildSupportsJSON() bool {
+	goBuildJSONOnce.Do(func() {
+		output, err := exec.Command("go", "env", "GOVERSION").Output()
+		if err != nil {
+			klog.Warningf("goexec: failed to get the Go version: %+v", err)
+			return
+		}
+		version := "v" + strings.TrimPrefix(strings.TrimSpace(string(output)), "go")
+		// Release candidates and betas (e.g.: "go1.24rc1") are not valid semver, compare only the prefix.
+		if idx := strings.IndexAny(version, "rb"); idx > 0 {
+			version = version[:idx]
+		}
+		goBuildJSONSupported = semver.IsValid(version) && semver.Compare(version, "v1.24") >= 0
+	})
+	return goBuildJSONSupported
+}
@@ -0,0 +1,48 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	s := newEmptyStateWithRawError(t, true)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+	fileToCellLine := createTestGoMain(t, s, sampleCellCode)
+	fileToCellIdAndLine := MakeFileToCellIdAndLine(-1, fileToCellLine)
+	errorMsg := "# gonb_test\n" +
+		"./main.go:3:1: undefined: fmt\n" +
+		"vet: ./main.go:1:9: something suspicious\n"
+	err := s.DisplayErrorWithContext(nil, fileToCellIdAndLine, errorMsg, errors.New("build failed"))
+	var nbErr *GonbError
+	require.True(t, errors.As(err, &nbErr))
+	diagnostics := nbErr.Diagnostics()
+	require.Len(t, diagnostics, 2)
+	assert.Equal(t, Diagnostic{Severity: "error", Source: "compiler", Message: "undefined: fmt",
+		File: "./main.go", Line: 3, Column: 1, CellId: -1, CellLine: 1}, diagnostics[0])
+	assert.Equal(t, "warning", diagnostics[1].Severity)
+	assert.Equal(t, "vet", diagnostics[1].Source)
+	assert.Equal(t, 0, diagnostics[1].CellLine, "`package main` is not in the cell")
+	assert.Contains(t, nbErr.Lines[1].HtmlContext, `<span class="gonb-err-squiggle">import</span> &#34;fmt&#34;`)
+}
+
+func TestSquiggleHtml(t *testing.T) {
+	assert.Equal(t, `x := <span class="gonb-err-squiggle">fooBar</span>(1) &lt; 2`, squiggleHtml("x := fooBar(1) < 2", 6))
+	assert.Equal(t, `x <span class="gonb-err-squiggle">:</span>= 1`, squiggleHtml("x := 1", 3))
+	assert.Equal(t, `x`, squiggleHtml("x", 10))
+}
+
+func TestBuildOutputFromJSON(t *testing.T) {
+	output := `go: downloading example.com/m v1.0.0
+{"ImportPath":"x","Action":"build-output","Output":"# x\n"}
+{"ImportPath":"x","Action":"build-output","Output":"./main.go:5:2: undefined: y\n"}
+{"ImportPath":"x","Action":"build-fail"}
+`
+	assert.Equal(t, "go: downloading example.com/m v1.0.0\n# x\n./main.go:5:2: undefined: y\n",
+		string(buildOutputFromJSON([]byte(output))))
+}
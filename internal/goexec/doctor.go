@@ -0,0 +1,286 @@
+package goexec
+
+import (
+	"context"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec/goplsclient"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// This file implements `%doctor`: a self-diagnosis of the environment GoNB runs in, with actionable fixes
+// for the problems found.
+
+// DoctorHeartbeatTimeout is how long `%doctor` waits for the front-end to reply to a websocket ping.
+var DoctorHeartbeatTimeout = 2 * time.Second
+
+// doctorStatus of each check.
+type doctorStatus int
+
+const (
+	doctorOK doctorStatus = iota
+	doctorWarning
+	doctorFailed
+)
+
+// String returns the icon used to display the status.
+func (st doctorStatus) String() string {
+	switch st {
+	case doctorOK:
+		return "✅"
+	case doctorWarning:
+		return "⚠️"
+	default:
+		return "❌"
+	}
+}
+
+// doctorCheck is the result of one check: `fix` is only set if the status is not doctorOK.
+type doctorCheck struct {
+	name   string
+	status doctorStatus
+	detail string
+	fix    string
+}
+
+// Doctor checks the environment (Go toolchain, `gopls`, temporary directories, named pipes, the connection
+// to Jupyter and to the front-end, and the Go module proxy) and publishes a report, with suggested fixes.
+// It implements the `%doctor` special command.
+func (s *State) Doctor(msg kernel.Message) error {
+	var checks []doctorCheck
+	checks = append(checks, s.doctorGo(msg))
+	checks = append(checks, doctorTool("goimports", "golang.org/x/tools/cmd/goimports@latest",
+		"used to automatically add missing imports"))
+	checks = append(checks, s.doctorGopls())
+	checks = append(checks, doctorWritableDir("Temporary directory", s.TempDir))
+	checks = append(checks, doctorWritableDir("System temporary directory", os.TempDir()))
+	checks = append(checks, doctorNamedPipes(s.TempDir))
+	checks = append(checks, doctorJupyterConnection(msg))
+	checks = append(checks, s.doctorWebSocket(msg))
+	checks = append(checks, s.doctorModuleProxy(msg)...)
+
+	var report strings.Builder
+	report.WriteString("## GoNB Doctor\n\n| | Check | Details |\n|---|---|---|\n")
+	var fixes []string
+	for _, check := range checks {
+		_, _ = fmt.Fprintf(&report, "| %s | %s | %s |\n", check.status, check.name, escapeMarkdownCell(check.detail))
+		if check.status != doctorOK && check.fix != "" {
+			fixes = append(fixes, fmt.Sprintf("- **%s**: %s", check.name, check.fix))
+		}
+	}
+	if len(fixes) > 0 {
+		report.WriteString("\n### Suggested fixes\n\n")
+		report.WriteString(strings.Join(fixes, "\n"))
+		report.WriteString("\n")
+	} else {
+		report.WriteString("\nNo problems found.\n")
+	}
+	return kernel.PublishMarkdown(msg, report.String())
+}
+
+// escapeMarkdownCell makes text safe to be included in a cell of a Markdown table.
+func escapeMarkdownCell(text string) string {
+	text = strings.ReplaceAll(strings.TrimSpace(text), "|", "\\|")
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+// doctorGo checks the Go toolchain is available, and its version.
+func (s *State) doctorGo(msg kernel.Message) doctorCheck {
+	check := doctorCheck{name: "Go toolchain"}
+	const installFix = "install Go from https://go.dev/dl/ and make sure `go` is in the `PATH` of the kernel -- " +
+		"notice Jupyter may not inherit the `PATH` of your shell."
+	goPath, err := exec.LookPath("go")
+	if err != nil {
+		check.status, check.detail, check.fix = doctorFailed, "`go` not found in PATH", installFix
+		return check
+	}
+	cmd := exec.Command(goPath, "version")
+	cmd.Dir = s.TempDir
+	output, err := runWithWatchdog(msg, GoImportsTimeout, cmd)
+	if err != nil {
+		check.status, check.detail, check.fix = doctorFailed, fmt.Sprintf("%q failed: %v %s", cmd, err, output), installFix
+		return check
+	}
+	check.detail = fmt.Sprintf("%s (%s)", strings.TrimSpace(string(output)), goPath)
+	fields := strings.Fields(string(output))
+	// Development toolchains report "go version devel ...".
+	if len(fields) >= 3 && fields[2] != "devel" && common.IsOldGoVersion(fields[2]) {
+		check.status = doctorWarning
+		check.fix = fmt.Sprintf("GoNB requires %s or newer, upgrade Go from https://go.dev/dl/.", common.MinGoVersion)
+	}
+	return check
+}
+
+// doctorTool checks a Go tool is installed.
+func doctorTool(name, installPath, usage string) doctorCheck {
+	check := doctorCheck{name: name}
+	toolPath, err := exec.LookPath(name)
+	if err != nil {
+		check.status = doctorFailed
+		check.detail = fmt.Sprintf("not found in PATH, it is %s", usage)
+		check.fix = fmt.Sprintf("install it with `!go install %s`, and make sure `$(go env GOPATH)/bin` is in the PATH.", installPath)
+		return check
+	}
+	check.detail = toolPath
+	return check
+}
+
+// doctorGopls checks `gopls` is installed, running and connected.
+func (s *State) doctorGopls() doctorCheck {
+	check := doctorTool("gopls", "golang.org/x/tools/gopls@latest", "used for auto-complete and contextual help")
+	if check.status != doctorOK {
+		return check
+	}
+	restartFix := "restart the kernel; if the problem persists, check the kernel logs (e.g.: `--extra_log`)."
+	if s.gopls == nil {
+		check.status, check.detail, check.fix = doctorWarning, check.detail+": not started (installed after the kernel started?)", restartFix
+		return check
+	}
+	if s.gopls.IsStopped() {
+		check.status, check.detail, check.fix = doctorFailed, check.detail+": not running", restartFix
+		return check
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*goplsclient.ConnectTimeout)
+	defer cancel()
+	if !s.gopls.WaitConnection(ctx) {
+		check.status, check.detail, check.fix = doctorFailed, check.detail+": running but not connected", restartFix
+		return check
+	}
+	check.detail += ": running and connected"
+	return check
+}
+
+// doctorWritableDir checks files can be created in dir.
+func doctorWritableDir(name, dir string) doctorCheck {
+	check := doctorCheck{name: name, detail: dir}
+	f, err := os.CreateTemp(dir, "gonb_doctor_")
+	if err != nil {
+		check.status = doctorFailed
+		check.detail = fmt.Sprintf("%s: not writable: %v", dir, err)
+		check.fix = fmt.Sprintf("check the permissions and free space of %q, or set `$TMPDIR` to a writable directory before starting Jupyter.", dir)
+		return check
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return check
+}
+
+// doctorNamedPipes checks named pipes (used to display rich content and for widgets) can be created in dir.
+func doctorNamedPipes(dir string) doctorCheck {
+	check := doctorCheck{name: "Named pipes", detail: "supported"}
+	pipePath := path.Join(dir, "gonb_doctor_pipe_"+common.UniqueId())
+	if err := syscall.Mkfifo(pipePath, 0600); err != nil {
+		check.status = doctorFailed
+		check.detail = fmt.Sprintf("failed to create a named pipe in %q: %v", dir, err)
+		check.fix = "named pipes are used by Go cells to display rich content (HTML, images, widgets): set `$TMPDIR` " +
+			"to a local filesystem (some network or mounted filesystems don't support them) before starting Jupyter."
+		return check
+	}
+	_ = os.Remove(pipePath)
+	return check
+}
+
+// doctorJupyterConnection checks the ZMQ sockets connected to the Jupyter client, and its heartbeats.
+func doctorJupyterConnection(msg kernel.Message) doctorCheck {
+	check := doctorCheck{name: "Jupyter connection (ZMQ)"}
+	k := msg.Kernel()
+	if k == nil || k.IsStandalone() {
+		check.detail = "not used: running without Jupyter (console or HTTP API)"
+		return check
+	}
+	addresses := k.SocketAddresses()
+	parts := make([]string, 0, len(addresses))
+	for _, name := range common.SortedKeys(addresses) {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, addresses[name]))
+	}
+	check.detail = strings.Join(parts, ", ")
+	lastHeartbeat := k.LastHeartbeat()
+	switch {
+	case lastHeartbeat.IsZero():
+		check.status = doctorWarning
+		check.detail += "; no heartbeat received from Jupyter yet"
+	case time.Since(lastHeartbeat) > time.Minute:
+		check.status = doctorWarning
+		check.detail += fmt.Sprintf("; last heartbeat received %s ago", time.Since(lastHeartbeat).Round(time.Second))
+	default:
+		check.detail += fmt.Sprintf("; last heartbeat received %s ago", time.Since(lastHeartbeat).Round(time.Millisecond))
+	}
+	if check.status != doctorOK {
+		check.fix = "Jupyter may not be monitoring the kernel: if it shows the kernel as disconnected, restart it."
+	}
+	return check
+}
+
+// doctorWebSocket checks the websocket connection with the front-end, used by widgets, with a live ping.
+func (s *State) doctorWebSocket(msg kernel.Message) doctorCheck {
+	check := doctorCheck{name: "Front-end websocket (widgets)"}
+	if s.Comms == nil {
+		check.detail = "not available"
+		return check
+	}
+	installed, opened, numPeers := s.Comms.Status()
+	if !installed && !opened {
+		check.detail = "not installed: it is only needed by widgets, and is installed on demand (or with `%widgets`)"
+		return check
+	}
+	fix := "reload the notebook page; if it persists, check that the browser can open websockets to JupyterServer " +
+		"(some proxies block them) and look for errors in the browser's Javascript console (enable logging with `--comms_log`)."
+	if !opened {
+		check.status, check.detail, check.fix = doctorFailed, "installed, but the front-end never connected back", fix
+		return check
+	}
+	start := time.Now()
+	pong, err := s.Comms.SendHeartbeatAndWait(msg, DoctorHeartbeatTimeout)
+	switch {
+	case err != nil:
+		check.status, check.detail, check.fix = doctorFailed, fmt.Sprintf("failed to send ping: %v", err), fix
+	case !pong:
+		check.status, check.detail, check.fix = doctorFailed, fmt.Sprintf("no reply to ping in %s", DoctorHeartbeatTimeout), fix
+	default:
+		check.detail = fmt.Sprintf("connected (%d front-end connection(s)), ping replied in %s",
+			numPeers, time.Since(start).Round(time.Millisecond))
+	}
+	return check
+}
+
+// doctorModuleProxy checks the Go module proxies and checksum database are reachable.
+func (s *State) doctorModuleProxy(msg kernel.Message) []doctorCheck {
+	const proxyName, sumDBName = "Go module proxy", "Go checksum database"
+	fix := "`go get` won't be able to download (or verify) modules: use `%proxy check` for details, and `%proxy` to configure " +
+		"the network (e.g.: `%proxy http://proxy.corp:3128`, `%proxy ca <file.pem>`)."
+	goProxy, goSumDB, proxies, sumDBURL, err := s.moduleEndpoints(msg)
+	if err != nil {
+		return []doctorCheck{{name: proxyName, status: doctorFailed, detail: err.Error(), fix: fix}}
+	}
+	client, err := NetworkHTTPClient()
+	if err != nil {
+		return []doctorCheck{{name: proxyName, status: doctorFailed, detail: err.Error(), fix: fix}}
+	}
+	check := func(name, target string) doctorCheck {
+		line, ok := checkNetworkTarget(client, target)
+		result := doctorCheck{name: name, detail: strings.TrimSpace(strings.TrimLeft(line, "✅❌ "))}
+		if !ok {
+			result.status, result.fix = doctorFailed, fix
+		}
+		return result
+	}
+	var checks []doctorCheck
+	if len(proxies) == 0 {
+		checks = append(checks, doctorCheck{name: proxyName, detail: fmt.Sprintf("GOPROXY=%q: no remote proxy used", goProxy)})
+	}
+	for _, target := range proxies {
+		checks = append(checks, check(proxyName, target))
+	}
+	if sumDBURL == "" {
+		checks = append(checks, doctorCheck{name: sumDBName, detail: fmt.Sprintf("GOSUMDB=%q: not used", goSumDB)})
+	} else {
+		checks = append(checks, check(sumDBName, sumDBURL))
+	}
+	return checks
+}
@@ -0,0 +1,24 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path"
+	"testing"
+)
+
+func TestDoctorChecks(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, doctorOK, doctorWritableDir("tmp", dir).status)
+	check := doctorWritableDir("tmp", path.Join(dir, "missing"))
+	assert.Equal(t, doctorFailed, check.status)
+	assert.NotEmpty(t, check.fix)
+
+	assert.Equal(t, doctorOK, doctorNamedPipes(dir).status)
+	assert.Equal(t, doctorFailed, doctorNamedPipes(path.Join(dir, "missing")).status)
+
+	check = doctorTool("gonb_doctor_missing_tool", "example.com/tool@latest", "used for tests")
+	assert.Equal(t, doctorFailed, check.status)
+	assert.Contains(t, check.fix, "!go install example.com/tool@latest")
+
+	assert.Equal(t, `a \| b<br>c`, escapeMarkdownCell("a | b\nc\n"))
+}
@@ -0,0 +1,121 @@
+package goexec
+
+import (
+	"html"
+	"regexp"
+)
+
+// This file implements a knowledge base of common failures: error reports whose output matches one of the
+// known signatures get a targeted suggestion on how to fix it appended.
+
+// ErrorHint is a suggested resolution for a common failure.
+type ErrorHint struct {
+	// Name identifies the failure, e.g.: "missing-c-compiler".
+	Name string
+
+	// Pattern matching the output (or error message) of the failure.
+	Pattern *regexp.Regexp
+
+	// Suggestion displayed to the user. Text between backticks is rendered as code.
+	Suggestion string
+}
+
+// ErrorHints is the table of known failure signatures, in the order their suggestions are displayed.
+// It can be extended (it's not safe to change it concurrently with the execution of cells).
+var ErrorHints = []*ErrorHint{
+	{
+		Name:    "missing-c-compiler",
+		Pattern: regexp.MustCompile(`C compiler "[^"]*" not found|exec: "(gcc|clang|cc)": executable file not found`),
+		Suggestion: "Some package requires cgo, but no C compiler was found: install one (e.g. `apt install gcc`, or " +
+			"`xcode-select --install` on macOS), or disable cgo with `%env CGO_ENABLED 0`, if the packages support it.",
+	},
+	{
+		Name:    "race-requires-cgo",
+		Pattern: regexp.MustCompile(`-race requires cgo`),
+		Suggestion: "The race detector requires cgo: install a C compiler and make sure `CGO_ENABLED` is not " +
+			"set to 0 (e.g. `%env CGO_ENABLED 1`).",
+	},
+	{
+		Name:    "gopath-go-mod",
+		Pattern: regexp.MustCompile(`\$GOPATH/go\.mod exists but should not`),
+		Suggestion: "There is a `go.mod` file in the `$GOPATH` directory (by default `~/go`): remove it, or point " +
+			"`GOPATH` somewhere else (e.g. `%env GOPATH /path/to/gopath`).",
+	},
+	{
+		Name:    "gopath-relative",
+		Pattern: regexp.MustCompile(`GOPATH entry is relative|GOPATH entry cannot start with shell metacharacter`),
+		Suggestion: "`GOPATH` must be an absolute path: fix it in the environment Jupyter is started from, or " +
+			"with `%env GOPATH /absolute/path`.",
+	},
+	{
+		Name:    "goroot",
+		Pattern: regexp.MustCompile(`cannot find GOROOT directory|go: cannot find GOROOT`),
+		Suggestion: "`GOROOT` points to a missing Go installation: unset it (the `go` tool finds its own) or fix it " +
+			"in the environment Jupyter is started from.",
+	},
+	{
+		Name:    "go-version",
+		Pattern: regexp.MustCompile(`requires go >= \S+ \(running go \S+`),
+		Suggestion: "A module requires a newer Go version: upgrade Go, or let the `go` tool download the required " +
+			"toolchain with `%env GOTOOLCHAIN auto`.",
+	},
+	{
+		Name:    "checksum-mismatch",
+		Pattern: regexp.MustCompile(`checksum mismatch|SECURITY ERROR`),
+		Suggestion: "A downloaded module doesn't match its recorded checksum: if it was re-tagged upstream, clean the " +
+			"cached copy with `!go clean -modcache` and remove the stale `go.sum` (`!*rm go.sum`, it is regenerated); " +
+			"for private modules set `%proxy goprivate <patterns...>`, so they are not verified against the public " +
+			"checksum database.",
+	},
+	{
+		Name:       "missing-go-sum",
+		Pattern:    regexp.MustCompile(`missing go\.sum entry`),
+		Suggestion: "Some module is missing from `go.sum`: run `!*go mod tidy` to update it.",
+	},
+	{
+		Name:    "tls-certificate",
+		Pattern: regexp.MustCompile(`x509: certificate signed by unknown authority|tls: failed to verify certificate`),
+		Suggestion: "The TLS certificate of the server (or of a proxy intercepting connections) is not trusted: " +
+			"set the certificate authority with `%proxy ca <file.pem>`.",
+	},
+	{
+		Name:    "network",
+		Pattern: regexp.MustCompile(`dial tcp: lookup \S+.*no such host|proxyconnect tcp|i/o timeout|connection refused`),
+		Suggestion: "There was a problem connecting to the network: check the connection to the Go module proxy " +
+			"with `%proxy check`, and set a proxy, if required, with `%proxy <url>`.",
+	},
+	{
+		Name:    "fifo-unsupported",
+		Pattern: regexp.MustCompile(`Mkfifo.*(operation not permitted|permission denied|not supported|function not implemented)`),
+		Suggestion: "Named pipes (used to display rich content and widgets) can't be created in the temporary " +
+			"directory, usually because it's a mounted volume: set `$TMPDIR` to a local filesystem (e.g. `/tmp`) " +
+			"before starting Jupyter. `%doctor` checks it.",
+	},
+}
+
+// MatchErrorHints returns the suggestions of the ErrorHints whose pattern matches `output`.
+func MatchErrorHints(output string) (suggestions []string) {
+	for _, hint := range ErrorHints {
+		if hint.Pattern.MatchString(output) {
+			suggestions = append(suggestions, hint.Suggestion)
+		}
+	}
+	return
+}
+
+// regexpBackticks matches text between backticks in the suggestions.
+var regexpBackticks = regexp.MustCompile("`([^`]+)`")
+
+// hintToHtml escapes the suggestion, and renders the text between backticks as code.
+func hintToHtml(suggestion string) string {
+	return regexpBackticks.ReplaceAllString(html.EscapeString(suggestion), "<code>$1</code>")
+}
+
+// hintsTraceback returns the lines with the suggestions to be appended to an error traceback.
+func hintsTraceback(suggestions []string) []string {
+	lines := make([]string, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		lines = append(lines, "💡 "+suggestion)
+	}
+	return lines
+}
@@ -0,0 +1,36 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMatchErrorHints(t *testing.T) {
+	for name, output := range map[string]string{
+		"missing-c-compiler": `# runtime/cgo
+cgo: C compiler "gcc" not found: exec: "gcc": executable file not found in $PATH`,
+		"gopath-go-mod":     "go: warning: ignoring go.mod in $GOPATH /home/user/go\n$GOPATH/go.mod exists but should not",
+		"checksum-mismatch": "verifying github.com/x/y@v1.0.0: checksum mismatch\n\tdownloaded: h1:abc\n\tgo.sum: h1:def\n\nSECURITY ERROR",
+		"fifo-unsupported":  `failed to create pipe (Mkfifo) for "/mnt/data/gonb_pipe_123": operation not permitted`,
+	} {
+		hints := MatchErrorHints(output)
+		var found bool
+		for _, hint := range ErrorHints {
+			if hint.Name == name {
+				require.Len(t, hints, 1, "hint %q", name)
+				assert.Equal(t, hint.Suggestion, hints[0])
+				found = true
+			}
+		}
+		assert.True(t, found, "hint %q not in ErrorHints", name)
+	}
+	assert.Empty(t, MatchErrorHints("./main.go:3:1: undefined: x"))
+
+	assert.Equal(t, "use <code>%env A &lt;b&gt;</code>", hintToHtml("use `%env A <b>`"))
+
+	_, _, traceback := JupyterErrorSplit(errors.New(`failed to create pipe (Mkfifo) for "/mnt/x": operation not permitted`))
+	require.Len(t, traceback, 2)
+	assert.Contains(t, traceback[1], "$TMPDIR")
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"text/template"
 
+	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/internal/kernel"
 	"k8s.io/klog/v2"
 )
@@ -46,6 +47,28 @@ var templateErrorReport = template.Must(template.New("error_report").Parse(`
 	background-color: var(--jp-rendermime-err-background);
 	font-weight: bold;
 }
+.gonb-err-squiggle {
+	text-decoration: underline wavy var(--jp-error-color1, red);
+	text-decoration-skip-ink: none;
+}
+.gonb-err-severity {
+	border-radius: 3px;
+	color: white;
+	font-size: 85%;
+	padding-left: 0.3em;
+	padding-right: 0.3em;
+	background: var(--jp-error-color1, red);
+}
+.gonb-err-severity-warning {
+	background: var(--jp-warn-color1, orange);
+}
+.gonb-err-hints {
+	background: var(--jp-layout-color2);
+	border-left: 3px solid var(--jp-warn-color1, orange);
+	margin-top: 0.5em;
+	padding: 0.2em 0.5em;
+	font-family: var(--jp-ui-font-family, sans-serif);
+}
 .gonb-cell-line-info {
 	background: var(--jp-layout-color2);
 	color: #999;
@@ -58,7 +81,8 @@ var templateErrorReport = template.Must(template.New("error_report").Parse(`
 <div class="lm-Widget p-Widget lm-Panel p-Panel jp-OutputArea-child">
 <div class="lm-Widget p-Widget jp-RenderedText jp-mod-trusted jp-OutputArea-output" data-mime-type="application/vnd.jupyter.stderr" style="font-family: monospace;">
 {{range .Lines}}
-{{if .HasContext}}{{if .HasCellInfo}}<span class="gonb-cell-line-info">{{.CellInfo}}</span>
+{{if .HasContext}}{{with .Diagnostic}}<span class="gonb-err-severity gonb-err-severity-{{.Severity}}">{{.Severity}}</span>
+{{end}}{{if .HasCellInfo}}<span class="gonb-cell-line-info">{{.CellInfo}}</span>
 {{end}}<span class="gonb-err-location">{{.Location}}</span> {{.Message}}
 <div class="gonb-err-context">
 {{.HtmlContext}}
@@ -68,6 +92,8 @@ var templateErrorReport = template.Must(template.New("error_report").Parse(`
 {{end}}
 <br/>
 {{end}}
+{{with .HintsHtml}}<div class="gonb-err-hints">{{range .}}💡 {{.}}<br/>
+{{end}}</div>{{end}}
 </div>
 `))
 
@@ -96,7 +122,8 @@ func (s *State) DisplayErrorWithContext(msg kernel.Message, fileToCellIdAndLine
 // Hard-coded for now, but it could be made configurable.
 const LinesForErrorContext = 3
 
-// PublishWithHTML reports the GonbError as an HTML report in Jupyter.
+// PublishWithHTML reports the GonbError as an HTML report in Jupyter, with the diagnostics in the metadata
+// (see DiagnosticsMetadataKey).
 func (nbErr *GonbError) PublishWithHTML(msg kernel.Message) {
 	if msg == nil {
 		// Ignore, if there is no kernel.Message to reply to.
@@ -106,7 +133,10 @@ func (nbErr *GonbError) PublishWithHTML(msg kernel.Message) {
 	htmlReport := "<pre>" + nbErr.errMsg + "</pre>" // If anything goes wrong, simply display the err message.
 	defer func() {
 		// Display HTML report on exit.
-		err := kernel.PublishHtml(msg, htmlReport)
+		err := kernel.PublishData(msg, kernel.Data{
+			Data:     kernel.MIMEMap{string(protocol.MIMETextHTML): htmlReport},
+			Metadata: nbErr.diagnosticsMetadata(),
+		})
 		if err != nil {
 			klog.Errorf("Failed to publish data in DisplayErrorWithContext: %+v", err)
 		}
@@ -126,11 +156,12 @@ func (nbErr *GonbError) PublishWithHTML(msg kernel.Message) {
 // protocol uses for it.
 //
 // It special cases the GonbError, where it adds each sub-error in the "traceback" repeated field.
+// The suggestions for known failures (see ErrorHints) are appended to the traceback.
 func JupyterErrorSplit(err error) (string, string, []string) {
 	var nbErr *GonbError
 	if errors.As(err, &nbErr) {
 		return nbErr.Name(), nbErr.Error(), nbErr.Traceback()
 	} else {
-		return "ERROR", err.Error(), []string{err.Error()}
+		return "ERROR", err.Error(), append([]string{err.Error()}, hintsTraceback(MatchErrorHints(err.Error()))...)
 	}
 }
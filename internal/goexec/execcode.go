@@ -6,6 +6,7 @@ import (
 	. "github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/internal/jpyexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/metrics"
 	"github.com/pkg/errors"
 	"golang.org/x/exp/slices"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // cellExecParams are the parameters of ExecuteCell, packaged so they
@@ -59,8 +61,9 @@ func (s *State) serializeExecuteCell() {
 		select {
 		case params := <-s.cellExecChan:
 			// Received new execution request.
-			params.done.Trigger(s.executeCellImpl(
-				params.msg, params.cellId, params.lines, params.skipLines))
+			err := s.executeCellImpl(params.msg, params.cellId, params.lines, params.skipLines)
+			s.recordCellExecution(err)
+			params.done.Trigger(err)
 
 		case <-stopC:
 			// Kernel stopped, exit.
@@ -81,6 +84,12 @@ func (s *State) executeCellImpl(msg kernel.Message, cellId int, lines []string,
 	if s.CellIsTest && s.CellIsWasm {
 		return errors.Errorf("Cannot execute test in a %%wasm cell. Please, choose either `%%wasm` or `%%test`.")
 	}
+	if s.CellProfile != "" && s.CellIsWasm {
+		return errors.Errorf("Cannot profile a %%wasm cell. Please, choose either `%%wasm` or `%%prof`.")
+	}
+	if s.CellCache && s.CellIsWasm {
+		return errors.Errorf("Cannot cache a %%wasm cell. Please, choose either `%%wasm` or `%%cache`.")
+	}
 
 	// Runs AutoTrack: makes sure redirects in go.mod and use clauses in go.work are tracked.
 	err := s.AutoTrack()
@@ -97,6 +106,31 @@ func (s *State) executeCellImpl(msg kernel.Message, cellId int, lines []string,
 	}
 	klog.V(2).Infof("ExecuteCell: after s.parseLinesAndComposeMain()")
 
+	// With `%cache`, replay the outputs of a previous execution with the same fingerprint, if there is one.
+	var cacheFingerprint string
+	if s.CellCache {
+		cacheFingerprint, err = s.cellCacheFingerprint(updatedDecls, mainDecl)
+		if err != nil {
+			return errors.WithMessagef(err, "%%cache failed to fingerprint cell")
+		}
+		found, err := replayCachedCell(msg, cacheFingerprint)
+		if err != nil {
+			return errors.WithMessagef(err, "%%cache failed to replay cell")
+		}
+		s.recordUsage(func(u *UsageStats) {
+			if found {
+				u.CellCacheHits++
+			} else {
+				u.CellCacheMisses++
+			}
+		})
+		if found {
+			// The cell executed successfully before, so its declarations are committed.
+			s.Definitions = updatedDecls
+			return nil
+		}
+	}
+
 	// ProgramExecutor `goimports` (or the code that implements it) -- it updates `updatedDecls` with
 	// the new imports, if there are any.
 	_, fileToCellIdAndLine, err = s.GoImports(msg, updatedDecls, mainDecl, fileToCellIdAndLine)
@@ -109,17 +143,36 @@ func (s *State) executeCellImpl(msg kernel.Message, cellId int, lines []string,
 	}
 
 	// And then compile it.
+	if err := s.writeProfileHelper(); err != nil {
+		return err
+	}
+	if err := s.writeCoverTestWrappers(); err != nil {
+		return err
+	}
 	if err := s.Compile(msg, fileToCellIdAndLine); err != nil {
 		klog.Infof("goexec.ExecuteCell() failed to compile cell: %+v", err)
 		return err
 	}
 
 	klog.V(2).Infof("ExecuteCell: after s.Compile()")
+	if err := s.recordSourceMap(cellId, fileToCellIdAndLine); err != nil {
+		klog.Warningf("Failed to save source map: %+v", err)
+	}
 
 	// Compilation successful: save merged declarations into current State.
 	s.Definitions = updatedDecls
 
+	// With `%export`, write the compiled code as a standalone Go module.
+	if s.CellExport != nil {
+		if err := s.Export(msg, s.CellExport, updatedDecls, mainDecl); err != nil {
+			return err
+		}
+	}
+
 	// Execute compiled code.
+	if s.CellCache {
+		return s.executeAndCache(msg, cacheFingerprint, fileToCellIdAndLine)
+	}
 	return s.Execute(msg, fileToCellIdAndLine)
 }
 
@@ -132,12 +185,22 @@ func (s *State) PostExecuteCell() {
 		s.RemoveWasmConstants(s.Definitions)
 	}
 
+	if s.CellProfile != "" {
+		s.removeProfileHelper()
+	}
+
 	s.Args = nil
 	s.CellIsTest = false
 	s.CellTests = nil
 	s.CellHasBenchmarks = false
+	s.CellTestReport = nil
 	s.CellIsWasm = false
+	s.WasmComms = false
 	s.WasmDivId = ""
+	s.CellProfile = ""
+	s.CellGoFlags = nil
+	s.CellCache = false
+	s.CellExport = nil
 }
 
 // BinaryPath is the path to the generated binary file.
@@ -151,9 +214,12 @@ const (
 )
 
 // CodePath is the path to where the code is going to be saved. Either `main.go` or `main_test.go` file.
+//
+// Tests compiled with coverage are saved to `main.go`, since `_test.go` files are not instrumented, see
+// writeCoverTestWrappers.
 func (s *State) CodePath() string {
 	name := MainGo
-	if s.CellIsTest {
+	if s.CellIsTest && !s.coverTests() {
 		name = MainTestGo
 	}
 	return path.Join(s.TempDir, name)
@@ -182,18 +248,42 @@ func (s *State) Execute(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine)
 	if s.CellIsWasm {
 		return s.ExecuteWasm(msg)
 	}
+	if s.CellTestReport != nil {
+		return s.executeTestReport(msg, fileToCellIdAndLine)
+	}
 	args := s.Args
 	if len(args) == 0 && s.CellIsTest {
 		args = s.DefaultCellTestArgs()
 	}
+	if s.CellProfile != "" && s.CellIsTest {
+		args = append(slices.Clip(args), s.profileTestArgs()...)
+	}
+	start := time.Now()
 	err := jpyexec.New(msg, s.BinaryPath(), args...).
 		UseNamedPipes(s.Comms).
 		ExecutionCount(msg.Kernel().ExecCounter).
-		WithStderr(newJupyterStackTraceMapperWriter(msg, "stderr", s.CodePath(), fileToCellIdAndLine)).
+		WithStderr(s.newPanicReportWriter(msg, fileToCellIdAndLine)).
+		WithPriority(s.Priority).
+		WithRunnerPool(s.runnerPool).
+		WithOutputEncoding(s.OutputEncoding).
+		WithInterruptGrace(s.InterruptGrace).
+		WithInputTimeout(s.InputTimeout).
+		WithWatchdog(s.Watchdog).
+		WithSecrets(s.Secrets).
 		Exec()
+	metrics.ObserveSince(metrics.RunSeconds, start)
 	if err != nil {
 		klog.Infof("goexec.Execute(): failed to run the compiled cell: %+v", msg)
 	}
+	if s.CellProfile != "" && !msg.Kernel().Interrupted.Load() {
+		if profErr := s.PublishProfile(msg); profErr != nil {
+			if err == nil {
+				err = errors.WithMessagef(profErr, "%%prof %s", s.CellProfile)
+			} else {
+				klog.Warningf("%%prof %s failed: %+v", s.CellProfile, profErr)
+			}
+		}
+	}
 	return err
 }
 
@@ -203,14 +293,37 @@ func (s *State) Execute(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine)
 // current cell.
 func (s *State) Compile(msg kernel.Message, fileToCellIdAndLines []CellIdAndLine) error {
 	var args []string
+	outputPath := s.BinaryPath()
 	if s.CellIsTest {
-		args = []string{"test", "-c", "-o", s.BinaryPath()}
+		args = []string{"test", "-c", "-o", outputPath}
 	} else if s.CellIsWasm {
-		args = []string{"build", "-o", path.Join(s.WasmDir, CompiledWasmName)}
+		outputPath = path.Join(s.WasmDir, CompiledWasmName)
+		args = []string{"build", "-o", outputPath}
 	} else {
-		args = []string{"build", "-o", s.BinaryPath()}
+		args = []string{"build", "-o", outputPath}
+	}
+	if !s.CellIsTest && goBuildSupportsJSON() {
+		// Structured output, converted back to text (see buildOutputFromJSON) to parse the diagnostics.
+		args = append(args, "-json")
 	}
 	args = append(args, s.GoBuildFlags...)
+	args = append(args, s.CellGoFlags...)
+	if s.coverTests() {
+		args = append(args, "-cover")
+	}
+	args = appendLdflags(args, s.cellInfoLdflags())
+
+	// Skip the build if nothing changed since the last one.
+	fingerprint, err := s.buildFingerprint(args, outputPath)
+	if err != nil {
+		logBuildCacheError("go build", err)
+	} else if fingerprint != "" && fingerprint == s.buildCache.build {
+		klog.V(1).Infof("goexec.Compile(): nothing changed since last build, reusing %q", outputPath)
+		s.recordUsage(func(u *UsageStats) { u.BuildsReused++ })
+		return nil
+	}
+	s.buildCache.build = ""
+
 	cmd := exec.Command("go", args...)
 	cmd.Dir = s.TempDir
 	if s.CellIsWasm {
@@ -227,12 +340,28 @@ func (s *State) Compile(msg kernel.Message, fileToCellIdAndLines []CellIdAndLine
 
 	var output []byte
 	klog.V(2).Infof("Executing %s", cmd)
-	output, err := cmd.CombinedOutput()
+	start := time.Now()
+	output, err = runWithWatchdog(msg, GoBuildTimeout, cmd)
+	elapsed := time.Since(start)
+	if slices.Contains(args, "-json") {
+		output = buildOutputFromJSON(output)
+	}
+	s.recordUsage(func(u *UsageStats) {
+		u.Builds++
+		u.BuildTime += elapsed
+	})
+	metrics.CompileSeconds.Observe(elapsed.Seconds())
 	if err != nil {
 		klog.Errorf("Failed %q:\n%s\n", cmd, output)
 		err := s.DisplayErrorWithContext(msg, fileToCellIdAndLines, string(output), err)
 		return errors.Wrapf(err, "failed to run %q", cmd)
 	}
+	klog.V(1).Infof("goexec.Compile(): %q took %s", cmd, elapsed)
+	if fingerprint, err = s.buildFingerprint(args, outputPath); err != nil {
+		logBuildCacheError("go build", err)
+	} else {
+		s.buildCache.build = fingerprint
+	}
 	return nil
 }
 
@@ -260,7 +389,7 @@ can install it from the notebook with:
 	cmd.Dir = s.TempDir
 	var output []byte
 	klog.V(2).Infof("Executing %s", cmd)
-	output, err = cmd.CombinedOutput()
+	output, err = runWithWatchdog(msg, GoImportsTimeout, cmd)
 	if err != nil {
 		err = s.DisplayErrorWithContext(msg, fileToCellIdAndLine, string(output)+"\n"+err.Error(), err)
 		err = errors.Wrapf(err, "failed to run %q", cmd.String())
@@ -305,6 +434,16 @@ can install it from the notebook with:
 		return
 	}
 
+	// Skip `go get` if imports and modules didn't change since the last one.
+	fingerprint, fpErr := s.goGetFingerprint(newDecls)
+	if fpErr != nil {
+		logBuildCacheError("go get", fpErr)
+	} else if fingerprint == s.buildCache.goGet {
+		klog.V(1).Infof("goexec.GoImports(): imports and modules unchanged, skipping `go get`")
+		return
+	}
+	s.buildCache.goGet = ""
+
 	args := []string{"get"}
 	if s.CellIsTest {
 		args = append(args, "-t")
@@ -312,7 +451,8 @@ can install it from the notebook with:
 	cmd = exec.Command("go", args...)
 	cmd.Dir = s.TempDir
 	klog.V(2).Infof("Executing %s", cmd)
-	output, err = cmd.CombinedOutput()
+	start := time.Now()
+	output, err = runWithWatchdog(msg, GoGetTimeout, cmd)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to run %q", cmd.String())
 		strOutput := fmt.Sprintf("%v\n\n%s", err, output)
@@ -320,6 +460,13 @@ can install it from the notebook with:
 		err = s.DisplayErrorWithContext(msg, fileToCellIdAndLine, strOutput, err)
 		return
 	}
+	klog.V(1).Infof("goexec.GoImports(): %q took %s", cmd, time.Since(start))
+	// Fingerprint after `go get`, since it may update go.mod and go.sum.
+	if fingerprint, fpErr = s.goGetFingerprint(newDecls); fpErr != nil {
+		logBuildCacheError("go get", fpErr)
+	} else {
+		s.buildCache.goGet = fingerprint
+	}
 	return
 }
 
@@ -354,7 +501,20 @@ func (w *jupyterStackTraceMapperWriter) Write(p []byte) (int, error) {
 	if w.regexpMainPath == nil {
 		return w.jupyterWriter.Write(p)
 	}
-	p = w.regexpMainPath.ReplaceAllFunc(p, func(match []byte) []byte {
+	_, err := w.jupyterWriter.Write(w.mapLines(p))
+	if err != nil {
+		return 0, err
+	}
+	// Return the original number of bytes: since we change what is written, we actually write more bytes.
+	return n, nil
+}
+
+// mapLines maps references to the `main.go` file to their corresponding Lines in cells.
+func (w *jupyterStackTraceMapperWriter) mapLines(p []byte) []byte {
+	if w.regexpMainPath == nil {
+		return p
+	}
+	return w.regexpMainPath.ReplaceAllFunc(p, func(match []byte) []byte {
 		klog.V(2).Infof("\tFiltering stderr: %s", match)
 		lineNumStr := strings.Split(string(match), ":")[1]
 		lineNum, err := strconv.Atoi(lineNumStr)
@@ -380,12 +540,6 @@ func (w *jupyterStackTraceMapperWriter) Write(p []byte) (int, error) {
 		res := bytes.Join([][]byte{cellText, match}, nil)
 		return res
 	})
-	_, err := w.jupyterWriter.Write(p)
-	if err != nil {
-		return 0, err
-	}
-	// Return the original number of bytes: since we change what is written, we actually write more bytes.
-	return n, nil
 }
 
 const (
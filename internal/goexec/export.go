@@ -0,0 +1,215 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"go/format"
+	"golang.org/x/mod/modfile"
+	"k8s.io/klog/v2"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// This file implements `%export`: it assembles the memorized declarations, plus the current cell, into a
+// standalone Go module (a program or a package) in a directory, so code prototyped in the notebook can
+// graduate to a real repository.
+
+// ExportOptions configure `%export`, see State.CellExport.
+type ExportOptions struct {
+	// Dir where to write the module.
+	Dir string
+
+	// Package name: if set, a package (as opposed to a program) is exported, and `func main()` is dropped.
+	Package string
+
+	// Module path in the exported `go.mod`. If empty, it defaults to the base name of Dir.
+	Module string
+
+	// Cells adds a comment before each declaration with the notebook cell where it was defined.
+	Cells bool
+
+	// Force overwriting the files if they already exist.
+	Force bool
+}
+
+// regexpPackageClause matches the package clause of the generated code.
+var regexpPackageClause = regexp.MustCompile(`(?m)^package main$`)
+
+// Export writes the declarations, and the cell's `func main()` (if `mainDecl` is not nil and it's not exporting
+// a package), to the Go module in `opts.Dir`, along with a `go.mod` (and `go.sum`) with the requirements of the
+// notebook, and other Go files created by the user (e.g. with `%%writefile`).
+func (s *State) Export(msg kernel.Message, opts *ExportOptions, decls *Declarations, mainDecl *Function) error {
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "invalid directory %q for %%export", opts.Dir)
+	}
+	fileName := MainGo
+	if opts.Package != "" {
+		mainDecl = nil
+		fileName = opts.Package + ".go"
+	} else if mainDecl == nil {
+		return errors.Errorf("%%export of a program requires a `func main()` (or `%%%%`) in the cell, " +
+			"or use `%%export --package=<name> <dir>` to export a package")
+	}
+	moduleName := opts.Module
+	if moduleName == "" {
+		moduleName = path.Base(dir)
+	}
+
+	// Render the code, with the profiling hooks disabled.
+	decls = decls.Copy()
+	delete(decls.Functions, "main")
+	var buf bytes.Buffer
+	cellProfile := s.CellProfile
+	s.CellProfile = ""
+	_, fileToCellIdAndLine, err := s.createCodeFromDecls(&buf, decls, mainDecl)
+	s.CellProfile = cellProfile
+	if err != nil {
+		return errors.WithMessagef(err, "rendering declarations for %%export")
+	}
+	code := buf.String()
+	if opts.Cells {
+		code = annotateCells(code, fileToCellIdAndLine)
+	}
+	if opts.Package != "" {
+		code = regexpPackageClause.ReplaceAllLiteralString(code, "package "+opts.Package)
+	}
+	code = "// Code exported from a GoNB notebook with %export.\n\n" + code
+
+	// Write files.
+	files := map[string][]byte{fileName: []byte(code)}
+	goMod, err := s.exportGoMod(moduleName)
+	if err != nil {
+		return err
+	}
+	files["go.mod"] = goMod
+	if contents, err := os.ReadFile(path.Join(s.TempDir, "go.sum")); err == nil {
+		files["go.sum"] = contents
+	}
+	userFiles, err := s.sourceFiles()
+	if err != nil {
+		return err
+	}
+	for _, filePath := range userFiles {
+		relPath, _ := filepath.Rel(s.TempDir, filePath)
+		if !strings.HasSuffix(relPath, ".go") || relPath == MainGo || relPath == MainTestGo ||
+			relPath == ProfileHelperGo || filePath == s.AlternativeDefinitionsPath() {
+			continue
+		}
+		contents, err := os.ReadFile(filePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %q", filePath)
+		}
+		if opts.Package != "" {
+			contents = regexpPackageClause.ReplaceAllLiteral(contents, []byte("package "+opts.Package))
+		}
+		files[relPath] = contents
+	}
+	if !opts.Force {
+		for name := range files {
+			if _, err := os.Stat(path.Join(dir, name)); err == nil {
+				return errors.Errorf("%%export: %q already exists, use `%%export --force` to overwrite it", path.Join(dir, name))
+			}
+		}
+	}
+	for name, contents := range files {
+		filePath := path.Join(dir, name)
+		if err = os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+			return errors.Wrapf(err, "failed to create directory for %q", filePath)
+		}
+		if err = os.WriteFile(filePath, contents, 0644); err != nil {
+			return errors.Wrapf(err, "failed to write %q", filePath)
+		}
+	}
+
+	// Prune unused imports and format.
+	codePath := path.Join(dir, fileName)
+	if err = formatExportedCode(msg, codePath); err != nil {
+		return err
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+		fmt.Sprintf("* Exported module %q to %q.\n", moduleName, dir))
+}
+
+// annotateCells inserts a comment with the notebook cell before each top-level declaration.
+func annotateCells(code string, fileToCellIdAndLine []CellIdAndLine) string {
+	lines := strings.Split(code, "\n")
+	var annotated []string
+	lastCellId := NoCursorLine
+	for ii, line := range lines {
+		if ii < len(fileToCellIdAndLine) {
+			cellId := fileToCellIdAndLine[ii].Id
+			topLevel := line != "" && line[0] != ' ' && line[0] != '\t' && line[0] != ')' && line[0] != '}'
+			if cellId != NoCursorLine && cellId != lastCellId && topLevel {
+				if cellId >= 0 {
+					annotated = append(annotated, fmt.Sprintf("// From notebook cell [%d].", cellId))
+				}
+				lastCellId = cellId
+			}
+		}
+		annotated = append(annotated, line)
+	}
+	return strings.Join(annotated, "\n")
+}
+
+// exportGoMod returns the contents of the notebook's `go.mod`, with the new module name, and local
+// replace rules converted to absolute paths.
+func (s *State) exportGoMod(moduleName string) ([]byte, error) {
+	goModPath := path.Join(s.TempDir, "go.mod")
+	contents, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", goModPath)
+	}
+	goMod, err := modfile.Parse(goModPath, contents, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %q", goModPath)
+	}
+	if err = goMod.AddModuleStmt(moduleName); err != nil {
+		return nil, errors.Wrapf(err, "failed to set module name %q", moduleName)
+	}
+	for _, replace := range goMod.Replace {
+		if replace.New.Version != "" || filepath.IsAbs(replace.New.Path) {
+			continue
+		}
+		newPath := filepath.Join(s.TempDir, replace.New.Path)
+		if err = goMod.AddReplace(replace.Old.Path, replace.Old.Version, newPath, ""); err != nil {
+			return nil, errors.Wrapf(err, "failed to update replace rule for %q", replace.Old.Path)
+		}
+	}
+	goMod.Cleanup()
+	formatted, err := goMod.Format()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to format go.mod")
+	}
+	return formatted, nil
+}
+
+// formatExportedCode runs `goimports` on the exported file, removing unused imports. If `goimports` is not
+// installed, the file is only formatted.
+func formatExportedCode(msg kernel.Message, filePath string) error {
+	if goimportsPath, err := exec.LookPath("goimports"); err == nil {
+		cmd := exec.Command(goimportsPath, "-w", filePath)
+		cmd.Dir = path.Dir(filePath)
+		output, err := runWithWatchdog(msg, GoImportsTimeout, cmd)
+		if err != nil {
+			return errors.Wrapf(err, "failed to run %q on exported code: %s", cmd, output)
+		}
+		return nil
+	}
+	klog.Warningf("goimports not found, exported code %q is formatted, but unused imports are not pruned", filePath)
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %q", filePath)
+	}
+	formatted, err := format.Source(contents)
+	if err != nil {
+		return errors.Wrapf(err, "failed to format exported code %q", filePath)
+	}
+	return os.WriteFile(filePath, formatted, 0644)
+}
@@ -0,0 +1,56 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s.Stop(), "Failed to finalized state")
+	}()
+	s.Definitions.Imports["fmt"] = &Import{Key: "fmt", Path: "fmt", CellLines: CellLines{Id: 1, Lines: []int{0}}}
+	s.Definitions.Functions["f"] = &Function{Key: "f", Name: "f", Definition: "func f() int { return 1 }",
+		CellLines: CellLines{Id: 2, Lines: []int{0}}}
+	mainDecl := &Function{Key: "main", Name: "main", Definition: "func main() {\n\tfmt.Println(f())\n}",
+		CellLines: CellLines{Id: 3, Lines: []int{0, 1, 2}}}
+
+	// Program.
+	dir := path.Join(t.TempDir(), "myprog")
+	require.NoError(t, s.Export(nil, &ExportOptions{Dir: dir, Cells: true}, s.Definitions, mainDecl))
+	code, err := os.ReadFile(path.Join(dir, MainGo))
+	require.NoError(t, err)
+	assert.Contains(t, string(code), "package main\n")
+	assert.Contains(t, string(code), "// From notebook cell [2].\nfunc f() int { return 1 }")
+	assert.Contains(t, string(code), "// From notebook cell [3].\nfunc main() {")
+	goMod, err := os.ReadFile(path.Join(dir, "go.mod"))
+	require.NoError(t, err)
+	assert.Contains(t, string(goMod), "module myprog\n")
+
+	// Existing files are not overwritten, unless forced.
+	require.Error(t, s.Export(nil, &ExportOptions{Dir: dir}, s.Definitions, mainDecl))
+	require.NoError(t, s.Export(nil, &ExportOptions{Dir: dir, Force: true}, s.Definitions, mainDecl))
+	code, err = os.ReadFile(path.Join(dir, MainGo))
+	require.NoError(t, err)
+	assert.NotContains(t, string(code), "From notebook cell")
+
+	// A program requires a `func main()`.
+	require.Error(t, s.Export(nil, &ExportOptions{Dir: t.TempDir()}, s.Definitions, nil))
+
+	// Package: `func main()` is dropped.
+	dir = t.TempDir()
+	opts := &ExportOptions{Dir: dir, Package: "mylib", Module: "example.com/mylib"}
+	require.NoError(t, s.Export(nil, opts, s.Definitions, mainDecl))
+	code, err = os.ReadFile(path.Join(dir, "mylib.go"))
+	require.NoError(t, err)
+	assert.Contains(t, string(code), "package mylib\n")
+	assert.Contains(t, string(code), "func f() int { return 1 }")
+	assert.NotContains(t, string(code), "func main()")
+	goMod, err = os.ReadFile(path.Join(dir, "go.mod"))
+	require.NoError(t, err)
+	assert.Contains(t, string(goMod), "module example.com/mylib\n")
+}
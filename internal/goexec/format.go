@@ -0,0 +1,127 @@
+package goexec
+
+import (
+	"fmt"
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"go/format"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This file implements the formatting of the cells: the Go code is formatted with `gofmt` (imports included,
+// they are sorted), while the special commands and shell lines are preserved verbatim.
+//
+// Missing imports are not added to the cell: they are added to the generated code, when the cell is executed.
+
+// formatMarker prefixes the placeholder comments for the cell lines that are not Go code, followed by the
+// cell line number.
+const formatMarker = "//gonb:format:"
+
+var (
+	regexpFormatMarker     = regexp.MustCompile(`^\s*` + formatMarker + `(\d+)$`)
+	regexpFormatMainMarker = regexp.MustCompile(`^func main\(\) \{ ` + formatMarker + `(\d+)$`)
+)
+
+// FormatCell formats the Go code in the cell lines, as `gofmt` would.
+//
+// The lines in skipLines (special commands and shell lines) are preserved as is, and the code after `%%` (or
+// `%main`) is formatted as the body of the `main` function, without the extra indentation.
+//
+// It returns an error if the Go code can't be parsed. Cells without Go code are returned unchanged.
+func FormatCell(lines []string, skipLines Set[int]) (formatted []string, err error) {
+	if IsEmptyLines(lines, skipLines) {
+		return lines, nil
+	}
+
+	// Create a Go file with placeholder comments for the lines that are not Go.
+	var buf strings.Builder
+	buf.WriteString("package main\n\n")
+	var hasMain bool
+	for ii, line := range lines {
+		switch {
+		case !hasMain && (strings.HasPrefix(line, "%main") || strings.HasPrefix(line, "%%")):
+			_, _ = fmt.Fprintf(&buf, "func main() { %s%d\n", formatMarker, ii)
+			hasMain = true
+		case skipLines.Has(ii):
+			_, _ = fmt.Fprintf(&buf, "%s%d\n", formatMarker, ii)
+		default:
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+	}
+	if hasMain {
+		buf.WriteString("}\n")
+	}
+	src := []byte(buf.String())
+	src, err = format.Source(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "formatting cell")
+	}
+
+	// Convert back to cell lines.
+	verbatim := multiLineLiterals(src)
+	fileLines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	formatted = make([]string, 0, len(lines))
+	var inMain bool
+	for ii, line := range fileLines {
+		if ii == 0 || (ii == 1 && line == "") {
+			// Skip `package main` line.
+			continue
+		}
+		if verbatim.Has(ii + 1) {
+			formatted = append(formatted, line)
+			continue
+		}
+		if matches := regexpFormatMainMarker.FindStringSubmatch(line); matches != nil {
+			cellLine, _ := strconv.Atoi(matches[1])
+			formatted = append(formatted, lines[cellLine])
+			inMain = true
+			continue
+		}
+		if matches := regexpFormatMarker.FindStringSubmatch(line); matches != nil {
+			cellLine, _ := strconv.Atoi(matches[1])
+			formatted = append(formatted, lines[cellLine])
+			continue
+		}
+		if inMain {
+			if ii == len(fileLines)-1 && line == "}" {
+				// Closing of `func main()`.
+				continue
+			}
+			line = strings.TrimPrefix(line, "\t")
+		}
+		formatted = append(formatted, line)
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == "" && (len(formatted) == 0 || formatted[len(formatted)-1] != "") {
+		// Preserve final new line.
+		formatted = append(formatted, "")
+	}
+	return formatted, nil
+}
+
+// multiLineLiterals returns the line numbers (starting at 1) of the contents of multi-line raw
+// strings and block comments, except their first line: these are not changed when converting back to cell lines.
+func multiLineLiterals(src []byte) Set[int] {
+	lines := MakeSet[int]()
+	fileSet := token.NewFileSet()
+	file := fileSet.AddFile("", fileSet.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if (tok == token.STRING && strings.HasPrefix(lit, "`")) || (tok == token.COMMENT && strings.HasPrefix(lit, "/*")) {
+			startLine := fileSet.Position(pos).Line
+			for ii := 1; ii <= strings.Count(lit, "\n"); ii++ {
+				lines.Insert(startLine + ii)
+			}
+		}
+	}
+	return lines
+}
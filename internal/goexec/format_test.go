@@ -0,0 +1,70 @@
+package goexec
+
+import (
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestFormatCell(t *testing.T) {
+	cell := `import (
+"strings"
+  "fmt"
+)
+!echo hello
+func f(x int)int{
+return x*2
+}
+
+%% --n=3
+x:=f(1)
+  if x>1 {
+fmt.Println(strings.ToUpper("ok"))
+}
+s := ` + "`" + `raw
+  string` + "`" + `
+_ = s
+%env A B`
+	lines := strings.Split(cell, "\n")
+	skipLines := SetWithValues(4, 9, 17)
+	formatted, err := FormatCell(lines, skipLines)
+	require.NoError(t, err)
+	want := `import (
+	"fmt"
+	"strings"
+)
+
+!echo hello
+func f(x int) int {
+	return x * 2
+}
+
+%% --n=3
+x := f(1)
+if x > 1 {
+	fmt.Println(strings.ToUpper("ok"))
+}
+s := ` + "`" + `raw
+  string` + "`" + `
+_ = s
+%env A B`
+	assert.Equal(t, want, strings.Join(formatted, "\n"))
+
+	// Formatting is idempotent.
+	lines = strings.Split(want, "\n")
+	formatted, err = FormatCell(lines, SetWithValues(5, 10, 18))
+	require.NoError(t, err)
+	assert.Equal(t, lines, formatted)
+
+	// Cells without Go code are unchanged.
+	lines = []string{"!ls  -l", "%env  A B"}
+	formatted, err = FormatCell(lines, SetWithValues(0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, lines, formatted)
+
+	// Syntax error.
+	_, err = FormatCell([]string{"func f( {"}, MakeSet[int]())
+	require.Error(t, err)
+}
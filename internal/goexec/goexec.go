@@ -10,13 +10,18 @@ import (
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/internal/comms"
 	"github.com/janpfeifer/gonb/internal/goexec/goplsclient"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/logs"
+	"github.com/janpfeifer/gonb/internal/resources"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -44,10 +49,52 @@ type State struct {
 	// Temporary directory where Go program is build at each execution.
 	UniqueID, Package, TempDir string
 
+	// Executing is set while an "execute_request" is being handled by the dispatcher.
+	Executing atomic.Bool
+
 	// Building and executing go code configuration:
 	Args         []string // Args to be passed to the program, after being executed.
 	GoBuildFlags []string // Flags to be passed to `go build`, in State.Compile.
+	CellGoFlags  []string // Extra flags to be passed to `go build` only for the current cell, set with `%with_goflags`.
 	AutoGet      bool     // Whether to do a "go get" before compiling, to fetch missing external modules.
+	AutoFormat   bool     // Whether to format the Go code of the cells (see FormatCell) before executing them.
+
+	// DisabledCommands are the special commands (without the "%", e.g.: "cd") refused in this session. It is
+	// used by front-ends where sessions share the process (see httpapi), for the commands that change the
+	// environment or the current directory of the process.
+	DisabledCommands common.Set[string]
+
+	// Priority (CPU and I/O) of the programs executed, set with `%limits`.
+	Priority jpyexec.Priority
+
+	// OutputEncoding of the programs executed, set with `%config output.encoding=<name>`.
+	// If empty, the encoding of the locale is used, see jpyexec.LocaleEncoding.
+	OutputEncoding string
+
+	// InterruptGrace is how long an interrupted program has to exit, before it is killed, set with
+	// `%config interrupt.grace=<duration>`. If 0, jpyexec.WaitToKill is used.
+	InterruptGrace time.Duration
+
+	// InputTimeout is how long the input prompts of the programs wait for an answer, set with
+	// `%config input.timeout=<duration>`. If 0, they don't time out. See jpyexec.Executor.WithInputTimeout.
+	InputTimeout time.Duration
+
+	// Watchdog is how long a program can run without output before a notice (with an interrupt button) is
+	// displayed, set with `%config watchdog=<duration>`. If 0, jpyexec.WatchdogThreshold is used; if negative,
+	// it is disabled. See jpyexec.Executor.WithWatchdog.
+	Watchdog time.Duration
+
+	// tempDirResource registers TempDir in the resources registry, if it is not preserved.
+	tempDirResource *resources.Resource
+
+	// runnerPool of pre-started processes used to execute the programs, set with `%runners`. It may be nil.
+	runnerPool *jpyexec.RunnerPool
+
+	// SessionEnv holds the environment variables set with `%env`, saved in the session snapshots.
+	SessionEnv map[string]string
+
+	// notebookEnv is the environment persisted for the notebook, see notebookenv.go.
+	notebookEnv notebookEnvState
 
 	// Global elements defined mapped by their keys.
 	Definitions *Declarations
@@ -71,6 +118,18 @@ type State struct {
 	// This is set by State.autoTrackGoWork.
 	goWorkUsePaths common.Set[string]
 
+	// snapshots of the session, for crash recovery.
+	snapshots *snapshotState
+
+	// usageStats not yet saved, displayed with `%stats`.
+	usageStats *usageStatsState
+
+	// sourceMaps of the latest executions, see MapSourcePosition.
+	sourceMaps *sourceMapsState
+
+	// buildCache holds the fingerprints of the last `go get` and `go build`, to skip them if nothing changed.
+	buildCache buildCache
+
 	// preserveTempDir indicates the temporary directory should be logged and
 	// preserved for debugging.
 	preserveTempDir bool
@@ -89,12 +148,41 @@ type State struct {
 	CellTests         []string // Tests defined in this cell. Only used if CellIsTest==true.
 	CellHasBenchmarks bool
 
+	// CellTestReport, set by `%bench` or by `%test` with `go test` style flags (e.g. `-run`), renders the results
+	// of the tests and benchmarks of a CellIsTest cell as rich output, instead of the raw output (see testreport.go).
+	CellTestReport *TestReport
+
+	// CellProfile is the type of profile (see ProfileTypes) to collect while executing the current cell, set
+	// with `%prof`. It is empty if the cell is not being profiled.
+	CellProfile string
+
+	// CellCache indicates the outputs of the current cell should be memoized, set with `%cache`: if a previous
+	// execution with the same fingerprint is found, its outputs are replayed instead (see cellcache.go).
+	CellCache bool
+
+	// CellExport, if set with `%export`, makes the current cell (with the memorized declarations) be exported
+	// to a standalone Go module, once it compiles (see export.go).
+	CellExport *ExportOptions
+
 	// CellIsWasm indicates whether the current cell is to be compiled for WebAssembly (wasm).
 	CellIsWasm                  bool
 	WasmDir, WasmUrl, WasmDivId string
 
+	// WasmComms is set with `%wasm --comms`: the compiled wasm is sent through the websocket, instead of
+	// being served by Jupyter.
+	WasmComms bool
+
+	// wasm keeps track of the `%wasm` cells, to relay their output.
+	wasm *wasmState
+
 	// Comms represents the communication with the front-end.
 	Comms *comms.State
+
+	// Secrets entered in the session, see `gonbui.RequestSecret`.
+	Secrets *Secrets
+
+	// VariableInspector tracks the front-ends inspecting the memorized declarations (see InspectVariables).
+	VariableInspector *comms.VariableInspector
 }
 
 // Declarations is a collection of declarations that we carry over from one cell to another.
@@ -118,18 +206,35 @@ type Declarations struct {
 // goroutines, that stop when the kernel stops.
 func New(k *kernel.Kernel, uniqueID string, preserveTempDir, rawError bool) (*State, error) {
 	s := &State{
-		Kernel:          k,
-		UniqueID:        uniqueID,
-		Package:         "gonb_" + uniqueID,
-		Definitions:     NewDeclarations(),
-		AutoGet:         true,
-		trackingInfo:    newTrackingInfo(),
-		preserveTempDir: preserveTempDir,
-		rawError:        rawError,
-		Comms:           comms.New(),
-		cellExecChan:    make(chan *cellExecParams),
+		Kernel:            k,
+		UniqueID:          uniqueID,
+		Package:           "gonb_" + uniqueID,
+		Definitions:       NewDeclarations(),
+		AutoGet:           true,
+		AutoFormat:        true,
+		trackingInfo:      newTrackingInfo(),
+		preserveTempDir:   preserveTempDir,
+		rawError:          rawError,
+		Comms:             comms.New(),
+		VariableInspector: comms.NewVariableInspector(),
+		Secrets:           &Secrets{},
+		snapshots:         &snapshotState{},
+		usageStats:        &usageStatsState{},
+		sourceMaps:        &sourceMapsState{},
+		wasm:              &wasmState{},
+		cellExecChan:      make(chan *cellExecParams),
 	}
 
+	// Output of `%wasm` programs running in the front-end.
+	s.Comms.HandleAddress(protocol.GonbuiWasmOutputAddress, s.handleWasmOutput)
+	s.Comms.HandleAddress(logs.JsAddress, logs.Default.HandleJsRecord)
+	s.Comms.HandleAddress(protocol.GonbuiStdinCloseAddress, func(any) { s.Comms.CloseProgramStdin() })
+	s.Comms.HandleAddress(jpyexec.InterruptAddress, func(any) {
+		if s.Kernel != nil {
+			s.Kernel.CallInterruptSubscribers()
+		}
+	})
+
 	// Goroutine that processes incoming ExecuteCell requests.
 	// It stops when the kernel stops.
 	go s.serializeExecuteCell()
@@ -142,6 +247,8 @@ func New(k *kernel.Kernel, uniqueID string, preserveTempDir, rawError bool) (*St
 	}
 	if s.preserveTempDir {
 		klog.Infof("Temporary work directory: %s", s.TempDir)
+	} else {
+		s.tempDirResource = resources.RegisterPath(resources.TempDir, "goexec", s.TempDir)
 	}
 
 	// Set environment variables with currently used GoNB directories.
@@ -189,7 +296,7 @@ with:
 	// Try to find out Jupyter root's directory.
 	jupyterRoot, err := JupyterRootDirectory()
 	if err != nil {
-		klog.Warningf("Could not find Jupyter root directory, %%wasm will not work: %v", err)
+		klog.Warningf("Could not find Jupyter root directory, %%wasm programs will be sent through the websocket: %v", err)
 	} else {
 		err = os.Setenv(protocol.GONB_JUPYTER_ROOT_ENV, jupyterRoot)
 		if err != nil {
@@ -204,6 +311,7 @@ with:
 
 // GoModInit removes current `go.mod` if it already exists, and recreate it with `go mod init`.
 func (s *State) GoModInit() error {
+	s.buildCache.Reset()
 	err := os.Remove(path.Join(s.TempDir, "go.mod"))
 	if err != nil && !os.IsNotExist(err) {
 		klog.Errorf("Failed to remove go.mod: %+v", err)
@@ -213,7 +321,7 @@ func (s *State) GoModInit() error {
 	cmd := exec.Command("go", "mod", "init", s.Package)
 	cmd.Dir = s.TempDir
 	var output []byte
-	output, err = cmd.CombinedOutput()
+	output, err = runWithWatchdog(nil, GoGetTimeout, cmd)
 	if err != nil {
 		klog.Errorf("Failed to run `go mod init %s`:\n%s", s.Package, output)
 		return errors.Wrapf(err, "failed to run %q", cmd.String())
@@ -227,13 +335,21 @@ func (s *State) Stop() error {
 		s.gopls.Shutdown()
 		s.gopls = nil
 	}
+	s.removeSnapshots()
+	if err := s.FlushUsageStats(); err != nil {
+		klog.Warningf("Failed to save usage statistics: %+v", err)
+	}
 	if s.TempDir != "" && !s.preserveTempDir {
-		err := os.RemoveAll(s.TempDir)
+		err := s.tempDirResource.Release()
 		if err != nil {
-			return errors.Wrapf(err, "Failed to remove goexec.State temporary directory %s", s.TempDir)
+			return errors.WithMessagef(err, "Failed to remove goexec.State temporary directory %s", s.TempDir)
 		}
 		s.TempDir = "/"
 	}
+	if s.runnerPool != nil {
+		s.runnerPool.Close()
+		s.runnerPool = nil
+	}
 	if s.Comms != nil {
 		// Close without a message (no sending back a comm_close message),
 		// if not yet closed.
@@ -280,6 +396,28 @@ func copyMap[K comparable, V any](dst, src map[K]V) {
 	}
 }
 
+// CellIds returns a summary of the declarations: it maps the kind of declaration ("functions", "variables",
+// "types", "constants" and "imports") to a map of the declaration key to the id of the cell that declared it.
+//
+// Since cell ids are the execution count, any redefinition changes the summary.
+func (d *Declarations) CellIds() map[string]map[string]int {
+	return map[string]map[string]int{
+		"functions": cellIds(d.Functions),
+		"variables": cellIds(d.Variables),
+		"types":     cellIds(d.Types),
+		"constants": cellIds(d.Constants),
+		"imports":   cellIds(d.Imports),
+	}
+}
+
+func cellIds[V interface{ CellId() int }](data map[string]V) map[string]int {
+	ids := make(map[string]int, len(data))
+	for k, v := range data {
+		ids[k] = v.CellId()
+	}
+	return ids
+}
+
 // ClearCursor wherever declaration it may be.
 func (d *Declarations) ClearCursor() {
 	clearCursor(d.Imports)
@@ -366,6 +504,9 @@ type CellLines struct {
 	Lines []int
 }
 
+// CellId returns the id of the cell where the declaration comes from, or -1 if it was automatically created.
+func (c CellLines) CellId() int { return c.Id }
+
 // Append id and line numbers to fileToCellIdAndLine, a slice of `CellIdAndLine`. This is used when
 // rendering a declaration to a file.
 func (c CellLines) Append(fileToCellIdAndLine []CellIdAndLine) []CellIdAndLine {
@@ -19,7 +19,11 @@ import (
 //
 // It can be rendered to HTML in the notebook with `GonbError.PublishWithHTML`.
 type GonbError struct {
-	Lines  []errorLine
+	Lines []errorLine
+
+	// Hints are the suggestions of the ErrorHints that match the error, see MatchErrorHints.
+	Hints []string
+
 	errMsg string
 	err    error
 }
@@ -43,7 +47,7 @@ func newGonbErrors(s *State, fileToCellIdAndLine []CellIdAndLine, errorMsg strin
 
 	// Parse err Lines.
 	lines := strings.Split(errorMsg, "\n")
-	nbErr := &GonbError{Lines: make([]errorLine, len(lines)), errMsg: errorMsg, err: baseErr}
+	nbErr := &GonbError{Lines: make([]errorLine, len(lines)), Hints: MatchErrorHints(errorMsg), errMsg: errorMsg, err: baseErr}
 	for ii, line := range lines {
 		parsed := s.parseErrorLine(line, codeLines, fileToCellIdAndLine)
 		nbErr.Lines[ii] = parsed
@@ -62,13 +66,22 @@ func (nbErr *GonbError) Error() string {
 	return nbErr.errMsg
 }
 
-// Traceback corresponds to field "traceback" in Jupyter.
+// Traceback corresponds to field "traceback" in Jupyter. It includes the suggestions for known failures.
 func (nbErr *GonbError) Traceback() []string {
 	traceback := make([]string, len(nbErr.Lines))
 	for ii, line := range nbErr.Lines {
 		traceback[ii] = line.getTraceback()
 	}
-	return traceback
+	return append(traceback, hintsTraceback(nbErr.Hints)...)
+}
+
+// HintsHtml returns the suggestions for known failures, rendered as HTML.
+func (nbErr *GonbError) HintsHtml() []string {
+	hints := make([]string, 0, len(nbErr.Hints))
+	for _, hint := range nbErr.Hints {
+		hints = append(hints, hintToHtml(hint))
+	}
+	return hints
 }
 
 // Name corresponds to field "ename" in Jupyter. Hardcoded in "ERROR" for now.
@@ -106,6 +119,9 @@ type errorLine struct {
 
 	HasCellInfo bool
 	CellInfo    string
+
+	// Diagnostic parsed from the line, or nil if the line has no position.
+	Diagnostic *Diagnostic
 }
 
 // getTraceback renders the colored traceback sent to Jupyter for this errorLine.
@@ -141,6 +157,9 @@ func (e *errorLine) getColLine() string {
 
 var reFileLinePrefix = regexp.MustCompile(`(^.*main(_test)?\.go:(\d+):(\d+): )(.+)$`)
 
+// vetPrefix is the prefix of the findings of `go vet`.
+const vetPrefix = "vet: "
+
 // parseErrorLine parses an err line, and given current line to cell mapping, creates context for the err
 // if available.
 func (s *State) parseErrorLine(lineStr string, codeLines []string, fileToCellIdAndLine []CellIdAndLine) (l errorLine) {
@@ -158,7 +177,19 @@ func (s *State) parseErrorLine(lineStr string, codeLines []string, fileToCellIdA
 
 	lineNum, _ := strconv.Atoi(matches[3])
 	lineNum -= 1 // Error messages start at line 1 (as opposed to 0)
-	//colNum, _ := strconv.Atoi(matches[4])
+	colNum, _ := strconv.Atoi(matches[4])
+	l.Diagnostic = &Diagnostic{
+		Severity: "error",
+		Source:   "compiler",
+		Message:  l.Message,
+		File:     strings.TrimSuffix(strings.TrimPrefix(matches[1], vetPrefix), fmt.Sprintf(":%s:%s: ", matches[3], matches[4])),
+		Line:     lineNum + 1,
+		Column:   colNum,
+	}
+	if strings.HasPrefix(lineStr, vetPrefix) {
+		l.Diagnostic.Severity = "warning"
+		l.Diagnostic.Source = "vet"
+	}
 	fromLines := lineNum - LinesForErrorContext
 	fromLines = inBetween(fromLines, 0, len(codeLines)-1)
 	toLines := lineNum + LinesForErrorContext
@@ -170,7 +201,7 @@ func (s *State) parseErrorLine(lineStr string, codeLines []string, fileToCellIdA
 		partRaw := codeLines[ii] + "\n"
 		partHtml := html.EscapeString(codeLines[ii]) + "\n"
 		if ii == lineNum {
-			partHtml = fmt.Sprintf(`<div class="gonb-err-line">%s</div>`, partHtml)
+			partHtml = fmt.Sprintf(`<div class="gonb-err-line">%s</div>`, squiggleHtml(codeLines[ii], colNum)+"\n")
 			partRaw += l.getColLine()
 		}
 		partsHtml = append(partsHtml, partHtml)
@@ -184,6 +215,8 @@ func (s *State) parseErrorLine(lineStr string, codeLines []string, fileToCellIdA
 	if lineNum > 0 && lineNum < len(fileToCellIdAndLine) && fileToCellIdAndLine[lineNum].Line != NoCursorLine {
 		cell := fileToCellIdAndLine[lineNum]
 		l.HasCellInfo = true
+		l.Diagnostic.CellId = cell.Id
+		l.Diagnostic.CellLine = cell.Line + 1
 		// Notice GoNB store Lines starting at 0, but Jupyter display Lines starting at 1, so we add 1 here.
 		if cell.Id != -1 {
 			l.CellInfo = fmt.Sprintf("Cell[%d]: Line %d", cell.Id, cell.Line+1)
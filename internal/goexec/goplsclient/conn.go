@@ -11,6 +11,7 @@ import (
 
 	lsp "github.com/go-language-server/protocol"
 	"github.com/go-language-server/uri"
+	"github.com/janpfeifer/gonb/internal/metrics"
 	"github.com/pkg/errors"
 	"go.lsp.dev/jsonrpc2"
 )
@@ -20,6 +21,18 @@ var _ = lsp.MethodInitialize
 var (
 	ConnectTimeout       = 2000 * time.Millisecond
 	CommunicationTimeout = 2000 * time.Millisecond
+
+	// RequestTimeout is the deadline for a whole request (e.g.: Definition or Complete), that
+	// may involve several calls to `gopls`.
+	RequestTimeout = 10 * time.Second
+
+	// InitializationOptions sent to `gopls` when connecting: hovers include the full documentation of
+	// the symbols (rendered in Markdown), with links to their documentation in pkg.go.dev.
+	// See https://github.com/golang/tools/blob/master/gopls/doc/settings.md
+	InitializationOptions = map[string]any{
+		"hoverKind":    "FullDocumentation",
+		"linksInHover": true,
+	}
 )
 
 func (c *Client) ConnClose() {
@@ -37,20 +50,21 @@ func (c *Client) connCloseLocked() {
 	}
 }
 
-// minTimeout extends (or returns a new context with extended Deadline), discarding the
-// previous one -- not the correct use of context, but will do for now.
-func minTimeout(ctx context.Context, timeout time.Duration) context.Context {
+// minTimeout returns a context whose deadline is at most `timeout` from now, and the
+// corresponding cancel function, which must be called once the context is no longer needed.
+func minTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
 	minDeadline := time.Now().Add(timeout)
 	if deadline, ok := ctx.Deadline(); !ok || deadline.After(minDeadline) {
-		ctx, _ = context.WithDeadline(ctx, minDeadline)
+		return context.WithDeadline(ctx, minDeadline)
 	}
-	return ctx
+	return ctx, func() {}
 }
 
 // Connect to the `gopls` in address given by `c.Address()`. It also starts
 // a goroutine to monitor receiving requests.
 func (c *Client) Connect(ctx context.Context) error {
-	ctx = minTimeout(ctx, ConnectTimeout)
+	ctx, cancel := minTimeout(ctx, ConnectTimeout)
+	defer cancel()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -92,6 +106,7 @@ func (c *Client) Connect(ctx context.Context) error {
 		ProcessID: 0,
 		RootURI:   uri.File(c.dir),
 		// Capabilities:          lsp.ClientCapabilities{},
+		InitializationOptions: InitializationOptions,
 	}, &c.lspCapabilities)
 	_ = callId // Not used now.
 	if err != nil {
@@ -123,7 +138,8 @@ func (c *Client) NotifyDidOpenOrChange(ctx context.Context, filePath string) (er
 		// Silently do nothing, if no connection available.
 		return
 	}
-	ctx = minTimeout(ctx, CommunicationTimeout)
+	ctx, cancel := minTimeout(ctx, CommunicationTimeout)
+	defer cancel()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn == nil {
@@ -221,7 +237,8 @@ func (c *Client) CallDefinition(ctx context.Context, filePath string, line, col
 		// Silently do nothing, if no connection available.
 		return
 	}
-	ctx = minTimeout(ctx, CommunicationTimeout)
+	ctx, cancel := minTimeout(ctx, CommunicationTimeout)
+	defer cancel()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn == nil {
@@ -248,7 +265,9 @@ func (c *Client) callDefinitionLocked(ctx context.Context, filePath string, line
 			Character: float64(col),
 		},
 	}
+	start := time.Now()
 	_, err = c.jsonConn.Call(ctx, lsp.MethodTextDocumentDefinition, params, &results)
+	metrics.ObserveSince(metrics.GoplsRequestSeconds.With("definition"), start)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed call to `gopls` \"definition_request\"")
 	}
@@ -271,7 +290,8 @@ func (c *Client) CallHover(ctx context.Context, filePath string, line, col int)
 		// Silently do nothing, if no connection available.
 		return
 	}
-	ctx = minTimeout(ctx, CommunicationTimeout)
+	ctx, cancel := minTimeout(ctx, CommunicationTimeout)
+	defer cancel()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn == nil {
@@ -299,7 +319,9 @@ func (c *Client) callHoverLocked(ctx context.Context, filePath string, line, col
 		},
 	}
 
+	start := time.Now()
 	_, err = c.jsonConn.Call(ctx, lsp.MethodTextDocumentHover, params, &hover)
+	metrics.ObserveSince(metrics.GoplsRequestSeconds.With("hover"), start)
 	if err != nil {
 		klog.V(2).Infof("goplsclient.CallHover(ctx, %s, %d, %d): %+v", uri.File(filePath), line, col, err)
 		err = errors.Wrapf(err, "Failed Client.CallHover notification for %q", filePath)
@@ -313,7 +335,8 @@ func (c *Client) CallComplete(ctx context.Context, filePath string, line, col in
 		// Silently do nothing, if no connection available.
 		return
 	}
-	ctx = minTimeout(ctx, CommunicationTimeout)
+	ctx, cancel := minTimeout(ctx, CommunicationTimeout)
+	defer cancel()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn == nil {
@@ -345,7 +368,9 @@ func (c *Client) callCompleteLocked(ctx context.Context, filePath string, line,
 		},
 	}
 	items = &lsp.CompletionList{}
+	start := time.Now()
 	callId, err := c.jsonConn.Call(ctx, lsp.MethodTextDocumentCompletion, params, items)
+	metrics.ObserveSince(metrics.GoplsRequestSeconds.With("completion"), start)
 	_ = callId
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed call to `gopls` \"complete_request\"")
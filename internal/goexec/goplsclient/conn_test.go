@@ -0,0 +1,41 @@
+package goplsclient
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMinTimeout(t *testing.T) {
+	// No deadline: one is set, and cancel releases the context.
+	ctx, cancel := minTimeout(context.Background(), time.Minute)
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+	require.NoError(t, ctx.Err())
+	cancel()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	// Later deadline: it is shortened.
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Hour)
+	defer parentCancel()
+	ctx, cancel = minTimeout(parent, time.Minute)
+	deadline, _ = ctx.Deadline()
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+	cancel()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.NoError(t, parent.Err(), "cancel should not affect the parent context")
+
+	// Earlier deadline: the parent context is kept, and cancel is a no-op.
+	parent, parentCancel = context.WithTimeout(context.Background(), time.Second)
+	defer parentCancel()
+	parentDeadline, _ := parent.Deadline()
+	ctx, cancel = minTimeout(parent, time.Minute)
+	assert.Equal(t, parent, ctx)
+	deadline, _ = ctx.Deadline()
+	assert.Equal(t, parentDeadline, deadline)
+	cancel()
+	assert.NoError(t, parent.Err())
+}
@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/janpfeifer/gonb/internal/resources"
 	"github.com/pkg/errors"
 )
 
@@ -17,6 +18,10 @@ import (
 
 var StartTimeout = 5 * time.Second
 
+// MaxConsecutiveTimeouts is the number of consecutive requests to `gopls` that can time out before
+// it is considered wedged, and is restarted.
+var MaxConsecutiveTimeouts = 3
+
 // Start `gopls` as a server, on `Client.Address()` port. It is started
 // asynchronously (so `Start()` returns immediately) and is followed up
 // by automatically connecting to it.
@@ -58,6 +63,10 @@ func (c *Client) Start() error {
 		close(c.stop)
 		return err
 	}
+	processResource := resources.RegisterProcess("gopls", "gopls", c.goplsExec.Process)
+	if socketPath := strings.TrimPrefix(addr, "unix;"); strings.HasPrefix(socketPath, "/") {
+		c.socketResource = resources.RegisterPath(resources.Socket, "gopls", socketPath)
+	}
 
 	c.waitConnecting = true
 
@@ -97,9 +106,18 @@ func (c *Client) Start() error {
 		}
 		c.mu.Lock()
 		defer c.mu.Unlock()
+		processResource.Forget()
 		close(c.stop)
 		c.removeUnixSocketFile()
 		c.stopLocked()
+		if c.restartOnExit {
+			c.restartOnExit = false
+			go func() {
+				if err := c.Start(); err != nil {
+					klog.Errorf("Failed to restart `gopls`: %+v", err)
+				}
+			}()
+		}
 	}()
 
 	return nil
@@ -112,8 +130,21 @@ func (c *Client) Stop() {
 	c.stopLocked()
 }
 
+// Restart `gopls` if it is running: it is stopped, and started again once it exits. Used so it picks up
+// changes in the environment (e.g.: `GOMODCACHE`).
+func (c *Client) Restart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.IsStopped() {
+		return
+	}
+	c.restartOnExit = true
+	c.connCloseLocked()
+	c.stopLocked()
+}
+
 // WaitConnection checks whether connection is up, and if not tries connecting.
-// Returns true if good to proceed.
+// Returns true if good to proceed. It gives up (returns false) if the context is done.
 func (c *Client) WaitConnection(ctx context.Context) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -127,7 +158,12 @@ func (c *Client) WaitConnection(ctx context.Context) bool {
 
 		// Wait before checking again.
 		c.mu.Unlock()
-		<-time.After(ConnectTimeout)
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			return false
+		case <-time.After(ConnectTimeout):
+		}
 		c.mu.Lock()
 	}
 }
@@ -166,4 +202,25 @@ func (c *Client) removeUnixSocketFile() {
 		// Remove unix socket file, if it exists -- we ignore any errors.
 		_ = os.Remove(addr)
 	}
+	c.socketResource.Forget()
+}
+
+// watchdog keeps track of requests that timed out: after MaxConsecutiveTimeouts of them `gopls` is
+// assumed to be wedged, and it is killed and restarted.
+func (c *Client) watchdog(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		c.consecutiveTimeouts = 0
+		return
+	}
+	c.consecutiveTimeouts++
+	if c.consecutiveTimeouts < MaxConsecutiveTimeouts || c.IsStopped() {
+		return
+	}
+	klog.Warningf("gopls timed out %d consecutive times, restarting it", c.consecutiveTimeouts)
+	c.consecutiveTimeouts = 0
+	c.restartOnExit = true
+	c.connCloseLocked()
+	c.stopLocked()
 }
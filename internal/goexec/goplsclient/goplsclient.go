@@ -30,6 +30,7 @@ import (
 
 	lsp "github.com/go-language-server/protocol"
 	"github.com/go-language-server/uri"
+	"github.com/janpfeifer/gonb/internal/resources"
 	"github.com/pkg/errors"
 	"go.lsp.dev/jsonrpc2"
 )
@@ -48,9 +49,14 @@ type Client struct {
 
 	// gopls execution
 	goplsExec      *exec.Cmd
+	socketResource *resources.Resource // Unix socket in the resources registry.
 	stop           chan struct{}
 	waitConnecting bool
 
+	// Watchdog: consecutive requests that timed out, and whether to restart `gopls` once it exits.
+	consecutiveTimeouts int
+	restartOnExit       bool
+
 	// File cache.
 	fileVersions map[string]int       // Every open file that has been sent to gopls has a version, that is bumped when it is sent again.
 	fileCache    map[string]*FileData // Cache of files stored in disk.
@@ -94,6 +100,7 @@ func (c *Client) SetAddress(address string) {
 func (c *Client) Shutdown() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.restartOnExit = false
 	c.connCloseLocked()
 	c.stopLocked()
 }
@@ -111,6 +118,7 @@ func isGoInternalOrCache(filePath string) bool {
 // in Markdown. It returns empty if position has no identifier.
 func (c *Client) Definition(ctx context.Context, filePath string, line, col int) (markdown string, err error) {
 	klog.V(2).Infof("goplsclient.Definition(ctx, %s, %d, %d)", filePath, line, col)
+	defer func() { c.watchdog(err) }()
 
 	// Send filePath.
 	err = c.NotifyDidOpenOrChange(ctx, filePath)
@@ -149,6 +157,7 @@ func (c *Client) Definition(ctx context.Context, filePath string, line, col int)
 // be replaced by the matches (the same value for every entry).
 func (c *Client) Complete(ctx context.Context, filePath string, line, col int) (matches []string, replaceLength int, err error) {
 	klog.V(2).Infof("goplsclient.Complete(ctx, %s, %d, %d)", filePath, line, col)
+	defer func() { c.watchdog(err) }()
 	err = c.NotifyDidOpenOrChange(ctx, filePath)
 	if err != nil {
 		return
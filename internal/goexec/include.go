@@ -0,0 +1,247 @@
+package goexec
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// This file implements `%include`: it merges the declarations of another notebook, or Go file, into the
+// current state -- so helper code can be shared across notebooks.
+
+// Include reads the declarations of the notebook (".ipynb") or Go file (".go") in `filePath`, and merges them
+// into the memorized declarations, reporting the ones that replace different previous definitions.
+//
+// The included code is compiled (and the cell executed, without a `func main()`) as if it were the contents
+// of the cell `cellId`: `func main()` and special commands in the included source are ignored.
+func (s *State) Include(msg kernel.Message, cellId int, filePath string) error {
+	if s.CellIsTest || s.CellIsWasm || s.CellProfile != "" || s.CellCache || s.CellExport != nil ||
+		len(s.Args) > 0 || len(s.CellGoFlags) > 0 {
+		return errors.Errorf("%%include must come before the special commands that configure the cell execution " +
+			"(`%%test`, `%%args`, `%%prof`, `%%cache`, etc.)")
+	}
+	lines, err := ReadIncludeSource(filePath)
+	if err != nil {
+		return err
+	}
+	newDecls, err := parseDeclarationsFromLines(cellId, lines)
+	if err != nil {
+		return errors.WithMessagef(err, "%%include %q", filePath)
+	}
+	skipLines := MakeSet[int]()
+	if mainDecl, found := newDecls.Functions["main"]; found {
+		for _, line := range mainDecl.Lines {
+			skipLines.Insert(line)
+		}
+		delete(newDecls.Functions, "main")
+	}
+	numDecls := len(newDecls.Imports) + len(newDecls.Constants) + len(newDecls.Types) +
+		len(newDecls.Variables) + len(newDecls.Functions)
+	if numDecls == 0 {
+		return errors.Errorf("%%include %q: no declarations found", filePath)
+	}
+	conflicts := s.Definitions.conflictsWith(newDecls)
+	if err = s.ExecuteCell(msg, cellId, lines, skipLines); err != nil {
+		return errors.WithMessagef(err, "%%include %q", filePath)
+	}
+
+	var report strings.Builder
+	_, _ = fmt.Fprintf(&report, "* Included %d declaration(s) from %q", numDecls, filePath)
+	if len(conflicts) == 0 {
+		report.WriteString(".\n")
+	} else {
+		_, _ = fmt.Fprintf(&report, ", %d replaced previous different definitions:\n", len(conflicts))
+		for _, conflict := range conflicts {
+			_, _ = fmt.Fprintf(&report, "  - %s\n", conflict)
+		}
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+}
+
+// conflictsWith returns a description of the declarations in `d2` that have a different definition in `d`,
+// sorted.
+func (d *Declarations) conflictsWith(d2 *Declarations) (conflicts []string) {
+	describe := func(kind, key string, cellId int) string {
+		if cellId >= 0 {
+			return fmt.Sprintf("%s %q (from cell [%d])", kind, key, cellId)
+		}
+		return fmt.Sprintf("%s %q", kind, key)
+	}
+	for key, decl := range d2.Imports {
+		if prev, found := d.Imports[key]; found && (prev.Path != decl.Path || prev.Alias != decl.Alias) {
+			conflicts = append(conflicts, describe("import", key, prev.Id))
+		}
+	}
+	for key, decl := range d2.Constants {
+		if prev, found := d.Constants[key]; found &&
+			(prev.TypeDefinition != decl.TypeDefinition || prev.ValueDefinition != decl.ValueDefinition) {
+			conflicts = append(conflicts, describe("constant", key, prev.Id))
+		}
+	}
+	for key, decl := range d2.Types {
+		if prev, found := d.Types[key]; found && prev.TypeDefinition != decl.TypeDefinition {
+			conflicts = append(conflicts, describe("type", key, prev.Id))
+		}
+	}
+	for key, decl := range d2.Variables {
+		if prev, found := d.Variables[key]; found &&
+			(prev.TypeDefinition != decl.TypeDefinition || prev.ValueDefinition != decl.ValueDefinition) {
+			conflicts = append(conflicts, describe("variable", key, prev.Id))
+		}
+	}
+	for key, decl := range d2.Functions {
+		if prev, found := d.Functions[key]; found && prev.Definition != decl.Definition {
+			conflicts = append(conflicts, describe("function", key, prev.Id))
+		}
+	}
+	sort.Strings(conflicts)
+	return
+}
+
+// includeFileName is the name used to parse the included source.
+const includeFileName = "include.go"
+
+// parseDeclarationsFromLines parses the declarations in `lines`, as if they were in the cell `cellId`.
+// Unlike parseFromGoCode, it doesn't use the files in `s.TempDir`.
+func parseDeclarationsFromLines(cellId int, lines []string) (*Declarations, error) {
+	const header = "package main\n\n"
+	content := header + strings.Join(lines, "\n") + "\n"
+	pi := &parseInfo{
+		cursor:        NoCursor,
+		cellId:        cellId,
+		fileSet:       token.NewFileSet(),
+		filesContents: map[string]string{includeFileName: content},
+	}
+	// The header lines are not in the cell.
+	pi.fileToCellIdAndLine = []CellIdAndLine{{NoCursorLine, NoCursorLine}, {NoCursorLine, NoCursorLine}}
+	for ii := range lines {
+		pi.fileToCellIdAndLine = append(pi.fileToCellIdAndLine, CellIdAndLine{cellId, ii})
+	}
+	pi.fileToCellIdAndLine = append(pi.fileToCellIdAndLine, CellIdAndLine{NoCursorLine, NoCursorLine})
+	fileObj, err := parser.ParseFile(pi.fileSet, includeFileName, content, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse included code")
+	}
+	decls := NewDeclarations()
+	pi.parseFile(decls, fileObj)
+	return decls, nil
+}
+
+var regexpPackageLine = regexp.MustCompile(`^package\s+\w+`)
+
+// ReadIncludeSource returns the Go code to be included from the notebook or Go file in `filePath`.
+//
+// For Go files, the `package` clause is removed. For notebooks, the Go code of all code cells is concatenated,
+// except for cells with cell magic (e.g. `%%writefile`), `%test` or `%bench`, and the special commands (lines
+// starting with "%" or "!") and the body of `%%` (or `%main`) are dropped.
+func ReadIncludeSource(filePath string) ([]string, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%%include failed to read %q", filePath)
+	}
+	switch filepath.Ext(filePath) {
+	case ".go":
+		lines := strings.Split(string(contents), "\n")
+		for ii, line := range lines {
+			if regexpPackageLine.MatchString(line) {
+				// Keep the line numbers, so errors are reported in the right line.
+				lines[ii] = ""
+				break
+			}
+		}
+		return lines, nil
+	case ".ipynb":
+		return readNotebookGoCode(filePath, contents)
+	default:
+		return nil, errors.Errorf("%%include only supports notebooks (\".ipynb\") and Go files (\".go\"), got %q", filePath)
+	}
+}
+
+// readNotebookGoCode returns the Go code in the code cells of a notebook, see ReadIncludeSource.
+func readNotebookGoCode(filePath string, contents []byte) ([]string, error) {
+	var nb struct {
+		Metadata struct {
+			KernelSpec struct {
+				Language string `json:"language"`
+			} `json:"kernelspec"`
+		} `json:"metadata"`
+		Cells []struct {
+			CellType string          `json:"cell_type"`
+			Source   json.RawMessage `json:"source"`
+		} `json:"cells"`
+	}
+	if err := json.Unmarshal(contents, &nb); err != nil {
+		return nil, errors.Wrapf(err, "%%include failed to parse notebook %q", filePath)
+	}
+	if language := nb.Metadata.KernelSpec.Language; language != "" && language != "go" {
+		return nil, errors.Errorf("%%include: notebook %q is for language %q, not Go", filePath, language)
+	}
+	var lines []string
+	for _, cell := range nb.Cells {
+		if cell.CellType != "code" {
+			continue
+		}
+		// Jupyter stores the source either as a string or as a list of lines.
+		var source string
+		var sourceLines []string
+		if err := json.Unmarshal(cell.Source, &sourceLines); err == nil {
+			source = strings.Join(sourceLines, "")
+		} else if err = json.Unmarshal(cell.Source, &source); err != nil {
+			return nil, errors.Wrapf(err, "%%include: invalid cell source in notebook %q", filePath)
+		}
+		cellLines := notebookCellGoCode(strings.Split(source, "\n"))
+		if len(cellLines) > 0 {
+			lines = append(lines, cellLines...)
+			lines = append(lines, "")
+		}
+	}
+	return lines, nil
+}
+
+// notebookCellGoCode returns the lines of Go declarations of a notebook cell.
+func notebookCellGoCode(cellLines []string) []string {
+	for _, line := range cellLines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "%%") && trimmed != "%%" {
+			// Cell magic, e.g.: `%%writefile`, `%%bash`.
+			return nil
+		}
+		break
+	}
+	var goLines []string
+	for ii := 0; ii < len(cellLines); ii++ {
+		line := cellLines[ii]
+		if strings.HasPrefix(line, "%%") || strings.HasPrefix(line, "%main") {
+			// The rest of the cell is the body of `func main()`.
+			break
+		}
+		if strings.HasPrefix(line, "%test") || strings.HasPrefix(line, "%bench") || strings.HasPrefix(line, "%wasm") {
+			return nil
+		}
+		if len(line) > 1 && (line[0] == '%' || line[0] == '!') {
+			// Special command, possibly continued in the following lines.
+			for strings.HasSuffix(line, "\\") && ii+1 < len(cellLines) {
+				ii++
+				line = cellLines[ii]
+			}
+			continue
+		}
+		goLines = append(goLines, line)
+	}
+	if len(strings.TrimSpace(strings.Join(goLines, ""))) == 0 {
+		return nil
+	}
+	return goLines
+}
@@ -0,0 +1,67 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestReadIncludeSource(t *testing.T) {
+	dir := t.TempDir()
+
+	// Go file: the package clause is removed, preserving line numbers.
+	goPath := path.Join(dir, "helpers.go")
+	require.NoError(t, os.WriteFile(goPath, []byte("package helpers\n\nfunc Double(x int) int { return 2 * x }\n"), 0644))
+	lines, err := ReadIncludeSource(goPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "", "func Double(x int) int { return 2 * x }", ""}, lines)
+
+	// Notebook: special commands, `%%` bodies, cell magic and `%test` cells are dropped.
+	nbPath := path.Join(dir, "helpers.ipynb")
+	require.NoError(t, os.WriteFile(nbPath, []byte(`{
+ "metadata": {"kernelspec": {"language": "go", "name": "gonb"}},
+ "cells": [
+  {"cell_type": "markdown", "source": ["# Helpers"]},
+  {"cell_type": "code", "source": ["!echo \\\n", "  hello\n", "import \"math\"\n", "const Pi = math.Pi"]},
+  {"cell_type": "code", "source": "func Square(x float64) float64 { return x * x }\n\n%%\nfmt.Println(Square(Pi))"},
+  {"cell_type": "code", "source": ["%%writefile foo.txt\n", "func NotGo() {}"]},
+  {"cell_type": "code", "source": ["%test\n", "func TestSquare(t *testing.T) {}"]}
+ ]
+}`), 0644))
+	lines, err = ReadIncludeSource(nbPath)
+	require.NoError(t, err)
+	code := strings.Join(lines, "\n")
+	assert.Contains(t, code, "import \"math\"\nconst Pi = math.Pi")
+	assert.Contains(t, code, "func Square(x float64) float64 { return x * x }")
+	for _, excluded := range []string{"echo", "hello", "fmt.Println", "NotGo", "TestSquare", "%"} {
+		assert.NotContains(t, code, excluded)
+	}
+
+	_, err = ReadIncludeSource(path.Join(dir, "helpers.txt"))
+	require.Error(t, err)
+}
+
+func TestIncludeConflicts(t *testing.T) {
+	decls, err := parseDeclarationsFromLines(7, []string{
+		"type T int",
+		"func F() int { return 1 }",
+		"func main() {",
+		"}",
+	})
+	require.NoError(t, err)
+	assert.Contains(t, decls.Types, "T")
+	require.Contains(t, decls.Functions, "main")
+	assert.Equal(t, 7, decls.Functions["F"].Id)
+	assert.Equal(t, []int{1}, decls.Functions["F"].Lines)
+	assert.Equal(t, []int{2, 3}, decls.Functions["main"].Lines)
+
+	current, err := parseDeclarationsFromLines(3, []string{
+		"type T int",
+		"func F() int { return 2 }",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`function "F" (from cell [3])`}, current.conflictsWith(decls))
+}
@@ -2,10 +2,15 @@ package goexec
 
 import (
 	"context"
+	"fmt"
 	"github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/goexec/goplsclient"
 	"github.com/janpfeifer/gonb/internal/kernel"
 	"github.com/pkg/errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"k8s.io/klog/v2"
 	"os"
 	"path"
@@ -41,7 +46,10 @@ func (s *State) notifyAboutStandardAndTrackedFiles(ctx context.Context) (err err
 
 // InspectIdentifierInCell implements an `inspect_request` from Jupyter, using `gopls`.
 // It updates `main.go` with the cell contents (given as Lines)
-func (s *State) InspectIdentifierInCell(lines []string, skipLines map[int]struct{}, cursorLine, cursorCol int) (mimeMap kernel.MIMEMap, err error) {
+//
+// It returns the full documentation of the identifier, rendered in Markdown. If detailLevel > 0 (e.g.: `obj??`),
+// the source of the declaration of the identifier is also included.
+func (s *State) InspectIdentifierInCell(lines []string, skipLines map[int]struct{}, cursorLine, cursorCol, detailLevel int) (mimeMap kernel.MIMEMap, err error) {
 	klog.V(2).Infof("InspectIdentifierInCell: ")
 	if s.gopls == nil {
 		// gopls not installed.
@@ -98,7 +106,8 @@ func (s *State) InspectIdentifierInCell(lines []string, skipLines map[int]struct
 	}
 
 	// Query `gopls`.
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), goplsclient.RequestTimeout)
+	defer cancel()
 	var desc string
 	klog.V(2).Infof("InspectIdentifierInCell: gopls.Definition(ctx, %s, %d, %d)",
 		s.CodePath(), cursorInFile.Line, cursorInFile.Col)
@@ -118,11 +127,126 @@ func (s *State) InspectIdentifierInCell(lines []string, skipLines map[int]struct
 		return kernel.MIMEMap{string(protocol.MIMETextPlain): strings.Join(parts, "\n\n")}, nil
 	}
 
-	// Return MIMEMap with markdown.
-	mimeMap = kernel.MIMEMap{string(protocol.MIMETextMarkdown): desc}
+	if detailLevel > 0 {
+		source, sourceErr := s.inspectSource(ctx, cursorInFile, fileToCellIdAndLine)
+		if sourceErr != nil {
+			klog.V(1).Infof("InspectIdentifierInCell: source not available: %+v", sourceErr)
+		} else if source != "" {
+			desc = strings.Join([]string{desc, source}, "\n\n---\n\n")
+		}
+	}
+
+	// Return MIMEMap with markdown, and the same as plain text for pagers that don't render Markdown.
+	mimeMap = kernel.MIMEMap{
+		string(protocol.MIMETextMarkdown): desc,
+		string(protocol.MIMETextPlain):    desc,
+	}
 	return
 }
 
+// MaxInspectSourceLines is the maximum number of lines of source displayed by InspectIdentifierInCell.
+const MaxInspectSourceLines = 300
+
+// inspectSource returns the source of the declaration of the identifier under the cursor, located with `gopls`,
+// rendered as Markdown with the location of the source.
+func (s *State) inspectSource(ctx context.Context, cursorInFile Cursor, fileToCellIdAndLine []CellIdAndLine) (string, error) {
+	locations, err := s.gopls.CallDefinition(ctx, s.CodePath(), cursorInFile.Line, cursorInFile.Col)
+	if err != nil || len(locations) == 0 {
+		return "", err
+	}
+	filePath := locations[0].URI.Filename()
+	line := int(locations[0].Range.Start.Line) + 1 // Lines in gopls start from 0.
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read source of definition in %q", filePath)
+	}
+	source, err := declarationSource(content, line)
+	if err != nil {
+		return "", err
+	}
+	if sourceLines := strings.Split(source, "\n"); len(sourceLines) > MaxInspectSourceLines {
+		source = strings.Join(sourceLines[:MaxInspectSourceLines], "\n") + "\n\t// ... (truncated)"
+	}
+
+	location := fmt.Sprintf("`%s:%d`", filePath, line)
+	if filePath == s.CodePath() && line <= len(fileToCellIdAndLine) {
+		if cellIdAndLine := fileToCellIdAndLine[line-1]; cellIdAndLine.Line != NoCursorLine {
+			if cellIdAndLine.Id == -1 {
+				location = fmt.Sprintf("current cell, line %d", cellIdAndLine.Line+1)
+			} else {
+				location = fmt.Sprintf("cell [%d], line %d", cellIdAndLine.Id, cellIdAndLine.Line+1)
+			}
+		}
+	}
+	return fmt.Sprintf("**Source** (%s):\n\n```go\n%s\n```", location, source), nil
+}
+
+// declarationSource returns the source of the top-level declaration in the Go file `content` that contains
+// the `line` (starting from 1), including its doc comments. For declarations grouped in parenthesis
+// (e.g.: `const ( ... )`), only the specification that contains the line is returned.
+func declarationSource(content []byte, line int) (string, error) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", content, parser.ParseComments|parser.SkipObjectResolution)
+	if file == nil {
+		return "", errors.Wrapf(err, "failed to parse source of definition")
+	}
+	contains := func(start, end token.Pos) bool {
+		return fileSet.Position(start).Line <= line && line <= fileSet.Position(end).Line
+	}
+	extract := func(start, end token.Pos) string {
+		return string(content[fileSet.Position(start).Offset:fileSet.Position(end).Offset])
+	}
+	for _, decl := range file.Decls {
+		if !contains(decl.Pos(), decl.End()) {
+			continue
+		}
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			start := decl.Pos()
+			if decl.Doc != nil {
+				start = decl.Doc.Pos()
+			}
+			return extract(start, decl.End()), nil
+		case *ast.GenDecl:
+			start := decl.Pos()
+			if decl.Doc != nil {
+				start = decl.Doc.Pos()
+			}
+			if !decl.Lparen.IsValid() {
+				return extract(start, decl.End()), nil
+			}
+			for _, spec := range decl.Specs {
+				specStart, specDoc := spec.Pos(), specDocComment(spec)
+				if specDoc != nil {
+					specStart = specDoc.Pos()
+				}
+				if contains(specStart, spec.End()) {
+					source := decl.Tok.String() + " " + extract(spec.Pos(), spec.End())
+					if specDoc != nil {
+						source = extract(specDoc.Pos(), specDoc.End()) + "\n" + source
+					}
+					return source, nil
+				}
+			}
+			return extract(start, decl.End()), nil
+		}
+	}
+	return "", errors.Errorf("no declaration found in line %d", line)
+}
+
+// specDocComment returns the doc comment of a specification in a declaration group.
+func specDocComment(spec ast.Spec) *ast.CommentGroup {
+	switch spec := spec.(type) {
+	case *ast.ValueSpec:
+		return spec.Doc
+	case *ast.TypeSpec:
+		return spec.Doc
+	case *ast.ImportSpec:
+		return spec.Doc
+	}
+	return nil
+}
+
 // AutoCompleteOptionsInCell implements a `complete_request` from Jupyter, using `gopls`.
 // It updates `main.go` with the cell contents (given as Lines)
 func (s *State) AutoCompleteOptionsInCell(cellLines []string, skipLines map[int]struct{},
@@ -179,7 +303,8 @@ func (s *State) AutoCompleteOptionsInCell(cellLines []string, skipLines map[int]
 	}
 
 	// Query `gopls`.
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), goplsclient.RequestTimeout)
+	defer cancel()
 	err = s.notifyAboutStandardAndTrackedFiles(ctx)
 	if err != nil {
 		return
@@ -0,0 +1,47 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const testInspectSource = `package p
+
+// F does things.
+func F() int {
+	return 1
+}
+
+const (
+	// A is the first.
+	A = iota
+	B
+)
+
+type T struct {
+	X int
+}
+`
+
+func TestDeclarationSource(t *testing.T) {
+	source, err := declarationSource([]byte(testInspectSource), 4)
+	require.NoError(t, err)
+	assert.Equal(t, "// F does things.\nfunc F() int {\n\treturn 1\n}", source)
+
+	source, err = declarationSource([]byte(testInspectSource), 10)
+	require.NoError(t, err)
+	assert.Equal(t, "// A is the first.\nconst A = iota", source)
+
+	source, err = declarationSource([]byte(testInspectSource), 11)
+	require.NoError(t, err)
+	assert.Equal(t, "const B", source)
+
+	// A field points to the whole type.
+	source, err = declarationSource([]byte(testInspectSource), 15)
+	require.NoError(t, err)
+	assert.Equal(t, "type T struct {\n\tX int\n}", source)
+
+	_, err = declarationSource([]byte(testInspectSource), 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,301 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// This file implements the detection of the CPU and memory limits of the container (cgroups v1 and v2), used
+// as the defaults of GOMAXPROCS and GOMEMLIMIT for the kernel and for the programs it executes. Otherwise,
+// the Go runtime uses all the CPUs of the host and no memory limit, which leads to CPU throttling and OOM kills
+// in constrained pods (e.g.: JupyterHub).
+//
+// The defaults are only used if the variables are not already set in the environment, and they can be
+// changed from the notebook with `%limits`.
+
+const (
+	GoMaxProcsEnv = "GOMAXPROCS"
+	GoMemLimitEnv = "GOMEMLIMIT"
+)
+
+// MemoryLimitFraction of the container memory limit used as the default GOMEMLIMIT: it's a soft limit, and
+// it leaves room for the memory not managed by Go, and for the other processes in the container.
+var MemoryLimitFraction = 0.9
+
+// CgroupRoot is where the cgroup filesystems are mounted.
+var CgroupRoot = "/sys/fs/cgroup"
+
+// ResourceLimits of the container the kernel runs in.
+type ResourceLimits struct {
+	// CPUs quota (e.g.: 1.5), or 0 if not limited.
+	CPUs float64
+
+	// MemoryBytes limit, or 0 if not limited.
+	MemoryBytes int64
+
+	// Source of the limits, "cgroup v1" or "cgroup v2", or empty if no limits were found.
+	Source string
+}
+
+// DetectResourceLimits reads the limits of the cgroup of the kernel process. Limits are only detected in Linux.
+func DetectResourceLimits() ResourceLimits {
+	if runtime.GOOS != "linux" {
+		return ResourceLimits{}
+	}
+	procCgroup, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		klog.V(1).Infof("goexec: failed to read cgroups of the process: %v", err)
+		return ResourceLimits{}
+	}
+	return detectCgroupLimits(CgroupRoot, string(procCgroup))
+}
+
+// detectCgroupLimits reads the limits of the cgroups listed in procCgroup (the contents of /proc/self/cgroup),
+// mounted under root. The most restrictive limit of the cgroup and its parents is used.
+func detectCgroupLimits(root, procCgroup string) (limits ResourceLimits) {
+	useCPUs := func(cpus float64, source string) {
+		if cpus > 0 && (limits.CPUs == 0 || cpus < limits.CPUs) {
+			limits.CPUs = cpus
+			limits.Source = source
+		}
+	}
+	useMemory := func(memory int64, source string) {
+		if memory > 0 && (limits.MemoryBytes == 0 || memory < limits.MemoryBytes) {
+			limits.MemoryBytes = memory
+			limits.Source = source
+		}
+	}
+	for _, line := range strings.Split(procCgroup, "\n") {
+		// Format: "<hierarchy-id>:<comma separated controllers>:<cgroup path>".
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		controllers, cgroupPath := parts[1], parts[2]
+		if parts[0] == "0" && controllers == "" {
+			// cgroup v2: unified hierarchy.
+			for _, dir := range cgroupDirs(root, cgroupPath) {
+				useCPUs(readCgroupV2CPUs(path.Join(dir, "cpu.max")), "cgroup v2")
+				useMemory(readCgroupInt(path.Join(dir, "memory.max")), "cgroup v2")
+			}
+			continue
+		}
+		// cgroup v1: one hierarchy per (set of) controller(s).
+		for _, controller := range strings.Split(controllers, ",") {
+			switch controller {
+			case "cpu":
+				for _, dir := range cgroupDirs(path.Join(root, controllers), cgroupPath) {
+					quota := readCgroupInt(path.Join(dir, "cpu.cfs_quota_us"))
+					period := readCgroupInt(path.Join(dir, "cpu.cfs_period_us"))
+					if quota > 0 && period > 0 {
+						useCPUs(float64(quota)/float64(period), "cgroup v1")
+					}
+				}
+			case "memory":
+				for _, dir := range cgroupDirs(path.Join(root, controllers), cgroupPath) {
+					useMemory(readCgroupInt(path.Join(dir, "memory.limit_in_bytes")), "cgroup v1")
+				}
+			}
+		}
+	}
+	return
+}
+
+// cgroupDirs returns the directories of the cgroup and all its parents, under the mount point. Inside containers
+// the cgroup path is often not visible (the mount point is the container's cgroup), so the mount point itself is
+// always included.
+func cgroupDirs(mountPoint, cgroupPath string) []string {
+	dirs := []string{mountPoint}
+	for p := path.Clean("/" + cgroupPath); p != "/"; p = path.Dir(p) {
+		dirs = append(dirs, path.Join(mountPoint, p))
+	}
+	return dirs
+}
+
+// unlimitedCgroupValue: cgroup v1 reports no limit as a very large number (rounded down to the page size).
+const unlimitedCgroupValue = int64(1) << 60
+
+// readCgroupInt reads an integer value, returning 0 if the file doesn't exist, can't be parsed, is "max" or
+// negative (no limit).
+func readCgroupInt(filePath string) int64 {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(contents)), 10, 64)
+	if err != nil || value < 0 || value >= unlimitedCgroupValue {
+		return 0
+	}
+	return value
+}
+
+// readCgroupV2CPUs reads the `cpu.max` file, formatted as "<quota> <period>", where the quota may be "max".
+// It returns 0 if there is no limit.
+func readCgroupV2CPUs(filePath string) float64 {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(contents))
+	if len(fields) != 2 {
+		return 0
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || quota <= 0 {
+		return 0
+	}
+	period, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || period <= 0 {
+		return 0
+	}
+	return float64(quota) / float64(period)
+}
+
+// DefaultGoMaxProcs returns the GOMAXPROCS for the CPU limit -- rounded down, and at least 1 --, or 0 if there is no limit.
+func (l ResourceLimits) DefaultGoMaxProcs() int {
+	if l.CPUs <= 0 {
+		return 0
+	}
+	return min(max(int(math.Floor(l.CPUs)), 1), runtime.NumCPU())
+}
+
+// DefaultGoMemLimit returns the GOMEMLIMIT for the memory limit (see MemoryLimitFraction), or 0 if there is no limit.
+func (l ResourceLimits) DefaultGoMemLimit() int64 {
+	return int64(float64(l.MemoryBytes) * MemoryLimitFraction)
+}
+
+// defaultLimitValue returns the default value of the GOMAXPROCS or GOMEMLIMIT environment variable, or "" if
+// there is no limit.
+func (l ResourceLimits) defaultLimitValue(envVar string) string {
+	switch envVar {
+	case GoMaxProcsEnv:
+		if n := l.DefaultGoMaxProcs(); n > 0 {
+			return strconv.Itoa(n)
+		}
+	case GoMemLimitEnv:
+		if limit := l.DefaultGoMemLimit(); limit > 0 {
+			return formatMemLimit(limit)
+		}
+	}
+	return ""
+}
+
+// String describes the limits, e.g.: "cgroup v2: 1.5 CPUs, 2048MiB of memory".
+func (l ResourceLimits) String() string {
+	if l.Source == "" {
+		return "none found"
+	}
+	cpus, memory := "unlimited CPUs", "unlimited memory"
+	if l.CPUs > 0 {
+		cpus = strconv.FormatFloat(l.CPUs, 'f', -1, 64) + " CPUs"
+	}
+	if l.MemoryBytes > 0 {
+		memory = formatMemLimit(l.MemoryBytes) + " of memory"
+	}
+	return fmt.Sprintf("%s: %s, %s", l.Source, cpus, memory)
+}
+
+// formatMemLimit formats the number of bytes in the GOMEMLIMIT syntax, in MiB.
+func formatMemLimit(bytes int64) string {
+	return fmt.Sprintf("%dMiB", bytes>>20)
+}
+
+// ApplyResourceLimits sets GOMAXPROCS and GOMEMLIMIT to the defaults for the container limits -- in the
+// environment, so they are used by the programs executed, and in the runtime of the kernel itself --, unless
+// they are already set in the environment. It returns the limits detected.
+func ApplyResourceLimits() ResourceLimits {
+	limits := DetectResourceLimits()
+	if os.Getenv(GoMaxProcsEnv) == "" {
+		if n := limits.DefaultGoMaxProcs(); n > 0 {
+			runtime.GOMAXPROCS(n)
+			if err := os.Setenv(GoMaxProcsEnv, strconv.Itoa(n)); err != nil {
+				klog.Warningf("goexec: failed to set $%s: %+v", GoMaxProcsEnv, err)
+			}
+		}
+	}
+	if os.Getenv(GoMemLimitEnv) == "" {
+		if limit := limits.DefaultGoMemLimit(); limit > 0 {
+			debug.SetMemoryLimit(limit)
+			if err := os.Setenv(GoMemLimitEnv, formatMemLimit(limit)); err != nil {
+				klog.Warningf("goexec: failed to set $%s: %+v", GoMemLimitEnv, err)
+			}
+		}
+	}
+	if limits.Source != "" {
+		klog.Infof("Container limits (%s): %s=%q, %s=%q", limits, GoMaxProcsEnv, os.Getenv(GoMaxProcsEnv),
+			GoMemLimitEnv, os.Getenv(GoMemLimitEnv))
+	}
+	return limits
+}
+
+// regexpMemLimit matches the values accepted for GOMEMLIMIT, see `runtime/debug.SetMemoryLimit`.
+var regexpMemLimit = regexp.MustCompile(`^\d+(B|KiB|MiB|GiB|TiB)?$`)
+
+// SetResourceLimit sets the value of GOMAXPROCS or GOMEMLIMIT (envVar) for the programs executed, for the rest of
+// the session: value "auto" uses the default for the container limits, and "off" unsets it (so the Go defaults
+// are used). The change is also recorded in State.SessionEnv. The kernel itself is not affected.
+func (s *State) SetResourceLimit(envVar, value string) error {
+	switch value {
+	case "auto":
+		value = DetectResourceLimits().defaultLimitValue(envVar)
+	case "off":
+		value = ""
+	default:
+		switch envVar {
+		case GoMaxProcsEnv:
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				return errors.Errorf("invalid %s value %q: it must be a positive integer", envVar, value)
+			}
+		case GoMemLimitEnv:
+			if !regexpMemLimit.MatchString(value) {
+				return errors.Errorf("invalid %s value %q: it must be a number of bytes, with an optional unit "+
+					"(B, KiB, MiB, GiB or TiB), e.g.: 512MiB", envVar, value)
+			}
+		default:
+			return errors.Errorf("unknown resource limit %q", envVar)
+		}
+	}
+	var err error
+	if value == "" {
+		err = os.Unsetenv(envVar)
+	} else {
+		err = os.Setenv(envVar, value)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to set $%s", envVar)
+	}
+	if s.SessionEnv == nil {
+		s.SessionEnv = make(map[string]string)
+	}
+	s.SessionEnv[envVar] = value
+	return nil
+}
+
+// ResourceLimitsReport describes the container limits detected, and the current values of GOMAXPROCS and
+// GOMEMLIMIT used by the programs executed, displayed by `%limits`.
+func ResourceLimitsReport() string {
+	limits := DetectResourceLimits()
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "Container limits: %s\n", limits)
+	for _, envVar := range []string{GoMaxProcsEnv, GoMemLimitEnv} {
+		value := os.Getenv(envVar)
+		switch {
+		case value == "":
+			_, _ = fmt.Fprintf(&buf, "%s: not set (Go default)\n", envVar)
+		case value == limits.defaultLimitValue(envVar):
+			_, _ = fmt.Fprintf(&buf, "%s=%s (default for the container limits)\n", envVar, value)
+		default:
+			_, _ = fmt.Fprintf(&buf, "%s=%s\n", envVar, value)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,67 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeCgroupFiles(t *testing.T, root string, files map[string]string) {
+	for filePath, contents := range files {
+		filePath = path.Join(root, filePath)
+		require.NoError(t, os.MkdirAll(path.Dir(filePath), 0755))
+		require.NoError(t, os.WriteFile(filePath, []byte(contents+"\n"), 0644))
+	}
+}
+
+func TestDetectCgroupLimits(t *testing.T) {
+	// cgroup v2, with the memory limit set in the parent cgroup.
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"kubepods/pod1/cpu.max":    "150000 100000",
+		"kubepods/pod1/memory.max": "max",
+		"kubepods/memory.max":      "2147483648",
+		"cpu.max":                  "max 100000",
+	})
+	limits := detectCgroupLimits(root, "0::/kubepods/pod1\n")
+	assert.Equal(t, ResourceLimits{CPUs: 1.5, MemoryBytes: 2 << 30, Source: "cgroup v2"}, limits)
+	assert.Equal(t, 1, limits.DefaultGoMaxProcs())
+	assert.Equal(t, "1843MiB", limits.defaultLimitValue(GoMemLimitEnv))
+
+	// cgroup v1, with the cgroup path not visible inside the container.
+	root = t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"cpu,cpuacct/cpu.cfs_quota_us":  "50000",
+		"cpu,cpuacct/cpu.cfs_period_us": "100000",
+		"memory/memory.limit_in_bytes":  "9223372036854771712",
+	})
+	limits = detectCgroupLimits(root, "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n")
+	assert.Equal(t, ResourceLimits{CPUs: 0.5, Source: "cgroup v1"}, limits)
+	assert.Equal(t, 1, limits.DefaultGoMaxProcs())
+	assert.Equal(t, int64(0), limits.DefaultGoMemLimit())
+
+	// No limits.
+	limits = detectCgroupLimits(t.TempDir(), "0::/\n")
+	assert.Equal(t, ResourceLimits{}, limits)
+	assert.Equal(t, 0, limits.DefaultGoMaxProcs())
+	assert.Equal(t, "none found", limits.String())
+}
+
+func TestSetResourceLimit(t *testing.T) {
+	t.Setenv(GoMaxProcsEnv, "")
+	t.Setenv(GoMemLimitEnv, "")
+	s := &State{}
+	require.NoError(t, s.SetResourceLimit(GoMaxProcsEnv, "3"))
+	assert.Equal(t, "3", os.Getenv(GoMaxProcsEnv))
+	require.NoError(t, s.SetResourceLimit(GoMemLimitEnv, "512MiB"))
+	assert.Equal(t, "512MiB", os.Getenv(GoMemLimitEnv))
+	assert.Equal(t, map[string]string{GoMaxProcsEnv: "3", GoMemLimitEnv: "512MiB"}, s.SessionEnv)
+	require.NoError(t, s.SetResourceLimit(GoMaxProcsEnv, "off"))
+	_, found := os.LookupEnv(GoMaxProcsEnv)
+	assert.False(t, found)
+
+	require.Error(t, s.SetResourceLimit(GoMaxProcsEnv, "0"))
+	require.Error(t, s.SetResourceLimit(GoMemLimitEnv, "1.5GB"))
+}
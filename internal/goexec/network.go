@@ -0,0 +1,500 @@
+package goexec
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// This file implements the networking configuration (proxy, custom certificate authorities and the
+// Go module settings) used by the `go` commands, the shell commands and the programs executed by
+// the kernel, and by the kernel's own HTTP requests.
+//
+// Everything is configured through the standard environment variables, so it is applied consistently
+// to every subprocess. It can be given when the kernel starts (see NetworkConfig) or changed from the
+// notebook with `%proxy`.
+
+// NetworkConfig holds the networking configuration of the kernel. Empty fields are left as they are
+// in the environment.
+type NetworkConfig struct {
+	// Proxy is the URL of the HTTP(S) proxy, set in HTTP_PROXY and HTTPS_PROXY.
+	Proxy string
+
+	// NoProxy is the comma-separated list of hosts (or domains) not to use the proxy for, set in NO_PROXY.
+	NoProxy string
+
+	// CAFile is a PEM file with additional certificate authorities to trust (e.g.: a corporate one).
+	// It is recorded in CAFileEnv, and since SSL_CERT_FILE (used by Go and OpenSSL) and GIT_SSL_CAINFO
+	// (used by `git`, when `go get` downloads modules directly) replace the system certificates, these
+	// are set to a bundle with the system certificates plus the ones in CAFile, see writeCABundle.
+	CAFile string
+
+	// GoProxy, GoPrivate and GoInsecure are set in GOPROXY, GOPRIVATE and GOINSECURE respectively.
+	// See `go help environment`.
+	GoProxy, GoPrivate, GoInsecure string
+}
+
+// CAFileEnv is the environment variable holding NetworkConfig.CAFile.
+const CAFileEnv = "GONB_CA_FILE"
+
+// caBundleEnvVars are the environment variables set to the bundle of certificate authorities, when
+// NetworkConfig.CAFile is set.
+var caBundleEnvVars = []string{"SSL_CERT_FILE", "GIT_SSL_CAINFO"}
+
+// NetworkEnvVars maps each field of NetworkConfig (by name) to the environment variables it sets.
+// For CAFile, the first one (CAFileEnv) holds the file given, and the others the bundle created from it.
+var NetworkEnvVars = []struct {
+	Field string
+	Vars  []string
+}{
+	{"Proxy", []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"}},
+	{"NoProxy", []string{"NO_PROXY", "no_proxy"}},
+	{"CAFile", append([]string{CAFileEnv}, caBundleEnvVars...)},
+	{"GoProxy", []string{"GOPROXY"}},
+	{"GoPrivate", []string{"GOPRIVATE"}},
+	{"GoInsecure", []string{"GOINSECURE"}},
+}
+
+// field returns a pointer to the field of NetworkConfig with the given name.
+func (c *NetworkConfig) field(name string) *string {
+	switch name {
+	case "Proxy":
+		return &c.Proxy
+	case "NoProxy":
+		return &c.NoProxy
+	case "CAFile":
+		return &c.CAFile
+	case "GoProxy":
+		return &c.GoProxy
+	case "GoPrivate":
+		return &c.GoPrivate
+	case "GoInsecure":
+		return &c.GoInsecure
+	}
+	klog.Fatalf("NetworkConfig has no field %q", name)
+	return nil
+}
+
+// String lists the configuration, one field per line, with the environment variables they set.
+func (c NetworkConfig) String() string {
+	var parts strings.Builder
+	parts.WriteString("Network configuration:\n")
+	for _, entry := range NetworkEnvVars {
+		value := *c.field(entry.Field)
+		if value == "" {
+			value = "(not set)"
+		}
+		_, _ = fmt.Fprintf(&parts, "\t%-11s %s ($%s)\n", entry.Field+":", value, strings.Join(entry.Vars, ", $"))
+	}
+	return parts.String()
+}
+
+// Validate checks that the proxy is a valid URL and that the CA file holds valid certificates.
+func (c *NetworkConfig) Validate() error {
+	if c.Proxy != "" {
+		if _, err := parseProxyURL(c.Proxy); err != nil {
+			return err
+		}
+	}
+	if c.CAFile != "" {
+		if _, err := caBundle(c.CAFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyNetworkConfig validates the configuration and sets the corresponding environment variables
+// of the kernel process, to be inherited by every command it executes.
+func ApplyNetworkConfig(config NetworkConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	for _, entry := range NetworkEnvVars {
+		value := *config.field(entry.Field)
+		if value == "" {
+			continue
+		}
+		values, err := networkEnvValues(entry.Field, value, os.TempDir())
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			if err := os.Setenv(key, value); err != nil {
+				return errors.Wrapf(err, "failed to set $%s", key)
+			}
+		}
+	}
+	return nil
+}
+
+// networkEnvValues returns the environment variables to set for the NetworkConfig field. For CAFile, the
+// bundle of certificate authorities is written to bundleDir.
+func networkEnvValues(field, value, bundleDir string) (map[string]string, error) {
+	values := make(map[string]string)
+	if field == "CAFile" {
+		bundlePath, err := writeCABundle(bundleDir, value)
+		if err != nil {
+			return nil, err
+		}
+		values[CAFileEnv] = value
+		for _, key := range caBundleEnvVars {
+			values[key] = bundlePath
+		}
+		return values, nil
+	}
+	for _, entry := range NetworkEnvVars {
+		if entry.Field == field {
+			for _, key := range entry.Vars {
+				values[key] = value
+			}
+		}
+	}
+	return values, nil
+}
+
+// CurrentNetworkConfig returns the networking configuration in the environment.
+func CurrentNetworkConfig() NetworkConfig {
+	var config NetworkConfig
+	for _, entry := range NetworkEnvVars {
+		for _, key := range entry.Vars {
+			if value := os.Getenv(key); value != "" {
+				*config.field(entry.Field) = value
+				break
+			}
+		}
+	}
+	return config
+}
+
+// SetNetworkField sets (or clears, if `value` is empty) the environment variables of the given
+// NetworkConfig field, for the rest of the session. The change is also recorded in State.SessionEnv.
+//
+// For CAFile, the bundle of certificate authorities is written to State.TempDir, and only CAFileEnv is
+// recorded: the bundle is recreated from it when the session is restored.
+func (s *State) SetNetworkField(field, value string) error {
+	config := NetworkConfig{}
+	*config.field(field) = value
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	for _, entry := range NetworkEnvVars {
+		if entry.Field != field {
+			continue
+		}
+		if value == "" {
+			for _, key := range entry.Vars {
+				if err := os.Unsetenv(key); err != nil {
+					return errors.Wrapf(err, "failed to unset $%s", key)
+				}
+				delete(s.SessionEnv, key)
+			}
+			continue
+		}
+		values, err := networkEnvValues(field, value, s.TempDir)
+		if err != nil {
+			return err
+		}
+		if s.SessionEnv == nil {
+			s.SessionEnv = make(map[string]string)
+		}
+		for _, key := range entry.Vars {
+			if err = os.Setenv(key, values[key]); err != nil {
+				return errors.Wrapf(err, "failed to set $%s", key)
+			}
+			if field == "CAFile" && key != CAFileEnv {
+				continue
+			}
+			s.SessionEnv[key] = values[key]
+		}
+	}
+	return nil
+}
+
+// parseProxyURL parses the proxy URL, defaulting to "http://" if no scheme is given, as Go does.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+		if proxyURL, err = url.Parse("http://" + proxy); err != nil || proxyURL.Host == "" {
+			return nil, errors.Errorf("invalid proxy URL %q", proxy)
+		}
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Errorf("invalid proxy URL %q: scheme %q not supported", proxy, proxyURL.Scheme)
+	}
+	return proxyURL, nil
+}
+
+// systemCertFiles are the locations of the system certificate authorities in the various systems (the same
+// used by Go's crypto/x509), and systemCertDirs the directories searched if none of the files exist.
+var (
+	systemCertFiles = []string{
+		"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+		"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+		"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+		"/etc/pki/tls/cacert.pem",                           // OpenELEC
+		"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+		"/etc/ssl/cert.pem",                                 // Alpine Linux, macOS and BSDs
+	}
+	systemCertDirs = []string{"/etc/ssl/certs", "/etc/pki/tls/certs"}
+
+	// originalSSLCertFile is the value of $SSL_CERT_FILE when the kernel started, before it was replaced by
+	// a bundle: if set, it holds the certificate authorities trusted instead of the system ones.
+	originalSSLCertFile = os.Getenv("SSL_CERT_FILE")
+)
+
+// systemCertsPEM returns the system certificate authorities in PEM format, or nil if they were not found.
+func systemCertsPEM() []byte {
+	files := systemCertFiles
+	if originalSSLCertFile != "" {
+		files = []string{originalSSLCertFile}
+	}
+	for _, filePath := range files {
+		if contents, err := os.ReadFile(filePath); err == nil && len(contents) > 0 {
+			return contents
+		}
+	}
+	var bundle []byte
+	for _, dir := range systemCertDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if ext := path.Ext(entry.Name()); ext != ".pem" && ext != ".crt" {
+				continue
+			}
+			if contents, err := os.ReadFile(path.Join(dir, entry.Name())); err == nil {
+				bundle = append(bundle, contents...)
+				bundle = append(bundle, '\n')
+			}
+		}
+		if len(bundle) > 0 {
+			return bundle
+		}
+	}
+	return nil
+}
+
+// caBundle returns the system certificate authorities followed by the ones in caFile, in PEM format.
+// It fails if caFile doesn't hold any valid certificate.
+func caBundle(caFile string) ([]byte, error) {
+	contents, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read certificate authorities file %q", caFile)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(contents) {
+		return nil, errors.Errorf("no valid PEM certificates found in %q", caFile)
+	}
+	system := systemCertsPEM()
+	if len(system) == 0 {
+		klog.Warningf("goexec: system certificate authorities not found, trusting only the ones in %q", caFile)
+	}
+	bundle := make([]byte, 0, len(system)+len(contents)+1)
+	bundle = append(bundle, system...)
+	if len(bundle) > 0 && bundle[len(bundle)-1] != '\n' {
+		bundle = append(bundle, '\n')
+	}
+	return append(bundle, contents...), nil
+}
+
+// writeCABundle writes the bundle of certificate authorities (see caBundle) to dir, and returns its path.
+// Its name is derived from its contents, so bundles of different configurations don't overwrite each other.
+func writeCABundle(dir, caFile string) (string, error) {
+	bundle, err := caBundle(caFile)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(bundle)
+	bundlePath := path.Join(dir, fmt.Sprintf("gonb_ca_bundle_%x.pem", hash[:8]))
+	if err = os.WriteFile(bundlePath, bundle, 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to write certificate authorities bundle %q", bundlePath)
+	}
+	return bundlePath, nil
+}
+
+// loadCABundle returns a certificate pool with only the certificates in the PEM file: the same certificate
+// authorities trusted by the `go` commands and the programs, when it is set in $SSL_CERT_FILE.
+func loadCABundle(bundlePath string) (*x509.CertPool, error) {
+	contents, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read certificate authorities file %q", bundlePath)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, errors.Errorf("no valid PEM certificates found in %q", bundlePath)
+	}
+	return pool, nil
+}
+
+// proxyForRequest implements http.Transport.Proxy using the current environment.
+// Unlike http.ProxyFromEnvironment, it doesn't cache the environment, so changes made with `%proxy`
+// are taken into account.
+func proxyForRequest(req *http.Request) (*url.URL, error) {
+	config := CurrentNetworkConfig()
+	if config.Proxy == "" || matchNoProxy(config.NoProxy, req.URL.Hostname(), req.URL.Port()) {
+		return nil, nil
+	}
+	return parseProxyURL(config.Proxy)
+}
+
+// matchNoProxy returns whether the host (with optional port) matches the comma-separated NO_PROXY
+// list, which can include "*", host names (also matching their subdomains), domains starting
+// with ".", IP addresses and CIDR ranges, optionally with a port.
+func matchNoProxy(noProxy, host, port string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip := net.ParseIP(host); ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryHost, entryPort, err := net.SplitHostPort(entry); err == nil {
+			if entryPort != port {
+				continue
+			}
+			entry = entryHost
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		if host == entry || host == strings.TrimPrefix(entry, ".") ||
+			strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkHTTPClient returns an HTTP client configured with the current networking configuration
+// (proxy and certificate authorities), to be used by any HTTP request made by the kernel itself.
+func NetworkHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyForRequest
+	if bundlePath := os.Getenv("SSL_CERT_FILE"); bundlePath != "" {
+		// Validate the same certificate authorities `go get` will use.
+		pool, err := loadCABundle(bundlePath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport, Timeout: NetworkCheckTimeout}, nil
+}
+
+// NetworkCheckTimeout is the deadline of each request made by CheckNetwork.
+var NetworkCheckTimeout = 15 * time.Second
+
+// CheckNetwork displays the networking configuration and tests connecting to the Go module proxies
+// and checksum database that `go get` will use, with hints on how to fix the most common problems.
+func (s *State) CheckNetwork(msg kernel.Message) error {
+	var report strings.Builder
+	config := CurrentNetworkConfig()
+	report.WriteString(config.String())
+	if err := config.Validate(); err != nil {
+		_, _ = fmt.Fprintf(&report, "\n❌ %v\n", err)
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+	}
+
+	goProxy, goSumDB, proxies, sumDBURL, err := s.moduleEndpoints(msg)
+	if err != nil {
+		return err
+	}
+	targets := proxies
+	if sumDBURL != "" {
+		targets = append(targets, sumDBURL)
+	}
+	_, _ = fmt.Fprintf(&report, "\nGOPROXY=%q, GOSUMDB=%q\n", goProxy, goSumDB)
+
+	client, err := NetworkHTTPClient()
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		line, _ := checkNetworkTarget(client, target)
+		report.WriteString(line)
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+}
+
+// moduleEndpoints returns the effective GOPROXY and GOSUMDB (which can also be set with `go env -w`), and the
+// URLs of the module proxies and checksum database they point to. sumDBURL is empty if GOSUMDB is off.
+func (s *State) moduleEndpoints(msg kernel.Message) (goProxy, goSumDB string, proxies []string, sumDBURL string, err error) {
+	cmd := exec.Command("go", "env", "GOPROXY", "GOSUMDB")
+	cmd.Dir = s.TempDir
+	output, err := runWithWatchdog(msg, GoGetTimeout, cmd)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to run %q: %s", cmd, output)
+		return
+	}
+	goEnv := strings.Split(strings.TrimSpace(string(output)), "\n")
+	for len(goEnv) < 2 {
+		goEnv = append(goEnv, "")
+	}
+	goProxy, goSumDB = goEnv[0], goEnv[1]
+	for _, entry := range strings.FieldsFunc(goProxy, func(r rune) bool { return r == ',' || r == '|' }) {
+		if strings.HasPrefix(entry, "http://") || strings.HasPrefix(entry, "https://") {
+			proxies = append(proxies, entry)
+		}
+	}
+	if sumDB := strings.Fields(goSumDB); len(sumDB) > 0 && sumDB[0] != "off" {
+		sumDBURL = sumDB[0]
+		if len(sumDB) > 1 {
+			sumDBURL = sumDB[1] // Explicit URL.
+		} else if !strings.Contains(sumDBURL, "://") {
+			sumDBURL = "https://" + strings.SplitN(sumDBURL, "+", 2)[0]
+		}
+	}
+	return
+}
+
+// checkNetworkTarget makes a HEAD request to `target` and returns a line describing the result, and whether
+// it succeeded.
+func checkNetworkTarget(client *http.Client, target string) (line string, ok bool) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, target, nil)
+	if err != nil {
+		return fmt.Sprintf("❌ %s: invalid URL: %v\n", target, err), false
+	}
+	via := "direct"
+	if proxyURL, _ := proxyForRequest(req); proxyURL != nil {
+		via = "via proxy " + proxyURL.Redacted()
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		hint := ""
+		errMsg := err.Error()
+		switch {
+		case strings.Contains(errMsg, "x509:") || strings.Contains(errMsg, "certificate"):
+			hint = " -- if a corporate proxy intercepts TLS, set its certificate authority with `%proxy ca <file.pem>`"
+		case strings.Contains(errMsg, "proxyconnect"):
+			hint = " -- the proxy is unreachable, check `%proxy <url>`"
+		case strings.Contains(errMsg, "no such host") || strings.Contains(errMsg, "Client.Timeout"):
+			hint = " -- if a proxy is required, set it with `%proxy <url>`"
+		}
+		return fmt.Sprintf("❌ %s (%s): %v%s\n", target, via, err, hint), false
+	}
+	_ = resp.Body.Close()
+	return fmt.Sprintf("✅ %s (%s): %s in %s\n", target, via, resp.Status, time.Since(start).Round(time.Millisecond)), true
+}
@@ -0,0 +1,136 @@
+package goexec
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestMatchNoProxy(t *testing.T) {
+	noProxy := "localhost, .corp.example.com,example.org,10.0.0.0/8,internal:8080"
+	assert.True(t, matchNoProxy(noProxy, "localhost", ""))
+	assert.True(t, matchNoProxy(noProxy, "git.corp.example.com", "443"))
+	assert.True(t, matchNoProxy(noProxy, "corp.example.com", ""))
+	assert.True(t, matchNoProxy(noProxy, "www.example.org", ""))
+	assert.True(t, matchNoProxy(noProxy, "10.1.2.3", ""))
+	assert.True(t, matchNoProxy(noProxy, "internal", "8080"))
+	assert.False(t, matchNoProxy(noProxy, "internal", "443"))
+	assert.False(t, matchNoProxy(noProxy, "proxy.golang.org", ""))
+	assert.False(t, matchNoProxy(noProxy, "notexample.org", ""))
+	assert.True(t, matchNoProxy("*", "proxy.golang.org", ""))
+}
+
+func TestNetworkConfig(t *testing.T) {
+	for _, entry := range NetworkEnvVars {
+		for _, key := range entry.Vars {
+			t.Setenv(key, "") // Restored at the end of the test.
+		}
+	}
+	s := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s.Stop(), "Failed to finalized state")
+	}()
+
+	require.Error(t, s.SetNetworkField("Proxy", "ftp://proxy:21"))
+	require.NoError(t, s.SetNetworkField("Proxy", "proxy.corp:3128"))
+	require.NoError(t, s.SetNetworkField("NoProxy", "localhost"))
+	assert.Equal(t, "proxy.corp:3128", os.Getenv("HTTPS_PROXY"))
+	assert.Equal(t, "proxy.corp:3128", os.Getenv("http_proxy"))
+	assert.Equal(t, "proxy.corp:3128", s.SessionEnv["HTTP_PROXY"])
+	assert.Equal(t, NetworkConfig{Proxy: "proxy.corp:3128", NoProxy: "localhost"}, CurrentNetworkConfig())
+
+	req, err := http.NewRequest(http.MethodGet, "https://proxy.golang.org/", nil)
+	require.NoError(t, err)
+	proxyURL, err := proxyForRequest(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.corp:3128", proxyURL.String())
+	req, err = http.NewRequest(http.MethodGet, "http://localhost:8888/", nil)
+	require.NoError(t, err)
+	proxyURL, err = proxyForRequest(req)
+	require.NoError(t, err)
+	assert.Nil(t, proxyURL)
+
+	// CA files must hold valid certificates.
+	caFile := path.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+	require.Error(t, ApplyNetworkConfig(NetworkConfig{CAFile: caFile}))
+	assert.Empty(t, os.Getenv("SSL_CERT_FILE"))
+
+	require.NoError(t, s.SetNetworkField("Proxy", ""))
+	assert.Empty(t, os.Getenv("HTTPS_PROXY"))
+	assert.Empty(t, CurrentNetworkConfig().Proxy)
+	assert.NotContains(t, s.SessionEnv, "HTTP_PROXY")
+}
+
+// writeTestCertificate writes a self-signed certificate in PEM format to filePath.
+func writeTestCertificate(t *testing.T, filePath, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	contents := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	require.NoError(t, os.WriteFile(filePath, contents, 0600))
+	return contents
+}
+
+func TestCABundle(t *testing.T) {
+	for _, entry := range NetworkEnvVars {
+		for _, key := range entry.Vars {
+			t.Setenv(key, "") // Restored at the end of the test.
+		}
+	}
+	tmpDir := t.TempDir()
+	systemPEM := writeTestCertificate(t, path.Join(tmpDir, "system.pem"), "system")
+	corpPEM := writeTestCertificate(t, path.Join(tmpDir, "corp.pem"), "corp")
+	savedFiles, savedOriginal := systemCertFiles, originalSSLCertFile
+	systemCertFiles, originalSSLCertFile = []string{path.Join(tmpDir, "missing.pem"), path.Join(tmpDir, "system.pem")}, ""
+	defer func() { systemCertFiles, originalSSLCertFile = savedFiles, savedOriginal }()
+
+	s := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s.Stop(), "Failed to finalized state")
+	}()
+	caFile := path.Join(tmpDir, "corp.pem")
+	require.NoError(t, s.SetNetworkField("CAFile", caFile))
+
+	// The CA file is recorded, and Go and git use a bundle with the system certificates plus the CA file.
+	assert.Equal(t, caFile, os.Getenv(CAFileEnv))
+	assert.Equal(t, caFile, CurrentNetworkConfig().CAFile)
+	bundlePath := os.Getenv("SSL_CERT_FILE")
+	assert.Equal(t, s.TempDir, path.Dir(bundlePath))
+	assert.Equal(t, bundlePath, os.Getenv("GIT_SSL_CAINFO"))
+	bundle, err := os.ReadFile(bundlePath)
+	require.NoError(t, err)
+	assert.Equal(t, string(systemPEM)+string(corpPEM), string(bundle))
+	pool, err := loadCABundle(bundlePath)
+	require.NoError(t, err)
+	assert.Len(t, pool.Subjects(), 2)
+
+	// Only the CA file is recorded in the session: the bundle is recreated when restoring.
+	assert.Equal(t, map[string]string{CAFileEnv: caFile}, s.SessionEnv)
+
+	// Clearing removes everything.
+	require.NoError(t, s.SetNetworkField("CAFile", ""))
+	assert.Empty(t, os.Getenv("SSL_CERT_FILE"))
+	assert.Empty(t, os.Getenv(CAFileEnv))
+	assert.Empty(t, s.SessionEnv)
+}
@@ -0,0 +1,315 @@
+package goexec
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// This file implements the environment of each notebook, so several notebooks can run without
+// interfering with each other:
+//
+//   - The variables set with `%env` are persisted (in a file per notebook, see NotebookEnvDir), and set
+//     again when the kernel of the notebook restarts.
+//   - A `.env` file in the notebook directory is loaded when the kernel starts.
+//   - Optionally (`%config modules.isolated=on`), the notebook uses its own Go module cache (`GOMODCACHE`).
+
+const (
+	// NotebookEnvDirEnv can be set to change the base directory where the environment of the notebooks is
+	// saved. It defaults to `gonb/env` under the user cache directory (see os.UserCacheDir).
+	NotebookEnvDirEnv = "GONB_NOTEBOOK_ENV_DIR"
+
+	// DotEnvFile is loaded from the notebook directory when the kernel starts.
+	DotEnvFile = ".env"
+
+	// notebookEnvFile is the name of the file, in NotebookEnvDir, with the persisted environment.
+	notebookEnvFile = "env.json"
+)
+
+// NotebookEnv is what is saved to disk about the environment of a notebook.
+type NotebookEnv struct {
+	// Env holds the environment variables set with `%env`.
+	Env map[string]string `json:"env,omitempty"`
+
+	// IsolatedModules is set with `%config modules.isolated=on`.
+	IsolatedModules bool `json:"isolated_modules,omitempty"`
+}
+
+// notebookEnvState is a substructure of State with the bookkeeping of the notebook environment.
+type notebookEnvState struct {
+	// dir where the environment of the notebook is saved. If empty, it is not persisted.
+	dir string
+
+	// saved is the environment persisted.
+	saved NotebookEnv
+
+	// isolatedModules is set when the notebook uses its own module cache, and previousModCache holds the value
+	// of `GOMODCACHE` before (nil if it was not set).
+	isolatedModules  bool
+	previousModCache *string
+}
+
+// NotebookEnvDir returns the directory where the environment of the current notebook is saved.
+func NotebookEnvDir() (string, error) {
+	return notebookDir(NotebookEnvDirEnv, "env")
+}
+
+// EnableNotebookEnv loads the `.env` file from the current directory (where Jupyter starts the kernel of
+// the notebook) and the persisted environment of the notebook, and enables persisting the changes made
+// with `%env` and `%config modules.isolated`.
+//
+// It is disabled by default: it is enabled for the kernel of a notebook (or the console), and not for
+// sessions that are not associated to a notebook (e.g.: the HTTP API).
+func (s *State) EnableNotebookEnv() {
+	if err := s.loadDotEnv(DotEnvFile); err != nil {
+		klog.Warningf("Failed to load %q: %+v", DotEnvFile, err)
+	}
+	dir, err := NotebookEnvDir()
+	if err != nil {
+		klog.Warningf("Notebook environment won't be persisted: %+v", err)
+		return
+	}
+	s.notebookEnv.dir = dir
+	contents, err := os.ReadFile(path.Join(dir, notebookEnvFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Failed to read notebook environment: %+v", err)
+		}
+		return
+	}
+	var saved NotebookEnv
+	if err = json.Unmarshal(contents, &saved); err != nil {
+		klog.Warningf("Failed to parse notebook environment in %q: %+v", dir, err)
+		return
+	}
+	for _, key := range common.SortedKeys(saved.Env) {
+		if err := s.setSessionEnv(key, saved.Env[key]); err != nil {
+			klog.Warningf("Failed to restore notebook environment: %+v", err)
+		}
+	}
+	if saved.IsolatedModules {
+		if err := s.isolateModules(); err != nil {
+			klog.Warningf("Failed to isolate the Go modules of the notebook: %+v", err)
+		} else if s.gopls != nil {
+			// Started before the module cache was set.
+			s.gopls.Restart()
+		}
+	}
+	s.notebookEnv.saved = saved
+	klog.Infof("Restored notebook environment: %d variables, isolated modules=%v", len(saved.Env), saved.IsolatedModules)
+}
+
+// saveNotebookEnv persists the environment of the notebook, if enabled.
+func (s *State) saveNotebookEnv() error {
+	if s.notebookEnv.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.notebookEnv.dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory for the notebook environment")
+	}
+	contents, err := json.MarshalIndent(&s.notebookEnv.saved, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize notebook environment")
+	}
+	filePath := path.Join(s.notebookEnv.dir, notebookEnvFile)
+	if err = os.WriteFile(filePath, contents, 0600); err != nil {
+		return errors.Wrapf(err, "failed to save notebook environment to %q", filePath)
+	}
+	return nil
+}
+
+// setSessionEnv sets the environment variable for the kernel (and the programs it executes), and records it
+// in SessionEnv.
+func (s *State) setSessionEnv(key, value string) error {
+	if err := os.Setenv(key, value); err != nil {
+		return errors.Wrapf(err, "failed to set environment variable %q", key)
+	}
+	if s.SessionEnv == nil {
+		s.SessionEnv = make(map[string]string)
+	}
+	s.SessionEnv[key] = value
+	return nil
+}
+
+// SetNotebookEnv sets the environment variable (`%env`), and persists it for the next sessions of the notebook.
+func (s *State) SetNotebookEnv(key, value string) error {
+	if err := s.setSessionEnv(key, value); err != nil {
+		return err
+	}
+	if s.notebookEnv.saved.Env == nil {
+		s.notebookEnv.saved.Env = make(map[string]string)
+	}
+	s.notebookEnv.saved.Env[key] = value
+	return s.saveNotebookEnv()
+}
+
+// UnsetNotebookEnv removes the environment variable (`%env --unset`), also from the next sessions of the notebook.
+func (s *State) UnsetNotebookEnv(key string) error {
+	if err := os.Unsetenv(key); err != nil {
+		return errors.Wrapf(err, "failed to unset environment variable %q", key)
+	}
+	delete(s.SessionEnv, key)
+	delete(s.notebookEnv.saved.Env, key)
+	return s.saveNotebookEnv()
+}
+
+// IsPersistedEnv returns whether the environment variable is persisted for the next sessions of the notebook.
+func (s *State) IsPersistedEnv(key string) bool {
+	_, found := s.notebookEnv.saved.Env[key]
+	return found && s.notebookEnv.dir != ""
+}
+
+// IsolatedModules returns whether the notebook uses its own Go module cache, see SetIsolatedModules.
+func (s *State) IsolatedModules() bool {
+	return s.notebookEnv.isolatedModules
+}
+
+// SetIsolatedModules configures whether the notebook uses its own Go module cache (`GOMODCACHE`), under
+// NotebookEnvDir, so the modules downloaded (and the changes to them) don't affect other notebooks.
+// The setting is persisted for the next sessions of the notebook.
+func (s *State) SetIsolatedModules(isolated bool) error {
+	if isolated == s.IsolatedModules() {
+		return nil
+	}
+	if isolated {
+		if err := s.isolateModules(); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if previous := s.notebookEnv.previousModCache; previous == nil {
+			err = os.Unsetenv("GOMODCACHE")
+		} else {
+			err = os.Setenv("GOMODCACHE", *previous)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to restore GOMODCACHE")
+		}
+		s.notebookEnv.isolatedModules = false
+	}
+	if s.gopls != nil {
+		// So it uses the new module cache.
+		s.gopls.Restart()
+	}
+	s.notebookEnv.saved.IsolatedModules = isolated
+	return s.saveNotebookEnv()
+}
+
+// isolateModules sets `GOMODCACHE` to a directory of the notebook.
+func (s *State) isolateModules() error {
+	dir := s.notebookEnv.dir
+	if dir == "" {
+		var err error
+		if dir, err = NotebookEnvDir(); err != nil {
+			return err
+		}
+	}
+	modCache := path.Join(dir, "gomodcache")
+	if err := os.MkdirAll(modCache, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create notebook module cache")
+	}
+	s.notebookEnv.previousModCache = nil
+	if value, found := os.LookupEnv("GOMODCACHE"); found {
+		s.notebookEnv.previousModCache = &value
+	}
+	if err := os.Setenv("GOMODCACHE", modCache); err != nil {
+		return errors.Wrapf(err, "failed to set GOMODCACHE")
+	}
+	s.notebookEnv.isolatedModules = true
+	klog.Infof("Notebook modules isolated in GOMODCACHE=%q", modCache)
+	return nil
+}
+
+// loadDotEnv loads the environment variables defined in the given file, if it exists. See parseDotEnv for
+// the format. The variables are recorded in SessionEnv, but not persisted: the file is read at every start.
+func (s *State) loadDotEnv(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to open %q", filePath)
+	}
+	defer func() { _ = f.Close() }()
+	vars, err := parseDotEnv(f)
+	if err != nil {
+		return errors.WithMessagef(err, "in %q", filePath)
+	}
+	for _, kv := range vars {
+		if err := s.setSessionEnv(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	klog.Infof("Loaded %d environment variables from %q", len(vars), filePath)
+	return nil
+}
+
+// parseDotEnv parses the contents of a `.env` file, returning the key/value pairs in order.
+//
+// Each line is `KEY=value`, optionally prefixed with `export`. Empty lines and lines starting with `#` are
+// ignored. Values can be single-quoted (taken literally), or double-quoted (where `\n`, `\"` and `\\` are
+// unescaped); unquoted values end at a ` #` comment. References to `$VAR` or `${VAR}` in unquoted and
+// double-quoted values are expanded, including variables defined in previous lines.
+func parseDotEnv(r io.Reader) (vars [][2]string, err error) {
+	defined := make(map[string]string)
+	expand := func(value string) string {
+		return os.Expand(value, func(key string) string {
+			if value, found := defined[key]; found {
+				return value
+			}
+			return os.Getenv(key)
+		})
+	}
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || !isEnvName(key) {
+			return nil, errors.Errorf("line %d: invalid `KEY=value` definition %q", lineNum, line)
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && strings.HasSuffix(value, "'"):
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"' && strings.HasSuffix(value, `"`):
+			value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+			value = expand(value)
+		default:
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+			value = expand(value)
+		}
+		defined[key] = value
+		vars = append(vars, [2]string{key, value})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read")
+	}
+	return vars, nil
+}
+
+// isEnvName returns whether name is a valid environment variable name.
+func isEnvName(name string) bool {
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
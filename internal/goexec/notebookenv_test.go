@@ -0,0 +1,84 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	t.Setenv("GONB_DOTENV_HOME", "/home/gonb")
+	vars, err := parseDotEnv(strings.NewReader(`
+# Comment.
+A=1
+export B = two words # Trailing comment.
+C='literal $A # not a comment'
+D="line\nbreak ${A} \"quoted\""
+E=$GONB_DOTENV_HOME/data
+F=
+`))
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{
+		{"A", "1"},
+		{"B", "two words"},
+		{"C", "literal $A # not a comment"},
+		{"D", "line\nbreak 1 \"quoted\""},
+		{"E", "/home/gonb/data"},
+		{"F", ""},
+	}, vars)
+
+	_, err = parseDotEnv(strings.NewReader("A=1\nnot a definition\n"))
+	require.ErrorContains(t, err, "line 2")
+	_, err = parseDotEnv(strings.NewReader("1A=1\n"))
+	require.Error(t, err)
+}
+
+func TestNotebookEnv(t *testing.T) {
+	t.Setenv(NotebookEnvDirEnv, t.TempDir())
+	t.Setenv(jupyterSessionNameEnv, "notebookenv_test.ipynb")
+	t.Setenv("GONB_NOTEBOOK_ENV_TEST", "") // Restored at the end of the test.
+	t.Setenv("GONB_DOTENV_TEST", "")
+	t.Setenv("GOMODCACHE", "/original/gomodcache")
+
+	// Current directory of the kernel is where `.env` is loaded from.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	notebookDir := t.TempDir()
+	require.NoError(t, os.Chdir(notebookDir))
+	defer func() { _ = os.Chdir(wd) }()
+	require.NoError(t, os.WriteFile(path.Join(notebookDir, DotEnvFile), []byte("GONB_DOTENV_TEST=from_file\n"), 0600))
+
+	s := newEmptyState(t)
+	s.EnableNotebookEnv()
+	assert.Equal(t, "from_file", os.Getenv("GONB_DOTENV_TEST"))
+	assert.Equal(t, "from_file", s.SessionEnv["GONB_DOTENV_TEST"])
+	assert.False(t, s.IsPersistedEnv("GONB_DOTENV_TEST"))
+	require.NoError(t, s.SetNotebookEnv("GONB_NOTEBOOK_ENV_TEST", "persisted"))
+	assert.True(t, s.IsPersistedEnv("GONB_NOTEBOOK_ENV_TEST"))
+	require.NoError(t, s.SetIsolatedModules(true))
+	modCache := os.Getenv("GOMODCACHE")
+	assert.NotEqual(t, "/original/gomodcache", modCache)
+	require.NoError(t, s.Stop())
+
+	// New session of the same notebook: the environment is restored.
+	require.NoError(t, os.Setenv("GONB_NOTEBOOK_ENV_TEST", ""))
+	require.NoError(t, os.Setenv("GOMODCACHE", "/original/gomodcache"))
+	s2 := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s2.Stop(), "Failed to finalized state")
+	}()
+	s2.EnableNotebookEnv()
+	assert.Equal(t, "persisted", os.Getenv("GONB_NOTEBOOK_ENV_TEST"))
+	assert.True(t, s2.IsolatedModules())
+	assert.Equal(t, modCache, os.Getenv("GOMODCACHE"))
+
+	// Unset and disable isolation.
+	require.NoError(t, s2.UnsetNotebookEnv("GONB_NOTEBOOK_ENV_TEST"))
+	_, found := os.LookupEnv("GONB_NOTEBOOK_ENV_TEST")
+	assert.False(t, found)
+	require.NoError(t, s2.SetIsolatedModules(false))
+	assert.Equal(t, "/original/gomodcache", os.Getenv("GOMODCACHE"))
+}
@@ -0,0 +1,352 @@
+package goexec
+
+import (
+	"bytes"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"html/template"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// This file implements the parsing of the output of Go runtime crashes (panics and fatal errors) of the
+// program, and its rendering as grouped, collapsible frames, with the frames of the cells highlighted.
+
+// panicReport is the structured model of the output of a Go runtime crash.
+type panicReport struct {
+	// Message lines before the first goroutine: the panic value (including recovered and re-panicked ones) or
+	// the fatal error, and the signal information, if any.
+	Message []string
+
+	Goroutines []*panicGoroutine
+
+	// Trailer lines that follow the goroutines, and couldn't be parsed (e.g.: output of other threads).
+	Trailer []string
+}
+
+// panicGoroutine is one goroutine of the stack trace.
+type panicGoroutine struct {
+	// Header is the goroutine description, e.g.: "goroutine 1 [running]".
+	Header string
+
+	Frames []*panicFrame
+
+	// CreatedBy is the frame where the goroutine was created, if reported.
+	CreatedBy *panicFrame
+}
+
+// panicFrame is one frame of a goroutine stack trace.
+type panicFrame struct {
+	// Function called, including its arguments, e.g.: "main.f(0x1, ...)".
+	Function string
+
+	// File and Line of the frame. File is empty for elided frames.
+	File string
+	Line int
+
+	// CellId and CellLine (starting from 1) of the frame, if it is in the code of a cell (see InCell).
+	CellId, CellLine int
+	InCell           bool
+
+	// IsRuntime is set for frames of the Go runtime and the panic machinery.
+	IsRuntime bool
+}
+
+var (
+	regexpPanicStart       = regexp.MustCompile(`^(panic: |fatal error: |unexpected fault address |SIGQUIT: )`)
+	regexpPanicGoroutine   = regexp.MustCompile(`^(goroutine \d+.*\[.*\]):$`)
+	regexpPanicLocation    = regexp.MustCompile(`^\t(.+):(\d+)(?: \+0x[0-9a-f]+)?(?: .*)?$`)
+	regexpPanicCreatedByFn = regexp.MustCompile(`^created by (.+)$`)
+)
+
+// isPanicStart returns whether the line is the first line of the output of a Go runtime crash.
+func isPanicStart(line string) bool {
+	return regexpPanicStart.MatchString(line)
+}
+
+// positionMapper maps a file and line (starting at 1) of a stack trace to the cell id and line in the cell
+// (starting at 1). It returns found=false if the position is not in a cell.
+type positionMapper func(filePath string, line int) (cellId, cellLine int, found bool)
+
+// newPositionMapper returns a positionMapper for the references to `codePath` of the current execution, using
+// fileToCellIdAndLine. If `fallback` is given, it's used for other references -- e.g.: State.MapSourcePosition,
+// for binaries of previous executions.
+func newPositionMapper(codePath string, fileToCellIdAndLine []CellIdAndLine, fallback positionMapper) positionMapper {
+	return func(filePath string, line int) (cellId, cellLine int, found bool) {
+		if filePath != codePath {
+			if fallback == nil {
+				return
+			}
+			return fallback(filePath, line)
+		}
+		if line <= 0 || line > len(fileToCellIdAndLine) || fileToCellIdAndLine[line-1].Line == NoCursorLine {
+			return
+		}
+		return fileToCellIdAndLine[line-1].Id, fileToCellIdAndLine[line-1].Line + 1, true
+	}
+}
+
+// parsePanic parses the output of a Go runtime crash. It returns nil if no goroutine stack trace is found.
+//
+// The frames are mapped to their cells lines using mapPosition.
+func parsePanic(output string, mapPosition positionMapper) *panicReport {
+	report := &panicReport{}
+	var goroutine *panicGoroutine
+	var frame *panicFrame
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	for _, line := range lines {
+		if matches := regexpPanicGoroutine.FindStringSubmatch(line); matches != nil {
+			goroutine = &panicGoroutine{Header: matches[1]}
+			report.Goroutines = append(report.Goroutines, goroutine)
+			frame = nil
+			continue
+		}
+		switch {
+		case goroutine == nil && len(report.Goroutines) > 0:
+			// Lines after the goroutines.
+			if line != "" {
+				report.Trailer = append(report.Trailer, line)
+			}
+		case goroutine == nil:
+			report.Message = append(report.Message, line)
+		case line == "":
+			goroutine, frame = nil, nil
+		case frame != nil && frame.File == "" && regexpPanicLocation.MatchString(line):
+			matches := regexpPanicLocation.FindStringSubmatch(line)
+			frame.File = matches[1]
+			frame.Line, _ = strconv.Atoi(matches[2])
+			frame.IsRuntime = frame.IsRuntime || strings.Contains(frame.File, "/src/runtime/")
+			frame.CellId, frame.CellLine, frame.InCell = mapPosition(frame.File, frame.Line)
+		case regexpPanicCreatedByFn.MatchString(line):
+			frame = &panicFrame{Function: regexpPanicCreatedByFn.FindStringSubmatch(line)[1]}
+			goroutine.CreatedBy = frame
+		case strings.HasSuffix(line, ")") || line == "...additional frames elided...":
+			frame = &panicFrame{Function: line}
+			frame.IsRuntime = strings.HasPrefix(frame.Function, "runtime.") || strings.HasPrefix(frame.Function, "panic(")
+			goroutine.Frames = append(goroutine.Frames, frame)
+		default:
+			// Unknown line format: e.g. other output of the program, after the stack trace.
+			report.Trailer = append(report.Trailer, line)
+			goroutine, frame = nil, nil
+		}
+	}
+	if len(report.Goroutines) == 0 {
+		return nil
+	}
+	for len(report.Message) > 0 && strings.TrimSpace(report.Message[len(report.Message)-1]) == "" {
+		report.Message = report.Message[:len(report.Message)-1]
+	}
+	return report
+}
+
+// panicFramesGroup is a group of consecutive frames that are either all runtime frames, or none is.
+type panicFramesGroup struct {
+	IsRuntime bool
+	Frames    []*panicFrame
+}
+
+// groupFrames groups consecutive runtime frames, so they can be collapsed together.
+func (g *panicGoroutine) groupFrames() []*panicFramesGroup {
+	var groups []*panicFramesGroup
+	for _, frame := range g.Frames {
+		if len(groups) == 0 || groups[len(groups)-1].IsRuntime != frame.IsRuntime {
+			groups = append(groups, &panicFramesGroup{IsRuntime: frame.IsRuntime})
+		}
+		group := groups[len(groups)-1]
+		group.Frames = append(group.Frames, frame)
+	}
+	return groups
+}
+
+// renderPanicReport renders the report as HTML.
+func renderPanicReport(report *panicReport) (string, error) {
+	type goroutineView struct {
+		Header    string
+		Open      bool
+		Groups    []*panicFramesGroup
+		CreatedBy *panicFrame
+		InCell    bool
+	}
+	views := make([]*goroutineView, 0, len(report.Goroutines))
+	for ii, goroutine := range report.Goroutines {
+		view := &goroutineView{
+			Header:    goroutine.Header,
+			Groups:    goroutine.groupFrames(),
+			CreatedBy: goroutine.CreatedBy,
+		}
+		for _, frame := range goroutine.Frames {
+			view.InCell = view.InCell || frame.InCell
+		}
+		// The first goroutine is the one that crashed.
+		view.Open = ii == 0
+		views = append(views, view)
+	}
+	var buf bytes.Buffer
+	err := templatePanicReport.Execute(&buf, map[string]any{
+		"Message":    strings.Join(report.Message, "\n"),
+		"Goroutines": views,
+		"Trailer":    strings.Join(report.Trailer, "\n"),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render panic report")
+	}
+	return buf.String(), nil
+}
+
+var templatePanicReport = template.Must(template.New("panic_report").Parse(`
+{{define "frame"}}<div class="gonb-panic-frame{{if .InCell}} gonb-panic-cell{{end}}{{if .IsRuntime}} gonb-panic-runtime{{end}}">
+{{if .InCell}}<span class="gonb-panic-badge">{{if ge .CellId 0}}Cell [{{.CellId}}] {{end}}Line {{.CellLine}}</span> {{end}}<code>{{.Function}}</code>
+{{if .File}}<div class="gonb-panic-location">{{.File}}:{{.Line}}</div>{{end}}
+</div>{{end}}
+<style>
+.gonb-panic { font-size: 0.9em; }
+.gonb-panic-message { color: #c62828; font-weight: bold; white-space: pre-wrap; margin: 4px 0; }
+.gonb-panic details { margin: 2px 0 2px 8px; }
+.gonb-panic summary { cursor: pointer; }
+.gonb-panic-frame { padding: 1px 6px; margin: 1px 0 1px 8px; border-left: 3px solid transparent; }
+.gonb-panic-location { font-family: monospace; font-size: 0.9em; opacity: 0.8; margin-left: 16px; }
+.gonb-panic-cell { background: rgba(255, 193, 7, 0.2); border-left-color: #ff9800; }
+.gonb-panic-badge { background: #ff9800; color: white; border-radius: 3px; padding: 0 4px; font-size: 0.85em; }
+.gonb-panic-runtime { opacity: 0.55; }
+.gonb-panic pre { margin: 4px 0; }
+</style>
+<div class="gonb-panic">
+<div class="gonb-panic-message">💥 {{.Message}}</div>
+{{range .Goroutines}}<details {{if .Open}}open{{end}}><summary><b>{{.Header}}</b>{{if .InCell}} ⬅ cell code{{end}}</summary>
+{{range .Groups}}{{if .IsRuntime}}<details><summary class="gonb-panic-runtime">{{len .Frames}} runtime frame(s)</summary>
+{{range .Frames}}{{template "frame" .}}{{end}}
+</details>{{else}}{{range .Frames}}{{template "frame" .}}{{end}}{{end}}
+{{end}}{{with .CreatedBy}}<div class="gonb-panic-runtime" style="margin-left: 8px;">created by:</div>{{template "frame" .}}{{end}}
+</details>
+{{end}}{{if .Trailer}}<pre>{{.Trailer}}</pre>{{end}}
+</div>
+`))
+
+// panicReportWriter is an io.Writer for the stderr of the program that streams the output as is (mapping
+// references to the code to their cells, see jupyterStackTraceMapperWriter), until a Go runtime crash is
+// detected. From that line on, the output is collected, and when the program stderr is closed (see Flush)
+// it is published as a panic report.
+type panicReportWriter struct {
+	msg         kernel.Message
+	mapper      *jupyterStackTraceMapperWriter
+	mapPosition positionMapper
+
+	// pending is an incomplete line that may be the start of a crash.
+	pending []byte
+
+	// atLineStart indicates that the last byte written was a new line.
+	atLineStart bool
+
+	// crash output collected, if a crash was detected.
+	crash   bytes.Buffer
+	crashed bool
+}
+
+// newPanicReportWriter creates an io.Writer for the program stderr, that renders runtime crashes as panic
+// reports.
+//
+// If rawError is set, it's simply a jupyterStackTraceMapperWriter.
+func (s *State) newPanicReportWriter(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine) io.Writer {
+	mapper := newJupyterStackTraceMapperWriter(msg, "stderr", s.CodePath(), fileToCellIdAndLine)
+	if s.rawError {
+		return mapper
+	}
+	return &panicReportWriter{
+		msg:         msg,
+		mapper:      mapper.(*jupyterStackTraceMapperWriter),
+		mapPosition: newPositionMapper(s.CodePath(), fileToCellIdAndLine, s.MapSourcePosition),
+		atLineStart: true,
+	}
+}
+
+// Write implements io.Writer.
+func (w *panicReportWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.crashed {
+		w.crash.Write(p)
+		return n, nil
+	}
+	data := append(w.pending, p...)
+	w.pending = nil
+	var passThrough []byte
+	for len(data) > 0 {
+		lineEnd := bytes.IndexByte(data, '\n') + 1
+		line := data
+		if lineEnd > 0 {
+			line = data[:lineEnd]
+		}
+		if w.atLineStart {
+			if isPanicStart(string(line)) {
+				w.crashed = true
+				w.crash.Write(data)
+				break
+			}
+			if lineEnd == 0 && mayBecomePanicStart(line) {
+				// Wait for the rest of the line.
+				w.pending = bytes.Clone(line)
+				break
+			}
+		}
+		passThrough = append(passThrough, line...)
+		w.atLineStart = lineEnd > 0
+		data = data[len(line):]
+	}
+	if len(passThrough) > 0 {
+		if _, err := w.mapper.Write(passThrough); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// mayBecomePanicStart returns whether the incomplete line could still become the start of a crash,
+// once more output arrives.
+func mayBecomePanicStart(line []byte) bool {
+	for _, prefix := range []string{"panic: ", "fatal error: ", "unexpected fault address ", "SIGQUIT: "} {
+		if len(line) < len(prefix) && strings.HasPrefix(prefix, string(line)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush is called when the program stderr is closed: it writes any pending output, and publishes the
+// panic report, if a crash was detected.
+func (w *panicReportWriter) Flush() error {
+	if len(w.pending) > 0 {
+		if isPanicStart(string(w.pending)) {
+			w.crashed = true
+			w.crash.Write(w.pending)
+		} else if _, err := w.mapper.Write(w.pending); err != nil {
+			return err
+		}
+		w.pending = nil
+	}
+	if !w.crashed {
+		return nil
+	}
+	output := w.crash.String()
+	w.crash.Reset()
+	w.crashed = false
+	report := parsePanic(output, w.mapPosition)
+	if report == nil {
+		_, err := w.mapper.Write([]byte(output))
+		return err
+	}
+	htmlReport, err := renderPanicReport(report)
+	if err != nil {
+		_, _ = w.mapper.Write([]byte(output))
+		return err
+	}
+	return kernel.PublishData(w.msg, kernel.Data{
+		Data: kernel.MIMEMap{
+			string(protocol.MIMETextHTML):  htmlReport,
+			string(protocol.MIMETextPlain): string(w.mapper.mapLines([]byte(output))),
+		},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: make(kernel.MIMEMap),
+	})
+}
@@ -0,0 +1,95 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const testPanicOutput = `panic: runtime error: invalid memory address or nil pointer dereference [recovered]
+	panic: again
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x47e3b6]
+
+goroutine 1 [running]:
+panic({0x4a0f00?, 0x55e2b0?})
+	/usr/local/go/src/runtime/panic.go:785 +0x132
+main.f(...)
+	/tmp/gonb_x/main.go:3
+main.main()
+	/tmp/gonb_x/main.go:7 +0x1d
+
+goroutine 6 [chan receive]:
+main.g()
+	/tmp/gonb_x/main.go:9 +0x25
+created by main.main in goroutine 1
+	/tmp/gonb_x/main.go:6 +0x2a
+exit status 2
+`
+
+func TestParsePanic(t *testing.T) {
+	fileToCellIdAndLine := []CellIdAndLine{{3, 0}, {3, 1}, {3, 2}, {NoCursorLine, NoCursorLine}, {4, 0}, {4, 1}, {4, 2}}
+	report := parsePanic(testPanicOutput, newPositionMapper("/tmp/gonb_x/main.go", fileToCellIdAndLine, nil))
+	require.NotNil(t, report)
+	assert.Equal(t, []string{
+		"panic: runtime error: invalid memory address or nil pointer dereference [recovered]",
+		"\tpanic: again",
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x47e3b6]"}, report.Message)
+	assert.Equal(t, []string{"exit status 2"}, report.Trailer)
+	require.Len(t, report.Goroutines, 2)
+
+	g := report.Goroutines[0]
+	assert.Equal(t, "goroutine 1 [running]", g.Header)
+	require.Len(t, g.Frames, 3)
+	assert.True(t, g.Frames[0].IsRuntime)
+	assert.Equal(t, &panicFrame{Function: "main.f(...)", File: "/tmp/gonb_x/main.go", Line: 3,
+		CellId: 3, CellLine: 3, InCell: true}, g.Frames[1])
+	assert.Equal(t, 3, g.Frames[2].CellLine)
+	assert.Equal(t, 4, g.Frames[2].CellId)
+	groups := g.groupFrames()
+	require.Len(t, groups, 2)
+	assert.True(t, groups[0].IsRuntime)
+	assert.Len(t, groups[1].Frames, 2)
+
+	g = report.Goroutines[1]
+	require.NotNil(t, g.CreatedBy)
+	assert.Equal(t, "main.main in goroutine 1", g.CreatedBy.Function)
+	assert.Equal(t, 6, g.CreatedBy.Line)
+	assert.Len(t, g.Frames, 1)
+
+	htmlReport, err := renderPanicReport(report)
+	require.NoError(t, err)
+	assert.Contains(t, htmlReport, `<span class="gonb-panic-badge">Cell [3] Line 3</span> <code>main.f(...)</code>`)
+	assert.Contains(t, htmlReport, "1 runtime frame(s)")
+	assert.Contains(t, htmlReport, "<details open><summary><b>goroutine 1 [running]</b> ⬅ cell code</summary>")
+
+	assert.Nil(t, parsePanic("panic: no stack trace\n", newPositionMapper("/tmp/gonb_x/main.go", nil, nil)))
+}
+
+func TestPanicReportWriterPassThrough(t *testing.T) {
+	mapper := &jupyterStackTraceMapperWriter{}
+	for _, test := range []struct {
+		line  string
+		start bool
+		may   bool
+	}{
+		{"panic: boom\n", true, true},
+		{"fatal error: all goroutines are asleep - deadlock!\n", true, true},
+		{"pan", false, true},
+		{"panicking\n", false, false},
+		{"hello", false, false},
+	} {
+		assert.Equal(t, test.start, isPanicStart(test.line), "isPanicStart(%q)", test.line)
+		assert.Equal(t, test.may, isPanicStart(test.line) || mayBecomePanicStart([]byte(test.line)),
+			"mayBecomePanicStart(%q)", test.line)
+	}
+
+	// Writes split in the middle of the panic start.
+	w := &panicReportWriter{mapper: mapper, atLineStart: true}
+	for _, chunk := range []string{"pa", "nic: boom\n\ngoroutine 1 [running]:\n"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.True(t, w.crashed)
+	assert.Equal(t, "panic: boom\n\ngoroutine 1 [running]:\n", w.crash.String())
+}
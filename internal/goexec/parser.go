@@ -164,38 +164,43 @@ func (s *State) parseFromGoCode(msg kernel.Message,
 				return nil, errors.Wrapf(err, "Failed to read %q", fileObj.Name)
 			}
 			pi.filesContents[fileName] = string(content)
-			// Incorporate Imports
-			for _, entry := range fileObj.Imports {
-				pi.ParseImportEntry(decls, entry)
-			}
+			pi.parseFile(decls, fileObj)
+		}
+	}
+	return
+}
 
-			// Enumerate various declarations.
-			for _, decl := range fileObj.Decls {
-				switch typedDecl := decl.(type) {
-				case *ast.FuncDecl:
-					klog.V(2).Infof("> Declaration %T: %+v", typedDecl, typedDecl.Name)
-					pi.ParseFuncEntry(decls, typedDecl)
-				case *ast.GenDecl:
-					klog.V(2).Infof("> Declaration %T: %s", typedDecl, typedDecl.Tok)
-					if typedDecl.Tok == token.IMPORT {
-						// Imports are handled above.
-						continue
-					} else if typedDecl.Tok == token.VAR {
-						pi.ParseVarEntry(decls, typedDecl)
-					} else if typedDecl.Tok == token.CONST {
-						pi.ParseConstEntry(decls, typedDecl)
-					} else if typedDecl.Tok == token.TYPE {
-						pi.ParseTypeEntry(decls, typedDecl)
-					} else {
-						klog.Warningf("Dropped unknown generic declaration of type %s\n", typedDecl.Tok)
-					}
-				default:
-					klog.Warningf("Dropped unknown declaration type\n")
-				}
+// parseFile incorporates the declarations of a parsed file into `decls`.
+func (pi *parseInfo) parseFile(decls *Declarations, fileObj *ast.File) {
+	// Incorporate Imports
+	for _, entry := range fileObj.Imports {
+		pi.ParseImportEntry(decls, entry)
+	}
+
+	// Enumerate various declarations.
+	for _, decl := range fileObj.Decls {
+		switch typedDecl := decl.(type) {
+		case *ast.FuncDecl:
+			klog.V(2).Infof("> Declaration %T: %+v", typedDecl, typedDecl.Name)
+			pi.ParseFuncEntry(decls, typedDecl)
+		case *ast.GenDecl:
+			klog.V(2).Infof("> Declaration %T: %s", typedDecl, typedDecl.Tok)
+			if typedDecl.Tok == token.IMPORT {
+				// Imports are handled above.
+				continue
+			} else if typedDecl.Tok == token.VAR {
+				pi.ParseVarEntry(decls, typedDecl)
+			} else if typedDecl.Tok == token.CONST {
+				pi.ParseConstEntry(decls, typedDecl)
+			} else if typedDecl.Tok == token.TYPE {
+				pi.ParseTypeEntry(decls, typedDecl)
+			} else {
+				klog.Warningf("Dropped unknown generic declaration of type %s\n", typedDecl.Tok)
 			}
+		default:
+			klog.Warningf("Dropped unknown declaration type\n")
 		}
 	}
-	return
 }
 
 // NewImport from the importPath and it's alias. If alias is empty or "<nil>", it will default to the
@@ -0,0 +1,380 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"html/template"
+	"k8s.io/klog/v2"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile types supported by `%prof`.
+const (
+	ProfileCPU   = "cpu"
+	ProfileMem   = "mem"
+	ProfileBlock = "block"
+)
+
+// ProfileTypes lists the valid values for State.CellProfile.
+var ProfileTypes = []string{ProfileCPU, ProfileMem, ProfileBlock}
+
+const (
+	// ProfileHelperGo is the name of the file with the helper function that starts/stops profiling,
+	// generated next to `main.go` when a cell is executed with `%prof`.
+	ProfileHelperGo = "gonb_profile.go"
+
+	// ProfileDataFile is the name of the file where the child process saves its profile.
+	ProfileDataFile = "gonb_profile.pprof"
+
+	// profileStartFunc is the function defined in ProfileHelperGo, called at the start of `main()`.
+	profileStartFunc = "gonbStartProfile"
+
+	// ProfileMinFraction is the minimum fraction of the total a function must account for, to
+	// be included in the flame graph. Smaller ones are dropped, to keep the HTML small.
+	ProfileMinFraction = 0.002
+
+	// ProfileTopNodes is the number of entries in the "top" table.
+	ProfileTopNodes = 25
+)
+
+// ProfilePath is the path where the profile of the current cell is saved.
+func (s *State) ProfilePath() string {
+	return path.Join(s.TempDir, ProfileDataFile)
+}
+
+// profileHelperSource returns the source of ProfileHelperGo for the State.CellProfile type.
+func (s *State) profileHelperSource() string {
+	var start, stop string
+	switch s.CellProfile {
+	case ProfileCPU:
+		start = `if err := pprof.StartCPUProfile(f); err != nil {
+		_, _ = os.Stderr.WriteString("GoNB: failed to start CPU profile: " + err.Error() + "\n")
+	}`
+		stop = `pprof.StopCPUProfile()`
+	case ProfileMem:
+		start = `runtime.MemProfileRate = 4096`
+		stop = `runtime.GC()
+		_ = pprof.Lookup("allocs").WriteTo(f, 0)`
+	case ProfileBlock:
+		start = `runtime.SetBlockProfileRate(1)`
+		stop = `_ = pprof.Lookup("block").WriteTo(f, 0)`
+	}
+	return fmt.Sprintf(`// File generated by GoNB for %%prof: it is removed after the cell is executed.
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+var _ = runtime.GC
+
+// %[1]s is called by GoNB at the start of main(): it returns the function that stops
+// profiling and saves the profile.
+func %[1]s() func() {
+	f, err := os.Create(%[2]q)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("GoNB: failed to create profile file: " + err.Error() + "\n")
+		return func() {}
+	}
+	%[3]s
+	return func() {
+		%[4]s
+		_ = f.Close()
+	}
+}
+`, profileStartFunc, s.ProfilePath(), start, stop)
+}
+
+// writeProfileHelper creates ProfileHelperGo, if the cell is being profiled, and removes any
+// previous profile.
+// Test cells don't need it, since `go test` has its own profiling flags -- see profileTestArgs.
+func (s *State) writeProfileHelper() error {
+	if err := os.Remove(s.ProfilePath()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove previous profile %q", s.ProfilePath())
+	}
+	if s.CellProfile == "" || s.CellIsTest {
+		return nil
+	}
+	helperPath := path.Join(s.TempDir, ProfileHelperGo)
+	if err := os.WriteFile(helperPath, []byte(s.profileHelperSource()), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q for %%prof", helperPath)
+	}
+	return nil
+}
+
+// removeProfileHelper removes ProfileHelperGo, so it doesn't affect other cells.
+func (s *State) removeProfileHelper() {
+	helperPath := path.Join(s.TempDir, ProfileHelperGo)
+	if err := os.Remove(helperPath); err != nil && !os.IsNotExist(err) {
+		klog.Errorf("Failed to remove %q: %+v", helperPath, err)
+	}
+}
+
+// injectProfileStart adds the call to start profiling at the start of the `main()` function definition.
+// It is inserted in the same line as the opening brace, so line numbers are preserved.
+func (s *State) injectProfileStart(mainDefinition string) string {
+	if s.CellProfile == "" || s.CellIsTest {
+		return mainDefinition
+	}
+	pos := strings.Index(mainDefinition, "{")
+	if pos == -1 {
+		return mainDefinition
+	}
+	return fmt.Sprintf("%s defer %s()();%s", mainDefinition[:pos+1], profileStartFunc, mainDefinition[pos+1:])
+}
+
+// profileTestArgs returns the `go test` flags to profile a test cell.
+func (s *State) profileTestArgs() []string {
+	switch s.CellProfile {
+	case ProfileCPU:
+		return []string{"-test.cpuprofile=" + s.ProfilePath()}
+	case ProfileMem:
+		return []string{"-test.memprofile=" + s.ProfilePath(), "-test.memprofilerate=4096"}
+	case ProfileBlock:
+		return []string{"-test.blockprofile=" + s.ProfilePath()}
+	}
+	return nil
+}
+
+// profileSampleIndex returns the `-sample_index` flag for `go tool pprof`, if different from the default.
+func (s *State) profileSampleIndex() []string {
+	if s.CellProfile == ProfileMem {
+		return []string{"-sample_index=alloc_space"}
+	}
+	return nil
+}
+
+// PublishProfile renders the profile collected for the current cell as a flame graph and a table with
+// the top functions, using `go tool pprof`.
+func (s *State) PublishProfile(msg kernel.Message) error {
+	if _, err := os.Stat(s.ProfilePath()); err != nil {
+		return errors.Errorf("no profile was saved by the program: notice profiling is only finished if " +
+			"main() returns normally (e.g. not with os.Exit)")
+	}
+	top, err := s.runPprof("-top", fmt.Sprintf("-nodecount=%d", ProfileTopNodes))
+	if err != nil {
+		return err
+	}
+	traces, err := s.runPprof("-traces")
+	if err != nil {
+		return err
+	}
+	root := parsePprofTraces(traces)
+	if root.Value == 0 {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout,
+			fmt.Sprintf("%%prof %s: no samples collected.\n", s.CellProfile))
+	}
+	var buf bytes.Buffer
+	err = templateProfileReport.Execute(&buf, map[string]any{
+		"Type":  s.CellProfile,
+		"Total": root.Value,
+		"Root":  root.flameNode(root.Value, 0),
+		"Top":   top,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to render profile report")
+	}
+	return kernel.PublishData(msg, kernel.Data{
+		Data: kernel.MIMEMap{
+			string(protocol.MIMETextHTML):  buf.String(),
+			string(protocol.MIMETextPlain): top,
+		},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: make(kernel.MIMEMap),
+	})
+}
+
+// runPprof executes `go tool pprof` with the given flags on the cell profile, and returns its output.
+func (s *State) runPprof(flags ...string) (string, error) {
+	args := append([]string{"tool", "pprof"}, flags...)
+	args = append(args, s.profileSampleIndex()...)
+	args = append(args, s.BinaryPath(), s.ProfilePath())
+	cmd := exec.Command("go", args...)
+	cmd.Dir = s.TempDir
+	klog.V(2).Infof("Executing %s", cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		var stderr []byte
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stderr = exitErr.Stderr
+		}
+		return "", errors.Wrapf(err, "failed to run %q: %s", cmd, stderr)
+	}
+	return string(output), nil
+}
+
+// profileNode is a node in the tree of stack traces of a profile.
+type profileNode struct {
+	Name     string
+	Value    float64
+	children map[string]*profileNode
+}
+
+// child returns the child with the given name, creating it if needed.
+func (n *profileNode) child(name string) *profileNode {
+	if n.children == nil {
+		n.children = make(map[string]*profileNode)
+	}
+	c, found := n.children[name]
+	if !found {
+		c = &profileNode{Name: name}
+		n.children[name] = c
+	}
+	return c
+}
+
+var (
+	regexpPprofValue = regexp.MustCompile(`^\s*([0-9.]+)([a-zA-Z]*)\s+(.*)$`)
+	regexpPprofLabel = regexp.MustCompile(`^\s*[\w.-]+:\s`)
+)
+
+// pprofUnits maps the units used by `go tool pprof` to a common base, so values can be compared.
+var pprofUnits = map[string]float64{
+	"": 1, "ns": 1, "us": 1e3, "ms": 1e6, "s": 1e9, "mins": 60e9, "hrs": 3600e9,
+	"B": 1, "kB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40,
+}
+
+// parsePprofTraces parses the output of `go tool pprof -traces` into a tree of stacks, with the root
+// of the stacks (usually `runtime.main`) as the first level of children of the returned node.
+func parsePprofTraces(output string) *profileNode {
+	root := &profileNode{Name: "all"}
+	var value float64
+	var stack []string
+	flush := func() {
+		if len(stack) > 0 && value > 0 {
+			root.Value += value
+			node := root
+			for ii := len(stack) - 1; ii >= 0; ii-- { // Stacks are listed leaf first.
+				node = node.child(stack[ii])
+				node.Value += value
+			}
+		}
+		stack, value = nil, 0
+	}
+	inTraces := false
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "-----------+") {
+			flush()
+			inTraces = true
+			continue
+		}
+		if !inTraces || strings.TrimSpace(line) == "" || regexpPprofLabel.MatchString(line) {
+			continue
+		}
+		name := strings.TrimSpace(line)
+		if len(stack) == 0 {
+			matches := regexpPprofValue.FindStringSubmatch(line)
+			if matches == nil {
+				continue
+			}
+			number, err := strconv.ParseFloat(matches[1], 64)
+			if err != nil {
+				continue
+			}
+			value = number * pprofUnits[matches[2]]
+			name = matches[3]
+		}
+		stack = append(stack, strings.TrimSuffix(name, " (inline)"))
+	}
+	flush()
+	return root
+}
+
+// flameNode is the information used to render a node of the flame graph.
+type flameNode struct {
+	Name     string
+	Style    template.CSS
+	Title    string
+	Children []*flameNode
+}
+
+// flameNode converts the profile tree to the data used to render the flame graph.
+// Nodes smaller than ProfileMinFraction of the total are dropped.
+func (n *profileNode) flameNode(total float64, parentValue float64) *flameNode {
+	widthPct := 100.0
+	if parentValue > 0 {
+		widthPct = 100.0 * n.Value / parentValue
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(n.Name))
+	hue := hash.Sum32() % 50 // Reds to yellows.
+	fn := &flameNode{
+		Name:  n.Name,
+		Style: template.CSS(fmt.Sprintf("width: %.4f%%; --gonb-fg-color: hsl(%d, 85%%, 65%%);", widthPct, hue)),
+		Title: fmt.Sprintf("%s: %.2f%%", n.Name, 100.0*n.Value/total),
+	}
+	children := make([]*profileNode, 0, len(n.children))
+	for _, c := range n.children {
+		if c.Value/total >= ProfileMinFraction {
+			children = append(children, c)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].Value != children[j].Value {
+			return children[i].Value > children[j].Value
+		}
+		return children[i].Name < children[j].Name
+	})
+	for _, c := range children {
+		fn.Children = append(fn.Children, c.flameNode(total, n.Value))
+	}
+	return fn
+}
+
+var templateProfileReport = template.Must(template.New("profile_report").Parse(`
+{{define "node"}}<div class="gonb-fg-node" style="{{.Style}}"><div class="gonb-fg-frame" title="{{.Title}}" onclick="gonbFlameGraphZoom(this)">{{.Name}}</div><div class="gonb-fg-children">{{range .Children}}{{template "node" .}}{{end}}</div></div>{{end}}
+<style>
+.gonb-fg { font-family: monospace; font-size: 11px; width: 100%; }
+.gonb-fg-node { display: inline-block; vertical-align: top; box-sizing: border-box; }
+.gonb-fg-frame {
+	background: var(--gonb-fg-color); color: black; border: 1px solid var(--jp-layout-color0, white);
+	border-radius: 2px; padding: 1px 2px; overflow: hidden; white-space: nowrap; text-overflow: ellipsis;
+	cursor: pointer;
+}
+.gonb-fg-frame:hover { filter: brightness(0.85); }
+.gonb-fg-children { display: flex; }
+</style>
+<script>
+if (!window.gonbFlameGraphZoom) {
+	// Clicking on a frame zooms into it, clicking again resets the zoom.
+	window.gonbFlameGraphZoom = function(frame) {
+		const node = frame.parentElement;
+		const graph = node.closest(".gonb-fg");
+		graph.querySelectorAll(".gonb-fg-node").forEach(n => {
+			if (n.dataset.width === undefined) { n.dataset.width = n.style.width; }
+			n.style.width = n.dataset.width;
+			n.style.display = "";
+		});
+		if (graph.gonbZoomed === node) {
+			graph.gonbZoomed = null;
+			return;
+		}
+		graph.gonbZoomed = node;
+		for (let n = node; n && n.classList.contains("gonb-fg-node"); n = n.parentElement.closest(".gonb-fg-node")) {
+			n.style.width = "100%";
+			for (const sibling of n.parentElement.children) {
+				if (sibling !== n) { sibling.style.display = "none"; }
+			}
+		}
+	};
+}
+</script>
+<details open><summary><b>Flame graph</b> ({{.Type}} profile, click to zoom)</summary>
+<div class="gonb-fg">{{template "node" .Root}}</div>
+</details>
+<details><summary><b>Top functions</b></summary>
+<pre>{{.Top}}</pre>
+</details>
+`))
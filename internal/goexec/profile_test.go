@@ -0,0 +1,77 @@
+package goexec
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPprofTraces = `File: gonb_test
+Type: cpu
+Duration: 13.06ms, Total samples = 30ms (229%)
+-----------+-------------------------------------------------------
+      20ms   main.fib
+             main.fib
+             main.main
+             runtime.main
+-----------+-------------------------------------------------------
+     bytes:  1kB
+      10ms   strings.Repeat (inline)
+             main.main
+             runtime.main
+-----------+-------------------------------------------------------
+`
+
+func TestParsePprofTraces(t *testing.T) {
+	root := parsePprofTraces(testPprofTraces)
+	assert.Equal(t, 30e6, root.Value)
+	require.Len(t, root.children, 1)
+	runtimeMain := root.children["runtime.main"]
+	require.NotNil(t, runtimeMain)
+	mainMain := runtimeMain.children["main.main"]
+	require.NotNil(t, mainMain)
+	assert.Equal(t, 30e6, mainMain.Value)
+	assert.Equal(t, 20e6, mainMain.children["main.fib"].Value)
+	assert.Equal(t, 10e6, mainMain.children["strings.Repeat"].Value)
+
+	flame := root.flameNode(root.Value, 0)
+	require.Len(t, flame.Children, 1)
+	children := flame.Children[0].Children[0].Children
+	require.Len(t, children, 2)
+	assert.Equal(t, "main.fib", children[0].Name)
+	assert.Equal(t, "strings.Repeat", children[1].Name)
+}
+
+func TestProfile(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	s.CellProfile = ProfileCPU
+	mainDef := s.injectProfileStart("func main() {\n\tx := 0\n\tfor i := 0; i < 100_000_000; i++ { x += i % 7 }\n\tprintln(x)\n}")
+	assert.Contains(t, mainDef, "func main() { defer gonbStartProfile()();\n")
+	require.NoError(t, os.WriteFile(path.Join(s.TempDir, MainGo), []byte("package main\n\n"+mainDef+"\n"), 0600))
+	require.NoError(t, s.writeProfileHelper())
+	cmd := exec.Command("go", "build", "-o", s.BinaryPath())
+	cmd.Dir = s.TempDir
+	output, err := cmd.CombinedOutput()
+	require.NoErrorf(t, err, "go build failed: %s", output)
+	s.removeProfileHelper()
+	_, err = os.Stat(path.Join(s.TempDir, ProfileHelperGo))
+	assert.True(t, os.IsNotExist(err))
+
+	output, err = exec.Command(s.BinaryPath()).CombinedOutput()
+	require.NoErrorf(t, err, "running program failed: %s", output)
+	_, err = os.Stat(s.ProfilePath())
+	require.NoError(t, err, "profile not saved")
+	traces, err := s.runPprof("-traces")
+	require.NoError(t, err)
+	assert.Greater(t, parsePprofTraces(traces).Value, 0.0)
+	require.NoError(t, s.PublishProfile(nil))
+}
@@ -0,0 +1,40 @@
+package goexec
+
+import (
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/pkg/errors"
+	"os"
+)
+
+// SetRunners sets the number of pre-started runner processes (see jpyexec.RunnerPool) used to execute the
+// programs compiled from the cells, which saves the setup of the process from the latency of the execution.
+// If 0, it disables the pool, and programs are started as usual.
+//
+// The runners are started with the kernel executable, see jpyexec.RunnerArg.
+func (s *State) SetRunners(n int) error {
+	if n < 0 {
+		return errors.Errorf("invalid number of runners %d", n)
+	}
+	if s.runnerPool != nil {
+		s.runnerPool.Close()
+		s.runnerPool = nil
+	}
+	if n == 0 {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrapf(err, "failed to find the kernel executable, used to start the runners")
+	}
+	s.runnerPool = jpyexec.NewRunnerPool(n, executable, jpyexec.RunnerArg)
+	return nil
+}
+
+// Runners returns the number of pre-started runner processes configured (see SetRunners), and how many
+// of them are currently ready to execute a program.
+func (s *State) Runners() (size, idle int) {
+	if s.runnerPool == nil {
+		return 0, 0
+	}
+	return s.runnerPool.Size(), s.runnerPool.Idle()
+}
@@ -0,0 +1,61 @@
+package goexec
+
+import (
+	"github.com/janpfeifer/gonb/common"
+	"sync"
+)
+
+// Secrets holds the secrets entered in the session (e.g.: API tokens), so programs requesting them with
+// `gonbui.RequestSecret` only prompt the user once. They are kept only in memory: they are never saved to disk
+// (not even in the snapshots of the session), and are gone when the kernel restarts.
+//
+// It implements jpyexec.SecretsStore, and it's safe for concurrent use.
+type Secrets struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// Secret returns the value of the secret, and whether it was found.
+func (s *Secrets) Secret(name string) (value string, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, found = s.values[name]
+	return
+}
+
+// SetSecret sets the value of the secret for the rest of the session.
+func (s *Secrets) SetSecret(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	s.values[name] = value
+}
+
+// Forget removes the given secrets, and returns the number of them that were set.
+func (s *Secrets) Forget(names ...string) (forgotten int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, name := range names {
+		if _, found := s.values[name]; found {
+			delete(s.values, name)
+			forgotten++
+		}
+	}
+	return
+}
+
+// Clear removes all secrets.
+func (s *Secrets) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = nil
+}
+
+// Names returns the sorted names of the secrets set -- never their values.
+func (s *Secrets) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return common.SortedKeys(s.values)
+}
@@ -0,0 +1,22 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	secrets := &Secrets{}
+	_, found := secrets.Secret("token")
+	assert.False(t, found)
+	secrets.SetSecret("token", "abc")
+	secrets.SetSecret("another", "xyz")
+	value, found := secrets.Secret("token")
+	assert.True(t, found)
+	assert.Equal(t, "abc", value)
+	assert.Equal(t, []string{"another", "token"}, secrets.Names())
+	assert.Equal(t, 1, secrets.Forget("token", "unknown"))
+	assert.Equal(t, []string{"another"}, secrets.Names())
+	secrets.Clear()
+	assert.Empty(t, secrets.Names())
+}
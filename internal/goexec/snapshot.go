@@ -0,0 +1,488 @@
+package goexec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"k8s.io/klog/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the auto-save of session snapshots, used to recover the memorized declarations
+// and environment after a crash of the kernel (e.g.: killed for running out of memory).
+//
+// A snapshot is captured after each successful execution, and written to disk at most once every
+// SnapshotInterval. Snapshots are saved in a directory per notebook (see SnapshotsDir), and removed
+// when the kernel stops cleanly. If at start the kernel finds a snapshot of a previous session of
+// the same notebook, it tells the user the `%recover` special command can restore it.
+
+const (
+	// SnapshotDirEnv can be set to change the base directory where snapshots are saved.
+	// It defaults to `gonb/snapshots` under the user cache directory (see os.UserCacheDir).
+	SnapshotDirEnv = "GONB_SNAPSHOT_DIR"
+
+	// SnapshotInterval is the minimum interval between writing snapshots to disk.
+	SnapshotInterval = 30 * time.Second
+
+	// SnapshotRotation is the number of snapshots of the current session kept on disk.
+	SnapshotRotation = 3
+
+	// SnapshotMaxAge is the age after which snapshots of previous sessions are discarded.
+	SnapshotMaxAge = 7 * 24 * time.Hour
+
+	// jupyterSessionNameEnv is set by JupyterServer with the path of the notebook.
+	jupyterSessionNameEnv = "JPY_SESSION_NAME"
+)
+
+// SessionSnapshot is what is saved to disk: a compact representation of the session.
+type SessionSnapshot struct {
+	Time time.Time `json:"time"`
+
+	// Code with all memorized declarations, as it would be written in a cell.
+	Code string `json:"code"`
+
+	// NumDeclarations in Code.
+	NumDeclarations int `json:"num_declarations"`
+
+	// GoMod and GoSum are the contents of the files in the session's temporary directory.
+	GoMod string `json:"go_mod,omitempty"`
+	GoSum string `json:"go_sum,omitempty"`
+
+	// Env holds the environment variables set with `%env`.
+	Env map[string]string `json:"env,omitempty"`
+
+	// Dir is the current directory, set with `%cd`.
+	Dir string `json:"dir"`
+
+	GoBuildFlags []string `json:"go_build_flags,omitempty"`
+	AutoGet      bool     `json:"auto_get"`
+
+	// NoAutoFormat is set with `%autoformat off`: it's negated, so older snapshots keep the default.
+	NoAutoFormat bool `json:"no_auto_format,omitempty"`
+
+	// Priority of the programs executed, set with `%limits`.
+	Priority jpyexec.Priority `json:"priority"`
+
+	// Runners is the number of pre-started runner processes, set with `%runners`.
+	Runners int `json:"runners,omitempty"`
+
+	// OutputEncoding of the programs executed, set with `%config output.encoding=<name>`.
+	OutputEncoding string `json:"output_encoding,omitempty"`
+
+	// InterruptGrace of the programs executed, set with `%config interrupt.grace=<duration>`.
+	InterruptGrace time.Duration `json:"interrupt_grace,omitempty"`
+
+	// InputTimeout of the input prompts of the programs, set with `%config input.timeout=<duration>`.
+	InputTimeout time.Duration `json:"input_timeout,omitempty"`
+
+	// Watchdog threshold of the programs, set with `%config watchdog=<duration>`.
+	Watchdog time.Duration `json:"watchdog,omitempty"`
+
+	// Tracked files and directories, see `%track`.
+	Tracked []string `json:"tracked,omitempty"`
+
+	// SourceMaps of the latest executions, to map stack traces of their binaries to the cells.
+	SourceMaps []*SourceMap `json:"source_maps,omitempty"`
+}
+
+// snapshotState is a substructure of State with the bookkeeping of session snapshots.
+type snapshotState struct {
+	mu sync.Mutex
+
+	// dir where snapshots of the notebook are written. If empty, snapshots are disabled.
+	dir string
+
+	// count of snapshots written by this session, used to name the files.
+	count int
+
+	// lastWrite is when the last snapshot was written, used to rate-limit the writes.
+	lastWrite time.Time
+
+	// pending is the latest snapshot captured but not yet written, to be written by timer.
+	pending *SessionSnapshot
+	timer   *time.Timer
+
+	// recoverable is the path to the latest snapshot of a previous session, if one was found.
+	recoverable string
+	notified    bool
+}
+
+// SnapshotsDir returns the directory where the snapshots of the current notebook are saved.
+func SnapshotsDir() (string, error) {
+	return notebookDir(SnapshotDirEnv, "snapshots")
+}
+
+// cacheDir returns the directory given by the environment variable `dirEnv`, or by default `gonb/<subdir>`
+// under the user cache directory.
+func cacheDir(dirEnv, subdir string) (string, error) {
+	if dir := os.Getenv(dirEnv); dir != "" {
+		return dir, nil
+	}
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrapf(err, "can't find user cache directory for %s", subdir)
+	}
+	return path.Join(userCacheDir, "gonb", subdir), nil
+}
+
+// notebookDir returns a directory for files associated to the current notebook, under the base directory
+// given by cacheDir(baseDirEnv, subdir).
+//
+// The notebook is identified by the path given by JupyterServer, or the current directory if not available.
+func notebookDir(baseDirEnv, subdir string) (string, error) {
+	base, err := cacheDir(baseDirEnv, subdir)
+	if err != nil {
+		return "", err
+	}
+	key := os.Getenv(jupyterSessionNameEnv)
+	if key == "" {
+		key, err = os.Getwd()
+		if err != nil {
+			return "", errors.Wrapf(err, "can't find current directory for %s", subdir)
+		}
+	}
+	hash := sha256.Sum256([]byte(key))
+	return path.Join(base, hex.EncodeToString(hash[:8])), nil
+}
+
+// EnableSnapshots prepares the snapshots directory, and looks for a snapshot of a previous session
+// of the same notebook that can be recovered.
+//
+// Snapshots are disabled by default: they are enabled for the kernel of a notebook (or the console),
+// and not for sessions that are not associated to a notebook (e.g.: the HTTP API).
+func (s *State) EnableSnapshots() {
+	dir, err := SnapshotsDir()
+	if err != nil {
+		klog.Warningf("Session snapshots disabled: %+v", err)
+		return
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		klog.Warningf("Session snapshots disabled, failed to create %q: %+v", dir, err)
+		return
+	}
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+	s.snapshots.dir = dir
+
+	var latestTime time.Time
+	for _, filePath := range listSnapshots(dir, "") {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > SnapshotMaxAge {
+			_ = os.Remove(filePath)
+			continue
+		}
+		if info.ModTime().After(latestTime) {
+			latestTime = info.ModTime()
+			s.snapshots.recoverable = filePath
+		}
+	}
+	if s.snapshots.recoverable != "" {
+		klog.Infof("Found snapshot of previous session in %q, it can be restored with %%recover", s.snapshots.recoverable)
+	}
+}
+
+// listSnapshots returns the snapshot files in dir, whose name starts with prefix.
+func listSnapshots(dir, prefix string) []string {
+	files, _ := filepath.Glob(path.Join(dir, prefix+"*.json"))
+	sort.Strings(files)
+	return files
+}
+
+// RecoveryNotice returns a message offering to recover a previous session, the first time it's called
+// after a snapshot of a previous session was found. Otherwise, it returns an empty string.
+func (s *State) RecoveryNotice() string {
+	ss := s.snapshots
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.recoverable == "" || ss.notified {
+		return ""
+	}
+	ss.notified = true
+	snapshot, err := readSnapshot(ss.recoverable)
+	if err != nil {
+		klog.Warningf("Ignoring session snapshot: %+v", err)
+		return ""
+	}
+	return fmt.Sprintf("The previous kernel session did not stop cleanly: a snapshot with %d declarations, "+
+		"saved at %s, can be restored with `%%recover`.", snapshot.NumDeclarations, snapshot.Time.Format(time.DateTime))
+}
+
+// CaptureSnapshot of the session, to be written to disk. Writes are rate-limited to once every SnapshotInterval,
+// and only the latest captured snapshot is written.
+//
+// It should be called after each successful execution: the capture is cheap, it's the writing that is delayed.
+func (s *State) CaptureSnapshot() {
+	ss := s.snapshots
+	ss.mu.Lock()
+	enabled := ss.dir != ""
+	ss.mu.Unlock()
+	if !enabled {
+		return
+	}
+	snapshot, err := s.newSnapshot()
+	if err != nil {
+		klog.Warningf("Failed to capture session snapshot: %+v", err)
+		return
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.pending = snapshot
+	if ss.timer != nil {
+		// A write is already scheduled.
+		return
+	}
+	wait := SnapshotInterval - time.Since(ss.lastWrite)
+	if wait <= 0 {
+		s.writePendingSnapshotLocked()
+		return
+	}
+	ss.timer = time.AfterFunc(wait, func() {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		ss.timer = nil
+		s.writePendingSnapshotLocked()
+	})
+}
+
+// writePendingSnapshotLocked writes the pending snapshot, and removes the older ones of the session.
+// It assumes snapshotState.mu is locked.
+func (s *State) writePendingSnapshotLocked() {
+	ss := s.snapshots
+	if ss.pending == nil || ss.dir == "" {
+		return
+	}
+	snapshot := ss.pending
+	ss.pending = nil
+	ss.lastWrite = time.Now()
+	ss.count++
+	filePath := path.Join(ss.dir, fmt.Sprintf("%s-%06d.json", s.UniqueID, ss.count))
+	if err := writeSnapshot(filePath, snapshot); err != nil {
+		klog.Warningf("Failed to write session snapshot: %+v", err)
+		return
+	}
+	klog.V(1).Infof("Session snapshot written to %q", filePath)
+	files := listSnapshots(ss.dir, s.UniqueID+"-")
+	for ii := 0; ii < len(files)-SnapshotRotation; ii++ {
+		_ = os.Remove(files[ii])
+	}
+}
+
+// removeSnapshots written by this session, called when the kernel stops cleanly.
+func (s *State) removeSnapshots() {
+	ss := s.snapshots
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.dir == "" {
+		return
+	}
+	if ss.timer != nil {
+		ss.timer.Stop()
+		ss.timer = nil
+	}
+	ss.pending = nil
+	for _, filePath := range listSnapshots(ss.dir, s.UniqueID+"-") {
+		_ = os.Remove(filePath)
+	}
+	if ss.recoverable != "" && ss.notified {
+		// The user was offered to recover the previous session already: its snapshots are no longer needed.
+		base := path.Base(ss.recoverable)
+		if dashPos := strings.LastIndex(base, "-"); dashPos > 0 {
+			for _, filePath := range listSnapshots(ss.dir, base[:dashPos+1]) {
+				_ = os.Remove(filePath)
+			}
+		}
+	}
+	ss.dir = ""
+}
+
+// newSnapshot captures the current state of the session.
+func (s *State) newSnapshot() (*SessionSnapshot, error) {
+	// `func init_*` are rendered as `func init`, so they are appended separately to the code,
+	// with their original names.
+	decls := s.Definitions.Copy()
+	var initKeys []string
+	for key := range decls.Functions {
+		if strings.HasPrefix(key, InitFunctionPrefix) {
+			initKeys = append(initKeys, key)
+		}
+	}
+	sort.Strings(initKeys)
+	for _, key := range initKeys {
+		delete(decls.Functions, key)
+	}
+	var buf bytes.Buffer
+	if _, _, err := s.createCodeFromDecls(&buf, decls, nil); err != nil {
+		return nil, errors.WithMessagef(err, "rendering memorized declarations")
+	}
+	for _, key := range initKeys {
+		buf.WriteString(s.Definitions.Functions[key].Definition)
+		buf.WriteString("\n\n")
+	}
+
+	snapshot := &SessionSnapshot{
+		Time:           time.Now(),
+		Code:           strings.TrimPrefix(buf.String(), "package main\n\n"),
+		GoBuildFlags:   s.GoBuildFlags,
+		AutoGet:        s.AutoGet,
+		NoAutoFormat:   !s.AutoFormat,
+		Priority:       s.Priority,
+		OutputEncoding: s.OutputEncoding,
+		InterruptGrace: s.InterruptGrace,
+		InputTimeout:   s.InputTimeout,
+		Watchdog:       s.Watchdog,
+	}
+	snapshot.Runners, _ = s.Runners()
+	for _, count := range s.Definitions.CellIds() {
+		snapshot.NumDeclarations += len(count)
+	}
+	if len(s.SessionEnv) > 0 {
+		snapshot.Env = make(map[string]string, len(s.SessionEnv))
+		copyMap(snapshot.Env, s.SessionEnv)
+	}
+	snapshot.Dir, _ = os.Getwd()
+	snapshot.Tracked = s.ListTracked()
+	snapshot.SourceMaps = s.SourceMaps()
+	if contents, err := os.ReadFile(path.Join(s.TempDir, "go.mod")); err == nil {
+		snapshot.GoMod = string(contents)
+	}
+	if contents, err := os.ReadFile(path.Join(s.TempDir, "go.sum")); err == nil {
+		snapshot.GoSum = string(contents)
+	}
+	return snapshot, nil
+}
+
+// writeSnapshot to filePath atomically.
+func writeSnapshot(filePath string, snapshot *SessionSnapshot) error {
+	contents, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrapf(err, "encoding session snapshot")
+	}
+	tmpPath := filePath + ".tmp"
+	if err = os.WriteFile(tmpPath, contents, 0600); err != nil {
+		return errors.Wrapf(err, "writing %q", tmpPath)
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		return errors.Wrapf(err, "renaming %q to %q", tmpPath, filePath)
+	}
+	return nil
+}
+
+// readSnapshot from filePath.
+func readSnapshot(filePath string) (*SessionSnapshot, error) {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading session snapshot %q", filePath)
+	}
+	snapshot := &SessionSnapshot{}
+	if err = json.Unmarshal(contents, snapshot); err != nil {
+		return nil, errors.Wrapf(err, "decoding session snapshot %q", filePath)
+	}
+	return snapshot, nil
+}
+
+// RecoverSnapshot restores the configuration of the latest snapshot of a previous session: environment
+// variables, current directory, `go build` flags, tracked files, `go.mod` and `go.sum`.
+//
+// It returns the snapshot, whose Code with the memorized declarations should be executed as a cell to
+// restore them.
+func (s *State) RecoverSnapshot() (*SessionSnapshot, error) {
+	ss := s.snapshots
+	ss.mu.Lock()
+	filePath := ss.recoverable
+	ss.notified = true
+	ss.mu.Unlock()
+	if filePath == "" {
+		return nil, errors.Errorf("no snapshot of a previous session found")
+	}
+	return s.restoreSnapshot(filePath)
+}
+
+// restoreSnapshot reads the snapshot in filePath and restores its configuration, see RecoverSnapshot.
+func (s *State) restoreSnapshot(filePath string) (*SessionSnapshot, error) {
+	snapshot, err := readSnapshot(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range snapshot.Env {
+		if err = os.Setenv(key, value); err != nil {
+			return nil, errors.Wrapf(err, "failed to restore environment variable %q", key)
+		}
+		if s.SessionEnv == nil {
+			s.SessionEnv = make(map[string]string)
+		}
+		s.SessionEnv[key] = value
+	}
+	if caFile := snapshot.Env[CAFileEnv]; caFile != "" {
+		// The bundle of certificate authorities is not in the snapshot: recreate it.
+		if err = s.SetNetworkField("CAFile", caFile); err != nil {
+			klog.Warningf("Failed to restore the certificate authorities in %q: %+v", caFile, err)
+		}
+	}
+	if snapshot.Dir != "" {
+		if err = os.Chdir(snapshot.Dir); err != nil {
+			klog.Warningf("Failed to restore current directory to %q: %+v", snapshot.Dir, err)
+		} else if err = os.Setenv(protocol.GONB_DIR_ENV, snapshot.Dir); err != nil {
+			klog.Errorf("Failed to set environment variable %q: %+v", protocol.GONB_DIR_ENV, err)
+		}
+	}
+	s.GoBuildFlags = snapshot.GoBuildFlags
+	s.AutoGet = snapshot.AutoGet
+	s.AutoFormat = !snapshot.NoAutoFormat
+	s.Priority = snapshot.Priority
+	s.OutputEncoding = snapshot.OutputEncoding
+	s.InterruptGrace = snapshot.InterruptGrace
+	s.InputTimeout = snapshot.InputTimeout
+	s.Watchdog = snapshot.Watchdog
+	if runnersErr := s.SetRunners(snapshot.Runners); runnersErr != nil {
+		klog.Warningf("Failed to restore %d runners: %+v", snapshot.Runners, runnersErr)
+	}
+	s.restoreSourceMaps(snapshot.SourceMaps)
+	for _, fileOrDirPath := range snapshot.Tracked {
+		if trackErr := s.Track(fileOrDirPath); trackErr != nil {
+			klog.Warningf("Failed to restore tracking of %q: %+v", fileOrDirPath, trackErr)
+		}
+	}
+
+	if snapshot.GoMod != "" {
+		// The module name is the package of the session that saved the snapshot: it's replaced by the current one.
+		goModPath := path.Join(s.TempDir, "go.mod")
+		goMod, err := modfile.Parse(goModPath, []byte(snapshot.GoMod), nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse go.mod in snapshot %q", filePath)
+		}
+		if err = goMod.AddModuleStmt(s.Package); err != nil {
+			return nil, errors.Wrapf(err, "failed to rename module in go.mod")
+		}
+		contents, err := goMod.Format()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to format go.mod")
+		}
+		if err = os.WriteFile(goModPath, contents, 0600); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %q", goModPath)
+		}
+	}
+	if snapshot.GoSum != "" {
+		goSumPath := path.Join(s.TempDir, "go.sum")
+		if err = os.WriteFile(goSumPath, []byte(snapshot.GoSum), 0600); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %q", goSumPath)
+		}
+	}
+	return snapshot, nil
+}
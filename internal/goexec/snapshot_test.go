@@ -0,0 +1,54 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	t.Setenv(SnapshotDirEnv, t.TempDir())
+	t.Setenv(jupyterSessionNameEnv, "snapshot_test.ipynb")
+	t.Setenv("GONB_SNAPSHOT_TEST", "") // Restored at the end of the test.
+
+	// Session that "crashes": it is not stopped before the second one starts.
+	s := newEmptyState(t)
+	s.EnableSnapshots()
+	assert.Empty(t, s.RecoveryNotice())
+	s.Definitions.Functions["f"] = &Function{Key: "f", Name: "f", Definition: "func f() int { return 1 }"}
+	s.Definitions.Functions["init_a"] = &Function{Key: "init_a", Name: "init_a", Definition: "func init_a() {}"}
+	s.Definitions.Variables["x"] = &Variable{Key: "x", Name: "x", ValueDefinition: "f()"}
+	s.SessionEnv = map[string]string{"GONB_SNAPSHOT_TEST": "bar"}
+	s.GoBuildFlags = []string{"-race"}
+	s.CaptureSnapshot() // First snapshot is written immediately.
+	files := listSnapshots(s.snapshots.dir, s.UniqueID+"-")
+	require.Len(t, files, 1)
+
+	// A new session finds it.
+	s2 := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s2.Stop(), "Failed to finalized state")
+	}()
+	s2.EnableSnapshots()
+	assert.Contains(t, s2.RecoveryNotice(), "3 declarations")
+	assert.Empty(t, s2.RecoveryNotice(), "Notice is only given once")
+
+	snapshot, err := s2.RecoverSnapshot()
+	require.NoError(t, err)
+	assert.Contains(t, snapshot.Code, "func f() int { return 1 }")
+	assert.Contains(t, snapshot.Code, "func init_a() {}")
+	assert.Contains(t, snapshot.Code, "x = f()")
+	assert.False(t, strings.HasPrefix(snapshot.Code, "package"))
+	assert.Equal(t, "bar", os.Getenv("GONB_SNAPSHOT_TEST"))
+	assert.Equal(t, []string{"-race"}, s2.GoBuildFlags)
+	goMod, err := os.ReadFile(path.Join(s2.TempDir, "go.mod"))
+	require.NoError(t, err)
+	assert.Contains(t, string(goMod), "module "+s2.Package)
+
+	// Clean stop removes the session snapshots.
+	require.NoError(t, s.Stop())
+	assert.Empty(t, listSnapshots(s2.snapshots.dir, s.UniqueID+"-"))
+}
@@ -0,0 +1,182 @@
+package goexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the persistence of source maps: the mapping of the lines of the generated code
+// (`main.go` or `main_test.go`) to the lines of the cells, for each compiled binary.
+//
+// They are written as JSON alongside the binary (see SourceMapPath), and the most recent ones are kept in
+// the session snapshots, so stack traces of executions of previous kernel processes can still be mapped to
+// their cells (see MapSourcePosition), and external tools can do the same.
+
+// MaxSourceMaps is the maximum number of source maps of previous executions kept by the session.
+const MaxSourceMaps = 20
+
+// SourceMapSuffix is appended to the path of the binary to get the path of its source map.
+const SourceMapSuffix = ".srcmap.json"
+
+// SourceMap of one execution: it maps the lines of the generated code file to the cell lines.
+type SourceMap struct {
+	// ExecutionCount of the cell compiled, or -1 if not stored in the history.
+	ExecutionCount int `json:"execution_count"`
+
+	// Time when the binary was built.
+	Time time.Time `json:"time"`
+
+	// CodePath is the path to the generated code file, as it appears in stack traces.
+	CodePath string `json:"code_path"`
+
+	// BinaryPath is the path of the compiled binary.
+	BinaryPath string `json:"binary_path"`
+
+	// Lines maps each line of the code file (Lines[0] is the first line) to the cell id (the execution count
+	// of the cell where the line was defined, or -1 for the cell being executed) and the line in the cell
+	// (starting at 0). Generated lines have NoCursorLine for both.
+	Lines []CellIdAndLine `json:"lines"`
+}
+
+// sourceMapsState is a substructure of State with the source maps of the latest executions.
+type sourceMapsState struct {
+	mu sync.Mutex
+
+	// maps, the most recent last.
+	maps []*SourceMap
+}
+
+// SourceMapPath returns the path of the source map written alongside the binary in binaryPath.
+func SourceMapPath(binaryPath string) string {
+	return binaryPath + SourceMapSuffix
+}
+
+// recordSourceMap of the binary just compiled: it's written alongside the binary and kept in the session.
+func (s *State) recordSourceMap(executionCount int, fileToCellIdAndLine []CellIdAndLine) error {
+	sourceMap := &SourceMap{
+		ExecutionCount: executionCount,
+		Time:           time.Now(),
+		CodePath:       s.CodePath(),
+		BinaryPath:     s.BinaryPath(),
+		Lines:          fileToCellIdAndLine,
+	}
+	s.addSourceMaps(sourceMap)
+	contents, err := json.Marshal(sourceMap)
+	if err != nil {
+		return errors.Wrapf(err, "encoding source map")
+	}
+	filePath := SourceMapPath(sourceMap.BinaryPath)
+	if err = os.WriteFile(filePath, contents, 0600); err != nil {
+		return errors.Wrapf(err, "writing source map to %q", filePath)
+	}
+	return nil
+}
+
+// addSourceMaps to the session, dropping the oldest ones if there are more than MaxSourceMaps.
+func (s *State) addSourceMaps(sourceMaps ...*SourceMap) {
+	sm := s.sourceMaps
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.maps = append(sm.maps, sourceMaps...)
+	if len(sm.maps) > MaxSourceMaps {
+		sm.maps = append([]*SourceMap(nil), sm.maps[len(sm.maps)-MaxSourceMaps:]...)
+	}
+}
+
+// restoreSourceMaps of a previous session: they are kept before the ones of the current session.
+func (s *State) restoreSourceMaps(sourceMaps []*SourceMap) {
+	sm := s.sourceMaps
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.maps = append(append([]*SourceMap(nil), sourceMaps...), sm.maps...)
+	if len(sm.maps) > MaxSourceMaps {
+		sm.maps = sm.maps[len(sm.maps)-MaxSourceMaps:]
+	}
+}
+
+// SourceMaps returns the source maps of the latest executions, including the ones restored from a snapshot
+// of a previous session, the most recent last.
+func (s *State) SourceMaps() []*SourceMap {
+	sm := s.sourceMaps
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return append([]*SourceMap{}, sm.maps...)
+}
+
+// MapSourcePosition maps the line (starting at 1) of a generated code file, as it appears in stack traces,
+// to the cell id and the line in the cell (starting at 1). It uses the most recent source map of the
+// file or, if there is none in the session, the one written alongside the binary in the same directory (e.g.:
+// by the kernel of a previous session). It returns found=false if no source map is found, or if the line
+// was generated by GoNB.
+func (s *State) MapSourcePosition(codePath string, line int) (cellId, cellLine int, found bool) {
+	sourceMap := s.findSourceMap(codePath)
+	if sourceMap == nil {
+		// Binaries are named after the temporary directory, see State.BinaryPath.
+		dir := path.Dir(codePath)
+		var err error
+		sourceMap, err = ReadSourceMap(path.Join(dir, path.Base(dir)))
+		if err != nil || sourceMap.CodePath != codePath {
+			return 0, 0, false
+		}
+	}
+	if line < 1 || line > len(sourceMap.Lines) || sourceMap.Lines[line-1].Line == NoCursorLine {
+		return 0, 0, false
+	}
+	cellIdAndLine := sourceMap.Lines[line-1]
+	cellId = cellIdAndLine.Id
+	if cellId == -1 {
+		// Lines of the cell being executed are identified by the execution count.
+		cellId = sourceMap.ExecutionCount
+	}
+	return cellId, cellIdAndLine.Line + 1, true
+}
+
+// findSourceMap returns the most recent source map of the session for the code file, or nil if there is none.
+func (s *State) findSourceMap(codePath string) *SourceMap {
+	sm := s.sourceMaps
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for ii := len(sm.maps) - 1; ii >= 0; ii-- {
+		if sm.maps[ii].CodePath == codePath {
+			return sm.maps[ii]
+		}
+	}
+	return nil
+}
+
+// PublishSourceMaps displays the list of source maps of the session, with `%srcmap`.
+func (s *State) PublishSourceMaps(msg kernel.Message) error {
+	sourceMaps := s.SourceMaps()
+	if len(sourceMaps) == 0 {
+		return kernel.PublishMarkdown(msg, "No source maps recorded yet: they are saved when cells are compiled.")
+	}
+	var buf strings.Builder
+	buf.WriteString("| Execution | Built | Code file | Binary |\n|---|---|---|---|\n")
+	for ii := len(sourceMaps) - 1; ii >= 0; ii-- {
+		sourceMap := sourceMaps[ii]
+		_, _ = fmt.Fprintf(&buf, "| %d | %s | `%s` | `%s` |\n", sourceMap.ExecutionCount,
+			sourceMap.Time.Format(time.DateTime), sourceMap.CodePath, sourceMap.BinaryPath)
+	}
+	return kernel.PublishMarkdown(msg, buf.String())
+}
+
+// ReadSourceMap reads the source map written alongside the binary, e.g.: by the kernel of a previous session.
+func ReadSourceMap(binaryPath string) (*SourceMap, error) {
+	filePath := SourceMapPath(binaryPath)
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading source map %q", filePath)
+	}
+	sourceMap := &SourceMap{}
+	if err = json.Unmarshal(contents, sourceMap); err != nil {
+		return nil, errors.Wrapf(err, "decoding source map %q", filePath)
+	}
+	return sourceMap, nil
+}
@@ -0,0 +1,59 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSourceMaps(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		err := s.Stop()
+		require.NoError(t, err, "Failed to finalized state")
+	}()
+
+	fileToCellIdAndLine := []CellIdAndLine{
+		{Id: NoCursorLine, Line: NoCursorLine}, // package main
+		{Id: 3, Line: 0},                       // From a previous cell.
+		{Id: -1, Line: 1},                      // From the cell being executed.
+	}
+	require.NoError(t, s.recordSourceMap(7, fileToCellIdAndLine))
+	require.Len(t, s.SourceMaps(), 1)
+
+	cellId, cellLine, found := s.MapSourcePosition(s.CodePath(), 2)
+	assert.True(t, found)
+	assert.Equal(t, 3, cellId)
+	assert.Equal(t, 1, cellLine)
+	cellId, cellLine, found = s.MapSourcePosition(s.CodePath(), 3)
+	assert.True(t, found)
+	assert.Equal(t, 7, cellId)
+	assert.Equal(t, 2, cellLine)
+	_, _, found = s.MapSourcePosition(s.CodePath(), 1)
+	assert.False(t, found, "generated lines are not mapped")
+	_, _, found = s.MapSourcePosition(s.CodePath(), 10)
+	assert.False(t, found)
+
+	// Source map written alongside the binary.
+	sourceMap, err := ReadSourceMap(s.BinaryPath())
+	require.NoError(t, err)
+	assert.Equal(t, 7, sourceMap.ExecutionCount)
+	assert.Equal(t, s.CodePath(), sourceMap.CodePath)
+	assert.Equal(t, fileToCellIdAndLine, sourceMap.Lines)
+
+	// Without the source maps in the session, it falls back to the one written alongside the binary.
+	s.sourceMaps.maps = nil
+	cellId, cellLine, found = s.MapSourcePosition(s.CodePath(), 3)
+	assert.True(t, found)
+	assert.Equal(t, 7, cellId)
+	assert.Equal(t, 2, cellLine)
+
+	// Restored source maps come before the ones of the session, and only the latest MaxSourceMaps are kept.
+	for ii := 0; ii < MaxSourceMaps; ii++ {
+		s.addSourceMaps(&SourceMap{ExecutionCount: 100 + ii})
+	}
+	s.restoreSourceMaps([]*SourceMap{{ExecutionCount: 1}})
+	sourceMaps := s.SourceMaps()
+	require.Len(t, sourceMaps, MaxSourceMaps)
+	assert.Equal(t, 100+MaxSourceMaps-1, sourceMaps[MaxSourceMaps-1].ExecutionCount)
+}
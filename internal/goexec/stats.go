@@ -0,0 +1,308 @@
+package goexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/resources"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This file implements local usage statistics, displayed with `%stats`: number of cells executed, build
+// times, cache hit rates and the most used special commands.
+//
+// Statistics are aggregated over all sessions of the user in a file on disk: nothing is ever sent anywhere.
+// Each session accumulates its updates in memory, and merges them into the file after each cell execution.
+
+// UsageStatsDirEnv can be set to change the directory where the usage statistics are saved.
+// It defaults to `gonb/stats` under the user cache directory (see os.UserCacheDir).
+const UsageStatsDirEnv = "GONB_STATS_DIR"
+
+// usageStatsFileName is the name of the file with the usage statistics.
+const usageStatsFileName = "usage.json"
+
+// MaxStatsCommands is the number of most used commands displayed by `%stats`.
+var MaxStatsCommands = 10
+
+// UsageStats are the local usage statistics.
+type UsageStats struct {
+	// Since when statistics are being collected.
+	Since time.Time `json:"since"`
+
+	Sessions      int `json:"sessions"`
+	CellsExecuted int `json:"cells_executed"`
+	CellsFailed   int `json:"cells_failed"`
+
+	// Builds (`go build` or `go test -c`) executed, their total time, and the builds skipped because nothing
+	// changed since the previous one.
+	Builds       int           `json:"builds"`
+	BuildTime    time.Duration `json:"build_time_ns"`
+	BuildsReused int           `json:"builds_reused"`
+
+	// CellCacheHits and CellCacheMisses of the cells executed with `%cache`.
+	CellCacheHits   int `json:"cell_cache_hits"`
+	CellCacheMisses int `json:"cell_cache_misses"`
+
+	// Commands maps special commands (e.g.: "%help", "!" for shell commands, "%%writefile") to the number
+	// of times they were used.
+	Commands map[string]int `json:"commands,omitempty"`
+}
+
+// merge adds the statistics in `delta`.
+func (u *UsageStats) merge(delta *UsageStats) {
+	if u.Since.IsZero() || (!delta.Since.IsZero() && delta.Since.Before(u.Since)) {
+		u.Since = delta.Since
+	}
+	u.Sessions += delta.Sessions
+	u.CellsExecuted += delta.CellsExecuted
+	u.CellsFailed += delta.CellsFailed
+	u.Builds += delta.Builds
+	u.BuildTime += delta.BuildTime
+	u.BuildsReused += delta.BuildsReused
+	u.CellCacheHits += delta.CellCacheHits
+	u.CellCacheMisses += delta.CellCacheMisses
+	for name, count := range delta.Commands {
+		if u.Commands == nil {
+			u.Commands = make(map[string]int)
+		}
+		u.Commands[name] += count
+	}
+}
+
+// usageStatsState is a substructure of State with the statistics not yet merged into the file.
+type usageStatsState struct {
+	mu sync.Mutex
+
+	// filePath where statistics are saved. If empty, statistics are disabled.
+	filePath string
+
+	// pending statistics, not yet merged into the file.
+	pending UsageStats
+}
+
+// UsageStatsPath returns the path of the file where usage statistics are saved.
+func UsageStatsPath() (string, error) {
+	dir := os.Getenv(UsageStatsDirEnv)
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "can't find user cache directory for usage statistics")
+		}
+		dir = path.Join(cacheDir, "gonb", "stats")
+	}
+	return path.Join(dir, usageStatsFileName), nil
+}
+
+// EnableUsageStats starts collecting usage statistics for this session.
+//
+// Statistics are disabled by default: they are enabled for the kernel of a notebook (or the console),
+// and not for sessions that are not directly driven by the user (e.g.: the HTTP API).
+func (s *State) EnableUsageStats() {
+	filePath, err := UsageStatsPath()
+	if err != nil {
+		klog.Warningf("Usage statistics disabled: %+v", err)
+		return
+	}
+	s.usageStats.mu.Lock()
+	s.usageStats.filePath = filePath
+	s.usageStats.mu.Unlock()
+	s.recordUsage(func(u *UsageStats) { u.Sessions++ })
+}
+
+// recordUsage updates the pending usage statistics. It is a no-op if statistics are disabled.
+func (s *State) recordUsage(update func(u *UsageStats)) {
+	s.usageStats.mu.Lock()
+	defer s.usageStats.mu.Unlock()
+	if s.usageStats.filePath == "" {
+		return
+	}
+	if s.usageStats.pending.Since.IsZero() {
+		s.usageStats.pending.Since = time.Now()
+	}
+	update(&s.usageStats.pending)
+}
+
+// RecordCommand counts one use of the special command `name` (e.g.: "%help", or "!" for shell commands).
+func (s *State) RecordCommand(name string) {
+	s.recordUsage(func(u *UsageStats) {
+		if u.Commands == nil {
+			u.Commands = make(map[string]int)
+		}
+		u.Commands[name]++
+	})
+}
+
+// recordCellExecution counts one cell execution with Go code, and whether it failed.
+func (s *State) recordCellExecution(err error) {
+	s.recordUsage(func(u *UsageStats) {
+		u.CellsExecuted++
+		if err != nil {
+			u.CellsFailed++
+		}
+	})
+}
+
+// FlushUsageStats merges the pending usage statistics into the file. It is a no-op if statistics are
+// disabled, or if there is nothing new.
+func (s *State) FlushUsageStats() error {
+	s.usageStats.mu.Lock()
+	defer s.usageStats.mu.Unlock()
+	if s.usageStats.filePath == "" || s.usageStats.pending.Since.IsZero() {
+		return nil
+	}
+	err := updateUsageStatsFile(s.usageStats.filePath, func(u *UsageStats) {
+		u.merge(&s.usageStats.pending)
+	})
+	if err != nil {
+		return err
+	}
+	s.usageStats.pending = UsageStats{}
+	return nil
+}
+
+// UsageStats returns the usage statistics, including the ones of the current session. It returns nil if
+// statistics are disabled.
+func (s *State) UsageStats() (*UsageStats, error) {
+	if err := s.FlushUsageStats(); err != nil {
+		return nil, err
+	}
+	s.usageStats.mu.Lock()
+	filePath := s.usageStats.filePath
+	s.usageStats.mu.Unlock()
+	if filePath == "" {
+		return nil, nil
+	}
+	stats := &UsageStats{}
+	err := updateUsageStatsFile(filePath, func(u *UsageStats) { *stats = *u })
+	return stats, err
+}
+
+// ResetUsageStats removes all the usage statistics collected so far.
+func (s *State) ResetUsageStats() error {
+	s.usageStats.mu.Lock()
+	defer s.usageStats.mu.Unlock()
+	if s.usageStats.filePath == "" {
+		return nil
+	}
+	s.usageStats.pending = UsageStats{}
+	return updateUsageStatsFile(s.usageStats.filePath, func(u *UsageStats) { *u = UsageStats{} })
+}
+
+// updateUsageStatsFile reads the statistics in `filePath`, calls `update` and writes them back
+// (if they changed), while holding a lock, since other kernels may be updating the file concurrently.
+func updateUsageStatsFile(filePath string, update func(u *UsageStats)) error {
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory for usage statistics %q", filePath)
+	}
+	lockFile, err := os.OpenFile(filePath+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open lock for usage statistics %q", filePath)
+	}
+	defer func() { _ = lockFile.Close() }()
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.Wrapf(err, "failed to lock usage statistics %q", filePath)
+	}
+	lockResource := resources.Register(resources.Lock, "goexec", lockFile.Name(), func() error {
+		return syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	})
+	defer func() { _ = lockResource.Release() }()
+
+	var stats UsageStats
+	contents, err := os.ReadFile(filePath)
+	if err == nil {
+		if err = json.Unmarshal(contents, &stats); err != nil {
+			klog.Warningf("Discarding invalid usage statistics in %q: %v", filePath, err)
+			stats = UsageStats{}
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read usage statistics %q", filePath)
+	}
+	original, _ := json.Marshal(&stats)
+	update(&stats)
+	updated, err := json.MarshalIndent(&stats, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode usage statistics")
+	}
+	if compacted, _ := json.Marshal(&stats); string(compacted) == string(original) {
+		return nil
+	}
+	tmpPath := filePath + ".tmp"
+	if err = os.WriteFile(tmpPath, updated, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write usage statistics %q", tmpPath)
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		return errors.Wrapf(err, "failed to write usage statistics %q", filePath)
+	}
+	return nil
+}
+
+// PublishUsageStats displays the usage statistics as Markdown, for `%stats`.
+func (s *State) PublishUsageStats(msg kernel.Message) error {
+	stats, err := s.UsageStats()
+	if err != nil {
+		return err
+	}
+	if stats == nil {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* Usage statistics are disabled for this session.\n")
+	}
+	filePath, _ := UsageStatsPath()
+	var report strings.Builder
+	report.WriteString("### GoNB Usage Statistics\n\n")
+	if stats.Since.IsZero() {
+		_, _ = fmt.Fprintf(&report, "No statistics collected yet (they are stored locally in `%s`).\n", filePath)
+		return kernel.PublishMarkdown(msg, report.String())
+	}
+	_, _ = fmt.Fprintf(&report, "Local statistics since %s, stored in `%s` -- they are never sent anywhere.\n\n",
+		stats.Since.Format(time.DateTime), filePath)
+	report.WriteString("| Statistic | Value |\n|---|---|\n")
+	_, _ = fmt.Fprintf(&report, "| Sessions | %d |\n", stats.Sessions)
+	_, _ = fmt.Fprintf(&report, "| Cells executed | %d (%d failed) |\n", stats.CellsExecuted, stats.CellsFailed)
+	if stats.Builds > 0 {
+		average := (stats.BuildTime / time.Duration(stats.Builds)).Round(time.Millisecond)
+		_, _ = fmt.Fprintf(&report, "| Builds | %d, average %s |\n", stats.Builds, average)
+	} else {
+		report.WriteString("| Builds | 0 |\n")
+	}
+	if total := stats.Builds + stats.BuildsReused; total > 0 {
+		_, _ = fmt.Fprintf(&report, "| Build cache hit rate | %s (%d of %d builds skipped) |\n",
+			percentage(stats.BuildsReused, total), stats.BuildsReused, total)
+	}
+	if total := stats.CellCacheHits + stats.CellCacheMisses; total > 0 {
+		_, _ = fmt.Fprintf(&report, "| `%%cache` hit rate | %s (%d of %d cells replayed) |\n",
+			percentage(stats.CellCacheHits, total), stats.CellCacheHits, total)
+	}
+
+	if len(stats.Commands) > 0 {
+		names := make([]string, 0, len(stats.Commands))
+		for name := range stats.Commands {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if stats.Commands[names[i]] != stats.Commands[names[j]] {
+				return stats.Commands[names[i]] > stats.Commands[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		if len(names) > MaxStatsCommands {
+			names = names[:MaxStatsCommands]
+		}
+		report.WriteString("\n#### Most Used Commands\n\n| Command | Uses |\n|---|---|\n")
+		for _, name := range names {
+			_, _ = fmt.Fprintf(&report, "| `%s` | %d |\n", name, stats.Commands[name])
+		}
+	}
+	return kernel.PublishMarkdown(msg, report.String())
+}
+
+// percentage formats `part` of `total` as a percentage.
+func percentage(part, total int) string {
+	return fmt.Sprintf("%.0f%%", 100*float64(part)/float64(total))
+}
@@ -0,0 +1,61 @@
+package goexec
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestUsageStats(t *testing.T) {
+	t.Setenv(UsageStatsDirEnv, t.TempDir())
+
+	// Disabled by default.
+	s := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s.Stop(), "Failed to finalized state")
+	}()
+	s.RecordCommand("%help")
+	stats, err := s.UsageStats()
+	require.NoError(t, err)
+	assert.Nil(t, stats)
+
+	s.EnableUsageStats()
+	s.RecordCommand("%help")
+	s.RecordCommand("%help")
+	s.RecordCommand("!")
+	s.recordCellExecution(nil)
+	s.recordCellExecution(errors.New("failed"))
+	s.recordUsage(func(u *UsageStats) {
+		u.Builds++
+		u.BuildTime += time.Second
+		u.BuildsReused++
+	})
+	require.NoError(t, s.FlushUsageStats())
+
+	// A second session updates the same file.
+	s2 := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s2.Stop(), "Failed to finalized state")
+	}()
+	s2.EnableUsageStats()
+	s2.RecordCommand("%ls")
+	s2.recordCellExecution(nil)
+	stats, err = s2.UsageStats()
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Sessions)
+	assert.Equal(t, 3, stats.CellsExecuted)
+	assert.Equal(t, 1, stats.CellsFailed)
+	assert.Equal(t, 1, stats.Builds)
+	assert.Equal(t, time.Second, stats.BuildTime)
+	assert.Equal(t, 1, stats.BuildsReused)
+	assert.Equal(t, map[string]int{"%help": 2, "!": 1, "%ls": 1}, stats.Commands)
+	assert.False(t, stats.Since.IsZero())
+	require.NoError(t, s2.PublishUsageStats(nil))
+
+	require.NoError(t, s2.ResetUsageStats())
+	stats, err = s.UsageStats()
+	require.NoError(t, err)
+	assert.Equal(t, UsageStats{}, *stats)
+}
@@ -0,0 +1,90 @@
+package goexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/metrics"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+	"math"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// StatusHeartbeatTimeout is the time `%status` waits for a heartbeat from the front-end.
+var StatusHeartbeatTimeout = time.Second
+
+// PublishStatus displays a summary of the health of the kernel as Markdown, for `%status`: executions,
+// latencies of the compilation, execution and gopls requests, and the connection with the front-end.
+//
+// The same information is available as Prometheus metrics, if the kernel was started with `--metrics`.
+func (s *State) PublishStatus(msg kernel.Message) error {
+	var report strings.Builder
+	report.WriteString("### GoNB Status\n\n| Item | Value |\n|---|---|\n")
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	_, _ = fmt.Fprintf(&report, "| Kernel | v%s, session `%s`, up %s |\n",
+		kernel.Version, s.UniqueID, time.Since(metrics.StartTime).Round(time.Second))
+	_, _ = fmt.Fprintf(&report, "| Goroutines | %d |\n", runtime.NumGoroutine())
+	_, _ = fmt.Fprintf(&report, "| Memory | %.1f MiB heap, %.1f MiB from the OS |\n",
+		float64(memStats.HeapAlloc)/(1<<20), float64(memStats.Sys)/(1<<20))
+	executions := metrics.Executions.Values()
+	_, _ = fmt.Fprintf(&report, "| Executions | %.0f (%.0f failed) |\n", executions["ok"]+executions["error"], executions["error"])
+	_, _ = fmt.Fprintf(&report, "| Compilation | %s |\n", formatLatencies(metrics.CompileSeconds))
+	_, _ = fmt.Fprintf(&report, "| Execution | %s |\n", formatLatencies(metrics.RunSeconds))
+	goplsHistograms := metrics.GoplsRequestSeconds.Histograms()
+	methods := maps.Keys(goplsHistograms)
+	slices.Sort(methods)
+	for _, method := range methods {
+		_, _ = fmt.Fprintf(&report, "| gopls `%s` | %s |\n", method, formatLatencies(goplsHistograms[method]))
+	}
+
+	if s.Comms != nil {
+		installed, opened, numPeers := s.Comms.Status()
+		connection := "not installed (see `%widgets`)"
+		if installed || opened {
+			connection = fmt.Sprintf("opened, %d front-end(s)", numPeers)
+			if !opened {
+				connection = "installed, not opened"
+			}
+		}
+		_, _ = fmt.Fprintf(&report, "| Front-end connection | %s |\n", connection)
+		if opened {
+			heartbeat, err := s.Comms.SendHeartbeatAndWait(msg, StatusHeartbeatTimeout)
+			if err != nil {
+				klog.Warningf("%%status: failed to send heartbeat: %+v", err)
+			}
+			if heartbeat {
+				_, _ = fmt.Fprintf(&report, "| Heartbeat | %s round-trip |\n", s.Comms.HeartbeatRTT.Round(time.Microsecond))
+			} else {
+				_, _ = fmt.Fprintf(&report, "| Heartbeat | ⚠️ no reply in %s |\n", StatusHeartbeatTimeout)
+			}
+		}
+	}
+
+	if metrics.Address != "" {
+		_, _ = fmt.Fprintf(&report, "| Metrics | `http://%s/metrics` |\n", metrics.Address)
+	} else {
+		report.WriteString("| Metrics | not served, start the kernel with `--metrics=<address>` |\n")
+	}
+	return kernel.PublishMarkdown(msg, report.String())
+}
+
+// formatLatencies summarizes the histogram of latencies (in seconds) with its count, mean, median and 95th percentile.
+func formatLatencies(h *metrics.Histogram) string {
+	count, sum := h.Count()
+	if count == 0 {
+		return "-"
+	}
+	format := func(seconds float64) string {
+		if math.IsNaN(seconds) {
+			return "?"
+		}
+		return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+	}
+	// Percentiles are estimated from the buckets of the histogram.
+	return fmt.Sprintf("%d, mean %s, p50 ~%s, p95 ~%s", count, format(sum/float64(count)),
+		format(h.Quantile(0.5)), format(h.Quantile(0.95)))
+}
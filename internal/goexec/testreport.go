@@ -0,0 +1,750 @@
+package goexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"html/template"
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the rich rendering of the results of `%test` (when given `go test` style flags, like
+// `-run`) and `%bench`: the test binary is executed with `-test.v=test2json`, its output is converted to
+// events by `go tool test2json`, and the results are displayed as HTML: pass/fail per test, the output of the
+// failed tests, the coverage and the benchmark metrics.
+
+// TestReport configures the rich rendering of the results of a test cell, see State.CellTestReport.
+type TestReport struct {
+	// Bench runs only the benchmarks, as in `%bench`.
+	Bench bool
+
+	// Verbose displays the output of all tests, not only of the failed ones.
+	Verbose bool
+
+	// Cover compiles the tests with `-cover`, and displays the coverage with the HTML coverage report.
+	Cover bool
+
+	// Args are the flags (prefixed with "-test.") passed to the test binary.
+	Args []string
+}
+
+const (
+	// CoverProfileFile is the name of the file where the test coverage profile is saved.
+	CoverProfileFile = "gonb_cover.out"
+
+	// coverReportFile is the name of the HTML coverage report generated by `go tool cover`.
+	coverReportFile = "gonb_cover.html"
+)
+
+// CoverProfilePath is the path where the coverage profile of the current cell is saved.
+func (s *State) CoverProfilePath() string {
+	return path.Join(s.TempDir, CoverProfileFile)
+}
+
+// testReportArgs returns the flags for the test binary: the ones configured by the user, plus `-test.run` and
+// `-test.bench` matching the tests and the benchmarks of the current cell, if not given.
+func (s *State) testReportArgs() []string {
+	report := s.CellTestReport
+	args := []string{"-test.v=test2json"}
+	args = append(args, report.Args...)
+	var tests, benchmarks []string
+	for _, name := range s.CellTests {
+		if strings.HasPrefix(name, "Benchmark") {
+			benchmarks = append(benchmarks, name)
+		} else {
+			tests = append(tests, name)
+		}
+	}
+	hasRun, hasBench := hasTestFlag(report.Args, "run"), hasTestFlag(report.Args, "bench")
+	if !hasRun {
+		if report.Bench {
+			args = append(args, "-test.run=^$")
+		} else if len(tests) > 0 {
+			args = append(args, "-test.run="+anchoredAlternatives(tests))
+		}
+	}
+	if !hasBench {
+		if len(benchmarks) > 0 && (report.Bench || !hasRun) {
+			args = append(args, "-test.bench="+anchoredAlternatives(benchmarks))
+		} else if report.Bench {
+			args = append(args, "-test.bench=.")
+		}
+	}
+	if report.Cover {
+		args = append(args, "-test.coverprofile="+s.CoverProfilePath())
+	}
+	if s.CellProfile != "" {
+		args = append(args, s.profileTestArgs()...)
+	}
+	return args
+}
+
+// hasTestFlag returns whether the test binary flag `-test.<name>` is set in args.
+func hasTestFlag(args []string, name string) bool {
+	flag := "-test." + name
+	for _, arg := range args {
+		if arg == flag || strings.HasPrefix(arg, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// anchoredAlternatives returns a regular expression matching exactly any of the names.
+func anchoredAlternatives(names []string) string {
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("^%s$", name))
+	}
+	return strings.Join(parts, "|")
+}
+
+// executeTestReport executes the compiled tests, and renders the results as configured by State.CellTestReport.
+func (s *State) executeTestReport(msg kernel.Message, fileToCellIdAndLine []CellIdAndLine) error {
+	if s.CellTestReport.Cover {
+		_ = os.Remove(s.CoverProfilePath())
+	}
+	results := newTestResults()
+	converter, err := startTestEventsConverter(s.TempDir, results)
+	if err != nil {
+		return err
+	}
+	err = jpyexec.New(msg, s.BinaryPath(), s.testReportArgs()...).
+		UseNamedPipes(s.Comms).
+		ExecutionCount(msg.Kernel().ExecCounter).
+		WithStdout(converter).
+		WithPriority(s.Priority).
+		WithRunnerPool(s.runnerPool).
+		WithOutputEncoding(s.OutputEncoding).
+		WithInterruptGrace(s.InterruptGrace).
+		WithWatchdog(s.Watchdog).
+		WithStderr(newJupyterStackTraceMapperWriter(msg, "stderr", s.CodePath(), fileToCellIdAndLine)).
+		Exec()
+	if convErr := converter.finish(); convErr != nil {
+		if err == nil {
+			err = convErr
+		} else {
+			klog.Warningf("%+v", convErr)
+		}
+	}
+	if err != nil {
+		klog.Infof("goexec.executeTestReport(): failed to run the compiled tests: %+v", err)
+		return err
+	}
+	if msg.Kernel().Interrupted.Load() {
+		return nil
+	}
+
+	var coverageHtml string
+	if s.CellTestReport.Cover {
+		results.Coverage, err = s.filterCoverProfile()
+		if err == nil {
+			coverageHtml, err = s.coverageReportHtml()
+		}
+		if err != nil {
+			klog.Warningf("Failed to generate the coverage report: %+v", err)
+			_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf("Coverage report failed: %v\n", err))
+		}
+	}
+	htmlReport, textReport, err := renderTestReport(results, s.CellTestReport.Verbose, coverageHtml,
+		path.Base(s.CodePath()), fileToCellIdAndLine)
+	if err != nil {
+		return err
+	}
+	err = kernel.PublishData(msg, kernel.Data{
+		Data: kernel.MIMEMap{
+			string(protocol.MIMETextHTML):  htmlReport,
+			string(protocol.MIMETextPlain): textReport,
+		},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: make(kernel.MIMEMap),
+	})
+	if err != nil {
+		return err
+	}
+	if s.CellProfile != "" {
+		if profErr := s.PublishProfile(msg); profErr != nil {
+			return errors.WithMessagef(profErr, "%%prof %s", s.CellProfile)
+		}
+	}
+	return nil
+}
+
+// coverTests returns whether the tests of the current cell are compiled with coverage: in which case the cell
+// code is saved to `main.go` (since `_test.go` files are not instrumented), and the tests are registered
+// by wrappers synthesized in `main_test.go`.
+func (s *State) coverTests() bool {
+	return s.CellIsTest && s.CellTestReport != nil && s.CellTestReport.Cover
+}
+
+// coverTestPrefix is prepended to the name of the test functions in `main.go`, when compiling with coverage.
+const coverTestPrefix = "gonbCover"
+
+// testingType returns the type in package "testing" of the parameter of the test function `name`, or empty if
+// `name` is not a test (or benchmark, or fuzz test) function name.
+func testingType(name string) string {
+	if name == "TestMain" {
+		return "M"
+	}
+	for prefix, paramType := range map[string]string{"Test": "T", "Benchmark": "B", "Fuzz": "F"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		// As in `go test`: "Testify" is not a test, but "Test", "TestFoo" and "Test_foo" are.
+		if rest := name[len(prefix):]; rest != "" && unicode.IsLower([]rune(rest)[0]) {
+			return ""
+		}
+		return paramType
+	}
+	return ""
+}
+
+// writeCoverTestWrappers renames the test functions in `main.go` (prefixing them with coverTestPrefix), and
+// synthesizes `main_test.go` with the test functions calling them, so they are found by `go test`.
+// It is a no-op if the tests are not compiled with coverage.
+func (s *State) writeCoverTestWrappers() error {
+	if !s.coverTests() {
+		return nil
+	}
+	mainPath := path.Join(s.TempDir, MainGo)
+	content, err := os.ReadFile(mainPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %q", mainPath)
+	}
+	fileSet := token.NewFileSet()
+	fileObj, err := parser.ParseFile(fileSet, mainPath, content, parser.SkipObjectResolution)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %q", mainPath)
+	}
+	var renamed, wrappers bytes.Buffer
+	from := 0
+	for _, decl := range fileObj.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Recv != nil {
+			continue
+		}
+		name := funcDecl.Name.Name
+		paramType := testingType(name)
+		if paramType == "" {
+			continue
+		}
+		offset := fileSet.Position(funcDecl.Name.Pos()).Offset
+		renamed.Write(content[from:offset])
+		renamed.WriteString(coverTestPrefix)
+		from = offset
+		_, _ = fmt.Fprintf(&wrappers, "\nfunc %s(x *testing.%s) { %s%s(x) }\n", name, paramType, coverTestPrefix, name)
+	}
+	if wrappers.Len() == 0 {
+		return nil
+	}
+	renamed.Write(content[from:])
+	if err = os.WriteFile(mainPath, renamed.Bytes(), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", mainPath)
+	}
+	testPath := path.Join(s.TempDir, MainTestGo)
+	wrappersCode := "// Code generated by GoNB for `%test -cover`. DO NOT EDIT.\n\npackage main\n\nimport \"testing\"\n" +
+		wrappers.String()
+	if err = os.WriteFile(testPath, []byte(wrappersCode), 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", testPath)
+	}
+	return nil
+}
+
+// filterCoverProfile removes from the coverage profile the blocks of the test functions themselves (renamed by
+// writeCoverTestWrappers) and of `main()`, which is not executed by tests, and returns the resulting coverage, e.g.: "75.0% of statements".
+func (s *State) filterCoverProfile() (string, error) {
+	mainPath := path.Join(s.TempDir, MainGo)
+	fileSet := token.NewFileSet()
+	fileObj, err := parser.ParseFile(fileSet, mainPath, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse %q", mainPath)
+	}
+	type lineRange struct{ from, to int }
+	var testFuncs []lineRange
+	for _, decl := range fileObj.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil &&
+			(strings.HasPrefix(funcDecl.Name.Name, coverTestPrefix) || funcDecl.Name.Name == "main") {
+			testFuncs = append(testFuncs, lineRange{
+				fileSet.Position(funcDecl.Pos()).Line, fileSet.Position(funcDecl.End()).Line})
+		}
+	}
+
+	profilePath := s.CoverProfilePath()
+	content, err := os.ReadFile(profilePath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read coverage profile %q", profilePath)
+	}
+	var filtered strings.Builder
+	var statements, covered int
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		matches := regexpCoverBlock.FindStringSubmatch(line)
+		if matches == nil {
+			// Header, e.g.: "mode: set".
+			filtered.WriteString(line + "\n")
+			continue
+		}
+		startLine, _ := strconv.Atoi(matches[2])
+		if path.Base(matches[1]) == MainGo && slices.ContainsFunc(testFuncs, func(r lineRange) bool {
+			return startLine >= r.from && startLine <= r.to
+		}) {
+			continue
+		}
+		filtered.WriteString(line + "\n")
+		numStatements, _ := strconv.Atoi(matches[3])
+		statements += numStatements
+		if matches[4] != "0" {
+			covered += numStatements
+		}
+	}
+	if err = os.WriteFile(profilePath, []byte(filtered.String()), 0600); err != nil {
+		return "", errors.Wrapf(err, "failed to write coverage profile %q", profilePath)
+	}
+	if statements == 0 {
+		return "[no statements]", nil
+	}
+	return fmt.Sprintf("%.1f%% of statements", 100*float64(covered)/float64(statements)), nil
+}
+
+// regexpCoverBlock matches a block in a coverage profile: "<file>:<startLine>.<startCol>,<endLine>.<endCol>
+// <numStatements> <count>".
+var regexpCoverBlock = regexp.MustCompile(`^(.+):(\d+)\.\d+,\d+\.\d+ (\d+) (\d+)$`)
+
+// coverageReportHtml returns the HTML coverage report generated by `go tool cover` for the current cell.
+func (s *State) coverageReportHtml() (string, error) {
+	reportPath := path.Join(s.TempDir, coverReportFile)
+	cmd := exec.Command("go", "tool", "cover", "-html="+s.CoverProfilePath(), "-o", reportPath)
+	cmd.Dir = s.TempDir
+	klog.V(2).Infof("Executing %s", cmd)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", errors.Wrapf(err, "failed to run %q: %s", cmd, output)
+	}
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read coverage report %q", reportPath)
+	}
+	return string(content), nil
+}
+
+// testEvent is an event generated by `go tool test2json`, see `go doc test2json`.
+type testEvent struct {
+	Action     string
+	Test       string
+	Elapsed    float64
+	Output     string
+	OutputType string
+}
+
+// testEventsConverter is an io.Writer that pipes the output of a test binary (executed with
+// `-test.v=test2json`) through `go tool test2json`, and collects the resulting events into testResults.
+type testEventsConverter struct {
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+	done   chan error
+}
+
+// startTestEventsConverter starts `go tool test2json` in `dir`, collecting the events into `results`.
+func startTestEventsConverter(dir string, results *testResults) (*testEventsConverter, error) {
+	c := &testEventsConverter{done: make(chan error, 1)}
+	cmd := exec.Command("go", "tool", "test2json")
+	cmd.Dir = dir
+	cmd.Stderr = &c.stderr
+	var err error
+	c.stdin, err = cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create pipe for `go tool test2json`")
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create pipe for `go tool test2json`")
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start `go tool test2json`")
+	}
+	go func() {
+		decoder := json.NewDecoder(stdout)
+		for {
+			var event testEvent
+			if err := decoder.Decode(&event); err != nil {
+				if err != io.EOF {
+					klog.Errorf("Failed to decode `go tool test2json` events: %+v", err)
+					_, _ = io.Copy(io.Discard, stdout)
+				}
+				break
+			}
+			results.add(&event)
+		}
+		results.flush()
+		c.done <- cmd.Wait()
+	}()
+	return c, nil
+}
+
+// Write implements io.Writer, piping the output of the test binary to `go tool test2json`.
+func (c *testEventsConverter) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// File implements jpyexec.FileWriter, so the output of the test binary is moved directly to the
+// `go tool test2json` pipe.
+func (c *testEventsConverter) File() *os.File {
+	f, _ := c.stdin.(*os.File)
+	return f
+}
+
+// finish waits for all the events to be collected.
+func (c *testEventsConverter) finish() error {
+	_ = c.stdin.Close()
+	if err := <-c.done; err != nil {
+		return errors.Wrapf(err, "`go tool test2json` failed: %s", c.stderr.String())
+	}
+	return nil
+}
+
+// testResult holds the results of one test (or subtest, or benchmark).
+type testResult struct {
+	Name string
+
+	// Result is "pass", "fail", "skip", or empty if the test didn't finish.
+	Result  string
+	Elapsed float64
+	Output  strings.Builder
+}
+
+// IsBenchmark returns whether the result is of a benchmark function.
+func (r *testResult) IsBenchmark() bool {
+	return strings.HasPrefix(r.Name, "Benchmark")
+}
+
+// benchmarkResult holds the metrics of one benchmark, parsed from its output line.
+type benchmarkResult struct {
+	Name       string
+	Iterations string
+
+	// Metrics maps units (e.g.: "ns/op", "B/op", "allocs/op") to their values.
+	Metrics map[string]string
+}
+
+// testResults collects the events of a test execution.
+type testResults struct {
+	Tests      []*testResult
+	byName     map[string]*testResult
+	Benchmarks []*benchmarkResult
+
+	// BenchmarkUnits are the units of all benchmark metrics, in the order they first appeared.
+	BenchmarkUnits []string
+
+	// Coverage reported by the test binary, e.g.: "75.0% of statements".
+	Coverage string
+
+	// Output not associated with any test, except the usual headers and footers (e.g.: panic messages).
+	Output strings.Builder
+
+	// Failed is set if the test binary reported a failure.
+	Failed bool
+
+	// partial holds the last line of output of each test (nil for the package) not yet terminated by a new line.
+	partial map[*testResult]string
+}
+
+func newTestResults() *testResults {
+	return &testResults{byName: make(map[string]*testResult), partial: make(map[*testResult]string)}
+}
+
+var (
+	regexpTestFrame       = regexp.MustCompile(`^\s*(=== (RUN|PAUSE|CONT|NAME)|--- (PASS|FAIL|SKIP|BENCH):)`)
+	regexpTestHeader      = regexp.MustCompile(`^(PASS|FAIL|ok\s.*|goos: .*|goarch: .*|pkg: .*|cpu: .*)$`)
+	regexpTestCoverage    = regexp.MustCompile(`^coverage: (.*)$`)
+	regexpBenchmarkResult = regexp.MustCompile(`^(Benchmark\S*)\s+(\d+)\s+(.*)$`)
+)
+
+// add collects one event.
+func (r *testResults) add(event *testEvent) {
+	if event.Test == "" {
+		switch event.Action {
+		case "fail":
+			r.Failed = true
+		case "output":
+			r.addOutput(nil, event)
+		}
+		return
+	}
+
+	test, found := r.byName[event.Test]
+	if !found {
+		test = &testResult{Name: event.Test}
+		r.byName[event.Test] = test
+		r.Tests = append(r.Tests, test)
+		r.partial[test] = ""
+	}
+	switch event.Action {
+	case "pass", "fail", "skip":
+		test.Result = event.Action
+		test.Elapsed = event.Elapsed
+		if event.Action == "fail" {
+			r.Failed = true
+		}
+	case "output":
+		r.addOutput(test, event)
+	}
+}
+
+// addOutput collects the output of `test` (or of the package, if `test` is nil), one line at a time: a line
+// may be split into several events, e.g. the name of a benchmark and its metrics.
+func (r *testResults) addOutput(test *testResult, event *testEvent) {
+	if event.OutputType == "frame" {
+		return
+	}
+	pending := r.partial[test] + event.Output
+	for {
+		eol := strings.IndexByte(pending, '\n')
+		if eol == -1 {
+			break
+		}
+		r.addLine(test, pending[:eol+1])
+		pending = pending[eol+1:]
+	}
+	r.partial[test] = pending
+}
+
+// flush collects the last lines of output not terminated by a new line.
+func (r *testResults) flush() {
+	for test, pending := range r.partial {
+		if pending != "" {
+			r.addLine(test, pending)
+			r.partial[test] = ""
+		}
+	}
+}
+
+// addLine collects a line of output of `test` (or of the package, if `test` is nil).
+func (r *testResults) addLine(test *testResult, output string) {
+	line := strings.TrimRight(output, "\n")
+	if regexpTestFrame.MatchString(line) || r.addBenchmark(line) {
+		return
+	}
+	if test == nil {
+		if regexpTestHeader.MatchString(line) {
+			return
+		}
+		if matches := regexpTestCoverage.FindStringSubmatch(line); matches != nil {
+			r.Coverage = matches[1]
+			return
+		}
+		r.Output.WriteString(output)
+		return
+	}
+	if test.IsBenchmark() && strings.TrimSpace(line) == test.Name {
+		// Benchmark name printed before running it.
+		return
+	}
+	test.Output.WriteString(output)
+}
+
+// addBenchmark parses and collects the benchmark metrics in `line`, if it is a benchmark result.
+func (r *testResults) addBenchmark(line string) bool {
+	matches := regexpBenchmarkResult.FindStringSubmatch(line)
+	if matches == nil {
+		return false
+	}
+	fields := strings.Fields(matches[3])
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		return false
+	}
+	bench := &benchmarkResult{Name: matches[1], Iterations: matches[2], Metrics: make(map[string]string)}
+	for ii := 0; ii < len(fields); ii += 2 {
+		value, unit := fields[ii], fields[ii+1]
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return false
+		}
+		bench.Metrics[unit] = value
+	}
+	for ii := 0; ii < len(fields); ii += 2 {
+		unit := fields[ii+1]
+		if !slices.Contains(r.BenchmarkUnits, unit) {
+			r.BenchmarkUnits = append(r.BenchmarkUnits, unit)
+		}
+	}
+	r.Benchmarks = append(r.Benchmarks, bench)
+	return true
+}
+
+// mapCodeFileLines prefixes references to lines of the generated code file `fileName` (e.g. "main_test.go:12")
+// in the test output with their corresponding cell lines.
+func mapCodeFileLines(output, fileName string, fileToCellIdAndLine []CellIdAndLine) string {
+	regexpFileLine := regexp.MustCompile(regexp.QuoteMeta(fileName) + `:(\d+)`)
+	return regexpFileLine.ReplaceAllStringFunc(output, func(match string) string {
+		lineNum, err := strconv.Atoi(strings.Split(match, ":")[1])
+		lineNum -= 1 // Since line reporting starts with 1, but our indices start with 0.
+		if err != nil || lineNum < 0 || lineNum >= len(fileToCellIdAndLine) {
+			return match
+		}
+		cellId, cellLineNum := fileToCellIdAndLine[lineNum].Id, fileToCellIdAndLine[lineNum].Line
+		if cellLineNum == NoCursorLine {
+			return match
+		}
+		if cellId == -1 {
+			return fmt.Sprintf("[[ Cell Line %d ]] %s", cellLineNum+1, match)
+		}
+		return fmt.Sprintf("[[ Cell [%d] Line %d ]] %s", cellId, cellLineNum+1, match)
+	})
+}
+
+// testReportRow is a row of the tests table of the report.
+type testReportRow struct {
+	Name, Result, Elapsed, Output string
+	ShowOutput                    bool
+}
+
+// renderTestReport renders the test results as HTML and as plain text.
+func renderTestReport(results *testResults, verbose bool, coverageHtml, codeFileName string,
+	fileToCellIdAndLine []CellIdAndLine) (htmlReport, textReport string, err error) {
+	var text strings.Builder
+	var rows []*testReportRow
+	counts := make(map[string]int)
+	for _, test := range results.Tests {
+		if test.IsBenchmark() && test.Result != "fail" {
+			// Benchmarks are reported in their own table.
+			continue
+		}
+		row := &testReportRow{
+			Name:    test.Name,
+			Result:  strings.ToUpper(test.Result),
+			Elapsed: fmt.Sprintf("%.2fs", test.Elapsed),
+			Output:  mapCodeFileLines(test.Output.String(), codeFileName, fileToCellIdAndLine),
+		}
+		if row.Result == "" {
+			row.Result = "UNFINISHED"
+		}
+		counts[row.Result]++
+		row.ShowOutput = row.Output != "" && (verbose || test.Result != "pass")
+		rows = append(rows, row)
+		_, _ = fmt.Fprintf(&text, "--- %s: %s (%s)\n", row.Result, row.Name, row.Elapsed)
+		if row.ShowOutput {
+			text.WriteString(row.Output)
+		}
+	}
+	output := mapCodeFileLines(results.Output.String(), codeFileName, fileToCellIdAndLine)
+	text.WriteString(output)
+
+	type benchmarkRow struct {
+		Name, Iterations string
+		Values           []string
+	}
+	var benchmarks []benchmarkRow
+	for _, bench := range results.Benchmarks {
+		row := benchmarkRow{Name: bench.Name, Iterations: bench.Iterations}
+		_, _ = fmt.Fprintf(&text, "%s\t%s", bench.Name, bench.Iterations)
+		for _, unit := range results.BenchmarkUnits {
+			value := bench.Metrics[unit]
+			row.Values = append(row.Values, value)
+			if value != "" {
+				_, _ = fmt.Fprintf(&text, "\t%s %s", value, unit)
+			}
+		}
+		text.WriteString("\n")
+		benchmarks = append(benchmarks, row)
+	}
+
+	var summary []string
+	for _, result := range []string{"PASS", "FAIL", "SKIP", "UNFINISHED"} {
+		if counts[result] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[result], testResultNames[result]))
+		}
+	}
+	if len(benchmarks) > 0 {
+		summary = append(summary, fmt.Sprintf("%d benchmark(s)", len(benchmarks)))
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "no tests were run")
+	}
+	if results.Coverage != "" {
+		summary = append(summary, "coverage: "+results.Coverage)
+	}
+	status := "PASS"
+	if results.Failed {
+		status = "FAIL"
+	}
+	_, _ = fmt.Fprintf(&text, "%s: %s\n", status, strings.Join(summary, ", "))
+
+	var buf bytes.Buffer
+	err = templateTestReport.Execute(&buf, map[string]any{
+		"Status":         status,
+		"Summary":        strings.Join(summary, ", "),
+		"Tests":          rows,
+		"Output":         output,
+		"BenchmarkUnits": results.BenchmarkUnits,
+		"Benchmarks":     benchmarks,
+		"CoverageHtml":   coverageHtml,
+	})
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to render test report")
+	}
+	return buf.String(), text.String(), nil
+}
+
+// testResultNames are used in the summary of the report.
+var testResultNames = map[string]string{
+	"PASS":       "passed",
+	"FAIL":       "failed",
+	"SKIP":       "skipped",
+	"UNFINISHED": "unfinished",
+}
+
+var templateTestReport = template.Must(template.New("test_report").Funcs(template.FuncMap{
+	"icon": func(result string) string {
+		switch result {
+		case "PASS":
+			return "✅"
+		case "FAIL":
+			return "❌"
+		case "SKIP":
+			return "⏭️"
+		}
+		return "⚠️"
+	},
+}).Parse(`
+<style>
+.gonb-test-report table { border-collapse: collapse; margin: 4px 0; }
+.gonb-test-report th, .gonb-test-report td { padding: 2px 8px; text-align: left; }
+.gonb-test-report td.gonb-num { text-align: right; font-family: monospace; }
+.gonb-test-report pre { margin: 2px 0 6px 16px; }
+</style>
+<div class="gonb-test-report">
+<p><b>{{icon .Status}} {{.Status}}</b>: {{.Summary}}</p>
+{{if .Tests}}<table>
+<tr><th>Test</th><th>Result</th><th>Time</th></tr>
+{{range .Tests}}<tr><td>{{.Name}}</td><td>{{icon .Result}} {{.Result}}</td><td class="gonb-num">{{.Elapsed}}</td></tr>
+{{end}}</table>
+{{range .Tests}}{{if .ShowOutput}}<details {{if ne .Result "PASS"}}open{{end}}><summary>{{icon .Result}} <b>{{.Name}}</b> output</summary>
+<pre>{{.Output}}</pre>
+</details>
+{{end}}{{end}}{{end}}
+{{if .Output}}<pre>{{.Output}}</pre>{{end}}
+{{if .Benchmarks}}<table>
+<tr><th>Benchmark</th><th>Iterations</th>{{range .BenchmarkUnits}}<th>{{.}}</th>{{end}}</tr>
+{{range .Benchmarks}}<tr><td>{{.Name}}</td><td class="gonb-num">{{.Iterations}}</td>{{range .Values}}<td class="gonb-num">{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+{{if .CoverageHtml}}<details><summary><b>Coverage report</b></summary>
+<iframe srcdoc="{{.CoverageHtml}}" style="width: 100%; height: 600px; border: none;"></iframe>
+</details>{{end}}
+</div>
+`))
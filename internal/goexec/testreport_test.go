@@ -0,0 +1,91 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTestResults(t *testing.T) {
+	results := newTestResults()
+	for _, event := range []testEvent{
+		{Action: "start"},
+		{Action: "output", Output: "goos: linux\n"},
+		{Action: "run", Test: "TestA"},
+		{Action: "output", Test: "TestA", Output: "=== RUN   TestA\n", OutputType: "frame"},
+		{Action: "output", Test: "TestA", Output: "hello\n"},
+		{Action: "pass", Test: "TestA", Elapsed: 0.5},
+		{Action: "run", Test: "TestB"},
+		{Action: "output", Test: "TestB", Output: "    main_test.go:3: expected 1\n"},
+		{Action: "output", Test: "TestB", Output: "        got 2\n"},
+		{Action: "output", Test: "TestB", Output: "--- FAIL: TestB (0.00s)\n"},
+		{Action: "fail", Test: "TestB"},
+		{Action: "run", Test: "BenchmarkC"},
+		{Action: "output", Test: "BenchmarkC", Output: "BenchmarkC\n"},
+		// Benchmark results may be split in several events.
+		{Action: "output", Test: "BenchmarkC", Output: "BenchmarkC-8 \t"},
+		{Action: "output", Test: "BenchmarkC", Output: "    1000\t  135.4 ns/op\t  3 B/op\t  0 allocs/op\n"},
+		{Action: "output", Output: "FAIL\n", OutputType: "frame"},
+		{Action: "output", Output: "coverage: 50.0% of statements\n"},
+		{Action: "fail"},
+	} {
+		results.add(&event)
+	}
+	results.flush()
+
+	require.Len(t, results.Tests, 3)
+	assert.Equal(t, "pass", results.Tests[0].Result)
+	assert.Equal(t, "hello\n", results.Tests[0].Output.String())
+	assert.Equal(t, "fail", results.Tests[1].Result)
+	assert.Equal(t, "    main_test.go:3: expected 1\n        got 2\n", results.Tests[1].Output.String())
+	assert.Empty(t, results.Tests[2].Output.String())
+	assert.True(t, results.Failed)
+	assert.Equal(t, "50.0% of statements", results.Coverage)
+	assert.Empty(t, results.Output.String())
+	require.Len(t, results.Benchmarks, 1)
+	assert.Equal(t, &benchmarkResult{Name: "BenchmarkC-8", Iterations: "1000",
+		Metrics: map[string]string{"ns/op": "135.4", "B/op": "3", "allocs/op": "0"}}, results.Benchmarks[0])
+	assert.Equal(t, []string{"ns/op", "B/op", "allocs/op"}, results.BenchmarkUnits)
+
+	fileToCellIdAndLine := []CellIdAndLine{{NoCursorLine, NoCursorLine}, {NoCursorLine, NoCursorLine}, {7, 1}}
+	htmlReport, textReport, err := renderTestReport(results, false, "<html>coverage</html>", MainTestGo,
+		fileToCellIdAndLine)
+	require.NoError(t, err)
+	assert.Equal(t, "--- PASS: TestA (0.50s)\n"+
+		"--- FAIL: TestB (0.00s)\n"+
+		"    [[ Cell [7] Line 2 ]] main_test.go:3: expected 1\n        got 2\n"+
+		"BenchmarkC-8\t1000\t135.4 ns/op\t3 B/op\t0 allocs/op\n"+
+		"FAIL: 1 passed, 1 failed, 1 benchmark(s), coverage: 50.0% of statements\n", textReport)
+	assert.Contains(t, htmlReport, "<td>TestB</td><td>❌ FAIL</td>")
+	assert.NotContains(t, htmlReport, "hello", "output of passing tests is only displayed if verbose")
+	assert.Contains(t, htmlReport, "<th>allocs/op</th>")
+	assert.Contains(t, htmlReport, `srcdoc="&lt;html&gt;coverage&lt;/html&gt;"`)
+
+	htmlReport, _, err = renderTestReport(results, true, "", MainTestGo, fileToCellIdAndLine)
+	require.NoError(t, err)
+	assert.Contains(t, htmlReport, "hello")
+	assert.NotContains(t, htmlReport, "srcdoc")
+}
+
+func TestTestingType(t *testing.T) {
+	for name, want := range map[string]string{
+		"Test": "T", "TestFoo": "T", "Test_foo": "T", "Testify": "", "TestMain": "M",
+		"BenchmarkFoo": "B", "FuzzFoo": "F", "Foo": "", "main": "",
+	} {
+		assert.Equal(t, want, testingType(name), "testingType(%q)", name)
+	}
+}
+
+func TestTestReportArgs(t *testing.T) {
+	s := &State{TempDir: "/tmp/gonb", CellIsTest: true, CellTests: []string{"TestA", "BenchmarkB"}}
+	s.CellTestReport = &TestReport{}
+	assert.Equal(t, []string{"-test.v=test2json", "-test.run=^TestA$", "-test.bench=^BenchmarkB$"}, s.testReportArgs())
+	s.CellTestReport = &TestReport{Args: []string{"-test.run=A"}}
+	assert.Equal(t, []string{"-test.v=test2json", "-test.run=A"}, s.testReportArgs())
+	s.CellTestReport = &TestReport{Bench: true, Cover: true}
+	assert.Equal(t, []string{"-test.v=test2json", "-test.run=^$", "-test.bench=^BenchmarkB$",
+		"-test.coverprofile=/tmp/gonb/" + CoverProfileFile}, s.testReportArgs())
+	s.CellTests = nil
+	s.CellTestReport = &TestReport{Bench: true}
+	assert.Equal(t, []string{"-test.v=test2json", "-test.run=^$", "-test.bench=."}, s.testReportArgs())
+}
@@ -0,0 +1,222 @@
+package goexec
+
+import (
+	"bytes"
+	. "github.com/janpfeifer/gonb/common"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"golang.org/x/exp/slices"
+	"strings"
+)
+
+// This file implements the introspection of the memorized declarations, used by the variable inspector
+// (see comms.VariableInspector).
+
+// MaxVariablePreview is the maximum length of the preview of the contents of a declaration in
+// VariableInfo.Content.
+const MaxVariablePreview = 100
+
+// VariableInfo describes one package-level declaration (variable, constant, function or type).
+//
+// The JSON field names follow the ones used by jupyterlab-variableInspector, so front-ends written
+// for it can display them as is.
+type VariableInfo struct {
+	Name string `json:"varName"`
+
+	// Kind is one of "var", "const", "func" or "type".
+	Kind string `json:"varKind"`
+
+	// Type of the declaration, if known: the declared type of variables and constants, or inferred from
+	// its value when it's cheap (e.g.: literals and composite literals), the signature of functions and
+	// the underlying type of types.
+	Type string `json:"varType"`
+
+	// Content is a preview of the declaration: the (truncated) value definition of variables and constants,
+	// the definition of types.
+	Content string `json:"varContent"`
+
+	// Size and Shape are not known without executing the program, and are left empty.
+	Size  string `json:"varSize"`
+	Shape string `json:"varShape"`
+
+	IsMatrix bool `json:"isMatrix"`
+	IsWidget bool `json:"isWidget"`
+
+	// CellId is the id of the cell (execution count) that declared it.
+	CellId int `json:"cellId"`
+}
+
+// InspectVariables returns the package-level variables, constants, functions and types currently
+// memorized, sorted by kind and name.
+//
+// Methods, anonymous variables (`_`) and the `main` and `init` functions are not included.
+func (s *State) InspectVariables() []VariableInfo {
+	decls := s.Definitions
+	infos := make([]VariableInfo, 0, len(decls.Variables)+len(decls.Constants)+len(decls.Functions)+len(decls.Types))
+	for _, key := range SortedKeys(decls.Variables) {
+		v := decls.Variables[key]
+		if v.Name == "_" {
+			continue
+		}
+		vType := v.TypeDefinition
+		if vType == "" {
+			vType = inferTypeOfValue(v.ValueDefinition)
+		}
+		infos = append(infos, VariableInfo{Name: v.Name, Kind: "var", Type: vType,
+			Content: variablePreview(v.ValueDefinition), CellId: v.CellId()})
+	}
+	for _, key := range SortedKeys(decls.Constants) {
+		c := decls.Constants[key]
+		cType := c.TypeDefinition
+		if cType == "" {
+			if cType = inferTypeOfValue(c.ValueDefinition); cType != "" && !strings.Contains(cType, ".") {
+				cType = "untyped " + cType
+			}
+		}
+		infos = append(infos, VariableInfo{Name: c.Key, Kind: "const", Type: cType,
+			Content: variablePreview(c.ValueDefinition), CellId: c.CellId()})
+	}
+	for _, key := range SortedKeys(decls.Functions) {
+		f := decls.Functions[key]
+		if f.Receiver != "" || strings.Contains(key, "~") || f.Name == "main" || f.Name == "init" {
+			continue
+		}
+		infos = append(infos, VariableInfo{Name: f.Name, Kind: "func", Type: functionSignature(f.Definition),
+			CellId: f.CellId()})
+	}
+	for _, key := range SortedKeys(decls.Types) {
+		t := decls.Types[key]
+		infos = append(infos, VariableInfo{Name: t.Key, Kind: "type", Type: underlyingTypeKind(t.TypeDefinition),
+			Content: variablePreview(t.TypeDefinition), CellId: t.CellId()})
+	}
+	return infos
+}
+
+// variablePreview collapses the white spaces of the definition, and truncates it to MaxVariablePreview.
+func variablePreview(definition string) string {
+	preview := strings.Join(strings.Fields(definition), " ")
+	if runes := []rune(preview); len(runes) > MaxVariablePreview {
+		preview = string(runes[:MaxVariablePreview-1]) + "…"
+	}
+	return preview
+}
+
+// inferTypeOfValue returns the type of the value expression, if it can be inferred without type-checking:
+// basic literals (their default type), composite literals (`T{...}` and `&T{...}`), and `make`, `new`
+// and conversions to builtin types. It returns "" otherwise.
+func inferTypeOfValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	expr, err := parser.ParseExpr(value)
+	if err != nil {
+		return ""
+	}
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			return "int"
+		case token.FLOAT:
+			return "float64"
+		case token.IMAG:
+			return "complex128"
+		case token.CHAR:
+			return "rune"
+		case token.STRING:
+			return "string"
+		}
+	case *ast.Ident:
+		if e.Name == "true" || e.Name == "false" {
+			return "bool"
+		}
+	case *ast.CompositeLit:
+		return exprToString(e.Type)
+	case *ast.FuncLit:
+		return exprToString(e.Type)
+	case *ast.UnaryExpr:
+		if lit, ok := e.X.(*ast.CompositeLit); ok && e.Op == token.AND && lit.Type != nil {
+			return "*" + exprToString(lit.Type)
+		}
+	case *ast.CallExpr:
+		fn, ok := e.Fun.(*ast.Ident)
+		if !ok || len(e.Args) == 0 {
+			return ""
+		}
+		switch {
+		case fn.Name == "make":
+			return exprToString(e.Args[0])
+		case fn.Name == "new":
+			return "*" + exprToString(e.Args[0])
+		case slices.Contains(builtinConversionTypes, fn.Name):
+			return fn.Name
+		}
+	}
+	return ""
+}
+
+// builtinConversionTypes are the builtin types whose conversions (e.g.: `float32(1)`) are used to infer
+// the type of values.
+var builtinConversionTypes = []string{
+	"bool", "byte", "complex64", "complex128", "error", "float32", "float64",
+	"int", "int8", "int16", "int32", "int64", "rune", "string",
+	"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+}
+
+// functionSignature returns the signature of the function definition (without the body and comments),
+// or "" if it fails to parse.
+func functionSignature(definition string) string {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", "package p\n"+definition, parser.SkipObjectResolution)
+	if err != nil {
+		return ""
+	}
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
+			funcDecl.Doc = nil
+			funcDecl.Body = nil
+			return nodeToString(fileSet, funcDecl)
+		}
+	}
+	return ""
+}
+
+// underlyingTypeKind returns "struct" or "interface" for the corresponding type definitions, or the
+// underlying type otherwise (e.g.: "map[string]int"). It returns "" if it fails to parse.
+func underlyingTypeKind(typeDefinition string) string {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "", "package p\ntype "+typeDefinition, parser.SkipObjectResolution)
+	if err != nil || len(file.Decls) == 0 {
+		return ""
+	}
+	genDecl, ok := file.Decls[0].(*ast.GenDecl)
+	if !ok || len(genDecl.Specs) == 0 {
+		return ""
+	}
+	switch tType := genDecl.Specs[0].(*ast.TypeSpec).Type; tType.(type) {
+	case *ast.StructType:
+		return "struct"
+	case *ast.InterfaceType:
+		return "interface"
+	default:
+		return nodeToString(fileSet, tType)
+	}
+}
+
+// exprToString prints the expression, or returns "" if it is nil.
+func exprToString(expr ast.Expr) string {
+	if expr == nil {
+		return ""
+	}
+	return nodeToString(token.NewFileSet(), expr)
+}
+
+func nodeToString(fileSet *token.FileSet, node any) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fileSet, node); err != nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
@@ -0,0 +1,49 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestInspectVariables(t *testing.T) {
+	s := &State{Definitions: NewDeclarations()}
+	decls := s.Definitions
+	decls.Variables["x"] = &Variable{CellLines: CellLines{Id: 1}, Key: "x", Name: "x", ValueDefinition: "1.5"}
+	decls.Variables["p"] = &Variable{CellLines: CellLines{Id: 1}, Key: "p", Name: "p", ValueDefinition: "&image.Point{X: 1}"}
+	decls.Variables["m"] = &Variable{CellLines: CellLines{Id: 2}, Key: "m", Name: "m", ValueDefinition: "make(map[string]int)"}
+	decls.Variables["f"] = &Variable{CellLines: CellLines{Id: 2}, Key: "f", Name: "f", ValueDefinition: "g()"}
+	decls.Variables["e"] = &Variable{CellLines: CellLines{Id: 2}, Key: "e", Name: "e", TypeDefinition: "error"}
+	decls.Variables["s"] = &Variable{CellLines: CellLines{Id: 2}, Key: "s", Name: "s",
+		ValueDefinition: "\"" + strings.Repeat("a", 200) + "\""}
+	decls.Variables["_~1"] = &Variable{Key: "_~1", Name: "_", ValueDefinition: "g()"}
+	decls.Constants["Pi"] = &Constant{CellLines: CellLines{Id: 3}, Key: "Pi", ValueDefinition: "3.14"}
+	decls.Constants["N"] = &Constant{CellLines: CellLines{Id: 3}, Key: "N", TypeDefinition: "uint8", ValueDefinition: "iota"}
+	decls.Functions["g"] = &Function{CellLines: CellLines{Id: 4}, Key: "g", Name: "g",
+		Definition: "// g does things.\nfunc g[T any](a, b T) (int, error) {\n\treturn 0, nil\n}"}
+	decls.Functions["main"] = &Function{Key: "main", Name: "main", Definition: "func main() {}"}
+	decls.Functions["T~M"] = &Function{Key: "T~M", Name: "M", Receiver: "T", Definition: "func (T) M() {}"}
+	decls.Types["T"] = &TypeDecl{CellLines: CellLines{Id: 5}, Key: "T", TypeDefinition: "T struct {\n\tA int\n}"}
+	decls.Types["Ids"] = &TypeDecl{CellLines: CellLines{Id: 5}, Key: "Ids", TypeDefinition: "Ids []int"}
+
+	infos := s.InspectVariables()
+	byName := make(map[string]VariableInfo, len(infos))
+	var names []string
+	for _, info := range infos {
+		byName[info.Name] = info
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{"e", "f", "m", "p", "s", "x", "N", "Pi", "g", "Ids", "T"}, names)
+	assert.Equal(t, VariableInfo{Name: "x", Kind: "var", Type: "float64", Content: "1.5", CellId: 1}, byName["x"])
+	assert.Equal(t, "*image.Point", byName["p"].Type)
+	assert.Equal(t, "map[string]int", byName["m"].Type)
+	assert.Equal(t, "", byName["f"].Type, "type of function calls can't be inferred")
+	assert.Equal(t, "error", byName["e"].Type)
+	assert.Equal(t, "string", byName["s"].Type)
+	assert.Len(t, []rune(byName["s"].Content), MaxVariablePreview)
+	assert.Equal(t, "untyped float64", byName["Pi"].Type)
+	assert.Equal(t, "uint8", byName["N"].Type)
+	assert.Equal(t, VariableInfo{Name: "g", Kind: "func", Type: "func g[T any](a, b T) (int, error)", CellId: 4}, byName["g"])
+	assert.Equal(t, VariableInfo{Name: "T", Kind: "type", Type: "struct", Content: "T struct { A int }", CellId: 5}, byName["T"])
+	assert.Equal(t, "[]int", byName["Ids"].Type)
+}
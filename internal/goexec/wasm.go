@@ -2,16 +2,19 @@ package goexec
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/internal/kernel"
 	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
 	"k8s.io/klog/v2"
 	"os"
 	"os/exec"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 )
 
@@ -25,8 +28,12 @@ const (
 	CompiledWasmName   = "gonb_cell.wasm"
 )
 
-// MakeWasmSubdir creates a subdirectory named `.wasm/<notebook name>/` in the
-// same directory as the notebook, if it is not yet created.
+// MakeWasmSubdir creates a subdirectory named `jupyter_files/<kernel unique id>/` under the
+// Jupyter root directory, so the files in it are served by Jupyter, if it is not yet created.
+//
+// If the Jupyter root directory can't be found, the subdirectory is created in the
+// temporary directory of the kernel instead, and the URL is left empty: the compiled wasm
+// is then delivered to the front-end through the websocket (see ExecuteWasm).
 //
 // It also copies current Go compiler `wasm_exec.js` file to this directory, if
 // it's not there already.
@@ -34,41 +41,41 @@ const (
 // Path and URL to access it are stored in s.WasmDir and s.WasmUrl.
 func (s *State) MakeWasmSubdir() (err error) {
 	// Check if value already cached.
-	if s.WasmDir != "" && s.WasmUrl != "" {
+	if s.WasmDir != "" {
 		return nil
 	}
 
-	// Set and create `WasmDir`.
+	// Set and create `WasmDir`, and set `WasmUrl`.
 	var jupyterRoot string
 	jupyterRoot, err = JupyterRootDirectory()
 	if err != nil {
-		return
+		klog.Warningf("`%%wasm`: %v -- the compiled wasm will be sent through the websocket instead", err)
+		err = nil
+		s.WasmDir = path.Join(s.TempDir, "wasm")
+		s.WasmUrl = ""
+	} else {
+		s.WasmDir = path.Join(jupyterRoot, JupyterFilesSubdir, s.UniqueID)
+		s.WasmUrl = path.Join("/files", JupyterFilesSubdir, s.UniqueID)
 	}
-	s.WasmDir = path.Join(jupyterRoot, JupyterFilesSubdir, s.UniqueID)
 	err = os.MkdirAll(s.WasmDir, 0777)
 	if err != nil {
 		err = errors.Wrapf(err, "failed to created subdirectory %q required to install WASM files", s.WasmDir)
+		s.WasmDir = ""
 		return
 	}
 
-	// Set `WasmUrl`.
-	s.WasmUrl = path.Join("/files", JupyterFilesSubdir, s.UniqueID)
-
 	// Copy over `wasm_exec.js` if needed.
 	var wasmExecSrc string
-	wasmExecSrc, err = GoRoot()
+	wasmExecSrc, err = wasmExecJsPath()
 	if err != nil {
-		err = errors.WithMessage(err, "failed to find GOROOT, needed to copy wasm_exec.js for WASM programs")
 		return
 	}
-	klog.Infof("GOROOT=%q", goRoot)
-	wasmExecSrc = path.Join(wasmExecSrc, "misc", "wasm", "wasm_exec.js")
 	wasmExecDst := path.Join(s.WasmDir, "wasm_exec.js")
 
 	var data []byte
 	data, err = os.ReadFile(wasmExecSrc)
 	if err != nil {
-		err = errors.Wrapf(err, "failed to read '$GOROOT/misc/wasm/wasm_exec.js'")
+		err = errors.Wrapf(err, "failed to read %q", wasmExecSrc)
 		return
 	}
 	err = os.WriteFile(wasmExecDst, data, 0775)
@@ -89,6 +96,23 @@ func (s *State) MakeWasmSubdir() (err error) {
 	return
 }
 
+// wasmExecJsPath returns the path to the `wasm_exec.js` of the Go compiler: it is in `$GOROOT/lib/wasm`
+// since Go 1.24, and in `$GOROOT/misc/wasm` before.
+func wasmExecJsPath() (string, error) {
+	goRoot, err := GoRoot()
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to find GOROOT, needed to copy wasm_exec.js for WASM programs")
+	}
+	klog.Infof("GOROOT=%q", goRoot)
+	for _, dir := range []string{"lib", "misc"} {
+		wasmExecPath := path.Join(goRoot, dir, "wasm", "wasm_exec.js")
+		if _, err := os.Stat(wasmExecPath); err == nil {
+			return wasmExecPath, nil
+		}
+	}
+	return "", errors.Errorf("failed to find `wasm_exec.js` in '$GOROOT/lib/wasm' or '$GOROOT/misc/wasm' (GOROOT=%q)", goRoot)
+}
+
 var jupyterRootDirectory string
 
 // JupyterRootDirectory returns Jupyter's root directory.
@@ -147,32 +171,113 @@ func GoRoot() (string, error) {
 
 var (
 	runWasmHtml = template.Must(template.New("wasm_exec_html").Parse(
-		`<div id="{{.WasmDivId}}"></div><script src="{{.WasmExecJsUrl}}"></script>`))
+		`<div id="{{.WasmDivId}}"></div>{{if .WasmExecJsUrl}}<script src="{{.WasmExecJsUrl}}"></script>{{end}}`))
 
 	runWasmScript = template.Must(template.New("wasm_exec_js").Parse(
 		`
-(() => {
-	var go_{{.Id}} = new globalThis.Go();
-	go_{{.Id}}.argv = ["js"].concat([{{range .Args}}"{{.}}", {{end}}]);
-	console.log("argv="+go_{{.Id}}.argv);
-	WebAssembly.instantiateStreaming(fetch("{{.CompiledWasmUrl}}"), go_{{.Id}}.importObject).
-		then((result) => { go_{{.Id}}.run(result.instance); });
+{{if .WasmExecJs}}if (!globalThis.Go) {
+{{.WasmExecJs}}
+}
+{{end}}(() => {
+	const id = "{{.WasmDivId}}";
+	const go = new globalThis.Go();
+	go.argv = ["js"].concat([{{range .Args}}"{{.}}", {{end}}]);
+{{if .Relay}}
+	// Relay the output of the program to the cell output: writes to stdout/stderr go through the global
+	// "fs.writeSync", so they are attributed to the cell whose program is making the call.
+	go.env = Object.assign({}, go.env, {"{{.WasmIdEnv}}": id});
+	let relay = globalThis.gonb_wasm_relay;
+	if (!relay) {
+		relay = {running: null};
+		globalThis.gonb_wasm_relay = relay;
+		const writeSync = globalThis.fs.writeSync.bind(globalThis.fs);
+		const decoder = new TextDecoder("utf-8");
+		globalThis.fs.writeSync = (fd, buf) => {
+			if (relay.running === null || !globalThis.gonb_comm) {
+				return writeSync(fd, buf);
+			}
+			globalThis.gonb_comm.send("{{.OutputAddress}}", {
+				id: relay.running,
+				stream: (fd === 2) ? "stderr" : "stdout",
+				text: decoder.decode(buf),
+			});
+			return buf.length;
+		};
+	}
+	const imports = go.importObject.gojs || go.importObject.go;
+	for (const name of ["runtime.wasmWrite", "syscall/js.valueCall"]) {
+		const fn = imports[name];
+		if (!fn) {
+			continue;
+		}
+		imports[name] = (sp) => {
+			const previous = relay.running;
+			relay.running = id;
+			try {
+				fn(sp);
+			} finally {
+				relay.running = previous;
+			}
+		};
+	}
+{{end}}
+	const run = (result) => { go.run(result.instance); };
+{{if .BinaryAddress}}
+	const gonb_comm = globalThis.gonb_comm;
+	const subscription = gonb_comm.subscribe("{{.BinaryAddress}}", (address, buffer) => {
+		gonb_comm.unsubscribe(subscription);
+		WebAssembly.instantiate(buffer, go.importObject).then(run);
+	});
+{{else}}
+	WebAssembly.instantiateStreaming(fetch("{{.CompiledWasmUrl}}"), go.importObject).then(run);
+{{end}}
 })();
 `))
 )
 
 // ExecuteWasm expects `wasm_exec.js` and CompiledWasmName to be in the directory
 // pointed to `s.WasmDir` already.
+//
+// The compiled wasm is fetched by the front-end from `s.WasmUrl` (served by Jupyter), or, with
+// `%wasm --comms` (or if the Jupyter root directory is unknown), sent through the websocket.
+// The output of the program is relayed to the cell output, if the websocket is installed.
 func (s *State) ExecuteWasm(msg kernel.Message) error {
+	useComms := s.WasmComms || s.WasmUrl == ""
+	relay := true
+	if err := s.Comms.InstallWebSocket(msg); err != nil {
+		if useComms {
+			return errors.WithMessagef(err, "`%%wasm` requires the websocket to send the compiled program to the front-end")
+		}
+		klog.Warningf("`%%wasm`: the output of the program won't be relayed to the cell, websocket not installed: %+v", err)
+		relay = false
+	}
+	if relay {
+		s.wasm.addCell(s.WasmDivId, msg)
+	}
+
 	data := struct {
-		Id, WasmExecJsUrl, CompiledWasmUrl, WasmDivId string
-		Args                                          []string
+		WasmDivId, WasmExecJsUrl, CompiledWasmUrl string
+		WasmExecJs, BinaryAddress, OutputAddress  string
+		WasmIdEnv                                 string
+		Relay                                     bool
+		Args                                      []string
 	}{
-		Id:              s.UniqueID,
-		WasmExecJsUrl:   path.Join(s.WasmUrl, "wasm_exec.js"),
-		CompiledWasmUrl: path.Join(s.WasmUrl, CompiledWasmName),
-		WasmDivId:       s.WasmDivId,
-		Args:            s.Args,
+		WasmDivId:     s.WasmDivId,
+		OutputAddress: protocol.GonbuiWasmOutputAddress,
+		WasmIdEnv:     protocol.GONB_WASM_ID_ENV,
+		Relay:         relay,
+		Args:          s.Args,
+	}
+	if useComms {
+		wasmExecJs, err := os.ReadFile(path.Join(s.WasmDir, "wasm_exec.js"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read 'wasm_exec.js'")
+		}
+		data.WasmExecJs = string(wasmExecJs)
+		data.BinaryAddress = wasmBinaryAddress(s.WasmDivId)
+	} else {
+		data.WasmExecJsUrl = path.Join(s.WasmUrl, "wasm_exec.js")
+		data.CompiledWasmUrl = path.Join(s.WasmUrl, CompiledWasmName)
 	}
 	var buf bytes.Buffer
 	err := runWasmHtml.Execute(&buf, &data)
@@ -190,8 +295,128 @@ func (s *State) ExecuteWasm(msg kernel.Message) error {
 		return errors.Wrapf(err, "failed to generate javascript to bootstrap WASM")
 	}
 	js := buf.String()
-	klog.V(2).Infof("WASM bootstrap code served:\n%s\n", js)
-	return kernel.PublishJavascript(msg, js)
+	if klog.V(2).Enabled() && !useComms {
+		klog.Infof("WASM bootstrap code served:\n%s\n", js)
+	}
+	if err = kernel.PublishJavascript(msg, js); err != nil {
+		return err
+	}
+	if !useComms {
+		return nil
+	}
+
+	// Send the compiled program: it is re-sent until the front-end (the script above) receives it.
+	binary, err := os.ReadFile(path.Join(s.WasmDir, CompiledWasmName))
+	if err != nil {
+		return errors.Wrapf(err, "failed to read compiled wasm")
+	}
+	klog.V(1).Infof("`%%wasm`: sending compiled program (%d bytes) through the websocket", len(binary))
+	return s.Comms.SendReliable(msg, data.BinaryAddress, binary)
+}
+
+// wasmBinaryAddress is the comms address where the compiled wasm of the cell is sent, when it is
+// delivered through the websocket.
+func wasmBinaryAddress(wasmDivId string) string {
+	return "#wasm/" + wasmDivId + "/binary"
+}
+
+// MaxWasmCells is the number of `%wasm` cells whose output is relayed (see handleWasmOutput): the output of
+// programs of older cells still running in the front-end is dropped.
+var MaxWasmCells = 32
+
+// wasmState is a substructure of State with the `%wasm` cells whose programs may still be running in the
+// front-end.
+type wasmState struct {
+	mu sync.Mutex
+
+	// cells whose output is relayed, the most recent last.
+	cells []wasmCell
+}
+
+// wasmCell is a `%wasm` cell, identified by its WasmDivId, and the message that executed it, used to relay
+// its output.
+type wasmCell struct {
+	id  string
+	msg kernel.Message
+}
+
+// addCell registers the cell, so its output is relayed.
+func (w *wasmState) addCell(id string, msg kernel.Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cells = append(w.cells, wasmCell{id: id, msg: msg})
+	if len(w.cells) > MaxWasmCells {
+		w.cells = slices.Delete(w.cells, 0, len(w.cells)-MaxWasmCells)
+	}
+}
+
+// cellMsg returns the message that executed the `%wasm` cell with the given id, or nil if it is not known.
+func (w *wasmState) cellMsg(id string) kernel.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, cell := range w.cells {
+		if cell.id == id {
+			return cell.msg
+		}
+	}
+	return nil
+}
+
+// handleWasmOutput relays the output of a `%wasm` program running in the front-end, sent to the
+// protocol.GonbuiWasmOutputAddress, to the output of its cell.
+//
+// The value has the "id" of the cell, and either the "text" written to a "stream" ("stdout" or "stderr"),
+// or a "display" data (a `protocol.DisplayData` in JSON, sent by `gonbui`).
+func (s *State) handleWasmOutput(value any) {
+	fields, ok := value.(map[string]any)
+	if !ok {
+		klog.Warningf("`%%wasm`: invalid output message %v", value)
+		return
+	}
+	id, _ := fields["id"].(string)
+	msg := s.wasm.cellMsg(id)
+	if msg == nil {
+		klog.V(1).Infof("`%%wasm`: output of unknown cell %q dropped", id)
+		return
+	}
+	if text, ok := fields["text"].(string); ok {
+		stream := kernel.StreamStdout
+		if fields["stream"] == "stderr" {
+			stream = kernel.StreamStderr
+		}
+		if err := kernel.PublishWriteStream(msg, stream, text); err != nil {
+			klog.Errorf("Failed to publish `%%wasm` output: %+v", err)
+		}
+		return
+	}
+	if display, ok := fields["display"].(string); ok {
+		var displayData protocol.DisplayData
+		if err := json.Unmarshal([]byte(display), &displayData); err != nil {
+			klog.Warningf("`%%wasm`: invalid display data: %+v", err)
+			return
+		}
+		msgData := kernel.Data{
+			Data:      make(kernel.MIMEMap, len(displayData.Data)),
+			Metadata:  make(kernel.MIMEMap, len(displayData.Metadata)),
+			Transient: make(kernel.MIMEMap),
+		}
+		for mimeType, content := range displayData.Data {
+			msgData.Data[string(mimeType)] = content
+		}
+		for key, content := range displayData.Metadata {
+			msgData.Metadata[key] = content
+		}
+		var err error
+		if displayData.DisplayID != "" {
+			msgData.Transient["display_id"] = displayData.DisplayID
+			err = kernel.PublishUpdateDisplayData(msg, msgData)
+		} else {
+			err = kernel.PublishData(msg, msgData)
+		}
+		if err != nil {
+			klog.Errorf("Failed to publish `%%wasm` display data: %+v", err)
+		}
+	}
 }
 
 // DeclareStringConst creates a const definition in `decls` for a string value.
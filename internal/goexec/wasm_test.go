@@ -0,0 +1,56 @@
+package goexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// publishRecorder is a kernel.Message that records the messages published, encoded in JSON.
+type publishRecorder struct {
+	kernel.Message
+	published []string
+}
+
+func (m *publishRecorder) Publish(msgType string, content any) error {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	m.published = append(m.published, msgType+" "+string(encoded))
+	return nil
+}
+
+func TestHandleWasmOutput(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() {
+		require.NoError(t, s.Stop(), "Failed to finalized state")
+	}()
+	msg := &publishRecorder{}
+	s.wasm.addCell("cell_1", msg)
+
+	s.handleWasmOutput(map[string]any{"id": "cell_1", "stream": "stdout", "text": "hello\n"})
+	s.handleWasmOutput(map[string]any{"id": "cell_1", "stream": "stderr", "text": "oops\n"})
+	display, err := json.Marshal(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: "bold"},
+	})
+	require.NoError(t, err)
+	s.handleWasmOutput(map[string]any{"id": "cell_1", "display": string(display)})
+	s.handleWasmOutput(map[string]any{"id": "unknown", "text": "dropped"})
+	s.handleWasmOutput("invalid")
+	require.Len(t, msg.published, 3)
+	assert.Equal(t, `stream {"name":"stdout","text":"hello\n"}`, msg.published[0])
+	assert.Equal(t, `stream {"name":"stderr","text":"oops\n"}`, msg.published[1])
+	assert.Contains(t, msg.published[2], `display_data {"data":{"text/plain":"bold"}`)
+
+	// Only the most recent cells are kept.
+	for ii := 0; ii < MaxWasmCells; ii++ {
+		s.wasm.addCell(fmt.Sprintf("cell_%d", ii+2), msg)
+	}
+	assert.Nil(t, s.wasm.cellMsg("cell_1"))
+	assert.NotNil(t, s.wasm.cellMsg(fmt.Sprintf("cell_%d", MaxWasmCells+1)))
+}
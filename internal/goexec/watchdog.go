@@ -0,0 +1,125 @@
+package goexec
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// This file implements a watchdog around the external tools GoNB executes (`go build`, `go get`,
+// `goimports`, etc.): they are killed if they take longer than their deadline, or if the kernel
+// is interrupted, so a stuck module download can't make the kernel unresponsive forever.
+
+var (
+	// GoBuildTimeout is the deadline for `go build` (or `go test -c`).
+	GoBuildTimeout = 10 * time.Minute
+
+	// GoGetTimeout is the deadline for `go get` and `go mod` commands.
+	GoGetTimeout = 10 * time.Minute
+
+	// GoImportsTimeout is the deadline for `goimports`.
+	GoImportsTimeout = 2 * time.Minute
+
+	// WatchdogNoticeDelay is how long an external tool runs before the user is told that
+	// it is still running, and how to cancel it.
+	WatchdogNoticeDelay = 30 * time.Second
+)
+
+// ToolTimeoutEnv is the name of the environment variable that, if set to a duration
+// (e.g.: "30m"), overrides the deadlines of all external tools.
+// It can be set from the notebook with `%env GONB_TOOL_TIMEOUT 30m`.
+const ToolTimeoutEnv = "GONB_TOOL_TIMEOUT"
+
+// toolTimeout returns the deadline for an external tool, taking into account ToolTimeoutEnv.
+func toolTimeout(defaultTimeout time.Duration) time.Duration {
+	value := os.Getenv(ToolTimeoutEnv)
+	if value == "" {
+		return defaultTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		klog.Warningf("goexec: invalid $%s=%q, using default of %s", ToolTimeoutEnv, value, defaultTimeout)
+		return defaultTimeout
+	}
+	return timeout
+}
+
+// runWithWatchdog runs `cmd` and returns its combined output, like exec.Cmd.CombinedOutput.
+//
+// The command (and any sub-processes it creates, like `git` for `go get`) is killed if it takes
+// longer than `timeout` or if the kernel is interrupted. If it is still running after
+// WatchdogNoticeDelay, a note is published to the cell's stderr.
+//
+// `msg` can be nil, in which case there is no notice and interruptions are not monitored.
+func runWithWatchdog(msg kernel.Message, timeout time.Duration, cmd *exec.Cmd) ([]byte, error) {
+	timeout = toolTimeout(timeout)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Own process group, so the whole group can be killed.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: 0}
+
+	var k *kernel.Kernel
+	if msg != nil {
+		k = msg.Kernel()
+	}
+	if k != nil && k.Interrupted.Load() {
+		return nil, errors.Errorf("%q not executed: kernel interrupted", cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start %q", cmd)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	interrupted := make(chan struct{})
+	if k != nil {
+		var once sync.Once
+		interruptId := k.SubscribeInterrupt(func(id kernel.SubscriptionId) {
+			k.UnsubscribeInterrupt(id)
+			once.Do(func() { close(interrupted) })
+		})
+		defer k.UnsubscribeInterrupt(interruptId)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	notice := time.NewTimer(WatchdogNoticeDelay)
+	defer notice.Stop()
+	for {
+		select {
+		case err := <-done:
+			return output.Bytes(), err
+		case <-notice.C:
+			if msg != nil {
+				_ = kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf(
+					"%q still running after %s: interrupt the kernel to cancel it (it times out after %s).\n",
+					cmd, WatchdogNoticeDelay, timeout))
+			}
+		case <-deadline.C:
+			killProcessGroup(cmd)
+			<-done
+			klog.Warningf("goexec: %q timed out after %s", cmd, timeout)
+			return output.Bytes(), errors.Errorf("%q timed out after %s (see $%s)", cmd, timeout, ToolTimeoutEnv)
+		case <-interrupted:
+			killProcessGroup(cmd)
+			<-done
+			return output.Bytes(), errors.Errorf("%q interrupted", cmd)
+		}
+	}
+}
+
+// killProcessGroup kills the process group started by runWithWatchdog.
+func killProcessGroup(cmd *exec.Cmd) {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		klog.Errorf("failed to kill process group of %q: %+v", cmd, err)
+		_ = cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,23 @@
+package goexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunWithWatchdog(t *testing.T) {
+	output, err := runWithWatchdog(nil, time.Minute, exec.Command("sh", "-c", "echo out; echo err >&2"))
+	require.NoError(t, err)
+	assert.Equal(t, "out\nerr\n", string(output))
+
+	// Sub-processes are also killed on timeout.
+	t.Setenv(ToolTimeoutEnv, "100ms")
+	start := time.Now()
+	_, err = runWithWatchdog(nil, time.Minute, exec.Command("sh", "-c", "sleep 30; echo done"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out after 100ms")
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
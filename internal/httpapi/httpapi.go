@@ -0,0 +1,685 @@
+// Package httpapi implements an HTTP/JSON API front-end to GoNB, to use it as a remote execution
+// service (e.g.: to back a web playground or internal tools), without Jupyter.
+//
+// Each session holds its own Go executor state (memorized declarations, `go.mod`, temporary
+// directory), and cells are executed with the same machinery used by the Jupyter kernel.
+// Sessions share the process environment and current directory, so the special commands that change
+// them (e.g.: `%cd` and `%env`, see DisabledCommands) are refused. Sessions not used for Config.IdleTimeout
+// are stopped.
+//
+// All requests must be authenticated with the header `Authorization: Bearer <token>`.
+// Endpoints:
+//
+//   - `POST /v1/sessions`: creates a session, returns `{"id": "<session_id>"}`.
+//   - `GET /v1/sessions`: lists sessions.
+//   - `DELETE /v1/sessions/<session_id>`: stops the session and removes its temporary files.
+//   - `POST /v1/sessions/<session_id>/execute`: executes the cell in the body `{"code": "..."}`.
+//     If the request has the header `Accept: text/event-stream`, outputs are streamed as Server-Sent Events
+//     (SSE) as they are produced, and the last one is the "execute_reply". Otherwise, it returns one JSON
+//     object with all the outputs and the reply, once the execution finishes.
+//     Closing the connection interrupts the execution.
+//     Requests to a session that is deleted while they wait for the previous execution fail with 410 (Gone).
+//   - `POST /v1/sessions/<session_id>/interrupt`: interrupts the cell being executed.
+//   - `POST /v1/sessions/<session_id>/complete`: returns the auto-complete options for the body
+//     `{"code": "...", "cursor_pos": <int>}` (cursor position in UTF-16 units, as in Jupyter).
+//   - `POST /v1/sessions/<session_id>/format`: formats the Go code of the cell in the body `{"code": "..."}`, and
+//     returns `{"status": "ok", "code": "<formatted code>", "changed": <bool>}`.
+//   - `GET /v1/sessions/<session_id>/sourcemaps`: returns `{"source_maps": [...]}`, the source maps of the
+//     latest compiled binaries. With the query `?file=<path>&line=<int>`, it instead maps the position of a
+//     generated code file (as found in stack traces) to `{"found": <bool>, "cell_id": <int>, "cell_line": <int>}`.
+//
+// Outputs and replies use the same content as the corresponding Jupyter messages, e.g.: "stream",
+// "display_data", "error", "execute_reply".
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/dispatcher"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/resources"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/maps"
+	"io"
+	"k8s.io/klog/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config for the HTTP API Server.
+type Config struct {
+	// Address to listen to, e.g.: "localhost:8080".
+	Address string
+
+	// Token that clients must provide in the "Authorization: Bearer <token>" header. Required.
+	Token string
+
+	// MaxSessions is the maximum number of concurrent sessions. If 0, DefaultMaxSessions is used.
+	MaxSessions int
+
+	// PreserveTempDir indicates the temporary directories of the sessions should be preserved when they
+	// are closed -- helpful for debugging.
+	PreserveTempDir bool
+
+	// Network configures the proxy, certificate authorities and Go module settings used by all sessions.
+	Network goexec.NetworkConfig
+
+	// IdleTimeout after which a session that received no requests is stopped, releasing its slot (see
+	// MaxSessions) and temporary files. If 0, DefaultIdleTimeout is used. If negative, sessions never expire.
+	IdleTimeout time.Duration
+}
+
+// DefaultMaxSessions is used if Config.MaxSessions is not set.
+const DefaultMaxSessions = 10
+
+// DefaultIdleTimeout is used if Config.IdleTimeout is not set.
+const DefaultIdleTimeout = time.Hour
+
+// DisabledCommands are the special commands refused by the sessions, because they change the environment or
+// the current directory of the process, shared by all sessions.
+var DisabledCommands = []string{"cd", "env", "recover", "load", "limits", "proxy"}
+
+// MaxRequestSize is the maximum size accepted for request bodies.
+const MaxRequestSize = 16 * 1024 * 1024
+
+// Server serves the HTTP API. Create it with New.
+type Server struct {
+	config Config
+
+	mu       sync.Mutex
+	sessions map[string]*session
+
+	// pendingSessions is the number of sessions being created: they count towards Config.MaxSessions.
+	// Protected by mu.
+	pendingSessions int
+
+	httpServer *http.Server
+
+	// done is closed by Shutdown, to stop expiring idle sessions.
+	done         chan struct{}
+	shutdownOnce sync.Once
+}
+
+// session holds the state of one client session.
+type session struct {
+	id      string
+	created time.Time
+	kernel  *kernel.Kernel
+	goExec  *goexec.State
+
+	// muExec serializes the requests handled by the session, and protects stopped: use lockExec.
+	muExec  sync.Mutex
+	stopped bool
+
+	// muInfo protects execCount, a snapshot of kernel.ExecCounter taken after each execution, so the
+	// session can be listed while it is executing, and the usage of the session: the number of requests
+	// being handled (active) and when the last one finished (lastUsed).
+	muInfo    sync.Mutex
+	execCount int
+	active    int
+	lastUsed  time.Time
+}
+
+// use marks the session as being used by a request: the returned function must be called when the request
+// is finished.
+func (sess *session) use() (done func()) {
+	sess.muInfo.Lock()
+	defer sess.muInfo.Unlock()
+	sess.active++
+	return func() {
+		sess.muInfo.Lock()
+		defer sess.muInfo.Unlock()
+		sess.active--
+		sess.lastUsed = time.Now()
+	}
+}
+
+// isIdle returns whether the session has not been used for the given timeout.
+func (sess *session) isIdle(timeout time.Duration) bool {
+	sess.muInfo.Lock()
+	defer sess.muInfo.Unlock()
+	return sess.active == 0 && time.Since(sess.lastUsed) >= timeout
+}
+
+// errSessionStopped is returned by lockExec if the session was stopped (e.g.: deleted) while the request
+// waited for the previous one to finish.
+var errSessionStopped = errors.New("session was stopped")
+
+// lockExec locks muExec to handle a request, or returns errSessionStopped if the session was stopped.
+func (sess *session) lockExec() error {
+	sess.muExec.Lock()
+	if sess.stopped {
+		sess.muExec.Unlock()
+		return errors.WithMessagef(errSessionStopped, "session %q", sess.id)
+	}
+	return nil
+}
+
+// updateExecutionCount takes a snapshot of the kernel.ExecCounter. It must be called with muExec locked.
+func (sess *session) updateExecutionCount() {
+	sess.muInfo.Lock()
+	defer sess.muInfo.Unlock()
+	sess.execCount = sess.kernel.ExecCounter
+}
+
+// executionCount returns the snapshot of the number of executions.
+func (sess *session) executionCount() int {
+	sess.muInfo.Lock()
+	defer sess.muInfo.Unlock()
+	return sess.execCount
+}
+
+// New creates a new Server. Call ListenAndServe to start serving, or use Server.Handler.
+func New(config Config) (*Server, error) {
+	if config.Token == "" {
+		return nil, errors.New("httpapi.New() requires Config.Token to be set")
+	}
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = DefaultMaxSessions
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if err := goexec.ApplyNetworkConfig(config.Network); err != nil {
+		return nil, errors.WithMessagef(err, "invalid network configuration")
+	}
+	goexec.ApplyResourceLimits()
+	s := &Server{
+		config:   config,
+		sessions: make(map[string]*session),
+		done:     make(chan struct{}),
+	}
+	s.httpServer = &http.Server{
+		Addr:              config.Address,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	if config.IdleTimeout > 0 {
+		go s.expireIdleSessions()
+	}
+	return s, nil
+}
+
+// expireIdleSessions stops the sessions not used for Config.IdleTimeout, until Shutdown is called.
+func (s *Server) expireIdleSessions() {
+	ticker := time.NewTicker(max(s.config.IdleTimeout/4, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		var expired []*session
+		s.mu.Lock()
+		for id, sess := range s.sessions {
+			if sess.isIdle(s.config.IdleTimeout) {
+				expired = append(expired, sess)
+				delete(s.sessions, id)
+			}
+		}
+		s.mu.Unlock()
+		for _, sess := range expired {
+			klog.Infof("httpapi: session %q expired, not used for %s", sess.id, s.config.IdleTimeout)
+			if err := sess.stop(); err != nil {
+				klog.Errorf("httpapi: failed to stop expired session: %+v", err)
+			}
+		}
+	}
+}
+
+// ListenAndServe listens on Config.Address and serves the API, until Shutdown is called.
+func (s *Server) ListenAndServe() error {
+	klog.Infof("GoNB HTTP API serving on %q", s.config.Address)
+	socketResource := resources.Register(resources.Socket, "httpapi", s.config.Address, s.httpServer.Close)
+	defer socketResource.Forget()
+	err := s.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return errors.Wrapf(err, "failed to serve HTTP API on %q", s.config.Address)
+}
+
+// Shutdown stops serving and closes all sessions.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() { close(s.done) })
+	err := s.httpServer.Shutdown(ctx)
+	s.mu.Lock()
+	sessions := maps.Values(s.sessions)
+	s.sessions = make(map[string]*session)
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		if stopErr := sess.stop(); stopErr != nil && err == nil {
+			err = stopErr
+		}
+	}
+	return err
+}
+
+// Handler returns the http.Handler that implements the API.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+// serveHTTP authenticates and routes the requests.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gonb"`)
+		httpError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxRequestSize)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" || parts[1] != "sessions" {
+		httpError(w, http.StatusNotFound, errors.Errorf("unknown path %q", r.URL.Path))
+		return
+	}
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		s.handleCreateSession(w)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.handleListSessions(w)
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		s.handleDeleteSession(w, parts[2])
+	case len(parts) == 4 && r.Method == http.MethodPost:
+		sess := s.getSession(parts[2])
+		if sess == nil {
+			httpError(w, http.StatusNotFound, errors.Errorf("session %q not found", parts[2]))
+			return
+		}
+		defer sess.use()()
+		switch parts[3] {
+		case "execute":
+			s.handleExecute(w, r, sess)
+		case "interrupt":
+			sess.kernel.CallInterruptSubscribers()
+			writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+		case "complete":
+			s.handleComplete(w, r, sess)
+		case "format":
+			s.handleFormat(w, r, sess)
+		default:
+			httpError(w, http.StatusNotFound, errors.Errorf("unknown session action %q", parts[3]))
+		}
+	case len(parts) == 4 && r.Method == http.MethodGet && parts[3] == "sourcemaps":
+		sess := s.getSession(parts[2])
+		if sess == nil {
+			httpError(w, http.StatusNotFound, errors.Errorf("session %q not found", parts[2]))
+			return
+		}
+		defer sess.use()()
+		s.handleSourceMaps(w, r, sess)
+	default:
+		httpError(w, http.StatusNotFound, errors.Errorf("unknown request %s %q", r.Method, r.URL.Path))
+	}
+}
+
+// authorized checks the bearer token of the request.
+func (s *Server) authorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) == 1
+}
+
+func (s *Server) getSession(id string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+func (s *Server) handleCreateSession(w http.ResponseWriter) {
+	// Reserve a slot for the new session, released if it fails to be created.
+	s.mu.Lock()
+	if len(s.sessions)+s.pendingSessions >= s.config.MaxSessions {
+		s.mu.Unlock()
+		httpError(w, http.StatusTooManyRequests, errors.Errorf("maximum number of sessions (%d) reached", s.config.MaxSessions))
+		return
+	}
+	s.pendingSessions++
+	s.mu.Unlock()
+	created := false
+	defer func() {
+		if !created {
+			s.mu.Lock()
+			s.pendingSessions--
+			s.mu.Unlock()
+		}
+	}()
+
+	sess := &session{
+		id:       common.UniqueId(),
+		created:  time.Now(),
+		kernel:   kernel.NewStandalone(),
+		lastUsed: time.Now(),
+	}
+	var err error
+	sess.goExec, err = goexec.New(sess.kernel, sess.id, s.config.PreserveTempDir, true)
+	if err != nil {
+		sess.kernel.Stop()
+		httpError(w, http.StatusInternalServerError, errors.WithMessagef(err, "failed to create go executor"))
+		return
+	}
+	sess.goExec.DisabledCommands = common.SetWithValues(DisabledCommands...)
+	s.mu.Lock()
+	s.sessions[sess.id] = sess
+	s.pendingSessions--
+	created = true
+	s.mu.Unlock()
+	klog.Infof("httpapi: created session %q", sess.id)
+	writeJSON(w, http.StatusCreated, map[string]any{"id": sess.id})
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter) {
+	s.mu.Lock()
+	list := make([]map[string]any, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		list = append(list, map[string]any{
+			"id":              sess.id,
+			"created":         sess.created.UTC().Format(time.RFC3339),
+			"execution_count": sess.executionCount(),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i]["created"].(string) < list[j]["created"].(string) })
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": list})
+}
+
+func (s *Server) handleDeleteSession(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	sess := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if sess == nil {
+		httpError(w, http.StatusNotFound, errors.Errorf("session %q not found", id))
+		return
+	}
+	if err := sess.stop(); err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	klog.Infof("httpapi: deleted session %q", id)
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// stop interrupts any execution and stops the session.
+func (sess *session) stop() error {
+	sess.kernel.CallInterruptSubscribers()
+	sess.muExec.Lock()
+	defer sess.muExec.Unlock()
+	if sess.stopped {
+		return nil
+	}
+	sess.stopped = true
+	sess.kernel.Stop()
+	if err := sess.goExec.Stop(); err != nil {
+		return errors.WithMessagef(err, "stopping session %q", sess.id)
+	}
+	return nil
+}
+
+// handleExecute executes a cell, streaming the outputs if requested.
+func (s *Server) handleExecute(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, errors.Wrapf(err, "invalid request body"))
+		return
+	}
+
+	streaming := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	var outputs []event
+	var flusher http.Flusher
+	if streaming {
+		var ok bool
+		flusher, ok = w.(http.Flusher)
+		if !ok {
+			httpError(w, http.StatusInternalServerError, errors.New("streaming not supported by the connection"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+	}
+	msg := newMessage(sess, "execute_request", map[string]any{
+		"code":          req.Code,
+		"silent":        false,
+		"store_history": true,
+	}, func(e event) {
+		if !streaming {
+			outputs = append(outputs, e)
+			return
+		}
+		if err := writeEvent(w, e); err != nil {
+			klog.Warningf("httpapi: failed to stream output to client: %+v", err)
+			return
+		}
+		flusher.Flush()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		if err := sess.lockExec(); err != nil {
+			done <- err
+			return
+		}
+		defer sess.muExec.Unlock()
+		err := dispatcher.HandleMessage(msg, sess.goExec)
+		sess.updateExecutionCount()
+		done <- err
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-r.Context().Done():
+		klog.Infof("httpapi: client disconnected, interrupting execution in session %q", sess.id)
+		sess.kernel.CallInterruptSubscribers()
+		err = <-done
+	}
+	msg.close()
+	if errors.Is(err, errSessionStopped) {
+		if streaming {
+			if writeErr := writeEvent(w, event{Type: "error", Content: map[string]any{"error": err.Error()}}); writeErr == nil {
+				flusher.Flush()
+			}
+			return
+		}
+		httpError(w, http.StatusGone, err)
+		return
+	}
+	if err != nil {
+		klog.Errorf("httpapi: failed executing cell in session %q: %+v", sess.id, err)
+	}
+	reply := event{Type: "execute_reply", Content: msg.reply}
+	if streaming {
+		if writeErr := writeEvent(w, reply); writeErr == nil {
+			flusher.Flush()
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"outputs": outputs, "reply": reply.Content})
+}
+
+// handleComplete replies with the auto-complete options.
+func (s *Server) handleComplete(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req struct {
+		Code      string `json:"code"`
+		CursorPos int    `json:"cursor_pos"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, errors.Wrapf(err, "invalid request body"))
+		return
+	}
+	msg := newMessage(sess, "complete_request", map[string]any{
+		"code":       req.Code,
+		"cursor_pos": float64(req.CursorPos),
+	}, func(event) {})
+	if err := sess.lockExec(); err != nil {
+		httpError(w, http.StatusGone, err)
+		return
+	}
+	err := dispatcher.HandleMessage(msg, sess.goExec)
+	sess.muExec.Unlock()
+	msg.close()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg.reply)
+}
+
+// handleFormat returns the formatted code of the cell.
+func (s *Server) handleFormat(w http.ResponseWriter, r *http.Request, sess *session) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, errors.Wrapf(err, "invalid request body"))
+		return
+	}
+	msg := newMessage(sess, "format_request", map[string]any{"code": req.Code}, func(event) {})
+	if err := sess.lockExec(); err != nil {
+		httpError(w, http.StatusGone, err)
+		return
+	}
+	err := dispatcher.HandleMessage(msg, sess.goExec)
+	sess.muExec.Unlock()
+	msg.close()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg.reply)
+}
+
+// handleSourceMaps lists the source maps of the session, or maps the position given in the query.
+func (s *Server) handleSourceMaps(w http.ResponseWriter, r *http.Request, sess *session) {
+	query := r.URL.Query()
+	if !query.Has("file") {
+		writeJSON(w, http.StatusOK, map[string]any{"source_maps": sess.goExec.SourceMaps()})
+		return
+	}
+	line, err := strconv.Atoi(query.Get("line"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, errors.Wrapf(err, "invalid \"line\" %q", query.Get("line")))
+		return
+	}
+	cellId, cellLine, found := sess.goExec.MapSourcePosition(query.Get("file"), line)
+	writeJSON(w, http.StatusOK, map[string]any{"found": found, "cell_id": cellId, "cell_line": cellLine})
+}
+
+// event is an output published by the kernel, or the final reply.
+type event struct {
+	Type    string `json:"type"`
+	Content any    `json:"content"`
+}
+
+// writeEvent writes the event in the Server-Sent Events format.
+func writeEvent(w io.Writer, e event) error {
+	data, err := json.Marshal(e.Content)
+	if err != nil {
+		return errors.Wrapf(err, "encoding %q event", e.Type)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
+
+// writeJSON writes the value as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		klog.Warningf("httpapi: failed to write response: %+v", err)
+	}
+}
+
+// httpError responds with a JSON error.
+func httpError(w http.ResponseWriter, status int, err error) {
+	klog.V(1).Infof("httpapi: %d error: %v", status, err)
+	writeJSON(w, status, map[string]any{"error": err.Error()})
+}
+
+// message implements kernel.Message for requests received by the HTTP API.
+type message struct {
+	sess     *session
+	composed kernel.ComposedMsg
+
+	// mu protects sink and closed: outputs are published concurrently.
+	mu     sync.Mutex
+	sink   func(e event)
+	closed bool
+
+	// reply holds the content of the last reply to this message.
+	reply any
+}
+
+// Compile-time check that message implements kernel.Message.
+var _ kernel.Message = (*message)(nil)
+
+func newMessage(sess *session, msgType string, content map[string]any, sink func(e event)) *message {
+	m := &message{sess: sess, sink: sink}
+	m.composed.Header.MsgType = msgType
+	m.composed.Header.ProtocolVersion = kernel.ProtocolVersion
+	m.composed.Content = content
+	return m
+}
+
+// close stops forwarding published outputs.
+func (m *message) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+}
+
+// Error implements kernel.Message.
+func (m *message) Error() error { return nil }
+
+// Ok implements kernel.Message.
+func (m *message) Ok() bool { return true }
+
+// ComposedMsg implements kernel.Message.
+func (m *message) ComposedMsg() kernel.ComposedMsg { return m.composed }
+
+// Kernel implements kernel.Message.
+func (m *message) Kernel() *kernel.Kernel { return m.sess.kernel }
+
+// Publish implements kernel.Message, by forwarding the output to the client.
+func (m *message) Publish(msgType string, content any) error {
+	if msgType == "status" || msgType == "execute_input" {
+		// No use for these in the API.
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		klog.Warningf("httpapi: %q published after request finished, dropped", msgType)
+		return nil
+	}
+	m.sink(event{Type: msgType, Content: content})
+	return nil
+}
+
+// PromptInput implements kernel.Message. Input is not supported by the HTTP API.
+func (m *message) PromptInput(_ string, _ bool, _ kernel.OnInputFn) error {
+	return errors.New("input prompting is not supported by the GoNB HTTP API")
+}
+
+// CancelInput implements kernel.Message.
+func (m *message) CancelInput() error { return nil }
+
+// DeliverInput implements kernel.Message.
+func (m *message) DeliverInput() error { return nil }
+
+// Reply implements kernel.Message, by storing the content to be sent back to the client.
+func (m *message) Reply(_ string, content any) error {
+	m.reply = content
+	return nil
+}
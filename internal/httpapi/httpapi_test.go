@@ -0,0 +1,262 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "test-token"
+
+func request(t *testing.T, handler http.Handler, method, path, token string, body any, accept string) *httptest.ResponseRecorder {
+	var bodyReader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		bodyReader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, bodyReader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestServer(t *testing.T) {
+	server, err := New(Config{Token: testToken})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, server.Shutdown(context.Background())) }()
+	handler := server.Handler()
+
+	// Authentication.
+	resp := request(t, handler, http.MethodGet, "/v1/sessions", "", nil, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = request(t, handler, http.MethodGet, "/v1/sessions", "wrong", nil, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// Create session.
+	resp = request(t, handler, http.MethodPost, "/v1/sessions", testToken, nil, "")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	var created struct{ Id string }
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Id)
+
+	resp = request(t, handler, http.MethodGet, "/v1/sessions", testToken, nil, "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), created.Id)
+
+	// Execute, with outputs returned in one JSON.
+	resp = request(t, handler, http.MethodPost, "/v1/sessions/"+created.Id+"/execute", testToken,
+		map[string]string{"code": "!echo hello"}, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var result struct {
+		Outputs []struct {
+			Type    string
+			Content map[string]any
+		}
+		Reply map[string]any
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	var stdout string
+	for _, output := range result.Outputs {
+		if output.Type == "stream" {
+			stdout += output.Content["text"].(string)
+		}
+	}
+	assert.Equal(t, "hello\n", stdout)
+	assert.Equal(t, "ok", result.Reply["status"])
+	assert.Equal(t, 1.0, result.Reply["execution_count"])
+
+	// Execute, with outputs streamed.
+	resp = request(t, handler, http.MethodPost, "/v1/sessions/"+created.Id+"/execute", testToken,
+		map[string]string{"code": "!echo world"}, "text/event-stream")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	body := resp.Body.String()
+	assert.Contains(t, body, "event: stream\ndata: ")
+	assert.Contains(t, body, `world\n`)
+	assert.True(t, strings.HasPrefix(body[strings.LastIndex(body, "event: "):], "event: execute_reply\n"))
+
+	// Format.
+	resp = request(t, handler, http.MethodPost, "/v1/sessions/"+created.Id+"/format", testToken,
+		map[string]string{"code": "%%\nx:=1\n_ = x"}, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	var formatReply map[string]any
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &formatReply))
+	assert.Equal(t, "%%\nx := 1\n_ = x", formatReply["code"])
+	assert.Equal(t, true, formatReply["changed"])
+
+	// Source maps: no Go cell was compiled.
+	resp = request(t, handler, http.MethodGet, "/v1/sessions/"+created.Id+"/sourcemaps", testToken, nil, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), `"source_maps":[]`)
+	resp = request(t, handler, http.MethodGet, "/v1/sessions/"+created.Id+"/sourcemaps?file=/tmp/main.go&line=3",
+		testToken, nil, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), `"found":false`)
+
+	// Delete session.
+	resp = request(t, handler, http.MethodDelete, "/v1/sessions/"+created.Id, testToken, nil, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp = request(t, handler, http.MethodPost, "/v1/sessions/"+created.Id+"/execute", testToken,
+		map[string]string{"code": "!echo hello"}, "")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestMaxSessionsConcurrent(t *testing.T) {
+	const maxSessions = 2
+	server, err := New(Config{Token: testToken, MaxSessions: maxSessions})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, server.Shutdown(context.Background())) }()
+	handler := server.Handler()
+
+	// Concurrent creations can't exceed the maximum.
+	const numRequests = 8
+	codes := make(chan int, numRequests)
+	var wg sync.WaitGroup
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- request(t, handler, http.MethodPost, "/v1/sessions", testToken, nil, "").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusCreated: maxSessions, http.StatusTooManyRequests: numRequests - maxSessions}, counts)
+	server.mu.Lock()
+	assert.Len(t, server.sessions, maxSessions)
+	assert.Zero(t, server.pendingSessions)
+	server.mu.Unlock()
+
+	// Sessions can be listed while executing.
+	var id string
+	for id = range server.sessions {
+		break
+	}
+	executed := make(chan int)
+	go func() {
+		executed <- request(t, handler, http.MethodPost, "/v1/sessions/"+id+"/execute", testToken,
+			map[string]string{"code": "!sleep 0.2"}, "").Code
+	}()
+	for i := 0; i < 5; i++ {
+		resp := request(t, handler, http.MethodGet, "/v1/sessions", testToken, nil, "")
+		require.Equal(t, http.StatusOK, resp.Code)
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, http.StatusOK, <-executed)
+	resp := request(t, handler, http.MethodGet, "/v1/sessions", testToken, nil, "")
+	assert.Contains(t, resp.Body.String(), `"execution_count":1`)
+}
+
+func TestStoppedSession(t *testing.T) {
+	server, err := New(Config{Token: testToken})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, server.Shutdown(context.Background())) }()
+	handler := server.Handler()
+	resp := request(t, handler, http.MethodPost, "/v1/sessions", testToken, nil, "")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	var created struct{ Id string }
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+
+	// Requests that were waiting for the session when it was stopped (e.g.: deleted) are refused.
+	sess := server.getSession(created.Id)
+	require.NoError(t, sess.stop())
+	require.NoError(t, sess.stop(), "stopping twice should be a no-op")
+	for _, action := range []string{"execute", "complete", "format"} {
+		resp = request(t, handler, http.MethodPost, "/v1/sessions/"+created.Id+"/"+action, testToken,
+			map[string]any{"code": "!echo hello"}, "")
+		assert.Equalf(t, http.StatusGone, resp.Code, "%s: %s", action, resp.Body.String())
+	}
+	resp = request(t, handler, http.MethodPost, "/v1/sessions/"+created.Id+"/execute", testToken,
+		map[string]any{"code": "!echo hello"}, "text/event-stream")
+	assert.Contains(t, resp.Body.String(), "event: error\n")
+	assert.NotContains(t, resp.Body.String(), "hello")
+}
+
+// createSession creates a session and returns its id.
+func createSession(t *testing.T, handler http.Handler) string {
+	resp := request(t, handler, http.MethodPost, "/v1/sessions", testToken, nil, "")
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	var created struct{ Id string }
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &created))
+	return created.Id
+}
+
+func TestDisabledCommands(t *testing.T) {
+	server, err := New(Config{Token: testToken})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, server.Shutdown(context.Background())) }()
+	handler := server.Handler()
+	id := createSession(t, handler)
+
+	pwd, err := os.Getwd()
+	require.NoError(t, err)
+	for _, code := range []string{"%cd /", "%env GONB_HTTPAPI_TEST=1"} {
+		resp := request(t, handler, http.MethodPost, "/v1/sessions/"+id+"/execute", testToken,
+			map[string]string{"code": code}, "")
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.Contains(t, resp.Body.String(), `"status":"error"`, code)
+		assert.Contains(t, resp.Body.String(), "is disabled in this session", code)
+	}
+	newPwd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, pwd, newPwd)
+	assert.Empty(t, os.Getenv("GONB_HTTPAPI_TEST"))
+}
+
+func TestIdleTimeout(t *testing.T) {
+	const idleTimeout = 100 * time.Millisecond
+	server, err := New(Config{Token: testToken, IdleTimeout: idleTimeout})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, server.Shutdown(context.Background())) }()
+	handler := server.Handler()
+	idle := createSession(t, handler)
+	busy := createSession(t, handler)
+	tempDir := server.getSession(idle).goExec.TempDir
+
+	// The busy session is used for longer than the idle timeout.
+	executed := make(chan int)
+	go func() {
+		executed <- request(t, handler, http.MethodPost, "/v1/sessions/"+busy+"/execute", testToken,
+			map[string]string{"code": "!sleep 0.5"}, "").Code
+	}()
+	require.Eventually(t, func() bool { return server.getSession(idle) == nil }, 5*time.Second, 10*time.Millisecond,
+		"idle session was not expired")
+	assert.NoDirExists(t, tempDir)
+	resp := request(t, handler, http.MethodPost, "/v1/sessions/"+idle+"/execute", testToken,
+		map[string]string{"code": "!echo hello"}, "")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	assert.Equal(t, http.StatusOK, <-executed)
+	assert.NotNil(t, server.getSession(busy), "session expired while in use")
+	require.Eventually(t, func() bool { return server.getSession(busy) == nil }, 5*time.Second, 10*time.Millisecond,
+		"session was not expired after it was used")
+
+	// Negative timeout: sessions never expire.
+	server2, err := New(Config{Token: testToken, IdleTimeout: -1})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, server2.Shutdown(context.Background())) }()
+	id := createSession(t, server2.Handler())
+	time.Sleep(2 * idleTimeout)
+	assert.NotNil(t, server2.getSession(id))
+}
@@ -0,0 +1,168 @@
+// Package jobs keeps a registry of the long-running services started by the programs executed -- currently the
+// servers announced with `gonbui.AnnounceServer` -- so they can be listed (see `%jobs`) while they run, and
+// for a while after they exit.
+//
+// The owner of a job (the executor of the program) should Finish it when the program exits.
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"k8s.io/klog/v2"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// MaxFinished is the maximum number of finished jobs kept in the registry: older ones are dropped.
+var MaxFinished = 20
+
+// Job is one entry in the Registry.
+type Job struct {
+	Id   int
+	Port int
+
+	// Path of the page of the server, e.g.: "/".
+	Path string
+
+	// Cell is the execution count of the cell that started the job.
+	Cell int
+
+	Started  time.Time
+	Finished time.Time // Zero while it is running.
+
+	registry *Registry
+}
+
+// Registry of jobs. Most users will use the Default one, through the package functions.
+type Registry struct {
+	mu     sync.Mutex
+	nextId int
+	jobs   []*Job
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the Registry used by the package functions.
+var Default = NewRegistry()
+
+// RegisterServer registers a server listening on port, started by the program of the given cell.
+func (reg *Registry) RegisterServer(port int, path string, cell int) *Job {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.nextId++
+	job := &Job{
+		Id:       reg.nextId,
+		Port:     port,
+		Path:     path,
+		Cell:     cell,
+		Started:  time.Now(),
+		registry: reg,
+	}
+	reg.jobs = append(reg.jobs, job)
+	klog.V(1).Infof("jobs: registered #%d server on port %d (cell %d)", job.Id, port, cell)
+	return job
+}
+
+// RegisterServer registers a server in the Default registry, see Registry.RegisterServer.
+func RegisterServer(port int, path string, cell int) *Job {
+	return Default.RegisterServer(port, path, cell)
+}
+
+// Finish marks the job as finished, and drops the oldest finished jobs beyond MaxFinished.
+// It is a no-op if it was already finished, or if job is nil.
+func (job *Job) Finish() {
+	if job == nil {
+		return
+	}
+	reg := job.registry
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if !job.Finished.IsZero() {
+		return
+	}
+	job.Finished = time.Now()
+	klog.V(1).Infof("jobs: #%d server on port %d finished", job.Id, job.Port)
+
+	var numFinished int
+	for _, j := range reg.jobs {
+		if !j.Finished.IsZero() {
+			numFinished++
+		}
+	}
+	if numFinished <= MaxFinished {
+		return
+	}
+	kept := reg.jobs[:0]
+	for _, j := range reg.jobs {
+		if !j.Finished.IsZero() && numFinished > MaxFinished {
+			numFinished--
+			continue
+		}
+		kept = append(kept, j)
+	}
+	reg.jobs = kept
+}
+
+// IsRunning returns whether the job has not finished yet.
+func (job *Job) IsRunning() bool {
+	job.registry.mu.Lock()
+	defer job.registry.mu.Unlock()
+	return job.Finished.IsZero()
+}
+
+// Url returns the address of the server in the machine running the kernel.
+func (job *Job) Url() string {
+	return fmt.Sprintf("http://localhost:%d%s", job.Port, job.Path)
+}
+
+// List returns a copy of the jobs, in order of creation. If running is true, only the jobs still running are
+// returned.
+func (reg *Registry) List(running bool) []Job {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	list := make([]Job, 0, len(reg.jobs))
+	for _, job := range reg.jobs {
+		if !running || job.Finished.IsZero() {
+			list = append(list, *job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// List returns the jobs of the Default registry, see Registry.List.
+func List(running bool) []Job {
+	return Default.List(running)
+}
+
+// Report returns a text table with the jobs of the registry.
+func (reg *Registry) Report() string {
+	list := reg.List(false)
+	if len(list) == 0 {
+		return "No jobs.\n"
+	}
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "%d jobs:\n", len(list))
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  #\tCell\tStatus\tAddress")
+	now := time.Now()
+	for _, job := range list {
+		status := "running " + now.Sub(job.Started).Round(time.Second).String()
+		if !job.Finished.IsZero() {
+			status = "exited " + now.Sub(job.Finished).Round(time.Second).String() + " ago"
+		}
+		_, _ = fmt.Fprintf(w, "  %d\t[%d]\t%s\t%s\n", job.Id, job.Cell, status, job.Url())
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+// Report returns a text table with the jobs of the Default registry.
+func Report() string {
+	return Default.Report()
+}
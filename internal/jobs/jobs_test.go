@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	assert.Equal(t, "No jobs.\n", reg.Report())
+	first := reg.RegisterServer(8080, "/", 3)
+	second := reg.RegisterServer(6060, "/debug/pprof/", 4)
+	assert.Equal(t, "http://localhost:6060/debug/pprof/", second.Url())
+	require.Len(t, reg.List(true), 2)
+
+	// Finish is idempotent, and finished jobs are still listed.
+	first.Finish()
+	first.Finish()
+	assert.False(t, first.IsRunning())
+	assert.True(t, second.IsRunning())
+	running := reg.List(true)
+	require.Len(t, running, 1)
+	assert.Equal(t, second.Id, running[0].Id)
+	report := reg.Report()
+	assert.Contains(t, report, "2 jobs:")
+	assert.Contains(t, report, "http://localhost:8080/")
+	assert.Contains(t, report, "exited")
+	var nilJob *Job
+	nilJob.Finish()
+
+	// Only the most recent MaxFinished finished jobs are kept.
+	for ii := 0; ii < MaxFinished+5; ii++ {
+		reg.RegisterServer(9000+ii, "/", 5).Finish()
+	}
+	assert.Len(t, reg.List(false), MaxFinished+1)
+	assert.Equal(t, second.Id, reg.List(true)[0].Id)
+}
@@ -0,0 +1,180 @@
+package jpyexec
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encodings of the output of the programs, other than the ones supported by golang.org/x/text/encoding/htmlindex
+// (e.g.: "latin-1", "iso-8859-15", "windows-1252", "shift_jis", "gbk", "utf-16le", etc.).
+const (
+	// EncodingUTF8 is the default: invalid bytes are replaced by the Unicode replacement character (U+FFFD).
+	EncodingUTF8 = "utf-8"
+
+	// EncodingAuto keeps valid UTF-8 as is, and decodes invalid bytes as "windows-1252" (a superset of "latin-1"),
+	// the most common legacy encoding.
+	EncodingAuto = "auto"
+)
+
+// LookupEncoding returns the canonical name of the given output encoding, or an error if it is not supported.
+func LookupEncoding(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case EncodingAuto:
+		return EncodingAuto, nil
+	case "latin-1", "latin_1":
+		// Common spelling (e.g. in Python), not one of the WHATWG labels.
+		name = "latin1"
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return "", errors.Errorf("unknown output encoding %q, use %q, %q or one of the names in "+
+			"https://encoding.spec.whatwg.org/#names-and-labels", name, EncodingUTF8, EncodingAuto)
+	}
+	canonical, err := htmlindex.Name(enc)
+	if err != nil {
+		return "", errors.Wrapf(err, "output encoding %q", name)
+	}
+	if canonical == EncodingUTF8 {
+		return EncodingUTF8, nil
+	}
+	return canonical, nil
+}
+
+// LocaleEncoding returns the encoding of the locale (the charset in the environment variables `LC_ALL`,
+// `LC_CTYPE` or `LANG`, e.g.: "de_DE.ISO-8859-1"), used by default for the output of the programs.
+// It returns EncodingUTF8 if the locale is not set, or its charset is not supported.
+func LocaleEncoding() string {
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		locale := os.Getenv(key)
+		if locale == "" {
+			continue
+		}
+		// Format: language[_territory][.codeset][@modifier]
+		_, codeset, found := strings.Cut(locale, ".")
+		if !found {
+			return EncodingUTF8
+		}
+		codeset, _, _ = strings.Cut(codeset, "@")
+		if name, err := LookupEncoding(codeset); err == nil {
+			return name
+		}
+		return EncodingUTF8
+	}
+	return EncodingUTF8
+}
+
+// WithOutputEncoding configures the encoding of the stdout and stderr of the program, converted to UTF-8 before
+// being written. See LookupEncoding for the names accepted. If empty (the default), LocaleEncoding is used.
+func (exec *Executor) WithOutputEncoding(name string) *Executor {
+	exec.outputEncoding = name
+	return exec
+}
+
+// decodingWriter converts the output of the program to UTF-8 before writing it.
+// Multibyte sequences split between writes are kept until they are complete.
+type decodingWriter struct {
+	w       io.Writer
+	decode  func(src []byte, atEOF bool) (out []byte, nSrc int)
+	pending []byte
+}
+
+// newDecodingWriter returns a writer that converts what is written from the given encoding (see LookupEncoding)
+// to UTF-8, before writing it to w. It returns w itself, if the encoding is EncodingUTF8.
+func newDecodingWriter(w io.Writer, name string) (io.Writer, error) {
+	name, err := LookupEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case EncodingUTF8:
+		return w, nil
+	case EncodingAuto:
+		return &decodingWriter{w: w, decode: decodeAuto}, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "output encoding %q", name)
+	}
+	return &decodingWriter{w: w, decode: transformDecoder(enc)}, nil
+}
+
+// Write implements io.Writer.
+func (d *decodingWriter) Write(p []byte) (int, error) {
+	src := p
+	if len(d.pending) > 0 {
+		src = append(d.pending, p...)
+		d.pending = nil
+	}
+	out, nSrc := d.decode(src, false)
+	if nSrc < len(src) {
+		d.pending = bytes.Clone(src[nSrc:])
+	}
+	if len(out) > 0 {
+		if _, err := d.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush implements Flusher: it writes what is left of an incomplete multibyte sequence, and flushes
+// the underlying writer, if it is a Flusher.
+func (d *decodingWriter) Flush() error {
+	if len(d.pending) > 0 {
+		out, _ := d.decode(d.pending, true)
+		d.pending = nil
+		if _, err := d.w.Write(out); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := d.w.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// decodeAuto keeps valid UTF-8, and decodes any invalid byte as windows-1252.
+func decodeAuto(src []byte, atEOF bool) (out []byte, nSrc int) {
+	if utf8.Valid(src) {
+		return src, len(src)
+	}
+	out = make([]byte, 0, len(src)+len(src)/2)
+	for nSrc < len(src) {
+		r, size := utf8.DecodeRune(src[nSrc:])
+		if r == utf8.RuneError && size <= 1 {
+			if !atEOF && !utf8.FullRune(src[nSrc:]) {
+				// Possibly the start of a rune split between writes.
+				break
+			}
+			r, size = charmap.Windows1252.DecodeByte(src[nSrc]), 1
+		}
+		out = utf8.AppendRune(out, r)
+		nSrc += size
+	}
+	return
+}
+
+// transformDecoder returns a decode function for the given encoding.
+func transformDecoder(enc encoding.Encoding) func(src []byte, atEOF bool) (out []byte, nSrc int) {
+	decoder := enc.NewDecoder()
+	return func(src []byte, atEOF bool) (out []byte, nSrc int) {
+		// Decoding to UTF-8 from any of the supported encodings takes at most 3 bytes per input byte.
+		out = make([]byte, 3*len(src)+utf8.UTFMax)
+		nDst, nSrc, err := decoder.Transform(out, src, atEOF)
+		if err != nil && err != transform.ErrShortSrc {
+			// Shouldn't happen, decoders replace invalid input by U+FFFD: simply pass the rest along.
+			return append(out[:nDst], src[nSrc:]...), len(src)
+		}
+		return out[:nDst], nSrc
+	}
+}
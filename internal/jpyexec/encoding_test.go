@@ -0,0 +1,35 @@
+package jpyexec
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDecodingWriter(t *testing.T) {
+	for _, tc := range []struct {
+		encoding string
+		writes   []string
+		want     string
+	}{
+		{"latin-1", []string{"caf\xe9", " na\xefve"}, "café naïve"},
+		{"auto", []string{"caf\xe9 ", "\xc3", "\xa9t\xe9"}, "café été"},
+		{"shift_jis", []string{"\x82", "\xa0"}, "あ"},
+		{"utf-16le", []string{"a\x00\xe9", "\x00"}, "aé"},
+	} {
+		var buf bytes.Buffer
+		w, err := newDecodingWriter(&buf, tc.encoding)
+		require.NoError(t, err)
+		for _, s := range tc.writes {
+			n, err := w.Write([]byte(s))
+			require.NoError(t, err)
+			require.Equal(t, len(s), n)
+		}
+		require.NoError(t, w.(Flusher).Flush())
+		assert.Equal(t, tc.want, buf.String(), "encoding %q", tc.encoding)
+	}
+
+	_, err := newDecodingWriter(&bytes.Buffer{}, "foo")
+	require.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package jpyexec
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// This file implements the file browser displayed for the program with `gonbui.RequestFilePath`: it lists the
+// files served by Jupyter with the Jupyter contents API, or lets the user upload one from the browser, and the
+// file picked is sent back through comms, to the address of the protocol.FileRequest.
+
+//go:embed filepicker.js
+var filePickerJs []byte
+
+var tmplFilePickerJs = template.Must(template.New("filePickerJs").Parse(string(filePickerJs)))
+
+// MaxFileUploadSize is the maximum size of the files uploaded from the browser with `gonbui.RequestFilePath`.
+// They are sent in one message, base64 encoded, through comms.
+var MaxFileUploadSize = 32 << 20
+
+// jupyterSessionNameEnv is set by the Jupyter server in the environment of the kernel, with the path of the notebook.
+const jupyterSessionNameEnv = "JPY_SESSION_NAME"
+
+// dispatchFileRequest displays the file browser requested by the program in the cell output.
+func (exec *Executor) dispatchFileRequest(req *protocol.FileRequest) {
+	htmlId := "gonb_file_" + common.UniqueId()
+	jupyterRoot := os.Getenv(protocol.GONB_JUPYTER_ROOT_ENV)
+	htmlPicker, err := filePickerHtml(htmlId, req, jupyterRoot, os.Getenv(jupyterSessionNameEnv))
+	if err != nil {
+		exec.reportCellError(err)
+		return
+	}
+	if err = kernel.PublishHtml(exec.Msg, htmlPicker); err != nil {
+		exec.reportCellError(errors.WithMessagef(err, "failed to display file browser requested by the program"))
+	}
+}
+
+// notebookContentsDir returns the directory of the notebook in the Jupyter contents API (relative to the Jupyter
+// root, with "/" separators), given the notebook path set by the Jupyter server, which may be absolute or relative.
+// It returns "" (the root) if it can't be determined.
+func notebookContentsDir(jupyterRoot, notebookPath string) string {
+	if notebookPath == "" {
+		return ""
+	}
+	if filepath.IsAbs(notebookPath) {
+		rel, err := filepath.Rel(jupyterRoot, notebookPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return ""
+		}
+		notebookPath = rel
+	}
+	dir := path.Dir(filepath.ToSlash(notebookPath))
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return strings.TrimPrefix(dir, "/")
+}
+
+// filePickerHtml returns the HTML (with the Javascript) of the file browser requested, with the given `htmlId`.
+// The files served by Jupyter are only listed if jupyterRoot is known, since the program needs it to find the
+// file picked -- otherwise only the upload is offered.
+func filePickerHtml(htmlId string, req *protocol.FileRequest, jupyterRoot, notebookPath string) (string, error) {
+	patterns := req.Patterns
+	if patterns == nil {
+		patterns = []string{}
+	}
+	patternsJson, err := json.Marshal(patterns)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode file patterns %q", patterns)
+	}
+	var js bytes.Buffer
+	data := struct {
+		HtmlId, Address, Patterns, StartDir string
+		Browse                              bool
+		MaxUploadSize                       int
+	}{
+		HtmlId:        htmlId,
+		Address:       req.Address,
+		Patterns:      string(patternsJson),
+		StartDir:      notebookContentsDir(jupyterRoot, notebookPath),
+		Browse:        jupyterRoot != "",
+		MaxUploadSize: MaxFileUploadSize,
+	}
+	if err := tmplFilePickerJs.Execute(&js, data); err != nil {
+		return "", errors.Wrapf(err, "file picker template is invalid!?")
+	}
+
+	// Patterns of extensions (e.g.: "*.csv") also filter the files offered for upload.
+	var accept []string
+	for _, pattern := range patterns {
+		if ext := strings.TrimPrefix(pattern, "*"); strings.HasPrefix(ext, ".") && !strings.ContainsAny(ext, "*?[") {
+			accept = append(accept, ext)
+		} else {
+			accept = nil
+			break
+		}
+	}
+	var acceptAttr string
+	if len(accept) > 0 {
+		acceptAttr = fmt.Sprintf(` accept="%s"`, html.EscapeString(strings.Join(accept, ",")))
+	}
+
+	var prompt string
+	if req.Prompt != "" {
+		prompt = fmt.Sprintf("<div class=\"gonb-file-prompt\" style=\"font-weight: bold;\">%s</div>\n", html.EscapeString(req.Prompt))
+	}
+	return fmt.Sprintf(`<div id="%s" class="gonb-file-picker">
+%s<div class="gonb-file-browser">
+<div class="gonb-file-dir" style="font-family: monospace;"></div>
+<div class="gonb-file-list" style="max-height: 15em; overflow-y: auto; font-family: monospace;"></div>
+</div>
+<div class="gonb-file-buttons">
+<label>Upload <input type="file"%s></label>
+<button type="button" class="gonb-file-cancel">Cancel</button>
+<span class="gonb-file-status"></span>
+</div>
+</div>
+<script>%s</script>`, htmlId, prompt, acceptAttr, js.String()), nil
+}
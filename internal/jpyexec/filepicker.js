@@ -0,0 +1,147 @@
+(() => {
+    const div = document.getElementById("{{.HtmlId}}");
+    const browser = div.querySelector(".gonb-file-browser");
+    const dirLabel = div.querySelector(".gonb-file-dir");
+    const list = div.querySelector(".gonb-file-list");
+    const upload = div.querySelector("input[type=file]");
+    const cancel = div.querySelector(".gonb-file-cancel");
+    const status = div.querySelector(".gonb-file-status");
+    const address = "{{.Address}}";
+    const patterns = {{.Patterns}};
+    const maxUploadSize = {{.MaxUploadSize}};
+
+    const gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        status.textContent = "Not connected to GoNB, no file can be picked.";
+        return;
+    }
+
+    let closed = false;
+    let subscription;
+    function close(message) {
+        closed = true;
+        browser.style.display = "none";
+        upload.disabled = true;
+        cancel.disabled = true;
+        status.textContent = message;
+        if (subscription) {
+            gonb_comm.unsubscribe(subscription);
+            subscription = undefined;
+        }
+    }
+
+    // The program closes the file browser if it stops waiting for it (e.g.: timeout or interruption).
+    subscription = gonb_comm.subscribe(address, (_, value) => {
+        if (value?.closed) {
+            close("Closed by the program.");
+        }
+    });
+
+    // Patterns in the syntax of Go's `path.Match`, converted to regular expressions.
+    const regexps = patterns.map((pattern) => new RegExp("^" + pattern
+        .replace(/[.+${}()|\\]/g, "\\$&")
+        .replace(/\*/g, "[^/]*")
+        .replace(/\?/g, "[^/]") + "$"));
+    function matches(name) {
+        return regexps.length === 0 || regexps.some((re) => re.test(name));
+    }
+
+    // Base URL of the Jupyter server: JupyterLab and Notebook 7 keep it in the page configuration, the classic
+    // Notebook in the body of the page.
+    function baseUrl() {
+        const config = document.getElementById("jupyter-config-data");
+        if (config) {
+            try {
+                const url = JSON.parse(config.textContent).baseUrl;
+                if (url) {
+                    return url.endsWith("/") ? url : url + "/";
+                }
+            } catch (err) {
+                console.error(`GoNB file picker: invalid Jupyter configuration: ${err.message}`);
+            }
+        }
+        return document.body?.dataset?.baseUrl || "/";
+    }
+
+    function entry(text, onClick) {
+        const item = document.createElement("div");
+        item.textContent = text;
+        item.style.cursor = "pointer";
+        item.addEventListener("click", onClick);
+        return item;
+    }
+
+    async function browse(dir) {
+        const encoded = dir.split("/").map(encodeURIComponent).join("/");
+        const response = await fetch(`${baseUrl()}api/contents/${encoded}?type=directory&content=1`,
+            {credentials: "same-origin"});
+        if (!response.ok) {
+            throw new Error(`${response.status} ${response.statusText}`);
+        }
+        const model = await response.json();
+        if (closed) {
+            return;
+        }
+        dirLabel.textContent = "/" + dir;
+        list.replaceChildren();
+        if (dir !== "") {
+            const parent = dir.includes("/") ? dir.substring(0, dir.lastIndexOf("/")) : "";
+            list.appendChild(entry("📁 ..", () => navigate(parent)));
+        }
+        const items = model.content.slice().sort((a, b) => a.name.localeCompare(b.name));
+        for (const item of items) {
+            if (item.type === "directory") {
+                list.appendChild(entry(`📁 ${item.name}`, () => navigate(item.path)));
+            }
+        }
+        for (const item of items) {
+            if (item.type !== "directory" && matches(item.name)) {
+                list.appendChild(entry(`📄 ${item.name}`, () => {
+                    gonb_comm.send(address, {path: item.path});
+                    close(`Picked ${item.path}`);
+                }));
+            }
+        }
+    }
+
+    function navigate(dir) {
+        browse(dir).catch((err) => {
+            browser.style.display = "none";
+            status.textContent = `Jupyter files can't be listed (${err.message}), upload a file instead.`;
+        });
+    }
+
+    upload.addEventListener("change", () => {
+        const file = upload.files[0];
+        if (!file) {
+            return;
+        }
+        if (file.size > maxUploadSize) {
+            status.textContent = `${file.name} is larger than the maximum upload size of ${maxUploadSize} bytes.`;
+            return;
+        }
+        const reader = new FileReader();
+        reader.onload = () => {
+            // The result is a data URL: the base64 content follows the first comma.
+            const content = reader.result.substring(reader.result.indexOf(",") + 1);
+            gonb_comm.send(address, {name: file.name, content: content});
+            close(`Uploaded ${file.name} (${file.size} bytes)`);
+        };
+        reader.onerror = () => {
+            status.textContent = `Failed to read ${file.name}: ${reader.error?.message}`;
+        };
+        status.textContent = `Reading ${file.name} ...`;
+        reader.readAsDataURL(file);
+    });
+
+    cancel.addEventListener("click", () => {
+        gonb_comm.send(address, {cancelled: true});
+        close("Cancelled.");
+    });
+
+    if ({{.Browse}}) {
+        navigate("{{.StartDir}}");
+    } else {
+        browser.style.display = "none";
+    }
+})();
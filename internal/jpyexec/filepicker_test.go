@@ -0,0 +1,39 @@
+package jpyexec
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNotebookContentsDir(t *testing.T) {
+	assert.Equal(t, "", notebookContentsDir("/home/me", ""))
+	assert.Equal(t, "", notebookContentsDir("/home/me", "nb.ipynb"))
+	assert.Equal(t, "work/data", notebookContentsDir("/home/me", "work/data/nb.ipynb"))
+	assert.Equal(t, "work", notebookContentsDir("/home/me", "/home/me/work/nb.ipynb"))
+	assert.Equal(t, "", notebookContentsDir("/home/me", "/tmp/nb.ipynb"))
+}
+
+func TestFilePickerHtml(t *testing.T) {
+	req := &protocol.FileRequest{
+		Address:  "#gonbui/file/abc",
+		Prompt:   "Pick <data>",
+		Patterns: []string{"*.csv", "*.tsv"},
+	}
+	got, err := filePickerHtml("gonb_file_test", req, "/home/me", "work/nb.ipynb")
+	require.NoError(t, err)
+	assert.Contains(t, got, `<div id="gonb_file_test"`)
+	assert.Contains(t, got, "Pick &lt;data&gt;")
+	assert.Contains(t, got, `<input type="file" accept=".csv,.tsv">`)
+	assert.Contains(t, got, `const patterns = ["*.csv","*.tsv"];`)
+	assert.Contains(t, got, `if (true) {`)
+	assert.Contains(t, got, `navigate("work");`)
+
+	// Without the Jupyter root, only the upload is offered, of any file if patterns are not extensions.
+	req.Patterns = []string{"data_*"}
+	got, err = filePickerHtml("gonb_file_test", req, "", "work/nb.ipynb")
+	require.NoError(t, err)
+	assert.Contains(t, got, `if (false) {`)
+	assert.Contains(t, got, `<input type="file">`)
+}
@@ -0,0 +1,117 @@
+package jpyexec
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"html"
+	"strings"
+	"text/template"
+)
+
+// This file implements the rendering of the forms requested by the program with `gonbui.RequestForm`: the values
+// entered are sent back through comms, to the address of the protocol.FormRequest.
+
+//go:embed form.js
+var formJs []byte
+
+var tmplFormJs = template.Must(template.New("formJs").Parse(string(formJs)))
+
+// dispatchFormRequest displays the form requested by the program in the cell output.
+func (exec *Executor) dispatchFormRequest(req *protocol.FormRequest) {
+	htmlId := "gonb_form_" + common.UniqueId()
+	htmlForm, err := formHtml(htmlId, req)
+	if err != nil {
+		exec.reportCellError(err)
+		return
+	}
+	if err = kernel.PublishHtml(exec.Msg, htmlForm); err != nil {
+		exec.reportCellError(errors.WithMessagef(err, "failed to display form requested by the program"))
+	}
+}
+
+// formHtml returns the HTML (with the Javascript) of the form requested, with the given `htmlId`.
+// The reply is sent with comms, so the websocket must be installed in the front-end -- the program subscribes
+// to the address of the form before requesting it, which installs it.
+func formHtml(htmlId string, req *protocol.FormRequest) (string, error) {
+	var js bytes.Buffer
+	data := struct {
+		HtmlId, Address string
+	}{
+		HtmlId:  htmlId,
+		Address: req.Address,
+	}
+	if err := tmplFormJs.Execute(&js, data); err != nil {
+		return "", errors.Wrapf(err, "form template is invalid!?")
+	}
+
+	var fields strings.Builder
+	for ii, field := range req.Fields {
+		fieldId := fmt.Sprintf("%s_%d", htmlId, ii)
+		label := field.Label
+		if label == "" {
+			label = field.Name
+		}
+		var required string
+		if field.Required {
+			required = " required"
+		}
+		name := html.EscapeString(field.Name)
+		_, _ = fmt.Fprintf(&fields, `<div class="gonb-form-field" style="margin: 0.2em 0;"><label for="%s" style="display: inline-block; min-width: 10em;">%s</label> `,
+			fieldId, html.EscapeString(label))
+		switch field.Type {
+		case protocol.FormFieldCheckbox:
+			var checked string
+			if field.Default == "true" {
+				checked = " checked"
+			}
+			_, _ = fmt.Fprintf(&fields, `<input type="checkbox" id="%s" data-gonb-field="%s"%s>`, fieldId, name, checked)
+		case protocol.FormFieldSelect:
+			_, _ = fmt.Fprintf(&fields, `<select id="%s" data-gonb-field="%s"%s>`, fieldId, name, required)
+			for _, option := range field.Options {
+				var selected string
+				if option == field.Default {
+					selected = " selected"
+				}
+				_, _ = fmt.Fprintf(&fields, `<option value="%s"%s>%s</option>`,
+					html.EscapeString(option), selected, html.EscapeString(option))
+			}
+			fields.WriteString(`</select>`)
+		case protocol.FormFieldText, protocol.FormFieldPassword, protocol.FormFieldNumber, "":
+			inputType := field.Type
+			if inputType == "" {
+				inputType = protocol.FormFieldText
+			}
+			var step string
+			if inputType == protocol.FormFieldNumber {
+				step = ` step="any"`
+			}
+			_, _ = fmt.Fprintf(&fields, `<input type="%s" id="%s" data-gonb-field="%s" value="%s"%s%s>`,
+				inputType, fieldId, name, html.EscapeString(field.Default), step, required)
+		default:
+			return "", errors.Errorf("form field %q has an invalid type %q", field.Name, field.Type)
+		}
+		fields.WriteString("</div>\n")
+	}
+
+	var title string
+	if req.Title != "" {
+		title = fmt.Sprintf("<div class=\"gonb-form-title\" style=\"font-weight: bold;\">%s</div>\n", html.EscapeString(req.Title))
+	}
+	submitLabel := req.SubmitLabel
+	if submitLabel == "" {
+		submitLabel = "Submit"
+	}
+	return fmt.Sprintf(`<form id="%s" class="gonb-form">
+%s%s<div class="gonb-form-buttons">
+<button type="submit">%s</button>
+<button type="button" class="gonb-form-cancel">Cancel</button>
+<span class="gonb-form-status"></span>
+</div>
+</form>
+<script>%s</script>`, htmlId, title, fields.String(), html.EscapeString(submitLabel), js.String()), nil
+}
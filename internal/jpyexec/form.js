@@ -0,0 +1,57 @@
+(() => {
+    const form = document.getElementById("{{.HtmlId}}");
+    const status = form.querySelector(".gonb-form-status");
+    const address = "{{.Address}}";
+
+    const gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        status.textContent = "Not connected to GoNB, the form can't be submitted.";
+        return;
+    }
+
+    let subscription;
+    function close(message) {
+        for (const element of form.elements) {
+            element.disabled = true;
+        }
+        status.textContent = message;
+        if (subscription) {
+            gonb_comm.unsubscribe(subscription);
+            subscription = undefined;
+        }
+    }
+
+    // The program closes the form if it stops waiting for it (e.g.: timeout or interruption).
+    subscription = gonb_comm.subscribe(address, (_, value) => {
+        if (value?.closed) {
+            close("Form closed by the program.");
+        }
+    });
+
+    form.addEventListener("submit", (event) => {
+        event.preventDefault();
+        if (!form.reportValidity()) {
+            return;
+        }
+        const values = {};
+        for (const element of form.querySelectorAll("[data-gonb-field]")) {
+            const name = element.dataset.gonbField;
+            if (element.type === "checkbox") {
+                values[name] = element.checked;
+            } else if (element.type === "number") {
+                if (element.value !== "") {
+                    values[name] = Number(element.value);
+                }
+            } else {
+                values[name] = element.value;
+            }
+        }
+        gonb_comm.send(address, {values: values});
+        close("Submitted.");
+    });
+
+    form.querySelector(".gonb-form-cancel").addEventListener("click", () => {
+        gonb_comm.send(address, {cancelled: true});
+        close("Cancelled.");
+    });
+})();
@@ -0,0 +1,41 @@
+package jpyexec
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFormHtml(t *testing.T) {
+	req := &protocol.FormRequest{
+		Address: "#gonbui/form/abc",
+		Title:   "Run <config>",
+		Fields: []protocol.FormField{
+			{Name: "name", Required: true},
+			{Name: "steps", Label: "Steps", Type: protocol.FormFieldNumber, Default: "100"},
+			{Name: "debug", Type: protocol.FormFieldCheckbox, Default: "true"},
+			{Name: "optimizer", Type: protocol.FormFieldSelect, Options: []string{"adam", "sgd"}, Default: "sgd"},
+		},
+	}
+	got, err := formHtml("gonb_form_test", req)
+	require.NoError(t, err)
+	assert.Contains(t, got, `<form id="gonb_form_test"`)
+	assert.Contains(t, got, "Run &lt;config&gt;")
+	assert.Contains(t, got, `<input type="text" id="gonb_form_test_0" data-gonb-field="name" value="" required>`)
+	assert.Contains(t, got, `<input type="number" id="gonb_form_test_1" data-gonb-field="steps" value="100" step="any">`)
+	assert.Contains(t, got, `data-gonb-field="debug" checked>`)
+	assert.Contains(t, got, `<option value="sgd" selected>sgd</option>`)
+	assert.Contains(t, got, `<button type="submit">Submit</button>`)
+	assert.Contains(t, got, `const address = "#gonbui/form/abc";`)
+
+	// Confirmation: no fields.
+	got, err = formHtml("gonb_form_test", &protocol.FormRequest{Address: "#gonbui/form/abc", Title: "Delete?", SubmitLabel: "OK"})
+	require.NoError(t, err)
+	assert.Contains(t, got, `<button type="submit">OK</button>`)
+	assert.NotContains(t, got, "gonb-form-field")
+
+	req.Fields = append(req.Fields, protocol.FormField{Name: "color", Type: "colour"})
+	_, err = formHtml("gonb_form_test", req)
+	require.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package jpyexec
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+)
+
+// This file implements the framing of the messages read from the named pipe (`$GONB_PIPE`), so the kernel
+// doesn't trust the sizes sent by the program: messages larger than MaxPipeMessageSize are skipped, without
+// loading them in memory, and the reading continues with the next message.
+//
+// The stream is in the `encoding/gob` format: a sequence of messages, each one prefixed by its size, encoded
+// as a gob unsigned integer -- one byte if < 128, otherwise the negated number of bytes that follow,
+// with the number in big-endian.
+
+// MaxPipeMessageSize is the maximum size of a message read from the program: larger ones are dropped, and
+// reported in the cell output.
+var MaxPipeMessageSize = 128 << 20
+
+// frameReader reads the messages (frames) of a gob stream, dropping the ones larger than maxSize.
+type frameReader struct {
+	r       *bufio.Reader
+	maxSize int
+
+	// pending bytes of the current frame, not yet read.
+	pending []byte
+
+	// onDrop is called with the size of each frame dropped.
+	onDrop func(size uint64)
+}
+
+// newFrameReader returns a reader of the gob stream in r, that drops frames larger than maxSize.
+func newFrameReader(r io.Reader, maxSize int, onDrop func(size uint64)) *frameReader {
+	return &frameReader{r: bufio.NewReader(r), maxSize: maxSize, onDrop: onDrop}
+}
+
+// Read implements io.Reader.
+func (f *frameReader) Read(p []byte) (n int, err error) {
+	for len(f.pending) == 0 {
+		if err = f.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// nextFrame reads the next frame into pending, or skips it if it's too large.
+func (f *frameReader) nextFrame() error {
+	header, size, err := f.readSize()
+	if err != nil {
+		return err
+	}
+	if size > uint64(f.maxSize) {
+		if _, err = io.CopyN(io.Discard, f.r, int64(min(size, math.MaxInt64))); err != nil {
+			return noEOF(err)
+		}
+		if f.onDrop != nil {
+			f.onDrop(size)
+		}
+		return nil
+	}
+	frame := make([]byte, len(header)+int(size))
+	copy(frame, header)
+	if _, err = io.ReadFull(f.r, frame[len(header):]); err != nil {
+		return noEOF(err)
+	}
+	f.pending = frame
+	return nil
+}
+
+// readSize reads the size prefix of a frame, returning also its encoding.
+func (f *frameReader) readSize() (header []byte, size uint64, err error) {
+	b, err := f.r.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	if b < 0x80 {
+		return []byte{b}, uint64(b), nil
+	}
+	numBytes := -int(int8(b))
+	if numBytes > 8 {
+		return nil, 0, errors.Errorf("invalid message size prefix 0x%x in named pipe", b)
+	}
+	header = make([]byte, 1+numBytes)
+	header[0] = b
+	if _, err = io.ReadFull(f.r, header[1:]); err != nil {
+		return nil, 0, noEOF(err)
+	}
+	for _, digit := range header[1:] {
+		size = size<<8 | uint64(digit)
+	}
+	return header, size, nil
+}
+
+// noEOF converts an io.EOF in the middle of a frame to io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
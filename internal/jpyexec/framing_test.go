@@ -0,0 +1,48 @@
+package jpyexec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameReader(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	for _, text := range []string{"first", strings.Repeat("x", 1000), "third"} {
+		require.NoError(t, encoder.Encode(&protocol.DisplayData{
+			Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: text},
+		}))
+	}
+
+	var dropped []uint64
+	decoder := gob.NewDecoder(newFrameReader(&buf, 500, func(size uint64) { dropped = append(dropped, size) }))
+	var got []any
+	for {
+		data := &protocol.DisplayData{}
+		err := decoder.Decode(data)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		got = append(got, data.Data[protocol.MIMETextPlain])
+	}
+	assert.Equal(t, []any{"first", "third"}, got)
+	require.Len(t, dropped, 1)
+	assert.Greater(t, dropped[0], uint64(1000))
+}
+
+func TestFrameReaderTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(&protocol.DisplayData{
+		Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: "truncated"},
+	}))
+	truncated := buf.Bytes()[:buf.Len()-3]
+	err := gob.NewDecoder(newFrameReader(bytes.NewReader(truncated), 1000, nil)).Decode(&protocol.DisplayData{})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,130 @@
+package jpyexec
+
+import (
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// This file implements the bookkeeping of the Jupyter input prompts of the program being executed: their
+// timeout (see WithInputTimeout and protocol.InputRequest), and their cancellation when the program exits,
+// so a late answer is not written to the stdin of a program no longer running.
+
+// pendingInput is an input prompt waiting for the answer of the user.
+type pendingInput struct {
+	timer *time.Timer
+
+	// done is set when the prompt is answered, times out or is cancelled: later answers are ignored.
+	done bool
+}
+
+// SecretsStore holds the secrets of the session, requested by the program with `gonbui.RequestSecret`.
+// See WithSecrets.
+type SecretsStore interface {
+	// Secret returns the value of the secret, and whether it was found.
+	Secret(name string) (value string, found bool)
+
+	// SetSecret sets the value of the secret for the rest of the session.
+	SetSecret(name, value string)
+}
+
+// WithSecrets configures the store of the secrets requested by the program (see protocol.InputRequest.SecretName):
+// the secrets found there are written to the stdin of the program without prompting the user.
+//
+// If not set, the user is always prompted for the secrets.
+func (exec *Executor) WithSecrets(store SecretsStore) *Executor {
+	exec.secrets = store
+	return exec
+}
+
+// WithInputTimeout configures the timeout of the input prompts (see WithInputs and `gonbui.RequestInput`),
+// after which they are cancelled: the default value of the request is written to the stdin of the program
+// (see protocol.InputRequest), or, for WithInputs and WithPassword, its stdin is closed.
+//
+// If 0 (the default), prompts don't time out. Requests from the program with their own timeout are not affected.
+func (exec *Executor) WithInputTimeout(timeout time.Duration) *Executor {
+	exec.inputTimeout = timeout
+	return exec
+}
+
+// promptInputLocked prompts the user for input, and calls onAnswer with the value entered (without the new line).
+// If timeout > 0 and the user hasn't answered in time, the prompt is cancelled and onTimeout is called instead.
+//
+// It assumes exec.muDone is locked, and onAnswer and onTimeout are also called with exec.muDone locked.
+func (exec *Executor) promptInputLocked(prompt string, password bool, timeout time.Duration,
+	onAnswer func(value string), onTimeout func()) error {
+	exec.cancelInputLocked() // Only one prompt at a time.
+	p := &pendingInput{}
+	exec.pendingInput = p
+	err := exec.Msg.PromptInput(prompt, password, func(_, input *kernel.MessageImpl) error {
+		exec.muDone.Lock()
+		defer exec.muDone.Unlock()
+		if p.done || exec.isDone {
+			klog.V(1).Infof("input answered after the prompt was cancelled, ignored")
+			return nil
+		}
+		exec.finishInputLocked(p)
+		content := input.Composed.Content.(map[string]any)
+		value, _ := content["value"].(string)
+		onAnswer(value)
+		return nil
+	})
+	if err != nil {
+		exec.finishInputLocked(p)
+		return err
+	}
+	if timeout > 0 {
+		p.timer = time.AfterFunc(timeout, func() {
+			exec.muDone.Lock()
+			defer exec.muDone.Unlock()
+			if p.done || exec.isDone {
+				return
+			}
+			klog.Infof("input prompt of %q %v not answered after %s, cancelled", exec.command, exec.args, timeout)
+			exec.finishInputLocked(p)
+			_ = exec.Msg.CancelInput()
+			onTimeout()
+		})
+	}
+	return nil
+}
+
+// finishInputLocked marks the prompt as done, and stops its timer. It assumes exec.muDone is locked.
+func (exec *Executor) finishInputLocked(p *pendingInput) {
+	p.done = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if exec.pendingInput == p {
+		exec.pendingInput = nil
+	}
+}
+
+// cancelInputLocked cancels the pending input prompt, if any. It assumes exec.muDone is locked.
+func (exec *Executor) cancelInputLocked() {
+	if exec.pendingInput == nil {
+		return
+	}
+	exec.finishInputLocked(exec.pendingInput)
+	_ = exec.Msg.CancelInput()
+}
+
+// writeStdinLocked writes the value to the stdin of the program, unless it was closed. It assumes exec.muDone
+// is locked.
+func (exec *Executor) writeStdinLocked(value string) {
+	if exec.stdinClosed {
+		klog.V(1).Infof("stdin of %q %v already closed, input dropped", exec.command, exec.args)
+		return
+	}
+	cmdStdin := exec.cmdStdin
+	go func() {
+		// Write concurrently, not to block, in case program doesn't
+		// actually read anything from the stdin.
+		_, err := cmdStdin.Write([]byte(value))
+		if err != nil {
+			// Could happen if something was not fully written, and channel was closed, in
+			// which case it's ok.
+			klog.Warningf("failed to write to stdin of %q %v: %+v", exec.command, exec.args, err)
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+package jpyexec
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// promptMsg is a kernel.Message that records the input prompts, so the tests can answer them.
+type promptMsg struct {
+	kernel.Message
+
+	mu        sync.Mutex
+	prompts   []kernel.OnInputFn
+	cancelled int
+}
+
+// PromptInput implements kernel.Message.
+func (m *promptMsg) PromptInput(_ string, _ bool, onInput kernel.OnInputFn) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prompts = append(m.prompts, onInput)
+	return nil
+}
+
+// CancelInput implements kernel.Message.
+func (m *promptMsg) CancelInput() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cancelled++
+	return nil
+}
+
+// Cancelled returns the number of times CancelInput was called.
+func (m *promptMsg) Cancelled() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cancelled
+}
+
+// answer the prompt number ii with the value, as the front-end would.
+func (m *promptMsg) answer(t *testing.T, ii int, value string) {
+	m.mu.Lock()
+	onInput := m.prompts[ii]
+	m.mu.Unlock()
+	input := &kernel.MessageImpl{Composed: kernel.ComposedMsg{Content: map[string]any{"value": value}}}
+	require.NoError(t, onInput(nil, input))
+}
+
+// stdinRecorder is the stdin of a program, that records what is written to it.
+type stdinRecorder struct {
+	written chan string
+}
+
+func (r *stdinRecorder) Write(p []byte) (int, error) {
+	r.written <- string(p)
+	return len(p), nil
+}
+
+func (r *stdinRecorder) Close() error { return nil }
+
+// nextWrite returns the next value written to stdin, or "" if nothing is written in a short while.
+func (r *stdinRecorder) nextWrite() string {
+	select {
+	case value := <-r.written:
+		return value
+	case <-time.After(100 * time.Millisecond):
+		return ""
+	}
+}
+
+// promptExecutor returns an Executor with a fake message and stdin, to test the input prompts.
+func promptExecutor() (exec *Executor, msg *promptMsg, stdin *stdinRecorder) {
+	msg = &promptMsg{}
+	stdin = &stdinRecorder{written: make(chan string, 10)}
+	exec = &Executor{Msg: msg, cmdStdin: stdin}
+	return
+}
+
+func TestInputPrompt(t *testing.T) {
+	// The answer is written to stdin, and the timeout is stopped.
+	exec, msg, stdin := promptExecutor()
+	exec.dispatchInputRequest(&protocol.InputRequest{Prompt: "name?", Timeout: 50 * time.Millisecond, Default: "none"})
+	msg.answer(t, 0, "gopher")
+	assert.Equal(t, "gopher\n", stdin.nextWrite())
+	assert.Empty(t, stdin.nextWrite(), "timeout should not fire after the prompt is answered")
+	assert.Zero(t, msg.Cancelled())
+
+	// On timeout the prompt is cancelled, and the default is written. A late answer is ignored.
+	exec, msg, stdin = promptExecutor()
+	exec.dispatchInputRequest(&protocol.InputRequest{Prompt: "name?", Timeout: 10 * time.Millisecond, Default: "none"})
+	assert.Equal(t, "none\n", stdin.nextWrite())
+	assert.Equal(t, 1, msg.Cancelled())
+	msg.answer(t, 0, "gopher")
+	assert.Empty(t, stdin.nextWrite(), "answer after the timeout should be ignored")
+
+	// Answers after the program exits are ignored, and the timeout doesn't fire.
+	exec, msg, stdin = promptExecutor()
+	exec.dispatchInputRequest(&protocol.InputRequest{Prompt: "name?", Timeout: 10 * time.Millisecond, Default: "none"})
+	exec.muDone.Lock()
+	exec.isDone = true
+	exec.muDone.Unlock()
+	msg.answer(t, 0, "gopher")
+	assert.Empty(t, stdin.nextWrite(), "answer after the program exits should be ignored")
+	exec.dispatchInputRequest(&protocol.InputRequest{Prompt: "again?"})
+	assert.Len(t, msg.prompts, 1, "no prompt should be issued after the program exits")
+
+	// A new prompt cancels the previous one, whose answer is then ignored.
+	exec, msg, stdin = promptExecutor()
+	exec.dispatchInputRequest(&protocol.InputRequest{Prompt: "first?", Timeout: 50 * time.Millisecond, Default: "1"})
+	exec.dispatchInputRequest(&protocol.InputRequest{Prompt: "second?"})
+	assert.Equal(t, 1, msg.Cancelled())
+	msg.answer(t, 0, "late")
+	assert.Empty(t, stdin.nextWrite(), "answer to the cancelled prompt should be ignored, and it should not time out")
+	msg.answer(t, 1, "two")
+	assert.Equal(t, "two\n", stdin.nextWrite())
+	msg.answer(t, 1, "twice")
+	assert.Empty(t, stdin.nextWrite(), "prompt should be answered only once")
+}
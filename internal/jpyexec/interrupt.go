@@ -0,0 +1,90 @@
+package jpyexec
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"k8s.io/klog/v2"
+	"os"
+	osexec "os/exec"
+	"syscall"
+	"time"
+)
+
+// This file implements the interruption of the executed program: it is started in its own process group,
+// and when the kernel is interrupted, the whole group (the program and any sub-processes it started) receives
+// a SIGINT. Programs using `gonbui.OnInterrupt` are also notified through the named pipe, so they can checkpoint
+// their work and exit cleanly. If the program is still running after the grace period (see WithInterruptGrace),
+// the group is killed with SIGKILL.
+
+// WaitToKill is the default time to wait after an interrupt signal, before killing the process.
+var WaitToKill = 5 * time.Second
+
+// WithInterruptGrace configures how long to wait after the program is interrupted (with SIGINT) before it is
+// killed (with SIGKILL). If 0 (the default), WaitToKill is used.
+func (exec *Executor) WithInterruptGrace(grace time.Duration) *Executor {
+	exec.interruptGrace = grace
+	return exec
+}
+
+// newProcessGroup configures cmd to start in its own process group, so it can be interrupted (and killed)
+// along with the sub-processes it starts.
+func newProcessGroup(cmd *osexec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: 0}
+}
+
+// signalProcessGroup sends the signal to the process group of the executed program. If it fails (e.g.: the
+// process is not the leader of its group), the signal is sent only to the process.
+func signalProcessGroup(cmd *osexec.Cmd, sig syscall.Signal) error {
+	if err := syscall.Kill(-cmd.Process.Pid, sig); err == nil {
+		return nil
+	}
+	return cmd.Process.Signal(sig)
+}
+
+// subscribeInterrupt makes the kernel interruptions stop the program: see description at the top of the file.
+// It returns the subscription id, to be unsubscribed when the program finishes.
+func (exec *Executor) subscribeInterrupt(cmd *osexec.Cmd) kernel.SubscriptionId {
+	var interruptId kernel.SubscriptionId
+	interruptId = exec.Msg.Kernel().SubscribeInterrupt(func(id kernel.SubscriptionId) {
+		exec.Msg.Kernel().UnsubscribeInterrupt(interruptId)
+		exec.notifyInterrupt()
+		if err := signalProcessGroup(cmd, syscall.SIGINT); err != nil && err != os.ErrProcessDone {
+			klog.Errorf("failed to interrupt process %s (%v): %+v", cmd, cmd.Process, err)
+		}
+		grace := exec.interruptGrace
+		if grace <= 0 {
+			grace = WaitToKill
+		}
+		select {
+		case <-exec.doneChan:
+			// Normal stop, nothing to do.
+		case <-time.After(grace):
+			// If process hasn't yet died, kill it.
+			klog.Warningf("jpyexec: program still running %s after interrupted, killing it", grace)
+			err := signalProcessGroup(cmd, syscall.SIGKILL)
+			if err != nil && err != os.ErrProcessDone {
+				klog.Errorf("failed to kill process %s (%v): %+v", cmd, cmd.Process, err)
+			}
+		}
+	})
+	return interruptId
+}
+
+// notifyInterrupt sends protocol.GonbuiInterruptAddress to the program through the named pipe, if it is in use.
+// It is handled by `gonbui.OnInterrupt`.
+func (exec *Executor) notifyInterrupt() {
+	if !exec.useNamedPipes {
+		return
+	}
+	exec.muDone.Lock()
+	defer exec.muDone.Unlock()
+	if exec.isDone {
+		// PipeWriterFifo is closed after the program is done.
+		return
+	}
+	select {
+	case exec.PipeWriterFifo <- &protocol.CommValue{Address: protocol.GonbuiInterruptAddress, Value: 1}:
+	default:
+		klog.V(1).Infof("jpyexec: interrupt notification dropped because the named pipe buffer is full")
+	}
+}
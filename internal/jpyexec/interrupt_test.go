@@ -0,0 +1,35 @@
+package jpyexec
+
+import (
+	"github.com/stretchr/testify/require"
+	osexec "os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestSignalProcessGroup checks that sub-processes started by the program are also signaled: otherwise they
+// would keep the output pipes opened, and the execution wouldn't finish.
+func TestSignalProcessGroup(t *testing.T) {
+	cmd := osexec.Command("/bin/sh", "-c", "sleep 100 & sleep 100 & wait")
+	newProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, signalProcessGroup(cmd, syscall.SIGKILL))
+
+	// Pipe is closed when all processes of the group exit.
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := stdout.Read(make([]byte, 1))
+		readDone <- err
+	}()
+	select {
+	case err = <-readDone:
+		require.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("sub-processes not killed")
+	}
+	require.Error(t, cmd.Wait())
+}
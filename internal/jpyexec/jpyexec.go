@@ -10,17 +10,22 @@ package jpyexec
 
 import (
 	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/jobs"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/resources"
 	"github.com/pkg/errors"
 	"io"
 	"k8s.io/klog/v2"
-	"os"
 	osexec "os/exec"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
+// StdinCloseCommand, if entered as the answer to an input prompt, closes the stdin of the program being executed
+// instead of being written to it: so programs that read until EOF (e.g.: `io.ReadAll(os.Stdin)`) can finish.
+const StdinCloseCommand = "%stdin close"
+
 // Executor holds the configuration and state when executing a command that is piped to Jupyter.
 // Use New to create it.
 type Executor struct {
@@ -36,14 +41,31 @@ type Executor struct {
 	stdinContent               []byte
 	millisecondsToInput        int
 	inputPassword              bool
+	priority                   Priority
+	runnerPool                 *RunnerPool
+	outputEncoding             string
+	interruptGrace             time.Duration
+	inputTimeout               time.Duration
+	watchdogThreshold          time.Duration
+	secrets                    SecretsStore
 
 	// State when execution starts (after call to Exec)
 	cmd                                      *osexec.Cmd
 	cmdStdout, cmdStderr                     io.ReadCloser
 	cmdStdin                                 io.WriteCloser
 	namedPipeReaderPath, namedPipeWriterPath string
+	namedPipeReaderResource                  *resources.Resource
+	namedPipeWriterResource                  *resources.Resource
 	pipeReader                               io.ReadCloser // GONB_PIPE
 
+	// pipeReaderDone is closed when pipeReader is no longer being read: all messages sent by the program
+	// were handled.
+	pipeReaderDone     chan struct{}
+	pipeReaderDoneOnce sync.Once
+
+	// ordering of the display data relative to the stdout, if in use. See ordering.go.
+	ordering *outputOrdering
+
 	// pipeWriter is the pipe opened to send content to the program.
 	// jpyexec.Executor handles the opening/closing of the file, and exports
 	// PipeWriterFifo as the means to send messages through the pipe.
@@ -56,9 +78,26 @@ type Executor struct {
 	// Currently, it is assumed that it will be used by the CommsHandler.
 	PipeWriterFifo chan *protocol.CommValue
 
+	// protocolVersionChecked and protocolMismatchReported are set once the version of the protocol used by the
+	// program was checked, and once a message dropped was reported, see checkProtocolVersion. Similarly,
+	// decodeErrorReported is set once a message that failed to decode was reported.
+	protocolVersionChecked, protocolMismatchReported, decodeErrorReported bool
+
 	isDone   bool
 	doneChan chan struct{}
 	muDone   sync.Mutex
+
+	// stdinClosed is set once cmdStdin is closed before the end of the execution, see CloseStdin.
+	stdinClosed bool
+
+	// pendingInput is the input prompt waiting for an answer, if any, see promptInputLocked.
+	pendingInput *pendingInput
+
+	// watchdog of the execution, if enabled, see WithWatchdog.
+	watchdog *watchdog
+
+	// jobs started by the program (e.g.: servers announced), finished when it exits. Protected by muDone.
+	jobs []*jobs.Job
 }
 
 // New creates an executor for the given command plus arguments,
@@ -109,13 +148,34 @@ func (exec *Executor) InDir(dir string) *Executor {
 	return exec
 }
 
+// Flusher is implemented by the stdout and stderr `io.Writer` (see WithStdout and WithStderr) that need to be notified
+// when the program output is closed, e.g.: to write buffered output.
+type Flusher interface {
+	Flush() error
+}
+
 // WithStderr configures piping of stderr to the given `io.Writer`.
+// If it implements Flusher, it is flushed when the program stderr is closed.
 func (exec *Executor) WithStderr(stderrWriter io.Writer) *Executor {
 	exec.stderrWriter = stderrWriter
 	return exec
 }
 
-// WithStdout configures piping of stderr to the given `io.Writer`.
+// WithPriority configures the CPU and I/O priority of the program, see Priority.
+func (exec *Executor) WithPriority(priority Priority) *Executor {
+	exec.priority = priority
+	return exec
+}
+
+// WithRunnerPool configures the Executor to execute the program in a pre-started runner taken from the
+// pool, if one is available, see RunnerPool.
+func (exec *Executor) WithRunnerPool(pool *RunnerPool) *Executor {
+	exec.runnerPool = pool
+	return exec
+}
+
+// WithStdout configures piping of stdout to the given `io.Writer`.
+// If it implements FileWriter, the output may be moved directly to its file by the OS.
 func (exec *Executor) WithStdout(stdoutWriter io.Writer) *Executor {
 	exec.stdoutWriter = stdoutWriter
 	return exec
@@ -156,16 +216,18 @@ func (exec *Executor) WithStaticInput(stdinContent []byte) *Executor {
 	return exec
 }
 
-// WaitToKill is the to wait after an interrupt signal, before killing the process.
-var WaitToKill = 5 * time.Second
-
 // Exec executes the configured New configuration.
 //
 // It returns an error if it failed to execute or created the pipes -- but not if the executed
 // program returns an error for any reason.
 func (exec *Executor) Exec() error {
 	klog.Infof("Executing: %s %v", exec.command, exec.args)
+	command, args, err := exec.priority.Command(exec.command, exec.args)
+	if err != nil {
+		return err
+	}
 	exec.isDone = false
+	exec.stdinClosed = false
 	exec.doneChan = make(chan struct{})
 
 	// Make sure everyone is signal about program finished.
@@ -173,22 +235,42 @@ func (exec *Executor) Exec() error {
 	// writers/readers that were created are closed, even if the program was not executed.
 	defer exec.done()
 
-	cmd := osexec.Command(exec.command, exec.args...)
-	exec.cmd = cmd
-	cmd.Dir = exec.dir
-
-	var err error
-	exec.cmdStdout, err = cmd.StdoutPipe()
-	if err != nil {
-		return errors.WithMessagef(err, "failed to create pipe for stdout")
-	}
-	exec.cmdStderr, err = cmd.StderrPipe()
-	if err != nil {
-		return errors.WithMessagef(err, "failed to create pipe for stderr")
+	var cmd *osexec.Cmd
+	var r *runner
+	var runnerStarted bool
+	if exec.runnerPool != nil {
+		r = exec.runnerPool.take()
 	}
-	exec.cmdStdin, err = cmd.StdinPipe()
-	if err != nil {
-		return errors.WithMessagef(err, "failed to create pipe for stdin")
+	defer func() {
+		if r != nil && !runnerStarted {
+			// Failed before the program was sent to the runner.
+			r.kill()
+		}
+	}()
+	if r != nil {
+		// Pre-started runner, with the pipes already connected.
+		klog.V(1).Infof("Executing in pre-started runner (pid=%d)", r.cmd.Process.Pid)
+		cmd = r.cmd
+		exec.cmd = cmd
+		exec.cmdStdout, exec.cmdStderr, exec.cmdStdin = r.stdout, r.stderr, r.stdin
+	} else {
+		cmd = osexec.Command(command, args...)
+		exec.cmd = cmd
+		cmd.Dir = exec.dir
+		newProcessGroup(cmd)
+
+		exec.cmdStdout, err = cmd.StdoutPipe()
+		if err != nil {
+			return errors.WithMessagef(err, "failed to create pipe for stdout")
+		}
+		exec.cmdStderr, err = cmd.StderrPipe()
+		if err != nil {
+			return errors.WithMessagef(err, "failed to create pipe for stderr")
+		}
+		exec.cmdStdin, err = cmd.StdinPipe()
+		if err != nil {
+			return errors.WithMessagef(err, "failed to create pipe for stdin")
+		}
 	}
 
 	// Pipe all stdout and stderr to Jupyter (or the provided `io.Writer`'s).
@@ -198,21 +280,47 @@ func (exec *Executor) Exec() error {
 	if exec.stderrWriter == nil {
 		exec.stderrWriter = kernel.NewJupyterStreamWriter(exec.Msg, kernel.StreamStderr)
 	}
+	outputEncoding := exec.outputEncoding
+	if outputEncoding == "" {
+		outputEncoding = LocaleEncoding()
+	}
+	if exec.stdoutWriter, err = newDecodingWriter(exec.stdoutWriter, outputEncoding); err != nil {
+		return err
+	}
+	if exec.stderrWriter, err = newDecodingWriter(exec.stderrWriter, outputEncoding); err != nil {
+		return err
+	}
+	exec.ordering = nil
+	if exec.useNamedPipes && !isFileWriter(exec.stdoutWriter) {
+		exec.ordering = newOutputOrdering()
+		exec.stdoutWriter = &markerWriter{w: exec.stdoutWriter, ordering: exec.ordering}
+	}
+	exec.newWatchdog()
 	var streamersWG sync.WaitGroup
 	streamersWG.Add(2)
 	go func() {
 		defer streamersWG.Done()
-		_, err := io.Copy(exec.stdoutWriter, exec.cmdStdout)
+		_, err := pump("stdout", exec.watchOutput(exec.stdoutWriter), exec.cmdStdout)
 		if err != nil {
 			klog.Errorf("Failed copying execution stdout: %+v", err)
 		}
+		if flusher, ok := exec.stdoutWriter.(Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				klog.Errorf("Failed flushing execution stdout: %+v", err)
+			}
+		}
 	}()
 	go func() {
 		defer streamersWG.Done()
-		_, err := io.Copy(exec.stderrWriter, exec.cmdStderr)
-		if err != nil && err != io.EOF {
+		_, err := pump("stderr", exec.watchOutput(exec.stderrWriter), exec.cmdStderr)
+		if err != nil {
 			klog.Errorf("Failed copying execution stderr: %+v", err)
 		}
+		if flusher, ok := exec.stderrWriter.(Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				klog.Errorf("Failed flushing execution stderr: %+v", err)
+			}
+		}
 	}()
 
 	// Handle Jupyter input.
@@ -231,30 +339,25 @@ func (exec *Executor) Exec() error {
 	}
 
 	// Start command.
-	if err := cmd.Start(); err != nil {
+	if r != nil {
+		if err := r.run(command, args, cmd.Environ(), exec.dir); err != nil {
+			klog.Warningf("Failed to start command %q in runner", exec.command)
+			return errors.WithMessagef(err, "failed to start to execute command %q", exec.command)
+		}
+		runnerStarted = true
+	} else if err := cmd.Start(); err != nil {
 		klog.Warningf("Failed to start command %q", exec.command)
 		return errors.WithMessagef(err, "failed to start to execute command %q", exec.command)
 	}
+	var processResource *resources.Resource
+	if r != nil {
+		processResource = r.resource
+	} else {
+		processResource = resources.RegisterProcess("jpyexec", exec.command, cmd.Process)
+	}
 
-	var interruptId kernel.SubscriptionId
-	interruptId = exec.Msg.Kernel().SubscribeInterrupt(func(id kernel.SubscriptionId) {
-		// Sent interrupt to process.
-		err := cmd.Process.Signal(os.Interrupt)
-		exec.Msg.Kernel().UnsubscribeInterrupt(interruptId)
-		if err != nil {
-			klog.Errorf("failed to interrupt process %s (%v): %+v", cmd, cmd.Process, err)
-		}
-		select {
-		case <-exec.doneChan:
-			// Normal stop, nothing to do.
-		case <-time.After(WaitToKill):
-			// If process hasn't yet died, kill it.
-			err = cmd.Process.Signal(syscall.SIGKILL)
-			if err != nil {
-				klog.Errorf("failed to kill process %s (%v): %+v", cmd, cmd.Process, err)
-			}
-		}
-	})
+	interruptId := exec.subscribeInterrupt(cmd)
+	exec.startWatchdog()
 
 	if exec.stdinContent != nil {
 		exec.handleStaticInput()
@@ -262,7 +365,15 @@ func (exec *Executor) Exec() error {
 
 	// Wait for output pipes to finish.
 	streamersWG.Wait()
-	if err := cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	processResource.Forget()
+	exec.stopWatchdog()
+	if exec.useNamedPipes {
+		// Make sure the last messages sent by the program are displayed, before the execution finishes.
+		exec.drainPipeReader()
+	}
+	exec.finishJobs()
+	if err != nil {
 		errMsg := err.Error() + "\n"
 		if exec.Msg.Kernel().Interrupted.Load() {
 			errMsg = "^C\n" + errMsg
@@ -288,9 +399,7 @@ func (exec *Executor) done() {
 		return
 	}
 	exec.isDone = true
-	if exec.millisecondsToInput > 0 {
-		_ = exec.Msg.CancelInput()
-	}
+	exec.cancelInputLocked()
 	_ = exec.cmdStdin.Close()
 	close(exec.doneChan)
 	_ = exec.cmdStderr.Close()
@@ -303,45 +412,62 @@ func (exec *Executor) done() {
 
 // handleJupyterInput should only be called if exec.millisecondsToInput is set.
 func (exec *Executor) handleJupyterInput() {
-	// Set function to handle incoming content.
-	var writeStdinFn kernel.OnInputFn
+	var onAnswer func(value string)
 	schedulePromptFn := func() {
 		// Wait for the given time, and if command still running, ask
 		// Jupyter for stdin input.
 		time.Sleep(time.Duration(exec.millisecondsToInput) * time.Millisecond)
 		klog.V(2).Infof("%d milliseconds elapsed, prompt for input", exec.millisecondsToInput)
-		exec.muDone.Lock()
-		if !exec.isDone {
-			_ = exec.Msg.PromptInput(" ", exec.inputPassword, writeStdinFn)
-		}
-		exec.muDone.Unlock()
-	}
-	writeStdinFn = func(original, input *kernel.MessageImpl) error {
 		exec.muDone.Lock()
 		defer exec.muDone.Unlock()
-		if exec.isDone {
-			return nil
+		if !exec.isDone && !exec.stdinClosed {
+			// On timeout, the stdin is closed: there is no default value.
+			_ = exec.promptInputLocked(" ", exec.inputPassword, exec.inputTimeout, onAnswer, exec.closeStdinLocked)
 		}
-		content := input.Composed.Content.(map[string]any)
-		value := content["value"].(string) + "\n"
+	}
+	onAnswer = func(value string) {
+		value += "\n"
 		klog.V(2).Infof("stdin value: %q", value)
-		go func() {
-			// Write concurrently, not to block, in case program doesn't
-			// actually read anything from the stdin.
-			_, err := exec.cmdStdin.Write([]byte(value))
-			if err != nil {
-				// Could happen if something was not fully written, and channel was closed, in
-				// which case it's ok.
-				klog.Warningf("failed to write to stdin of %q %v: %+v", exec.command, exec.args, err)
-			}
-		}()
+		if isStdinClose(value) {
+			// No more prompts: the program won't read anything else.
+			exec.closeStdinLocked()
+			return
+		}
+		exec.writeStdinLocked(value)
 		// Reschedule itself for the next message.
 		go schedulePromptFn()
-		return nil
 	}
 	go schedulePromptFn()
 }
 
+// isStdinClose returns whether the value entered in an input prompt is the StdinCloseCommand.
+func isStdinClose(value string) bool {
+	return strings.TrimSpace(value) == StdinCloseCommand
+}
+
+// CloseStdin closes the stdin of the program being executed, so it reads an EOF. It is a no-op if the program
+// is not running, or its stdin is already closed.
+func (exec *Executor) CloseStdin() {
+	exec.muDone.Lock()
+	defer exec.muDone.Unlock()
+	if exec.isDone {
+		return
+	}
+	exec.closeStdinLocked()
+}
+
+// closeStdinLocked implements CloseStdin, assuming `exec.muDone` is locked.
+func (exec *Executor) closeStdinLocked() {
+	if exec.stdinClosed || exec.cmdStdin == nil {
+		return
+	}
+	exec.stdinClosed = true
+	klog.V(1).Infof("closing stdin of %q %v", exec.command, exec.args)
+	if err := exec.cmdStdin.Close(); err != nil {
+		klog.Warningf("failed to close stdin of %q %v: %+v", exec.command, exec.args, err)
+	}
+}
+
 func (exec *Executor) handleStaticInput() {
 	go func() {
 		// Write concurrently, not to block, in case program doesn't
@@ -0,0 +1,26 @@
+package jpyexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestCloseStdin(t *testing.T) {
+	assert.True(t, isStdinClose(" %stdin close\n"))
+	assert.False(t, isStdinClose("%stdin\n"))
+
+	reader, writer := io.Pipe()
+	exec := New(nil, "cat")
+	exec.cmdStdin = writer
+	go func() {
+		_, _ = writer.Write([]byte("some input\n"))
+		exec.CloseStdin()
+		exec.CloseStdin() // No-op.
+	}()
+	content, err := io.ReadAll(reader) // Returns on EOF.
+	require.NoError(t, err)
+	assert.Equal(t, "some input\n", string(content))
+	assert.True(t, exec.stdinClosed)
+}
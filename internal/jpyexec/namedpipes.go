@@ -11,21 +11,17 @@ import (
 	"fmt"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/logs"
+	"github.com/janpfeifer/gonb/internal/resources"
 	"github.com/pkg/errors"
 	"io"
 	"k8s.io/klog/v2"
 	"os"
 	"sync"
 	"syscall"
+	"time"
 )
 
-func init() {
-	// Register generic gob types we want to make sure are understood.
-	gob.Register(map[string]any{})
-	gob.Register([]string{})
-	gob.Register([]any{})
-}
-
 // CommsHandler interface is used if Executor.UseNamedPipes is called, and a CommsHandler
 // is provided.
 //
@@ -45,6 +41,10 @@ type CommsHandler interface {
 	// ProgramSendValueRequest is called when the program requests a value to be sent to an address.
 	ProgramSendValueRequest(address string, value any)
 
+	// ProgramSendReliableValueRequest is like ProgramSendValueRequest, but the value is re-sent
+	// until the front-end acknowledges it.
+	ProgramSendReliableValueRequest(address string, value any)
+
 	// ProgramReadValueRequest handler.
 	ProgramReadValueRequest(address string)
 
@@ -59,30 +59,64 @@ type CommsHandler interface {
 // can be buffered when writing to the named pipe before dropping.
 const PipeWriterFifoBufferSize = 128
 
+// DrainTimeout is the maximum time to wait, after the program exits, for the messages it left in the named
+// pipe (e.g.: the last plot displayed) to be handled, before the execution is considered finished.
+var DrainTimeout = 5 * time.Second
+
+// MaxConsecutiveDecodeErrors is the number of consecutive messages from the program that fail to be decoded
+// before the kernel stops reading them.
+var MaxConsecutiveDecodeErrors = 100
+
 // handleNamedPipes creates the named pipe and set up the goroutines to listen to them.
 //
 // TODO: make this more secure, maybe with a secret key also passed by the environment.
 func (exec *Executor) handleNamedPipes() (err error) {
 	exec.PipeWriterFifo = make(chan *protocol.CommValue, PipeWriterFifoBufferSize)
+	exec.pipeReaderDone = make(chan struct{})
+	exec.pipeReaderDoneOnce = sync.Once{}
+	exec.protocolVersionChecked, exec.protocolMismatchReported, exec.decodeErrorReported = false, false, false
 
 	// Create temporary named pipes in both directions.
 	exec.namedPipeReaderPath, err = exec.createTmpFifo()
 	if err != nil {
 		return errors.Wrapf(err, "creating named pipe used to read from program %s", exec.cmd)
 	}
+	exec.namedPipeReaderResource = resources.RegisterPath(resources.Fifo, "jpyexec", exec.namedPipeReaderPath)
 	exec.namedPipeWriterPath, err = exec.createTmpFifo()
 	if err != nil {
+		_ = exec.namedPipeReaderResource.Release()
 		return errors.Wrapf(err, "creating named pipe used to write to program %s", exec.cmd)
 	}
+	exec.namedPipeWriterResource = resources.RegisterPath(resources.Fifo, "jpyexec", exec.namedPipeWriterPath)
 	exec.cmd.Env = append(exec.cmd.Environ(),
 		protocol.GONB_PIPE_ENV+"="+exec.namedPipeReaderPath,
 		protocol.GONB_PIPE_BACK_ENV+"="+exec.namedPipeWriterPath)
+	exec.cmd.Env = append(exec.cmd.Env, exec.cellInfoEnv()...)
+	if exec.ordering != nil {
+		exec.cmd.Env = append(exec.cmd.Env, protocol.GONB_ORDERED_OUTPUT_ENV+"=1")
+	}
 
 	exec.openPipeReader()
 	exec.openPipeWriter()
 	return
 }
 
+// cellInfoEnv returns the environment variables with the information about the execution, that changes from
+// one execution to the other: so it is not injected in the binary at build time. See `gonbui.CellInfo`.
+func (exec *Executor) cellInfoEnv() (env []string) {
+	if exec.executionCount >= 0 {
+		env = append(env, fmt.Sprintf("%s=%d", protocol.GONB_EXECUTION_COUNT_ENV, exec.executionCount))
+	}
+	composed := exec.Msg.ComposedMsg()
+	if cellId, ok := composed.Metadata["cellId"].(string); ok {
+		env = append(env, protocol.GONB_CELL_ID_ENV+"="+cellId)
+	}
+	if composed.Header.Session != "" {
+		env = append(env, protocol.GONB_JUPYTER_SESSION_ENV+"="+composed.Header.Session)
+	}
+	return
+}
+
 func (exec *Executor) createTmpFifo() (string, error) {
 	// Create a temporary file name.
 	f, err := os.CreateTemp(exec.dir, "gonb_pipe_")
@@ -133,13 +167,14 @@ func (exec *Executor) openPipeReader() {
 			}
 		}
 		muFifo.Unlock()
-		_ = os.Remove(exec.namedPipeReaderPath)
+		_ = exec.namedPipeReaderResource.Release()
 	}()
 
 	go func() {
 		klog.V(2).Infof("Opening named pipeReader in %q", exec.namedPipeReaderPath)
 		if exec.isDone {
 			// In case program execution interrupted early.
+			exec.closePipeReaderDone()
 			return
 		}
 		// Notice that opening pipeReader below blocks, until the other end
@@ -148,6 +183,7 @@ func (exec *Executor) openPipeReader() {
 		exec.pipeReader, err = os.Open(exec.namedPipeReaderPath)
 		if err != nil {
 			klog.Warningf("Failed to open pipe (Mkfifo) %q for reading: %+v", exec.namedPipeReaderPath, err)
+			exec.closePipeReaderDone()
 			return
 		}
 		klog.V(2).Infof("Opened named pipeReader in %q", exec.namedPipeReaderPath)
@@ -156,28 +192,92 @@ func (exec *Executor) openPipeReader() {
 		defer muFifo.Unlock()
 
 		// Start polling of the pipeReader.
-		go exec.pollNamedPipeReader()
+		go func() {
+			defer exec.closePipeReaderDone()
+			exec.pollNamedPipeReader()
+		}()
 
 		// Wait program execution to finish to close reader (in case it is not yet closed).
 		<-exec.doneChan
 		_ = exec.pipeReader.Close()
-		_ = os.Remove(exec.namedPipeReaderPath)
+		_ = exec.namedPipeReaderResource.Release()
 	}()
 }
 
+// closePipeReaderDone signals that the pipeReader is no longer being read, see drainPipeReader.
+func (exec *Executor) closePipeReaderDone() {
+	exec.pipeReaderDoneOnce.Do(func() { close(exec.pipeReaderDone) })
+}
+
+// drainPipeReader is called after the program exits, and waits until all the messages it left in the
+// pipeReader are handled (up to DrainTimeout). Otherwise, the last display messages could be lost when
+// the pipes are closed.
+func (exec *Executor) drainPipeReader() {
+	// If the program never opened the pipe, the reader is (or will soon be) blocked opening it: opening it for
+	// writing (and closing it) unblocks it, and it reads an EOF. Otherwise, this is harmless.
+	// The non-blocking open fails until the reader is opening the pipe, so it is retried.
+	deadline := time.After(DrainTimeout)
+	unblocked := false
+	for {
+		var retry <-chan time.Time
+		if !unblocked {
+			w, err := os.OpenFile(exec.namedPipeReaderPath, os.O_WRONLY|syscall.O_NONBLOCK, 0600)
+			if err == nil {
+				_ = w.Close()
+				unblocked = true
+			} else {
+				retry = time.After(time.Millisecond)
+			}
+		}
+		select {
+		case <-exec.pipeReaderDone:
+			return
+		case <-retry:
+		case <-deadline:
+			klog.Warningf("jpyexec: messages from the program still being read %s after it exited, "+
+				"some output may be lost", DrainTimeout)
+			return
+		}
+	}
+}
+
 // pollNamedPipeReader will continuously read for incoming requests with displaying content
 // on the notebook or widgets updates.
 func (exec *Executor) pollNamedPipeReader() {
-	decoder := gob.NewDecoder(exec.pipeReader)
+	decoder := gob.NewDecoder(newFrameReader(exec.pipeReader, MaxPipeMessageSize, func(size uint64) {
+		exec.reportDecodeError(errors.Errorf("message of %d bytes dropped, larger than the maximum of %d bytes "+
+			"(see jpyexec.MaxPipeMessageSize)", size, MaxPipeMessageSize))
+	}))
+	var dropped bool // Whether the last message was dropped, see protocol.IsUnregisteredTypeError.
+	var consecutiveErrors int
 	for {
 		data := &protocol.DisplayData{}
 		err := decoder.Decode(data)
-		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.Is(err, io.ErrClosedPipe) || errors.Is(err, os.ErrClosed) {
 			return
 		} else if err != nil {
-			klog.Infof("Named pipe: failed to parse message: %+v", err)
-			return
+			// The decoder re-synchronizes at the next message: gob messages are prefixed by their size.
+			consecutiveErrors++
+			if consecutiveErrors > MaxConsecutiveDecodeErrors {
+				exec.reportCellError(errors.Errorf("failed to decode %d consecutive messages from the program, "+
+					"giving up: displaying content and widgets won't work for the rest of the execution. "+
+					"Last error: %v", consecutiveErrors, err))
+				return
+			}
+			if protocol.IsUnregisteredTypeError(err) {
+				exec.reportProtocolMismatch(err)
+				dropped = true
+			} else if !dropped || !protocol.IsCorruptedError(err) {
+				// Not the remainder of a message dropped.
+				exec.reportDecodeError(err)
+			}
+			continue
 		}
+		dropped = false
+		consecutiveErrors = 0
+		exec.checkProtocolVersion(data.Version)
+		exec.watchdog.touch()
 
 		// Special case for a request for input:
 		if reqAny, found := data.Data[protocol.MIMEJupyterInput]; found {
@@ -193,6 +293,45 @@ func (exec *Executor) pollNamedPipeReader() {
 			continue
 		}
 
+		// Form requested with `gonbui.RequestForm`: the reply is sent back through comms.
+		if reqAny, found := data.Data[protocol.MIMEFormRequest]; found {
+			req, ok := reqAny.(protocol.FormRequest)
+			if !ok {
+				exec.reportCellError(errors.Errorf(
+					"A MIMEFormRequest sent to GONB_PIPE without an associated protocol.FormRequest!? -- got (%T) %#v",
+					reqAny, reqAny))
+				continue
+			}
+			exec.dispatchFormRequest(&req)
+			continue
+		}
+
+		// File requested with `gonbui.RequestFilePath`: the file picked is sent back through comms.
+		if reqAny, found := data.Data[protocol.MIMEFileRequest]; found {
+			req, ok := reqAny.(protocol.FileRequest)
+			if !ok {
+				exec.reportCellError(errors.Errorf(
+					"A MIMEFileRequest sent to GONB_PIPE without an associated protocol.FileRequest!? -- got (%T) %#v",
+					reqAny, reqAny))
+				continue
+			}
+			exec.dispatchFileRequest(&req)
+			continue
+		}
+
+		// Server announced with `gonbui.AnnounceServer`: tracked as a job, and linked in the cell output.
+		if reqAny, found := data.Data[protocol.MIMEServerAnnouncement]; found {
+			req, ok := reqAny.(protocol.ServerAnnouncement)
+			if !ok {
+				exec.reportCellError(errors.Errorf(
+					"A MIMEServerAnnouncement sent to GONB_PIPE without an associated protocol.ServerAnnouncement!? -- got (%T) %#v",
+					reqAny, reqAny))
+				continue
+			}
+			exec.dispatchServerAnnouncement(&req)
+			continue
+		}
+
 		// CommValue: update or read value in the front-end.
 		if reqAny, found := data.Data[protocol.MIMECommValue]; found {
 			req, ok := reqAny.(protocol.CommValue)
@@ -226,6 +365,9 @@ func (exec *Executor) pollNamedPipeReader() {
 			} else if req.Request {
 				klog.V(2).Infof("ProgramReadValueRequest(%q) requested", req.Address)
 				exec.commsHandler.ProgramReadValueRequest(req.Address)
+			} else if req.Reliable {
+				klog.V(2).Infof("ProgramSendReliableValueRequest(%q, %v) requested", req.Address, req.Value)
+				exec.commsHandler.ProgramSendReliableValueRequest(req.Address, req.Value)
 			} else {
 				klog.V(2).Infof("ProgramSendValueRequest(%q, %v) requested", req.Address, req.Value)
 				exec.commsHandler.ProgramSendValueRequest(req.Address, req.Value)
@@ -256,7 +398,33 @@ func (exec *Executor) pollNamedPipeReader() {
 			continue
 		}
 
-		// Otherwise, just display with the corresponding MIME type:
+		// LogRecord: structured log of the program, streamed to the log viewers (`%logs`).
+		if reqAny, found := data.Data[protocol.MIMELogRecord]; found {
+			record, ok := reqAny.(protocol.LogRecord)
+			if !ok {
+				exec.reportCellError(errors.Errorf(
+					"Invalid message sent in named pipes to GoNB from cell -- "+
+						"MIMELogRecord sent to $GONB_PIPE without an associated `protocol.LogRecord` "+
+						"type, got %T instead", reqAny))
+				continue
+			}
+			if !logs.Default.AddProgramRecord(&record) {
+				// No log viewer opened: display it in the cell output.
+				err := kernel.PublishWriteStream(exec.Msg, kernel.StreamStderr, logs.FormatProgramRecord(&record)+"\n")
+				if err != nil {
+					klog.Errorf("Failed to publish log record to Jupyter: %+v", err)
+				}
+			}
+			continue
+		}
+
+		// Otherwise, just display with the corresponding MIME type, in order with the stdout (see ordering.go).
+		if data.Seq > 0 && exec.ordering != nil {
+			exec.ordering.waitStdout(data.Seq)
+			exec.dispatchDisplayData(data)
+			exec.ordering.handled(data.Seq)
+			continue
+		}
 		exec.dispatchDisplayData(data)
 	}
 }
@@ -271,6 +439,51 @@ func (exec *Executor) reportCellError(err error) {
 	}
 }
 
+// checkProtocolVersion logs (once per execution) if the program uses a different version of the protocol.
+func (exec *Executor) checkProtocolVersion(version int) {
+	if version == protocol.Version || exec.protocolVersionChecked {
+		return
+	}
+	exec.protocolVersionChecked = true
+	if version > protocol.Version {
+		klog.Warningf("jpyexec: program uses gonbui protocol version %d, newer than the kernel's (%d)", version, protocol.Version)
+	} else {
+		klog.V(1).Infof("jpyexec: program uses gonbui protocol version %d, older than the kernel's (%d)", version, protocol.Version)
+	}
+}
+
+// reportDecodeError reports to the cell output (once per execution, to avoid flooding it) that a message from
+// the program was dropped, because it could not be decoded.
+func (exec *Executor) reportDecodeError(err error) {
+	klog.Warningf("jpyexec: message from the program dropped: %v", err)
+	if exec.decodeErrorReported {
+		return
+	}
+	exec.decodeErrorReported = true
+	err = kernel.PublishWriteStream(exec.Msg, kernel.StreamStderr, fmt.Sprintf(
+		"GoNB: a message from the program (e.g.: some content displayed) was dropped, and the following ones are "+
+			"still handled: %v\n", err))
+	if err != nil {
+		klog.Errorf("%+v", errors.WithStack(err))
+	}
+}
+
+// reportProtocolMismatch reports (once per execution) to the cell output that a message from the program was
+// dropped, because it has a value of a type unknown to the kernel.
+func (exec *Executor) reportProtocolMismatch(err error) {
+	klog.Warningf("jpyexec: message from the program dropped: %v", err)
+	if exec.protocolMismatchReported {
+		return
+	}
+	exec.protocolMismatchReported = true
+	err = kernel.PublishWriteStream(exec.Msg, kernel.StreamStderr, fmt.Sprintf(
+		"GoNB: a message from the program was dropped, because it uses a version of `gonbui` newer than the "+
+			"kernel's (protocol version %d) -- consider updating GoNB: %v\n", protocol.Version, err))
+	if err != nil {
+		klog.Errorf("%+v", errors.WithStack(err))
+	}
+}
+
 // dispatchDisplayData received through the named pipe (`$GONB_PIPE`).
 func (exec *Executor) dispatchDisplayData(data *protocol.DisplayData) {
 	// Log info about what is being displayed.
@@ -302,32 +515,67 @@ func (exec *Executor) dispatchDisplayData(data *protocol.DisplayData) {
 
 // dispatchInputRequest uses the standard Jupyter input mechanism.
 // It is fundamentally broken -- it locks the UI even if the program already stopped running --
-// so we suggest using the `gonb/gonbui/widgets` API instead.
+// so we suggest using the `gonb/gonbui/widgets` API instead. To mitigate it, the prompt can time out (see
+// protocol.InputRequest and WithInputTimeout), and late answers (after the program exits) are ignored.
 func (exec *Executor) dispatchInputRequest(req *protocol.InputRequest) {
 	klog.V(2).Infof("Received InputRequest %+v", req)
-	writeStdinFn := func(original, input *kernel.MessageImpl) error {
-		content := input.Composed.Content.(map[string]any)
-		value := content["value"].(string) + "\n"
+	exec.muDone.Lock()
+	defer exec.muDone.Unlock()
+	if exec.isDone {
+		return
+	}
+	timeout := req.Timeout
+	if timeout == 0 {
+		timeout = exec.inputTimeout
+	}
+	if req.SecretName != "" {
+		exec.dispatchSecretRequestLocked(req, timeout)
+		return
+	}
+	onAnswer := func(value string) {
+		value += "\n"
 		klog.V(2).Infof("stdin value: %q", value)
-		go func() {
-			exec.muDone.Lock()
-			cmdStdin := exec.cmdStdin
-			exec.muDone.Unlock()
-			if exec.isDone {
-				return
-			}
-			// Write concurrently, not to block, in case program doesn't
-			// actually read anything from the stdin.
-			_, err := cmdStdin.Write([]byte(value))
-			if err != nil {
-				// Could happen if something was not fully written, and channel was closed, in
-				// which case it's ok.
-				klog.Warningf("failed to write to stdin of cell: %+v", err)
-			}
-		}()
-		return nil
+		if isStdinClose(value) {
+			exec.closeStdinLocked()
+			return
+		}
+		exec.writeStdinLocked(value)
+	}
+	onTimeout := func() {
+		exec.writeStdinLocked(req.Default + "\n")
+	}
+	err := exec.promptInputLocked(req.Prompt, req.Password, timeout, onAnswer, onTimeout)
+	if err != nil {
+		exec.reportCellError(err)
+	}
+}
+
+// dispatchSecretRequestLocked handles an InputRequest for a secret: it is taken from the secrets store, if
+// there, otherwise the user is prompted for it (as a password). It assumes exec.muDone is locked.
+func (exec *Executor) dispatchSecretRequestLocked(req *protocol.InputRequest, timeout time.Duration) {
+	if exec.secrets != nil {
+		if value, found := exec.secrets.Secret(req.SecretName); found {
+			klog.V(1).Infof("secret %q taken from the session", req.SecretName)
+			exec.writeStdinLocked(value + "\n")
+			return
+		}
+	}
+	onAnswer := func(value string) {
+		// The value is not logged.
+		if isStdinClose(value) {
+			exec.closeStdinLocked()
+			return
+		}
+		if req.PersistSecret && exec.secrets != nil {
+			exec.secrets.SetSecret(req.SecretName, value)
+			klog.V(1).Infof("secret %q kept for the session", req.SecretName)
+		}
+		exec.writeStdinLocked(value + "\n")
+	}
+	onTimeout := func() {
+		exec.writeStdinLocked(req.Default + "\n")
 	}
-	err := exec.Msg.PromptInput(req.Prompt, req.Password, writeStdinFn)
+	err := exec.promptInputLocked(req.Prompt, true, timeout, onAnswer, onTimeout)
 	if err != nil {
 		exec.reportCellError(err)
 	}
@@ -362,7 +610,7 @@ func (exec *Executor) openPipeWriter() {
 			}
 		}
 		muFifo.Unlock()
-		_ = os.Remove(exec.namedPipeWriterPath)
+		_ = exec.namedPipeWriterResource.Release()
 	}()
 
 	go func() {
@@ -392,7 +640,7 @@ func (exec *Executor) openPipeWriter() {
 		<-exec.doneChan
 		close(exec.PipeWriterFifo)
 		_ = exec.pipeWriter.Close()
-		_ = os.Remove(exec.namedPipeWriterPath)
+		_ = exec.namedPipeWriterResource.Release()
 	}()
 }
 
@@ -402,6 +650,7 @@ func (exec *Executor) pollPipeWriterFifo() {
 	encoder := gob.NewEncoder(exec.pipeWriter)
 	klog.V(2).Infof("jpyexec: pollPipeWriterFifo() listening to requests.")
 	for msg := range exec.PipeWriterFifo {
+		msg.Version = protocol.Version
 		if klog.V(2).Enabled() {
 			klog.Infof("jpyexec: encoding %+v to named pipe to cell program", msg)
 		}
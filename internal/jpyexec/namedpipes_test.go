@@ -0,0 +1,239 @@
+package jpyexec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// publishMsg is a kernel.Message that records the messages published, JSON encoded and decoded back.
+type publishMsg struct {
+	kernel.Message
+
+	mu        sync.Mutex
+	published []map[string]any
+}
+
+// Publish implements kernel.Message.
+func (m *publishMsg) Publish(msgType string, content any) error {
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	var decoded map[string]any
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		return err
+	}
+	decoded["msg_type"] = msgType
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published = append(m.published, decoded)
+	return nil
+}
+
+// Published returns the messages published of the given type.
+func (m *publishMsg) Published(msgType string) (published []map[string]any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, content := range m.published {
+		if content["msg_type"] == msgType {
+			published = append(published, content)
+		}
+	}
+	return
+}
+
+// oldDisplayData is protocol.DisplayData as sent by programs compiled with gonbui before the protocol was
+// versioned: it has no Version field.
+type oldDisplayData struct {
+	Data map[protocol.MIMEType]any
+}
+
+// futureValue is a value of a type unknown to the kernel, as sent by a program compiled with a newer gonbui.
+type futureValue struct{ Answer int }
+
+// futureValueName is the name futureValue is registered with, replaced by unknownValueName (of the same length)
+// in the messages encoded by encodeMessages.
+const (
+	futureValueName  = "gonb.test.futureValueRegistered"
+	unknownValueName = "gonb.test.futureValueUnknown___"
+)
+
+func init() {
+	gob.RegisterName(futureValueName, futureValue{})
+}
+
+// encodeMessages gob encodes the messages in a single stream, as written by a program to the named pipe.
+// Values of type futureValue are encoded with a type name not registered.
+func encodeMessages(t *testing.T, messages ...any) io.ReadCloser {
+	require.Equal(t, len(futureValueName), len(unknownValueName))
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	for _, msg := range messages {
+		require.NoError(t, encoder.Encode(msg))
+	}
+	return io.NopCloser(bytes.NewReader(bytes.ReplaceAll(buf.Bytes(), []byte(futureValueName), []byte(unknownValueName))))
+}
+
+// text returns a message displaying the text, with the given protocol version.
+func text(content string, version int) *protocol.DisplayData {
+	return &protocol.DisplayData{Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: content}, Version: version}
+}
+
+// displayedTexts returns the plain texts displayed, and the messages written to stderr.
+func displayedTexts(msg *publishMsg) (texts, stderr []string) {
+	for _, content := range msg.Published("display_data") {
+		texts = append(texts, content["data"].(map[string]any)[string(protocol.MIMETextPlain)].(string))
+	}
+	for _, content := range msg.Published("stream") {
+		stderr = append(stderr, content["text"].(string))
+	}
+	return
+}
+
+func TestPollNamedPipeReaderVersions(t *testing.T) {
+	// Messages of programs with older versions of the protocol are handled.
+	msg := &publishMsg{}
+	exec := &Executor{Msg: msg}
+	exec.pipeReader = encodeMessages(t,
+		&oldDisplayData{Data: map[protocol.MIMEType]any{protocol.MIMETextPlain: "unversioned"}},
+		text("older", protocol.Version-1))
+	exec.pollNamedPipeReader()
+	texts, stderr := displayedTexts(msg)
+	assert.Equal(t, []string{"unversioned", "older"}, texts)
+	assert.Empty(t, stderr)
+	assert.True(t, exec.protocolVersionChecked)
+
+	// Messages with values of types unknown to the kernel (from programs using a newer version of the protocol)
+	// are dropped, reported once, and the following messages are still handled.
+	msg = &publishMsg{}
+	exec = &Executor{Msg: msg}
+	future := &protocol.DisplayData{
+		Data:    map[protocol.MIMEType]any{protocol.MIMETextPlain: "future", "application/future": futureValue{42}},
+		Version: protocol.Version + 1,
+	}
+	exec.pipeReader = encodeMessages(t, text("before", protocol.Version+1), future, text("between", protocol.Version+1),
+		future, text("after", protocol.Version+1))
+	exec.pollNamedPipeReader()
+	texts, stderr = displayedTexts(msg)
+	assert.Equal(t, []string{"before", "between", "after"}, texts)
+	require.Len(t, stderr, 1, "the mismatch should be reported only once")
+	assert.Contains(t, stderr[0], "uses a version of `gonbui` newer than the kernel's")
+	assert.True(t, exec.protocolMismatchReported)
+	assert.True(t, exec.protocolVersionChecked)
+	assert.False(t, exec.decodeErrorReported, "the remainder of the messages dropped should not be reported")
+
+	// Messages of the current version.
+	msg = &publishMsg{}
+	exec = &Executor{Msg: msg}
+	exec.pipeReader = encodeMessages(t, text("current", protocol.Version))
+	exec.pollNamedPipeReader()
+	texts, stderr = displayedTexts(msg)
+	assert.Equal(t, []string{"current"}, texts)
+	assert.Empty(t, stderr)
+	assert.False(t, exec.protocolVersionChecked, "nothing to check with the current version")
+}
+
+// nopWriteCloser is an io.WriteCloser that doesn't need closing.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestPollPipeWriterFifoVersion(t *testing.T) {
+	var buf bytes.Buffer
+	exec := &Executor{
+		pipeWriter:     nopWriteCloser{&buf},
+		PipeWriterFifo: make(chan *protocol.CommValue, 2),
+	}
+	exec.PipeWriterFifo <- &protocol.CommValue{Address: "/a", Value: 1}
+	exec.PipeWriterFifo <- &protocol.CommValue{Address: "/b", Value: "x", Version: 1}
+	close(exec.PipeWriterFifo)
+	exec.pollPipeWriterFifo()
+
+	// Every message is sent with the version of the kernel.
+	decoder := gob.NewDecoder(&buf)
+	var addresses []string
+	for {
+		value := &protocol.CommValue{}
+		err := decoder.Decode(value)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, protocol.Version, value.Version)
+		addresses = append(addresses, value.Address)
+	}
+	assert.Equal(t, []string{"/a", "/b"}, addresses)
+}
+
+// pipeReaderExecutor returns an Executor reading the messages of the program from a named pipe, as
+// UseNamedPipes does, and the message where the displayed data is published.
+func pipeReaderExecutor(t *testing.T) (exec *Executor, msg *publishMsg) {
+	msg = &publishMsg{}
+	exec = &Executor{Msg: msg, dir: t.TempDir(), doneChan: make(chan struct{}), pipeReaderDone: make(chan struct{})}
+	var err error
+	exec.namedPipeReaderPath, err = exec.createTmpFifo()
+	require.NoError(t, err)
+	exec.openPipeReader()
+	t.Cleanup(func() { close(exec.doneChan) })
+	return
+}
+
+// writeMessages writes the texts to the named pipe, as a program using gonbui would.
+func writeMessages(t *testing.T, pipe io.Writer, texts ...string) {
+	encoder := gob.NewEncoder(pipe)
+	for _, content := range texts {
+		require.NoError(t, encoder.Encode(text(content, protocol.Version)))
+	}
+}
+
+func TestDrainPipeReader(t *testing.T) {
+	previousTimeout := DrainTimeout
+	DrainTimeout = 200 * time.Millisecond
+	defer func() { DrainTimeout = previousTimeout }()
+
+	// Messages written just before the program exits are still dispatched.
+	exec, msg := pipeReaderExecutor(t)
+	pipe, err := os.OpenFile(exec.namedPipeReaderPath, os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	var texts []string
+	for ii := 0; ii < 100; ii++ {
+		texts = append(texts, fmt.Sprintf("message #%d", ii))
+	}
+	writeMessages(t, pipe, texts...)
+	require.NoError(t, pipe.Close()) // Program exits.
+	exec.drainPipeReader()
+	displayed, _ := displayedTexts(msg)
+	assert.Equal(t, texts, displayed)
+
+	// Program that never opened the pipe: the reader is unblocked, without waiting for DrainTimeout.
+	exec, msg = pipeReaderExecutor(t)
+	start := time.Now()
+	exec.drainPipeReader()
+	assert.Less(t, time.Since(start), DrainTimeout)
+	assert.Empty(t, msg.Published("display_data"))
+
+	// The pipe is kept open (e.g.: by a sub-process of the program): it gives up after DrainTimeout.
+	exec, msg = pipeReaderExecutor(t)
+	pipe, err = os.OpenFile(exec.namedPipeReaderPath, os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	defer func() { _ = pipe.Close() }()
+	writeMessages(t, pipe, "still open")
+	start = time.Now()
+	exec.drainPipeReader()
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, DrainTimeout)
+	assert.Less(t, elapsed, DrainTimeout+5*time.Second)
+	displayed, _ = displayedTexts(msg)
+	assert.Equal(t, []string{"still open"}, displayed)
+}
@@ -0,0 +1,200 @@
+package jpyexec
+
+import (
+	"bytes"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"io"
+	"k8s.io/klog/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// This file implements the ordering of the display data relative to the stdout of the program: they travel
+// on different channels (the named pipe and the stdout pipe), so without it a plot could be displayed
+// before the lines printed just before it, or after the ones printed after it.
+//
+// When GONB_ORDERED_OUTPUT_ENV is set, `gonbui` tags each display data with a sequence number
+// (protocol.DisplayData.Seq), and writes a marker with the same number to stdout (protocol.StdoutMarker) just
+// before sending it. The kernel holds the display data until the stdout before the marker is published, and
+// holds the stdout after the marker until the display data is published.
+//
+// If the program doesn't write the markers to the stdout read by the kernel (e.g.: it redirected `os.Stdout`),
+// the waiting times out after OrderingTimeout, and the ordering is disabled for the rest of the execution.
+
+// OrderingTimeout is the maximum time to wait for stdout or display data to keep them in order.
+var OrderingTimeout = 2 * time.Second
+
+// maxStdoutMarkerLen is the maximum length of a protocol.StdoutMarker: anything longer is not a marker.
+const maxStdoutMarkerLen = len(protocol.StdoutMarkerPrefix) + 20 + len(protocol.StdoutMarkerSuffix)
+
+// outputOrdering is shared by the stdout writer (see markerWriter) and the named pipe reader, to keep
+// the order of their output.
+type outputOrdering struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// stdoutSeq is the last marker read from stdout, handledSeq the last display data published.
+	stdoutSeq, handledSeq int64
+
+	// disabled after a timeout, or after stdout is closed.
+	disabled bool
+}
+
+func newOutputOrdering() *outputOrdering {
+	o := &outputOrdering{}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// waitLocked waits until done returns true, the ordering is disabled or OrderingTimeout elapses -- in which
+// case the ordering is disabled. It must be called with o.mu locked.
+func (o *outputOrdering) waitLocked(what string, done func() bool) {
+	if done() || o.disabled {
+		return
+	}
+	timedOut := false
+	timer := time.AfterFunc(OrderingTimeout, func() {
+		o.mu.Lock()
+		timedOut = true
+		o.cond.Broadcast()
+		o.mu.Unlock()
+	})
+	defer timer.Stop()
+	for !done() && !o.disabled && !timedOut {
+		o.cond.Wait()
+	}
+	if timedOut && !done() {
+		klog.Warningf("jpyexec: timed out waiting for %s, display data and stdout may be out of order", what)
+		o.disabled = true
+		o.cond.Broadcast()
+	}
+}
+
+// waitStdout is called by the named pipe reader before publishing the display data with the given sequence
+// number: it waits until the stdout printed before it is published.
+func (o *outputOrdering) waitStdout(seq int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.waitLocked("stdout marker", func() bool { return o.stdoutSeq >= seq })
+}
+
+// handled is called by the named pipe reader after publishing the display data with the given sequence number.
+func (o *outputOrdering) handled(seq int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.handledSeq = max(o.handledSeq, seq)
+	o.cond.Broadcast()
+}
+
+// stdoutMarker is called by the stdout writer when the marker with the given sequence number is read, after
+// publishing the stdout before it. It waits until the corresponding display data is published.
+func (o *outputOrdering) stdoutMarker(seq int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stdoutSeq = max(o.stdoutSeq, seq)
+	o.cond.Broadcast()
+	o.waitLocked("display data", func() bool { return o.handledSeq >= seq })
+}
+
+// stdoutClosed disables the ordering: no more markers will be read.
+func (o *outputOrdering) stdoutClosed() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.disabled = true
+	o.cond.Broadcast()
+}
+
+// markerWriter removes the protocol.StdoutMarker from the stdout of the program, and synchronizes with the
+// display data using outputOrdering. A marker split between writes is kept until it is complete.
+type markerWriter struct {
+	w        io.Writer
+	ordering *outputOrdering
+	pending  []byte
+}
+
+// Write implements io.Writer.
+func (m *markerWriter) Write(p []byte) (int, error) {
+	src := p
+	if len(m.pending) > 0 {
+		src = append(m.pending, p...)
+		m.pending = nil
+	}
+	prefix := []byte(protocol.StdoutMarkerPrefix)
+	for len(src) > 0 {
+		idx := bytes.Index(src, prefix)
+		if idx < 0 {
+			// Keep a possible beginning of a marker at the end.
+			keep := partialPrefixLen(src, prefix)
+			if err := m.writeText(src[:len(src)-keep]); err != nil {
+				return 0, err
+			}
+			if keep > 0 {
+				m.pending = bytes.Clone(src[len(src)-keep:])
+			}
+			break
+		}
+		if err := m.writeText(src[:idx]); err != nil {
+			return 0, err
+		}
+		src = src[idx:]
+		end := bytes.Index(src, []byte(protocol.StdoutMarkerSuffix))
+		if end < 0 && len(src) < maxStdoutMarkerLen {
+			// Incomplete marker.
+			m.pending = bytes.Clone(src)
+			break
+		}
+		var seq int64
+		var err error
+		if end >= 0 {
+			seq, err = strconv.ParseInt(string(src[len(prefix):end]), 10, 64)
+		}
+		if end < 0 || err != nil {
+			// Not a marker after all: pass the prefix as is.
+			if err := m.writeText(prefix); err != nil {
+				return 0, err
+			}
+			src = src[len(prefix):]
+			continue
+		}
+		m.ordering.stdoutMarker(seq)
+		src = src[end+len(protocol.StdoutMarkerSuffix):]
+	}
+	return len(p), nil
+}
+
+// writeText writes the stdout to the underlying writer.
+func (m *markerWriter) writeText(text []byte) error {
+	if len(text) == 0 {
+		return nil
+	}
+	_, err := m.w.Write(text)
+	return err
+}
+
+// Flush implements Flusher: it writes any incomplete marker as text, flushes the underlying writer (if it is a
+// Flusher), and disables the ordering, since no more markers will come.
+func (m *markerWriter) Flush() error {
+	defer m.ordering.stdoutClosed()
+	if len(m.pending) > 0 {
+		pending := m.pending
+		m.pending = nil
+		if err := m.writeText(pending); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := m.w.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// partialPrefixLen returns the length of the longest suffix of data that is a (proper) prefix of prefix.
+func partialPrefixLen(data, prefix []byte) int {
+	for n := min(len(data), len(prefix)-1); n > 0; n-- {
+		if bytes.HasSuffix(data, prefix[:n]) {
+			return n
+		}
+	}
+	return 0
+}
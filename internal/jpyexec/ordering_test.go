@@ -0,0 +1,87 @@
+package jpyexec
+
+import (
+	"bytes"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingWriter records the stdout written, and the output events (stdout and display data) in order.
+type recordingWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	events []string
+}
+
+func (r *recordingWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf.Write(p)
+	r.events = append(r.events, "stdout:"+string(p))
+	return len(p), nil
+}
+
+func (r *recordingWriter) display(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, "display:"+name)
+}
+
+func TestMarkerWriter(t *testing.T) {
+	content := "before" + protocol.StdoutMarker(1) + "middle" + protocol.StdoutMarker(2) +
+		"\x1b]gonb;seq=x\a" + "\x1b]gonb; not a marker" + "after"
+	want := "beforemiddle\x1b]gonb;seq=x\a\x1b]gonb; not a marker" + "after"
+	// Write it in chunks of every size, so markers are split between writes.
+	for chunkSize := 1; chunkSize <= len(content); chunkSize++ {
+		ordering := newOutputOrdering()
+		ordering.handled(2) // Display data already published, so the writer doesn't wait.
+		rec := &recordingWriter{}
+		w := &markerWriter{w: rec, ordering: ordering}
+		for start := 0; start < len(content); start += chunkSize {
+			n, err := w.Write([]byte(content[start:min(start+chunkSize, len(content))]))
+			require.NoError(t, err)
+			require.Equal(t, min(chunkSize, len(content)-start), n)
+		}
+		require.NoError(t, w.Flush())
+		require.Equalf(t, want, rec.buf.String(), "chunkSize=%d", chunkSize)
+		assert.Equal(t, int64(2), ordering.stdoutSeq)
+	}
+}
+
+func TestOutputOrdering(t *testing.T) {
+	ordering := newOutputOrdering()
+	rec := &recordingWriter{}
+	w := &markerWriter{w: rec, ordering: ordering}
+
+	// The display data arrives before the stdout printed before it: it must wait.
+	displayed := make(chan struct{})
+	go func() {
+		ordering.waitStdout(1)
+		rec.display("plot")
+		ordering.handled(1)
+		close(displayed)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, err := w.Write([]byte("first\n" + protocol.StdoutMarker(1) + "second\n"))
+	require.NoError(t, err)
+	<-displayed
+	assert.False(t, ordering.disabled)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, []string{"stdout:first\n", "display:plot", "stdout:second\n"}, rec.events)
+}
+
+// TestOutputOrderingTimeout checks that if the markers are missing, the display data is not held for long.
+func TestOutputOrderingTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { OrderingTimeout = timeout }(OrderingTimeout)
+	OrderingTimeout = 10 * time.Millisecond
+	ordering := newOutputOrdering()
+	ordering.waitStdout(1)
+	assert.True(t, ordering.disabled)
+	start := time.Now()
+	ordering.waitStdout(2)
+	assert.Less(t, time.Since(start), OrderingTimeout)
+}
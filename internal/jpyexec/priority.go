@@ -0,0 +1,126 @@
+package jpyexec
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+)
+
+// Priority of the CPU and I/O scheduling of the executed program, so heavy computations don't starve other
+// processes (e.g.: the Jupyter server, or other users on shared machines). The zero value leaves it unchanged.
+//
+// It is applied by executing the program through the standard tools `nice`, `ionice` and `chrt` (the last two
+// are only available in Linux), so it is inherited by all the threads and sub-processes of the program.
+type Priority struct {
+	// Nice value, from 0 to 19 (the lowest priority). 0, the default, leaves it unchanged -- same as "off" in Set.
+	Nice int `json:"nice,omitempty"`
+
+	// IOClass is the I/O scheduling class (see `ionice`): "idle" or "best-effort". Empty leaves it unchanged.
+	IOClass string `json:"io_class,omitempty"`
+
+	// IOLevel of the "best-effort" IOClass, from 0 to 7 (the lowest priority).
+	IOLevel int `json:"io_level,omitempty"`
+
+	// Batch sets the SCHED_BATCH CPU scheduling policy (see `chrt`), for non-interactive CPU intensive programs.
+	Batch bool `json:"batch,omitempty"`
+}
+
+// IsZero returns whether the priority is left unchanged.
+func (p Priority) IsZero() bool {
+	return p == Priority{}
+}
+
+// String returns the priority in the format accepted by Set, e.g.: "nice=10 ionice=idle batch=on".
+func (p Priority) String() string {
+	if p.IsZero() {
+		return "default"
+	}
+	var parts []string
+	if p.Nice != 0 {
+		parts = append(parts, fmt.Sprintf("nice=%d", p.Nice))
+	}
+	switch p.IOClass {
+	case "idle":
+		parts = append(parts, "ionice=idle")
+	case "best-effort":
+		parts = append(parts, fmt.Sprintf("ionice=%d", p.IOLevel))
+	}
+	if p.Batch {
+		parts = append(parts, "batch=on")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Set one of the fields of the priority, given by key:
+//
+//   - "nice": a value from 0 to 19, or "off" (same as 0).
+//   - "ionice": "idle", a "best-effort" level from 0 to 7, or "off".
+//   - "batch": "on" or "off".
+func (p *Priority) Set(key, value string) error {
+	switch key {
+	case "nice":
+		if value == "off" {
+			p.Nice = 0
+			return nil
+		}
+		nice, err := strconv.Atoi(value)
+		if err != nil || nice < 0 || nice > 19 {
+			return errors.Errorf("invalid nice value %q: it must be a number from 0 to 19, or \"off\"", value)
+		}
+		p.Nice = nice
+	case "ionice":
+		switch value {
+		case "off":
+			p.IOClass, p.IOLevel = "", 0
+		case "idle":
+			p.IOClass, p.IOLevel = "idle", 0
+		default:
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 0 || level > 7 {
+				return errors.Errorf("invalid ionice value %q: it must be \"idle\", a level from 0 to 7, or \"off\"", value)
+			}
+			p.IOClass, p.IOLevel = "best-effort", level
+		}
+	case "batch":
+		if value != "on" && value != "off" {
+			return errors.Errorf("invalid batch value %q: it must be \"on\" or \"off\"", value)
+		}
+		p.Batch = value == "on"
+	default:
+		return errors.Errorf("unknown priority setting %q", key)
+	}
+	return nil
+}
+
+// Command returns the command and arguments that execute the program with the priority.
+// It returns an error if one of the tools required is not installed.
+func (p Priority) Command(command string, args []string) (string, []string, error) {
+	var wrapper []string
+	if p.Nice != 0 {
+		wrapper = append(wrapper, "nice", "-n", strconv.Itoa(p.Nice))
+	}
+	switch p.IOClass {
+	case "idle":
+		wrapper = append(wrapper, "ionice", "-c", "3")
+	case "best-effort":
+		wrapper = append(wrapper, "ionice", "-c", "2", "-n", strconv.Itoa(p.IOLevel))
+	}
+	if p.Batch {
+		wrapper = append(wrapper, "chrt", "--batch", "0")
+	}
+	if len(wrapper) == 0 {
+		return command, args, nil
+	}
+	for _, tool := range []string{"nice", "ionice", "chrt"} {
+		if !slices.Contains(wrapper, tool) {
+			continue
+		}
+		if _, err := osexec.LookPath(tool); err != nil {
+			return "", nil, errors.Wrapf(err, "program %q is required to set the priority %q", tool, p)
+		}
+	}
+	return wrapper[0], append(append(wrapper[1:], command), args...), nil
+}
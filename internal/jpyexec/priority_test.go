@@ -0,0 +1,94 @@
+package jpyexec
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestPrioritySet(t *testing.T) {
+	var p Priority
+	assert.True(t, p.IsZero())
+	assert.Equal(t, "default", p.String())
+	for _, tc := range []struct {
+		key, value string
+		want       Priority
+	}{
+		{"nice", "10", Priority{Nice: 10}},
+		{"nice", "19", Priority{Nice: 19}},
+		{"ionice", "idle", Priority{Nice: 19, IOClass: "idle"}},
+		{"ionice", "4", Priority{Nice: 19, IOClass: "best-effort", IOLevel: 4}},
+		{"batch", "on", Priority{Nice: 19, IOClass: "best-effort", IOLevel: 4, Batch: true}},
+		{"nice", "off", Priority{IOClass: "best-effort", IOLevel: 4, Batch: true}},
+		{"ionice", "off", Priority{Batch: true}},
+		{"batch", "off", Priority{}},
+		{"nice", "0", Priority{}},
+	} {
+		require.NoErrorf(t, p.Set(tc.key, tc.value), "Set(%q, %q)", tc.key, tc.value)
+		assert.Equalf(t, tc.want, p, "Set(%q, %q)", tc.key, tc.value)
+	}
+
+	p = Priority{Nice: 5}
+	for _, tc := range []struct{ key, value string }{
+		{"nice", "-1"}, {"nice", "20"}, {"nice", "low"},
+		{"ionice", "8"}, {"ionice", "realtime"},
+		{"batch", "yes"},
+		{"unknown", "on"},
+	} {
+		assert.Errorf(t, p.Set(tc.key, tc.value), "Set(%q, %q)", tc.key, tc.value)
+	}
+	assert.Equal(t, Priority{Nice: 5}, p, "invalid values should leave the priority unchanged")
+
+	assert.Equal(t, "nice=10 ionice=idle batch=on", Priority{Nice: 10, IOClass: "idle", Batch: true}.String())
+	assert.Equal(t, "ionice=0", Priority{IOClass: "best-effort"}.String())
+}
+
+// fakeTools creates empty executables with the given names in a temporary directory, and makes it the PATH.
+func fakeTools(t *testing.T, names ...string) {
+	dir := t.TempDir()
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(path.Join(dir, name), []byte("#!/bin/sh\n"), 0755))
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestPriorityCommand(t *testing.T) {
+	fakeTools(t, "nice", "ionice", "chrt")
+	args := []string{"-a", "b"}
+
+	// Default: the program is executed directly.
+	command, gotArgs, err := Priority{}.Command("/tmp/prog", args)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/prog", command)
+	assert.Equal(t, args, gotArgs)
+
+	// Wrappers are applied in the order nice, ionice, chrt.
+	for _, tc := range []struct {
+		priority Priority
+		want     []string
+	}{
+		{Priority{Nice: 10}, []string{"nice", "-n", "10", "/tmp/prog", "-a", "b"}},
+		{Priority{IOClass: "idle"}, []string{"ionice", "-c", "3", "/tmp/prog", "-a", "b"}},
+		{Priority{Batch: true}, []string{"chrt", "--batch", "0", "/tmp/prog", "-a", "b"}},
+		{Priority{Nice: 19, IOClass: "best-effort", IOLevel: 7, Batch: true},
+			[]string{"nice", "-n", "19", "ionice", "-c", "2", "-n", "7", "chrt", "--batch", "0", "/tmp/prog", "-a", "b"}},
+	} {
+		command, gotArgs, err = tc.priority.Command("/tmp/prog", args)
+		require.NoErrorf(t, err, "priority %q", tc.priority)
+		assert.Equalf(t, tc.want, append([]string{command}, gotArgs...), "priority %q", tc.priority)
+	}
+	assert.Equal(t, []string{"-a", "b"}, args, "arguments should not be modified")
+
+	// Missing tools are reported, only if they are used.
+	fakeTools(t, "nice")
+	_, _, err = Priority{Nice: 10}.Command("/tmp/prog", args)
+	require.NoError(t, err)
+	_, _, err = Priority{Nice: 10, IOClass: "idle"}.Command("/tmp/prog", args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `program "ionice" is required`)
+	_, _, err = Priority{Batch: true}.Command("/tmp/prog", args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `program "chrt" is required`)
+}
@@ -0,0 +1,170 @@
+package jpyexec
+
+import (
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+// This file implements the pump that copies the stdout and stderr of the program to their writers.
+//
+// Writing to Jupyter is expensive per write (each write is a message sent to the front-end), and for programs that
+// stream lots of output it was the bottleneck: `io.Copy` reads the pipe at most 32Kb at a time, and doesn't read
+// while it is writing. Instead, the pump reads the pipe in one goroutine and accumulates it in a pending buffer,
+// while the previous chunk is being written: so all the output produced while a write is happening is sent in
+// the next single write.
+//
+// The pending buffer is limited to PumpMaxPending bytes: when it is full the reader stops reading, the pipe fills
+// up and the program blocks on its writes, until the writer catches up (back-pressure). So the memory used is
+// bounded, no matter how much output the program produces.
+//
+// When the writer is a file (or a pipe) -- see FileWriter -- the output is moved from one file descriptor to the
+// other by the OS, without copying it to user space (with splice(2), in Linux).
+
+var (
+	// PumpReadSize is the size of the reads from the program output pipes.
+	PumpReadSize = 256 * 1024
+
+	// PumpMaxPending is the maximum number of bytes read from a program output pipe waiting to be written.
+	// It is also the maximum size of the writes.
+	PumpMaxPending = 1024 * 1024
+)
+
+// FileWriter is implemented by the stdout and stderr `io.Writer` (see WithStdout and WithStderr) backed by a file
+// or a pipe, allowing the program output to be moved directly to it by the OS, when supported.
+type FileWriter interface {
+	io.Writer
+
+	// File returns the file (or pipe) written to.
+	File() *os.File
+}
+
+// isFileWriter returns whether w is a file, or a FileWriter.
+func isFileWriter(w io.Writer) bool {
+	switch w.(type) {
+	case *os.File, FileWriter:
+		return true
+	}
+	return false
+}
+
+// pumpStats are collected for each pump, and logged (with verbosity 2) when it finishes.
+type pumpStats struct {
+	bytes, reads, writes int64
+	spliced              bool
+	start                time.Time
+}
+
+// pump copies src to dst until src reaches EOF or an error occurs. It returns the number of bytes written
+// and the first error encountered, other than io.EOF. The writes to dst are always from the same goroutine,
+// and in order.
+func pump(name string, dst io.Writer, src io.Reader) (written int64, err error) {
+	stats := &pumpStats{start: time.Now()}
+	defer func() {
+		klog.V(2).Infof("jpyexec: %s pump copied %d bytes in %d reads and %d writes in %s (spliced=%v)",
+			name, stats.bytes, stats.reads, stats.writes, time.Since(stats.start), stats.spliced)
+	}()
+	if srcFile, ok := src.(*os.File); ok {
+		var dstFile *os.File
+		switch w := dst.(type) {
+		case *os.File:
+			dstFile = w
+		case FileWriter:
+			dstFile = w.File()
+		}
+		growPipe(srcFile)
+		if dstFile != nil {
+			var handled bool
+			handled, err = splicePump(dstFile, srcFile, stats)
+			if handled || err != nil {
+				return stats.bytes, err
+			}
+			// Splice not supported for these files: continue with the buffered pump from where it stopped.
+		}
+	}
+	err = bufferedPump(dst, src, stats)
+	return stats.bytes, err
+}
+
+// bufferedPump reads src in a separate goroutine, accumulating the output in a pending buffer (bounded by
+// PumpMaxPending) while the previous chunk is written to dst.
+func bufferedPump(dst io.Writer, src io.Reader, stats *pumpStats) error {
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		pending = make([]byte, 0, PumpMaxPending)
+		readErr error // Set when the reader finishes: io.EOF if there were no errors.
+		writing = true
+	)
+
+	go func() {
+		buf := make([]byte, min(PumpReadSize, PumpMaxPending))
+		for {
+			n, err := src.Read(buf)
+			mu.Lock()
+			if writing {
+				stats.reads++
+			}
+			if n > 0 {
+				// Back-pressure: wait for the writer to catch up.
+				for writing && len(pending)+n > PumpMaxPending {
+					cond.Wait()
+				}
+				if writing {
+					pending = append(pending, buf[:n]...)
+				}
+				// If the writer failed, the output is discarded: the pipe is still drained, so that
+				// the program doesn't block on its writes.
+			}
+			if err != nil {
+				readErr = err
+			}
+			cond.Broadcast()
+			finished := readErr != nil
+			mu.Unlock()
+			if finished {
+				return
+			}
+		}
+	}()
+
+	// Writer: swaps the pending buffer with an empty one, and writes it, while the reader fills the new one.
+	spare := make([]byte, 0, PumpMaxPending)
+	var writeErr error
+	mu.Lock()
+	defer mu.Unlock()
+	for {
+		for len(pending) == 0 && readErr == nil {
+			cond.Wait()
+		}
+		if len(pending) == 0 {
+			break
+		}
+		pending, spare = spare[:0], pending
+		cond.Broadcast()
+		mu.Unlock()
+		var n int
+		n, writeErr = dst.Write(spare)
+		mu.Lock()
+		stats.writes++
+		stats.bytes += int64(n)
+		if writeErr == nil && n < len(spare) {
+			writeErr = io.ErrShortWrite
+		}
+		if writeErr != nil {
+			break
+		}
+	}
+	if writeErr != nil {
+		writing = false
+		pending = nil
+		cond.Broadcast()
+		return writeErr
+	}
+	if readErr != io.EOF {
+		return readErr
+	}
+	return nil
+}
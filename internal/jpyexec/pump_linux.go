@@ -0,0 +1,153 @@
+//go:build linux
+
+package jpyexec
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"syscall"
+)
+
+// Constants not defined in the `syscall` package, see fcntl(2) and splice(2).
+const (
+	fcntlSetPipeSize = 1031 // F_SETPIPE_SZ
+	fcntlGetPipeSize = 1032 // F_GETPIPE_SZ
+
+	spliceMove     = 0x1 // SPLICE_F_MOVE
+	spliceNonBlock = 0x2 // SPLICE_F_NONBLOCK
+)
+
+// setPipeSize sets the capacity of the pipe with the given file descriptor, and returns the resulting capacity.
+func setPipeSize(fd uintptr, size int) (int, error) {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, fcntlSetPipeSize, uintptr(size))
+	if errno != 0 {
+		return 0, errno
+	}
+	newSize, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, fcntlGetPipeSize, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(newSize), nil
+}
+
+// growPipe increases the capacity of the pipe from which the program output is read to PumpReadSize (the Linux
+// default is 64Kb), so the program can write (and the pump read) larger chunks at a time.
+// It's a best-effort: the maximum capacity for unprivileged users is given by `/proc/sys/fs/pipe-max-size`.
+func growPipe(src *os.File) {
+	rawConn, err := src.SyscallConn()
+	if err != nil {
+		return
+	}
+	_ = rawConn.Control(func(fd uintptr) {
+		_, _ = setPipeSize(fd, PumpReadSize)
+	})
+}
+
+// splice moves up to n bytes from the file descriptor rfd to wfd, retrying if interrupted.
+func splice(rfd, wfd int, n int) (int64, error) {
+	for {
+		moved, err := syscall.Splice(rfd, nil, wfd, nil, n, spliceMove|spliceNonBlock)
+		if err != syscall.EINTR {
+			return moved, err
+		}
+	}
+}
+
+// splicePump moves src to dst with splice(2), through an intermediate pipe: so that the data is never copied
+// to user space, and it's always clear on which side the splice is waiting.
+//
+// It returns handled=false if splice is not supported for the given files, in which case the pump
+// should continue with the buffered version.
+func splicePump(dst, src *os.File, stats *pumpStats) (handled bool, err error) {
+	srcConn, err := src.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+	dstConn, err := dst.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+	var fds [2]int
+	if err = syscall.Pipe2(fds[:], syscall.O_CLOEXEC); err != nil {
+		return false, nil
+	}
+	pipeReader, pipeWriter := fds[0], fds[1]
+	defer func() {
+		_ = syscall.Close(pipeReader)
+		_ = syscall.Close(pipeWriter)
+	}()
+	chunk, err := setPipeSize(uintptr(pipeWriter), PumpMaxPending)
+	if err != nil {
+		chunk = 64 * 1024 // Linux default pipe capacity.
+	}
+
+	for {
+		// From the program output to the intermediate pipe: since the latter is empty, it only waits for src.
+		var n int64
+		var spliceErr error
+		err = srcConn.Read(func(fd uintptr) bool {
+			n, spliceErr = splice(int(fd), pipeWriter, chunk)
+			return spliceErr != syscall.EAGAIN
+		})
+		if err != nil {
+			return true, errors.Wrapf(err, "failed reading program output")
+		}
+		if spliceErr != nil {
+			if !stats.spliced {
+				return false, nil
+			}
+			return true, errors.Wrapf(spliceErr, "failed to splice program output")
+		}
+		stats.reads++
+		if n == 0 {
+			// EOF.
+			return true, nil
+		}
+
+		// From the intermediate pipe to dst: it only waits for dst.
+		for n > 0 {
+			var moved int64
+			err = dstConn.Write(func(fd uintptr) bool {
+				moved, spliceErr = splice(pipeReader, int(fd), int(n))
+				return spliceErr != syscall.EAGAIN
+			})
+			if err != nil {
+				return true, errors.Wrapf(err, "failed writing program output")
+			}
+			if spliceErr != nil {
+				if !stats.spliced {
+					// Not supported by dst (e.g.: files opened for appending): write out what
+					// is left in the intermediate pipe, and continue with the buffered pump.
+					return false, drainPipe(dst, pipeReader, int(n), stats)
+				}
+				return true, errors.Wrapf(spliceErr, "failed to splice program output")
+			}
+			n -= moved
+			stats.bytes += moved
+			stats.writes++
+			stats.spliced = true
+		}
+	}
+}
+
+// drainPipe reads n bytes from the pipe with the given file descriptor and writes them to dst.
+func drainPipe(dst *os.File, fd int, n int, stats *pumpStats) error {
+	buf := make([]byte, n)
+	for read := 0; read < n; {
+		m, err := syscall.Read(fd, buf[read:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed reading intermediate pipe of program output")
+		}
+		read += m
+	}
+	written, err := dst.Write(buf)
+	stats.bytes += int64(written)
+	stats.writes++
+	if err != nil {
+		return errors.Wrapf(err, "failed writing program output")
+	}
+	return nil
+}
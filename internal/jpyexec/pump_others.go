@@ -0,0 +1,13 @@
+//go:build !linux
+
+package jpyexec
+
+import "os"
+
+// growPipe is a no-op outside of Linux.
+func growPipe(_ *os.File) {}
+
+// splicePump is only supported in Linux: it always returns handled=false.
+func splicePump(_, _ *os.File, _ *pumpStats) (handled bool, err error) {
+	return false, nil
+}
@@ -0,0 +1,148 @@
+package jpyexec
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// startProducer writes content to a new pipe, in chunks of random sizes, and returns the reading end.
+// The error of the writes is sent to the returned channel when finished.
+func startProducer(t testing.TB, content []byte) (*os.File, chan error) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		defer func() { _ = w.Close() }()
+		for len(content) > 0 {
+			n := min(1+rand.Intn(100_000), len(content))
+			if _, err := w.Write(content[:n]); err != nil {
+				done <- err
+				return
+			}
+			content = content[n:]
+		}
+		done <- nil
+	}()
+	return r, done
+}
+
+func randomContent(size int) []byte {
+	content := make([]byte, size)
+	_, _ = rand.Read(content)
+	return content
+}
+
+func TestPump(t *testing.T) {
+	content := randomContent(5_000_000)
+
+	t.Run("buffered", func(t *testing.T) {
+		src, done := startProducer(t, content)
+		var buf bytes.Buffer
+		n, err := pump("test", &buf, src)
+		require.NoError(t, err)
+		require.NoError(t, <-done)
+		assert.Equal(t, int64(len(content)), n)
+		assert.True(t, bytes.Equal(content, buf.Bytes()))
+	})
+
+	t.Run("pipe", func(t *testing.T) {
+		src, done := startProducer(t, content)
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		received := make(chan []byte, 1)
+		go func() {
+			data, _ := io.ReadAll(r)
+			received <- data
+		}()
+		n, err := pump("test", w, src)
+		require.NoError(t, err)
+		require.NoError(t, <-done)
+		require.NoError(t, w.Close())
+		assert.Equal(t, int64(len(content)), n)
+		assert.True(t, bytes.Equal(content, <-received))
+	})
+
+	// Splice is not supported for files opened for appending: it falls back to the buffered pump.
+	t.Run("append", func(t *testing.T) {
+		src, done := startProducer(t, content)
+		filePath := path.Join(t.TempDir(), "output")
+		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		require.NoError(t, err)
+		n, err := pump("test", f, src)
+		require.NoError(t, err)
+		require.NoError(t, <-done)
+		require.NoError(t, f.Close())
+		assert.Equal(t, int64(len(content)), n)
+		data, err := os.ReadFile(filePath)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(content, data))
+	})
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// TestPumpWriteError checks that the program output is still drained after the writer fails, so it doesn't block.
+func TestPumpWriteError(t *testing.T) {
+	src, done := startProducer(t, randomContent(5_000_000))
+	_, err := pump("test", failingWriter{}, src)
+	require.ErrorContains(t, err, "write failed")
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("program output not drained after write error")
+	}
+}
+
+// messageWriter simulates the cost of sending each write as a message to Jupyter.
+type messageWriter struct{}
+
+func (messageWriter) Write(p []byte) (int, error) {
+	_ = string(p)
+	time.Sleep(50 * time.Microsecond)
+	return len(p), nil
+}
+
+func benchmarkCopy(b *testing.B, dst io.Writer, copyFn func(dst io.Writer, src io.Reader) (int64, error)) {
+	content := randomContent(64_000_000)
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for ii := 0; ii < b.N; ii++ {
+		src, done := startProducer(b, content)
+		if _, err := copyFn(dst, src); err != nil {
+			b.Fatalf("Failed to copy: %+v", err)
+		}
+		if err := <-done; err != nil {
+			b.Fatalf("Failed to write: %+v", err)
+		}
+		_ = src.Close()
+	}
+}
+
+func pumpFn(dst io.Writer, src io.Reader) (int64, error) {
+	return pump("benchmark", dst, src)
+}
+
+// BenchmarkPump compares the pump with io.Copy (used before), writing to Jupyter (simulated) and to a file.
+func BenchmarkPump(b *testing.B) {
+	b.Run("Jupyter/io.Copy", func(b *testing.B) { benchmarkCopy(b, messageWriter{}, io.Copy) })
+	b.Run("Jupyter/pump", func(b *testing.B) { benchmarkCopy(b, messageWriter{}, pumpFn) })
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	require.NoError(b, err)
+	defer func() { _ = devNull.Close() }()
+	b.Run("File/io.Copy", func(b *testing.B) { benchmarkCopy(b, devNull, io.Copy) })
+	b.Run("File/pump", func(b *testing.B) { benchmarkCopy(b, devNull, pumpFn) })
+}
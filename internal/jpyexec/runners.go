@@ -0,0 +1,239 @@
+package jpyexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/resources"
+	"github.com/pkg/errors"
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// This file implements the pool of pre-started runner processes: each runner is started with its stdin, stdout
+// and stderr pipes already connected, and waits for the path of the program to execute, which then replaces
+// the runner (with `exec(2)`), keeping its process id, pipes and so on. This saves the setup of the process from
+// the latency of executing a cell, which matters for rapid iterate-run loops.
+
+// RunnerArg is the only argument passed to the runner processes.
+//
+// A program that calls RunAsRunner at the start of its `main` acts as a runner when started with it: so the
+// kernel executable itself can be used as the runner command.
+const RunnerArg = "--gonb_jpyexec_runner"
+
+// runnerControlFd is the file descriptor from which the runner reads the runnerRequest.
+const runnerControlFd = 3
+
+// RunAsRunner executes the program requested by the RunnerPool, and never returns, if the program was started
+// as a runner (with the single argument RunnerArg). Otherwise, it returns immediately.
+//
+// It should be called at the start of `main`, before flags are parsed, by programs used as the runner command.
+func RunAsRunner() {
+	if len(os.Args) == 2 && os.Args[1] == RunnerArg {
+		runnerMain()
+	}
+}
+
+// runnerRequest is sent (JSON encoded) to a runner, with the program to execute.
+type runnerRequest struct {
+	Path string   // Absolute path of the program.
+	Argv []string // Including the program name, in Argv[0].
+	Env  []string
+	Dir  string
+}
+
+// runnerMain reads the runnerRequest from the control file descriptor and executes it. It never returns.
+//
+// If the control file descriptor is closed (the pool is closed) before a request is received, it simply exits.
+func runnerMain() {
+	control := os.NewFile(runnerControlFd, "runner_control")
+	var req runnerRequest
+	if err := json.NewDecoder(control).Decode(&req); err != nil {
+		os.Exit(0)
+	}
+	_ = control.Close()
+	if req.Dir != "" {
+		if err := os.Chdir(req.Dir); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "runner failed to change to directory %q: %v\n", req.Dir, err)
+			os.Exit(1)
+		}
+	}
+	err := syscall.Exec(req.Path, req.Argv, req.Env)
+	_, _ = fmt.Fprintf(os.Stderr, "runner failed to execute %q: %v\n", req.Path, err)
+	os.Exit(1)
+}
+
+// runner is a pre-started runner process, waiting for the program to execute.
+type runner struct {
+	cmd            *osexec.Cmd
+	stdout, stderr io.ReadCloser
+	stdin          io.WriteCloser
+	control        *os.File // Write end of the control pipe.
+	resource       *resources.Resource
+}
+
+// startRunner starts a runner process with the given command and arguments.
+func startRunner(command string, args []string) (r *runner, err error) {
+	r = &runner{cmd: osexec.Command(command, args...)}
+	newProcessGroup(r.cmd) // Kept by the program executed, since it replaces the runner.
+	if r.stdout, err = r.cmd.StdoutPipe(); err != nil {
+		return nil, errors.Wrapf(err, "failed to create pipe for stdout of runner")
+	}
+	if r.stderr, err = r.cmd.StderrPipe(); err != nil {
+		return nil, errors.Wrapf(err, "failed to create pipe for stderr of runner")
+	}
+	if r.stdin, err = r.cmd.StdinPipe(); err != nil {
+		return nil, errors.Wrapf(err, "failed to create pipe for stdin of runner")
+	}
+	controlReader, controlWriter, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create control pipe of runner")
+	}
+	defer func() { _ = controlReader.Close() }()
+	r.cmd.ExtraFiles = []*os.File{controlReader} // File descriptor 3 == runnerControlFd.
+	if err = r.cmd.Start(); err != nil {
+		_ = controlWriter.Close()
+		return nil, errors.Wrapf(err, "failed to start runner %q", command)
+	}
+	r.control = controlWriter
+	r.resource = resources.RegisterProcess("jpyexec", "runner", r.cmd.Process)
+	return r, nil
+}
+
+// run requests the runner to execute the program. The environment and directory given are used,
+// as opposed to the ones set in r.cmd. If it fails, the caller should kill the runner.
+func (r *runner) run(command string, args []string, env []string, dir string) error {
+	path := command
+	if !strings.Contains(command, "/") {
+		var err error
+		if path, err = osexec.LookPath(command); err != nil {
+			return errors.Wrapf(err, "failed to find program %q to execute", command)
+		}
+	} else if !filepath.IsAbs(command) && dir != "" {
+		// Same as osexec.Cmd: relative paths are relative to the directory of execution.
+		path = filepath.Join(dir, command)
+	}
+	req := runnerRequest{
+		Path: path,
+		Argv: append([]string{command}, args...),
+		Env:  env,
+		Dir:  dir,
+	}
+	err := json.NewEncoder(r.control).Encode(req)
+	_ = r.control.Close()
+	if err != nil {
+		return errors.Wrapf(err, "failed to send program %q to runner", command)
+	}
+	return nil
+}
+
+// kill the runner, and wait for it to finish, so it is not left as a zombie process.
+func (r *runner) kill() {
+	_ = r.control.Close()
+	if err := r.resource.Release(); err != nil {
+		klog.Warningf("jpyexec: %+v", err)
+	}
+	go func() { _ = r.cmd.Wait() }()
+}
+
+// RunnerPool maintains a pool of pre-started runner processes, used by the Executor configured
+// with WithRunnerPool.
+//
+// Runners are taken from the pool as programs are executed, and replenished in the background.
+// If the pool is empty (e.g.: programs are executed faster than runners are started), the program
+// is simply started as usual.
+type RunnerPool struct {
+	command string
+	args    []string
+	size    int
+
+	mu       sync.Mutex
+	idle     []*runner
+	starting int
+	closed   bool
+}
+
+// NewRunnerPool creates a pool with `size` runners, started in the background.
+//
+// The runners are started with the given command and arguments, that must be a program that calls RunAsRunner:
+// the easiest is to use `os.Executable()` of such a program, with the argument RunnerArg.
+func NewRunnerPool(size int, command string, args ...string) *RunnerPool {
+	p := &RunnerPool{
+		command: command,
+		args:    args,
+		size:    size,
+	}
+	go p.fill()
+	return p
+}
+
+// Size returns the number of runners the pool maintains.
+func (p *RunnerPool) Size() int {
+	return p.size
+}
+
+// Idle returns the number of runners currently ready to be used.
+func (p *RunnerPool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// fill starts runners until the pool is full.
+func (p *RunnerPool) fill() {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle)+p.starting >= p.size {
+			p.mu.Unlock()
+			return
+		}
+		p.starting++
+		p.mu.Unlock()
+
+		r, err := startRunner(p.command, p.args)
+
+		p.mu.Lock()
+		p.starting--
+		if err != nil {
+			p.mu.Unlock()
+			klog.Errorf("jpyexec: failed to start runner, programs will be started without it: %+v", err)
+			return
+		}
+		if p.closed {
+			p.mu.Unlock()
+			r.kill()
+			return
+		}
+		p.idle = append(p.idle, r)
+		p.mu.Unlock()
+	}
+}
+
+// take a runner from the pool, and start replenishing it. It returns nil if there are no runners available.
+func (p *RunnerPool) take() *runner {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) == 0 {
+		return nil
+	}
+	r := p.idle[0]
+	p.idle = p.idle[1:]
+	go p.fill()
+	return r
+}
+
+// Close the pool, terminating the idle runners.
+func (p *RunnerPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, r := range p.idle {
+		r.kill()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,168 @@
+package jpyexec
+
+import (
+	"errors"
+	"github.com/janpfeifer/gonb/internal/resources"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// exited returns whether the runner process finished and was waited for.
+func exited(r *runner) bool {
+	return errors.Is(r.cmd.Process.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// TestMain allows the test binary to be used as the runner command.
+func TestMain(m *testing.M) {
+	RunAsRunner()
+	os.Exit(m.Run())
+}
+
+// startTestRunner starts a runner using the test binary.
+func startTestRunner(t testing.TB) *runner {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	r, err := startRunner(executable, []string{RunnerArg})
+	require.NoError(t, err)
+	return r
+}
+
+// isRegistered returns whether the resource is still in the resources.Default registry.
+func isRegistered(resource *resources.Resource) bool {
+	for _, r := range resources.List() {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunnerRoundTrip(t *testing.T) {
+	r := startTestRunner(t)
+	dir := t.TempDir()
+	err := r.run("sh", []string{"-c", "echo $$ $GONB_RUNNER_TEST; pwd; cat"},
+		[]string{"GONB_RUNNER_TEST=hello", "PATH=" + os.Getenv("PATH")}, dir)
+	require.NoError(t, err)
+	_, err = r.stdin.Write([]byte("from stdin\n"))
+	require.NoError(t, err)
+	require.NoError(t, r.stdin.Close())
+
+	output, err := io.ReadAll(r.stdout)
+	require.NoError(t, err)
+	require.NoError(t, r.cmd.Wait())
+	r.resource.Forget()
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	require.Len(t, lines, 3)
+	// The program replaces the runner: same process id, environment, directory and pipes requested.
+	assert.Equal(t, strconv.Itoa(r.cmd.Process.Pid)+" hello", lines[0])
+	wantDir, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	assert.Equal(t, wantDir, lines[1])
+	assert.Equal(t, "from stdin", lines[2])
+}
+
+func TestRunnerFailures(t *testing.T) {
+	// Program not found: the request is not sent, and the caller kills the runner.
+	r := startTestRunner(t)
+	err := r.run("gonb_no_such_program", nil, nil, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gonb_no_such_program")
+	require.True(t, isRegistered(r.resource))
+	r.kill()
+	assert.False(t, isRegistered(r.resource))
+	require.Eventually(t, func() bool { return exited(r) }, 10*time.Second, 10*time.Millisecond,
+		"killed runner was not waited for")
+
+	// Program fails to execute in the runner: reported in its stderr, and exits with an error.
+	r = startTestRunner(t)
+	require.NoError(t, r.run("/", nil, nil, ""))
+	stderr, err := io.ReadAll(r.stderr)
+	require.NoError(t, err)
+	assert.Contains(t, string(stderr), "runner failed to execute")
+	err = r.cmd.Wait()
+	r.resource.Forget()
+	var exitErr *osexec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 1, exitErr.ExitCode())
+}
+
+func TestRunnerPoolClose(t *testing.T) {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	pool := NewRunnerPool(2, executable, RunnerArg)
+	require.Eventually(t, func() bool { return pool.Idle() == 2 }, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, pool.Size())
+
+	r := pool.take()
+	require.NotNil(t, r)
+	require.Eventually(t, func() bool { return pool.Idle() == 2 }, 10*time.Second, 10*time.Millisecond,
+		"pool was not replenished")
+	r.kill()
+
+	pool.mu.Lock()
+	idle := append([]*runner(nil), pool.idle...)
+	pool.mu.Unlock()
+	pool.Close()
+	assert.Equal(t, 0, pool.Idle())
+	assert.Nil(t, pool.take())
+	for _, r := range append(idle, r) {
+		assert.False(t, isRegistered(r.resource))
+		require.Eventually(t, func() bool { return exited(r) }, 10*time.Second, 10*time.Millisecond,
+			"runner not terminated when the pool was closed")
+	}
+}
+
+// BenchmarkRunnerPool compares the latency of executing a trivial program with a pre-started runner,
+// against starting it as usual.
+//
+// Notice the runner is a Go program, and replacing it (`exec(2)`) requires stopping its threads first:
+// for trivial programs it can be slower than starting them as usual.
+func BenchmarkRunnerPool(b *testing.B) {
+	truePath, err := osexec.LookPath("true")
+	if err != nil {
+		b.Skip("`true` not found")
+	}
+	b.Run("plain", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cmd := osexec.Command(truePath)
+			newProcessGroup(cmd)
+			stdout, err := cmd.StdoutPipe()
+			require.NoError(b, err)
+			require.NoError(b, cmd.Start())
+			_, _ = io.Copy(io.Discard, stdout)
+			require.NoError(b, cmd.Wait())
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		executable, err := os.Executable()
+		require.NoError(b, err)
+		pool := NewRunnerPool(1, executable, RunnerArg)
+		defer pool.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// As in a notebook, the runner is replenished in between executions: it is not measured.
+			b.StopTimer()
+			var r *runner
+			for r == nil {
+				time.Sleep(time.Millisecond)
+				r = pool.take()
+			}
+			time.Sleep(10 * time.Millisecond) // Let the runner finish its initialization.
+			b.StartTimer()
+			require.NoError(b, r.run(truePath, nil, nil, ""))
+			_, _ = io.Copy(io.Discard, r.stdout)
+			require.NoError(b, r.cmd.Wait())
+			r.resource.Forget()
+		}
+	})
+}
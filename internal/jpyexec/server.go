@@ -0,0 +1,75 @@
+package jpyexec
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/jobs"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"html"
+	"strings"
+)
+
+// This file implements the servers announced by the program with `gonbui.AnnounceServer`: they are tracked as
+// jobs (see package jobs and `%jobs`) until the program exits, and a link to them is displayed in the cell output.
+//
+// The link goes through the Jupyter server (with jupyter-server-proxy, in `<base_url>/proxy/<port>/`), so it
+// also works when the notebook is accessed remotely. A direct link to localhost is also offered.
+
+// dispatchServerAnnouncement registers the server announced by the program, and displays a link to it.
+func (exec *Executor) dispatchServerAnnouncement(announcement *protocol.ServerAnnouncement) {
+	if announcement.Port <= 0 || announcement.Port > 65535 {
+		exec.reportCellError(errors.Errorf("gonbui.AnnounceServer: invalid port %d", announcement.Port))
+		return
+	}
+	path := announcement.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	job := jobs.RegisterServer(announcement.Port, path, exec.executionCount)
+	exec.muDone.Lock()
+	exec.jobs = append(exec.jobs, job)
+	exec.muDone.Unlock()
+
+	if err := kernel.PublishHtml(exec.Msg, serverLinkHtml("gonb_server_"+common.UniqueId(), job)); err != nil {
+		exec.reportCellError(errors.WithMessagef(err, "failed to display the link to the server announced by the program"))
+	}
+}
+
+// finishJobs marks the jobs started by the program as finished, once it exited.
+func (exec *Executor) finishJobs() {
+	exec.muDone.Lock()
+	started := exec.jobs
+	exec.jobs = nil
+	exec.muDone.Unlock()
+	for _, job := range started {
+		job.Finish()
+	}
+}
+
+// serverLinkHtml returns the HTML (with the Javascript that builds the proxied link) of the link to the server.
+func serverLinkHtml(htmlId string, job *jobs.Job) string {
+	url := html.EscapeString(job.Url())
+	pathJson, _ := json.Marshal(job.Path) // Encoding a string never fails.
+	return fmt.Sprintf(`<div id="%[1]s" class="gonb-server">
+Server #%[2]d listening on port %[3]d: <a class="gonb-server-proxied" target="_blank">open %[4]s</a>
+(<a href="%[5]s" target="_blank">direct link</a>)
+</div>
+<script>(() => {
+    // Base URL of the Jupyter server, as in the file browser of gonbui.RequestFilePath.
+    let base = document.body?.dataset?.baseUrl || "/";
+    const config = document.getElementById("jupyter-config-data");
+    if (config) {
+        try {
+            base = JSON.parse(config.textContent).baseUrl || base;
+        } catch (err) {}
+    }
+    if (!base.endsWith("/")) {
+        base += "/";
+    }
+    const link = document.querySelector("#%[1]s .gonb-server-proxied");
+    link.href = new URL(base + "proxy/%[3]d" + %[6]s, window.location.href).href;
+})();</script>`, htmlId, job.Id, job.Port, html.EscapeString(job.Path), url, pathJson)
+}
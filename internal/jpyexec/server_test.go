@@ -0,0 +1,16 @@
+package jpyexec
+
+import (
+	"github.com/janpfeifer/gonb/internal/jobs"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestServerLinkHtml(t *testing.T) {
+	job := jobs.NewRegistry().RegisterServer(8080, `/a"b`, 3)
+	got := serverLinkHtml("gonb_server_test", job)
+	assert.Contains(t, got, `<div id="gonb_server_test"`)
+	assert.Contains(t, got, "Server #1 listening on port 8080")
+	assert.Contains(t, got, `href="http://localhost:8080/a&#34;b"`)
+	assert.Contains(t, got, `base + "proxy/8080" + "/a\"b"`)
+}
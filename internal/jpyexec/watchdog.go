@@ -0,0 +1,218 @@
+package jpyexec
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"io"
+	"k8s.io/klog/v2"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// This file implements the execution watchdog: if the program runs for longer than a threshold without producing
+// any output (see WithWatchdog), a transient notice is displayed in the cell output -- with the time elapsed, the
+// time since the last output and an interrupt button, wired over comms -- so the user can tell a long computation
+// from a program that hung. The notice is removed when the program outputs something again, or finishes.
+
+var (
+	// WatchdogThreshold is the default time without output after which the watchdog notice is displayed.
+	WatchdogThreshold = 2 * time.Minute
+
+	// WatchdogUpdateInterval is how often the watchdog checks the output, and updates its notice.
+	WatchdogUpdateInterval = 10 * time.Second
+)
+
+// InterruptAddress is messaged by the front-end (with any value), by the interrupt button of the watchdog notice,
+// to interrupt the execution. It is handled by the kernel.
+const InterruptAddress = "#gonb/interrupt"
+
+// WithWatchdog enables the watchdog notice (see description at the top of the file), displayed when the program
+// doesn't output anything for threshold. If 0, WatchdogThreshold is used; if negative, it is disabled.
+func (exec *Executor) WithWatchdog(threshold time.Duration) *Executor {
+	if threshold == 0 {
+		threshold = WatchdogThreshold
+	}
+	exec.watchdogThreshold = threshold
+	return exec
+}
+
+// watchdog holds the state of the watchdog of one execution.
+type watchdog struct {
+	start time.Time
+
+	// lastOutput is the time (in Unix nanoseconds) of the last output of the program, or 0 if none yet.
+	lastOutput atomic.Int64
+
+	// htmlId is the display id of the notice, and shown is whether it is currently displayed.
+	htmlId string
+	shown  bool
+
+	// stop is closed to stop the watchdog, and finished is closed by its goroutine when it is done.
+	stop, finished chan struct{}
+}
+
+// touch records output from the program.
+func (w *watchdog) touch() {
+	if w != nil {
+		w.lastOutput.Store(time.Now().UnixNano())
+	}
+}
+
+// watchdogWriter records the output written in the watchdog.
+type watchdogWriter struct {
+	w        io.Writer
+	watchdog *watchdog
+}
+
+// Write implements io.Writer.
+func (ww *watchdogWriter) Write(p []byte) (int, error) {
+	ww.watchdog.touch()
+	return ww.w.Write(p)
+}
+
+// watchOutput returns w wrapped to record its output in the watchdog, if it is enabled. Writers that are files
+// (see FileWriter) are not wrapped, so their output can still be moved directly by the OS: their output is not
+// displayed in the notebook anyway.
+func (exec *Executor) watchOutput(w io.Writer) io.Writer {
+	if exec.watchdog == nil || isFileWriter(w) {
+		return w
+	}
+	return &watchdogWriter{w: w, watchdog: exec.watchdog}
+}
+
+// startWatchdog starts the goroutine of the watchdog, if enabled, that runs until stopWatchdog is called.
+func (exec *Executor) startWatchdog() {
+	w := exec.watchdog
+	if w == nil {
+		return
+	}
+	interval := min(WatchdogUpdateInterval, exec.watchdogThreshold)
+	go func() {
+		defer close(w.finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				exec.updateWatchdog(true)
+				return
+			case <-ticker.C:
+				exec.updateWatchdog(false)
+			}
+		}
+	}()
+}
+
+// stopWatchdog stops the goroutine of the watchdog, if it was started, and waits for its notice to be removed.
+func (exec *Executor) stopWatchdog() {
+	w := exec.watchdog
+	if w == nil {
+		return
+	}
+	close(w.stop)
+	<-w.finished
+}
+
+// updateWatchdog displays, updates or removes the notice of the watchdog.
+func (exec *Executor) updateWatchdog(done bool) {
+	w := exec.watchdog
+	exec.muDone.Lock()
+	// A program waiting for the user to answer a prompt is not expected to output anything.
+	waitingInput := exec.pendingInput != nil
+	exec.muDone.Unlock()
+
+	now := time.Now()
+	last := w.start
+	if lastNano := w.lastOutput.Load(); lastNano != 0 {
+		last = time.Unix(0, lastNano)
+	}
+	if done || waitingInput || now.Sub(last) < exec.watchdogThreshold {
+		if w.shown {
+			w.shown = false
+			exec.publishWatchdogNotice("")
+		}
+		return
+	}
+
+	if !w.shown {
+		klog.V(1).Infof("jpyexec: program running for %s without output for %s", now.Sub(w.start), now.Sub(last))
+		if installer, ok := exec.commsHandler.(webSocketInstaller); ok {
+			// The interrupt button is wired over comms.
+			if err := installer.InstallWebSocket(exec.Msg); err != nil {
+				klog.Warningf("jpyexec: watchdog failed to install the websocket: %+v", err)
+			}
+		}
+	}
+	w.shown = true
+	lastOutput := "no output yet"
+	if w.lastOutput.Load() != 0 {
+		lastOutput = "last output " + formatElapsed(now.Sub(last)) + " ago"
+	}
+	exec.publishWatchdogNotice(watchdogNoticeHtml(w.htmlId, formatElapsed(now.Sub(w.start)), lastOutput))
+}
+
+// webSocketInstaller is implemented by the CommsHandler that can install the websocket in the front-end,
+// see `comms.State.InstallWebSocket`.
+type webSocketInstaller interface {
+	InstallWebSocket(msg kernel.Message) error
+}
+
+// publishWatchdogNotice displays or updates the notice of the watchdog. An empty html removes it.
+func (exec *Executor) publishWatchdogNotice(html string) {
+	data := kernel.Data{
+		Data:      kernel.MIMEMap{string(protocol.MIMETextHTML): html},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: kernel.MIMEMap{"display_id": exec.watchdog.htmlId},
+	}
+	if err := kernel.PublishUpdateDisplayData(exec.Msg, data); err != nil {
+		klog.Warningf("jpyexec: failed to publish watchdog notice: %+v", err)
+	}
+}
+
+// watchdogNoticeHtml returns the HTML (with the Javascript of the interrupt button) of the watchdog notice.
+func watchdogNoticeHtml(htmlId, elapsed, lastOutput string) string {
+	return fmt.Sprintf(`<div id="%[1]s" class="gonb-watchdog" style="font-style: italic; opacity: 0.8;">
+Still running &mdash; %[2]s elapsed, %[3]s. <button type="button">Interrupt</button>
+</div>
+<script>(() => {
+    const button = document.querySelector("#%[1]s button");
+    button.addEventListener("click", () => {
+        const gonb_comm = globalThis?.gonb_comm;
+        if (!gonb_comm) {
+            button.replaceWith("Not connected to GoNB: use the interrupt button of the notebook.");
+            return;
+        }
+        gonb_comm.send("%[4]s", true);
+        button.disabled = true;
+        button.textContent = "Interrupting...";
+    });
+})();</script>`, htmlId, elapsed, lastOutput, InterruptAddress)
+}
+
+// formatElapsed returns the duration rounded to seconds, without the trailing zero units: e.g.: "2m30s", "2m", "1h".
+func formatElapsed(d time.Duration) string {
+	s := d.Round(time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+		if strings.HasSuffix(s, "h0m") {
+			s = strings.TrimSuffix(s, "0m")
+		}
+	}
+	return s
+}
+
+// newWatchdog creates the state of the watchdog for a new execution, if it is enabled.
+func (exec *Executor) newWatchdog() {
+	exec.watchdog = nil
+	if exec.watchdogThreshold > 0 {
+		exec.watchdog = &watchdog{
+			start:    time.Now(),
+			htmlId:   "gonb_watchdog_" + common.UniqueId(),
+			stop:     make(chan struct{}),
+			finished: make(chan struct{}),
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package jpyexec
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFormatElapsed(t *testing.T) {
+	assert.Equal(t, "10s", formatElapsed(10*time.Second+200*time.Millisecond))
+	assert.Equal(t, "2m30s", formatElapsed(150*time.Second))
+	assert.Equal(t, "2m", formatElapsed(2*time.Minute))
+	assert.Equal(t, "1h", formatElapsed(time.Hour))
+	assert.Equal(t, "1h0m5s", formatElapsed(time.Hour+5*time.Second))
+}
+
+func TestWatchdogOutput(t *testing.T) {
+	exec := New(nil, "true").WithWatchdog(time.Minute)
+	exec.newWatchdog()
+	var buf bytes.Buffer
+	w := exec.watchOutput(&buf)
+	assert.Zero(t, exec.watchdog.lastOutput.Load())
+	_, _ = w.Write([]byte("hello"))
+	assert.NotZero(t, exec.watchdog.lastOutput.Load())
+	assert.Equal(t, "hello", buf.String())
+
+	// Disabled watchdog: the writer is not wrapped.
+	exec = New(nil, "true").WithWatchdog(-1)
+	exec.newWatchdog()
+	assert.Nil(t, exec.watchdog)
+	assert.Same(t, &buf, exec.watchOutput(&buf))
+
+	got := watchdogNoticeHtml("gonb_watchdog_test", "2m30s", "last output 2m ago")
+	assert.Contains(t, got, `<div id="gonb_watchdog_test"`)
+	assert.Contains(t, got, "2m30s elapsed, last output 2m ago.")
+	assert.Contains(t, got, `gonb_comm.send("#gonb/interrupt", true);`)
+}
@@ -61,19 +61,10 @@ func Install(extraArgs []string, forceDeps, forceCopy bool) error {
 	}
 
 	// Jupyter configuration directory for gonb.
-	home := os.Getenv("HOME")
-	jupyterDataDir := os.Getenv(JupyterDataDirEnv)
-	if jupyterDataDir == "" {
-		switch runtime.GOOS {
-		case "linux":
-			jupyterDataDir = path.Join(home, ".local/share/jupyter")
-		case "darwin":
-			jupyterDataDir = path.Join(home, "Library/Jupyter")
-		default:
-			return errors.Errorf("Unknown OS %q: not sure where to install GoNB kernel -- set the environment %q to force a location.", runtime.GOOS, JupyterDataDirEnv)
-		}
+	kernelDir, err := KernelSpecDir()
+	if err != nil {
+		return err
 	}
-	kernelDir := path.Join(jupyterDataDir, "/kernels/gonb")
 	if err := os.MkdirAll(kernelDir, 0755); err != nil {
 		return errors.WithMessagef(err, "failed to create configuration directory %q", kernelDir)
 	}
@@ -150,6 +141,33 @@ go install golang.org/x/tools/gopls@latest
 	return nil
 }
 
+// KernelSpecDir returns the directory where the GoNB kernel configuration (`kernel.json`) is installed.
+func KernelSpecDir() (string, error) {
+	jupyterDataDir := os.Getenv(JupyterDataDirEnv)
+	if jupyterDataDir == "" {
+		home := os.Getenv("HOME")
+		switch runtime.GOOS {
+		case "linux":
+			jupyterDataDir = path.Join(home, ".local/share/jupyter")
+		case "darwin":
+			jupyterDataDir = path.Join(home, "Library/Jupyter")
+		default:
+			return "", errors.Errorf("Unknown OS %q: not sure where to install GoNB kernel -- set the environment %q to force a location.", runtime.GOOS, JupyterDataDirEnv)
+		}
+	}
+	return path.Join(jupyterDataDir, "/kernels/gonb"), nil
+}
+
+// IsInstalled returns whether the GoNB kernel configuration is installed.
+func IsInstalled() bool {
+	kernelDir, err := KernelSpecDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path.Join(kernelDir, "kernel.json"))
+	return err == nil
+}
+
 // copyFile, by reading all to memory -- not good for large files.
 func copyFile(dst, src string) error {
 	data, err := os.ReadFile(src)
@@ -25,6 +25,7 @@ import (
 	"regexp"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -37,6 +38,12 @@ var (
 	ProtocolVersion = "5.4"
 )
 
+const (
+	// Version of GoNB, reported to the Jupyter client in `kernel_info_reply`, and to the programs executed
+	// (see `gonbui.CellInfo`).
+	Version = "0.1.0"
+)
+
 const (
 	StatusStarting = "starting"
 	StatusBusy     = "busy"
@@ -87,7 +94,8 @@ type Kernel struct {
 	// stop should be listened to after kernel creation. It is closed
 	// when the Kernel is stopped (Kernel.Stop). Don't directly close it,
 	// instead call Kernel.Stop.
-	stop chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
 
 	// Sockets connected to Jupyter client.
 	sockets *SocketGroup
@@ -113,7 +121,8 @@ type Kernel struct {
 
 	// stdinMsg holds the MessageImpl that last asked from input from stdin (MessageImpl.PromptInput).
 	stdinMsg *MessageImpl
-	stdinFn  OnInputFn // Callback when stdin input is received.
+	stdinFn  OnInputFn  // Callback when stdin input is received.
+	muStdin  sync.Mutex // Protects stdinMsg and stdinFn.
 
 	// JupyterKernelId is a unique id associated to the kernel by Jupyter.
 	// It's different from the id created by goexec.State to identify the temporary Go
@@ -125,6 +134,46 @@ type Kernel struct {
 	// KnownBlockIds are display data blocks with a "display_id" that have already been created, and
 	// hence should be updated (instead of created anew) in calls to PublishUpdate
 	KnownBlockIds common.Set[string]
+
+	// lastHeartbeat is the time (in Unix nanoseconds) the last heartbeat from Jupyter was received.
+	lastHeartbeat atomic.Int64
+}
+
+// IsStandalone returns whether the Kernel is not connected to a Jupyter client, see NewStandalone.
+func (k *Kernel) IsStandalone() bool {
+	return k.sockets == nil
+}
+
+// SocketAddresses returns the addresses of the ZMQ sockets bound to communicate with the Jupyter client,
+// indexed by the socket name. It returns nil for a standalone kernel.
+func (k *Kernel) SocketAddresses() map[string]string {
+	if k.sockets == nil {
+		return nil
+	}
+	addresses := make(map[string]string)
+	for name, sck := range map[string]*SyncSocket{
+		"shell":     &k.sockets.ShellSocket,
+		"control":   &k.sockets.ControlSocket,
+		"stdin":     &k.sockets.StdinSocket,
+		"iopub":     &k.sockets.IOPubSocket,
+		"heartbeat": &k.sockets.HBSocket,
+	} {
+		if addr := sck.Socket.Addr(); addr != nil {
+			addresses[name] = addr.String()
+		} else {
+			addresses[name] = ""
+		}
+	}
+	return addresses
+}
+
+// LastHeartbeat returns when the last heartbeat from the Jupyter client was received, or the zero time if none was.
+func (k *Kernel) LastHeartbeat() time.Time {
+	nanos := k.lastHeartbeat.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
 }
 
 // IsStopped returns whether the Kernel has been stopped.
@@ -145,10 +194,21 @@ func (k *Kernel) StoppedChan() <-chan struct{} {
 }
 
 // Stop the Kernel, indicating to all polling processes to quit.
+//
+// It is safe to call it more than once, and concurrently: only the first call has an effect.
 func (k *Kernel) Stop() {
+	k.stopOnce.Do(k.stopSockets)
+}
+
+// stopSockets implements Stop: it should be called only once.
+func (k *Kernel) stopSockets() {
 	klog.V(1).Infof("Kernel.Stop()")
 	k.Interrupted.Store(true) // Also mark as interrupted.
 	close(k.stop)
+	if k.sockets == nil {
+		// Standalone kernel, see NewStandalone.
+		return
+	}
 	err := k.sockets.ShellSocket.Socket.Close()
 	if err != nil {
 		klog.Errorf("Failed to close Shell socket: %v", err)
@@ -363,6 +423,25 @@ func New(connectionFile string) (*Kernel, error) {
 	return k, nil
 }
 
+// NewStandalone creates a Kernel that is not connected to any Jupyter client: no sockets are
+// bound and nothing is received on Kernel.Stdin, Kernel.Shell or Kernel.Control.
+//
+// It is used by front-ends other than Jupyter (e.g.: the console REPL), which create their
+// own implementation of Message, and use the Kernel only for its interruption handling,
+// execution counter and other bookkeeping. Calling MessageImpl methods that communicate
+// with Jupyter on a standalone kernel is an error.
+func NewStandalone() *Kernel {
+	return &Kernel{
+		stop:    make(chan struct{}),
+		shell:   make(chan Message, 1),
+		stdin:   make(chan Message, 1),
+		control: make(chan Message, 1),
+
+		interruptSubscriptions: list.New(),
+		KnownBlockIds:          make(common.Set[string]),
+	}
+}
+
 // pollCommonSocket polls for messages from a socket, parses them, and sends them to msgChan.
 //
 // This function runs the loop of receiving messages, parsing and verifying from the wire
@@ -421,6 +500,7 @@ func (k *Kernel) pollHeartbeat() {
 				err = errors.WithMessagef(err, "error reading heartbeat ping bytes")
 				break
 			}
+			k.lastHeartbeat.Store(time.Now().UnixNano())
 			err = k.sockets.HBSocket.RunLocked(func(echo zmq4.Socket) error {
 				if err := echo.Send(msg); err != nil {
 					return errors.WithMessagef(err, "error sending heartbeat pong %q", msg.String())
@@ -551,6 +631,10 @@ func (k *Kernel) FromWireMsg(zmqMsg zmq4.Msg) Message {
 		m.err = errors.WithMessagef(err, "while decoding ComposedMsg.Content")
 		return m
 	}
+	if len(parts) > i+6 {
+		// Binary buffers are not part of the signature.
+		m.Composed.Buffers = parts[i+6:]
+	}
 	return m
 }
 
@@ -598,5 +682,7 @@ func (k *Kernel) ToWireMsg(c *ComposedMsg) ([][]byte, error) {
 		hex.Encode(parts[0], mac.Sum(nil))
 	}
 
+	// Binary buffers follow the content, and are not signed.
+	parts = append(parts, c.Buffers...)
 	return parts, nil
 }
@@ -1,12 +1,16 @@
 package kernel
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/pkg/errors"
 	"io"
 	"k8s.io/klog/v2"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/go-zeromq/zmq4"
@@ -29,6 +33,10 @@ type ComposedMsg struct {
 	ParentHeader zmqMsgHeader
 	Metadata     map[string]any
 	Content      any
+
+	// Buffers are the optional binary buffers that follow the content in the wire protocol (e.g.: used
+	// by `comm_msg` messages to send binary data). They are only read from received messages.
+	Buffers [][]byte
 }
 
 // MIMEMap holds data that can be presented in multiple formats. The keys are MIME types
@@ -272,6 +280,35 @@ func (m *MessageImpl) Publish(msgType string, content interface{}) error {
 	})
 }
 
+// PublishWithBuffers is like Publish, but the message carries the given binary buffers, which the front-end
+// receives as they are, without the JSON conversion.
+func (m *MessageImpl) PublishWithBuffers(msgType string, content interface{}, buffers [][]byte) error {
+	msg, err := NewComposed(msgType, m.Composed)
+	if err != nil {
+		return err
+	}
+	klog.V(1).Infof("[IOPub] Publish message %q with %d buffers -- parent msg_id=%q", msgType, len(buffers), msg.ParentHeader.MsgID)
+	msg.Content = content
+	msg.Buffers = buffers
+	return m.kernel.sockets.IOPubSocket.RunLocked(func(socket zmq4.Socket) error {
+		return m.sendMessage(socket, msg)
+	})
+}
+
+// bufferPublisher is implemented by messages that can publish binary buffers, see PublishWithBuffers.
+type bufferPublisher interface {
+	PublishWithBuffers(msgType string, content interface{}, buffers [][]byte) error
+}
+
+// PublishWithBuffers publishes a message carrying the given binary buffers. Messages not connected to
+// Jupyter (e.g.: the console) don't support buffers: for those the message is published without them.
+func PublishWithBuffers(msg Message, msgType string, content any, buffers [][]byte) error {
+	if publisher, ok := msg.(bufferPublisher); ok {
+		return publisher.PublishWithBuffers(msgType, content, buffers)
+	}
+	return msg.Publish(msgType, content)
+}
+
 // OnInputFn is the callback function. It receives the original shell execute
 // message and the message with the incoming input value.
 type OnInputFn func(original, input *MessageImpl) error
@@ -302,18 +339,29 @@ func (m *MessageImpl) PromptInput(prompt string, password bool, onInput OnInputF
 	}
 
 	// Register callback.
+	m.kernel.muStdin.Lock()
 	m.kernel.stdinMsg = m
 	m.kernel.stdinFn = onInput
+	m.kernel.muStdin.Unlock()
 
 	return nil
 }
 
 // CancelInput will cancel any `input_request` message sent by PromptInput.
+//
+// Jupyter has no message to withdraw an `input_request`, so the prompt may still be displayed: but its
+// callback is dropped, and an eventual answer is ignored.
 func (m *MessageImpl) CancelInput() error {
 	klog.V(1).Infof("MessageImpl.CancelInput()")
 	// TODO: Check for any answers in the cross-posted question:
 	// https://discourse.jupyter.org/t/cancelling-input-request-at-end-of-execution/17637
 	// https://stackoverflow.com/questions/75206276/kernel-cancelling-a-input-request-at-the-end-of-the-execution-of-a-cell
+	m.kernel.muStdin.Lock()
+	defer m.kernel.muStdin.Unlock()
+	if m.kernel.stdinMsg == m {
+		m.kernel.stdinMsg = nil
+		m.kernel.stdinFn = nil
+	}
 	return nil
 }
 
@@ -322,10 +370,13 @@ func (m *MessageImpl) CancelInput() error {
 // Still the user has to handle its delivery.
 func (m *MessageImpl) DeliverInput() error {
 	klog.V(1).Infof("MessageImpl.DeliverInput()")
-	if m.kernel.stdinMsg == nil {
+	m.kernel.muStdin.Lock()
+	stdinMsg, stdinFn := m.kernel.stdinMsg, m.kernel.stdinFn
+	m.kernel.muStdin.Unlock()
+	if stdinMsg == nil {
 		return nil
 	}
-	return m.kernel.stdinFn(m.kernel.stdinMsg, m)
+	return stdinFn(stdinMsg, m)
 }
 
 // Reply creates a new ComposedMsg and sends it back to the return identities over the
@@ -523,23 +574,53 @@ func PublishWriteStream(msg Message, stream string, data string) error {
 type jupyterStreamWriter struct {
 	stream string
 	msg    Message
+
+	// incomplete holds the start of a UTF-8 encoded rune split between writes.
+	incomplete []byte
 }
 
 // NewJupyterStreamWriter returns an io.Writer that forwards what is written to the Jupyter client,
 // under the given stream name.
+//
+// The data is expected to be UTF-8 encoded: runes split between writes are kept whole, and invalid bytes
+// are replaced by the Unicode replacement character (U+FFFD). It also implements `Flush() error`, to write
+// any incomplete rune left at the end.
 func NewJupyterStreamWriter(msg Message, stream string) io.Writer {
-	return &jupyterStreamWriter{stream, msg}
+	return &jupyterStreamWriter{stream: stream, msg: msg}
 }
 
 // Write implements `io.Writer.Write` by publishing the data via `PublishWriteStream`
 func (w *jupyterStreamWriter) Write(p []byte) (n int, err error) {
-	data := string(p)
-	if err := PublishWriteStream(w.msg, w.stream, data); err != nil {
-		klog.Errorf("Failed to stream %d bytes of data to stream %q: %+v", n, w.stream, err)
+	data := p
+	if len(w.incomplete) > 0 {
+		data = append(w.incomplete, p...)
+		w.incomplete = nil
+	}
+	if suffix := common.IncompleteUTF8Suffix(data); suffix > 0 {
+		w.incomplete = bytes.Clone(data[len(data)-suffix:])
+		data = data[:len(data)-suffix]
+	}
+	if len(data) > 0 {
+		w.publish(data)
 	}
 	return len(p), nil
 }
 
+// Flush publishes the incomplete rune left from the last write, if any.
+func (w *jupyterStreamWriter) Flush() error {
+	if len(w.incomplete) > 0 {
+		w.publish(w.incomplete)
+		w.incomplete = nil
+	}
+	return nil
+}
+
+func (w *jupyterStreamWriter) publish(data []byte) {
+	if err := PublishWriteStream(w.msg, w.stream, strings.ToValidUTF8(string(data), "\uFFFD")); err != nil {
+		klog.Errorf("Failed to stream %d bytes of data to stream %q: %+v", len(data), w.stream, err)
+	}
+}
+
 // PublishKernelStatus publishes a status message notifying front-ends of the state the kernel
 // is in. It supports the states "starting", "busy", and "idle".
 func PublishKernelStatus(msg Message, status string) error {
@@ -599,6 +680,8 @@ func LogDisplayData(data MIMEMap) {
 			klog.Infof("Data[%s]=%q", key, displayValue)
 		case []byte:
 			klog.Infof("Data[%s]=...%d bytes...", key, len(value))
+		case json.RawMessage:
+			klog.Infof("Data[%s]=...%d bytes of JSON...", key, len(value))
 		default:
 			klog.Infof("Data[%s]: unknown type %t", key, value)
 		}
@@ -0,0 +1,345 @@
+// Package logs captures the logs of the kernel (the output of klog) and the structured logs of the programs
+// executed (see gonbui.LogHandler), and streams them to the log viewers opened in the notebook with `%logs`.
+//
+// The records are sent in batches (at most every FlushInterval) with comms, to the address Address, as a
+// JSON object `{"history": <bool>, "records": [...]}`: when `history` is true, the records are all the ones
+// kept (up to MaxHistory), and replace the ones the viewer had.
+package logs
+
+import (
+	"bytes"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Address in the front-end where the log records are sent.
+	Address = "#gonb/logs"
+
+	// JsAddress is where the front-end sends the Javascript errors and console warnings of GoNB's front-end code,
+	// see HandleJsRecord.
+	JsAddress = "#gonb/js_log"
+
+	// MaxHistory is the number of most recent records kept, and sent to a log viewer when it is opened.
+	MaxHistory = 1000
+
+	// FlushInterval is the maximum frequency with which records are sent to the log viewers.
+	FlushInterval = 200 * time.Millisecond
+
+	// TimeLayout is the format of the time of the records.
+	TimeLayout = "15:04:05.000"
+)
+
+// Level of a log record, with the same values as `log/slog` levels.
+type Level int
+
+const (
+	LevelDebug   Level = -4
+	LevelInfo    Level = 0
+	LevelWarning Level = 4
+	LevelError   Level = 8
+)
+
+// levelNames are the names of the levels, as used in `%logs` and by the log viewer.
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelInfo:    "info",
+	LevelWarning: "warning",
+	LevelError:   "error",
+}
+
+// String implements fmt.Stringer. Levels between the named ones are reported with the name of the level below.
+func (l Level) String() string {
+	switch {
+	case l >= LevelError:
+		return levelNames[LevelError]
+	case l >= LevelWarning:
+		return levelNames[LevelWarning]
+	case l >= LevelInfo:
+		return levelNames[LevelInfo]
+	default:
+		return levelNames[LevelDebug]
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, so levels are converted to JSON by name.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// ParseLevel returns the Level with the given name ("debug", "info", "warning" or "error").
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, errors.Errorf("unknown log level %q, valid values are \"debug\", \"info\", \"warning\" or \"error\"", name)
+}
+
+// Source of the log records.
+const (
+	SourceKernel  = "kernel"
+	SourceProgram = "program"
+	SourceJs      = "js"
+)
+
+// Sources of the log records, as accepted by `%logs`.
+var Sources = []string{SourceKernel, SourceProgram, SourceJs}
+
+// Record is one log entry, as sent to the log viewers.
+type Record struct {
+	// Time formatted with TimeLayout.
+	Time   string `json:"time"`
+	Level  Level  `json:"level"`
+	Source string `json:"source"`
+
+	// Location in the source code (`file:line`) that logged the record, if known.
+	Location string `json:"location,omitempty"`
+
+	Message string   `json:"message"`
+	Attrs   []string `json:"attrs,omitempty"`
+}
+
+// Sender sends values to the front-end. It is implemented by comms.State.
+type Sender interface {
+	Broadcast(msg kernel.Message, address string, value any) error
+}
+
+// State keeps the recent log records, and streams them to the log viewers while they are opened.
+//
+// It implements io.Writer, to receive the output of klog.
+type State struct {
+	mu sync.Mutex
+
+	// history holds the most recent records, up to MaxHistory.
+	history []Record
+
+	// pending records not yet sent to the log viewers.
+	pending        []Record
+	flushScheduled bool
+
+	// msg and sender are set while streaming, see Start.
+	msg    kernel.Message
+	sender Sender
+
+	// muSend serializes the sending of records, and sending is set while they are being sent.
+	muSend  sync.Mutex
+	sending bool
+}
+
+// New creates a State not streaming. Most users will use Default instead.
+func New() *State {
+	return &State{}
+}
+
+// Default State, that receives the output of klog (see SetUpKlog in the main package).
+var Default = New()
+
+// klogHeaderRegexp matches the header of klog lines, as in "I1014 18:12:07.807170    6308 goexec.go:244] ".
+var klogHeaderRegexp = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}\.\d+\s+\d+ ([^\]]*)\] `)
+
+// ansiEscapeRegexp matches the ANSI escape sequences used for colors (e.g.: in the kernel's unique id prefix).
+var ansiEscapeRegexp = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// jsLogPrefix is the prefix of the kernel logs of the records sent by the front-end, see HandleJsRecord.
+const jsLogPrefix = "front-end js: "
+
+var (
+	addressBytes = []byte(Address)
+	commMsgBytes = []byte(`"comm_msg"`)
+	jsLogBytes   = []byte(jsLogPrefix)
+)
+
+// Write implements io.Writer: each write is one record output by klog.
+//
+// The logging of the records being sent (by comms and the kernel) is dropped, otherwise it would
+// feed back into new records. And so is the logging of the records from the front-end, already kept
+// by HandleJsRecord.
+func (s *State) Write(p []byte) (int, error) {
+	if bytes.Contains(p, addressBytes) || bytes.Contains(p, jsLogBytes) {
+		return len(p), nil
+	}
+	record := Record{
+		Time:   time.Now().Format(TimeLayout),
+		Level:  LevelInfo,
+		Source: SourceKernel,
+	}
+	line := string(p)
+	if matches := klogHeaderRegexp.FindStringSubmatch(line); matches != nil {
+		switch matches[1] {
+		case "W":
+			record.Level = LevelWarning
+		case "E", "F":
+			record.Level = LevelError
+		}
+		record.Location = matches[2]
+		line = line[len(matches[0]):]
+	}
+	record.Message = strings.TrimRight(ansiEscapeRegexp.ReplaceAllString(line, ""), "\n")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sending && bytes.Contains(p, commMsgBytes) {
+		return len(p), nil
+	}
+	s.addLocked(record)
+	return len(p), nil
+}
+
+// AddProgramRecord adds a structured log record sent by the program. It returns whether it is being
+// streamed to the log viewers -- if not, the caller should display it somewhere else.
+func (s *State) AddProgramRecord(programRecord *protocol.LogRecord) (streaming bool) {
+	record := Record{
+		Time:    programRecord.Time.Format(TimeLayout),
+		Level:   Level(programRecord.Level),
+		Source:  SourceProgram,
+		Message: programRecord.Message,
+		Attrs:   programRecord.Attrs,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(record)
+	return s.sender != nil
+}
+
+// HandleJsRecord handles the Javascript errors and console warnings sent by the front-end to JsAddress, as an
+// object `{"level": "warning"|"error", "message": <string>, "location": <string>}`. They are logged by the kernel,
+// and kept as records of SourceJs.
+func (s *State) HandleJsRecord(value any) {
+	values, ok := value.(map[string]any)
+	if !ok {
+		klog.Warningf("logs: invalid record from the front-end, expected an object, got %T", value)
+		return
+	}
+	record := Record{
+		Time:   time.Now().Format(TimeLayout),
+		Level:  LevelError,
+		Source: SourceJs,
+	}
+	if level, _ := values["level"].(string); level == "warning" {
+		record.Level = LevelWarning
+	}
+	record.Message, _ = values["message"].(string)
+	record.Location, _ = values["location"].(string)
+	if record.Level == LevelWarning {
+		klog.Warningf("%s%s", jsLogPrefix, record.Message)
+	} else {
+		klog.Errorf("%s%s", jsLogPrefix, record.Message)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(record)
+}
+
+// FormatProgramRecord formats a log record sent by the program as a line of text (without the new line).
+func FormatProgramRecord(programRecord *protocol.LogRecord) string {
+	parts := []string{programRecord.Time.Format(TimeLayout), strings.ToUpper(Level(programRecord.Level).String()),
+		programRecord.Message}
+	return strings.Join(append(parts, programRecord.Attrs...), " ")
+}
+
+// addLocked adds the record to the history and, if streaming, schedules it to be sent.
+// It assumes `s.mu` lock is already acquired.
+//
+// Notice it must not log with klog, since it may be called from within klog.
+func (s *State) addLocked(record Record) {
+	s.history = append(s.history, record)
+	if len(s.history) > 2*MaxHistory {
+		// Trim only once in a while, to avoid copying the history at every record.
+		s.history = slices.Delete(s.history, 0, len(s.history)-MaxHistory)
+	}
+	if s.sender == nil {
+		return
+	}
+	s.pending = append(s.pending, record)
+	if !s.flushScheduled {
+		s.flushScheduled = true
+		time.AfterFunc(FlushInterval, s.flush)
+	}
+}
+
+// recentLocked returns a copy of the last MaxHistory records.
+// It assumes `s.mu` lock is already acquired.
+func (s *State) recentLocked() []Record {
+	return slices.Clone(s.history[max(0, len(s.history)-MaxHistory):])
+}
+
+// Start streaming the records to the log viewers: all the records kept so far are sent immediately, and new
+// ones as they are logged. The message `msg` is used to publish the records, even after its execution finished.
+func (s *State) Start(msg kernel.Message, sender Sender) error {
+	s.muSend.Lock()
+	defer s.muSend.Unlock()
+	s.mu.Lock()
+	s.msg, s.sender = msg, sender
+	records := s.recentLocked()
+	s.pending = nil
+	s.mu.Unlock()
+	return s.sendLocked(true, records)
+}
+
+// Stop streaming the records. Records are still kept, and sent if streaming is started again.
+func (s *State) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msg, s.sender = nil, nil
+	s.pending = nil
+}
+
+// IsStreaming returns whether records are being streamed to the log viewers.
+func (s *State) IsStreaming() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sender != nil
+}
+
+// flush sends the pending records.
+func (s *State) flush() {
+	s.muSend.Lock()
+	defer s.muSend.Unlock()
+	s.mu.Lock()
+	records := s.pending
+	s.pending = nil
+	s.flushScheduled = false
+	streaming := s.sender != nil
+	s.mu.Unlock()
+	if !streaming || len(records) == 0 {
+		return
+	}
+	if err := s.sendLocked(false, records); err != nil {
+		// Stop streaming, otherwise the logged error would be sent again, and fail again.
+		s.Stop()
+		klog.Warningf("logs: failed to send log records to the log viewer, streaming stopped: %+v", err)
+	}
+}
+
+// sendLocked sends the records to the log viewers.
+// It assumes `s.muSend` lock is already acquired, and that `s.mu` is not.
+func (s *State) sendLocked(history bool, records []Record) error {
+	s.mu.Lock()
+	msg, sender := s.msg, s.sender
+	s.sending = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.sending = false
+		s.mu.Unlock()
+	}()
+	if sender == nil {
+		return nil
+	}
+	if records == nil {
+		records = []Record{}
+	}
+	return sender.Broadcast(msg, Address, map[string]any{
+		"history": history,
+		"records": records,
+	})
+}
@@ -0,0 +1,97 @@
+package logs
+
+import (
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSender records the values broadcast.
+type fakeSender struct {
+	mu     sync.Mutex
+	values []map[string]any
+}
+
+func (f *fakeSender) Broadcast(_ kernel.Message, address string, value any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if address == Address {
+		f.values = append(f.values, value.(map[string]any))
+	}
+	return nil
+}
+
+func (f *fakeSender) Values() []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.values
+}
+
+func TestWrite(t *testing.T) {
+	s := New()
+	_, _ = s.Write([]byte("W1014 18:12:07.807170    6308 goexec.go:244] \x1b[7;39;32m[f0a750fa]\x1b[0m gopls not found\n"))
+	_, _ = s.Write([]byte("not a klog line"))
+	_, _ = s.Write([]byte("I1014 18:12:07.807170    6308 broadcast.go:108] comms: broadcast(address=\"#gonb/logs\")\n"))
+	require.Len(t, s.history, 2)
+	assert.Equal(t, LevelWarning, s.history[0].Level)
+	assert.Equal(t, "goexec.go:244", s.history[0].Location)
+	assert.Equal(t, "[f0a750fa] gopls not found", s.history[0].Message)
+	assert.Equal(t, SourceKernel, s.history[0].Source)
+	assert.Equal(t, LevelInfo, s.history[1].Level)
+	assert.Equal(t, "not a klog line", s.history[1].Message)
+}
+
+func TestStreaming(t *testing.T) {
+	s := New()
+	record := &protocol.LogRecord{Time: time.Now(), Level: -4, Message: "loading", Attrs: []string{"file=a.csv"}}
+	assert.False(t, s.AddProgramRecord(record))
+	assert.Contains(t, FormatProgramRecord(record), "DEBUG loading file=a.csv")
+
+	sender := &fakeSender{}
+	require.NoError(t, s.Start(nil, sender))
+	assert.True(t, s.IsStreaming())
+	values := sender.Values()
+	require.Len(t, values, 1)
+	assert.Equal(t, true, values[0]["history"])
+	require.Len(t, values[0]["records"], 1)
+	assert.Equal(t, SourceProgram, values[0]["records"].([]Record)[0].Source)
+
+	assert.True(t, s.AddProgramRecord(record))
+	_, _ = s.Write([]byte("E1014 18:12:07.807170    6308 main.go:1] failed\n"))
+	require.Eventually(t, func() bool { return len(sender.Values()) == 2 }, 10*time.Second, 10*time.Millisecond)
+	values = sender.Values()
+	assert.Equal(t, false, values[1]["history"])
+	records := values[1]["records"].([]Record)
+	require.Len(t, records, 2)
+	assert.Equal(t, LevelError, records[1].Level)
+
+	s.Stop()
+	assert.False(t, s.AddProgramRecord(record))
+	assert.Len(t, s.history, 4)
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("Warning")
+	require.NoError(t, err)
+	assert.Equal(t, LevelWarning, level)
+	_, err = ParseLevel("verbose")
+	require.Error(t, err)
+	assert.Equal(t, "info", Level(2).String())
+}
+
+func TestHandleJsRecord(t *testing.T) {
+	s := New()
+	s.HandleJsRecord(map[string]any{"level": "warning", "message": "gonb_comm: no one listening", "location": ""})
+	s.HandleJsRecord(map[string]any{"level": "error", "message": "TypeError: x is undefined", "location": "page:10"})
+	s.HandleJsRecord("invalid")
+	_, _ = s.Write([]byte("E1014 18:12:07.807170    6308 logs.go:1] " + jsLogPrefix + "TypeError: x is undefined\n"))
+	require.Len(t, s.history, 2)
+	assert.Equal(t, SourceJs, s.history[0].Source)
+	assert.Equal(t, LevelWarning, s.history[0].Level)
+	assert.Equal(t, LevelError, s.history[1].Level)
+	assert.Equal(t, "page:10", s.history[1].Location)
+}
@@ -0,0 +1,62 @@
+package logs
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"text/template"
+)
+
+//go:embed viewer.js
+var viewerJs []byte
+
+var tmplViewerJs = template.Must(template.New("viewerJs").Parse(string(viewerJs)))
+
+// ViewerHtml returns the HTML (with the Javascript) of a log viewer panel, with the given `htmlId`, initially
+// showing the records of the given level and above. If `source` is not empty, only the records of the
+// given source (SourceKernel, SourceProgram or SourceJs) are shown.
+//
+// The panel can be collapsed, filters the records by level, and follows (scrolls to) the new records, or pauses
+// receiving them. The records are received with comms, so the websocket must be installed in the front-end.
+func ViewerHtml(htmlId string, level Level, source string) (string, error) {
+	var options strings.Builder
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarning, LevelError} {
+		selected := ""
+		if l == level {
+			selected = " selected"
+		}
+		_, _ = fmt.Fprintf(&options, `<option value="%s"%s>%s</option>`, l, selected, l)
+	}
+
+	var js bytes.Buffer
+	data := struct {
+		HtmlId, Address, Source string
+		MaxRecords              int
+	}{
+		HtmlId:     htmlId,
+		Address:    Address,
+		Source:     source,
+		MaxRecords: MaxHistory,
+	}
+	if err := tmplViewerJs.Execute(&js, data); err != nil {
+		return "", errors.Wrapf(err, "log viewer template is invalid!?")
+	}
+	title := "GoNB logs"
+	if source != "" {
+		title = fmt.Sprintf("GoNB logs (%s)", source)
+	}
+	return fmt.Sprintf(`<details id="%s" class="gonb-logs" open>
+<summary>%s</summary>
+<div class="gonb-logs-toolbar">
+<label>Level <select>%s</select></label>
+<label><input type="checkbox" checked> Follow</label>
+<button class="gonb-logs-pause">Pause</button>
+<button class="gonb-logs-clear">Clear</button>
+<span class="gonb-logs-status"></span>
+</div>
+<div class="gonb-logs-lines" style="max-height: 20em; overflow-y: auto; font-family: monospace; font-size: small; white-space: pre-wrap;"></div>
+</details>
+<script>%s</script>`, htmlId, title, options.String(), js.String()), nil
+}
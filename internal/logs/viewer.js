@@ -0,0 +1,110 @@
+(() => {
+    const panel = document.getElementById("{{.HtmlId}}");
+    const lines = panel.querySelector(".gonb-logs-lines");
+    const status = panel.querySelector(".gonb-logs-status");
+    const levelSelect = panel.querySelector("select");
+    const follow = panel.querySelector("input[type=checkbox]");
+    const pauseButton = panel.querySelector(".gonb-logs-pause");
+    const clearButton = panel.querySelector(".gonb-logs-clear");
+
+    const gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        status.textContent = "Not connected to GoNB: re-run `%logs` to reconnect.";
+        return;
+    }
+
+    const maxRecords = {{.MaxRecords}};
+    const source = "{{.Source}}";  // If set, only records of this source are shown.
+    const levels = {debug: -4, info: 0, warning: 4, error: 8};
+    const colors = {debug: "gray", info: "inherit", warning: "#b58900", error: "#dc322f"};
+    let records = [];  // All records received, up to maxRecords.
+    let held = [];  // Records received while paused.
+    let paused = false;
+
+    function matches(record) {
+        return (!source || record.source === source) && levels[record.level] >= levels[levelSelect.value];
+    }
+
+    function render(record) {
+        const div = document.createElement("div");
+        div.style.color = colors[record.level];
+        let text = `${record.time} ${record.level.charAt(0).toUpperCase()} [${record.source}] `;
+        if (record.location) {
+            text += `${record.location}: `;
+        }
+        text += record.message;
+        if (record.attrs) {
+            text += " " + record.attrs.join(" ");
+        }
+        div.textContent = text;
+        return div;
+    }
+
+    function scroll() {
+        if (follow.checked) {
+            lines.scrollTop = lines.scrollHeight;
+        }
+    }
+
+    function rerender() {
+        lines.replaceChildren(...records.filter(matches).map(render));
+        scroll();
+    }
+
+    function append(newRecords) {
+        records.push(...newRecords);
+        if (records.length > maxRecords) {
+            records.splice(0, records.length - maxRecords);
+        }
+        for (const record of newRecords) {
+            if (matches(record)) {
+                lines.appendChild(render(record));
+            }
+        }
+        while (lines.childElementCount > maxRecords) {
+            lines.firstChild.remove();
+        }
+        scroll();
+    }
+
+    const subscription = gonb_comm.subscribe("{{.Address}}", (address, value) => {
+        if (!panel.isConnected) {
+            // Panel was removed (e.g.: the cell output was cleared).
+            gonb_comm.unsubscribe(subscription);
+            return;
+        }
+        if (value.history) {
+            records = [];
+            held = [];
+        }
+        if (paused) {
+            held.push(...value.records);
+            status.textContent = `paused, ${held.length} new record(s)`;
+            return;
+        }
+        if (value.history) {
+            records = value.records;
+            rerender();
+        } else {
+            append(value.records);
+        }
+    });
+
+    levelSelect.addEventListener("change", rerender);
+    follow.addEventListener("change", scroll);
+    pauseButton.addEventListener("click", () => {
+        paused = !paused;
+        pauseButton.textContent = paused ? "Resume" : "Pause";
+        if (!paused) {
+            status.textContent = "";
+            const toAppend = held;
+            held = [];
+            append(toAppend);
+        }
+    });
+    clearButton.addEventListener("click", () => {
+        records = [];
+        held = [];
+        lines.replaceChildren();
+    });
+})();
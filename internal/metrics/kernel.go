@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// Metrics of the kernel.
+var (
+	// StartTime of the kernel.
+	StartTime = time.Now()
+
+	Executions = NewCounterVec("gonb_executions_total",
+		"Number of cells executed, by status (\"ok\" or \"error\").", "status")
+
+	CompileSeconds = NewHistogram("gonb_compile_duration_seconds",
+		"Latency of the compilation of the cells (`go build`), excluding reused builds.",
+		[]float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60, 120, 300})
+
+	RunSeconds = NewHistogram("gonb_run_duration_seconds",
+		"Latency of the execution of the compiled cells.",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 1800})
+
+	GoplsRequestSeconds = NewHistogramVec("gonb_gopls_request_duration_seconds",
+		"Latency of the requests to gopls, by method.", "method",
+		[]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+
+	CommsConnected = NewGauge("gonb_comms_connected",
+		"Whether the connection (comms) with the front-end is opened (1) or not (0).")
+
+	CommsPeers = NewGauge("gonb_comms_peers",
+		"Number of front-end connections (comms) opened.")
+
+	CommsMessages = NewCounterVec("gonb_comms_messages_total",
+		"Number of messages exchanged with the front-end (comms), by direction (\"in\" or \"out\").", "direction")
+
+	CommsBytes = NewCounterVec("gonb_comms_bytes_total",
+		"Size of the values exchanged with the front-end (comms), by direction (\"in\" or \"out\").", "direction")
+
+	CommsDropped = NewCounter("gonb_comms_dropped_total",
+		"Number of messages from the front-end (comms) dropped, because there were no recipients.")
+
+	HeartbeatRTTSeconds = NewGauge("gonb_heartbeat_rtt_seconds",
+		"Round-trip time of the last heartbeat with the front-end.")
+
+	Goroutines = NewGaugeFunc("gonb_goroutines",
+		"Number of goroutines of the kernel.", func() float64 { return float64(runtime.NumGoroutine()) })
+
+	UptimeSeconds = NewGaugeFunc("gonb_uptime_seconds",
+		"Time since the kernel started.", func() float64 { return time.Since(StartTime).Seconds() })
+)
+
+// ObserveSince adds the time elapsed since `start` (in seconds) to the histogram.
+func ObserveSince(h *Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Handler returns an http.Handler that serves the metrics of the Default registry in the Prometheus
+// text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := Default.WriteText(w); err != nil {
+			klog.V(1).Infof("metrics: failed to write metrics: %+v", err)
+		}
+	})
+}
+
+// Address where the metrics are served, or empty if not served. Set by Serve.
+var Address string
+
+// Serve the metrics in `http://<address>/metrics`, in the background. It returns an error if it
+// fails to listen to the address.
+func Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen to %q to serve metrics", address)
+	}
+	Address = listener.Addr().String()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.Errorf("metrics: server failed: %+v", err)
+		}
+	}()
+	klog.Infof("Serving metrics in http://%s/metrics", Address)
+	return nil
+}
@@ -0,0 +1,387 @@
+// Package metrics keeps the metrics of the kernel -- executions, latencies of the compilation, execution and
+// gopls requests, and the state of the connection with the front-end -- and serves them in the Prometheus
+// text exposition format (see Serve and the `--metrics` flag). They are also summarized by `%status`.
+//
+// It implements only the subset of Prometheus needed by GoNB: counters, gauges and histograms, with at most
+// one label.
+package metrics
+
+import (
+	"fmt"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metric is implemented by all metrics types, so they can be written in the Prometheus text format.
+type Metric interface {
+	// Name of the metric.
+	Name() string
+
+	// write the samples of the metric, without the HELP and TYPE headers.
+	write(w io.Writer)
+}
+
+// Registry holds a collection of metrics, written together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []Metric
+	help    map[string]string
+	types   map[string]string
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{help: make(map[string]string), types: make(map[string]string)}
+}
+
+// Default is the Registry used by the metrics of the kernel, and by the package constructors.
+var Default = NewRegistry()
+
+// register the metric, panics if one with the same name is already registered.
+func (r *Registry) register(m Metric, metricType, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, found := r.types[m.Name()]; found {
+		panic(fmt.Sprintf("metric %q registered twice", m.Name()))
+	}
+	r.metrics = append(r.metrics, m)
+	r.help[m.Name()] = help
+	r.types[m.Name()] = metricType
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]Metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name(), r.help[m.Name()], m.Name(), r.types[m.Name()])
+		if err != nil {
+			return err
+		}
+		m.write(w)
+	}
+	return nil
+}
+
+// formatValue formats a sample value as expected by Prometheus.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatLabels formats the labels (pairs of name and value) of a sample, including the braces.
+func formatLabels(pairs ...string) string {
+	var parts []string
+	for ii := 0; ii+1 < len(pairs); ii += 2 {
+		if pairs[ii] == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", pairs[ii], strconv.Quote(pairs[ii+1])))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// atomicFloat is a float64 that can be updated concurrently.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+func (f *atomicFloat) Store(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Counter is a value that only increases, e.g.: the number of executions.
+type Counter struct {
+	name  string
+	value atomicFloat
+}
+
+// NewCounter creates and registers in the Default registry a Counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name}
+	Default.register(c, "counter", help)
+	return c
+}
+
+// Name implements Metric.
+func (c *Counter) Name() string { return c.name }
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.value.Add(1) }
+
+// Add increments the counter by the given non-negative delta.
+func (c *Counter) Add(delta float64) { c.value.Add(delta) }
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 { return c.value.Load() }
+
+func (c *Counter) write(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%s %s\n", c.name, formatValue(c.Value()))
+}
+
+// Gauge is a value that can go up and down, e.g.: the number of front-ends connected.
+type Gauge struct {
+	name  string
+	value atomicFloat
+	fn    func() float64
+}
+
+// NewGauge creates and registers in the Default registry a Gauge.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name}
+	Default.register(g, "gauge", help)
+	return g
+}
+
+// NewGaugeFunc creates and registers in the Default registry a Gauge whose value is given by `fn`, called
+// every time the metrics are written.
+func NewGaugeFunc(name, help string, fn func() float64) *Gauge {
+	g := &Gauge{name: name, fn: fn}
+	Default.register(g, "gauge", help)
+	return g
+}
+
+// Name implements Metric.
+func (g *Gauge) Name() string { return g.name }
+
+// Set the value of the gauge.
+func (g *Gauge) Set(v float64) { g.value.Store(v) }
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	if g.fn != nil {
+		return g.fn()
+	}
+	return g.value.Load()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.Value()))
+}
+
+// Histogram counts observations (e.g.: latencies) in buckets.
+type Histogram struct {
+	name, labelName, labelValue string
+
+	// buckets are the upper bounds of the buckets, in increasing order, not including +Inf.
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // Non-cumulative counts per bucket, the last one is +Inf.
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates and registers in the Default registry a Histogram with the given bucket upper bounds.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(name, buckets)
+	Default.register(h, "histogram", help)
+	return h
+}
+
+func newHistogram(name string, buckets []float64) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{name: name, buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Name implements Metric.
+func (h *Histogram) Name() string { return h.name }
+
+// Observe adds one observation.
+func (h *Histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.buckets, v) // First bucket with upper bound >= v.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[idx]++
+	h.sum += v
+	h.count++
+}
+
+// Count returns the number of observations, and their sum.
+func (h *Histogram) Count() (count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.sum
+}
+
+// Quantile estimates the given quantile (0 to 1) of the observations, by linear interpolation within the
+// bucket where it falls, as Prometheus' `histogram_quantile`. It returns NaN if there are no observations,
+// and the largest bucket bound if the quantile falls in the +Inf bucket.
+func (h *Histogram) Quantile(q float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(h.count)
+	var cumulative uint64
+	for ii, count := range h.counts {
+		if float64(cumulative+count) < rank || count == 0 {
+			cumulative += count
+			continue
+		}
+		if ii == len(h.buckets) {
+			break
+		}
+		lower := 0.0
+		if ii > 0 {
+			lower = h.buckets[ii-1]
+		}
+		return lower + (h.buckets[ii]-lower)*(rank-float64(cumulative))/float64(count)
+	}
+	if len(h.buckets) == 0 {
+		return math.NaN()
+	}
+	return h.buckets[len(h.buckets)-1]
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative uint64
+	for ii, count := range h.counts {
+		cumulative += count
+		upper := math.Inf(1)
+		if ii < len(h.buckets) {
+			upper = h.buckets[ii]
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labelName, h.labelValue, "le", formatValue(upper)), cumulative)
+	}
+	labels := formatLabels(h.labelName, h.labelValue)
+	_, _ = fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, labels, formatValue(h.sum), h.name, labels, h.count)
+}
+
+// CounterVec is a collection of counters, one per value of a label.
+type CounterVec struct {
+	name, labelName string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewCounterVec creates and registers in the Default registry a CounterVec with the given label.
+func NewCounterVec(name, help, labelName string) *CounterVec {
+	c := &CounterVec{name: name, labelName: labelName, counters: make(map[string]*Counter)}
+	Default.register(c, "counter", help)
+	return c
+}
+
+// Name implements Metric.
+func (c *CounterVec) Name() string { return c.name }
+
+// With returns the counter for the given label value, creating it if needed.
+func (c *CounterVec) With(labelValue string) *Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counter, found := c.counters[labelValue]
+	if !found {
+		counter = &Counter{name: c.name}
+		c.counters[labelValue] = counter
+	}
+	return counter
+}
+
+// Values returns the current value of the counters, per label value.
+func (c *CounterVec) Values() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[string]float64, len(c.counters))
+	for labelValue, counter := range c.counters {
+		values[labelValue] = counter.Value()
+	}
+	return values
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	values := c.Values()
+	for _, labelValue := range sortedKeys(values) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labelName, labelValue), formatValue(values[labelValue]))
+	}
+}
+
+// HistogramVec is a collection of histograms, one per value of a label.
+type HistogramVec struct {
+	name, labelName string
+	buckets         []float64
+
+	mu         sync.Mutex
+	histograms map[string]*Histogram
+}
+
+// NewHistogramVec creates and registers in the Default registry a HistogramVec with the given label
+// and bucket upper bounds.
+func NewHistogramVec(name, help, labelName string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{name: name, labelName: labelName, buckets: buckets, histograms: make(map[string]*Histogram)}
+	Default.register(h, "histogram", help)
+	return h
+}
+
+// Name implements Metric.
+func (h *HistogramVec) Name() string { return h.name }
+
+// With returns the histogram for the given label value, creating it if needed.
+func (h *HistogramVec) With(labelValue string) *Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	histogram, found := h.histograms[labelValue]
+	if !found {
+		histogram = newHistogram(h.name, h.buckets)
+		histogram.labelName, histogram.labelValue = h.labelName, labelValue
+		h.histograms[labelValue] = histogram
+	}
+	return histogram
+}
+
+// Histograms returns the histograms created so far, per label value.
+func (h *HistogramVec) Histograms() map[string]*Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	histograms := make(map[string]*Histogram, len(h.histograms))
+	for labelValue, histogram := range h.histograms {
+		histograms[labelValue] = histogram
+	}
+	return histograms
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	histograms := h.Histograms()
+	for _, labelValue := range sortedKeys(histograms) {
+		histograms[labelValue].write(w)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	counters := &CounterVec{name: "test_total", labelName: "status", counters: make(map[string]*Counter)}
+	registry.register(counters, "counter", "Test counter.")
+	histogram := newHistogram("test_seconds", []float64{1, 0.1})
+	registry.register(histogram, "histogram", "Test histogram.")
+	gauge := &Gauge{name: "test_gauge", fn: func() float64 { return 3 }}
+	registry.register(gauge, "gauge", "Test gauge.")
+
+	counters.With("ok").Inc()
+	counters.With("ok").Inc()
+	counters.With("error").Add(1)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.WriteText(&buf))
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{status="error"} 1
+test_total{status="ok"} 2
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 3
+`, buf.String())
+
+	assert.Panics(t, func() { registry.register(gauge, "gauge", "Again.") })
+}
+
+func TestQuantile(t *testing.T) {
+	h := newHistogram("test", []float64{1, 2, 4})
+	assert.True(t, math.IsNaN(h.Quantile(0.5)))
+	for _, v := range []float64{0.5, 1.5, 1.5, 3} {
+		h.Observe(v)
+	}
+	assert.InDelta(t, 1.5, h.Quantile(0.5), 1e-9) // Rank 2: halfway in the (1, 2] bucket, with 2 observations.
+	assert.InDelta(t, 3.6, h.Quantile(0.95), 1e-9)
+	h.Observe(10)
+	assert.Equal(t, 4.0, h.Quantile(0.99)) // In the +Inf bucket.
+}
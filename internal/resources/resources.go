@@ -0,0 +1,205 @@
+// Package resources keeps a registry of the resources created by the kernel -- temporary directories, named
+// pipes (fifos), sockets, child processes and locks -- with their owner, so they are released deterministically
+// on all exit paths (see ReleaseAll), and can be listed (see `%resources`).
+//
+// The owner of a resource should Release it as soon as it is no longer needed (or Forget it, if it was released
+// by other means). Resources still registered when the kernel exits are released by ReleaseAll.
+package resources
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Kind of resource.
+type Kind string
+
+const (
+	TempDir Kind = "temp_dir"
+	Fifo    Kind = "fifo"
+	Socket  Kind = "socket"
+	Process Kind = "process"
+	Lock    Kind = "lock"
+)
+
+// Resource is one entry in the Registry.
+type Resource struct {
+	Id      int
+	Kind    Kind
+	Owner   string // E.g.: "goexec", "jpyexec", "gopls".
+	Name    string // Path, address or process description.
+	Created time.Time
+
+	registry *Registry
+	release  func() error
+}
+
+// Registry of live resources. Most users will use the Default one, through the package functions
+// (Register, RegisterPath, RegisterProcess, List, ReleaseAll).
+type Registry struct {
+	mu        sync.Mutex
+	nextId    int
+	resources map[int]*Resource
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{resources: make(map[int]*Resource)}
+}
+
+// Default is the Registry used by the package functions.
+var Default = NewRegistry()
+
+// Register a resource, with the function that releases it. `release` may be nil, if there is nothing to do.
+func (reg *Registry) Register(kind Kind, owner, name string, release func() error) *Resource {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.nextId++
+	r := &Resource{
+		Id:       reg.nextId,
+		Kind:     kind,
+		Owner:    owner,
+		Name:     name,
+		Created:  time.Now(),
+		registry: reg,
+		release:  release,
+	}
+	reg.resources[r.Id] = r
+	klog.V(2).Infof("resources: registered #%d %s %q (owner %s)", r.Id, r.Kind, r.Name, r.Owner)
+	return r
+}
+
+// Register a resource in the Default registry, see Registry.Register.
+func Register(kind Kind, owner, name string, release func() error) *Resource {
+	return Default.Register(kind, owner, name, release)
+}
+
+// RegisterPath registers a file or directory (temporary directories, fifos, unix sockets, etc.) in the
+// Default registry, that is released by removing it (recursively).
+func RegisterPath(kind Kind, owner, filePath string) *Resource {
+	return Default.Register(kind, owner, filePath, func() error {
+		err := os.RemoveAll(filePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove %s %q", kind, filePath)
+		}
+		return nil
+	})
+}
+
+// RegisterProcess registers a child process in the Default registry, that is released by killing it.
+//
+// The owner should Forget it once it waited for the process to finish, since the pid may be reused.
+func RegisterProcess(owner, description string, process *os.Process) *Resource {
+	return Default.Register(Process, owner, fmt.Sprintf("pid=%d %s", process.Pid, description), func() error {
+		err := process.Kill()
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return errors.Wrapf(err, "failed to kill process %d", process.Pid)
+		}
+		return nil
+	})
+}
+
+// remove the resource from the registry, and returns whether it was still registered.
+func (r *Resource) remove() bool {
+	reg := r.registry
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, found := reg.resources[r.Id]; !found {
+		return false
+	}
+	delete(reg.resources, r.Id)
+	return true
+}
+
+// Release the resource and remove it from the registry.
+// It is a no-op if it was already released (or forgotten), or if r is nil.
+func (r *Resource) Release() error {
+	if r == nil || !r.remove() {
+		return nil
+	}
+	klog.V(2).Infof("resources: releasing #%d %s %q (owner %s)", r.Id, r.Kind, r.Name, r.Owner)
+	if r.release == nil {
+		return nil
+	}
+	return r.release()
+}
+
+// Forget removes the resource from the registry, without releasing it: to be used when the resource
+// was released by other means (e.g.: a process that exited).
+// It is a no-op if r is nil.
+func (r *Resource) Forget() {
+	if r != nil {
+		r.remove()
+	}
+}
+
+// List returns the live resources, in order of creation.
+func (reg *Registry) List() []*Resource {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	list := make([]*Resource, 0, len(reg.resources))
+	for _, r := range reg.resources {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// List returns the live resources of the Default registry, in order of creation.
+func List() []*Resource {
+	return Default.List()
+}
+
+// ReleaseAll releases all live resources, in the reverse order of creation (so, e.g., fifos are removed
+// before the temporary directory holding them). It returns the first error, and logs the others.
+func (reg *Registry) ReleaseAll() error {
+	list := reg.List()
+	var firstErr error
+	for ii := len(list) - 1; ii >= 0; ii-- {
+		err := list[ii].Release()
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		} else {
+			klog.Warningf("resources: %+v", err)
+		}
+	}
+	return firstErr
+}
+
+// ReleaseAll releases all live resources of the Default registry, see Registry.ReleaseAll.
+func ReleaseAll() error {
+	return Default.ReleaseAll()
+}
+
+// Report returns a text table with the live resources of the registry.
+func (reg *Registry) Report() string {
+	list := reg.List()
+	if len(list) == 0 {
+		return "No live resources.\n"
+	}
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "%d live resources:\n", len(list))
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  #\tKind\tOwner\tAge\tName")
+	now := time.Now()
+	for _, r := range list {
+		_, _ = fmt.Fprintf(w, "  %d\t%s\t%s\t%s\t%s\n", r.Id, r.Kind, r.Owner, now.Sub(r.Created).Round(time.Second), r.Name)
+	}
+	_ = w.Flush()
+	return buf.String()
+}
+
+// Report returns a text table with the live resources of the Default registry.
+func Report() string {
+	return Default.Report()
+}
@@ -0,0 +1,53 @@
+package resources
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	var released []string
+	releaseFn := func(name string) func() error {
+		return func() error {
+			released = append(released, name)
+			return nil
+		}
+	}
+	dir := reg.Register(TempDir, "test", "dir", releaseFn("dir"))
+	fifo := reg.Register(Fifo, "test", "fifo", releaseFn("fifo"))
+	reg.Register(Lock, "test", "lock", func() error { return errors.New("failed to unlock") })
+	proc := reg.Register(Process, "test", "proc", releaseFn("proc"))
+	require.Len(t, reg.List(), 4)
+	assert.Contains(t, reg.Report(), "4 live resources:")
+
+	// Release is idempotent, and Forget doesn't release.
+	require.NoError(t, fifo.Release())
+	require.NoError(t, fifo.Release())
+	proc.Forget()
+	assert.Equal(t, []string{"fifo"}, released)
+	assert.Equal(t, []*Resource{dir, reg.List()[1]}, reg.List())
+
+	// ReleaseAll in reverse order of creation, returning the error.
+	require.ErrorContains(t, reg.ReleaseAll(), "failed to unlock")
+	assert.Equal(t, []string{"fifo", "dir"}, released)
+	assert.Empty(t, reg.List())
+	assert.Equal(t, "No live resources.\n", reg.Report())
+	var nilResource *Resource
+	require.NoError(t, nilResource.Release())
+}
+
+func TestRegisterPath(t *testing.T) {
+	dir := path.Join(t.TempDir(), "gonb_test")
+	require.NoError(t, os.MkdirAll(path.Join(dir, "sub"), 0700))
+	r := RegisterPath(TempDir, "test", dir)
+	assert.Contains(t, List(), r)
+	require.NoError(t, r.Release())
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	assert.NotContains(t, List(), r)
+}
@@ -42,6 +42,7 @@ func ExecuteSpecialCell(msg kernel.Message, goExec *goexec.State, lines []string
 	}
 	isSpecialCell = true
 	klog.V(2).Infof("Executing special cell command %q", parts)
+	goExec.RecordCommand(parts[0])
 
 	switch parts[0] {
 	case "%%writefile":
@@ -122,6 +123,8 @@ func cellCmdScript(msg kernel.Message, goExec *goexec.State, args []string, line
 	}
 	return jpyexec.New(msg, args[0], args[1:]...).
 		ExecutionCount(msg.Kernel().ExecCounter).
+		WithOutputEncoding(goExec.OutputEncoding).
+		WithInterruptGrace(goExec.InterruptGrace).
 		WithStaticInput([]byte(strings.Join(lines, "\n") + "\n")).
 		Exec()
 }
@@ -0,0 +1,46 @@
+package specialcmd
+
+import (
+	"github.com/janpfeifer/gonb/internal/comms"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"strings"
+)
+
+// execComms executes the "%comms" special command. The parameter `args` excludes "%comms".
+//
+// It has the sub-commands `stats`, that reports the statistics of the messages exchanged with the front-end
+// (see comms.State.Stats), or erases them with `%comms stats reset`; and `chaos`, that configures the injection
+// of faults in the messages (see comms.Chaos), a testing aid for widgets.
+func execComms(msg kernel.Message, goExec *goexec.State, args []string) error {
+	args = slices.DeleteFunc(args, func(s string) bool { return s == "" })
+	if len(args) == 0 || (args[0] != "stats" && args[0] != "chaos") {
+		return errors.Errorf("`%%comms` requires the sub-command `stats` or `chaos` -- see `%%help`")
+	}
+	if args[0] == "chaos" {
+		return execCommsChaos(msg, goExec, args[1:])
+	}
+	switch {
+	case len(args) == 1:
+		return kernel.PublishMarkdown(msg, goExec.Comms.StatsReport())
+	case len(args) == 2 && args[1] == "reset":
+		goExec.Comms.ResetStats()
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* %comms stats reset: statistics erased.\n")
+	}
+	return errors.Errorf("`%%comms stats` takes only the optional parameter \"reset\"")
+}
+
+// execCommsChaos executes `%comms chaos [<configuration>|off]`: with no arguments, it reports the current chaos
+// configuration. The settings can be given in one comma-separated argument, or as separate arguments.
+func execCommsChaos(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) > 0 {
+		config, err := comms.ParseChaos(strings.Join(args, ","))
+		if err != nil {
+			return errors.WithMessagef(err, "`%%comms chaos`")
+		}
+		goExec.Comms.SetChaos(config)
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, goExec.Comms.ChaosReport())
+}
@@ -0,0 +1,196 @@
+package specialcmd
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+	"strings"
+	"time"
+)
+
+// configKey is one of the settings of `%config`.
+type configKey struct {
+	name string
+	get  func(goExec *goexec.State) string
+	set  func(goExec *goexec.State, value string) error
+}
+
+// configKeys lists the settings accepted by `%config`, in the order they are reported.
+var configKeys = []configKey{
+	{
+		name: "output.encoding",
+		get: func(goExec *goexec.State) string {
+			if goExec.OutputEncoding == "" {
+				return fmt.Sprintf("default (%s, from the locale)", jpyexec.LocaleEncoding())
+			}
+			return goExec.OutputEncoding
+		},
+		set: func(goExec *goexec.State, value string) error {
+			if value == "default" {
+				goExec.OutputEncoding = ""
+				return nil
+			}
+			name, err := jpyexec.LookupEncoding(value)
+			if err != nil {
+				return err
+			}
+			goExec.OutputEncoding = name
+			return nil
+		},
+	},
+	{
+		name: "interrupt.grace",
+		get: func(goExec *goexec.State) string {
+			if goExec.InterruptGrace == 0 {
+				return fmt.Sprintf("default (%s)", jpyexec.WaitToKill)
+			}
+			return goExec.InterruptGrace.String()
+		},
+		set: func(goExec *goexec.State, value string) error {
+			if value == "default" {
+				goExec.InterruptGrace = 0
+				return nil
+			}
+			grace, err := time.ParseDuration(value)
+			if err != nil || grace <= 0 {
+				return errors.Errorf("invalid grace period %q: it must be a positive duration, like \"10s\" or \"1m\"", value)
+			}
+			goExec.InterruptGrace = grace
+			return nil
+		},
+	},
+	{
+		name: "input.timeout",
+		get: func(goExec *goexec.State) string {
+			if goExec.InputTimeout == 0 {
+				return "off"
+			}
+			return goExec.InputTimeout.String()
+		},
+		set: func(goExec *goexec.State, value string) error {
+			if value == "off" || value == "default" {
+				goExec.InputTimeout = 0
+				return nil
+			}
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return errors.Errorf("invalid timeout %q: it must be a positive duration, like \"30s\" or \"5m\", or \"off\"", value)
+			}
+			goExec.InputTimeout = timeout
+			return nil
+		},
+	},
+	{
+		name: "watchdog",
+		get: func(goExec *goexec.State) string {
+			switch {
+			case goExec.Watchdog == 0:
+				return fmt.Sprintf("default (%s)", jpyexec.WatchdogThreshold)
+			case goExec.Watchdog < 0:
+				return "off"
+			}
+			return goExec.Watchdog.String()
+		},
+		set: func(goExec *goexec.State, value string) error {
+			switch value {
+			case "default":
+				goExec.Watchdog = 0
+				return nil
+			case "off":
+				goExec.Watchdog = -1
+				return nil
+			}
+			threshold, err := time.ParseDuration(value)
+			if err != nil || threshold <= 0 {
+				return errors.Errorf("invalid watchdog threshold %q: it must be a positive duration, like \"30s\" or \"5m\", or \"off\"", value)
+			}
+			goExec.Watchdog = threshold
+			return nil
+		},
+	},
+	{
+		name: "modules.isolated",
+		get: func(goExec *goexec.State) string {
+			if goExec.IsolatedModules() {
+				return "on"
+			}
+			return "off"
+		},
+		set: func(goExec *goexec.State, value string) error {
+			switch value {
+			case "on", "true":
+				return goExec.SetIsolatedModules(true)
+			case "off", "false", "default":
+				return goExec.SetIsolatedModules(false)
+			}
+			return errors.Errorf("invalid value %q: it must be \"on\" or \"off\"", value)
+		},
+	},
+	{
+		name: "comms.ttl",
+		get: func(goExec *goexec.State) string {
+			ttl := goExec.Comms.AddressTTL()
+			if ttl == 0 {
+				return "off"
+			}
+			return ttl.String()
+		},
+		set: func(goExec *goexec.State, value string) error {
+			switch value {
+			case "default":
+				goExec.Comms.SetAddressTTL(0)
+				return nil
+			case "off":
+				goExec.Comms.SetAddressTTL(-1)
+				return nil
+			}
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl <= 0 {
+				return errors.Errorf("invalid time-to-live %q: it must be a positive duration, like \"10m\" or \"1h\", or \"off\"", value)
+			}
+			goExec.Comms.SetAddressTTL(ttl)
+			return nil
+		},
+	},
+}
+
+// execConfig executes the "%config" special command. The parameter `args` excludes "%config".
+//
+// Each setting is given as `<key>=<value>` (or `<key> <value>`). With no settings, it reports the current configuration.
+func execConfig(msg kernel.Message, goExec *goexec.State, args []string) error {
+	args = slices.DeleteFunc(args, func(s string) bool { return s == "" })
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		key, value, found := strings.Cut(arg, "=")
+		if !found {
+			if len(args) == 0 {
+				return errors.Errorf("`%%config %s` requires a value, as in `<key>=<value>` -- see `%%help`", arg)
+			}
+			value = args[0]
+			args = args[1:]
+			arg = key + "=" + value
+		}
+		idx := slices.IndexFunc(configKeys, func(c configKey) bool { return c.name == key })
+		if idx < 0 {
+			return errors.Errorf("`%%config` unknown setting %q -- see `%%help`", key)
+		}
+		if err := configKeys[idx].set(goExec, value); err != nil {
+			return errors.WithMessagef(err, "`%%config %s`", arg)
+		}
+	}
+	var report strings.Builder
+	report.WriteString("Configuration:\n")
+	for _, c := range configKeys {
+		_, _ = fmt.Fprintf(&report, "  %s=%s\n", c.name, c.get(goExec))
+	}
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
@@ -5,12 +5,15 @@ import (
 	"github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/internal/goexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
+	"slices"
 	"strings"
+	"time"
 )
 
-// This file handles the commands %list (or %ls), %remove (%rm) and %reset, which help manipulate
-// memorized definitions.
+// This file handles the commands %list (or %ls), %remove (%rm), %reset, %recover, %save, %load and %include, which help
+// manipulate memorized definitions.
 
 // reset removes all definitions memorized, as if the kernel had been reset.
 func resetDefinitions(msg kernel.Message, goExec *goexec.State) {
@@ -81,3 +84,99 @@ func removeDefinitions(msg kernel.Message, goExec *goexec.State, keys []string)
 		}
 	}
 }
+
+// recoverSnapshot restores the memorized definitions and environment saved in the snapshot of a previous
+// session that didn't stop cleanly (e.g.: the kernel was killed). It implements the "%recover" command.
+//
+// The definitions are restored by executing them as a cell.
+func recoverSnapshot(msg kernel.Message, goExec *goexec.State) error {
+	snapshot, err := goExec.RecoverSnapshot()
+	if err != nil {
+		return err
+	}
+	return restoreDefinitions(msg, goExec, snapshot, "the session snapshot")
+}
+
+// saveCheckpoint implements the "%save <name>" command. Without a name, it lists the saved checkpoints.
+func saveCheckpoint(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		return listCheckpoints(msg)
+	}
+	if len(args) > 1 {
+		return errors.Errorf("`%%save <name>` takes one argument, %d were given", len(args))
+	}
+	filePath, snapshot, err := goExec.SaveCheckpoint(args[0])
+	if err != nil {
+		return err
+	}
+	err = kernel.PublishWriteStream(msg, kernel.StreamStdout,
+		fmt.Sprintf("* Saved %d definitions and %d environment variables to checkpoint %q (%s): restore them with `%%load %s`.\n",
+			snapshot.NumDeclarations, len(snapshot.Env), args[0], filePath, args[0]))
+	if err != nil {
+		klog.Errorf("Failed to publish back to jupyter output of saving checkpoint: %+v", err)
+	}
+	return nil
+}
+
+// loadCheckpoint implements the "%load <name>" command. Without a name, it lists the saved checkpoints.
+//
+// The definitions are restored by executing them as a cell.
+func loadCheckpoint(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		return listCheckpoints(msg)
+	}
+	if len(args) > 1 {
+		return errors.Errorf("`%%load <name>` takes one argument, %d were given", len(args))
+	}
+	snapshot, err := goExec.LoadCheckpoint(args[0])
+	if err != nil {
+		return err
+	}
+	return restoreDefinitions(msg, goExec, snapshot, fmt.Sprintf("checkpoint %q", args[0]))
+}
+
+// listCheckpoints displays the names of the saved checkpoints.
+func listCheckpoints(msg kernel.Message) error {
+	names, err := goexec.ListCheckpoints()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No checkpoints saved, use `%save <name>`.\n")
+	}
+	displayEnumeration(msg, "Checkpoints", names)
+	return nil
+}
+
+// includeDefinitions implements `%include <files...>`, merging the declarations of the given notebooks
+// or Go files. The parameter `args` excludes "%include".
+func includeDefinitions(msg kernel.Message, goExec *goexec.State, args []string) error {
+	args = slices.DeleteFunc(args, func(s string) bool { return s == "" })
+	if len(args) == 0 {
+		return errors.Errorf("`%%include <file.ipynb|file.go>...` requires at least one file")
+	}
+	for _, filePath := range args {
+		if err := goExec.Include(msg, msg.Kernel().ExecCounter, filePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreDefinitions executes the code of a snapshot (or checkpoint) as a cell, restoring its memorized
+// definitions, and reports it. `from` describes the snapshot for the messages.
+func restoreDefinitions(msg kernel.Message, goExec *goexec.State, snapshot *goexec.SessionSnapshot, from string) error {
+	if code := strings.TrimSpace(snapshot.Code); code != "" {
+		err := goExec.ExecuteCell(msg, msg.Kernel().ExecCounter, strings.Split(code, "\n"), common.MakeSet[int]())
+		if err != nil {
+			return errors.WithMessagef(err, "failed to restore definitions from %s", from)
+		}
+	}
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout,
+		fmt.Sprintf("* Recovered %d definitions and %d environment variables from %s saved at %s.\n",
+			snapshot.NumDeclarations, len(snapshot.Env), from, snapshot.Time.Format(time.DateTime)))
+	if err != nil {
+		klog.Errorf("Failed to publish back to jupyter output of recovering definitions: %+v", err)
+	}
+	return nil
+}
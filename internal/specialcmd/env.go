@@ -0,0 +1,71 @@
+package specialcmd
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"strings"
+)
+
+// execEnv executes the "%env" special command. The parameter `args` excludes "%env".
+//
+// Variables set are persisted for the next sessions of the notebook, see goexec.State.SetNotebookEnv.
+// With no arguments, it lists the variables set in the session.
+func execEnv(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		return listEnv(msg, goExec)
+	}
+	if args[0] == "--unset" || args[0] == "-u" {
+		if len(args) != 2 {
+			return errors.Errorf("`%%env --unset <VAR_NAME>`: it takes the name of the variable to unset, but %d arguments were given", len(args)-1)
+		}
+		if err := goExec.UnsetNotebookEnv(args[1]); err != nil {
+			return errors.WithMessagef(err, "`%%env --unset %s` failed", args[1])
+		}
+		publishEnv(msg, fmt.Sprintf("Unset: %s\n", args[1]))
+		return nil
+	}
+
+	if len(args) == 1 {
+		// Adjust args if one uses `%env KEY=VALUE` format instead.
+		if eqPos := strings.Index(args[0], "="); eqPos > 1 {
+			args = []string{args[0][:eqPos], args[0][eqPos+1:]}
+		}
+	}
+	if len(args) != 2 {
+		return errors.Errorf("`%%env <VAR_NAME> <value>` (or `%%env <VAR_NAME>=<value>`): it takes 2 arguments, the variable name and it's content, but %d were given", len(args))
+	}
+	if err := goExec.SetNotebookEnv(args[0], args[1]); err != nil {
+		return errors.WithMessagef(err, "`%%env %q %q` failed", args[0], args[1])
+	}
+	publishEnv(msg, fmt.Sprintf("Set: %s=%q\n", args[0], args[1]))
+	return nil
+}
+
+// listEnv reports the environment variables set in the session, with `%env`, `.env` file or other special commands.
+func listEnv(msg kernel.Message, goExec *goexec.State) error {
+	if len(goExec.SessionEnv) == 0 {
+		publishEnv(msg, "No environment variables set in this session.\n")
+		return nil
+	}
+	var report strings.Builder
+	report.WriteString("Environment variables set in this session:\n")
+	for _, key := range common.SortedKeys(goExec.SessionEnv) {
+		_, _ = fmt.Fprintf(&report, "  %s=%q", key, goExec.SessionEnv[key])
+		if goExec.IsPersistedEnv(key) {
+			report.WriteString(" (persisted)")
+		}
+		report.WriteString("\n")
+	}
+	publishEnv(msg, report.String())
+	return nil
+}
+
+func publishEnv(msg kernel.Message, text string) {
+	if err := kernel.PublishWriteStream(msg, kernel.StreamStdout, text); err != nil {
+		klog.Errorf("Failed to output: %+v", err)
+	}
+}
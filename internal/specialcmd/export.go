@@ -0,0 +1,56 @@
+package specialcmd
+
+import (
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"go/token"
+	"strings"
+)
+
+// parseExport parses the arguments of "%export" and sets goExec.CellExport: the export itself happens
+// once the cell is compiled, or in exportDefinitions if the cell has no Go code.
+// The parameter `args` excludes "%export".
+func parseExport(goExec *goexec.State, args []string) error {
+	opts := &goexec.ExportOptions{}
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		if !strings.HasPrefix(arg, "--") {
+			if opts.Dir != "" {
+				return errors.Errorf("`%%export` takes only one directory, got %q and %q", opts.Dir, arg)
+			}
+			opts.Dir = arg
+			continue
+		}
+		name, value, hasValue := strings.Cut(arg[2:], "=")
+		switch {
+		case name == "package" && hasValue:
+			if !token.IsIdentifier(value) || value == "main" {
+				return errors.Errorf("`%%export --package=%s`: invalid package name", value)
+			}
+			opts.Package = value
+		case name == "module" && hasValue:
+			opts.Module = value
+		case name == "cells" && !hasValue:
+			opts.Cells = true
+		case name == "force" && !hasValue:
+			opts.Force = true
+		default:
+			return errors.Errorf("`%%export`: unknown flag %q -- see `%%help`", arg)
+		}
+	}
+	if opts.Dir == "" {
+		return errors.Errorf("`%%export [--package=<name>] [--module=<path>] [--cells] [--force] <dir>` requires a directory")
+	}
+	goExec.CellExport = opts
+	return nil
+}
+
+// exportDefinitions exports the memorized declarations, for a cell with `%export` and no Go code.
+func exportDefinitions(msg kernel.Message, goExec *goexec.State) error {
+	opts := goExec.CellExport
+	goExec.CellExport = nil
+	return goExec.Export(msg, opts, goExec.Definitions, nil)
+}
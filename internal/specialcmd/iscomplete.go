@@ -0,0 +1,129 @@
+package specialcmd
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// Status values returned by IsComplete, as defined by Jupyter's `is_complete_reply` message.
+const (
+	CodeComplete   = "complete"
+	CodeIncomplete = "incomplete"
+	CodeInvalid    = "invalid"
+)
+
+// IsComplete checks whether the code in a cell seems complete, that is, whether it is ready
+// to be executed, or whether the user is still typing it -- e.g.: there are unbalanced
+// parenthesis/brackets/braces, an unterminated raw string or comment, a trailing binary operator,
+// or a special command ending with a line continuation ("\").
+//
+// It returns one of CodeComplete, CodeIncomplete or CodeInvalid and, if incomplete, the indentation
+// suggested for the next line.
+//
+// It is used to answer `is_complete_request` messages, and by console front-ends to decide
+// when to submit a multi-line input.
+// It doesn't check whether the code compiles: uncertain cases are considered complete, and left
+// to be reported by the compiler.
+func IsComplete(code string) (status, indent string) {
+	lines := strings.Split(code, "\n")
+	if !IsGoCell(lines[0]) {
+		// Cell magic (e.g.: "%%script"): it takes everything that follows, so only an empty
+		// line marks its end.
+		if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			return CodeComplete, ""
+		}
+		return CodeIncomplete, ""
+	}
+
+	// Separate special commands from Go code, the same way Parse does.
+	goLines := make([]string, 0, len(lines))
+	continuation, endsWithMain := false, false
+	for _, line := range lines {
+		isSpecial := continuation || (len(line) > 1 && (line[0] == '%' || line[0] == '!'))
+		if isSpecial {
+			if !continuation {
+				parts := strings.Fields(line)
+				endsWithMain = parts[0] == "%%" || parts[0] == "%main"
+			}
+			continuation = strings.HasSuffix(line, "\\")
+			goLines = append(goLines, "") // Keep line numbers.
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			endsWithMain = false
+		}
+		goLines = append(goLines, line)
+	}
+	if continuation || endsWithMain {
+		// A "%%" (or "%main") is always followed by the body of the main function.
+		return CodeIncomplete, ""
+	}
+	return isGoCodeComplete(strings.Join(goLines, "\n"))
+}
+
+// isGoCodeComplete implements IsComplete for Go code, by tokenizing it and checking that the
+// nesting of parenthesis/brackets/braces is balanced.
+func isGoCodeComplete(code string) (status, indent string) {
+	src := []byte(code)
+	fileSet := token.NewFileSet()
+	file := fileSet.AddFile("", fileSet.Base(), len(src))
+
+	var unterminated bool
+	errorHandler := func(_ token.Position, msg string) {
+		if msg == "raw string literal not terminated" || msg == "comment not terminated" {
+			// Raw strings and comments can span multiple lines.
+			unterminated = true
+		}
+	}
+	var scan scanner.Scanner
+	scan.Init(file, src, errorHandler, scanner.ScanComments)
+
+	depth := 0
+	lastTok := token.ILLEGAL
+	for {
+		_, tok, lit := scan.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			// Automatically inserted semicolon: doesn't count as last token.
+			continue
+		}
+		if tok == token.COMMENT {
+			continue
+		}
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		}
+		if depth < 0 {
+			return CodeInvalid, ""
+		}
+		lastTok = tok
+	}
+	if unterminated {
+		return CodeIncomplete, ""
+	}
+	if depth > 0 {
+		return CodeIncomplete, strings.Repeat("\t", depth)
+	}
+	if lastTok.IsOperator() && !isClosingToken(lastTok) {
+		// Binary operators, commas, periods, etc. at the end of the code imply it continues
+		// in the next line.
+		switch lastTok {
+		case token.INC, token.DEC, token.SEMICOLON:
+			// These can finish a statement.
+		default:
+			return CodeIncomplete, "\t"
+		}
+	}
+	return CodeComplete, ""
+}
+
+// isClosingToken returns whether tok is one of ")", "]" or "}".
+func isClosingToken(tok token.Token) bool {
+	return tok == token.RPAREN || tok == token.RBRACK || tok == token.RBRACE
+}
@@ -0,0 +1,40 @@
+package specialcmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsComplete(t *testing.T) {
+	testCases := []struct {
+		code, status, indent string
+	}{
+		{"", CodeComplete, ""},
+		{"x := 1", CodeComplete, ""},
+		{"func f() {", CodeIncomplete, "\t"},
+		{"func f() {\n\tif true {", CodeIncomplete, "\t\t"},
+		{"func f() {\n}", CodeComplete, ""},
+		{"x := []int{1,\n2}", CodeComplete, ""},
+		{"fmt.Println(1,", CodeIncomplete, "\t"},
+		{"x := 1 +", CodeIncomplete, "\t"},
+		{"x++", CodeComplete, ""},
+		{"s := `abc", CodeIncomplete, ""},
+		{"/* comment", CodeIncomplete, ""},
+		{"x := 1 // {", CodeComplete, ""},
+		{"}", CodeInvalid, ""},
+		{"%goflags -race \\", CodeIncomplete, ""},
+		{"%goflags -race \\\n  -v", CodeComplete, ""},
+		{"!ls {", CodeComplete, ""},
+		{"%%", CodeIncomplete, ""},
+		{"%main", CodeIncomplete, ""},
+		{"%%\nfmt.Println(1)", CodeComplete, ""},
+		{"%%bash\necho 1", CodeIncomplete, ""},
+		{"%%bash\necho 1\n", CodeComplete, ""},
+	}
+	for _, tc := range testCases {
+		status, indent := IsComplete(tc.code)
+		assert.Equalf(t, tc.status, status, "IsComplete(%q) status", tc.code)
+		assert.Equalf(t, tc.indent, indent, "IsComplete(%q) indent", tc.code)
+	}
+}
@@ -0,0 +1,90 @@
+package specialcmd
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"k8s.io/klog/v2"
+	"strconv"
+	"strings"
+)
+
+// limitsEnvVars maps the `%limits` settings to the environment variables they set.
+var limitsEnvVars = map[string]string{
+	"gomaxprocs": goexec.GoMaxProcsEnv,
+	"gomemlimit": goexec.GoMemLimitEnv,
+}
+
+// limitsPriority are the `%limits` settings of the priority of the programs executed, see jpyexec.Priority.
+var limitsPriority = []string{"nice", "ionice", "batch"}
+
+// execLimits executes the "%limits" special command. The parameter `args` excludes "%limits".
+//
+// Each setting is given as `<key>=<value>` or `<key> <value>`.
+func execLimits(msg kernel.Message, goExec *goexec.State, args []string) error {
+	args = slices.DeleteFunc(args, func(s string) bool { return s == "" })
+	for len(args) > 0 {
+		key, value, found := strings.Cut(args[0], "=")
+		args = args[1:]
+		if !found {
+			if len(args) == 0 {
+				return errors.Errorf("`%%limits %s` requires a value -- see `%%help`", key)
+			}
+			value = args[0]
+			args = args[1:]
+		}
+		if envVar, ok := limitsEnvVars[key]; ok {
+			if err := goExec.SetResourceLimit(envVar, value); err != nil {
+				return err
+			}
+		} else if slices.Contains(limitsPriority, key) {
+			if err := goExec.Priority.Set(key, value); err != nil {
+				return err
+			}
+		} else {
+			return errors.Errorf("`%%limits` unknown setting %q, it takes gomaxprocs, gomemlimit, nice, "+
+				"ionice or batch -- see `%%help`", key)
+		}
+	}
+	report := goexec.ResourceLimitsReport() + fmt.Sprintf("Priority of the programs executed: %s\n", goExec.Priority)
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout, report)
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
+
+// execRunners executes the "%runners" special command. The parameter `args` excludes "%runners".
+func execRunners(msg kernel.Message, goExec *goexec.State, args []string) error {
+	args = slices.DeleteFunc(args, func(s string) bool { return s == "" })
+	if len(args) > 1 {
+		return errors.Errorf("`%%runners` takes at most one parameter, the number of runners or \"off\"")
+	}
+	if len(args) == 1 {
+		n := 0
+		if args[0] != "off" {
+			var err error
+			n, err = strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return errors.Errorf("`%%runners %s`: the number of runners must be a non-negative number, or \"off\"", args[0])
+			}
+		}
+		if err := goExec.SetRunners(n); err != nil {
+			return err
+		}
+	}
+	size, idle := goExec.Runners()
+	var report string
+	if size == 0 {
+		report = "Pre-started runners: off\n"
+	} else {
+		report = fmt.Sprintf("Pre-started runners: %d (%d ready)\n", size, idle)
+	}
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout, report)
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+	return nil
+}
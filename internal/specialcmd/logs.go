@@ -0,0 +1,64 @@
+package specialcmd
+
+import (
+	"flag"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/logs"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slices"
+	"strings"
+)
+
+// execLogs executes the "%logs" special command. The parameter `args` excludes "%logs".
+//
+// It opens a log viewer panel in the cell output, that streams the logs of the kernel, the structured
+// logs of the programs executed (see gonbui.LogHandler) and the Javascript errors of the front-end, or with
+// `%logs off` stops streaming them. Naming a source (e.g.: `%logs js`) shows only its records.
+func execLogs(msg kernel.Message, goExec *goexec.State, args []string) error {
+	level := logs.LevelInfo
+	var source string
+	for _, arg := range args {
+		switch {
+		case arg == "":
+			continue
+		case slices.Contains(logs.Sources, arg):
+			source = arg
+		case arg == "off":
+			logs.Default.Stop()
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "Log viewer stopped.\n")
+		case strings.HasPrefix(arg, "v="):
+			// Verbosity of the kernel logs.
+			if err := flag.Set("v", arg[2:]); err != nil {
+				return errors.WithMessagef(err, "`%%logs %s`: invalid verbosity", arg)
+			}
+		default:
+			var err error
+			level, err = logs.ParseLevel(arg)
+			if err != nil {
+				return errors.WithMessagef(err, "`%%logs %s`", arg)
+			}
+		}
+	}
+
+	if err := goExec.Comms.InstallWebSocket(msg); err != nil {
+		return errors.WithMessagef(err, "`%%logs` requires the connection to the front-end (see `%%widgets`)")
+	}
+	htmlId := "gonb_logs_" + common.UniqueId()
+	html, err := logs.ViewerHtml(htmlId, level, source)
+	if err != nil {
+		return err
+	}
+	data := kernel.Data{
+		Data:      kernel.MIMEMap{string(protocol.MIMETextHTML): html},
+		Metadata:  make(kernel.MIMEMap),
+		Transient: kernel.MIMEMap{"display_id": htmlId},
+	}
+	if err := kernel.PublishUpdateDisplayData(msg, data); err != nil {
+		return err
+	}
+	// The records are only sent once the front-end is connected, so the viewer is ready to receive them.
+	return logs.Default.Start(msg, goExec.Comms)
+}
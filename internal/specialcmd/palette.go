@@ -0,0 +1,234 @@
+package specialcmd
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/gonbui/protocol"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/jobs"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/logs"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"strings"
+	"text/template"
+)
+
+// This file implements the command palette (`%palette`): a registry of the special commands and session actions
+// that can be invoked from the front-end, without typing them in a cell. The registry is kept in the kernel, next
+// to the special commands, and sent to the front-end through comms.
+
+const (
+	// PaletteListAddress is messaged by the front-end (with any value) to request the actions of the palette,
+	// which are sent to PaletteActionsAddress.
+	PaletteListAddress = "#gonb/palette/list"
+
+	// PaletteActionsAddress is where the kernel sends the list of actions of the palette.
+	PaletteActionsAddress = "#gonb/palette/actions"
+
+	// PaletteRunAddress is messaged by the front-end with the id of the action to run. The result is sent as a
+	// toast notification (see protocol.GonbuiToastAddress).
+	PaletteRunAddress = "#gonb/palette/run"
+)
+
+// PaletteAction is an action of the command palette.
+type PaletteAction struct {
+	Id          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	// Command is the equivalent special command, displayed to the user.
+	Command string `json:"command,omitempty"`
+
+	// whileBusy actions can be run while the kernel is executing a cell. The others change the state used
+	// by the execution, and are refused.
+	whileBusy bool
+
+	// run the action, and returns the message displayed to the user.
+	run func(goExec *goexec.State) (string, error)
+}
+
+// paletteActions is the registry of the actions of the command palette, in the order they are displayed.
+var paletteActions = []*PaletteAction{
+	{
+		Id:          "interrupt",
+		Title:       "Interrupt execution",
+		Description: "Interrupts the cell being executed, like the interrupt button of the notebook.",
+		whileBusy:   true,
+		run: func(goExec *goexec.State) (string, error) {
+			if goExec.Kernel == nil {
+				return "", errors.New("no kernel to interrupt")
+			}
+			goExec.Kernel.CallInterruptSubscribers()
+			return "Interruption requested.", nil
+		},
+	},
+	{
+		Id:          "jobs",
+		Title:       "List running servers",
+		Description: "Lists the servers announced by the programs (with `gonbui.AnnounceServer`) still running.",
+		Command:     "%jobs",
+		whileBusy:   true,
+		run: func(goExec *goexec.State) (string, error) {
+			running := jobs.List(true)
+			if len(running) == 0 {
+				return "No servers running.", nil
+			}
+			parts := make([]string, 0, len(running))
+			for _, job := range running {
+				parts = append(parts, fmt.Sprintf("#%d %s (cell [%d])", job.Id, job.Url(), job.Cell))
+			}
+			return fmt.Sprintf("%d server(s) running: %s.", len(running), strings.Join(parts, ", ")), nil
+		},
+	},
+	{
+		Id:          "reset",
+		Title:       "Reset definitions",
+		Description: "Discards all the memorized declarations.",
+		Command:     "%reset",
+		run: func(goExec *goexec.State) (string, error) {
+			goExec.Reset()
+			return "State reset: all memorized declarations discarded.", goExec.GoModInit()
+		},
+	},
+	{
+		Id:          "reset_gomod",
+		Title:       "Reset go.mod",
+		Description: "Resets the go.mod file of the session, keeping the memorized declarations.",
+		Command:     "%reset go.mod",
+		run: func(goExec *goexec.State) (string, error) {
+			return "go.mod reset.", goExec.GoModInit()
+		},
+	},
+	{
+		Id:          "autoget",
+		Title:       "Toggle automatic go get",
+		Description: "Enables or disables fetching the missing modules before compiling.",
+		Command:     "%autoget / %noautoget",
+		run: func(goExec *goexec.State) (string, error) {
+			goExec.AutoGet = !goExec.AutoGet
+			return fmt.Sprintf("Automatic `go get`: %s.", onOff(goExec.AutoGet)), nil
+		},
+	},
+	{
+		Id:          "autoformat",
+		Title:       "Toggle formatting on execution",
+		Description: "Enables or disables formatting the cells before executing them.",
+		Command:     "%autoformat on|off",
+		run: func(goExec *goexec.State) (string, error) {
+			goExec.AutoFormat = !goExec.AutoFormat
+			return fmt.Sprintf("Formatting on execution: %s.", onOff(goExec.AutoFormat)), nil
+		},
+	},
+	{
+		Id:          "cache_reset",
+		Title:       "Reset the cells cache",
+		Description: "Removes the memoized outputs of the cells executed with `%cache`.",
+		Command:     "%cache reset",
+		run: func(goExec *goexec.State) (string, error) {
+			numRemoved, err := goexec.ResetCellCache()
+			return fmt.Sprintf("%d cached cell(s) removed.", numRemoved), err
+		},
+	},
+	{
+		Id:          "logs_off",
+		Title:       "Stop the log viewer",
+		Description: "Stops streaming the logs to the log viewers opened with `%logs`.",
+		Command:     "%logs off",
+		whileBusy:   true,
+		run: func(goExec *goexec.State) (string, error) {
+			logs.Default.Stop()
+			return "Log viewer stopped.", nil
+		},
+	},
+}
+
+// onOff returns "on" or "off".
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
+// PaletteActions returns the actions of the command palette, in the order they are displayed.
+func PaletteActions() []*PaletteAction {
+	return paletteActions
+}
+
+// RunPaletteAction runs the action of the palette with the given id, and returns the message to display to the
+// user. If busy (the kernel is executing a cell), only the actions that don't change the state used by the
+// execution are run.
+func RunPaletteAction(goExec *goexec.State, id string, busy bool) (string, error) {
+	for _, action := range paletteActions {
+		if action.Id != id {
+			continue
+		}
+		if busy && !action.whileBusy {
+			return "", errors.Errorf("%q is not available while a cell is executing, interrupt it first", action.Title)
+		}
+		return action.run(goExec)
+	}
+	return "", errors.Errorf("unknown palette action %q", id)
+}
+
+// HandlePalette registers the handlers of the messages of the command palette sent by the front-end. isBusy
+// reports whether the kernel is executing a cell.
+func HandlePalette(goExec *goexec.State, isBusy func() bool) {
+	if goExec.Comms == nil {
+		return
+	}
+	goExec.Comms.HandleRequest(PaletteListAddress, func(msg kernel.Message, _ any) {
+		if err := goExec.Comms.Send(msg, PaletteActionsAddress, PaletteActions()); err != nil {
+			klog.Warningf("Failed to send the palette actions to the front-end: %+v", err)
+		}
+	})
+	goExec.Comms.HandleRequest(PaletteRunAddress, func(msg kernel.Message, value any) {
+		id, _ := value.(string)
+		klog.V(1).Infof("palette: running action %q", id)
+		goExec.RecordCommand("palette:" + id)
+		toast := map[string]any{"level": "success"}
+		message, err := RunPaletteAction(goExec, id, isBusy())
+		if err != nil {
+			klog.Warningf("palette: action %q failed: %+v", id, err)
+			toast["level"], toast["message"] = "error", err.Error()
+		} else {
+			toast["message"] = message
+		}
+		if err = goExec.Comms.Send(msg, protocol.GonbuiToastAddress, toast); err != nil {
+			klog.Warningf("Failed to send the result of the palette action %q to the front-end: %+v", id, err)
+		}
+	})
+}
+
+//go:embed palette.js
+var paletteJs []byte
+
+var tmplPaletteJs = template.Must(template.New("paletteJs").Parse(string(paletteJs)))
+
+// execPalette executes the "%palette" special command: it displays the command palette in the cell output.
+func execPalette(msg kernel.Message, goExec *goexec.State) error {
+	if err := goExec.Comms.InstallWebSocket(msg); err != nil {
+		return errors.WithMessagef(err, "`%%palette` requires the connection to the front-end (see `%%widgets`)")
+	}
+	htmlId := "gonb_palette_" + common.UniqueId()
+	var js bytes.Buffer
+	data := struct {
+		HtmlId, ListAddress, ActionsAddress, RunAddress string
+	}{
+		HtmlId:         htmlId,
+		ListAddress:    PaletteListAddress,
+		ActionsAddress: PaletteActionsAddress,
+		RunAddress:     PaletteRunAddress,
+	}
+	if err := tmplPaletteJs.Execute(&js, data); err != nil {
+		return errors.Wrapf(err, "palette template is invalid!?")
+	}
+	return kernel.PublishHtml(msg, fmt.Sprintf(`<div id="%s" class="gonb-palette" tabindex="-1">
+<input type="search" placeholder="GoNB command (Ctrl+Shift+P) ..." style="width: 30em;">
+<div class="gonb-palette-list" style="max-height: 15em; overflow-y: auto;"></div>
+</div>
+<script>%s</script>`, htmlId, js.String()))
+}
@@ -0,0 +1,90 @@
+(() => {
+    const panel = document.getElementById("{{.HtmlId}}");
+    const input = panel.querySelector("input");
+    const list = panel.querySelector(".gonb-palette-list");
+
+    const gonb_comm = globalThis?.gonb_comm;
+    if (!gonb_comm) {
+        list.textContent = "Not connected to GoNB: re-run `%palette` to reconnect.";
+        return;
+    }
+
+    let actions = [];  // Registry of actions, as sent by the kernel.
+    let shown = [];  // Actions matching the filter.
+    let selected = 0;  // Index in shown.
+
+    function matches(action, words) {
+        const text = `${action.title} ${action.description} ${action.command || ""}`.toLowerCase();
+        return words.every((word) => text.includes(word));
+    }
+
+    function render() {
+        const words = input.value.toLowerCase().split(/\s+/).filter((word) => word);
+        shown = actions.filter((action) => matches(action, words));
+        selected = Math.min(selected, Math.max(shown.length - 1, 0));
+        list.replaceChildren(...shown.map((action, ii) => {
+            const div = document.createElement("div");
+            div.style.cursor = "pointer";
+            div.style.padding = "0.1em 0.3em";
+            if (ii === selected) {
+                div.style.background = "rgba(127, 127, 127, 0.25)";
+            }
+            const title = document.createElement("b");
+            title.textContent = action.title;
+            div.appendChild(title);
+            if (action.command) {
+                const command = document.createElement("code");
+                command.textContent = ` ${action.command}`;
+                div.appendChild(command);
+            }
+            div.appendChild(document.createTextNode(` — ${action.description}`));
+            div.addEventListener("click", () => run(action));
+            return div;
+        }));
+    }
+
+    function run(action) {
+        gonb_comm.send("{{.RunAddress}}", action.id);
+    }
+
+    const subscription = gonb_comm.subscribe("{{.ActionsAddress}}", (address, value) => {
+        if (!panel.isConnected) {
+            // Panel was removed (e.g.: the cell output was cleared).
+            gonb_comm.unsubscribe(subscription);
+            return;
+        }
+        actions = value || [];
+        render();
+    });
+
+    input.addEventListener("input", () => {
+        selected = 0;
+        render();
+    });
+    input.addEventListener("keydown", (event) => {
+        if (event.key === "ArrowDown" || event.key === "ArrowUp") {
+            const delta = event.key === "ArrowDown" ? 1 : -1;
+            selected = Math.min(Math.max(selected + delta, 0), Math.max(shown.length - 1, 0));
+            render();
+        } else if (event.key === "Enter" && shown.length > 0) {
+            run(shown[selected]);
+        } else if (event.key === "Escape") {
+            input.value = "";
+            selected = 0;
+            render();
+        } else {
+            return;
+        }
+        event.preventDefault();
+        event.stopPropagation();  // Don't trigger the notebook shortcuts.
+    });
+    panel.addEventListener("keydown", (event) => {
+        if (event.ctrlKey && event.shiftKey && event.key.toLowerCase() === "p") {
+            event.preventDefault();
+            event.stopPropagation();
+            input.focus();
+        }
+    });
+
+    gonb_comm.send("{{.ListAddress}}", true);
+})();
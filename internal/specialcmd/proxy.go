@@ -0,0 +1,64 @@
+package specialcmd
+
+import (
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"strings"
+)
+
+// proxySubCommands maps the `%proxy <sub-command> <value>` forms to the goexec.NetworkConfig field they set.
+var proxySubCommands = map[string]string{
+	"no_proxy":   "NoProxy",
+	"ca":         "CAFile",
+	"goproxy":    "GoProxy",
+	"goprivate":  "GoPrivate",
+	"goinsecure": "GoInsecure",
+}
+
+// execProxy executes the "%proxy" special command. The parameter `args` excludes "%proxy".
+func execProxy(msg kernel.Message, goExec *goexec.State, args []string) error {
+	if len(args) == 0 {
+		showNetworkConfig(msg)
+		return nil
+	}
+	switch args[0] {
+	case "check":
+		return goExec.CheckNetwork(msg)
+	case "off":
+		if len(args) > 1 {
+			return errors.Errorf("`%%proxy off` takes no arguments")
+		}
+		if err := goExec.SetNetworkField("Proxy", ""); err != nil {
+			return err
+		}
+	default:
+		field, found := proxySubCommands[args[0]]
+		if !found {
+			// `%proxy <url>`
+			if len(args) > 1 {
+				return errors.Errorf("`%%proxy <url>` takes only one argument, %d were given -- see `%%help`", len(args))
+			}
+			field = "Proxy"
+			args = []string{"", args[0]}
+		}
+		if len(args) < 2 {
+			// Clear field.
+			args = append(args, "")
+		}
+		if err := goExec.SetNetworkField(field, strings.Join(args[1:], ",")); err != nil {
+			return err
+		}
+	}
+	showNetworkConfig(msg)
+	return nil
+}
+
+// showNetworkConfig lists the current network configuration.
+func showNetworkConfig(msg kernel.Message) {
+	err := kernel.PublishWriteStream(msg, kernel.StreamStdout, goexec.CurrentNetworkConfig().String())
+	if err != nil {
+		klog.Errorf("Failed to publish to Jupyter: %+v", err)
+	}
+}
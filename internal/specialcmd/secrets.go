@@ -0,0 +1,44 @@
+package specialcmd
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// execSecrets executes the "%secrets" special command. The parameter `args` excludes "%secrets".
+//
+// With no arguments it lists the names of the secrets kept in the session (see `gonbui.RequestSecret`), never
+// their values. `%secrets forget <name>...` removes the given ones, and `%secrets clear` all of them.
+func execSecrets(msg kernel.Message, goExec *goexec.State, args []string) error {
+	secrets := goExec.Secrets
+	if len(args) == 0 {
+		names := secrets.Names()
+		if len(names) == 0 {
+			return kernel.PublishWriteStream(msg, kernel.StreamStdout, "No secrets kept in this session.\n")
+		}
+		var report strings.Builder
+		report.WriteString("Secrets kept in this session:\n")
+		for _, name := range names {
+			_, _ = fmt.Fprintf(&report, "  %s\n", name)
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, report.String())
+	}
+	switch args[0] {
+	case "forget":
+		if len(args) == 1 {
+			return errors.Errorf("`%%secrets forget` requires the names of the secrets to forget")
+		}
+		forgotten := secrets.Forget(args[1:]...)
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, fmt.Sprintf("Forgot %d secret(s).\n", forgotten))
+	case "clear":
+		if len(args) > 1 {
+			return errors.Errorf("`%%secrets clear` takes no arguments")
+		}
+		secrets.Clear()
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "All secrets forgotten.\n")
+	}
+	return errors.Errorf("`%%secrets %s`: unknown sub-command, the valid ones are \"forget\" and \"clear\" -- see `%%help`", args[0])
+}
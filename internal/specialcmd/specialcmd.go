@@ -19,7 +19,9 @@ import (
 	. "github.com/janpfeifer/gonb/common"
 	"github.com/janpfeifer/gonb/gonbui/protocol"
 	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/jobs"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/resources"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
 )
@@ -45,6 +47,13 @@ type cellStatus struct {
 // If any errors happen, it is returned in err.
 func Parse(msg kernel.Message, goExec *goexec.State, execute bool, codeLines []string, usedLines Set[int]) (err error) {
 	status := &cellStatus{}
+	if execute {
+		// `%with_goflags`, `%cache` and `%export` only apply to the cell being parsed, even if the previous one had
+		// no Go code.
+		goExec.CellGoFlags = nil
+		goExec.CellCache = false
+		goExec.CellExport = nil
+	}
 
 	for lineNum, line := range codeLines {
 		if usedLines.Has(lineNum) {
@@ -84,6 +93,10 @@ func Parse(msg kernel.Message, goExec *goexec.State, execute bool, codeLines []s
 			}
 		}
 	}
+	if execute && goExec.CellExport != nil && !goExec.CellIsTest && goexec.IsEmptyLines(codeLines, usedLines) {
+		// No Go code to compile: export only the memorized declarations.
+		err = exportDefinitions(msg, goExec)
+	}
 	return
 }
 
@@ -118,6 +131,15 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 		content = msg.ComposedMsg().Content.(map[string]any)
 	}
 	parts := splitCmd(cmdStr)
+	if goExec.DisabledCommands.Has(parts[0]) {
+		return errors.Errorf("`%%%s` is disabled in this session, since it would affect other sessions", parts[0])
+	}
+	knownCommand := true
+	defer func() {
+		if knownCommand {
+			goExec.RecordCommand("%" + parts[0])
+		}
+	}()
 	switch parts[0] {
 
 	// Configures how cell will be executed.
@@ -127,11 +149,46 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 		klog.V(2).Infof("Program args to use (%%%s): %+q", parts[0], goExec.Args)
 		if parts[0] == "test" {
 			goExec.CellIsTest = true
+			goExec.CellTestReport = nil
+			if !isRawTestArgs(goExec.Args) {
+				// `go test` style flags: results are rendered as rich output.
+				report, err := parseTestReport(goExec.Args, false)
+				if err != nil {
+					return err
+				}
+				goExec.Args = nil
+				goExec.CellTestReport = report
+			}
 		}
 		// %% and %main are also handled specially by goexec, where it starts a main() clause.
+	case "bench":
+		// Run the benchmarks of the cell, with the results rendered as rich output.
+		report, err := parseTestReport(parts[1:], true)
+		if err != nil {
+			return err
+		}
+		goExec.Args = nil
+		goExec.CellIsTest = true
+		goExec.CellTestReport = report
+	case "prof":
+		// Profile the execution of the cell.
+		if len(parts) > 2 {
+			return errors.Errorf("`%%prof [%s]` takes at most one argument, %d given", strings.Join(goexec.ProfileTypes, "|"), len(parts)-1)
+		}
+		profType := goexec.ProfileCPU
+		if len(parts) == 2 {
+			profType = parts[1]
+		}
+		if !slices.Contains(goexec.ProfileTypes, profType) {
+			return errors.Errorf("`%%prof %s`: unknown profile type, valid values are %q", profType, goexec.ProfileTypes)
+		}
+		goExec.CellProfile = profType
 	case "wasm":
-		if len(parts) > 1 {
-			return errors.Errorf("`%%wasm` takes no extra parameters.")
+		for _, flag := range parts[1:] {
+			if flag != "--comms" {
+				return errors.Errorf("`%%wasm` unknown parameter %q, only `--comms` is accepted.", flag)
+			}
+			goExec.WasmComms = true
 		}
 		goExec.CellIsWasm = true
 		var err error
@@ -144,6 +201,9 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 	case "widgets":
 		return goExec.Comms.InstallWebSocket(msg)
 
+	case "comms":
+		return execComms(msg, goExec, parts[1:])
+
 	case "widgets_hb":
 		var hb bool
 		hb, err := goExec.Comms.SendHeartbeatAndWait(msg, 1*time.Second)
@@ -157,27 +217,7 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 		}
 
 	case "env":
-		// Set environment variables.
-		if len(parts) == 2 {
-			// Adjust parts if one uses `%env KEY=VALUE` format instead.
-			if eqPos := strings.Index(parts[1], "="); eqPos > 1 {
-				key := parts[1][:eqPos]
-				value := parts[1][eqPos+1:]
-				parts = []string{parts[0], key, value}
-			}
-		}
-		if len(parts) != 3 {
-			return errors.Errorf("`%%env <VAR_NAME> <value>` (or `%%env <VAR_NAME>=<value>`): it takes 2 arguments, the variable name and it's content, but %d were given", len(parts)-1)
-		}
-		err := os.Setenv(parts[1], parts[2])
-		if err != nil {
-			return errors.Wrapf(err, "`%%env %q %q` failed", parts[1], parts[2])
-		}
-		err = kernel.PublishWriteStream(msg, kernel.StreamStdout,
-			fmt.Sprintf("Set: %s=%q\n", parts[1], parts[2]))
-		if err != nil {
-			klog.Errorf("Failed to output: %+v", err)
-		}
+		return execEnv(msg, goExec, parts[1:])
 
 	case "cd":
 		if len(parts) == 1 {
@@ -215,11 +255,51 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 			klog.Errorf("Failed publishing contents: %+v", err)
 		}
 
+	case "with_goflags":
+		// One-shot flags, only for the current cell.
+		nonEmptyArgs := slices.DeleteFunc(parts[1:], func(s string) bool { return s == "" })
+		if len(nonEmptyArgs) == 0 {
+			return errors.Errorf("`%%with_goflags <values...>` requires at least one flag to pass to `go build`")
+		}
+		goExec.CellGoFlags = append(goExec.CellGoFlags, nonEmptyArgs...)
+
+	case "cache":
+		if len(parts) == 1 {
+			goExec.CellCache = true
+			return nil
+		}
+		if len(parts) > 2 || parts[1] != "reset" {
+			return errors.Errorf("`%%cache` takes only the optional parameter \"reset\"")
+		}
+		numRemoved, err := goexec.ResetCellCache()
+		if err != nil {
+			return err
+		}
+		err = kernel.PublishWriteStream(msg, kernel.StreamStdout,
+			fmt.Sprintf("* %%cache reset: %d cached cell(s) removed.\n", numRemoved))
+		if err != nil {
+			klog.Errorf("Failed publishing contents: %+v", err)
+		}
+
+	case "recover":
+		return recoverSnapshot(msg, goExec)
+	case "save":
+		return saveCheckpoint(msg, goExec, parts[1:])
+	case "load":
+		return loadCheckpoint(msg, goExec, parts[1:])
+	case "include":
+		return includeDefinitions(msg, goExec, parts[1:])
+
 		// Automatic `go get` control:
 	case "autoget":
 		goExec.AutoGet = true
 	case "noautoget":
 		goExec.AutoGet = false
+	case "autoformat":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			return errors.Errorf("`%%autoformat` takes one parameter, \"on\" or \"off\"")
+		}
+		goExec.AutoFormat = parts[1] == "on"
 	case "help":
 		//_ = kernel.PublishWriteStream(msg, kernel.StreamStdout, HelpMessage)
 		err := kernel.PublishMarkdown(msg, HelpMessage)
@@ -262,6 +342,50 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 	case "untrack":
 		execUntrack(msg, goExec, parts[1:])
 
+		// Self-diagnosis of the environment.
+	case "doctor":
+		return goExec.Doctor(msg)
+	case "status":
+		return goExec.PublishStatus(msg)
+	case "stats":
+		if len(parts) == 1 {
+			return goExec.PublishUsageStats(msg)
+		}
+		if len(parts) > 2 || parts[1] != "reset" {
+			return errors.Errorf("`%%stats` takes only the optional parameter \"reset\"")
+		}
+		if err := goExec.ResetUsageStats(); err != nil {
+			return err
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, "* %stats reset: usage statistics removed.\n")
+	case "limits":
+		return execLimits(msg, goExec, parts[1:])
+	case "runners":
+		return execRunners(msg, goExec, parts[1:])
+	case "logs":
+		return execLogs(msg, goExec, parts[1:])
+	case "palette":
+		return execPalette(msg, goExec)
+	case "config":
+		return execConfig(msg, goExec, parts[1:])
+	case "secrets":
+		return execSecrets(msg, goExec, parts[1:])
+	case "resources":
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, resources.Report())
+	case "jobs":
+		if len(parts) > 1 {
+			return errors.Errorf("`%%jobs` takes no arguments")
+		}
+		return kernel.PublishWriteStream(msg, kernel.StreamStdout, jobs.Report())
+	case "srcmap":
+		return execSourceMap(msg, goExec, parts[1:])
+	case "export":
+		return parseExport(goExec, parts[1:])
+
+		// Networking configuration.
+	case "proxy":
+		return execProxy(msg, goExec, parts[1:])
+
 		// Fix issues with `go work`.
 	case "goworkfix":
 		return goExec.GoWorkFix(msg)
@@ -274,6 +398,7 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 		}
 
 		// Unknown special command.
+		knownCommand = false
 		err := kernel.PublishWriteStream(msg, kernel.StreamStderr, fmt.Sprintf("\"%%%s\" unknown or not implemented yet.", parts[0]))
 		if err != nil {
 			klog.Errorf("Error while reporting back on unimplemented message command \"%%%s\" kernel: %+v", parts[0], err)
@@ -287,6 +412,7 @@ func execSpecialConfig(msg kernel.Message, goExec *goexec.State, cmdStr string,
 // It only returns errors for system errors that will lead to the kernel restart. Syntax errors
 // on the command themselves are simply reported back to jupyter and are not returned here.
 func execShell(msg kernel.Message, goExec *goexec.State, cmdStr string, status *cellStatus) error {
+	goExec.RecordCommand("!")
 	var execDir string // Default "", means current directory.
 	if cmdStr[0] == '*' {
 		cmdStr = cmdStr[1:]
@@ -297,16 +423,24 @@ func execShell(msg kernel.Message, goExec *goexec.State, cmdStr string, status *
 		status.withPassword = false
 		return jpyexec.New(msg, "/bin/bash", "-c", cmdStr).
 			ExecutionCount(msg.Kernel().ExecCounter).
+			WithOutputEncoding(goExec.OutputEncoding).
+			WithInterruptGrace(goExec.InterruptGrace).
+			WithInputTimeout(goExec.InputTimeout).
 			InDir(execDir).WithInputs(MillisecondsWaitForInput).Exec()
 	} else if status.withPassword {
 		status.withInputs = false
 		status.withPassword = false
 		return jpyexec.New(msg, "/bin/bash", "-c", cmdStr).
 			ExecutionCount(msg.Kernel().ExecCounter).
+			WithOutputEncoding(goExec.OutputEncoding).
+			WithInterruptGrace(goExec.InterruptGrace).
+			WithInputTimeout(goExec.InputTimeout).
 			InDir(execDir).WithPassword(MillisecondsWaitForInput).Exec()
 	} else {
 		return jpyexec.New(msg, "/bin/bash", "-c", cmdStr).
 			ExecutionCount(msg.Kernel().ExecCounter).
+			WithOutputEncoding(goExec.OutputEncoding).
+			WithInterruptGrace(goExec.InterruptGrace).
 			InDir(execDir).Exec()
 	}
 }
@@ -61,3 +61,79 @@ func TestDirEnv(t *testing.T) {
 	assert.Equal(t, "/tmp", os.Getenv(protocol.GONB_DIR_ENV))
 	require.NoError(t, s.Stop())
 }
+
+func TestGoFlags(t *testing.T) {
+	s := newEmptyState(t)
+	defer func() { require.NoError(t, s.Stop()) }()
+	var msg kernel.Message
+	parse := func(lines ...string) error {
+		return Parse(msg, s, true, lines, MakeSet[int]())
+	}
+
+	// `%goflags` is kept for the following cells, `%with_goflags` only applies to the cell being executed.
+	require.NoError(t, parse("%goflags -race", "%with_goflags -tags=foo", "%with_goflags -gcflags=-N"))
+	assert.Equal(t, []string{"-race"}, s.GoBuildFlags)
+	assert.Equal(t, []string{"-tags=foo", "-gcflags=-N"}, s.CellGoFlags)
+	s.PostExecuteCell()
+	assert.Equal(t, []string{"-race"}, s.GoBuildFlags)
+	assert.Empty(t, s.CellGoFlags)
+
+	// Cell flags are also reset by the next cell, even if the previous one was not executed.
+	require.NoError(t, parse("%with_goflags -tags=bar"))
+	require.NoError(t, parse("%goflags"))
+	assert.Equal(t, []string{"-race"}, s.GoBuildFlags, "`%goflags` with no arguments should only print the flags")
+	assert.Empty(t, s.CellGoFlags)
+
+	// Setting `%goflags` in the same cell doesn't include the cell flags.
+	require.NoError(t, parse("%with_goflags -tags=bar", "%goflags -v"))
+	assert.Equal(t, []string{"-v"}, s.GoBuildFlags)
+	assert.Equal(t, []string{"-tags=bar"}, s.CellGoFlags)
+
+	require.Error(t, parse("%with_goflags"))
+}
+
+func TestParseTestReport(t *testing.T) {
+	assert.True(t, isRawTestArgs(nil))
+	assert.True(t, isRawTestArgs([]string{""}))
+	assert.True(t, isRawTestArgs([]string{"-test.bench=.", "-test.run", "Benchmark"}))
+	assert.False(t, isRawTestArgs([]string{"-run", "TestA"}))
+
+	report, err := parseTestReport([]string{"-run", "TestA", "-v", "-cover", "-count=2"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, &goexec.TestReport{Verbose: true, Cover: true, Args: []string{"-test.count=2", "-test.run=TestA"}},
+		report)
+
+	report, err = parseTestReport([]string{"-benchmem", "-test.cpu=1"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, &goexec.TestReport{Bench: true, Args: []string{"-test.cpu=1", "-test.benchmem=true"}}, report)
+
+	_, err = parseTestReport([]string{"-unknown"}, false)
+	require.Error(t, err)
+	_, err = parseTestReport([]string{"-v", "TestA"}, false)
+	require.Error(t, err)
+}
+
+func TestPaletteActions(t *testing.T) {
+	ids := MakeSet[string]()
+	for _, action := range PaletteActions() {
+		require.NotEmpty(t, action.Id)
+		require.False(t, ids.Has(action.Id), "palette action %q registered more than once", action.Id)
+		ids.Insert(action.Id)
+		assert.NotEmpty(t, action.Title)
+		assert.NotNil(t, action.run, "palette action %q has no implementation", action.Id)
+	}
+
+	s := newEmptyState(t)
+	autoGet := s.AutoGet
+	_, err := RunPaletteAction(s, "autoget", false)
+	require.NoError(t, err)
+	assert.Equal(t, !autoGet, s.AutoGet)
+
+	// Actions that change the state are refused while a cell is executing.
+	_, err = RunPaletteAction(s, "autoget", true)
+	require.Error(t, err)
+	assert.Equal(t, !autoGet, s.AutoGet)
+
+	_, err = RunPaletteAction(s, "unknown", false)
+	require.Error(t, err)
+}
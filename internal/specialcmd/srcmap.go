@@ -0,0 +1,39 @@
+package specialcmd
+
+import (
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// execSourceMap implements `%srcmap`: with no arguments it lists the source maps of the session, otherwise it maps
+// each `<file>:<line>` given (as they appear in stack traces) to the corresponding cell line.
+// The parameter `args` excludes "%srcmap".
+func execSourceMap(msg kernel.Message, goExec *goexec.State, args []string) error {
+	var positions []string
+	for _, arg := range args {
+		if arg != "" {
+			positions = append(positions, arg)
+		}
+	}
+	if len(positions) == 0 {
+		return goExec.PublishSourceMaps(msg)
+	}
+	var buf strings.Builder
+	for _, position := range positions {
+		filePath, lineStr, found := strings.Cut(position, ":")
+		line, err := strconv.Atoi(lineStr)
+		if !found || err != nil {
+			return errors.Errorf("`%%srcmap %s`: positions must be given as <file>:<line>", position)
+		}
+		if cellId, cellLine, found := goExec.MapSourcePosition(filePath, line); found {
+			_, _ = fmt.Fprintf(&buf, "%s -> Cell [%d] Line %d\n", position, cellId, cellLine)
+		} else {
+			_, _ = fmt.Fprintf(&buf, "%s -> not found in any cell\n", position)
+		}
+	}
+	return kernel.PublishWriteStream(msg, kernel.StreamStdout, buf.String())
+}
@@ -0,0 +1,79 @@
+package specialcmd
+
+import (
+	"flag"
+	"fmt"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// isRawTestArgs returns whether the `%test` arguments have no flags, or only flags for the test binary
+// (prefixed with "-test."): in which case the output of the tests is displayed as is.
+func isRawTestArgs(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "-test.") {
+			return false
+		}
+	}
+	return true
+}
+
+// testReportValueFlags and testReportBoolFlags are the `go test` style flags accepted by `%test` and `%bench`,
+// that are passed to the test binary prefixed with "-test.".
+var (
+	testReportValueFlags = []string{"run", "skip", "bench", "benchtime", "count", "cpu", "timeout", "shuffle"}
+	testReportBoolFlags  = []string{"short", "failfast", "benchmem"}
+)
+
+// parseTestReport parses the `go test` style flags of `%test` or, if `bench` is true, of `%bench`.
+// The parameter `args` excludes the command itself.
+func parseTestReport(args []string, bench bool) (*goexec.TestReport, error) {
+	cmdName := "%test"
+	if bench {
+		cmdName = "%bench"
+	}
+	report := &goexec.TestReport{Bench: bench}
+	flagSet := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+	flagSet.BoolVar(&report.Verbose, "v", false, "")
+	flagSet.BoolVar(&report.Cover, "cover", false, "")
+	flagSet.Bool("json", false, "")
+	for _, name := range testReportValueFlags {
+		flagSet.String(name, "", "")
+	}
+	for _, name := range testReportBoolFlags {
+		flagSet.Bool(name, false, "")
+	}
+
+	// Flags for the test binary are passed as is, except `-test.v`, which is used to generate the events.
+	var goTestArgs []string
+	for _, arg := range args {
+		switch {
+		case arg == "":
+			continue
+		case arg == "-test.v" || strings.HasPrefix(arg, "-test.v="):
+			report.Verbose = true
+		case strings.HasPrefix(arg, "-test."):
+			report.Args = append(report.Args, arg)
+		default:
+			goTestArgs = append(goTestArgs, arg)
+		}
+	}
+	if err := flagSet.Parse(goTestArgs); err != nil {
+		return nil, errors.Errorf("`%s`: %v", cmdName, err)
+	}
+	if flagSet.NArg() > 0 {
+		return nil, errors.Errorf("`%s`: unexpected arguments %q", cmdName, flagSet.Args())
+	}
+	flagSet.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "v", "cover", "json":
+			// Handled by goexec.
+		default:
+			report.Args = append(report.Args, fmt.Sprintf("-test.%s=%s", f.Name, f.Value))
+		}
+	})
+	return report, nil
+}
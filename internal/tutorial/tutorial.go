@@ -0,0 +1,521 @@
+// Package tutorial generates the onboarding notebooks written by `gonb --init-tutorial <dir>`.
+//
+// The notebooks (widgets, plotting, testing and profiling) are tailored to the environment detected
+// with DetectEnvironment: e.g., if `goimports` or `gopls` are missing, the welcome notebook starts with
+// the cells to install them.
+package tutorial
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/janpfeifer/gonb/common"
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// Environment where the tutorials will run, used to tailor their contents.
+type Environment struct {
+	// GoVersion as reported by `go env GOVERSION` (e.g.: "go1.22.1"). Empty if `go` is not found.
+	GoVersion string
+
+	// HasGoImports and HasGopls indicate whether these tools are in the PATH.
+	HasGoImports, HasGopls bool
+
+	// HasJupyter indicates whether the `jupyter` program is in the PATH.
+	HasJupyter bool
+
+	// Proxy is set if an HTTP(S) proxy is configured in the environment.
+	Proxy bool
+
+	// CGO indicates whether cgo is enabled and a C compiler is available, required by the race detector.
+	CGO bool
+}
+
+// DetectEnvironment returns the Environment of the current process.
+func DetectEnvironment() Environment {
+	var env Environment
+	if output, err := exec.Command("go", "env", "GOVERSION").Output(); err == nil {
+		env.GoVersion = strings.TrimSpace(string(output))
+	}
+	_, err := exec.LookPath("goimports")
+	env.HasGoImports = err == nil
+	_, err = exec.LookPath("gopls")
+	env.HasGopls = err == nil
+	_, err = exec.LookPath("jupyter")
+	env.HasJupyter = err == nil
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		if os.Getenv(name) != "" {
+			env.Proxy = true
+		}
+	}
+	if output, err := exec.Command("go", "env", "CGO_ENABLED", "CC").Output(); err == nil {
+		fields := strings.Fields(string(output))
+		if len(fields) == 2 && fields[0] == "1" {
+			_, err = exec.LookPath(fields[1])
+			env.CGO = err == nil
+		}
+	}
+	return env
+}
+
+// OldGo returns whether the detected Go version is older than common.MinGoVersion (or unknown).
+func (env Environment) OldGo() bool {
+	return common.IsOldGoVersion(env.GoVersion)
+}
+
+// Notebook is a minimal representation of a Jupyter notebook (nbformat 4), enough to write the tutorials.
+type Notebook struct {
+	Cells         []Cell         `json:"cells"`
+	Metadata      map[string]any `json:"metadata"`
+	NBFormat      int            `json:"nbformat"`
+	NBFormatMinor int            `json:"nbformat_minor"`
+}
+
+// Cell of a Notebook.
+type Cell struct {
+	CellType string         `json:"cell_type"`
+	Id       string         `json:"id"`
+	Metadata map[string]any `json:"metadata"`
+	Source   []string       `json:"source"`
+}
+
+// MarshalJSON implements json.Marshaler: code cells require the (empty) execution count and outputs.
+func (c Cell) MarshalJSON() ([]byte, error) {
+	type plainCell Cell // Without the MarshalJSON method.
+	if c.CellType != "code" {
+		return json.Marshal(plainCell(c))
+	}
+	return json.Marshal(struct {
+		plainCell
+		ExecutionCount *int  `json:"execution_count"`
+		Outputs        []any `json:"outputs"`
+	}{plainCell: plainCell(c), Outputs: []any{}})
+}
+
+// newNotebook creates an empty notebook using the GoNB kernel.
+func newNotebook() *Notebook {
+	return &Notebook{
+		Metadata: map[string]any{
+			"kernelspec": map[string]any{
+				"display_name": "Go (gonb)",
+				"language":     "go",
+				"name":         "gonb",
+			},
+			"language_info": map[string]any{
+				"file_extension": ".go",
+				"name":           "go",
+			},
+		},
+		NBFormat:      4,
+		NBFormatMinor: 5,
+	}
+}
+
+// splitSource splits the source of a cell in lines, keeping the "\n", as Jupyter does.
+func splitSource(source string) []string {
+	return strings.SplitAfter(strings.TrimSpace(source), "\n")
+}
+
+// Markdown appends a markdown cell.
+func (nb *Notebook) Markdown(source string) *Notebook {
+	nb.Cells = append(nb.Cells, Cell{
+		CellType: "markdown",
+		Id:       fmt.Sprintf("gonb-tutorial-%d", len(nb.Cells)),
+		Metadata: map[string]any{},
+		Source:   splitSource(source),
+	})
+	return nb
+}
+
+// Code appends a (not executed) code cell.
+func (nb *Notebook) Code(source string) *Notebook {
+	nb.Cells = append(nb.Cells, Cell{
+		CellType: "code",
+		Id:       fmt.Sprintf("gonb-tutorial-%d", len(nb.Cells)),
+		Metadata: map[string]any{},
+		Source:   splitSource(source),
+	})
+	return nb
+}
+
+// Write the notebook to the given file path.
+func (nb *Notebook) Write(filePath string) error {
+	contents, err := json.MarshalIndent(nb, "", " ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode notebook %q", filePath)
+	}
+	if err = os.WriteFile(filePath, append(contents, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "failed to write notebook %q", filePath)
+	}
+	return nil
+}
+
+// tutorialEntry describes one of the generated notebooks.
+type tutorialEntry struct {
+	FileName, Title, Description string
+	Build                        func(env Environment) *Notebook
+}
+
+// tutorials generated after the welcome notebook, in order.
+var tutorials = []tutorialEntry{
+	{"01_Widgets.ipynb", "Widgets", "interactive sliders and buttons, and updating the output of a running cell.", widgetsNotebook},
+	{"02_Plotting.ipynb", "Plotting", "displaying HTML, Markdown and SVG plots generated from Go.", plottingNotebook},
+	{"03_Testing.ipynb", "Testing and Benchmarking", "running tests and benchmarks of the notebook code with `%test`.", testingNotebook},
+	{"04_Profiling.ipynb", "Profiling", "profiling a cell with `%prof`, and passing build flags.", profilingNotebook},
+}
+
+// WelcomeNotebook is the name of the notebook with the index of the tutorials.
+const WelcomeNotebook = "00_Welcome.ipynb"
+
+// Generate writes the tutorial notebooks to `dir` (created if needed), tailored to `env`, and returns
+// the paths of the files written.
+//
+// Existing notebooks are not overwritten: it returns an error instead, before writing anything.
+func Generate(dir string, env Environment) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create tutorial directory %q", dir)
+	}
+	notebooks := map[string]*Notebook{WelcomeNotebook: welcomeNotebook(env)}
+	fileNames := []string{WelcomeNotebook}
+	for _, entry := range tutorials {
+		notebooks[entry.FileName] = entry.Build(env)
+		fileNames = append(fileNames, entry.FileName)
+	}
+	for _, fileName := range fileNames {
+		if _, err := os.Stat(path.Join(dir, fileName)); err == nil {
+			return nil, errors.Errorf("tutorial notebook %q already exists, remove it first", path.Join(dir, fileName))
+		}
+	}
+	var written []string
+	for _, fileName := range fileNames {
+		filePath := path.Join(dir, fileName)
+		if err := notebooks[fileName].Write(filePath); err != nil {
+			return written, err
+		}
+		written = append(written, filePath)
+	}
+	return written, nil
+}
+
+// welcomeNotebook has the index of the tutorials, and the setup cells needed by the environment.
+func welcomeNotebook(env Environment) *Notebook {
+	nb := newNotebook()
+	var index strings.Builder
+	for _, entry := range tutorials {
+		_, _ = fmt.Fprintf(&index, "1. [%s](%s): %s\n", entry.Title, entry.FileName, entry.Description)
+	}
+	nb.Markdown(`
+# Welcome to GoNB
+
+**GoNB** is a Go kernel for Jupyter notebooks: each cell is compiled and executed on-the-fly, and the
+declarations (imports, types, functions, etc.) of a cell are memorized and available to the following cells.
+
+These tutorials are runnable notebooks, execute their cells in order (**Shift+Enter**) and edit them freely:
+
+` + index.String())
+
+	if env.OldGo() {
+		version := env.GoVersion
+		if version == "" {
+			version = "not found"
+		}
+		nb.Markdown(fmt.Sprintf(`
+## ⚠️ Go version
+
+The Go toolchain (%s) is older than %s, which these tutorials require. Install a newer version from
+[go.dev/dl](https://go.dev/dl/), and restart the kernel.`, version, common.MinGoVersion))
+	}
+	if !env.HasGoImports || !env.HasGopls {
+		nb.Markdown(`
+## Setup
+
+GoNB uses ` + "`goimports`" + ` to automatically add missing imports and ` + "`gopls`" + ` for auto-complete and
+contextual help, and they were not found in the PATH. Install them with the cell below: lines starting with
+` + "`!`" + ` are executed in a shell.`)
+		var install []string
+		if !env.HasGoImports {
+			install = append(install, "!go install golang.org/x/tools/cmd/goimports@latest")
+		}
+		if !env.HasGopls {
+			install = append(install, "!go install golang.org/x/tools/gopls@latest")
+		}
+		nb.Code(strings.Join(install, "\n"))
+		nb.Markdown("After they are installed, make sure `$(go env GOPATH)/bin` is in the PATH, and restart the kernel.")
+	}
+	if env.Proxy {
+		nb.Markdown("A proxy is configured: check that Go modules can be downloaded through it with `%proxy check`.")
+		nb.Code("%proxy check")
+	}
+
+	nb.Markdown(`
+## Hello World
+
+A cell with a ` + "`func main()`" + ` is executed. ` + "`%%`" + ` is a shortcut for ` + "`func main() {`" + `, up to the end of the cell.`)
+	nb.Code(`
+%%
+fmt.Println("Hello, GoNB!")`)
+	nb.Markdown(`
+Declarations are memorized across cells: define a function here, and use it in the next cell.`)
+	nb.Code(`
+func Greet(name string) string {
+    return fmt.Sprintf("Hello, %s!", name)
+}`)
+	nb.Code(`
+%%
+fmt.Println(Greet("Gopher"))`)
+	nb.Markdown(`
+## Diagnostics and Help
+
+` + "`%doctor`" + ` checks that the environment is correctly set up, and suggests fixes otherwise.
+` + "`%help`" + ` lists all special commands.`)
+	nb.Code("%doctor")
+	nb.Code("%help")
+	return nb
+}
+
+// widgetsNotebook demonstrates gonbui/widgets and transient outputs.
+func widgetsNotebook(_ Environment) *Notebook {
+	nb := newNotebook()
+	nb.Markdown(`
+# Widgets
+
+The package ` + "`github.com/janpfeifer/gonb/gonbui`" + ` displays rich content (HTML, images, etc.) from Go programs,
+and ` + "`gonbui/widgets`" + ` creates interactive widgets, whose values are communicated to the running cell.
+
+The first execution downloads these packages (with ` + "`go get`" + `), it may take a few seconds.`)
+	nb.Code(`
+import (
+    "github.com/janpfeifer/gonb/gonbui"
+    "github.com/janpfeifer/gonb/gonbui/widgets"
+)`)
+	nb.Markdown(`
+## Updating Outputs
+
+` + "`gonbui.UpdateHtml`" + ` replaces the contents of a display block, identified by an id: useful for progress reports.`)
+	nb.Code(`
+%%
+displayId := "progress_" + gonbui.UniqueId()
+for ii := 0; ii <= 10; ii++ {
+    gonbui.UpdateHtml(displayId, fmt.Sprintf("Progress: <b>%d%%</b>", ii*10))
+    time.Sleep(200 * time.Millisecond)
+}`)
+	nb.Markdown(`
+## Slider
+
+The cell below keeps running for 30 seconds, reporting the value of the slider as it changes.
+Interrupt the kernel (the ■ button) to stop it earlier.`)
+	nb.Code(`
+%%
+slider := widgets.Slider(0, 100, 50).Done()
+values := slider.Listen().LatestOnly()
+defer values.Close()
+displayId := "slider_" + gonbui.UniqueId()
+gonbui.UpdateHtml(displayId, fmt.Sprintf("Value: <b>%d</b>", slider.Value()))
+timeout := time.After(30 * time.Second)
+for {
+    select {
+    case value := <-values.C:
+        gonbui.UpdateHtml(displayId, fmt.Sprintf("Value: <b>%d</b>", value))
+    case <-timeout:
+        return
+    }
+}`)
+	nb.Markdown(`
+## Button
+
+Each click on the button is received as a counter.`)
+	nb.Code(`
+%%
+button := widgets.Button("Click me!").Done()
+clicks := button.Listen()
+defer clicks.Close()
+displayId := "clicks_" + gonbui.UniqueId()
+timeout := time.After(30 * time.Second)
+for {
+    select {
+    case count := <-clicks.C:
+        gonbui.UpdateHtml(displayId, fmt.Sprintf("Clicked %d time(s)", count))
+    case <-timeout:
+        return
+    }
+}`)
+	return nb
+}
+
+// plottingNotebook demonstrates displaying HTML, Markdown and SVG.
+func plottingNotebook(_ Environment) *Notebook {
+	nb := newNotebook()
+	nb.Markdown(`
+# Plotting
+
+Any library that generates SVG, PNG or HTML can be used to plot from GoNB: the output is displayed with
+` + "`gonbui.DisplaySvg`" + `, ` + "`gonbui.DisplayImage`" + ` or ` + "`gonbui.DisplayHtml`" + `.
+This tutorial generates the SVG directly, so it doesn't depend on any plotting library.`)
+	nb.Code(`
+import (
+    "math"
+    "strings"
+
+    "github.com/janpfeifer/gonb/gonbui"
+)
+
+// LinePlot renders the values as an SVG line plot of the given size.
+func LinePlot(values []float64, width, height int) string {
+    minV, maxV := values[0], values[0]
+    for _, v := range values {
+        minV, maxV = min(minV, v), max(maxV, v)
+    }
+    if maxV == minV {
+        maxV = minV + 1
+    }
+    points := make([]string, len(values))
+    for ii, v := range values {
+        x := float64(ii) * float64(width) / float64(len(values)-1)
+        y := float64(height) - (v-minV)/(maxV-minV)*float64(height)
+        points[ii] = fmt.Sprintf("%.1f,%.1f", x, y)
+    }
+    return fmt.Sprintf("<svg width=\"%d\" height=\"%d\" xmlns=\"http://www.w3.org/2000/svg\">"+
+        "<rect width=\"100%%\" height=\"100%%\" fill=\"#f8f8f8\"/>"+
+        "<polyline points=\"%s\" fill=\"none\" stroke=\"steelblue\" stroke-width=\"2\"/></svg>",
+        width, height, strings.Join(points, " "))
+}`)
+	nb.Code(`
+%%
+values := make([]float64, 200)
+for ii := range values {
+    x := float64(ii) / 10
+    values[ii] = math.Sin(x) * math.Exp(-x/10)
+}
+gonbui.DisplaySvg(LinePlot(values, 600, 200))`)
+	nb.Markdown(`
+## Markdown and HTML
+
+Tables and reports can be generated as Markdown (or HTML).`)
+	nb.Code(`
+%%
+var table strings.Builder
+table.WriteString("| x | sin(x) |\n|---|---|\n")
+for _, x := range []float64{0, math.Pi / 6, math.Pi / 2, math.Pi} {
+    fmt.Fprintf(&table, "| %.3f | %.3f |\n", x, math.Sin(x))
+}
+gonbui.DisplayMarkdown(table.String())`)
+	nb.Markdown(`
+## Plotting Libraries
+
+GoNB works with several Go plotting libraries, e.g. [Plotly](https://github.com/MetalBlueberry/go-plotly)
+(with ` + "`github.com/janpfeifer/gonb/gonbui/plotly`" + `), [gonum/plot](https://github.com/gonum/plot) and
+[margaid](https://github.com/erkkah/margaid) -- see examples in the
+[GoNB tutorial](https://github.com/janpfeifer/gonb/blob/main/examples/tutorial.ipynb).`)
+	return nb
+}
+
+// testingNotebook demonstrates `%test` and benchmarks.
+func testingNotebook(_ Environment) *Notebook {
+	nb := newNotebook()
+	nb.Markdown(`
+# Testing and Benchmarking
+
+A cell with ` + "`%test`" + ` is compiled with ` + "`go test`" + `, and by default runs the tests
+(and benchmarks) defined in the cell. First, a function to test:`)
+	nb.Code(`
+// Fib returns the n-th Fibonacci number.
+func Fib(n int) int {
+    if n < 2 {
+        return n
+    }
+    return Fib(n-1) + Fib(n-2)
+}`)
+	nb.Code(`
+%test
+func TestFib(t *testing.T) {
+    for n, want := range []int{0, 1, 1, 2, 3, 5, 8, 13} {
+        if got := Fib(n); got != want {
+            t.Errorf("Fib(%d) = %d, want %d", n, got, want)
+        }
+    }
+}`)
+	nb.Markdown(`
+Benchmarks in a ` + "`%test`" + ` cell are also executed:`)
+	nb.Code(`
+%test
+func BenchmarkFib(b *testing.B) {
+    for ii := 0; ii < b.N; ii++ {
+        Fib(20)
+    }
+}`)
+	nb.Markdown(`
+Flags given to ` + "`%test`" + ` are passed to the test binary, prefixed with ` + "`test.`" + `.`)
+	nb.Code(`
+%test -test.v -test.run=TestFib -test.count=2
+func TestFib(t *testing.T) {
+    if Fib(10) != 55 {
+        t.Fail()
+    }
+}`)
+	return nb
+}
+
+// profilingNotebook demonstrates `%prof` and, if cgo is available, `%with_goflags -race`.
+func profilingNotebook(env Environment) *Notebook {
+	nb := newNotebook()
+	nb.Markdown(`
+# Profiling
+
+` + "`%prof [cpu|mem|block]`" + ` profiles the execution of the cell, and displays a flame graph and the top functions.`)
+	nb.Code(`
+// Primes returns the prime numbers up to n, using a naive algorithm.
+func Primes(n int) []int {
+    var primes []int
+    for ii := 2; ii <= n; ii++ {
+        isPrime := true
+        for jj := 2; jj*jj <= ii; jj++ {
+            if ii%jj == 0 {
+                isPrime = false
+                break
+            }
+        }
+        if isPrime {
+            primes = append(primes, ii)
+        }
+    }
+    return primes
+}`)
+	nb.Code(`
+%prof cpu
+%%
+fmt.Printf("%d primes found\n", len(Primes(5_000_000)))`)
+	nb.Code(`
+%prof mem
+%%
+total := 0
+for ii := 0; ii < 20; ii++ {
+    total += len(Primes(200_000))
+}
+fmt.Println(total)`)
+	if !env.CGO {
+		return nb
+	}
+	nb.Markdown(`
+## Build Flags
+
+` + "`%with_goflags`" + ` passes flags to ` + "`go build`" + ` for the current cell only (` + "`%goflags`" + ` sets them
+for the session). E.g., the race detector:`)
+	nb.Code(`
+%with_goflags -race
+%%
+var wg sync.WaitGroup
+counts := make([]int, 4)
+for ii := range counts {
+    wg.Add(1)
+    go func(ii int) {
+        defer wg.Done()
+        counts[ii] = len(Primes(100_000))
+    }(ii)
+}
+wg.Wait()
+fmt.Println(counts)`)
+	return nb
+}
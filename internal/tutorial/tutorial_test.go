@@ -0,0 +1,70 @@
+package tutorial
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// readNotebook returns the cells of the notebook, and the concatenated source of all cells.
+func readNotebook(t *testing.T, filePath string) (cells []map[string]any, source string) {
+	contents, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	var nb struct {
+		Cells    []map[string]any `json:"cells"`
+		NBFormat int              `json:"nbformat"`
+	}
+	require.NoError(t, json.Unmarshal(contents, &nb))
+	assert.Equal(t, 4, nb.NBFormat)
+	var sb strings.Builder
+	for _, cell := range nb.Cells {
+		for _, line := range cell["source"].([]any) {
+			sb.WriteString(line.(string))
+		}
+		sb.WriteString("\n")
+	}
+	return nb.Cells, sb.String()
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	env := Environment{GoVersion: "go1.22.1", HasGoImports: true, HasGopls: false, Proxy: true}
+	files, err := Generate(dir, env)
+	require.NoError(t, err)
+	require.Len(t, files, len(tutorials)+1)
+	for _, filePath := range files {
+		cells, _ := readNotebook(t, filePath)
+		for _, cell := range cells {
+			_, hasOutputs := cell["outputs"]
+			_, hasExecutionCount := cell["execution_count"]
+			isCode := cell["cell_type"] == "code"
+			assert.Equal(t, isCode, hasOutputs, "only code cells have outputs")
+			assert.Equal(t, isCode, hasExecutionCount, "only code cells have an execution count")
+		}
+	}
+
+	// Tailored welcome notebook.
+	_, source := readNotebook(t, path.Join(dir, WelcomeNotebook))
+	assert.Contains(t, source, "!go install golang.org/x/tools/gopls@latest")
+	assert.NotContains(t, source, "goimports@latest")
+	assert.Contains(t, source, "%proxy check")
+	assert.NotContains(t, source, "Go version")
+	for _, entry := range tutorials {
+		assert.Contains(t, source, "("+entry.FileName+")")
+	}
+
+	// Existing notebooks are not overwritten.
+	_, err = Generate(dir, env)
+	require.Error(t, err)
+}
+
+func TestOldGo(t *testing.T) {
+	assert.True(t, Environment{}.OldGo())
+	assert.True(t, Environment{GoVersion: "go1.20.5"}.OldGo())
+	assert.False(t, Environment{GoVersion: "go1.21"}.OldGo())
+	assert.False(t, Environment{GoVersion: "go1.22rc1"}.OldGo())
+}
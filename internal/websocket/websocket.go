@@ -11,6 +11,18 @@ import (
 	"text/template"
 )
 
+// ProtocolVersion of the communication between the Javascript (`gonb_comm`) and the kernel. It is sent by the
+// front-end in the "comm_open" message, and the kernel rejects connections from a different version -- e.g.: from
+// the Javascript saved in the output of an old notebook -- and installs the current one instead.
+//
+// It must be incremented whenever the protocol changes, in a way the front-end and the kernel need to agree on.
+// Version 1 is the one before the version was sent.
+const ProtocolVersion = 3
+
+// The same Javascript is bundled by the JupyterLab extension (see `labextension/` in the repository), which
+// installs it when a notebook with a GoNB kernel is opened or the kernel restarts, with `.Extension` set to
+// true: the kernel then doesn't need to inject it in the cell outputs (see comms.State.FromExtension).
+
 //go:embed websocket.js
 var webSocketConnectJs []byte
 
@@ -24,11 +36,13 @@ var tmplWebSocketConnectJs = template.Must(template.New("ws").Parse(
 // the status of the communication -- useful for debugging.
 func Javascript(jupyterKernelId string, verbose bool) string {
 	data := struct {
-		KernelId string
-		Verbose  bool
+		KernelId           string
+		Verbose, Extension bool
+		ProtocolVersion    int
 	}{
-		KernelId: jupyterKernelId,
-		Verbose:  verbose,
+		KernelId:        jupyterKernelId,
+		Verbose:         verbose,
+		ProtocolVersion: ProtocolVersion,
 	}
 	var buf bytes.Buffer
 	err := tmplWebSocketConnectJs.Execute(&buf, data)
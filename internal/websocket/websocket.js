@@ -26,6 +26,10 @@
         }
     }
 
+    // The subscriptions of the previous gonb_comm (if any) are adopted, so the widgets already displayed keep
+    // working, and their values are synchronized once connected (see _request_sync).
+    const previous_comm = globalThis.gonb_comm || globalThis.gonb_comm_previous;
+    delete globalThis.gonb_comm_previous;
     if (globalThis.gonb_comm) {
         // Already defined.
         console.error("gonb_comm already running: we assume this is after a kernel restart, closing previous instance.");
@@ -42,6 +46,8 @@
     // The `comm` abbreviation comes from Jupyter `comm` protocol used by it.
     let gonb_comm = {
         debug: {{.Verbose}},  // Set to true to see verbose debugging messages in the console.
+        protocol_version: {{.ProtocolVersion}},  // Must match the kernel's, see websocket.ProtocolVersion.
+        from_extension: {{.Extension}},  // Installed by the JupyterLab extension, as opposed to the kernel.
         websocket_is_opened: false,
         _kernel_id: "{{.KernelId}}",
         _ws_url: "ws://" + document.location.host + "/api/kernels/{{.KernelId}}/channels",
@@ -54,12 +60,20 @@
         _address_subscriptions: {},  // map address -> map id(Symbol) -> callback.
         _address_subscriptions_next_id: 0,
         _address_subscriptions_id_to_address: {},  // map id(Symbol) -> address.
+        _address_last_seq: {},  // map address -> sequence number of the last reliable message delivered.
 
         // Synced Variables:
         _address_to_synced_var: {},  // map address -> variable.
     };
+    if (previous_comm) {
+        gonb_comm._address_subscriptions = previous_comm._address_subscriptions;
+        gonb_comm._address_subscriptions_next_id = previous_comm._address_subscriptions_next_id;
+        gonb_comm._address_subscriptions_id_to_address = previous_comm._address_subscriptions_id_to_address;
+        gonb_comm._address_to_synced_var = previous_comm._address_to_synced_var;
+    }
     globalThis.gonb_comm = gonb_comm; // Make it globally available.
     gonb_comm._websocket = new WebSocket(gonb_comm._ws_url);
+    gonb_comm._websocket.binaryType = "arraybuffer";  // Messages with binary buffers, see _decode_binary.
 
     /**
      * Handles opening: mark as ready for business.
@@ -93,6 +107,7 @@
 
         if (globalThis.gonb_comm === gonb_comm) {
             delete globalThis.gonb_comm;
+            globalThis.gonb_comm_previous = gonb_comm;  // Adopted by the next gonb_comm.
         }
     };
 
@@ -113,7 +128,7 @@
             gonb_comm.close(1000, "gonb_comm from previous kernel still hanging, closing it");
         }
 
-        const msg = JSON.parse(event.data);
+        const msg = (event.data instanceof ArrayBuffer) ? gonb_comm._decode_binary(event.data) : JSON.parse(event.data);
         // debug_log(`gonb_comm: websocket received "${msg.msg_type}"`);
         if (msg.msg_type === "comm_msg") {
             gonb_comm._on_comm_msg(msg);
@@ -129,8 +144,10 @@
      *
      * @param address A string, by convention organized hierarchically, separated by "/". E.g.: "/hyperparameters/learning_rate".
      * @param value Any pod (plain-old-data) value, or an object. It will be JASON.stringified.
+     * @param buffers Optional list of binary buffers (`ArrayBuffer` or typed arrays) sent along. The Go program
+     *        receives the first buffer as a `[]byte`, in place of the value.
      */
-    gonb_comm.send = function(address, value) {
+    gonb_comm.send = function(address, value, buffers) {
         debug_log(`gonb_comm.send(${address}, ${value})`);
         this._is_connected.
             then(() => {
@@ -143,7 +160,7 @@
                     },
                 }
                 debug_log(`async gonb_comm.send(${address}, ${value})`);
-                let err = this._send(msg);
+                let err = buffers ? this._send_binary(msg, buffers) : this._send(msg);
                 if (err) {
                     console.error(`gonb_comm: failed sending data to address "${address}": ${err.message}`);
                 }
@@ -196,12 +213,19 @@
     }
 
     /** close closes websocket connection and cleans up.
+     * If the connection to the kernel was opened, it sends a "comm_close", so the kernel cleans up its side.
      * Once websocket closes, gonb_comm will be deleted from the global scope.
      */
     gonb_comm.close = function(code, reason) {
         debug_log(`gonb_comm.close(${code}, ${reason}) called`);
         if (globalThis.gonb_comm === this) {
             delete globalThis.gonb_comm;
+            globalThis.gonb_comm_previous = this;  // Adopted by the next gonb_comm.
+        }
+        if (this._comm_id !== null && this.websocket_is_opened) {
+            let msg = this._build_raw_message("comm_close");
+            msg.content = {comm_id: this._comm_id, data: {}};
+            this._send(msg);
         }
         this._comm_id = null;  // Won't recognize or deliver any more comm_msg.
         this._self_closed = true;  // Prevents a second deletion of global gonb_comm, since a new one may be in the process of being created.
@@ -228,6 +252,11 @@
 
         if (address === "#comm_open_ack") {
             debug_log(`gonb_comm: received comm_msg addressed to #comm_open_ack.`);
+            if (typeof data.value === "number" && data.value !== this.protocol_version) {
+                // The kernel only acknowledges the same version, so this shouldn't happen.
+                console.warn(`gonb_comm: GoNB kernel uses protocol version ${data.value}, but this Javascript uses ` +
+                    `version ${this.protocol_version}: widgets may not work, re-run the cell.`);
+            }
             if (this._onopen_ack) {
                 this._onopen_ack();
             }
@@ -237,20 +266,63 @@
             this.send("#heartbeat/pong", true);
             debug_log(`gonb_comm: replied #heartbeat/ping with /pong`);
             return;
+        } else if (address === "#comm_sync") {
+            // Snapshot of the values requested by _request_sync.
+            const snapshot = data.value || {};
+            debug_log(`gonb_comm: received #comm_sync with ${Object.keys(snapshot).length} values.`);
+            for (const [sync_address, value] of Object.entries(snapshot)) {
+                this._deliver(sync_address, value);
+            }
+            return;
+        } else if (address === "#gonbui/toast") {
+            // Notification sent by the program, see gonbui.Toast.
+            this._show_toast(data.value);
+            return;
+        }
+
+        let seq = data?.seq;
+        let last_seq = this._address_last_seq[address];
+        if (seq !== undefined && last_seq !== undefined && seq <= last_seq) {
+            // Reliable message already delivered, or superseded by a newer value: acknowledge it again, since
+            // the previous acknowledgement may have been lost.
+            this.send("#comm_ack", seq);
+            debug_log(`gonb_comm: comm_msg to address \"${address}\" with seq=${seq} dropped, already delivered seq=${last_seq}.`);
+            return;
         }
 
         let subscribers = this._address_subscriptions[address];
         if (!subscribers) {
-            console.error(`gonb_comm: comm_msg to address \"${address}\" but no one listening.`);
+            if (address.startsWith("#")) {
+                // Kernel events (e.g. "#execution/start") are broadcast, even if no one is listening.
+                debug_log(`gonb_comm: comm_msg to address \"${address}\" dropped, no one listening.`);
+            } else {
+                console.error(`gonb_comm: comm_msg to address \"${address}\" but no one listening.`);
+            }
             return;
         }
 
-        let value = data?.value;
+        if (seq !== undefined) {
+            // Reliable message: acknowledged once it is delivered -- if there are no subscribers yet, the kernel
+            // re-sends it later.
+            this.send("#comm_ack", seq);
+            this._address_last_seq[address] = seq;
+        }
+
+        let value = msg.buffers?.length > 0 ? msg.buffers[0] : data?.value;
         if (!value) {
             console.error(`gonb_comm: comm_msg to address \"${address}\" but with no value!?.`);
             return;
         }
-        debug_log(`gonb_comm: delivered comm_msg to address \"${address}\" to ${Object.keys(subscribers).length} listener(s).`)
+        this._deliver(address, value);
+    }
+
+    // _deliver the value to the subscribers of the address, if any.
+    gonb_comm._deliver = function(address, value) {
+        let subscribers = this._address_subscriptions[address];
+        if (!subscribers) {
+            return;
+        }
+        debug_log(`gonb_comm: delivered comm_msg to address \"${address}\" to ${Reflect.ownKeys(subscribers).length} listener(s).`)
         for (const key of Reflect.ownKeys(subscribers)) {
             debug_log(`\t> ${key.toString()}::callback(${address}, ${value});`);
             let callback = subscribers[key];
@@ -258,6 +330,46 @@
         }
     }
 
+    /**
+     * _show_toast displays a notification ({level, message}) in the bottom-right corner of the page, removed after
+     * a few seconds -- errors and warnings stay longer -- or when clicked.
+     */
+    gonb_comm._show_toast = function(toast) {
+        const colors = {info: "#268bd2", success: "#2aa198", warning: "#b58900", error: "#dc322f"};
+        const durations = {info: 4000, success: 4000, warning: 8000, error: 12000};
+        const level = colors[toast?.level] ? toast.level : "info";
+        let container = document.getElementById("gonb-toasts");
+        if (!container) {
+            container = document.createElement("div");
+            container.id = "gonb-toasts";
+            container.style.cssText = "position: fixed; bottom: 1em; right: 1em; z-index: 10000; " +
+                "display: flex; flex-direction: column; gap: 0.5em; max-width: 30em;";
+            document.body.appendChild(container);
+        }
+        const div = document.createElement("div");
+        div.textContent = toast?.message ?? "";
+        div.style.cssText = `background: ${colors[level]}; color: white; padding: 0.5em 1em; ` +
+            "border-radius: 4px; box-shadow: 0 2px 6px rgba(0, 0, 0, 0.3); cursor: pointer; white-space: pre-wrap;";
+        const remove = () => div.remove();
+        div.addEventListener("click", remove);
+        container.appendChild(div);
+        setTimeout(remove, durations[level]);
+    }
+
+    /**
+     * _request_sync requests the current value of all the addresses listened to, after (re-)connecting: the
+     * kernel replies with a snapshot (to "#comm_sync") of the ones it knows about, so the widgets of a
+     * previous connection are brought up-to-date right away.
+     */
+    gonb_comm._request_sync = function() {
+        const addresses = Object.keys(this._address_subscriptions).filter((address) => !address.startsWith("#"));
+        if (addresses.length === 0) {
+            return;
+        }
+        debug_log(`gonb_comm: requesting synchronization of ${addresses.length} addresses.`);
+        this.send("#comm_sync_request", addresses);
+    }
+
     /**
      * send is JSON.stringify the message and sends it to the websocket.
      *
@@ -278,6 +390,69 @@
         }
     }
 
+    /**
+     * _send_binary sends the message with the given binary buffers to the websocket, serialized
+     * in the Jupyter Server binary websocket format: the number of parts (the JSON message plus the
+     * buffers) and the offset of each part, as big-endian uint32, followed by the parts.
+     *
+     * @param msg message sent to be sent to the JupyterServer.
+     * @param buffers list of `ArrayBuffer` or typed arrays.
+     * @returns Error or null.
+     */
+    gonb_comm._send_binary = function(msg, buffers) {
+        debug_log(`gonb_comm._send_binary(${this._kernel_id}, ${buffers.length} buffers)`);
+        let parts = [new TextEncoder().encode(JSON.stringify(msg))];
+        for (const buffer of buffers) {
+            parts.push(ArrayBuffer.isView(buffer) ?
+                new Uint8Array(buffer.buffer, buffer.byteOffset, buffer.byteLength) : new Uint8Array(buffer));
+        }
+        const headerSize = 4 * (parts.length + 1);
+        let total = headerSize;
+        for (const part of parts) {
+            total += part.byteLength;
+        }
+        let bytes = new Uint8Array(total);
+        let view = new DataView(bytes.buffer);
+        view.setUint32(0, parts.length);
+        let offset = headerSize;
+        parts.forEach((part, ii) => {
+            view.setUint32(4 * (ii + 1), offset);
+            bytes.set(part, offset);
+            offset += part.byteLength;
+        });
+        try {
+            this._websocket.send(bytes.buffer);
+            return null;
+        } catch (err) {
+            debug_log(`gonb_comm._send_binary() failed: ${err.message}`);
+            return err;
+        }
+    }
+
+    /**
+     * _decode_binary decodes a message with binary buffers received from the websocket, in the Jupyter Server
+     * binary websocket format (see _send_binary). The buffers are set in `msg.buffers`, as `ArrayBuffer`s.
+     *
+     * @param data `ArrayBuffer` received.
+     * @returns the decoded message.
+     */
+    gonb_comm._decode_binary = function(data) {
+        let view = new DataView(data);
+        const numParts = view.getUint32(0);
+        let offsets = [];
+        for (let ii = 0; ii < numParts; ii++) {
+            offsets.push(view.getUint32(4 * (ii + 1)));
+        }
+        offsets.push(data.byteLength);
+        let parts = [];
+        for (let ii = 0; ii < numParts; ii++) {
+            parts.push(data.slice(offsets[ii], offsets[ii + 1]));
+        }
+        let msg = JSON.parse(new TextDecoder().decode(parts[0]));
+        msg.buffers = parts.slice(1);
+        return msg;
+    }
+
     /**
      * _build_raw_message of the given type, with a newly created msg_id.
      * The message has channel set to "shell" -- usual for communicating, and the content is empty.
@@ -313,10 +488,11 @@
                 comm_id: this._comm_id,
                 target_name: "gonb_comm",
                 kernel_id: this._kernel_id,
-                data: {},
+                data: {protocol_version: this.protocol_version, extension: this.from_extension},
             }
             let err = this._send(msg);
             await this._wait_open_ack();
+            this._request_sync();
         } catch (err) {
             console.error(`gonb_comm: failed to connect to kernel, communication (and widgets) will not work: ${err.message}`);
             gonb_comm.close(1000, err);
@@ -484,6 +660,94 @@ observer.observe(element.parentNode, { childList: true });
 
 
 
+    /**
+     * install_console_bridge forwards the JavaScript errors and console warnings of GoNB's front-end code to the
+     * kernel, to the address "#gonb/js_log", where they are logged and shown with `%logs js`.
+     *
+     * Console warnings and errors are forwarded if they mention GoNB, and uncaught errors if they come from
+     * scripts in the page (where the cell outputs run) -- at most 20 every 10 seconds. It is installed only once
+     * per page, and forwards through the current `gonb_comm`, if any.
+     */
+    function install_console_bridge() {
+        if (globalThis.gonb_console_bridge) {
+            return;
+        }
+        globalThis.gonb_console_bridge = true;
+        const gonb_regexp = /gonb|SyncedVariable/i;
+        const max_records = 20, window_ms = 10000;
+        let window_start = 0, window_count = 0, forwarding = false;
+
+        function forward(level, message, location) {
+            const comm = globalThis.gonb_comm;
+            if (!comm || forwarding || message.includes("#gonb/js_log")) {
+                return;
+            }
+            const now = Date.now();
+            if (now - window_start > window_ms) {
+                window_start = now;
+                window_count = 0;
+            }
+            window_count++;
+            if (window_count > max_records) {
+                return;
+            }
+            forwarding = true;  // Errors while forwarding are not forwarded.
+            try {
+                comm.send("#gonb/js_log", {level: level, message: message, location: location || ""});
+            } finally {
+                forwarding = false;
+            }
+        }
+
+        function format(args) {
+            return args.map((arg) => {
+                if (arg instanceof Error) {
+                    return arg.stack || arg.message;
+                } else if (typeof arg === "string") {
+                    return arg;
+                }
+                try {
+                    return JSON.stringify(arg);
+                } catch (err) {
+                    return String(arg);
+                }
+            }).join(" ");
+        }
+
+        for (const [method, level] of [["warn", "warning"], ["error", "error"]]) {
+            const original = console[method];
+            console[method] = function(...args) {
+                original.apply(console, args);
+                const message = format(args);
+                if (gonb_regexp.test(message)) {
+                    forward(level, message);
+                }
+            };
+        }
+        globalThis.addEventListener("error", (event) => {
+            const page = document.location.href.split("#")[0];
+            const in_page = !event.filename || event.filename.split("#")[0] === page;
+            const message = event.error?.stack || event.message;
+            if (in_page || gonb_regexp.test(message)) {
+                forward("error", message, event.filename ? `${event.filename}:${event.lineno}` : "");
+            }
+        });
+        globalThis.addEventListener("unhandledrejection", (event) => {
+            const message = event.reason?.stack || String(event.reason);
+            if (gonb_regexp.test(message)) {
+                forward("error", `unhandled promise rejection: ${message}`);
+            }
+        });
+    }
+    install_console_bridge();
+
+    // Close the connection when navigating away from the page.
+    globalThis.addEventListener?.("pagehide", () => {
+        if (globalThis.gonb_comm === gonb_comm) {
+            gonb_comm.close(1000, "page closed");
+        }
+    });
+
     // Start connecting protocol ("comm_open", and a "comm_open_ack" message).
     gonb_comm._is_connected = gonb_comm._connect_to_gonb();
 })();
\ No newline at end of file
@@ -0,0 +1,5 @@
+"""Front-end code of GoNB (the Go kernel for Jupyter), as a pre-built JupyterLab extension."""
+
+
+def _jupyter_labextension_paths():
+    return [{"src": "labextension", "dest": "jupyterlab-gonb"}]
@@ -0,0 +1,26 @@
+// Generates src/gonb_comm.ts from the Javascript the kernel injects (internal/websocket/websocket.js), so the
+// extension installs the same `gonb_comm` object. The protocol version is taken from websocket.go, and the
+// other template fields are filled in when installing it (see src/index.ts).
+const fs = require("fs");
+const path = require("path");
+
+const websocketDir = path.join(__dirname, "..", "..", "internal", "websocket");
+const js = fs.readFileSync(path.join(websocketDir, "websocket.js"), "utf8");
+const goSource = fs.readFileSync(path.join(websocketDir, "websocket.go"), "utf8");
+const match = goSource.match(/const ProtocolVersion = (\d+)/);
+if (!match) {
+    throw new Error("ProtocolVersion not found in websocket.go");
+}
+const protocolVersion = Number(match[1]);
+
+const output = `// Generated by scripts/generate.js from internal/websocket/websocket.js -- DO NOT EDIT.
+
+// PROTOCOL_VERSION of the Javascript, see websocket.ProtocolVersion.
+export const PROTOCOL_VERSION = ${protocolVersion};
+
+// GONB_COMM_JS is the Javascript that installs \`gonb_comm\`, with the fields {{.KernelId}}, {{.Verbose}}
+// and {{.Extension}} still to be replaced.
+export const GONB_COMM_JS = ${JSON.stringify(js.split("{{.ProtocolVersion}}").join(String(protocolVersion)))};
+`;
+fs.writeFileSync(path.join(__dirname, "..", "src", "gonb_comm.ts"), output);
+console.log(`Generated src/gonb_comm.ts (protocol version ${protocolVersion}).`);
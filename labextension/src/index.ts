@@ -0,0 +1,90 @@
+import { JupyterFrontEnd, JupyterFrontEndPlugin } from '@jupyterlab/application';
+import { INotebookTracker, NotebookPanel } from '@jupyterlab/notebook';
+import { Kernel } from '@jupyterlab/services';
+import { GONB_COMM_JS, PROTOCOL_VERSION } from './gonb_comm';
+
+// KERNEL_NAME is the name of the GoNB kernel spec, as installed by `gonb --install`.
+const KERNEL_NAME = 'gonb';
+
+/**
+ * install runs the `gonb_comm` Javascript connected to the given kernel, as the kernel itself would inject it -- but
+ * with `from_extension` set, so the kernel knows it doesn't need to.
+ *
+ * There is only one `gonb_comm` in the page: installing it for a kernel closes the previous one.
+ */
+function install(kernel: Kernel.IKernelConnection): void {
+  const current = (globalThis as any).gonb_comm;
+  if (current?._kernel_id === kernel.id && current?.websocket_is_opened) {
+    return;
+  }
+  const js = GONB_COMM_JS.split('{{.KernelId}}')
+    .join(kernel.id)
+    .split('{{.Verbose}}')
+    .join('false')
+    .split('{{.Extension}}')
+    .join('true');
+  console.debug(`jupyterlab-gonb: installing gonb_comm (protocol version ${PROTOCOL_VERSION}) for kernel ${kernel.id}`);
+  // Executed in the global scope, like the `<script>` injected by the kernel.
+  new Function(js)();
+}
+
+/**
+ * watch installs `gonb_comm` once the kernel is ready, and again every time it restarts.
+ */
+function watch(panel: NotebookPanel, kernel: Kernel.IKernelConnection): void {
+  if (kernel.name !== KERNEL_NAME) {
+    return;
+  }
+  let pending = true;
+  const maybeInstall = () => {
+    if (pending && kernel.status === 'idle' && kernel.connectionStatus === 'connected') {
+      pending = false;
+      install(kernel);
+    }
+  };
+  kernel.statusChanged.connect((_, status) => {
+    if (status === 'restarting' || status === 'autorestarting' || status === 'starting') {
+      pending = true;
+    }
+    maybeInstall();
+  });
+  kernel.connectionStatusChanged.connect((_, status) => {
+    if (status === 'connecting') {
+      pending = true;
+    }
+    maybeInstall();
+  });
+  panel.disposed.connect(() => {
+    pending = false;
+  });
+  maybeInstall();
+}
+
+const plugin: JupyterFrontEndPlugin<void> = {
+  id: 'jupyterlab-gonb:plugin',
+  description: 'Installs the front-end code of GoNB, so the kernel does not need to inject it in the cell outputs.',
+  autoStart: true,
+  requires: [INotebookTracker],
+  activate: (app: JupyterFrontEnd, tracker: INotebookTracker) => {
+    tracker.widgetAdded.connect((_, panel) => {
+      panel.sessionContext.kernelChanged.connect((_, args) => {
+        if (args.newValue) {
+          watch(panel, args.newValue);
+        }
+      });
+      const kernel = panel.sessionContext.session?.kernel;
+      if (kernel) {
+        watch(panel, kernel);
+      }
+    });
+    // gonb_comm connects to one kernel at a time: the one of the notebook being used.
+    tracker.currentChanged.connect((_, panel) => {
+      const kernel = panel?.sessionContext.session?.kernel;
+      if (kernel?.name === KERNEL_NAME && kernel.status === 'idle') {
+        install(kernel);
+      }
+    });
+  }
+};
+
+export default plugin;
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/gofrs/uuid"
-	"github.com/janpfeifer/gonb/internal/dispatcher"
-	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/httpapi"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
 	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/janpfeifer/gonb/internal/logs"
+	"github.com/janpfeifer/gonb/internal/metrics"
+	"github.com/janpfeifer/gonb/internal/resources"
+	"github.com/janpfeifer/gonb/internal/tutorial"
+	"github.com/janpfeifer/gonb/pkg/gonbkernel"
 	"io"
 	klog "k8s.io/klog/v2"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -24,8 +33,37 @@ var (
 	flagRawError  = flag.Bool("raw_error", false, "When GoNB executes cells, force raw text errors instead of HTML errors, which facilitates command line testing of notebooks.")
 	flagWork      = flag.Bool("work", false, "Print name of temporary work directory and preserve it at exit. ")
 	flagCommsLog  = flag.Bool("comms_log", false, "Enable verbose logging from communication library in Javascript console.")
+	flagConsole   = flag.Bool("console", false, "Run an interactive REPL in the terminal, without Jupyter. It can also be set with `gonb console`.")
+	flagHttp      = flag.String("http", "", "Serve the HTTP/JSON API for remote execution on the given address (e.g.: \"localhost:8080\"), without Jupyter.")
+	flagHttpToken = flag.String("http_token", "", "Token required by the HTTP API (--http). If empty, it is read from the environment variable "+HttpTokenEnv+", and if also empty a random one is generated and printed.")
+	flagHttpIdle  = flag.Duration("http_idle_timeout", httpapi.DefaultIdleTimeout, "Sessions of the HTTP API (--http) not used for this long are stopped. A negative value disables it.")
+	flagMetrics   = flag.String("metrics", "", "Serve Prometheus metrics of the kernel in http://<address>/metrics (e.g.: \"localhost:9464\", or \":0\" for any free port). See `%status`.")
+	flagTutorial  = flag.String("init-tutorial", "", "Write runnable tutorial notebooks (widgets, plotting, testing, profiling) to the given directory, tailored to the environment, and install the kernel if not yet installed.")
 )
 
+// Networking configuration: applied to the environment of the kernel, and hence to all `go` commands
+// and programs it executes. They are preserved by --install, and can be changed with `%proxy`.
+var (
+	flagProxy      = flag.String("proxy", "", "HTTP(S) proxy URL, sets $HTTP_PROXY and $HTTPS_PROXY.")
+	flagNoProxy    = flag.String("no_proxy", "", "Comma-separated hosts not to use the proxy for, sets $NO_PROXY.")
+	flagCAFile     = flag.String("ca_file", "", "PEM file with additional certificate authorities to trust: $SSL_CERT_FILE and $GIT_SSL_CAINFO are set to a bundle with the system ones plus these.")
+	flagGoProxy    = flag.String("goproxy", "", "Sets $GOPROXY.")
+	flagGoPrivate  = flag.String("goprivate", "", "Sets $GOPRIVATE.")
+	flagGoInsecure = flag.String("goinsecure", "", "Sets $GOINSECURE.")
+)
+
+// networkConfig returns the networking configuration given by the flags.
+func networkConfig() gonbkernel.NetworkConfig {
+	return gonbkernel.NetworkConfig{
+		Proxy:      *flagProxy,
+		NoProxy:    *flagNoProxy,
+		CAFile:     *flagCAFile,
+		GoProxy:    *flagGoProxy,
+		GoPrivate:  *flagGoPrivate,
+		GoInsecure: *flagGoInsecure,
+	}
+}
+
 var (
 	// UniqueID uniquely identifies a kernel execution. Used to create the temporary
 	// directory holding the kernel code, and for logging.
@@ -46,6 +84,9 @@ func init() {
 }
 
 func main() {
+	// Pre-started runner of the programs executed by the kernel, see `%runners`.
+	jpyexec.RunAsRunner()
+
 	klog.InitFlags(nil)
 	defer klog.Flush()
 
@@ -69,6 +110,18 @@ func main() {
 		})
 		defer func() { _ = logFile.Close() }()
 	}
+	if flag.Arg(0) == "console" {
+		*flagConsole = true
+	}
+	if *flagConsole && logWriter == nil {
+		// Logging would interleave with the REPL: only display errors.
+		logWriter = io.Discard
+		for name, value := range map[string]string{"logtostderr": "false", "alsologtostderr": "false", "stderrthreshold": "ERROR"} {
+			if f := flag.Lookup(name); f != nil {
+				_ = f.Value.Set(value)
+			}
+		}
+	}
 	SetUpLogging() // "log" package.
 	SetUpKlog()    // "github.com/golang/klog" package
 
@@ -96,15 +149,35 @@ func main() {
 		if glogFlag := flag.Lookup("comms_log"); glogFlag != nil && glogFlag.Value.String() != "false" {
 			extraArgs = append(extraArgs, "--comms_log")
 		}
+		for _, name := range []string{"metrics", "proxy", "no_proxy", "ca_file", "goproxy", "goprivate", "goinsecure"} {
+			if f := flag.Lookup(name); f != nil && f.Value.String() != "" {
+				value := f.Value.String()
+				if name == "ca_file" {
+					// Jupyter may start the kernel from a different directory.
+					if absPath, err := filepath.Abs(value); err == nil {
+						value = absPath
+					}
+				}
+				extraArgs = append(extraArgs, fmt.Sprintf("--%s=%s", name, value))
+			}
+		}
 		err := kernel.Install(extraArgs, *flagForceDeps, *flagForceCopy)
 		if err != nil {
 			log.Fatalf("Installation failed: %+v\n", err)
 		}
+		if *flagTutorial != "" {
+			initTutorial(*flagTutorial)
+		}
+		return
+	}
+
+	if *flagTutorial != "" {
+		initTutorial(*flagTutorial)
 		return
 	}
 
-	if *flagKernel == "" {
-		_, _ = fmt.Fprintf(os.Stderr, "Use either --install to install the kernel, or if started by Jupyter the flag --kernel must be provided.\n")
+	if *flagKernel == "" && !*flagConsole && *flagHttp == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Use either --install to install the kernel, --console to run the REPL in the terminal, --http to serve the HTTP API, or if started by Jupyter the flag --kernel must be provided.\n")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -119,37 +192,132 @@ func main() {
 		klog.Exitf("Failed to find path for the `go` program: %+v\n\nCurrent PATH=%q", err, os.Getenv("PATH"))
 	}
 
-	// Create a kernel.
-	k, err := kernel.New(*flagKernel)
-	klog.Infof("kernel created\n")
+	if *flagMetrics != "" {
+		if err := metrics.Serve(*flagMetrics); err != nil {
+			// Metrics are optional: e.g., another kernel may already be using the port.
+			klog.Warningf("Metrics won't be available: %+v", err)
+		}
+	}
+
+	if *flagHttp != "" {
+		serveHttp()
+		return
+	}
+
+	// Create a kernel, connected to Jupyter (or the console), and a Go executor.
+	config := gonbkernel.Config{
+		ConnectionFile:  *flagKernel,
+		UniqueID:        UniqueID,
+		PreserveTempDir: *flagWork,
+		RawError:        *flagRawError,
+		CommsLog:        *flagCommsLog,
+		HandleInterrupt: true,
+		Network:         networkConfig(),
+	}
+	var k *gonbkernel.Kernel
+	if *flagConsole {
+		k, err = gonbkernel.NewConsole(config, os.Stdin, os.Stdout)
+	} else {
+		k, err = gonbkernel.New(config)
+	}
 	if err != nil {
 		log.Fatalf("Failed to start kernel: %+v", err)
 	}
-	k.HandleInterrupt() // Handle Jupyter interruptions and Control+C.
 
-	// Create a Go executor.
-	goExec, err := goexec.New(k, UniqueID, *flagWork, *flagRawError)
+	// Orchestrate dispatching of messages, until the kernel stops.
+	err = k.Run()
 	if err != nil {
-		log.Fatalf("Failed to create go executor: %+v", err)
+		klog.Warningf("Error during shutdown: %+v", err)
 	}
-	goExec.Comms.LogWebSocket = *flagCommsLog
+	releaseResources()
+	klog.Infof("Exiting...")
+}
 
-	// Orchestrate dispatching of messages.
-	dispatcher.RunKernel(k, goExec)
-	klog.V(1).Infof("Dispatcher exited.")
+// releaseResources releases any resources (temporary directories, fifos, child processes, etc.) still
+// registered before exiting.
+func releaseResources() {
+	if live := len(resources.List()); live > 0 {
+		klog.Infof("Releasing %d resources still live", live)
+	}
+	if err := resources.ReleaseAll(); err != nil {
+		klog.Warningf("Error releasing resources: %+v", err)
+	}
+}
 
-	// Stop gopls.
-	err = goExec.Stop()
+// HttpTokenEnv is the environment variable used as the default value for --http_token.
+const HttpTokenEnv = "GONB_HTTP_TOKEN"
+
+// serveHttp serves the HTTP API (--http) until the process receives a SIGINT or SIGTERM.
+func serveHttp() {
+	token := *flagHttpToken
+	if token == "" {
+		token = os.Getenv(HttpTokenEnv)
+	}
+	if token == "" {
+		uuidTmp, _ := uuid.NewV4()
+		token = uuidTmp.String()
+		_, _ = fmt.Fprintf(os.Stderr, "GoNB HTTP API token: %s\n", token)
+	}
+	server, err := httpapi.New(httpapi.Config{
+		Address:         *flagHttp,
+		Token:           token,
+		PreserveTempDir: *flagWork,
+		Network:         networkConfig(),
+		IdleTimeout:     *flagHttpIdle,
+	})
 	if err != nil {
-		klog.Warningf("Error during shutdown: %+v", err)
+		log.Fatalf("Failed to create HTTP API server: %+v", err)
 	}
-	klog.V(1).Infof("goExec stopped.")
 
-	// Wait for all polling goroutines.
-	k.ExitWait()
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		klog.Infof("Shutting down HTTP API server...")
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			klog.Warningf("Error during shutdown: %+v", err)
+		}
+	}()
+	if err = server.ListenAndServe(); err != nil {
+		log.Fatalf("%+v", err)
+	}
+	<-shutdownDone // Wait for sessions to be cleaned up.
+	releaseResources()
 	klog.Infof("Exiting...")
 }
 
+// initTutorial writes the tutorial notebooks to dir (--init-tutorial). If the kernel is not installed yet,
+// it is installed, so the notebooks can be opened from Jupyter right away.
+//
+// The notebooks are not registered in the Jupyter launcher: it only lists the installed kernels (and
+// extensions), so instead the command to open the welcome notebook is printed.
+func initTutorial(dir string) {
+	env := tutorial.DetectEnvironment()
+	files, err := tutorial.Generate(dir, env)
+	if err != nil {
+		log.Fatalf("Failed to create tutorial: %+v", err)
+	}
+	for _, filePath := range files {
+		_, _ = fmt.Printf("- %s\n", filePath)
+	}
+	if !kernel.IsInstalled() {
+		// Missing goimports and gopls are installed from the welcome notebook.
+		if err = kernel.Install(nil, true, *flagForceCopy); err != nil {
+			log.Fatalf("Installation failed: %+v\n", err)
+		}
+	}
+	if !env.HasJupyter {
+		_, _ = fmt.Printf("\nJupyter was not found in the PATH: install it (e.g. `pip install jupyterlab`), and then ")
+	} else {
+		_, _ = fmt.Printf("\nTo start, ")
+	}
+	_, _ = fmt.Printf("open the tutorials with:\n\n\tjupyter lab %s\n\n", filepath.Join(dir, tutorial.WelcomeNotebook))
+}
+
 var (
 	ColorReset    = "\033[0m"
 	ColorYellow   = "\033[33m"
@@ -193,10 +361,22 @@ func (_ UniqueIDFilter) FilterS(msg string, keysAndValues []interface{}) (string
 	return coloredUniqueID + msg, keysAndValues
 }
 
-// SetUpKlog to include prefix with kernel's UniqueID.
+// SetUpKlog to include prefix with kernel's UniqueID, and to capture the logs for the log viewer (`%logs`).
 func SetUpKlog() {
+	if logWriter == nil {
+		if f := flag.Lookup("logtostderr"); f != nil && f.Value.String() == "true" {
+			// klog writes directly to stderr when `--logtostderr` is set: instead, write to stderr through
+			// klog.SetOutput, so the logs can also be captured.
+			logWriter = os.Stderr
+			for name, value := range map[string]string{"logtostderr": "false", "stderrthreshold": "4"} {
+				_ = flag.Set(name, value)
+			}
+		}
+	}
 	if logWriter != nil {
-		klog.SetOutput(logWriter)
+		// Write each record only once, and not once for each severity up to its own.
+		_ = flag.Set("one_output", "true")
+		klog.SetOutput(io.MultiWriter(logWriter, logs.Default))
 	}
 	klog.SetLogFilter(UniqueIDFilter{})
 }
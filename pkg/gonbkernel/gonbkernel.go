@@ -0,0 +1,249 @@
+// Package gonbkernel is the public API to embed the GoNB kernel in other programs.
+//
+// It wraps the connection setup with the Jupyter client (internally the `kernel` package), the
+// Go code executor state (internally `goexec`) and the dispatching of messages (internally `dispatcher`),
+// so other projects (a custom IDE, a web playground back-end, etc.) can run GoNB without
+// depending on its internal packages -- which are subject to change at any time.
+//
+// Example:
+//
+//	k, err := gonbkernel.New(gonbkernel.Config{
+//		ConnectionFile:  connectionFilePath,
+//		HandleInterrupt: true,
+//	})
+//	if err != nil {
+//		log.Fatalf("Failed to start kernel: %+v", err)
+//	}
+//	if err = k.Run(); err != nil {
+//		log.Printf("Error during shutdown: %+v", err)
+//	}
+package gonbkernel
+
+import (
+	"github.com/janpfeifer/gonb/common"
+	"github.com/janpfeifer/gonb/internal/console"
+	"github.com/janpfeifer/gonb/internal/dispatcher"
+	"github.com/janpfeifer/gonb/internal/goexec"
+	"github.com/janpfeifer/gonb/internal/jpyexec"
+	"github.com/janpfeifer/gonb/internal/kernel"
+	"github.com/pkg/errors"
+	"io"
+	"k8s.io/klog/v2"
+)
+
+// Version of the GoNB kernel, reported to the Jupyter client in `kernel_info_reply`.
+const Version = dispatcher.Version
+
+// RunAsRunner should be called at the start of `main`, before flags are parsed, by programs embedding the kernel:
+// the pre-started runners of the programs executed (see `%runners`) are started with the program's own executable,
+// and this executes the program requested, never returning. Otherwise, it returns immediately.
+func RunAsRunner() {
+	jpyexec.RunAsRunner()
+}
+
+// Config holds the configuration used to create a new Kernel.
+type Config struct {
+	// ConnectionFile is the path to the `connection_file` provided by the Jupyter client.
+	// It is required.
+	ConnectionFile string
+
+	// UniqueID identifies this kernel execution. It is used to name the temporary directory
+	// holding the generated Go code.
+	// If left empty, a new one is created.
+	UniqueID string
+
+	// PreserveTempDir indicates the temporary directory should be logged and preserved
+	// when the kernel exits -- helpful for debugging.
+	PreserveTempDir bool
+
+	// RawError forces errors to be reported as raw text, instead of HTML.
+	// It facilitates command line testing of notebooks.
+	RawError bool
+
+	// CommsLog enables verbose logging from the communication library in the Javascript console.
+	CommsLog bool
+
+	// HandleInterrupt configures the kernel to capture the SIGINT signal (Control+C), used by Jupyter
+	// to interrupt the execution of a cell, as opposed to letting the process die.
+	// Other captured signals (e.g.: SIGTERM) trigger a clean stop of the kernel.
+	HandleInterrupt bool
+
+	// NoSnapshots disables the auto-save of session snapshots, used to recover the declarations and
+	// environment with `%recover` after a crash.
+	NoSnapshots bool
+
+	// NoUsageStats disables the collection of local usage statistics, displayed with `%stats`.
+	NoUsageStats bool
+
+	// NoNotebookEnv disables loading the `.env` file of the notebook directory, and persisting the
+	// environment variables set with `%env` for the next sessions of the notebook.
+	NoNotebookEnv bool
+
+	// Network configures the proxy, certificate authorities and Go module settings used by the
+	// `go` commands and programs executed by the kernel. Empty fields are left as in the environment.
+	// It can also be changed from the notebook with `%proxy`.
+	//
+	// The configuration is process-wide: it is applied by setting the environment variables of the
+	// process (e.g.: `HTTPS_PROXY`, `GOPROXY`), so it also affects the host program and every other kernel
+	// running in the same process -- the last kernel created (or `%proxy` executed) wins. Programs
+	// embedding more than one kernel should use the same configuration for all of them.
+	Network NetworkConfig
+}
+
+// NetworkConfig holds the networking configuration of the kernel, see Config.Network.
+type NetworkConfig = goexec.NetworkConfig
+
+// Kernel is a GoNB kernel connected to a Jupyter client, or to a console.
+// Create it with New (or NewConsole), and then call Run.
+type Kernel struct {
+	config Config
+	kernel *kernel.Kernel
+	goExec *goexec.State
+
+	// console is set if created with NewConsole.
+	console *console.Console
+}
+
+// New creates a kernel connected to the Jupyter client described in `config.ConnectionFile`,
+// and the Go executor that will hold the state of the notebook.
+//
+// The kernel is connected (its sockets bound) when New returns, but messages are
+// only handled once Run is called.
+//
+// New also changes the process environment: it applies Config.Network, and it sets GOMAXPROCS and
+// GOMEMLIMIT (if not yet set) to the defaults for the container limits. See Config.Network.
+func New(config Config) (*Kernel, error) {
+	if config.ConnectionFile == "" {
+		return nil, errors.New("gonbkernel.New() requires Config.ConnectionFile to be set")
+	}
+	if config.UniqueID == "" {
+		config.UniqueID = common.UniqueId()
+	}
+	if err := goexec.ApplyNetworkConfig(config.Network); err != nil {
+		return nil, errors.WithMessagef(err, "invalid network configuration")
+	}
+	goexec.ApplyResourceLimits()
+	k := &Kernel{config: config}
+
+	var err error
+	k.kernel, err = kernel.New(config.ConnectionFile)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to start kernel")
+	}
+	klog.Infof("kernel created\n")
+	if config.HandleInterrupt {
+		k.kernel.HandleInterrupt() // Handle Jupyter interruptions and Control+C.
+	}
+
+	k.goExec, err = goexec.New(k.kernel, config.UniqueID, config.PreserveTempDir, config.RawError)
+	if err != nil {
+		k.kernel.Stop()
+		return nil, errors.WithMessagef(err, "failed to create go executor")
+	}
+	k.goExec.Comms.LogWebSocket = config.CommsLog
+	if !config.NoSnapshots {
+		k.goExec.EnableSnapshots()
+	}
+	if !config.NoNotebookEnv {
+		k.goExec.EnableNotebookEnv()
+	}
+	if !config.NoUsageStats {
+		k.goExec.EnableUsageStats()
+	}
+	return k, nil
+}
+
+// NewConsole creates a kernel that, instead of connecting to a Jupyter client, reads cells from `in`
+// and writes their outputs as plain text to `out` -- a REPL usable from a terminal.
+//
+// `config.ConnectionFile` is ignored, and errors are always reported as raw text.
+// Call Run to start the REPL: it returns when `in` reaches EOF or the kernel is stopped.
+//
+// Like New, it changes the process environment, see Config.Network.
+func NewConsole(config Config, in io.Reader, out io.Writer) (*Kernel, error) {
+	if config.UniqueID == "" {
+		config.UniqueID = common.UniqueId()
+	}
+	if err := goexec.ApplyNetworkConfig(config.Network); err != nil {
+		return nil, errors.WithMessagef(err, "invalid network configuration")
+	}
+	goexec.ApplyResourceLimits()
+	config.RawError = true
+	k := &Kernel{config: config}
+	k.kernel = kernel.NewStandalone()
+	if config.HandleInterrupt {
+		k.kernel.HandleInterrupt() // Control+C interrupts the cell being executed.
+	}
+
+	var err error
+	k.goExec, err = goexec.New(k.kernel, config.UniqueID, config.PreserveTempDir, config.RawError)
+	if err != nil {
+		k.kernel.Stop()
+		return nil, errors.WithMessagef(err, "failed to create go executor")
+	}
+	if !config.NoSnapshots {
+		k.goExec.EnableSnapshots()
+	}
+	if !config.NoNotebookEnv {
+		k.goExec.EnableNotebookEnv()
+	}
+	if !config.NoUsageStats {
+		k.goExec.EnableUsageStats()
+	}
+	k.console = console.New(k.kernel, k.goExec, in, out)
+	return k, nil
+}
+
+// Run dispatches incoming messages to their handlers, and blocks until the kernel is stopped,
+// either by a "shutdown_request" from the Jupyter client, a signal (if Config.HandleInterrupt is set)
+// or a call to Stop. For kernels created with NewConsole, it runs the REPL instead.
+//
+// Before returning it stops `gopls`, removes the temporary files (except if Config.PreserveTempDir is set)
+// and waits for all polling goroutines to finish.
+// It returns any error that happened during the clean-up.
+func (k *Kernel) Run() error {
+	var err error
+	if k.console != nil {
+		err = k.console.Run()
+		if err != nil {
+			err = errors.WithMessagef(err, "running console")
+		}
+		k.Stop()
+	} else {
+		dispatcher.RunKernel(k.kernel, k.goExec)
+		klog.V(1).Infof("Dispatcher exited.")
+	}
+
+	// Stop gopls.
+	if stopErr := k.goExec.Stop(); stopErr != nil && err == nil {
+		err = errors.WithMessagef(stopErr, "stopping go executor")
+	}
+	klog.V(1).Infof("goExec stopped.")
+
+	// Wait for all polling goroutines.
+	k.kernel.ExitWait()
+	return err
+}
+
+// Stop the kernel: it will cause Run to return, after cleaning up.
+// It is safe to call it more than once, and concurrently with the kernel stopping itself (e.g.: on a
+// "shutdown_request").
+func (k *Kernel) Stop() {
+	k.kernel.Stop()
+}
+
+// UniqueID returns the unique id for this kernel execution.
+func (k *Kernel) UniqueID() string {
+	return k.config.UniqueID
+}
+
+// TempDir returns the temporary directory where the Go code of the cells is generated and compiled.
+func (k *Kernel) TempDir() string {
+	return k.goExec.TempDir
+}
+
+// JupyterKernelId returns the id assigned to this kernel by Jupyter, parsed from the connection file
+// name. It may be empty, if it couldn't be parsed.
+func (k *Kernel) JupyterKernelId() string {
+	return k.kernel.JupyterKernelId
+}
@@ -0,0 +1,151 @@
+package gonbkernel
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	RunAsRunner()
+	os.Exit(m.Run())
+}
+
+// freePort returns a TCP port in localhost that is not in use.
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+	return port
+}
+
+// writeConnectionFile writes a Jupyter connection file with free ports in localhost, named as the ones
+// created by Jupyter, so the kernel id can be parsed.
+func writeConnectionFile(t *testing.T) string {
+	connInfo := map[string]any{
+		"signature_scheme": "hmac-sha256",
+		"transport":        "tcp",
+		"ip":               "127.0.0.1",
+		"key":              "test-key",
+		"stdin_port":       freePort(t),
+		"control_port":     freePort(t),
+		"iopub_port":       freePort(t),
+		"hb_port":          freePort(t),
+		"shell_port":       freePort(t),
+	}
+	connData, err := json.Marshal(connInfo)
+	require.NoError(t, err)
+	filePath := path.Join(t.TempDir(), "kernel-0a1b2c3d-4e5f-6789-abcd-ef0123456789.json")
+	require.NoError(t, os.WriteFile(filePath, connData, 0600))
+	return filePath
+}
+
+// testConfig returns a configuration that doesn't leave state behind (snapshots, statistics, etc.).
+func testConfig() Config {
+	return Config{NoSnapshots: true, NoUsageStats: true, NoNotebookEnv: true}
+}
+
+// runKernel runs the kernel in a goroutine, returning the channel with the result of Run.
+func runKernel(k *Kernel) chan error {
+	result := make(chan error, 1)
+	go func() { result <- k.Run() }()
+	return result
+}
+
+// waitRun waits for Run to return.
+func waitRun(t *testing.T, result chan error) error {
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(30 * time.Second):
+		t.Fatal("kernel Run didn't return after Stop")
+		return nil
+	}
+}
+
+func TestNewStop(t *testing.T) {
+	_, err := New(testConfig())
+	require.Error(t, err, "ConnectionFile is required")
+	config := testConfig()
+	config.ConnectionFile = path.Join(t.TempDir(), "missing.json")
+	_, err = New(config)
+	require.Error(t, err)
+
+	config.ConnectionFile = writeConnectionFile(t)
+	k, err := New(config)
+	require.NoError(t, err)
+	assert.NotEmpty(t, k.UniqueID())
+	assert.Equal(t, "0a1b2c3d-4e5f-6789-abcd-ef0123456789", k.JupyterKernelId())
+	tempDir := k.TempDir()
+	assert.DirExists(t, tempDir)
+	assert.True(t, strings.Contains(tempDir, k.UniqueID()), "TempDir %q should be named after the UniqueID %q",
+		tempDir, k.UniqueID())
+
+	result := runKernel(k)
+	var wg sync.WaitGroup
+	for ii := 0; ii < 10; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.Stop() // Concurrent calls, only the first one stops the kernel.
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, waitRun(t, result))
+	k.Stop() // Stopping again is a no-op.
+	assert.NoDirExists(t, tempDir, "temporary directory should be removed when the kernel stops")
+
+	// The ports are released, and the kernel can be run again in the same process.
+	config.UniqueID = "gonbkernel_test"
+	k, err = New(config)
+	require.NoError(t, err)
+	assert.Equal(t, "gonbkernel_test", k.UniqueID())
+	result = runKernel(k)
+	k.Stop()
+	require.NoError(t, waitRun(t, result))
+}
+
+func TestNewConsoleStop(t *testing.T) {
+	config := testConfig()
+	config.PreserveTempDir = true
+	var out strings.Builder
+	k, err := NewConsole(config, strings.NewReader(""), &out)
+	require.NoError(t, err)
+	assert.Empty(t, k.JupyterKernelId())
+	tempDir := k.TempDir()
+	t.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+
+	// Run returns when the input reaches EOF.
+	require.NoError(t, waitRun(t, runKernel(k)))
+	k.Stop()
+	assert.DirExists(t, tempDir, "temporary directory should be preserved")
+}
+
+func TestConcurrentKernels(t *testing.T) {
+	var kernels []*Kernel
+	var results []chan error
+	for ii := 0; ii < 2; ii++ {
+		config := testConfig()
+		config.ConnectionFile = writeConnectionFile(t)
+		k, err := New(config)
+		require.NoError(t, err)
+		kernels = append(kernels, k)
+		results = append(results, runKernel(k))
+	}
+
+	// Stopping one kernel doesn't affect the other.
+	kernels[0].Stop()
+	require.NoError(t, waitRun(t, results[0]))
+	assert.False(t, kernels[1].kernel.IsStopped())
+	assert.DirExists(t, kernels[1].TempDir())
+	kernels[1].Stop()
+	require.NoError(t, waitRun(t, results[1]))
+}